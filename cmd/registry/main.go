@@ -3,11 +3,15 @@ package main
 import (
 	_ "net/http/pprof"
 
+	_ "github.com/lib/pq"
+
 	"github.com/distribution/distribution/v3/registry"
 	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
 	_ "github.com/distribution/distribution/v3/registry/auth/silly"
 	_ "github.com/distribution/distribution/v3/registry/auth/token"
 	_ "github.com/distribution/distribution/v3/registry/extension/distribution"
+	_ "github.com/distribution/distribution/v3/registry/extension/events"
+	_ "github.com/distribution/distribution/v3/registry/extension/federation"
 	_ "github.com/distribution/distribution/v3/registry/extension/oci"
 	_ "github.com/distribution/distribution/v3/registry/proxy"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/azure"
@@ -16,9 +20,20 @@ import (
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/alicdn"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/cloudfront"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/metadatadb"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/metrics"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/readcache"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/redirect"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/retry"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/shadow"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/timeout"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/tracing"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/ocilayout"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/oss"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/plugin"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/s3-aws"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/shard"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/storageclass"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/swift"
 )
 
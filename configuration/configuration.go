@@ -89,6 +89,12 @@ type Configuration struct {
 		// receives a stop signal
 		DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
 
+		// ProcessingInterval is the interval at which "102 Processing"
+		// responses are sent while manifest pushes are verified, keeping the
+		// connections of clients alive through long verifications. Disabled
+		// when zero.
+		ProcessingInterval time.Duration `yaml:"processinginterval,omitempty"`
+
 		// TLS instructs the http server to listen with a TLS configuration.
 		// This only support simple tls configuration with a cert and key.
 		// Mostly, this is useful for testing situations or simple deployments
@@ -138,6 +144,11 @@ type Configuration struct {
 		// the values are the associated header payloads.
 		Headers http.Header `yaml:"headers,omitempty"`
 
+		// ResponseHeaders configures the headers set on responses depending
+		// on the class of their route, HSTS and CORS. Unlike Headers, they
+		// override the headers set by the handlers.
+		ResponseHeaders ResponseHeaders `yaml:"responseheaders,omitempty"`
+
 		// Debug configures the http debug interface, if specified. This can
 		// include services such as pprof, expvar and other data that should
 		// not be exposed externally. Left disabled by default.
@@ -149,6 +160,11 @@ type Configuration struct {
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
 			} `yaml:"prometheus,omitempty"`
+			// Triggers enables the endpoints of the debug server starting
+			// maintenance tasks: the purge of uploads, the expiry of the
+			// content of pull through caches and the garbage collection of
+			// repositories.
+			Triggers bool `yaml:"triggers,omitempty"`
 		} `yaml:"debug,omitempty"`
 
 		// HTTP2 configuration options
@@ -232,7 +248,24 @@ type Configuration struct {
 				// that URLs in pushed manifests must not match.
 				Deny []string `yaml:"deny,omitempty"`
 			} `yaml:"urls,omitempty"`
+			// Artifacts enables checks of the structure of the OCI
+			// manifests of artifacts of the Helm and WASM ecosystems.
+			Artifacts struct {
+				// Helm checks the manifests of Helm charts.
+				Helm bool `yaml:"helm,omitempty"`
+				// Wasm checks the manifests of WASM modules.
+				Wasm bool `yaml:"wasm,omitempty"`
+			} `yaml:"artifacts,omitempty"`
+			// Concurrency is the number of descriptors of a pushed
+			// manifest whose presence is checked at once.
+			Concurrency int `yaml:"concurrency,omitempty"`
 		} `yaml:"manifests,omitempty"`
+		// ArtifactTypes configures the artifact types known to the
+		// registry and the validation of the artifacts pushed.
+		ArtifactTypes ArtifactTypes `yaml:"artifacttypes,omitempty"`
+		// Annotations configures the validation and normalization of
+		// the annotation keys of the OCI manifests pushed.
+		Annotations Annotations `yaml:"annotations,omitempty"`
 	} `yaml:"validation,omitempty"`
 
 	// Policy configures registry policy options.
@@ -245,11 +278,469 @@ type Configuration struct {
 			// manifests. When non-empty, the registry will enforce
 			// the class in authorized resources.
 			Classes []string `yaml:"classes"`
+
+			// Unauthorized is the response to requests authenticated
+			// but denied access to a repository: "unauthorized", the
+			// default, challenges them with a 401 Unauthorized
+			// response, and "notfound" responds as if the repository
+			// did not exist, so that its existence is not disclosed.
+			Unauthorized string `yaml:"unauthorized,omitempty"`
 		} `yaml:"repository,omitempty"`
+
+		// Rules is the content policy, a list of rules evaluated in
+		// order as manifests are pushed, pulled and deleted.
+		Rules []PolicyRule `yaml:"rules,omitempty"`
+
+		// Attestations lists the referrers required of manifests before
+		// they are tagged with protected tags.
+		Attestations []AttestationRequirement `yaml:"attestations,omitempty"`
+
+		// TagPulls lists the rules deprecating or disabling the pulls by
+		// tag of manifests of some media types.
+		TagPulls []TagPullRule `yaml:"tagpulls,omitempty"`
+
+		// StorageClasses lists the rules routing the blobs of manifests
+		// to the storage classes of the storageclass storage driver.
+		StorageClasses []StorageClassRule `yaml:"storageclasses,omitempty"`
 	} `yaml:"policy,omitempty"`
 
 	// Extensions configures options for the distribution extensions
 	Extensions map[string]ExtensionConfig `yaml:"extensions,omitempty"`
+
+	// Egress configures limits on the bandwidth used to serve blobs.
+	Egress Egress `yaml:"egress,omitempty"`
+
+	// Uploads configures guardrails refusing new blob uploads while the
+	// registry is busy.
+	Uploads Uploads `yaml:"uploads,omitempty"`
+
+	// Faults configures the injection of faults into requests, to test
+	// the retries of clients against staging registries.
+	Faults Faults `yaml:"faults,omitempty"`
+
+	// Export configures the periodic export of the metadata of the
+	// registry to analytical sinks.
+	Export Export `yaml:"export,omitempty"`
+
+	// Cluster configures the heartbeats through which registry instances
+	// sharing redis list each other.
+	Cluster Cluster `yaml:"cluster,omitempty"`
+
+	// Features rolls experimental features out to some repositories,
+	// keyed by the name of the feature.
+	Features map[string]FeatureFlag `yaml:"features,omitempty"`
+
+	// Templates lists the templates repositories are bootstrapped with
+	// as they are created.
+	Templates []RepositoryTemplate `yaml:"templates,omitempty"`
+
+	// Replication configures the registries trusted to replicate content
+	// into this one.
+	Replication Replication `yaml:"replication,omitempty"`
+
+	// Profile tunes the defaults of the registry for a kind of workload.
+	// The only profile is "models", for registries of AI models whose blobs
+	// are commonly several gigabytes.
+	Profile string `yaml:"profile,omitempty"`
+}
+
+// PolicyRule is a rule of the content policy. Its expression is written in
+// the Common Expression Language, as described in the documentation of the
+// registry/policy package, and is evaluated with the repository, tag,
+// media type, annotations, platforms, size and subject of the manifest. Exactly
+// one of Deny, Allow and Warn must be set.
+type PolicyRule struct {
+	// Name identifies the rule in logs and in the default denial message.
+	Name string `yaml:"name,omitempty"`
+
+	// Actions lists the actions the rule applies to, among push, pull,
+	// delete and tag. The rule applies to all actions when empty.
+	Actions []string `yaml:"actions,omitempty"`
+
+	// Deny is an expression denying the request when true.
+	Deny string `yaml:"deny,omitempty"`
+
+	// Allow is an expression denying the request when false.
+	Allow string `yaml:"allow,omitempty"`
+
+	// Warn is an expression answering the request with a Warning header
+	// holding the message when true, without denying it.
+	Warn string `yaml:"warn,omitempty"`
+
+	// Message is returned to clients whose requests the rule denies or
+	// warns.
+	Message string `yaml:"message,omitempty"`
+}
+
+// AttestationRequirement requires manifests to have referrers of artifact
+// types, such as signatures, SBOMs or provenance, before they are tagged with
+// the protected tags. Tags and repositories are matched with the patterns of
+// path.Match, such as prod-*.
+type AttestationRequirement struct {
+	// Name identifies the requirement in logs and errors.
+	Name string `yaml:"name,omitempty"`
+
+	// Repositories lists the patterns of the repositories the
+	// requirement applies to. It applies to all repositories when empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Tags lists the patterns of the protected tags.
+	Tags []string `yaml:"tags"`
+
+	// ArtifactTypes lists the artifact types of the referrers required.
+	ArtifactTypes []string `yaml:"artifacttypes"`
+}
+
+// TagPullRule deprecates or disables pulling the manifests of media types by
+// tag, while they may still be pulled by digest. Repositories are matched with
+// the patterns of path.Match.
+type TagPullRule struct {
+	// Name identifies the rule in logs and in the default message.
+	Name string `yaml:"name,omitempty"`
+
+	// Repositories lists the patterns of the repositories the rule applies
+	// to. It applies to all repositories when empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// MediaTypes lists the media types, or artifact types, of the manifests
+	// the rule applies to.
+	MediaTypes []string `yaml:"mediatypes"`
+
+	// Referrers lists the artifact types of referrers, such as signatures,
+	// exempting the manifests having one of them from the rule.
+	Referrers []string `yaml:"referrers,omitempty"`
+
+	// Mode is "deprecate", answering the pulls with a Warning header, or
+	// "disable", the default, denying them.
+	Mode string `yaml:"mode,omitempty"`
+
+	// Message is returned to clients whose pulls the rule applies to.
+	Message string `yaml:"message,omitempty"`
+}
+
+// StorageClassRule routes the blobs referenced by the manifests its
+// expression matches to a storage class, as the manifests are pushed. Its
+// expression is written in the Common Expression Language, as those of the
+// content policy, and is evaluated with the same variables.
+type StorageClassRule struct {
+	// Name identifies the rule in logs.
+	Name string `yaml:"name,omitempty"`
+
+	// Class is the name of the storage class of the storageclass storage
+	// driver the blobs are routed to.
+	Class string `yaml:"class"`
+
+	// Match is an expression routing the blobs of the manifest when true.
+	// The rule matches all manifests when empty.
+	Match string `yaml:"match,omitempty"`
+}
+
+// ArtifactTypes configures the artifact types known to the registry. The
+// artifact type of a manifest is its artifactType field or, when unset, the
+// media type of its config. Images, whose config is an image config, are not
+// artifacts.
+type ArtifactTypes struct {
+	// Restrict rejects the pushes of artifacts whose type is not listed.
+	Restrict bool `yaml:"restrict,omitempty"`
+
+	// Types lists the known artifact types.
+	Types []ArtifactType `yaml:"types,omitempty"`
+}
+
+// ArtifactType is an artifact type known to the registry.
+type ArtifactType struct {
+	// Type is the artifact type, such as application/spdx+json.
+	Type string `yaml:"type"`
+
+	// ConfigSchema is the path of a JSON schema the config blob of the
+	// artifacts of the type must validate against.
+	ConfigSchema string `yaml:"configschema,omitempty"`
+
+	// MaxConfigSize is the size in bytes of the largest config blob
+	// validated. Larger configs are rejected. Defaults to 16MiB.
+	MaxConfigSize int64 `yaml:"maxconfigsize,omitempty"`
+}
+
+// Annotations configures the validation of the annotation keys of the OCI
+// manifests pushed, which should follow reverse-domain conventions, such as
+// com.example.version. Keys are normalized by trimming whitespace and
+// lowercasing their domain, and keys duplicated once normalized are merged.
+type Annotations struct {
+	// Mode is the strictness applied to repositories no namespace
+	// matches: "off", the default, "normalize" or "strict".
+	Mode string `yaml:"mode,omitempty"`
+
+	// Namespaces overrides the mode of the repositories matching their
+	// patterns. The first namespace matching a repository applies.
+	Namespaces []AnnotationNamespace `yaml:"namespaces,omitempty"`
+}
+
+// AnnotationNamespace sets the strictness of the validation of annotation
+// keys in a set of repositories.
+type AnnotationNamespace struct {
+	// Repositories lists the patterns of the repositories of the
+	// namespace.
+	Repositories []string `yaml:"repositories"`
+
+	// Mode is the strictness applied to the repositories: "off",
+	// "normalize" or "strict".
+	Mode string `yaml:"mode"`
+}
+
+// Egress configures rate limiting of blob downloads, so that a few large
+// pulls cannot saturate the network of the registry. Each limit is a token
+// bucket and is disabled when its rate is zero; a download proceeds at the
+// pace of the most restrictive limit applying to it.
+type Egress struct {
+	// Connection limits the bandwidth of each blob download.
+	Connection EgressLimit `yaml:"connection,omitempty"`
+
+	// Subject limits the bandwidth shared by all downloads of an
+	// authenticated user, or of a client address for anonymous requests.
+	Subject EgressLimit `yaml:"subject,omitempty"`
+
+	// Repository limits the bandwidth shared by all downloads from a
+	// repository.
+	Repository EgressLimit `yaml:"repository,omitempty"`
+}
+
+// EgressLimit configures a token bucket limiting bandwidth.
+type EgressLimit struct {
+	// Rate is the sustained bandwidth in bytes per second.
+	Rate int64 `yaml:"rate,omitempty"`
+
+	// Burst is the number of bytes which may be sent at once after an idle
+	// period. It defaults to Rate.
+	Burst int64 `yaml:"burst,omitempty"`
+}
+
+// Uploads configures the guardrails protecting the registry from running out
+// of memory during push storms. While a limit is exceeded, new blob uploads
+// are refused with a 503 Service Unavailable response and a Retry-After
+// header, and the uploads in progress carry on. Each limit is disabled when
+// zero.
+type Uploads struct {
+	// MaxConcurrent is the number of blob upload requests in progress from
+	// which new uploads are refused.
+	MaxConcurrent int `yaml:"maxconcurrent,omitempty"`
+
+	// MemoryWatermark is the size, in bytes, of the heap of the registry
+	// from which new uploads are refused.
+	MemoryWatermark uint64 `yaml:"memorywatermark,omitempty"`
+
+	// RetryAfter is the delay clients refused an upload are asked to wait
+	// before retrying. Defaults to 10s.
+	RetryAfter time.Duration `yaml:"retryafter,omitempty"`
+
+	// Quota limits the blob bytes each subject pushes per window.
+	Quota UploadQuota `yaml:"quota,omitempty"`
+
+	// MinChunkLength is the length, in bytes, of the shortest chunk but
+	// the last that clients are asked to send, advertised with the
+	// OCI-Chunk-Min-Length header.
+	MinChunkLength int64 `yaml:"minchunklength,omitempty"`
+
+	// MaxChunkLength is the length, in bytes, of the longest chunk
+	// accepted, advertised with the OCI-Chunk-Max-Length header.
+	MaxChunkLength int64 `yaml:"maxchunklength,omitempty"`
+}
+
+// UploadQuota limits the blob bytes pushed by each authenticated user, or
+// client address for anonymous requests, per time window. A subject having
+// pushed its quota is refused uploads with a 429 Too Many Requests response
+// and a Retry-After header until enough of the window has passed, as the
+// quota is refilled continuously.
+type UploadQuota struct {
+	// Bytes is the number of blob bytes a subject may push per Window.
+	// Subjects not listed in Subjects have no quota when zero.
+	Bytes int64 `yaml:"bytes,omitempty"`
+
+	// Window is the time over which Bytes may be pushed. Defaults to 1h.
+	Window time.Duration `yaml:"window,omitempty"`
+
+	// Subjects overrides Bytes for the users or client addresses it lists.
+	// A negative number exempts the subject from the quota.
+	Subjects map[string]int64 `yaml:"subjects,omitempty"`
+}
+
+// Faults configures the injection of latency, errors and truncated responses
+// into a percentage of the requests of each class of routes, so that the
+// retries of clients can be tested against a staging registry. Faults must
+// not be configured on registries serving production traffic.
+type Faults struct {
+	// Routes maps classes of routes, as in http.responseheaders, to the
+	// faults injected into their requests. The faults of default apply to
+	// the routes whose class has none.
+	Routes map[string]FaultRule `yaml:"routes,omitempty"`
+}
+
+// FaultRule configures the faults injected into the requests of a class of
+// routes. Each percentage is of the requests of the class, from 0 to 100,
+// and a fault is disabled when its percentage is zero.
+type FaultRule struct {
+	// Latency is the delay added before serving the requests delayed.
+	Latency time.Duration `yaml:"latency,omitempty"`
+
+	// LatencyPercent is the percentage of requests delayed.
+	LatencyPercent float64 `yaml:"latencypercent,omitempty"`
+
+	// ErrorPercent is the percentage of requests failed with ErrorStatus
+	// instead of being served.
+	ErrorPercent float64 `yaml:"errorpercent,omitempty"`
+
+	// ErrorStatus is the 5xx status of the requests failed. Defaults to
+	// 503.
+	ErrorStatus int `yaml:"errorstatus,omitempty"`
+
+	// TruncatePercent is the percentage of successful GET responses whose
+	// body is cut off halfway, closing the connection.
+	TruncatePercent float64 `yaml:"truncatepercent,omitempty"`
+}
+
+// Export configures the periodic export of the metadata and usage of the
+// registry to analytical sinks.
+type Export struct {
+	// Interval is the time between exports. Defaults to 24h.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// Sinks configures the sinks exports are written to, keyed by the name
+	// of the sink, such as jsonl or sql, with their parameters. Exports are
+	// disabled when empty.
+	Sinks map[string]Parameters `yaml:"sinks,omitempty"`
+}
+
+// Cluster configures the heartbeats registry instances sharing the same redis
+// publish, so that each lists the instances of the fleet at the
+// /debug/cluster endpoint of its debug server.
+type Cluster struct {
+	// Enabled publishes the heartbeats of the instance. It requires the
+	// redis section to be configured.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Name identifies the instance within the fleet. Defaults to the host
+	// name.
+	Name string `yaml:"name,omitempty"`
+
+	// Role is the role of the instance, primary, replica or proxy. Defaults
+	// to proxy for pull through caches and to primary otherwise.
+	Role string `yaml:"role,omitempty"`
+
+	// Interval is the time between heartbeats. Defaults to 10s. Instances
+	// are listed until three intervals pass without a heartbeat.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// FeatureFlag configures the repositories an experimental feature is enabled
+// for, in addition to those it is enabled for by its own section of the
+// configuration, if any.
+type FeatureFlag struct {
+	// Namespaces lists the repositories the feature is enabled for, along
+	// with the repositories below them, such as team for team/app.
+	Namespaces []string `yaml:"namespaces,omitempty"`
+
+	// Percent is the percentage of the other repositories the feature is
+	// enabled for, from 0 to 100.
+	Percent float64 `yaml:"percent,omitempty"`
+}
+
+// RepositoryTemplate configures the settings repositories are bootstrapped
+// with as they are created. The settings are recorded in the repository, so
+// that repositories keep those they were created with as templates change.
+type RepositoryTemplate struct {
+	// Name identifies the template in the records of the repositories it
+	// bootstrapped.
+	Name string `yaml:"name"`
+
+	// Repositories lists the patterns of the repositories the template
+	// bootstraps. Defaults to all repositories. The first template matching
+	// a repository applies.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// RequiredAnnotations lists the keys of the annotations the manifests
+	// pushed into the repositories must have.
+	RequiredAnnotations []string `yaml:"requiredannotations,omitempty"`
+}
+
+// Replication configures the sources, such as registries within a trusted
+// boundary, replicating content into the registry. The blobs the sources
+// upload are stored without verifying their digest, already verified by the
+// source, and the delegation of their verification is recorded with them.
+type Replication struct {
+	// Trusted lists the sources trusted with the verification of the blobs
+	// they upload. The first source matching a request applies.
+	Trusted []ReplicationSource `yaml:"trusted,omitempty"`
+}
+
+// ReplicationSource identifies the requests of a source of replication.
+type ReplicationSource struct {
+	// Name identifies the source in the records of the blobs it uploaded.
+	Name string `yaml:"name"`
+
+	// Users lists the names of the users the source authenticates as.
+	Users []string `yaml:"users"`
+
+	// Networks restricts the source to the clients of the networks it lists,
+	// in CIDR notation.
+	Networks []string `yaml:"networks,omitempty"`
+}
+
+// ResponseHeaders configures the headers the registry sets on its responses.
+type ResponseHeaders struct {
+	// Routes maps classes of routes to the headers set on their responses:
+	// default, applying to all routes, base, manifests, blobs, uploads,
+	// tags, catalog, holds and extensions. The headers of a class override
+	// those of default, and a header with no value is removed.
+	Routes map[string]http.Header `yaml:"routes,omitempty"`
+
+	// HSTS configures the Strict-Transport-Security header.
+	HSTS HSTS `yaml:"hsts,omitempty"`
+
+	// CORS lists the rules allowing cross-origin requests from browsers.
+	// The first rule matching the origin of a request applies.
+	CORS []CORSRule `yaml:"cors,omitempty"`
+}
+
+// HSTS configures the Strict-Transport-Security header, set on all responses
+// if MaxAge is not zero.
+type HSTS struct {
+	// MaxAge is how long browsers only access the registry over HTTPS.
+	MaxAge time.Duration `yaml:"maxage,omitempty"`
+
+	// IncludeSubdomains extends the policy to the subdomains of the host.
+	IncludeSubdomains bool `yaml:"includesubdomains,omitempty"`
+
+	// Preload allows the host to be included in the preload lists of
+	// browsers.
+	Preload bool `yaml:"preload,omitempty"`
+}
+
+// CORSRule allows cross-origin requests from a list of origins.
+type CORSRule struct {
+	// Origins lists the origins the rule applies to, such as
+	// https://ui.example.com. An origin of * matches all of them, and one
+	// whose host starts with *., such as https://*.example.com, matches
+	// the subdomains of the rest of the host.
+	Origins []string `yaml:"origins"`
+
+	// Methods lists the methods allowed. Defaults to GET and HEAD.
+	Methods []string `yaml:"methods,omitempty"`
+
+	// Headers lists the request headers allowed. Defaults to
+	// Authorization, Accept, Content-Type and Content-Range.
+	Headers []string `yaml:"headers,omitempty"`
+
+	// ExposedHeaders lists the response headers scripts may read. Defaults
+	// to the headers of the distribution API, such as
+	// Docker-Content-Digest, Location and Range.
+	ExposedHeaders []string `yaml:"exposedheaders,omitempty"`
+
+	// Credentials allows requests carrying cookies or an Authorization
+	// header.
+	Credentials bool `yaml:"credentials,omitempty"`
+
+	// MaxAge is how long browsers may cache the result of preflight
+	// requests.
+	MaxAge time.Duration `yaml:"maxage,omitempty"`
 }
 
 // ExtensionConfig is the configuration of an extension namespace. It can comprise of extension and components.
@@ -433,6 +924,28 @@ func (storage Storage) Type() string {
 			// allow configuration of delete
 		case "redirect":
 			// allow configuration of redirect
+		case "coalesce":
+			// allow configuration of read coalescing
+		case "holds":
+			// allow configuration of legal holds
+		case "tagoperations":
+			// allow configuration of tag operations
+		case "taghistory":
+			// allow configuration of tag history
+		case "provenance":
+			// allow configuration of manifest provenance
+		case "catalogsnapshots":
+			// allow configuration of catalog snapshots
+		case "digestaliases":
+			// allow configuration of digest aliases
+		case "links":
+			// allow configuration of link files
+		case "manifests":
+			// allow configuration of manifest reads
+		case "referrers":
+			// allow configuration of the referrers index
+		case "journal":
+			// allow configuration of batch journals
 		default:
 			storageType = append(storageType, k)
 		}
@@ -474,6 +987,28 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 					// allow configuration of delete
 				case "redirect":
 					// allow configuration of redirect
+				case "coalesce":
+					// allow configuration of read coalescing
+				case "holds":
+					// allow configuration of legal holds
+				case "tagoperations":
+					// allow configuration of tag operations
+				case "taghistory":
+					// allow configuration of tag history
+				case "provenance":
+					// allow configuration of manifest provenance
+				case "catalogsnapshots":
+					// allow configuration of catalog snapshots
+				case "digestaliases":
+					// allow configuration of digest aliases
+				case "links":
+					// allow configuration of link files
+				case "manifests":
+					// allow configuration of manifest reads
+				case "referrers":
+					// allow configuration of the referrers index
+				case "journal":
+					// allow configuration of batch journals
 				default:
 					types = append(types, k)
 				}
@@ -650,6 +1185,79 @@ type Proxy struct {
 
 	// Password of the hub user
 	Password string `yaml:"password"`
+
+	// DockerConfig is the path of a docker config.json file whose stored
+	// credentials or credential helpers are used to authenticate to the
+	// remote, when Username is not set.
+	DockerConfig string `yaml:"dockerconfig,omitempty"`
+
+	// CredentialProvider configures a provider minting short lived
+	// credentials for the remote from ambient cloud credentials, in place
+	// of a static Username and Password.
+	CredentialProvider ProxyCredentialProvider `yaml:"credentialprovider,omitempty"`
+
+	// RateLimit keeps the manifest pulls from the remote within a budget.
+	RateLimit ProxyRateLimit `yaml:"ratelimit,omitempty"`
+
+	// Revalidate serves cached tags right away and checks the remote for
+	// changes to them in the background. Cached content is kept instead of
+	// expiring after a TTL.
+	Revalidate bool `yaml:"revalidate,omitempty"`
+
+	// Local lists namespaces which are never proxied. Their repositories
+	// are served from local storage only, and may be pushed to.
+	Local []string `yaml:"local,omitempty"`
+
+	// Passthrough lists namespaces whose repositories are served straight
+	// from the remote, without being cached.
+	Passthrough []string `yaml:"passthrough,omitempty"`
+
+	// Scheduler configures where the expiry state of cached content is
+	// persisted.
+	Scheduler ProxyScheduler `yaml:"scheduler,omitempty"`
+}
+
+// ProxyScheduler configures the persistence of the TTL scheduler of a pull
+// through cache.
+type ProxyScheduler struct {
+	// Store is the backend keeping the scheduler state, either storage, the
+	// default, to keep it with the storage driver, redis to keep it in the
+	// redis instance configured for the registry, or boltdb to keep it in a
+	// local boltdb file.
+	Store string `yaml:"store,omitempty"`
+
+	// Path is the boltdb file keeping the state with the boltdb store.
+	Path string `yaml:"path,omitempty"`
+
+	// Shards is the number of parts the state is split into, so that saving
+	// a change does not rewrite the whole state. 16 by default.
+	Shards int `yaml:"shards,omitempty"`
+}
+
+// ProxyRateLimit configures the pull budget of a pull through cache. The
+// quota advertised by the remote in RateLimit-Remaining headers, such as the
+// one of Docker Hub, is always taken into account.
+type ProxyRateLimit struct {
+	// Budget is the number of manifest pulls the cache may make from the
+	// remote per Window. When zero, only the quota of the remote applies.
+	Budget int `yaml:"budget,omitempty"`
+
+	// Window is the period Budget applies to, 6 hours by default.
+	Window time.Duration `yaml:"window,omitempty"`
+
+	// Reserve is the number of pulls of the quota of the remote left for
+	// other users of the same account.
+	Reserve int `yaml:"reserve,omitempty"`
+}
+
+// ProxyCredentialProvider selects a provider of credentials for the remote
+// of a pull through cache.
+type ProxyCredentialProvider struct {
+	// Name of the provider, one of ecr, gcr or acr.
+	Name string `yaml:"name,omitempty"`
+
+	// Options are passed to the provider.
+	Options Parameters `yaml:"options,omitempty"`
 }
 
 // Parse parses an input configuration yaml document into a Configuration struct
@@ -72,14 +72,15 @@ var configStruct = Configuration{
 		},
 	},
 	HTTP: struct {
-		Addr         string        `yaml:"addr,omitempty"`
-		Net          string        `yaml:"net,omitempty"`
-		Host         string        `yaml:"host,omitempty"`
-		Prefix       string        `yaml:"prefix,omitempty"`
-		Secret       string        `yaml:"secret,omitempty"`
-		RelativeURLs bool          `yaml:"relativeurls,omitempty"`
-		DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
-		TLS          struct {
+		Addr               string        `yaml:"addr,omitempty"`
+		Net                string        `yaml:"net,omitempty"`
+		Host               string        `yaml:"host,omitempty"`
+		Prefix             string        `yaml:"prefix,omitempty"`
+		Secret             string        `yaml:"secret,omitempty"`
+		RelativeURLs       bool          `yaml:"relativeurls,omitempty"`
+		DrainTimeout       time.Duration `yaml:"draintimeout,omitempty"`
+		ProcessingInterval time.Duration `yaml:"processinginterval,omitempty"`
+		TLS                struct {
 			Certificate  string   `yaml:"certificate,omitempty"`
 			Key          string   `yaml:"key,omitempty"`
 			ClientCAs    []string `yaml:"clientcas,omitempty"`
@@ -91,13 +92,15 @@ var configStruct = Configuration{
 				Hosts     []string `yaml:"hosts,omitempty"`
 			} `yaml:"letsencrypt,omitempty"`
 		} `yaml:"tls,omitempty"`
-		Headers http.Header `yaml:"headers,omitempty"`
-		Debug   struct {
+		Headers         http.Header     `yaml:"headers,omitempty"`
+		ResponseHeaders ResponseHeaders `yaml:"responseheaders,omitempty"`
+		Debug           struct {
 			Addr       string `yaml:"addr,omitempty"`
 			Prometheus struct {
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
 			} `yaml:"prometheus,omitempty"`
+			Triggers bool `yaml:"triggers,omitempty"`
 		} `yaml:"debug,omitempty"`
 		HTTP2 struct {
 			Disabled bool `yaml:"disabled,omitempty"`
@@ -133,7 +133,9 @@ var getVarsFromRequest = mux.Vars
 // context. Variables are available at keys with the prefix "vars.". For
 // example, if looking for the variable "name", it can be accessed as
 // "vars.name". Implementations that are accessing values need not know that
-// the underlying context is implemented with gorilla/mux vars.
+// the underlying context is implemented with gorilla/mux vars. The variables
+// of the registry API are best read with GetRepositoryName, GetReference,
+// GetDigest and GetUploadUUID.
 func WithVars(ctx context.Context, r *http.Request) context.Context {
 	return &muxVarsContext{
 		Context: ctx,
@@ -0,0 +1,78 @@
+package context
+
+import (
+	"context"
+	"errors"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// The keys of the request-scoped values set by the registry on the contexts
+// of the requests it serves. They are part of the API of this package, and
+// keep their values across releases, so that middleware and extensions may
+// log them as fields, for instance with GetLogger(ctx, RepositoryNameKey).
+// The values are best read with the accessors below.
+const (
+	// RepositoryNameKey is the key of the name of the repository of the
+	// request, set by WithVars.
+	RepositoryNameKey = "vars.name"
+
+	// ReferenceKey is the key of the tag or digest of the manifest of the
+	// request, set by WithVars.
+	ReferenceKey = "vars.reference"
+
+	// DigestKey is the key of the digest of the blob of the request, set by
+	// WithVars.
+	DigestKey = "vars.digest"
+
+	// UploadUUIDKey is the key of the identifier of the upload of the
+	// request, set by WithVars.
+	UploadUUIDKey = "vars.uuid"
+
+	// UserKey is the key of the user info the request was authenticated as,
+	// set by the access controllers.
+	UserKey = "auth.user"
+
+	// UserNameKey is the key of the name of the user the request was
+	// authenticated as, set by the access controllers.
+	UserNameKey = "auth.user.name"
+)
+
+// ErrDigestNotAvailable is returned by GetDigest when the request has no
+// digest.
+var ErrDigestNotAvailable = errors.New("digest not available in context")
+
+// GetRepositoryName returns the name of the repository of the request, or
+// an empty string when the request has none.
+func GetRepositoryName(ctx context.Context) string {
+	return GetStringValue(ctx, RepositoryNameKey)
+}
+
+// GetReference returns the tag or digest of the manifest of the request, or
+// an empty string when the request has none.
+func GetReference(ctx context.Context) string {
+	return GetStringValue(ctx, ReferenceKey)
+}
+
+// GetDigest returns the digest of the blob of the request. It returns
+// ErrDigestNotAvailable when the request has no digest, and the error parsing
+// the digest when it is invalid.
+func GetDigest(ctx context.Context) (digest.Digest, error) {
+	dgstStr := GetStringValue(ctx, DigestKey)
+	if dgstStr == "" {
+		return "", ErrDigestNotAvailable
+	}
+	return digest.Parse(dgstStr)
+}
+
+// GetUploadUUID returns the identifier of the upload of the request, or an
+// empty string when the request has none.
+func GetUploadUUID(ctx context.Context) string {
+	return GetStringValue(ctx, UploadUUIDKey)
+}
+
+// GetUserName returns the name of the user the request was authenticated as,
+// or an empty string when the request is not authenticated.
+func GetUserName(ctx context.Context) string {
+	return GetStringValue(ctx, UserNameKey)
+}
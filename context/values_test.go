@@ -0,0 +1,53 @@
+package context
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestValues(t *testing.T) {
+	dgst := digest.FromString("blob")
+	var req http.Request
+	getVarsFromRequest = func(r *http.Request) map[string]string {
+		return map[string]string{
+			"name":      "foo/bar",
+			"reference": "latest",
+			"digest":    dgst.String(),
+			"uuid":      "a4d3b8a6",
+		}
+	}
+
+	ctx := WithVars(context.WithValue(Background(), UserNameKey, "alice"), &req)
+	if name := GetRepositoryName(ctx); name != "foo/bar" {
+		t.Errorf("unexpected repository name: %q", name)
+	}
+	if reference := GetReference(ctx); reference != "latest" {
+		t.Errorf("unexpected reference: %q", reference)
+	}
+	if d, err := GetDigest(ctx); err != nil || d != dgst {
+		t.Errorf("unexpected digest: %q, %v", d, err)
+	}
+	if uuid := GetUploadUUID(ctx); uuid != "a4d3b8a6" {
+		t.Errorf("unexpected upload uuid: %q", uuid)
+	}
+	if user := GetUserName(ctx); user != "alice" {
+		t.Errorf("unexpected user name: %q", user)
+	}
+
+	ctx = Background()
+	if _, err := GetDigest(ctx); err != ErrDigestNotAvailable {
+		t.Errorf("expected the digest not to be available, got %v", err)
+	}
+	if name, user := GetRepositoryName(ctx), GetUserName(ctx); name != "" || user != "" {
+		t.Errorf("unexpected values without a request: %q, %q", name, user)
+	}
+	getVarsFromRequest = func(r *http.Request) map[string]string {
+		return map[string]string{"digest": "sha256:invalid"}
+	}
+	if _, err := GetDigest(WithVars(Background(), &req)); err == nil {
+		t.Error("expected an error parsing an invalid digest")
+	}
+}
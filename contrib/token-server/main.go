@@ -262,7 +262,7 @@ func (ts *tokenServer) getToken(ctx context.Context, w http.ResponseWriter, r *h
 	}
 	ctx = authorizedCtx
 
-	username := dcontext.GetStringValue(ctx, "auth.user.name")
+	username := dcontext.GetUserName(ctx)
 
 	ctx = context.WithValue(ctx, acctSubject{}, username)
 	ctx = dcontext.WithLogger(ctx, dcontext.GetLogger(ctx, acctSubject{}))
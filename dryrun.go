@@ -0,0 +1,20 @@
+package distribution
+
+import (
+	"context"
+)
+
+type dryRunKey struct{}
+
+// WithDryRun returns a context in which the deletions of manifests, tags and
+// blobs are evaluated, running the checks and policies which may refuse them,
+// without deleting anything or notifying listeners.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun reports whether deletions are only evaluated in ctx.
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
@@ -121,3 +121,24 @@ type ErrManifestNameInvalid struct {
 func (err ErrManifestNameInvalid) Error() string {
 	return fmt.Sprintf("manifest name %q invalid: %v", err.Name, err.Reason)
 }
+
+// ErrContentHeld is returned when deleting content, or a tag, which is under
+// the legal hold identified by Hold.
+type ErrContentHeld struct {
+	Hold string
+}
+
+func (err ErrContentHeld) Error() string {
+	return fmt.Sprintf("content is under legal hold %s", err.Hold)
+}
+
+// ErrBlobReferenced is returned when deleting a blob of a repository which
+// the manifest Manifest stored in the repository still references.
+type ErrBlobReferenced struct {
+	Digest   digest.Digest
+	Manifest digest.Digest
+}
+
+func (err ErrBlobReferenced) Error() string {
+	return fmt.Sprintf("blob %s is referenced by manifest %s", err.Digest, err.Manifest)
+}
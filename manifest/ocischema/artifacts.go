@@ -0,0 +1,24 @@
+package ocischema
+
+// Media types of the configs and layers of artifacts stored as OCI image
+// manifests by the Helm and WASM ecosystems.
+const (
+	// MediaTypeHelmConfig is the media type of the config of Helm charts,
+	// the metadata of the chart in JSON.
+	MediaTypeHelmConfig = "application/vnd.cncf.helm.config.v1+json"
+
+	// MediaTypeHelmChartContent is the media type of the layer holding the
+	// chart archive.
+	MediaTypeHelmChartContent = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+	// MediaTypeHelmChartProvenance is the media type of the optional layer
+	// holding the provenance file of the chart.
+	MediaTypeHelmChartProvenance = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+
+	// MediaTypeWasmConfig is the media type of the config of WASM modules.
+	MediaTypeWasmConfig = "application/vnd.wasm.config.v1+json"
+
+	// MediaTypeWasmContentLayer is the media type of the layers holding
+	// WASM modules.
+	MediaTypeWasmContentLayer = "application/vnd.wasm.content.layer.v1+wasm"
+)
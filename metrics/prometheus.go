@@ -13,4 +13,19 @@ var (
 
 	// NotificationsNamespace is the prometheus namespace of notification related metrics
 	NotificationsNamespace = metrics.NewNamespace(NamespacePrefix, "notifications", nil)
+
+	// ProxyNamespace is the prometheus namespace of pull through cache related metrics
+	ProxyNamespace = metrics.NewNamespace(NamespacePrefix, "proxy", nil)
+
+	// EgressNamespace is the prometheus namespace of blob download bandwidth related metrics
+	EgressNamespace = metrics.NewNamespace(NamespacePrefix, "egress", nil)
+
+	// UploadsNamespace is the prometheus namespace of blob upload guardrail related metrics
+	UploadsNamespace = metrics.NewNamespace(NamespacePrefix, "uploads", nil)
+
+	// FaultsNamespace is the prometheus namespace of fault injection related metrics
+	FaultsNamespace = metrics.NewNamespace(NamespacePrefix, "faults", nil)
+
+	// ReferrersNamespace is the prometheus namespace of referrers index related metrics
+	ReferrersNamespace = metrics.NewNamespace(NamespacePrefix, "referrers", nil)
 )
@@ -1,6 +1,7 @@
 package notifications
 
 import (
+	"encoding/json"
 	"net/http"
 	"time"
 
@@ -22,6 +23,7 @@ type bridge struct {
 }
 
 var _ Listener = &bridge{}
+var _ HoldListener = &bridge{}
 
 // URLBuilder defines a subset of url builder to be used by the event listener.
 type URLBuilder interface {
@@ -126,6 +128,24 @@ func (b *bridge) RepoDeleted(repo reference.Named) error {
 	return b.sink.Write(*event)
 }
 
+func (b *bridge) HoldPlaced(url, repo string, dgst digest.Digest, tags string) error {
+	return b.createHoldEventAndWrite(EventActionHold, url, repo, dgst, tags)
+}
+
+func (b *bridge) HoldReleased(url, repo string, dgst digest.Digest, tags string) error {
+	return b.createHoldEventAndWrite(EventActionRelease, url, repo, dgst, tags)
+}
+
+func (b *bridge) createHoldEventAndWrite(action, url, repo string, dgst digest.Digest, tags string) error {
+	event := b.createEvent(action)
+	event.Target.URL = url
+	event.Target.Repository = repo
+	event.Target.Digest = dgst
+	event.Target.Tag = tags
+
+	return b.sink.Write(*event)
+}
+
 func (b *bridge) createManifestDeleteEventAndWrite(action string, repo reference.Named, dgst digest.Digest) error {
 	event := b.createEvent(action)
 	event.Target.Repository = repo.Name()
@@ -156,6 +176,12 @@ func (b *bridge) createManifestEvent(action string, repo reference.Named, sm dis
 	if b.includeReferences {
 		event.Target.References = append(event.Target.References, manifest.References()...)
 	}
+	var fields struct {
+		Subject *distribution.Descriptor `json:"subject"`
+	}
+	if err := json.Unmarshal(p, &fields); err == nil {
+		event.Target.Subject = fields.Subject
+	}
 
 	ref, err := reference.WithDigest(repo, event.Target.Digest)
 	if err != nil {
@@ -146,6 +146,31 @@ func TestEventBridgeRepoDeleted(t *testing.T) {
 	}
 }
 
+func TestEventBridgeHold(t *testing.T) {
+	const holdURL = "http://test.example.com/v2/_holds/0b5d3d4a-2b3c-4f3e-9a3c-5b6f2e9c1d2e"
+	var actions []string
+	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
+		checkDeleted(t, EventActionHold, event)
+		e := event.(Event)
+		if e.Target.URL != holdURL || e.Target.Tag != "release-*" {
+			t.Fatalf("unexpected hold event target: %#v", e.Target)
+		}
+		actions = append(actions, e.Action)
+		return nil
+	}))
+
+	hl := l.(HoldListener)
+	if err := hl.HoldPlaced(holdURL, repo, "", "release-*"); err != nil {
+		t.Fatalf("unexpected error notifying hold: %v", err)
+	}
+	if err := hl.HoldReleased(holdURL, repo, "", "release-*"); err != nil {
+		t.Fatalf("unexpected error notifying hold release: %v", err)
+	}
+	if len(actions) != 2 || actions[0] != EventActionHold || actions[1] != EventActionRelease {
+		t.Fatalf("unexpected event actions: %v", actions)
+	}
+}
+
 func createTestEnv(t *testing.T, fn testSinkFn) Listener {
 	pk, err := libtrust.GenerateECP256PrivateKey()
 	if err != nil {
@@ -14,6 +14,11 @@ const (
 	EventActionPush   = "push"
 	EventActionMount  = "mount"
 	EventActionDelete = "delete"
+
+	// EventActionHold and EventActionRelease audit legal holds being placed
+	// and released. The URL of their target locates the hold.
+	EventActionHold    = "hold"
+	EventActionRelease = "release"
 )
 
 const (
@@ -75,6 +80,10 @@ type Event struct {
 
 		// References provides the references descriptors.
 		References []distribution.Descriptor `json:"references,omitempty"`
+
+		// Subject describes the manifest a pushed manifest refers to, such
+		// as the image a signature is attached to.
+		Subject *distribution.Descriptor `json:"subject,omitempty"`
 	} `json:"target,omitempty"`
 
 	// Request covers the request that generated the event.
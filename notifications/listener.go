@@ -32,6 +32,14 @@ type RepoListener interface {
 	RepoDeleted(repo reference.Named) error
 }
 
+// HoldListener describes a listener that can respond to legal holds being
+// placed and released. repo is empty for holds applying to all repositories,
+// and tags is the pattern of the held tags, if any.
+type HoldListener interface {
+	HoldPlaced(url, repo string, dgst digest.Digest, tags string) error
+	HoldReleased(url, repo string, dgst digest.Digest, tags string) error
+}
+
 // Listener combines all repository events into a single interface.
 type Listener interface {
 	ManifestListener
@@ -93,7 +101,7 @@ type manifestServiceListener struct {
 
 func (msl *manifestServiceListener) Delete(ctx context.Context, dgst digest.Digest) error {
 	err := msl.ManifestService.Delete(ctx, dgst)
-	if err == nil {
+	if err == nil && !distribution.IsDryRun(ctx) {
 		if err := msl.parent.listener.ManifestDeleted(msl.parent.Repository.Named(), dgst); err != nil {
 			dcontext.GetLogger(ctx).Errorf("error dispatching manifest delete to listener: %v", err)
 		}
@@ -114,9 +122,10 @@ func (msl *manifestServiceListener) Get(ctx context.Context, dgst digest.Digest,
 }
 
 func (msl *manifestServiceListener) Put(ctx context.Context, sm distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
-	dgst, err := msl.ManifestService.Put(ctx, sm, options...)
+	var stored bool
+	dgst, err := msl.ManifestService.Put(distribution.WithManifestUnchanged(ctx, &stored), sm, options...)
 
-	if err == nil {
+	if err == nil && !(stored && msl.tagged(ctx, dgst, options...)) {
 		if err := msl.parent.listener.ManifestPushed(msl.parent.Repository.Named(), sm, options...); err != nil {
 			dcontext.GetLogger(ctx).Errorf("error dispatching manifest push to listener: %v", err)
 		}
@@ -125,6 +134,21 @@ func (msl *manifestServiceListener) Put(ctx context.Context, sm distribution.Man
 	return dgst, err
 }
 
+// tagged returns whether the tag the manifest dgst is pushed by, if any,
+// already points to it. Pushes of manifests already stored by such tags, which
+// CI pipelines often repeat, change nothing and are not notified.
+func (msl *manifestServiceListener) tagged(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) bool {
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithTagOption); ok {
+			desc, err := msl.parent.Repository.Tags(ctx).Get(ctx, opt.Tag)
+			if err != nil || desc.Digest != dgst {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 type blobServiceListener struct {
 	distribution.BlobStore
 	parent *repositoryListener
@@ -202,7 +226,7 @@ func (bsl *blobServiceListener) Create(ctx context.Context, options ...distribut
 
 func (bsl *blobServiceListener) Delete(ctx context.Context, dgst digest.Digest) error {
 	err := bsl.BlobStore.Delete(ctx, dgst)
-	if err == nil {
+	if err == nil && !distribution.IsDryRun(ctx) {
 		if err := bsl.parent.listener.BlobDeleted(bsl.parent.Repository.Named(), dgst); err != nil {
 			dcontext.GetLogger(ctx).Errorf("error dispatching layer delete to listener: %v", err)
 		}
@@ -255,6 +279,9 @@ func (tagSL *tagServiceListener) Untag(ctx context.Context, tag string) error {
 	if err := tagSL.TagService.Untag(ctx, tag); err != nil {
 		return err
 	}
+	if distribution.IsDryRun(ctx) {
+		return nil
+	}
 	if err := tagSL.parent.listener.TagDeleted(tagSL.parent.Repository.Named(), tag); err != nil {
 		dcontext.GetLogger(ctx).Errorf("error dispatching tag deleted to listener: %v", err)
 		return err
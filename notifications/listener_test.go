@@ -203,6 +203,15 @@ func checkExerciseRepository(t *testing.T, repository distribution.Repository, r
 		t.Fatalf("unexpected error tagging manifest: %v", err)
 	}
 
+	// Pushing the manifest again by its tag changes nothing, and is not
+	// notified.
+	if digestPut, err = manifests.Put(ctx, sm, distribution.WithTag(tag)); err != nil {
+		t.Fatalf("unexpected error putting the manifest again: %v", err)
+	}
+	if dgst != digestPut {
+		t.Fatalf("mismatching digest from payload and put")
+	}
+
 	_, err = manifests.Get(ctx, dgst)
 	if err != nil {
 		t.Fatalf("unexpected error fetching manifest: %v", err)
@@ -162,3 +162,84 @@ func (imts *ignoredSink) Write(event events.Event) error {
 func (imts *ignoredSink) Close() error {
 	return nil
 }
+
+// Hub fans events out to subscribers, such as clients watching the events of
+// a repository. Writes never block: subscribers which fall further behind
+// than their buffer are unsubscribed, closing their channel, rather than
+// hold up the other sinks of the registry.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+// NewHub returns a hub without subscribers.
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving the events written to the hub from
+// now on, buffering up to buffer of them, and the function unsubscribing it.
+// The channel is closed once unsubscribed, or when the hub is closed.
+func (h *Hub) Subscribe(buffer int) (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Event, buffer)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subscribers[ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.unsubscribe(ch)
+	}
+}
+
+// unsubscribe must be called with the lock held.
+func (h *Hub) unsubscribe(ch chan Event) {
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Write passes the event along to the subscribers.
+func (h *Hub) Write(event events.Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return ErrSinkClosed
+	}
+	e, ok := event.(Event)
+	if !ok {
+		return nil
+	}
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			logrus.Warnf("hub: subscriber fell behind, unsubscribing it")
+			h.unsubscribe(ch)
+		}
+	}
+	return nil
+}
+
+// Close unsubscribes all subscribers.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return fmt.Errorf("hub: already closed")
+	}
+	h.closed = true
+	for ch := range h.subscribers {
+		h.unsubscribe(ch)
+	}
+	return nil
+}
@@ -122,6 +122,44 @@ func TestIgnoredSink(t *testing.T) {
 	}
 }
 
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	watching, unsubscribe := hub.Subscribe(2)
+	defer unsubscribe()
+	behind, _ := hub.Subscribe(1)
+
+	push := createTestEvent("push", "library/test", "manifest")
+	del := createTestEvent("delete", "library/test", "manifest")
+	for _, event := range []Event{push, del} {
+		if err := hub.Write(event); err != nil {
+			t.Fatalf("error writing event: %v", err)
+		}
+	}
+
+	for _, expected := range []Event{push, del} {
+		if event := <-watching; !reflect.DeepEqual(event, expected) {
+			t.Fatalf("unexpected event: %#v != %#v", event, expected)
+		}
+	}
+	// The subscriber buffering one event missed the second one.
+	if event := <-behind; !reflect.DeepEqual(event, push) {
+		t.Fatalf("unexpected event: %#v != %#v", event, push)
+	}
+	if _, ok := <-behind; ok {
+		t.Fatalf("expected the subscriber which fell behind to be unsubscribed")
+	}
+
+	if err := hub.Close(); err != nil {
+		t.Fatalf("error closing hub: %v", err)
+	}
+	if _, ok := <-watching; ok {
+		t.Fatalf("expected closing the hub to unsubscribe subscribers")
+	}
+	if err := hub.Write(push); err != ErrSinkClosed {
+		t.Fatalf("expected ErrSinkClosed writing to a closed hub, got %v", err)
+	}
+}
+
 type testSink struct {
 	event  events.Event
 	count  int
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
 )
 
 // Scope defines the set of items that match a namespace.
@@ -93,6 +94,23 @@ func (o WithManifestMediaTypesOption) Apply(m ManifestService) error {
 	return nil
 }
 
+// WithReplacementDigest allows a manifest service serving another manifest in
+// place of the one requested from Get to report the digest of the manifest it
+// serves into dgst, which is left unchanged otherwise.
+func WithReplacementDigest(dgst *digest.Digest) ManifestServiceOption {
+	return WithReplacementDigestOption{dgst}
+}
+
+// WithReplacementDigestOption holds where to report the digest of a
+// replacement manifest
+type WithReplacementDigestOption struct{ Digest *digest.Digest }
+
+// Apply conforms to the ManifestServiceOption interface
+func (o WithReplacementDigestOption) Apply(m ManifestService) error {
+	// no implementation
+	return nil
+}
+
 // Repository is a named collection of manifests and layers.
 type Repository interface {
 	// Named returns the name of the repository.
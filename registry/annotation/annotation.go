@@ -0,0 +1,413 @@
+// Package annotation validates and normalizes the annotation keys of the OCI
+// manifests pushed to the registry, so that keys which do not follow
+// reverse-domain conventions do not propagate to referrers listings and the
+// indexes built from manifests.
+//
+// A key follows the conventions when it is a reverse domain name of at least
+// two DNS labels followed by a name, such as org.opencontainers.image.created
+// or com.example.myKey. The name is made of letters, digits, dots, hyphens and
+// underscores. Keys are normalized by trimming surrounding whitespace and
+// lowercasing their domain labels. The annotations of the manifest, of its
+// config, layers, manifests and subject are validated.
+package annotation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The strictness of the validation of annotation keys.
+const (
+	// ModeOff leaves annotations as pushed.
+	ModeOff = "off"
+
+	// ModeNormalize stores the manifests pushed by tag with their
+	// annotation keys normalized. Keys not following the conventions are
+	// logged but accepted.
+	ModeNormalize = "normalize"
+
+	// ModeStrict rejects manifests with keys not following the
+	// conventions, and those pushed by digest whose keys are not
+	// normalized, as they cannot be stored normalized.
+	ModeStrict = "strict"
+)
+
+type namespace struct {
+	repositories []string
+	mode         string
+}
+
+// Validator validates the annotation keys of the manifests pushed, with the
+// strictness configured for their repository.
+type Validator struct {
+	mode       string
+	namespaces []namespace
+}
+
+func validMode(mode string) bool {
+	switch mode {
+	case ModeOff, ModeNormalize, ModeStrict:
+		return true
+	}
+	return false
+}
+
+// New creates the validator of annotation keys configured.
+func New(config configuration.Annotations) (*Validator, error) {
+	v := &Validator{mode: config.Mode}
+	if v.mode == "" {
+		v.mode = ModeOff
+	}
+	if !validMode(v.mode) {
+		return nil, fmt.Errorf("unknown mode %q", config.Mode)
+	}
+
+	for i, ns := range config.Namespaces {
+		if !validMode(ns.Mode) {
+			return nil, fmt.Errorf("namespace #%d: unknown mode %q", i+1, ns.Mode)
+		}
+		if len(ns.Repositories) == 0 {
+			return nil, fmt.Errorf("namespace #%d: no repositories", i+1)
+		}
+		for _, pattern := range ns.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("namespace #%d: invalid pattern %q", i+1, pattern)
+			}
+		}
+		v.namespaces = append(v.namespaces, namespace{repositories: ns.Repositories, mode: ns.Mode})
+	}
+	return v, nil
+}
+
+// Mode returns the strictness applied to the repository name.
+func (v *Validator) Mode(name string) string {
+	for _, ns := range v.namespaces {
+		for _, pattern := range ns.repositories {
+			if ok, _ := path.Match(pattern, name); ok {
+				return ns.mode
+			}
+		}
+	}
+	return v.mode
+}
+
+// NormalizeKey returns key with surrounding whitespace trimmed and its domain
+// labels lowercased.
+func NormalizeKey(key string) string {
+	key = strings.TrimSpace(key)
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) < 3 {
+		return key
+	}
+	return strings.ToLower(parts[0]) + "." + strings.ToLower(parts[1]) + "." + parts[2]
+}
+
+// ValidKey reports whether the normalized key follows reverse-domain
+// conventions.
+func ValidKey(key string) bool {
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) < 3 || !validLabel(parts[0]) || !validLabel(parts[1]) {
+		return false
+	}
+	for _, name := range strings.Split(parts[2], ".") {
+		if name == "" {
+			return false
+		}
+		for _, c := range name {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validLabel reports whether label is a lowercase DNS label.
+func validLabel(label string) bool {
+	if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// InvalidKey is an annotation key which does not follow reverse-domain
+// conventions.
+type InvalidKey struct {
+	// Path is the JSON pointer of the annotations holding the key.
+	Path string `json:"path"`
+	Key  string `json:"key"`
+}
+
+// ValidationDetail is the detail of the errors of manifests rejected for
+// their annotation keys.
+type ValidationDetail struct {
+	Invalid []InvalidKey `json:"invalid,omitempty"`
+}
+
+// member is an annotation, in the order it appears in a manifest.
+type member struct {
+	key   string
+	value string
+}
+
+// decodeAnnotations decodes an annotations object, keeping the keys it
+// duplicates.
+func decodeAnnotations(raw json.RawMessage) ([]member, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, fmt.Errorf("annotations are not an object")
+	}
+	var members []member
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var m member
+		m.key = t.(string)
+		if err := dec.Decode(&m.value); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// normalize returns the annotations of members with their keys normalized,
+// and whether they differ from members. Of the keys duplicated, the last
+// occurrence of a key wins as it does with JSON decoders, and of the keys
+// which differ but are equal once normalized, the one which already is
+// normalized wins, or else the first in byte order.
+func normalize(members []member) (map[string]string, bool) {
+	originals := make(map[string][]string)
+	values := make(map[string]string)
+	changed := false
+	for _, m := range members {
+		normalized := NormalizeKey(m.key)
+		if _, ok := values[m.key]; ok {
+			changed = true
+		} else {
+			originals[normalized] = append(originals[normalized], m.key)
+		}
+		if normalized != m.key {
+			changed = true
+		}
+		values[m.key] = m.value
+	}
+
+	annotations := make(map[string]string, len(originals))
+	for normalized, keys := range originals {
+		sort.Strings(keys)
+		winner := keys[0]
+		for _, key := range keys {
+			if key == normalized {
+				winner = key
+			}
+		}
+		annotations[normalized] = values[winner]
+	}
+	return annotations, changed
+}
+
+// document is an OCI manifest or index, of which the members holding
+// annotations are decoded.
+type document struct {
+	fields  map[string]json.RawMessage
+	invalid []InvalidKey
+}
+
+// normalizeObject normalizes the annotations of the object at pointer,
+// returning whether they changed.
+func (d *document) normalizeObject(object map[string]json.RawMessage, pointer string) (bool, error) {
+	raw, ok := object["annotations"]
+	if !ok || string(raw) == "null" {
+		return false, nil
+	}
+	members, err := decodeAnnotations(raw)
+	if err != nil {
+		return false, err
+	}
+	annotations, changed := normalize(members)
+
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !ValidKey(key) {
+			d.invalid = append(d.invalid, InvalidKey{Path: pointer + "/annotations", Key: key})
+		}
+	}
+
+	if changed {
+		if object["annotations"], err = json.Marshal(annotations); err != nil {
+			return false, err
+		}
+	}
+	return changed, nil
+}
+
+// normalizeDescriptor normalizes the annotations of the descriptor the member
+// name of the document holds, returning whether they changed.
+func (d *document) normalizeDescriptor(name string) (bool, error) {
+	raw, ok := d.fields[name]
+	if !ok || string(raw) == "null" {
+		return false, nil
+	}
+	var descriptor map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &descriptor); err != nil {
+		return false, err
+	}
+	changed, err := d.normalizeObject(descriptor, "/"+name)
+	if err != nil || !changed {
+		return false, err
+	}
+	d.fields[name], err = json.Marshal(descriptor)
+	return true, err
+}
+
+// normalizeDescriptors normalizes the annotations of the descriptors the
+// member name of the document lists, returning whether they changed.
+func (d *document) normalizeDescriptors(name string) (bool, error) {
+	raw, ok := d.fields[name]
+	if !ok || string(raw) == "null" {
+		return false, nil
+	}
+	var descriptors []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &descriptors); err != nil {
+		return false, err
+	}
+	changed := false
+	for i, descriptor := range descriptors {
+		c, err := d.normalizeObject(descriptor, fmt.Sprintf("/%s/%d", name, i))
+		if err != nil {
+			return false, err
+		}
+		changed = changed || c
+	}
+	if !changed {
+		return false, nil
+	}
+	var err error
+	d.fields[name], err = json.Marshal(descriptors)
+	return true, err
+}
+
+// normalizeManifest normalizes the annotations of an OCI manifest payload,
+// returning the normalized payload, or nil if it is already normalized, and
+// the keys which do not follow the conventions once normalized.
+func normalizeManifest(payload []byte) ([]byte, []InvalidKey, error) {
+	d := &document{}
+	if err := json.Unmarshal(payload, &d.fields); err != nil {
+		return nil, nil, err
+	}
+	changed, err := d.normalizeObject(d.fields, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range []string{"config", "subject"} {
+		c, err := d.normalizeDescriptor(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		changed = changed || c
+	}
+	for _, name := range []string{"layers", "manifests"} {
+		c, err := d.normalizeDescriptors(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		changed = changed || c
+	}
+	if !changed {
+		return nil, d.invalid, nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "   ")
+	if err := enc.Encode(d.fields); err != nil {
+		return nil, nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), d.invalid, nil
+}
+
+// Validate validates the annotation keys of a manifest pushed to repository
+// with tag, empty when it is pushed by digest, and returns the manifest to
+// store in its place. It returns a v2.ErrorCodeManifestInvalid error when the
+// manifest is rejected by the strictness of the repository.
+func (v *Validator) Validate(ctx context.Context, repository reference.Named, tag string, manifest distribution.Manifest) (distribution.Manifest, error) {
+	mode := v.Mode(repository.Name())
+	if mode == ModeOff {
+		return manifest, nil
+	}
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != v1.MediaTypeImageManifest && mediaType != v1.MediaTypeImageIndex {
+		return manifest, nil
+	}
+
+	normalized, invalid, err := normalizeManifest(payload)
+	if err != nil {
+		// Malformed manifests are rejected by the manifest store.
+		return manifest, nil
+	}
+
+	logger := dcontext.GetLogger(ctx)
+	if len(invalid) > 0 {
+		if mode == ModeStrict {
+			return nil, v2.ErrorCodeManifestInvalid.WithMessage(fmt.Sprintf("annotation key %s does not follow reverse-domain conventions", invalid[0].Key)).
+				WithDetail(ValidationDetail{Invalid: invalid})
+		}
+		logger.Warnf("manifest pushed to %s has %d annotation keys not following reverse-domain conventions, such as %s", repository.Name(), len(invalid), invalid[0].Key)
+	}
+	if normalized == nil {
+		return manifest, nil
+	}
+
+	if tag == "" {
+		if mode == ModeStrict {
+			return nil, v2.ErrorCodeManifestInvalid.WithMessage("annotation keys of manifests pushed by digest must be normalized")
+		}
+		logger.Infof("manifest pushed by digest to %s stored with annotation keys not normalized", repository.Name())
+		return manifest, nil
+	}
+	rewritten, _, err := distribution.UnmarshalManifest(mediaType, normalized)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("annotation keys of manifest pushed to %s:%s normalized", repository.Name(), tag)
+	return rewritten, nil
+}
+
+// Repository returns the repository with the annotation keys of the manifests
+// pushed validated.
+func (v *Validator) Repository(repository distribution.Repository) distribution.Repository {
+	return repositorymiddleware.WithHooks(repository, repositorymiddleware.Hooks{
+		PushManifest: v.Validate,
+	})
+}
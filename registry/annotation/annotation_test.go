@@ -0,0 +1,198 @@
+package annotation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	_ "github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestKeys(t *testing.T) {
+	for key, expected := range map[string]string{
+		"org.opencontainers.image.created": "org.opencontainers.image.created",
+		" Com.Example.myKey\t":             "com.example.myKey",
+		"IO.CNCF.Notary.x":                 "io.cncf.Notary.x",
+		"version":                          "version",
+	} {
+		if normalized := NormalizeKey(key); normalized != expected {
+			t.Errorf("NormalizeKey(%q) = %q, expected %q", key, normalized, expected)
+		}
+	}
+
+	for key, valid := range map[string]bool{
+		"org.opencontainers.image.created":         true,
+		"com.example.myKey":                        true,
+		"com.docker.official-images.bashbrew.arch": true,
+		"com.example.my_key.v2":                    true,
+		"version":                                  false,
+		"com.example":                              false,
+		"com.example.":                             false,
+		"com.example..key":                         false,
+		"com.-example.key":                         false,
+		"com.example.my key":                       false,
+		"COM.example.key":                          false,
+	} {
+		if ValidKey(key) != valid {
+			t.Errorf("ValidKey(%q) = %t, expected %t", key, !valid, valid)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		annotations string
+		expected    map[string]string
+		changed     bool
+	}{
+		{`{"com.example.a": "1", "com.example.b": "2"}`, map[string]string{"com.example.a": "1", "com.example.b": "2"}, false},
+		{`{"com.example.a": "1", "com.example.a": "2"}`, map[string]string{"com.example.a": "2"}, true},
+		{`{"COM.example.a": "1", "com.example.a": "2", "Com.Example.a": "3"}`, map[string]string{"com.example.a": "2"}, true},
+		{`{"Com.Example.a": "3", "COM.example.a": "1"}`, map[string]string{"com.example.a": "1"}, true},
+		{`{" com.example.a": "1"}`, map[string]string{"com.example.a": "1"}, true},
+	} {
+		members, err := decodeAnnotations(json.RawMessage(tc.annotations))
+		if err != nil {
+			t.Fatal(err)
+		}
+		annotations, changed := normalize(members)
+		if !reflect.DeepEqual(annotations, tc.expected) || changed != tc.changed {
+			t.Errorf("normalizing %s: got %v, %t, expected %v, %t", tc.annotations, annotations, changed, tc.expected, tc.changed)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, config := range []configuration.Annotations{
+		{Mode: "lenient"},
+		{Namespaces: []configuration.AnnotationNamespace{{Repositories: []string{"prod/*"}}}},
+		{Namespaces: []configuration.AnnotationNamespace{{Mode: ModeStrict}}},
+		{Namespaces: []configuration.AnnotationNamespace{{Repositories: []string{"[prod"}, Mode: ModeStrict}}},
+	} {
+		if _, err := New(config); err == nil {
+			t.Errorf("expected an error creating a validator with %+v", config)
+		}
+	}
+
+	v, err := New(configuration.Annotations{
+		Mode: ModeNormalize,
+		Namespaces: []configuration.AnnotationNamespace{
+			{Repositories: []string{"prod/*"}, Mode: ModeStrict},
+			{Repositories: []string{"legacy/*", "prod/legacy"}, Mode: ModeOff},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range map[string]string{"prod/app": ModeStrict, "prod/legacy": ModeStrict, "legacy/app": ModeOff, "dev/app": ModeNormalize} {
+		if m := v.Mode(name); m != mode {
+			t.Errorf("unexpected mode of %s: %s, expected %s", name, m, mode)
+		}
+	}
+}
+
+const testManifest = `{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.oci.image.manifest.v1+json",
+   "config": {
+      "mediaType": "application/vnd.oci.image.config.v1+json",
+      "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+      "size": 2
+   },
+   "layers": [
+      {
+         "mediaType": "application/vnd.oci.image.layer.v1.tar+gzip",
+         "digest": "sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef",
+         "size": 1,
+         "annotations": {"Org.OpenContainers.image.title": "a.tar", "org.opencontainers.image.title": "b.tar"}
+      }
+   ],
+   "annotations": %s
+}`
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	v, err := New(configuration.Annotations{
+		Mode: ModeNormalize,
+		Namespaces: []configuration.AnnotationNamespace{
+			{Repositories: []string{"prod/*"}, Mode: ModeStrict},
+			{Repositories: []string{"legacy/*"}, Mode: ModeOff},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifestOf := func(annotations string) distribution.Manifest {
+		m, _, err := distribution.UnmarshalManifest(v1.MediaTypeImageManifest, []byte(fmt.Sprintf(testManifest, annotations)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	annotationsOf := func(m distribution.Manifest) (map[string]string, map[string]string) {
+		_, payload, err := m.Payload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields v1.Manifest
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			t.Fatal(err)
+		}
+		return fields.Annotations, fields.Layers[0].Annotations
+	}
+
+	dev, _ := reference.WithName("dev/app")
+	pushed := manifestOf(`{"Com.Example.Version": "1", "com.example.version": "2", "version": "3", "<html>": "&"}`)
+	stored, err := v.Validate(ctx, dev, "latest", pushed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations, layer := annotationsOf(stored)
+	if !reflect.DeepEqual(annotations, map[string]string{"com.example.Version": "1", "com.example.version": "2", "version": "3", "<html>": "&"}) {
+		t.Errorf("unexpected annotations stored: %v", annotations)
+	}
+	if !reflect.DeepEqual(layer, map[string]string{"org.opencontainers.image.title": "b.tar"}) {
+		t.Errorf("unexpected layer annotations stored: %v", layer)
+	}
+	// Normalized manifests are stored as is.
+	again, err := v.Validate(ctx, dev, "latest", stored)
+	if err != nil || again != stored {
+		t.Errorf("expected a normalized manifest to be stored as is, got %v", err)
+	}
+	// Manifests pushed by digest cannot be replaced.
+	if stored, err := v.Validate(ctx, dev, "", pushed); err != nil || stored != pushed {
+		t.Errorf("expected a manifest pushed by digest to be stored as is, got %v", err)
+	}
+
+	legacy, _ := reference.WithName("legacy/app")
+	if stored, err := v.Validate(ctx, legacy, "latest", pushed); err != nil || stored != pushed {
+		t.Errorf("expected a manifest pushed to a namespace without validation to be stored as is, got %v", err)
+	}
+
+	prod, _ := reference.WithName("prod/app")
+	_, err = v.Validate(ctx, prod, "latest", pushed)
+	e, ok := err.(errcode.Error)
+	if !ok || e.Code != v2.ErrorCodeManifestInvalid {
+		t.Fatalf("expected an invalid key to be rejected, got %v", err)
+	}
+	if detail := e.Detail.(ValidationDetail); !reflect.DeepEqual(detail.Invalid, []InvalidKey{{Path: "/annotations", Key: "<html>"}, {Path: "/annotations", Key: "version"}}) {
+		t.Errorf("unexpected detail: %v", detail)
+	}
+
+	valid := manifestOf(`{"com.example.version": "2"}`)
+	if _, err := v.Validate(ctx, prod, "latest", valid); err != nil {
+		t.Errorf("unexpected error normalizing a manifest pushed by tag: %v", err)
+	}
+	if _, err := v.Validate(ctx, prod, "", valid); err == nil {
+		t.Error("expected a manifest pushed by digest which is not normalized to be rejected")
+	}
+}
@@ -2,6 +2,7 @@ package errcode
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -117,6 +118,19 @@ func (e Error) Error() string {
 	return fmt.Sprintf("%s: %s", e.Code.Error(), e.Message)
 }
 
+// Is reports whether target is the ErrorCode of e, or an Error of the same
+// code, so that errors.Is matches errors by code whatever their message and
+// detail.
+func (e Error) Is(target error) bool {
+	switch target := target.(type) {
+	case ErrorCode:
+		return e.Code == target
+	case Error:
+		return e.Code == target.Code
+	}
+	return false
+}
+
 // WithDetail will return a new Error, based on the current one, but with
 // some Detail info added
 func (e Error) WithDetail(detail interface{}) Error {
@@ -197,6 +211,27 @@ func (errs Errors) Len() int {
 	return len(errs)
 }
 
+// Is reports whether any of the errors matches target, as errors.Is does.
+func (errs Errors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors which matches target, as errors.As
+// does, and sets target to it.
+func (errs Errors) As(target interface{}) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // MarshalJSON converts slice of error, ErrorCode or Error into a
 // slice of Error - then serializes
 func (errs Errors) MarshalJSON() ([]byte, error) {
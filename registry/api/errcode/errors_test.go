@@ -2,6 +2,8 @@ package errcode
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"reflect"
 	"strings"
@@ -183,3 +185,29 @@ func TestErrorsManagement(t *testing.T) {
 	}
 
 }
+
+func TestErrorsIs(t *testing.T) {
+	var errs Errors
+	errs = append(errs, ErrorCodeTest1.WithDetail(map[string]string{"blob": "unknown"}))
+	errs = append(errs, ErrorCodeTest3.WithArgs("BOOGIE"))
+	err := fmt.Errorf("request failed: %w", errs)
+
+	if !errors.Is(err, ErrorCodeTest1) || !errors.Is(err, ErrorCodeTest3) {
+		t.Fatal("expected the errors to match their codes")
+	}
+	if !errors.Is(err, ErrorCodeTest3.WithMessage("another message")) {
+		t.Fatal("expected the errors to match an error of the same code")
+	}
+	if errors.Is(err, ErrorCodeTest2) {
+		t.Fatal("expected the errors not to match other codes")
+	}
+
+	var e Error
+	if !errors.As(err, &e) || e.Code != ErrorCodeTest1 {
+		t.Fatalf("expected the first error to be found, got %v", e)
+	}
+	var all Errors
+	if !errors.As(err, &all) || len(all) != 2 {
+		t.Fatalf("expected the errors to be found, got %v", all)
+	}
+}
@@ -88,6 +88,36 @@ var (
 		Format:      "<digest>",
 	}
 
+	dryRunHeader = ParameterDescriptor{
+		Name:        "X-Dry-Run",
+		Type:        "boolean",
+		Description: "Evaluate the deletion, with the checks and policies which may refuse it, and report what it would delete without deleting. Requires the `*` action on the repository.",
+		Format:      "true",
+	}
+
+	dryRunResponseDescriptor = ResponseDescriptor{
+		Name:        "Dry Run",
+		Description: "The deletion was evaluated with the `X-Dry-Run` header and would have succeeded. The body lists what it would have deleted.",
+		StatusCode:  http.StatusOK,
+		Headers: []ParameterDescriptor{
+			{
+				Name:        "X-Dry-Run",
+				Type:        "boolean",
+				Description: "The request was evaluated without deleting.",
+				Format:      "true",
+			},
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format: `{
+    "name": <name>,
+    "manifests": [<digest>, ...],
+    "tags": [<tag>, ...],
+    "blobs": [<digest>, ...]
+}`,
+		},
+	}
+
 	linkHeader = ParameterDescriptor{
 		Name:        "Link",
 		Type:        "link",
@@ -112,6 +142,16 @@ var (
 		},
 	}
 
+	catalogPaginationParameters = append([]ParameterDescriptor{
+		{
+			Name:        "cursor",
+			Type:        "string",
+			Description: "Continue reading the snapshot of the catalog taken by the first page, as given by the Link header of the previous page, when catalog snapshots are enabled. Takes precedence over last.",
+			Format:      "<cursor>",
+			Required:    false,
+		},
+	}, paginationParameters...)
+
 	unauthorizedResponseDescriptor = ResponseDescriptor{
 		Name:        "Authentication Required",
 		StatusCode:  http.StatusUnauthorized,
@@ -508,6 +548,162 @@ var routeDescriptors = []RouteDescriptor{
 							tooManyRequestsDescriptor,
 						},
 					},
+					{
+						Name:           "Tags by Platform",
+						Description:    "Return the tags of the specified repository pointing at manifests with an image of a platform. The filter applies before pagination.",
+						PathParameters: []ParameterDescriptor{nameParameterDescriptor},
+						QueryParameters: append([]ParameterDescriptor{
+							{
+								Name:        "platform",
+								Type:        "string",
+								Description: "Only list the tags of images, or of indexes listing images, of the platform. Without a variant, images of any variant match.",
+								Format:      "<os>/<architecture>[/<variant>]",
+								Required:    true,
+							},
+						}, paginationParameters...),
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "A list of the tags of the platform for the named repository.",
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "tags": [
+        <tag>,
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid platform",
+								Description: "The received parameter platform was not in the os/architecture[/variant] format.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodePlatformInvalid,
+									ErrorCodePaginationNumberInvalid,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameTagOperations,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_ops/tags",
+		Entity:      "Tag Operations",
+		Description: "List and merge the latest operations on the tags of a repository, so that registries accepting tag writes concurrently converge on the state of tags. This route is only served when tag operations are enabled in the storage configuration.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Fetch the latest operation on each tag of the repository identified by `name`, including deleted tags, ordered by tag.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "operations": [
+        {
+            "tag": <tag>,
+            "digest": "<digest>",
+            "timestamp": "<time>",
+            "actor": "<actor>"
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "POST",
+				Description: "Merge operations on tags of the repository identified by `name`. Operations ordered after the latest operation on their tag, by timestamp and then by actor, are applied. An operation without a digest deletes the tag.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+    "operations": [
+        <operation>,
+        ...
+    ]
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The operations were merged.",
+								StatusCode:  http.StatusNoContent,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Operation",
+								Description: "An operation names an invalid tag or digest, or has no timestamp or actor. No operations were merged.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeTagOperationInvalid,
+								},
+							},
+							{
+								Name:        "Unknown Manifest",
+								Description: "An operation tags a manifest which is not stored in the repository. No operations were merged.",
+								StatusCode:  http.StatusNotFound,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
 				},
 			},
 		},
@@ -531,6 +727,15 @@ var routeDescriptors = []RouteDescriptor{
 							nameParameterDescriptor,
 							referenceParameterDescriptor,
 						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "at",
+								Type:        "string",
+								Description: "Resolve the tag `reference` at a past time, when the registry records the history of tags.",
+								Format:      "<RFC 3339 timestamp>",
+								Required:    false,
+							},
+						},
 						Successes: []ResponseDescriptor{
 							{
 								Description: "The manifest identified by `name` and `reference`. The contents can be used to identify and resolve resources required to run the specified image.",
@@ -546,11 +751,12 @@ var routeDescriptors = []RouteDescriptor{
 						},
 						Failures: []ResponseDescriptor{
 							{
-								Description: "The name or reference was invalid.",
+								Description: "The name, reference or time was invalid.",
 								StatusCode:  http.StatusBadRequest,
 								ErrorCodes: []errcode.ErrorCode{
 									ErrorCodeNameInvalid,
 									ErrorCodeTagInvalid,
+									ErrorCodeTimestampInvalid,
 								},
 								Body: BodyDescriptor{
 									ContentType: "application/json",
@@ -613,6 +819,7 @@ var routeDescriptors = []RouteDescriptor{
 									ErrorCodeManifestInvalid,
 									ErrorCodeManifestUnverified,
 									ErrorCodeBlobUnknown,
+									ErrorCodeArtifactInvalid,
 								},
 							},
 							unauthorizedResponseDescriptor,
@@ -661,15 +868,25 @@ var routeDescriptors = []RouteDescriptor{
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
+							dryRunHeader,
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
 							referenceParameterDescriptor,
 						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "force",
+								Type:        "query",
+								Format:      "true",
+								Description: "Delete a manifest by digest even though tags point to it, when deletion of tagged manifests is disabled. Requires the `*` action on the repository.",
+							},
+						},
 						Successes: []ResponseDescriptor{
 							{
 								StatusCode: http.StatusAccepted,
 							},
+							dryRunResponseDescriptor,
 						},
 						Failures: []ResponseDescriptor{
 							{
@@ -710,6 +927,18 @@ var routeDescriptors = []RouteDescriptor{
 									errcode.ErrorCodeUnsupported,
 								},
 							},
+							{
+								Name:        "Manifest Tagged",
+								Description: "The manifest is not deleted because tags of the repository point to it and the registry is configured to protect tagged manifests. The tags must be deleted first.",
+								StatusCode:  http.StatusConflict,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestTagged,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 						},
 					},
 				},
@@ -885,6 +1114,7 @@ var routeDescriptors = []RouteDescriptor{
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
+							dryRunHeader,
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
@@ -903,6 +1133,7 @@ var routeDescriptors = []RouteDescriptor{
 									digestHeader,
 								},
 							},
+							dryRunResponseDescriptor,
 						},
 						Failures: []ResponseDescriptor{
 							{
@@ -936,6 +1167,17 @@ var routeDescriptors = []RouteDescriptor{
 									errcode.ErrorCodeUnsupported,
 								},
 							},
+							{
+								Description: "The blob is referenced by a manifest stored in the repository, which must be deleted first. Only returned if the registry protects referenced blobs.",
+								StatusCode:  http.StatusConflict,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeBlobReferenced,
+								},
+							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
 							deniedResponseDescriptor,
@@ -1565,7 +1807,7 @@ var routeDescriptors = []RouteDescriptor{
 					{
 						Name:            "Catalog Fetch Paginated",
 						Description:     "Return the specified portion of repositories.",
-						QueryParameters: paginationParameters,
+						QueryParameters: catalogPaginationParameters,
 						Successes: []ResponseDescriptor{
 							{
 								StatusCode: http.StatusOK,
@@ -1590,6 +1832,363 @@ var routeDescriptors = []RouteDescriptor{
 								},
 							},
 						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The number of entries or the cursor was invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodePaginationNumberInvalid,
+									ErrorCodePaginationCursorInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameHolds,
+		Path:        "/v2/_holds",
+		Entity:      "Holds",
+		Description: "List and place legal holds. Held content cannot be deleted, through the API, by garbage collection or by the expiry of cached content, until the hold is released. This route requires the registry:holds:* access and is only served when legal holds are enabled in the storage configuration.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve the legal holds of the registry, in the order they were placed.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"holds": [
+		<hold>,
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "POST",
+				Description: "Place a legal hold on a digest, optionally restricted to a repository, or on the tags of a repository matching a pattern.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+	"repository": <name>,
+	"digest": "<digest>",
+	"tags": "<pattern>",
+	"reason": "<reason>"
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The hold was placed and is identified by the returned location.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Format:      "<url>",
+										Description: "The location of the placed hold.",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"id": "<id>",
+	"repository": <name>,
+	"digest": "<digest>",
+	"tags": "<pattern>",
+	"reason": "<reason>",
+	"createdBy": "<user>",
+	"createdAt": "<time>"
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:       "Invalid Hold",
+								StatusCode: http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeHoldInvalid,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameHold,
+		Path:        "/v2/_holds/{id:[a-f0-9-]+}",
+		Entity:      "Hold",
+		Description: "Retrieve or release a legal hold.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve the legal hold identified by `id`.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"id": "<id>",
+	"repository": <name>,
+	"digest": "<digest>",
+	"tags": "<pattern>",
+	"reason": "<reason>",
+	"createdBy": "<user>",
+	"createdAt": "<time>"
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:       "No Such Hold",
+								StatusCode: http.StatusNotFound,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeHoldUnknown,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "DELETE",
+				Description: "Release the legal hold identified by `id`, allowing the content it held to be deleted.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:       "No Such Hold",
+								StatusCode: http.StatusNotFound,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeHoldUnknown,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameRestores,
+		Path:        "/v2/_restores",
+		Entity:      "Restores",
+		Description: "List and request the restores of the blobs archived by the archive classes of the storageclass storage driver, which cannot be pulled until they are restored. This route requires the registry:restores:* access and is only served when the storage driver has archive classes.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve the restores requested from this registry instance, in the order they were requested.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"restores": [
+		<restore>,
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+			{
+				Method:      "POST",
+				Description: "Request the restore of an archived blob to the default storage class, in the background.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+	"digest": "<digest>"
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The restore was started, or was already in progress, and its status is returned by the returned location.",
+								StatusCode:  http.StatusAccepted,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Format:      "<url>",
+										Description: "The location of the status of the restore.",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"digest": "<digest>",
+	"class": "<storage class>",
+	"status": "archived" | "restoring" | "restored" | "failed",
+	"error": "<error>",
+	"requestedBy": "<user>",
+	"requestedAt": "<time>",
+	"completedAt": "<time>"
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:       "Invalid Restore",
+								StatusCode: http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeRestoreInvalid,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameRestore,
+		Path:        "/v2/_restores/{digest:" + digest.DigestRegexp.String() + "}",
+		Entity:      "Restore",
+		Description: "Retrieve the status of the restore of an archived blob. Pulls of archived blobs and manifests return this location.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "GET",
+				Description: "Retrieve the status of the restore of the blob identified by `digest`, `archived` when it is archived and its restore was not requested.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							digestPathParameter,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"digest": "<digest>",
+	"class": "<storage class>",
+	"status": "archived" | "restoring" | "restored" | "failed",
+	"error": "<error>",
+	"requestedBy": "<user>",
+	"requestedAt": "<time>",
+	"completedAt": "<time>"
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:       "No Such Restore",
+								StatusCode: http.StatusNotFound,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeRestoreUnknown,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
 					},
 				},
 			},
@@ -154,4 +154,132 @@ var (
 		to return) is not an integer, or "n" is negative.`,
 		HTTPStatusCode: http.StatusBadRequest,
 	})
+
+	// ErrorCodePaginationCursorInvalid is returned when the `cursor`
+	// parameter is malformed.
+	ErrorCodePaginationCursorInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "PAGINATION_CURSOR_INVALID",
+		Message: "invalid pagination cursor",
+		Description: `Returned when the "cursor" parameter, identifying the
+		snapshot of the catalog and the position in it a page starts at, is
+		malformed.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeTimestampInvalid is returned when the time a tag is resolved
+	// at is invalid.
+	ErrorCodeTimestampInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TIMESTAMP_INVALID",
+		Message: "invalid timestamp",
+		Description: `Returned when the "at" parameter, the time a tag is
+		resolved at, is not a timestamp in RFC 3339 format.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodePlatformInvalid is returned when the platform tags are
+	// filtered by is invalid.
+	ErrorCodePlatformInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "PLATFORM_INVALID",
+		Message: "invalid platform",
+		Description: `Returned when the "platform" parameter, the platform
+		tags are filtered by, is not in the os/architecture[/variant] format.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeHoldUnknown is returned when a legal hold is unknown.
+	ErrorCodeHoldUnknown = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "HOLD_UNKNOWN",
+		Message: "legal hold unknown to registry",
+		Description: `Returned when the legal hold identified in the path
+		does not exist, or was released.`,
+		HTTPStatusCode: http.StatusNotFound,
+	})
+
+	// ErrorCodeHoldInvalid is returned when a legal hold cannot be placed
+	// as requested.
+	ErrorCodeHoldInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "HOLD_INVALID",
+		Message: "invalid legal hold",
+		Description: `Returned when a legal hold to place does not apply to
+		exactly one of a digest or a tag pattern, or names an invalid
+		repository, digest or pattern.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeRestoreUnknown is returned when the restore of a blob is
+	// unknown.
+	ErrorCodeRestoreUnknown = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "RESTORE_UNKNOWN",
+		Message: "restore unknown to registry",
+		Description: `Returned when the blob identified in the path is not
+		archived, and was not restored by this registry.`,
+		HTTPStatusCode: http.StatusNotFound,
+	})
+
+	// ErrorCodeRestoreInvalid is returned when the restore of a blob cannot
+	// be requested.
+	ErrorCodeRestoreInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "RESTORE_INVALID",
+		Message: "invalid restore",
+		Description: `Returned when the restore of a blob is requested with
+		an invalid digest, or for a blob which is not archived.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeTagOperationInvalid is returned when tag operations to merge
+	// are invalid.
+	ErrorCodeTagOperationInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TAG_OPERATION_INVALID",
+		Message: "invalid tag operation",
+		Description: `Returned when a tag operation to merge names an invalid
+		tag or digest, or has no timestamp or actor.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeBlobReferenced is returned when deleting a blob which a
+	// manifest of the repository references.
+	ErrorCodeBlobReferenced = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "BLOB_REFERENCED",
+		Message: "blob referenced by a manifest",
+		Description: `Returned when a blob cannot be deleted because a
+		manifest stored in the repository references it. The manifest must be
+		deleted first.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeManifestTagged is returned when deleting a manifest by digest
+	// which tags of the repository point to.
+	ErrorCodeManifestTagged = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "MANIFEST_TAGGED",
+		Message: "manifest is tagged",
+		Description: `Returned when deleting a manifest which tags of the
+		repository point to, while the registry protects tagged manifests
+		from deletion. The tags must be deleted first.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeArtifactInvalid is returned when an artifact pushed is of an
+	// unknown type, or its config fails the validation of its type.
+	ErrorCodeArtifactInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "ARTIFACT_INVALID",
+		Message: "artifact invalid",
+		Description: `Returned when the artifact type of a pushed manifest
+		is not registered with the registry, or when the config of the
+		artifact does not validate against the schema registered for its type.
+		The detail lists the artifact type and the validation errors, each with
+		the JSON pointer of the invalid value.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeStorageTimeout is returned when an operation of the storage
+	// backend does not complete within its configured timeout.
+	ErrorCodeStorageTimeout = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "STORAGE_TIMEOUT",
+		Message: "storage operation timed out",
+		Description: `Returned when the registry gave up waiting on its
+		storage backend, because an operation did not complete within the
+		timeout configured for it. The request may be retried; writes may
+		have been applied by the backend regardless.`,
+		HTTPStatusCode: http.StatusGatewayTimeout,
+	})
 )
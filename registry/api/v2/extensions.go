@@ -43,3 +43,40 @@ func ExtendRoute(ns, ext, component string, template RouteDescriptor, nameRequir
 
 	return desc, true
 }
+
+// ExtendVendorRoute adds the route of a vendor extension, whose path is made of
+// the vendor and the path of the route below it, such as
+// /v2/_ext/<vendor>/<path>, or /v2/<name>/_ext/<vendor>/<path> when
+// nameRequired is set.
+// Returns the route descriptor with Name and Path populated.
+// Returns true if the route is successfully extended, or false if route exists.
+func ExtendVendorRoute(vendor, path, description string, nameRequired bool) (RouteDescriptor, bool) {
+	name := RouteNameExtensionsRegistry
+	routePath := routeDescriptorsMap[RouteNameBase].Path
+	if nameRequired {
+		name = RouteNameExtensionsRepository
+		routePath += "{name:" + reference.NameRegexp.String() + "}/"
+	}
+
+	routePath += "_ext/" + vendor
+	if path != "" {
+		routePath += "/" + path
+	}
+
+	desc := RouteDescriptor{
+		Name:        fmt.Sprintf("%s-_ext-%s-%s", name, vendor, path),
+		Path:        routePath,
+		Entity:      "Extensions",
+		Description: description,
+	}
+
+	if _, exists := routeDescriptorsMap[desc.Name]; exists {
+		return desc, false
+	}
+
+	routeDescriptors = append(routeDescriptors, desc)
+	routeDescriptorsMap[desc.Name] = desc
+	APIDescriptor.RouteDescriptors = routeDescriptors
+
+	return desc, true
+}
@@ -8,10 +8,15 @@ const (
 	RouteNameBase                 = "base"
 	RouteNameManifest             = "manifest"
 	RouteNameTags                 = "tags"
+	RouteNameTagOperations        = "tag-operations"
 	RouteNameBlob                 = "blob"
 	RouteNameBlobUpload           = "blob-upload"
 	RouteNameBlobUploadChunk      = "blob-upload-chunk"
 	RouteNameCatalog              = "catalog"
+	RouteNameHolds                = "holds"
+	RouteNameHold                 = "hold"
+	RouteNameRestores             = "restores"
+	RouteNameRestore              = "restore"
 	RouteNameExtensionsRegistry   = "extensions-registry"
 	RouteNameExtensionsRepository = "extensions-repository"
 )
@@ -8,6 +8,7 @@ import (
 
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
 )
 
 // URLBuilder creates registry API urls from a single base endpoint. It can be
@@ -127,6 +128,57 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildHoldsURL constructs a url to list and place legal holds.
+func (ub *URLBuilder) BuildHoldsURL() (string, error) {
+	route := ub.cloneRoute(RouteNameHolds)
+
+	holdsURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return holdsURL.String(), nil
+}
+
+// BuildHoldURL constructs a url to get or release the legal hold identified
+// by id.
+func (ub *URLBuilder) BuildHoldURL(id string) (string, error) {
+	route := ub.cloneRoute(RouteNameHold)
+
+	holdURL, err := route.URL("id", id)
+	if err != nil {
+		return "", err
+	}
+
+	return holdURL.String(), nil
+}
+
+// BuildRestoresURL constructs a url to list and request the restores of
+// archived blobs.
+func (ub *URLBuilder) BuildRestoresURL() (string, error) {
+	route := ub.cloneRoute(RouteNameRestores)
+
+	restoresURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return restoresURL.String(), nil
+}
+
+// BuildRestoreURL constructs a url to get the status of the restore of the
+// archived blob dgst.
+func (ub *URLBuilder) BuildRestoreURL(dgst digest.Digest) (string, error) {
+	route := ub.cloneRoute(RouteNameRestore)
+
+	restoreURL, err := route.URL("digest", dgst.String())
+	if err != nil {
+		return "", err
+	}
+
+	return restoreURL.String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
@@ -139,6 +191,19 @@ func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (
 	return appendValuesURL(tagsURL, values...).String(), nil
 }
 
+// BuildTagOperationsURL constructs a url to list and merge the operations on
+// the tags of the named repository.
+func (ub *URLBuilder) BuildTagOperationsURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameTagOperations)
+
+	tagOperationsURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return tagOperationsURL.String(), nil
+}
+
 // BuildManifestURL constructs a url for the manifest identified by name and
 // reference. The argument reference may be either a tag or digest.
 func (ub *URLBuilder) BuildManifestURL(ref reference.Named) (string, error) {
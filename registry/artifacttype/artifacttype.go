@@ -0,0 +1,182 @@
+// Package artifacttype implements the registry of the artifact types known to
+// the registry. The config blob of an artifact pushed is validated against the
+// JSON schema registered for its type, and artifacts of unregistered types may
+// be rejected.
+//
+// Schemas are JSON schema documents, of which a subset is supported: the
+// assertion keywords type, enum, const, properties, patternProperties,
+// additionalProperties, required, minProperties, maxProperties, items,
+// minItems, maxItems, uniqueItems, minLength, maxLength, pattern, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, multipleOf, allOf, anyOf,
+// oneOf and not. References with $ref must point to the same document, such
+// as #/definitions/component. Other keywords, such as format, are ignored.
+package artifacttype
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/reference"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// defaultMaxConfigSize is the size of the largest config blob validated, when
+// not configured.
+const defaultMaxConfigSize = 16 << 20
+
+type artifactType struct {
+	schema        *schema
+	maxConfigSize int64
+}
+
+// Registry holds the artifact types known to the registry.
+type Registry struct {
+	restrict bool
+	types    map[string]artifactType
+}
+
+// New creates the registry of the configured artifact types, compiling their
+// schemas.
+func New(config configuration.ArtifactTypes) (*Registry, error) {
+	r := &Registry{
+		restrict: config.Restrict,
+		types:    make(map[string]artifactType, len(config.Types)),
+	}
+	for _, t := range config.Types {
+		if t.Type == "" {
+			return nil, fmt.Errorf("artifact type without a type")
+		}
+		if _, ok := r.types[t.Type]; ok {
+			return nil, fmt.Errorf("duplicate artifact type %s", t.Type)
+		}
+		if t.MaxConfigSize < 0 {
+			return nil, fmt.Errorf("artifact type %s: maxconfigsize must not be negative", t.Type)
+		}
+
+		at := artifactType{maxConfigSize: t.MaxConfigSize}
+		if at.maxConfigSize == 0 {
+			at.maxConfigSize = defaultMaxConfigSize
+		}
+		if t.ConfigSchema != "" {
+			document, err := os.ReadFile(t.ConfigSchema)
+			if err != nil {
+				return nil, fmt.Errorf("artifact type %s: %v", t.Type, err)
+			}
+			if at.schema, err = compileSchema(document); err != nil {
+				return nil, fmt.Errorf("artifact type %s: schema %s: %v", t.Type, t.ConfigSchema, err)
+			}
+		}
+		r.types[t.Type] = at
+	}
+	return r, nil
+}
+
+// manifestFields are the fields of manifest payloads identifying artifacts,
+// shared by the OCI image manifest and index formats.
+type manifestFields struct {
+	ArtifactType string                   `json:"artifactType"`
+	Config       *distribution.Descriptor `json:"config"`
+}
+
+// artifactOf returns the artifact type of a manifest and the descriptor of
+// its config, if any. The artifact type is empty for images, whose config is
+// an image config.
+func artifactOf(manifest distribution.Manifest) (string, *distribution.Descriptor, error) {
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return "", nil, err
+	}
+	var fields manifestFields
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return "", nil, err
+	}
+
+	if fields.ArtifactType != "" {
+		return fields.ArtifactType, fields.Config, nil
+	}
+	if fields.Config == nil {
+		return "", nil, nil
+	}
+	switch fields.Config.MediaType {
+	case v1.MediaTypeImageConfig, schema2.MediaTypeImageConfig, "":
+		return "", nil, nil
+	}
+	return fields.Config.MediaType, fields.Config, nil
+}
+
+// ValidationDetail is the detail of the errors of invalid artifacts.
+type ValidationDetail struct {
+	ArtifactType string            `json:"artifactType"`
+	Errors       []ValidationError `json:"errors,omitempty"`
+}
+
+// Validate validates a manifest pushed to repository, returning a
+// v2.ErrorCodeArtifactInvalid error when it is an artifact of an unregistered
+// type and types are restricted, or when its config does not validate against
+// the schema of its type. Configs which are not in the repository are left to
+// the validation of the manifest.
+func (r *Registry) Validate(ctx context.Context, repository distribution.Repository, manifest distribution.Manifest) error {
+	name, config, err := artifactOf(manifest)
+	if err != nil || name == "" {
+		// Malformed manifests are rejected by the manifest store.
+		return nil
+	}
+
+	t, ok := r.types[name]
+	if !ok {
+		if r.restrict {
+			return v2.ErrorCodeArtifactInvalid.WithMessage(fmt.Sprintf("artifact type %s is not registered", name)).
+				WithDetail(ValidationDetail{ArtifactType: name})
+		}
+		return nil
+	}
+	if t.schema == nil || config == nil {
+		return nil
+	}
+
+	blobs := repository.Blobs(ctx)
+	desc, err := blobs.Stat(ctx, config.Digest)
+	if err == distribution.ErrBlobUnknown {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if desc.Size > t.maxConfigSize {
+		return v2.ErrorCodeArtifactInvalid.WithMessage(fmt.Sprintf("config of artifact type %s is larger than %d bytes", name, t.maxConfigSize)).
+			WithDetail(ValidationDetail{ArtifactType: name})
+	}
+
+	content, err := blobs.Get(ctx, config.Digest)
+	if err != nil {
+		return err
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return v2.ErrorCodeArtifactInvalid.WithMessage(fmt.Sprintf("config of artifact type %s is not valid JSON", name)).
+			WithDetail(ValidationDetail{ArtifactType: name, Errors: []ValidationError{{Message: err.Error()}}})
+	}
+	if errs := t.schema.validate(document); len(errs) > 0 {
+		dcontext.GetLogger(ctx).Infof("config of artifact %s pushed to %s does not validate: %v", name, repository.Named().Name(), errs[0])
+		return v2.ErrorCodeArtifactInvalid.WithMessage(fmt.Sprintf("config does not validate against the schema of artifact type %s", name)).
+			WithDetail(ValidationDetail{ArtifactType: name, Errors: errs})
+	}
+	return nil
+}
+
+// Repository returns the repository with the manifests pushed validated.
+func (r *Registry) Repository(repository distribution.Repository) distribution.Repository {
+	return repositorymiddleware.WithHooks(repository, repositorymiddleware.Hooks{
+		PushManifest: func(ctx context.Context, _ reference.Named, _ string, manifest distribution.Manifest) (distribution.Manifest, error) {
+			return manifest, r.Validate(ctx, repository, manifest)
+		},
+	})
+}
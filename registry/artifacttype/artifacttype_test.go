@@ -0,0 +1,251 @@
+package artifacttype
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const sbomSchema = `{
+	"type": "object",
+	"required": ["spdxVersion", "name", "packages"],
+	"properties": {
+		"spdxVersion": {"type": "string", "pattern": "^SPDX-[0-9]+\\.[0-9]+$"},
+		"name": {"type": "string", "minLength": 1},
+		"packages": {"type": "array", "items": {"$ref": "#/definitions/package"}}
+	},
+	"definitions": {
+		"package": {
+			"type": "object",
+			"required": ["name"],
+			"properties": {
+				"name": {"type": "string"},
+				"versionInfo": {"type": "string"},
+				"filesAnalyzed": {"type": "boolean"}
+			},
+			"additionalProperties": false
+		}
+	}
+}`
+
+func TestSchema(t *testing.T) {
+	for _, tc := range []struct {
+		schema   string
+		document string
+		errors   []string
+	}{
+		{schema: sbomSchema, document: `{"spdxVersion": "SPDX-2.3", "name": "app", "packages": [{"name": "libc", "versionInfo": "2.36"}]}`},
+		{
+			schema:   sbomSchema,
+			document: `{"spdxVersion": "2.3", "packages": [{"name": 1}, {"name": "zlib", "license": "MIT"}]}`,
+			errors: []string{
+				`/: missing required property "name"`,
+				"/packages/0/name: expected string, got number",
+				"/packages/1/license: additional property not allowed",
+				"/spdxVersion: string does not match pattern ^SPDX-[0-9]+\\.[0-9]+$",
+			},
+		},
+		{schema: sbomSchema, document: `[]`, errors: []string{"/: expected object, got array"}},
+		{schema: `{"type": "integer", "minimum": 1, "exclusiveMaximum": 10}`, document: `10`, errors: []string{"/: number not less than 10"}},
+		{schema: `{"type": "integer"}`, document: `1.5`, errors: []string{"/: expected integer, got number"}},
+		{schema: `{"enum": ["a", 1, null]}`, document: `null`},
+		{schema: `{"const": {"a": [1]}}`, document: `{"a": [2]}`, errors: []string{"/: value is not the allowed value"}},
+		{schema: `{"type": ["string", "null"], "maxLength": 2}`, document: `"abc"`, errors: []string{"/: string longer than 2 characters"}},
+		{schema: `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, document: `true`, errors: []string{"/: value does not match any of the schemas of anyOf"}},
+		{schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, document: `1`, errors: []string{"/: value matches 2 of the schemas of oneOf instead of exactly one"}},
+		{schema: `{"not": {"type": "string"}}`, document: `"a"`, errors: []string{"/: value matches the schema of not"}},
+		{schema: `{"allOf": [{"minItems": 1}, {"uniqueItems": true}]}`, document: `[1, 1]`, errors: []string{"/: items 0 and 1 are equal"}},
+		{schema: `{"patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": false}`, document: `{"x-a/b": 1}`, errors: []string{"/x-a~1b: expected string, got number"}},
+		{schema: `{"properties": {"next": {"$ref": "#"}}, "required": ["value"]}`, document: `{"value": 1, "next": {"value": 2, "next": {}}}`, errors: []string{`/next/next: missing required property "value"`}},
+		{schema: `{"format": "uri", "title": "ignored"}`, document: `"not a uri"`},
+		{schema: `true`, document: `{}`},
+		{schema: `false`, document: `{}`, errors: []string{"/: no value is allowed"}},
+	} {
+		s, err := compileSchema([]byte(tc.schema))
+		if err != nil {
+			t.Errorf("unexpected error compiling %s: %v", tc.schema, err)
+			continue
+		}
+		var document interface{}
+		if err := json.Unmarshal([]byte(tc.document), &document); err != nil {
+			t.Fatal(err)
+		}
+
+		var errors []string
+		for _, err := range s.validate(document) {
+			errors = append(errors, err.Error())
+		}
+		if strings.Join(errors, "\n") != strings.Join(tc.errors, "\n") {
+			t.Errorf("unexpected errors validating %s against %s:\n%s", tc.document, tc.schema, strings.Join(errors, "\n"))
+		}
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	for _, schema := range []string{
+		`{`,
+		`1`,
+		`{"type": "text"}`,
+		`{"minLength": -1}`,
+		`{"pattern": "("}`,
+		`{"anyOf": []}`,
+		`{"$ref": "https://example.com/schema.json"}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"properties": {"a": {"type": 1}}}`,
+	} {
+		if _, err := compileSchema([]byte(schema)); err == nil {
+			t.Errorf("expected an error compiling %s", schema)
+		}
+	}
+}
+
+func writeSchema(t *testing.T, schema string) string {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(schema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNew(t *testing.T) {
+	schemaPath := writeSchema(t, `{"type": 1}`)
+
+	for _, types := range [][]configuration.ArtifactType{
+		{{ConfigSchema: "schema.json"}},
+		{{Type: "application/spdx+json"}, {Type: "application/spdx+json"}},
+		{{Type: "application/spdx+json", MaxConfigSize: -1}},
+		{{Type: "application/spdx+json", ConfigSchema: filepath.Join(t.TempDir(), "missing.json")}},
+		{{Type: "application/spdx+json", ConfigSchema: schemaPath}},
+	} {
+		if _, err := New(configuration.ArtifactTypes{Types: types}); err == nil {
+			t.Errorf("expected an error creating artifact types %v", types)
+		}
+	}
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	schemaPath := writeSchema(t, sbomSchema)
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(mediaType, config string) distribution.Manifest {
+		desc, err := repo.Blobs(ctx).Put(ctx, mediaType, []byte(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc.MediaType = mediaType
+		return testManifest(t, desc)
+	}
+
+	for _, tc := range []struct {
+		restrict  bool
+		mediaType string
+		config    string
+		errors    int
+		invalid   bool
+	}{
+		{mediaType: "application/spdx+json", config: `{"spdxVersion": "SPDX-2.3", "name": "app", "packages": []}`},
+		{mediaType: "application/spdx+json", config: `{"spdxVersion": "SPDX-2.3", "packages": [{}]}`, invalid: true, errors: 2},
+		{mediaType: "application/spdx+json", config: `{"spdxVersion"`, invalid: true, errors: 1},
+		{mediaType: "application/spdx+json", config: strings.Repeat(" ", 1025) + "{}", invalid: true},
+		{mediaType: "application/vnd.cyclonedx+json", config: `{}`},
+		{restrict: true, mediaType: "application/vnd.cyclonedx+json", config: `{}`, invalid: true},
+		{restrict: true, mediaType: v1.MediaTypeImageConfig, config: `{}`},
+	} {
+		r, err := New(configuration.ArtifactTypes{
+			Restrict: tc.restrict,
+			Types: []configuration.ArtifactType{
+				{Type: "application/spdx+json", ConfigSchema: schemaPath, MaxConfigSize: 1024},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		manifests, err := r.Repository(repo).Manifests(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = manifests.Put(ctx, put(tc.mediaType, tc.config))
+		if !tc.invalid {
+			if err != nil {
+				t.Errorf("unexpected error pushing %s config %s: %v", tc.mediaType, tc.config, err)
+			}
+			continue
+		}
+
+		e, ok := err.(errcode.Error)
+		if !ok || e.Code != v2.ErrorCodeArtifactInvalid {
+			t.Errorf("expected pushing %s config %s to fail as invalid, got %v", tc.mediaType, tc.config, err)
+			continue
+		}
+		detail := e.Detail.(ValidationDetail)
+		if detail.ArtifactType != tc.mediaType || len(detail.Errors) != tc.errors {
+			t.Errorf("unexpected detail pushing %s config %s: %v", tc.mediaType, tc.config, detail)
+		}
+	}
+}
+
+func testManifest(t *testing.T, config distribution.Descriptor) distribution.Manifest {
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: config,
+		Layers: []distribution.Descriptor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// An unknown config is reported by the manifest store rather than validated.
+func TestUnknownConfig(t *testing.T) {
+	ctx := context.Background()
+	r, err := New(configuration.ArtifactTypes{Types: []configuration.ArtifactType{
+		{Type: "application/spdx+json", ConfigSchema: writeSchema(t, sbomSchema)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := testManifest(t, distribution.Descriptor{MediaType: "application/spdx+json", Digest: digest.FromString("config"), Size: 6})
+	if err := r.Validate(ctx, repo, m); err != nil {
+		t.Fatalf("unexpected error validating manifest with an unknown config: %v", err)
+	}
+}
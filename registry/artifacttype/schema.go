@@ -0,0 +1,574 @@
+package artifacttype
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrors is the number of validation errors reported for a document.
+const maxErrors = 20
+
+// ValidationError is a value of a document which does not validate against a
+// schema.
+type ValidationError struct {
+	// Path is the JSON pointer of the invalid value in the document.
+	Path string `json:"path"`
+	// Message describes why the value is invalid.
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.pathOrRoot(), e.Message)
+}
+
+func (e ValidationError) pathOrRoot() string {
+	if e.Path == "" {
+		return "/"
+	}
+	return e.Path
+}
+
+// schema is a compiled JSON schema, of the subset of keywords described in
+// the package documentation.
+type schema struct {
+	// always is the result of the true and false schemas.
+	always *bool
+
+	types    []string
+	enum     []interface{}
+	constant interface{}
+	hasConst bool
+
+	properties           map[string]*schema
+	patternProperties    map[*regexp.Regexp]*schema
+	additionalProperties *schema
+	required             []string
+	minProperties        *int
+	maxProperties        *int
+
+	items       *schema
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	allOf []*schema
+	anyOf []*schema
+	oneOf []*schema
+	not   *schema
+	ref   *schema
+}
+
+// compileSchema compiles a JSON schema document.
+func compileSchema(document []byte) (*schema, error) {
+	var root interface{}
+	if err := json.Unmarshal(document, &root); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	c := &compiler{root: root, compiled: make(map[string]*schema)}
+	return c.compile("#", root)
+}
+
+type compiler struct {
+	root interface{}
+	// compiled holds the schemas compiled by JSON pointer, so that
+	// recursive references are compiled once.
+	compiled map[string]*schema
+}
+
+func (c *compiler) compile(ptr string, v interface{}) (*schema, error) {
+	if s, ok := c.compiled[ptr]; ok {
+		return s, nil
+	}
+
+	s := &schema{}
+	c.compiled[ptr] = s
+
+	switch v := v.(type) {
+	case bool:
+		s.always = &v
+		return s, nil
+	case map[string]interface{}:
+		if err := c.fill(s, ptr, v); err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", ptr)
+	}
+}
+
+func (c *compiler) fill(s *schema, ptr string, m map[string]interface{}) error {
+	var err error
+	sub := func(key string) (*schema, error) {
+		return c.compile(ptr+"/"+escapePointer(key), m[key])
+	}
+	subs := func(key string) ([]*schema, error) {
+		list, ok := m[key].([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s/%s: must be a non-empty array", ptr, key)
+		}
+		schemas := make([]*schema, len(list))
+		for i, v := range list {
+			if schemas[i], err = c.compile(fmt.Sprintf("%s/%s/%d", ptr, key, i), v); err != nil {
+				return nil, err
+			}
+		}
+		return schemas, nil
+	}
+
+	if ref, ok := m["$ref"]; ok {
+		target, ok := ref.(string)
+		if !ok || !strings.HasPrefix(target, "#") {
+			return fmt.Errorf("%s/$ref: only references to the same document are supported, got %v", ptr, ref)
+		}
+		node, err := resolvePointer(c.root, strings.TrimPrefix(target, "#"))
+		if err != nil {
+			return fmt.Errorf("%s/$ref: %v", ptr, err)
+		}
+		if s.ref, err = c.compile(target, node); err != nil {
+			return err
+		}
+	}
+
+	if t, ok := m["type"]; ok {
+		switch t := t.(type) {
+		case string:
+			s.types = []string{t}
+		case []interface{}:
+			for _, t := range t {
+				name, ok := t.(string)
+				if !ok {
+					return fmt.Errorf("%s/type: must be a string or an array of strings", ptr)
+				}
+				s.types = append(s.types, name)
+			}
+		default:
+			return fmt.Errorf("%s/type: must be a string or an array of strings", ptr)
+		}
+		for _, name := range s.types {
+			switch name {
+			case "null", "boolean", "object", "array", "number", "string", "integer":
+			default:
+				return fmt.Errorf("%s/type: unknown type %q", ptr, name)
+			}
+		}
+	}
+	if enum, ok := m["enum"]; ok {
+		if s.enum, ok = enum.([]interface{}); !ok {
+			return fmt.Errorf("%s/enum: must be an array", ptr)
+		}
+	}
+	if constant, ok := m["const"]; ok {
+		s.constant, s.hasConst = constant, true
+	}
+
+	if props, ok := m["properties"]; ok {
+		props, ok := props.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s/properties: must be an object", ptr)
+		}
+		s.properties = make(map[string]*schema, len(props))
+		for name, v := range props {
+			if s.properties[name], err = c.compile(ptr+"/properties/"+escapePointer(name), v); err != nil {
+				return err
+			}
+		}
+	}
+	if props, ok := m["patternProperties"]; ok {
+		props, ok := props.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s/patternProperties: must be an object", ptr)
+		}
+		s.patternProperties = make(map[*regexp.Regexp]*schema, len(props))
+		for pattern, v := range props {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%s/patternProperties: %v", ptr, err)
+			}
+			if s.patternProperties[re], err = c.compile(ptr+"/patternProperties/"+escapePointer(pattern), v); err != nil {
+				return err
+			}
+		}
+	}
+	if _, ok := m["additionalProperties"]; ok {
+		if s.additionalProperties, err = sub("additionalProperties"); err != nil {
+			return err
+		}
+	}
+	if required, ok := m["required"]; ok {
+		list, ok := required.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s/required: must be an array of strings", ptr)
+		}
+		for _, name := range list {
+			name, ok := name.(string)
+			if !ok {
+				return fmt.Errorf("%s/required: must be an array of strings", ptr)
+			}
+			s.required = append(s.required, name)
+		}
+	}
+
+	if _, ok := m["items"]; ok {
+		if s.items, err = sub("items"); err != nil {
+			return err
+		}
+	}
+	if unique, ok := m["uniqueItems"]; ok {
+		if s.uniqueItems, ok = unique.(bool); !ok {
+			return fmt.Errorf("%s/uniqueItems: must be a boolean", ptr)
+		}
+	}
+
+	for key, field := range map[string]**int{
+		"minProperties": &s.minProperties,
+		"maxProperties": &s.maxProperties,
+		"minItems":      &s.minItems,
+		"maxItems":      &s.maxItems,
+		"minLength":     &s.minLength,
+		"maxLength":     &s.maxLength,
+	} {
+		if v, ok := m[key]; ok {
+			n, ok := v.(float64)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return fmt.Errorf("%s/%s: must be a non-negative integer", ptr, key)
+			}
+			i := int(n)
+			*field = &i
+		}
+	}
+	for key, field := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf":       &s.multipleOf,
+	} {
+		if v, ok := m[key]; ok {
+			n, ok := v.(float64)
+			if !ok {
+				return fmt.Errorf("%s/%s: must be a number", ptr, key)
+			}
+			*field = &n
+		}
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return fmt.Errorf("%s/multipleOf: must be positive", ptr)
+	}
+
+	if pattern, ok := m["pattern"]; ok {
+		p, ok := pattern.(string)
+		if !ok {
+			return fmt.Errorf("%s/pattern: must be a string", ptr)
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return fmt.Errorf("%s/pattern: %v", ptr, err)
+		}
+	}
+
+	if _, ok := m["allOf"]; ok {
+		if s.allOf, err = subs("allOf"); err != nil {
+			return err
+		}
+	}
+	if _, ok := m["anyOf"]; ok {
+		if s.anyOf, err = subs("anyOf"); err != nil {
+			return err
+		}
+	}
+	if _, ok := m["oneOf"]; ok {
+		if s.oneOf, err = subs("oneOf"); err != nil {
+			return err
+		}
+	}
+	if _, ok := m["not"]; ok {
+		if s.not, err = sub("not"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolvePointer returns the value of the JSON pointer ptr in root.
+func resolvePointer(root interface{}, ptr string) (interface{}, error) {
+	if ptr == "" {
+		return root, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", ptr)
+	}
+
+	v := root
+	for _, token := range strings.Split(ptr[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[token]; !ok {
+				return nil, fmt.Errorf("JSON pointer %q not found", ptr)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("JSON pointer %q not found", ptr)
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("JSON pointer %q not found", ptr)
+		}
+	}
+	return v, nil
+}
+
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// validate returns the errors of the document v against the schema, up to
+// maxErrors.
+func (s *schema) validate(v interface{}) []ValidationError {
+	var errs []ValidationError
+	s.check(v, "", &errs)
+	if len(errs) > maxErrors {
+		errs = errs[:maxErrors]
+	}
+	return errs
+}
+
+// valid reports whether v validates against the schema.
+func (s *schema) valid(v interface{}) bool {
+	var errs []ValidationError
+	s.check(v, "", &errs)
+	return len(errs) == 0
+}
+
+func (s *schema) check(v interface{}, path string, errs *[]ValidationError) {
+	if len(*errs) > maxErrors {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			fail("no value is allowed")
+		}
+		return
+	}
+	if s.ref != nil {
+		s.ref.check(v, path, errs)
+	}
+
+	if len(s.types) > 0 {
+		var matched bool
+		for _, t := range s.types {
+			if hasType(v, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+			return
+		}
+	}
+	if s.enum != nil {
+		var matched bool
+		for _, e := range s.enum {
+			if reflect.DeepEqual(v, e) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("value is not one of the allowed values")
+		}
+	}
+	if s.hasConst && !reflect.DeepEqual(v, s.constant) {
+		fail("value is not the allowed value")
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		s.checkObject(v, path, errs)
+	case []interface{}:
+		s.checkArray(v, path, errs)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			fail("string shorter than %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("string longer than %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("string does not match pattern %s", s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("number less than %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("number greater than %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			fail("number not greater than %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			fail("number not less than %v", *s.exclusiveMaximum)
+		}
+		if s.multipleOf != nil {
+			if q := v / *s.multipleOf; q != math.Trunc(q) {
+				fail("number not a multiple of %v", *s.multipleOf)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.check(v, path, errs)
+	}
+	if s.anyOf != nil {
+		var matched bool
+		for _, sub := range s.anyOf {
+			if sub.valid(v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("value does not match any of the schemas of anyOf")
+		}
+	}
+	if s.oneOf != nil {
+		var matched int
+		for _, sub := range s.oneOf {
+			if sub.valid(v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("value matches %d of the schemas of oneOf instead of exactly one", matched)
+		}
+	}
+	if s.not != nil && s.not.valid(v) {
+		fail("value matches the schema of not")
+	}
+}
+
+func (s *schema) checkObject(v map[string]interface{}, path string, errs *[]ValidationError) {
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("missing required property %q", name)})
+		}
+	}
+	if s.minProperties != nil && len(v) < *s.minProperties {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("fewer than %d properties", *s.minProperties)})
+	}
+	if s.maxProperties != nil && len(v) > *s.maxProperties {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("more than %d properties", *s.maxProperties)})
+	}
+
+	// Properties are checked in order, so that errors are reported in a
+	// stable order.
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		propPath := path + "/" + escapePointer(name)
+		matched := false
+		if sub, ok := s.properties[name]; ok {
+			sub.check(v[name], propPath, errs)
+			matched = true
+		}
+		for re, sub := range s.patternProperties {
+			if re.MatchString(name) {
+				sub.check(v[name], propPath, errs)
+				matched = true
+			}
+		}
+		if !matched && s.additionalProperties != nil {
+			if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+				*errs = append(*errs, ValidationError{Path: propPath, Message: "additional property not allowed"})
+				continue
+			}
+			s.additionalProperties.check(v[name], propPath, errs)
+		}
+	}
+}
+
+func (s *schema) checkArray(v []interface{}, path string, errs *[]ValidationError) {
+	if s.minItems != nil && len(v) < *s.minItems {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("fewer than %d items", *s.minItems)})
+	}
+	if s.maxItems != nil && len(v) > *s.maxItems {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("more than %d items", *s.maxItems)})
+	}
+	if s.uniqueItems {
+		for i := range v {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(v[i], v[j]) {
+					*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("items %d and %d are equal", j, i)})
+				}
+			}
+		}
+	}
+	if s.items != nil {
+		for i, item := range v {
+			s.items.check(item, fmt.Sprintf("%s/%d", path, i), errs)
+		}
+	}
+}
+
+func hasType(v interface{}, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	default:
+		return typeOf(v) == t
+	}
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -37,16 +37,18 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+
+	dcontext "github.com/distribution/distribution/v3/context"
 )
 
 const (
 	// UserKey is used to get the user object from
 	// a user context
-	UserKey = "auth.user"
+	UserKey = dcontext.UserKey
 
 	// UserNameKey is used to get the user name from
 	// a user context
-	UserNameKey = "auth.user.name"
+	UserNameKey = dcontext.UserNameKey
 )
 
 var (
@@ -55,6 +57,10 @@ var (
 
 	// ErrAuthenticationFailure returned when authentication fails.
 	ErrAuthenticationFailure = errors.New("authentication failure")
+
+	// ErrInsufficientScope is wrapped by the challenges of access
+	// controllers denying authenticated requests the access they require.
+	ErrInsufficientScope = errors.New("insufficient scope")
 )
 
 // UserInfo carries information about
@@ -11,10 +11,12 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/docker/libtrust"
+	log "github.com/sirupsen/logrus"
 )
 
 // accessSet maps a typed, named resource to
@@ -70,7 +72,7 @@ func (s accessSet) scopeParam() string {
 
 // Errors used and exported by this package.
 var (
-	ErrInsufficientScope = errors.New("insufficient scope")
+	ErrInsufficientScope = auth.ErrInsufficientScope
 	ErrTokenRequired     = errors.New("authorization token required")
 )
 
@@ -90,6 +92,11 @@ func (ac authChallenge) Error() string {
 	return ac.err.Error()
 }
 
+// Unwrap returns the error the challenge was issued for.
+func (ac authChallenge) Unwrap() error {
+	return ac.err
+}
+
 // Status returns the HTTP Response Status Code for this authChallenge.
 func (ac authChallenge) Status() int {
 	return http.StatusUnauthorized
@@ -129,10 +136,26 @@ func (ac authChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
 type accessController struct {
 	realm        string
 	autoRedirect bool
-	issuer       string
 	service      string
-	rootCerts    *x509.CertPool
-	trustedKeys  map[string]libtrust.PublicKey
+	audiences    []string
+	issuerNames  []string
+	issuers      map[string]*tokenIssuer
+}
+
+// tokenIssuer holds the keys trusted to sign the tokens of a single issuer.
+// Tokens are only verified against the keys of the issuer they name.
+type tokenIssuer struct {
+	rootCerts   *x509.CertPool
+	trustedKeys map[string]libtrust.PublicKey
+	keys        KeyResolver
+}
+
+// tokenIssuerOptions describes an additional trusted token issuer.
+type tokenIssuerOptions struct {
+	name            string
+	jwksURL         string
+	rootCertBundle  string
+	refreshInterval time.Duration
 }
 
 // tokenAccessOptions is a convenience type for handling
@@ -143,6 +166,8 @@ type tokenAccessOptions struct {
 	issuer         string
 	service        string
 	rootCertBundle string
+	audiences      []string
+	issuers        []tokenIssuerOptions
 }
 
 // checkOptions gathers the necessary options
@@ -150,7 +175,7 @@ type tokenAccessOptions struct {
 func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 	var opts tokenAccessOptions
 
-	keys := []string{"realm", "issuer", "service", "rootcertbundle"}
+	keys := []string{"realm", "service"}
 	vals := make([]string, 0, len(keys))
 	for _, key := range keys {
 		val, ok := options[key].(string)
@@ -160,7 +185,7 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		vals = append(vals, val)
 	}
 
-	opts.realm, opts.issuer, opts.service, opts.rootCertBundle = vals[0], vals[1], vals[2], vals[3]
+	opts.realm, opts.service = vals[0], vals[1]
 
 	autoRedirectVal, ok := options["autoredirect"]
 	if ok {
@@ -171,6 +196,104 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		opts.autoRedirect = autoRedirect
 	}
 
+	if audiences, ok := options["audiences"]; ok {
+		list, ok := stringList(audiences)
+		if !ok {
+			return opts, fmt.Errorf("token auth requires a valid option list of strings: audiences")
+		}
+		opts.audiences = list
+	}
+
+	if issuers, ok := options["issuers"]; ok {
+		list, ok := issuers.([]interface{})
+		if !ok {
+			return opts, fmt.Errorf("token auth requires a valid option list: issuers")
+		}
+		for _, item := range list {
+			issuer, err := checkIssuerOptions(item)
+			if err != nil {
+				return opts, err
+			}
+			opts.issuers = append(opts.issuers, issuer)
+		}
+	}
+
+	// A single static issuer remains required unless issuers are listed.
+	if val, ok := options["issuer"]; ok || len(opts.issuers) == 0 {
+		issuer, ok := val.(string)
+		if !ok {
+			return opts, fmt.Errorf("token auth requires a valid option string: %q", "issuer")
+		}
+		opts.issuer = issuer
+	}
+
+	// The root certificate bundle is only optional when every issuer is
+	// configured with keys of its own.
+	needsRootCertBundle := opts.issuer != ""
+	for _, issuer := range opts.issuers {
+		needsRootCertBundle = needsRootCertBundle || !issuer.hasKeys()
+	}
+	if val, ok := options["rootcertbundle"]; ok || needsRootCertBundle {
+		rootCertBundle, ok := val.(string)
+		if !ok {
+			return opts, fmt.Errorf("token auth requires a valid option string: %q", "rootcertbundle")
+		}
+		opts.rootCertBundle = rootCertBundle
+	}
+
+	return opts, nil
+}
+
+// hasKeys reports whether the issuer is configured with signing keys of its
+// own, rather than those of the root certificate bundle.
+func (opts tokenIssuerOptions) hasKeys() bool {
+	return opts.jwksURL != "" || opts.rootCertBundle != ""
+}
+
+// checkIssuerOptions parses a single entry of the issuers option, which is
+// either the name of the issuer or a map with the name of the issuer and
+// the location of its JWKS or root certificate bundle.
+func checkIssuerOptions(item interface{}) (tokenIssuerOptions, error) {
+	var opts tokenIssuerOptions
+
+	if name, ok := item.(string); ok {
+		opts.name = name
+		return opts, nil
+	}
+
+	params, ok := stringMap(item)
+	if !ok {
+		return opts, fmt.Errorf("token auth issuers must be strings or maps, got %T", item)
+	}
+
+	if opts.name, ok = params["name"].(string); !ok || opts.name == "" {
+		return opts, fmt.Errorf("token auth issuer requires a valid option string: %q", "name")
+	}
+
+	if val, ok := params["jwks"]; ok {
+		if opts.jwksURL, ok = val.(string); !ok {
+			return opts, fmt.Errorf("token auth issuer %q requires a valid option string: %q", opts.name, "jwks")
+		}
+	}
+
+	if val, ok := params["rootcertbundle"]; ok {
+		if opts.rootCertBundle, ok = val.(string); !ok {
+			return opts, fmt.Errorf("token auth issuer %q requires a valid option string: %q", opts.name, "rootcertbundle")
+		}
+	}
+
+	if val, ok := params["refreshinterval"]; ok {
+		str, ok := val.(string)
+		if !ok {
+			return opts, fmt.Errorf("token auth issuer %q requires a valid option duration: %q", opts.name, "refreshinterval")
+		}
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return opts, fmt.Errorf("token auth issuer %q has an invalid refreshinterval: %v", opts.name, err)
+		}
+		opts.refreshInterval = d
+	}
+
 	return opts, nil
 }
 
@@ -181,15 +304,91 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		return nil, err
 	}
 
-	fp, err := os.Open(config.rootCertBundle)
+	// The root certificate bundle is trusted for the issuer option and for
+	// the issuers which are not configured with keys of their own.
+	var bundleIssuer *tokenIssuer
+	if config.rootCertBundle != "" {
+		if bundleIssuer, err = newRootCertIssuer(config.rootCertBundle); err != nil {
+			return nil, err
+		}
+	}
+
+	var issuerNames []string
+	issuers := make(map[string]*tokenIssuer)
+	if config.issuer != "" {
+		issuerNames = append(issuerNames, config.issuer)
+		issuers[config.issuer] = bundleIssuer
+	}
+	for _, opts := range config.issuers {
+		if _, exists := issuers[opts.name]; exists {
+			return nil, fmt.Errorf("token auth issuer %q is configured more than once", opts.name)
+		}
+
+		issuer := bundleIssuer
+		if opts.hasKeys() {
+			issuer = &tokenIssuer{
+				rootCerts:   x509.NewCertPool(),
+				trustedKeys: make(map[string]libtrust.PublicKey),
+			}
+			if opts.rootCertBundle != "" {
+				if issuer, err = newRootCertIssuer(opts.rootCertBundle); err != nil {
+					return nil, err
+				}
+			}
+			if opts.jwksURL != "" {
+				issuer.keys = newJWKSKeyResolver(opts.jwksURL, opts.refreshInterval)
+			}
+		}
+
+		issuerNames = append(issuerNames, opts.name)
+		issuers[opts.name] = issuer
+	}
+
+	return &accessController{
+		realm:        config.realm,
+		autoRedirect: config.autoRedirect,
+		service:      config.service,
+		audiences:    append([]string{config.service}, config.audiences...),
+		issuerNames:  issuerNames,
+		issuers:      issuers,
+	}, nil
+}
+
+// newRootCertIssuer returns a tokenIssuer trusting the certificates of the
+// given root certificate bundle.
+func newRootCertIssuer(rootCertBundle string) (*tokenIssuer, error) {
+	rootCerts, err := loadRootCerts(rootCertBundle)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := &tokenIssuer{
+		rootCerts:   x509.NewCertPool(),
+		trustedKeys: make(map[string]libtrust.PublicKey),
+	}
+	for _, rootCert := range rootCerts {
+		issuer.rootCerts.AddCert(rootCert)
+		pubKey, err := libtrust.FromCryptoPublicKey(crypto.PublicKey(rootCert.PublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("unable to get public key from token auth root certificate: %s", err)
+		}
+		issuer.trustedKeys[pubKey.KeyID()] = pubKey
+	}
+
+	return issuer, nil
+}
+
+// loadRootCerts reads the certificates from the root certificate bundle.
+func loadRootCerts(rootCertBundle string) ([]*x509.Certificate, error) {
+	fp, err := os.Open(rootCertBundle)
 	if err != nil {
-		return nil, fmt.Errorf("unable to open token auth root certificate bundle file %q: %s", config.rootCertBundle, err)
+		return nil, fmt.Errorf("unable to open token auth root certificate bundle file %q: %s", rootCertBundle, err)
 	}
 	defer fp.Close()
 
 	rawCertBundle, err := ioutil.ReadAll(fp)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", config.rootCertBundle, err)
+		return nil, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", rootCertBundle, err)
 	}
 
 	var rootCerts []*x509.Certificate
@@ -211,25 +410,7 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		return nil, errors.New("token auth requires at least one token signing root certificate")
 	}
 
-	rootPool := x509.NewCertPool()
-	trustedKeys := make(map[string]libtrust.PublicKey, len(rootCerts))
-	for _, rootCert := range rootCerts {
-		rootPool.AddCert(rootCert)
-		pubKey, err := libtrust.FromCryptoPublicKey(crypto.PublicKey(rootCert.PublicKey))
-		if err != nil {
-			return nil, fmt.Errorf("unable to get public key from token auth root certificate: %s", err)
-		}
-		trustedKeys[pubKey.KeyID()] = pubKey
-	}
-
-	return &accessController{
-		realm:        config.realm,
-		autoRedirect: config.autoRedirect,
-		issuer:       config.issuer,
-		service:      config.service,
-		rootCerts:    rootPool,
-		trustedKeys:  trustedKeys,
-	}, nil
+	return rootCerts, nil
 }
 
 // Authorized handles checking whether the given request is authorized
@@ -262,11 +443,23 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 		return nil, challenge
 	}
 
+	// Only the keys configured for the issuer named by the token may have
+	// signed it.
+	issuer, ok := ac.issuers[token.Claims.Issuer]
+	if !ok {
+		log.Infof("token from untrusted issuer: %q", token.Claims.Issuer)
+		challenge.err = ErrInvalidToken
+		return nil, challenge
+	}
+
 	verifyOpts := VerifyOptions{
-		TrustedIssuers:    []string{ac.issuer},
-		AcceptedAudiences: []string{ac.service},
-		Roots:             ac.rootCerts,
-		TrustedKeys:       ac.trustedKeys,
+		TrustedIssuers:    ac.issuerNames,
+		AcceptedAudiences: ac.audiences,
+		Roots:             issuer.rootCerts,
+		TrustedKeys:       issuer.trustedKeys,
+	}
+	if issuer.keys != nil {
+		verifyOpts.IssuerKeys = map[string]KeyResolver{token.Claims.Issuer: issuer.keys}
 	}
 
 	if err = token.Verify(verifyOpts); err != nil {
@@ -0,0 +1,179 @@
+package token
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/docker/libtrust"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultJWKSRefreshInterval is how long a fetched key set is used
+	// before it is fetched again.
+	defaultJWKSRefreshInterval = time.Hour
+
+	// minJWKSRefreshInterval bounds how often an unknown key ID may
+	// trigger a refetch of the key set, so that tokens naming bogus key
+	// IDs cannot be used to hammer the issuer.
+	minJWKSRefreshInterval = time.Minute
+)
+
+// KeyResolver looks up the public key an issuer used to sign a token by
+// the key ID found in the token header.
+type KeyResolver interface {
+	ResolveKey(keyID string) (libtrust.PublicKey, error)
+}
+
+// jwksKeyResolver is a KeyResolver backed by a JSON Web Key Set published
+// by an issuer. The set is cached and refetched once the refresh interval
+// elapses, or earlier when a token names a key which is not in the cached
+// set, allowing the issuer to rotate its signing keys. The set is fetched
+// without holding the lock, and lookups racing a fetch share its result.
+type jwksKeyResolver struct {
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	keys        map[string]libtrust.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+
+	// fetching is closed once the fetch in progress, if any, completes.
+	fetching chan struct{}
+}
+
+var _ KeyResolver = &jwksKeyResolver{}
+
+// newJWKSKeyResolver returns a KeyResolver which fetches keys from the JWKS
+// document at url.
+func newJWKSKeyResolver(url string, refreshInterval time.Duration) *jwksKeyResolver {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+
+	return &jwksKeyResolver{
+		url:             url,
+		client:          &http.Client{Timeout: 30 * time.Second},
+		refreshInterval: refreshInterval,
+		keys:            make(map[string]libtrust.PublicKey),
+	}
+}
+
+// ResolveKey returns the key with the given key ID, fetching the key set
+// if it is stale or does not contain the key.
+func (r *jwksKeyResolver) ResolveKey(keyID string) (libtrust.PublicKey, error) {
+	r.mu.Lock()
+	now := time.Now()
+	key, known := r.keys[keyID]
+	stale := now.Sub(r.fetchedAt) > r.refreshInterval
+
+	switch {
+	case r.fetching != nil && !known:
+		// Wait for the fetch in progress, which may bring in the key.
+		fetching := r.fetching
+		r.mu.Unlock()
+		<-fetching
+		r.mu.Lock()
+		key, known = r.keys[keyID]
+	case r.fetching == nil && (stale || !known) && now.Sub(r.attemptedAt) > minJWKSRefreshInterval:
+		r.attemptedAt = now
+		fetching := make(chan struct{})
+		r.fetching = fetching
+		r.mu.Unlock()
+
+		keys, err := r.fetch()
+
+		r.mu.Lock()
+		if err != nil {
+			// Keep serving the keys we already have; a transient failure
+			// of the issuer should not invalidate every token.
+			log.Warnf("unable to refresh JWKS from %s: %v", r.url, err)
+		} else {
+			r.keys = keys
+			r.fetchedAt = now
+		}
+		r.fetching = nil
+		close(fetching)
+		key, known = r.keys[keyID]
+	}
+	r.mu.Unlock()
+
+	if !known {
+		return nil, fmt.Errorf("token signed by unknown key with ID: %q", keyID)
+	}
+
+	return key, nil
+}
+
+// fetch retrieves and parses the key set.
+func (r *jwksKeyResolver) fetch() (map[string]libtrust.PublicKey, error) {
+	resp, err := r.client.Get(r.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching JWKS: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJWKS(body)
+}
+
+// parseJWKS parses a JSON Web Key Set into a map of public keys indexed by
+// the key ID assigned by the issuer. Keys which are not usable for
+// signature verification are skipped.
+func parseJWKS(data []byte) (map[string]libtrust.PublicKey, error) {
+	var set struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("unable to decode JWKS: %v", err)
+	}
+
+	keys := make(map[string]libtrust.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if use, ok := jwk["use"].(string); ok && use != "sig" {
+			continue
+		}
+
+		kid, _ := jwk["kid"].(string)
+
+		// libtrust requires the kid of a JWK to be its own fingerprint,
+		// whereas issuers assign arbitrary identifiers, so drop it before
+		// decoding and index the key by the issuer's identifier instead.
+		delete(jwk, "kid")
+		raw, err := json.Marshal(jwk)
+		if err != nil {
+			return nil, err
+		}
+
+		pubKey, err := libtrust.UnmarshalPublicKeyJWK(raw)
+		if err != nil {
+			log.Debugf("skipping unsupported JWKS key %q: %v", kid, err)
+			continue
+		}
+
+		if kid == "" {
+			kid = pubKey.KeyID()
+		}
+		keys[kid] = pubKey
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS contains no usable signing keys")
+	}
+
+	return keys, nil
+}
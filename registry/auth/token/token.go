@@ -76,8 +76,17 @@ type Token struct {
 type VerifyOptions struct {
 	TrustedIssuers    []string
 	AcceptedAudiences []string
-	Roots             *x509.CertPool
-	TrustedKeys       map[string]libtrust.PublicKey
+
+	// Roots and TrustedKeys are trusted to sign tokens from any of the
+	// TrustedIssuers. Issuers which sign with keys of their own must be
+	// verified with options carrying only the keys of that issuer.
+	Roots       *x509.CertPool
+	TrustedKeys map[string]libtrust.PublicKey
+
+	// IssuerKeys optionally maps a trusted issuer to a resolver for the
+	// keys it signs tokens with, such as a JWKS endpoint. It is consulted
+	// for tokens carrying a `kid` which is not among the TrustedKeys.
+	IssuerKeys map[string]KeyResolver
 }
 
 // NewToken parses the given raw token string
@@ -191,7 +200,8 @@ func (t *Token) Verify(verifyOpts VerifyOptions) error {
 //              May contain its own `x5c` field which needs to be verified.
 //      `kid` - The unique identifier for the key. This library interprets it
 //              as a libtrust fingerprint. The key itself can be looked up in
-//              the trustedKeys field of the given verify options.
+//              the trustedKeys field of the given verify options, or, failing
+//              that, resolved through the key resolver of the token issuer.
 // Each of these methods are tried in that order of preference until the
 // signing key is found or an error is returned.
 func (t *Token) VerifySigningKey(verifyOpts VerifyOptions) (signingKey libtrust.PublicKey, err error) {
@@ -209,7 +219,12 @@ func (t *Token) VerifySigningKey(verifyOpts VerifyOptions) (signingKey libtrust.
 		signingKey, err = parseAndVerifyRawJWK(rawJWK, verifyOpts)
 	case len(keyID) > 0:
 		signingKey = verifyOpts.TrustedKeys[keyID]
-		if signingKey == nil {
+		if signingKey != nil {
+			break
+		}
+		if resolver, ok := verifyOpts.IssuerKeys[t.Claims.Issuer]; ok {
+			signingKey, err = resolver.ResolveKey(keyID)
+		} else {
 			err = fmt.Errorf("token signed by untrusted key with ID: %q", keyID)
 		}
 	default:
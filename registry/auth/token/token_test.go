@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -527,7 +528,319 @@ func TestNewAccessControllerPemBlock(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if len(ac.(*accessController).rootCerts.Subjects()) != 2 {
+	if len(ac.(*accessController).issuers[issuer].rootCerts.Subjects()) != 2 {
 		t.Fatal("accessController has the wrong number of certificates")
 	}
 }
+
+func makeKeyIDTestToken(issuer, audience, keyID string, access []*ResourceActions, signingKey libtrust.PrivateKey) (*Token, error) {
+	joseHeader := &Header{
+		Type:       "JWT",
+		SigningAlg: "RS256",
+		KeyID:      keyID,
+	}
+
+	now := time.Now()
+	claimSet := &ClaimSet{
+		Issuer:     issuer,
+		Subject:    "ci-job",
+		Audience:   audience,
+		Expiration: now.Add(5 * time.Minute).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		Access:     access,
+	}
+
+	joseHeaderBytes, err := json.Marshal(joseHeader)
+	if err != nil {
+		return nil, err
+	}
+	claimSetBytes, err := json.Marshal(claimSet)
+	if err != nil {
+		return nil, err
+	}
+
+	encodingToSign := fmt.Sprintf("%s.%s", joseBase64UrlEncode(joseHeaderBytes), joseBase64UrlEncode(claimSetBytes))
+	signatureBytes, _, err := signingKey.Sign(strings.NewReader(encodingToSign), crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewToken(fmt.Sprintf("%s.%s", encodingToSign, joseBase64UrlEncode(signatureBytes)))
+}
+
+func makeJWKS(t *testing.T, keys map[string]libtrust.PrivateKey) []byte {
+	var set struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	for kid, key := range keys {
+		raw, err := key.PublicKey().MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var jwk map[string]interface{}
+		if err := json.Unmarshal(raw, &jwk); err != nil {
+			t.Fatal(err)
+		}
+		jwk["kid"] = kid
+		jwk["use"] = "sig"
+		jwk["alg"] = "RS256"
+		set.Keys = append(set.Keys, jwk)
+	}
+	p, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestAccessControllerIssuers tests that tokens from several issuers and for
+// several audiences are accepted, with signing keys resolved via JWKS and
+// picked up again after the issuer rotates them.
+func TestAccessControllerIssuers(t *testing.T) {
+	oldKey, err := libtrust.GenerateRSA2048PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := libtrust.GenerateRSA2048PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := makeJWKS(t, map[string]libtrust.PrivateKey{"old": oldKey})
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	ciIssuer := "https://ci.example.com"
+	options := map[string]interface{}{
+		"realm":     "https://auth.example.com/token/",
+		"service":   "registry.example.com",
+		"audiences": []interface{}{"ci.registry.example.com"},
+		"issuers": []interface{}{
+			map[interface{}]interface{}{
+				"name": ciIssuer,
+				"jwks": server.URL,
+			},
+		},
+	}
+
+	ac, err := newAccessController(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testAccess := auth.Access{
+		Resource: auth.Resource{Type: "repository", Name: "foo/bar"},
+		Action:   "pull",
+	}
+	access := []*ResourceActions{{
+		Type:    testAccess.Type,
+		Name:    testAccess.Name,
+		Actions: []string{testAccess.Action},
+	}}
+
+	authorize := func(token *Token) error {
+		req, err := http.NewRequest("GET", "http://example.com/v2/foo/bar/tags/list", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.compactRaw()))
+		_, err = ac.Authorized(context.WithRequest(context.Background(), req), testAccess)
+		return err
+	}
+
+	for _, audience := range []string{"registry.example.com", "ci.registry.example.com"} {
+		token, err := makeKeyIDTestToken(ciIssuer, audience, "old", access, oldKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := authorize(token); err != nil {
+			t.Fatalf("unexpected error for audience %q: %v", audience, err)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("expected a single JWKS fetch, got %d", fetches)
+	}
+
+	token, err := makeKeyIDTestToken(ciIssuer, "other.example.com", "old", access, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authorize(token); err == nil {
+		t.Fatal("expected token for unknown audience to be rejected")
+	}
+
+	token, err = makeKeyIDTestToken("https://evil.example.com", "registry.example.com", "old", access, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authorize(token); err == nil {
+		t.Fatal("expected token from unknown issuer to be rejected")
+	}
+
+	// Rotate the issuer key. Refetches are rate limited, so pretend the
+	// last attempt happened long ago.
+	jwks = makeJWKS(t, map[string]libtrust.PrivateKey{"new": newKey})
+	ac.(*accessController).issuers[ciIssuer].keys.(*jwksKeyResolver).attemptedAt = time.Time{}
+
+	token, err = makeKeyIDTestToken(ciIssuer, "registry.example.com", "new", access, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authorize(token); err != nil {
+		t.Fatalf("unexpected error after key rotation: %v", err)
+	}
+	if fetches != 2 {
+		t.Fatalf("expected JWKS to be refetched after rotation, got %d fetches", fetches)
+	}
+}
+
+// TestAccessControllerIssuerKeys tests that the keys of the root certificate
+// bundle only sign tokens for the issuers they are configured for, and not
+// for issuers publishing keys of their own.
+func TestAccessControllerIssuerKeys(t *testing.T) {
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootCertBundleFilename, err := writeTempRootCerts(rootKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCertBundleFilename)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	issuer := "test-issuer.example.com"
+	ciIssuer := "https://ci.example.com"
+	service := "registry.example.com"
+	options := map[string]interface{}{
+		"realm":          "https://auth.example.com/token/",
+		"issuer":         issuer,
+		"service":        service,
+		"rootcertbundle": rootCertBundleFilename,
+		"issuers": []interface{}{
+			map[interface{}]interface{}{
+				"name": ciIssuer,
+				"jwks": server.URL,
+			},
+		},
+	}
+
+	ac, err := newAccessController(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testAccess := auth.Access{
+		Resource: auth.Resource{Type: "repository", Name: "foo/bar"},
+		Action:   "pull",
+	}
+	access := []*ResourceActions{{
+		Type:    testAccess.Type,
+		Name:    testAccess.Name,
+		Actions: []string{testAccess.Action},
+	}}
+
+	authorize := func(token *Token) error {
+		req, err := http.NewRequest("GET", "http://example.com/v2/foo/bar/tags/list", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.compactRaw()))
+		_, err = ac.Authorized(context.WithRequest(context.Background(), req), testAccess)
+		return err
+	}
+
+	// Sign with the root key itself and with a certificate chained to it.
+	for depth := 0; depth < 2; depth++ {
+		token, err := makeTestToken(issuer, service, access, rootKeys[0], depth, time.Now(), time.Now().Add(5*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := authorize(token); err != nil {
+			t.Fatalf("unexpected error for token of depth %d: %v", depth, err)
+		}
+
+		token, err = makeTestToken(ciIssuer, service, access, rootKeys[0], depth, time.Now(), time.Now().Add(5*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := authorize(token); err == nil {
+			t.Fatalf("expected token of depth %d signed by the root certificate bundle for %q to be rejected", depth, ciIssuer)
+		}
+	}
+}
+
+// TestJWKSKeyResolverConcurrentFetch tests that cached keys keep resolving
+// while the key set is refetched, and that lookups of unknown keys share
+// the fetch in progress.
+func TestJWKSKeyResolverConcurrentFetch(t *testing.T) {
+	oldKey, err := libtrust.GenerateRSA2048PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := libtrust.GenerateRSA2048PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := makeJWKS(t, map[string]libtrust.PrivateKey{"old": oldKey})
+	fetches := make(chan struct{}, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches <- struct{}{}
+		if len(fetches) > 1 {
+			<-release
+		}
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	r := newJWKSKeyResolver(server.URL, time.Hour)
+	if _, err := r.ResolveKey("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Let the cached set go stale and rotate the key, with the next fetch
+	// held up until released.
+	jwks = makeJWKS(t, map[string]libtrust.PrivateKey{"old": oldKey, "new": newKey})
+	r.mu.Lock()
+	r.fetchedAt, r.attemptedAt = time.Time{}, time.Time{}
+	r.mu.Unlock()
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := r.ResolveKey("old")
+		errs <- err
+	}()
+	for len(fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		_, err := r.ResolveKey("new")
+		errs <- err
+	}()
+
+	if _, err := r.ResolveKey("old"); err != nil {
+		t.Fatalf("unexpected error resolving a cached key during a fetch: %v", err)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(fetches) != 2 {
+		t.Fatalf("expected 2 JWKS fetches, got %d", len(fetches))
+	}
+}
@@ -56,3 +56,44 @@ func contains(ss []string, q string) bool {
 
 	return false
 }
+
+// stringList converts a configuration value holding a list of strings.
+func stringList(v interface{}) ([]string, bool) {
+	switch v := v.(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			list = append(list, s)
+		}
+		return list, true
+	}
+
+	return nil, false
+}
+
+// stringMap converts a configuration value holding a nested map, which the
+// yaml decoder produces with interface{} keys.
+func stringMap(v interface{}) (map[string]interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			s, ok := key.(string)
+			if !ok {
+				return nil, false
+			}
+			m[s] = val
+		}
+		return m, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,513 @@
+// Package bench drives concurrent push, pull and referrers workloads against
+// a registry, and reports the latency percentiles of each operation and the
+// number of storage operations the registry made to serve them.
+package bench
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math"
+	mrand "math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/client"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The operations of the workloads.
+const (
+	// OperationPush pushes an image of random layers and tags it.
+	OperationPush = "push"
+	// OperationPull pulls the manifest and all the blobs of an image.
+	OperationPull = "pull"
+	// OperationReferrers lists the referrers of an image.
+	OperationReferrers = "referrers"
+)
+
+// signatureArtifactType is the artifact type of the referrers pushed for the
+// images of the benchmark.
+const signatureArtifactType = "application/vnd.distribution.bench.signature+json"
+
+// Options configures a benchmark.
+type Options struct {
+	// Endpoint is the base URL of the registry, such as
+	// http://localhost:5000.
+	Endpoint string
+
+	// Transport returns the transport of the requests to a repository. It
+	// defaults to http.DefaultTransport.
+	Transport func(repository reference.Named) http.RoundTripper
+
+	// Prefix is the prefix of the names of the repositories created.
+	// Defaults to bench.
+	Prefix string
+
+	// Repositories is the number of repositories the operations are
+	// spread over. Defaults to 4.
+	Repositories int
+
+	// Concurrency is the number of operations in progress at once.
+	// Defaults to 8.
+	Concurrency int
+
+	// Duration is how long operations are started for. Defaults to 30s.
+	Duration time.Duration
+
+	// Operations, if not zero, is the number of operations after which the
+	// benchmark stops, even before Duration elapses.
+	Operations int
+
+	// Mix weighs the operations, keyed by push, pull and referrers. An
+	// operation is picked with a probability proportional to its weight.
+	// Defaults to 1 push for 8 pulls and 1 referrers listing.
+	Mix map[string]int
+
+	// Layers is the number of layers of the images pushed. Defaults to 2.
+	Layers int
+
+	// LayerSize is the size in bytes of the layers pushed. Defaults to
+	// 1MiB.
+	LayerSize int64
+
+	// StorageActions, if set, returns the number of storage operations
+	// made by the registry so far, by action. It is called before and
+	// after the workload, so that the operations made to serve it are
+	// reported.
+	StorageActions func() (map[string]float64, error)
+}
+
+// Result is the outcome of a benchmark.
+type Result struct {
+	// Duration is the time the workload took.
+	Duration time.Duration
+
+	// Operations lists the latencies of each operation, sorted by name.
+	Operations []OperationResult
+
+	// StorageActions is the number of storage operations made to serve
+	// the workload, by action, if Options.StorageActions is set.
+	StorageActions map[string]int64
+}
+
+// OperationResult describes the latencies of an operation.
+type OperationResult struct {
+	Name   string
+	Count  int
+	Errors int
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+// image is an image pushed to a repository.
+type image struct {
+	manifest digest.Digest
+	blobs    []digest.Digest
+}
+
+// repository holds the images pushed to a repository of the benchmark.
+type repository struct {
+	distribution.Repository
+	client *http.Client
+
+	mu     sync.Mutex
+	images []image
+}
+
+func (r *repository) add(img image) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.images = append(r.images, img)
+}
+
+func (r *repository) pick(random *mrand.Rand) image {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.images[random.Intn(len(r.images))]
+}
+
+// benchmark runs the operations of a workload.
+type benchmark struct {
+	opts         Options
+	repositories []*repository
+	weights      []string
+
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	pushed    int
+}
+
+// Run runs the benchmark configured by opts. It first pushes an image, and
+// a referrer of it, to each repository, so that there is content to pull,
+// then runs the workload.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	if err := setDefaults(&opts); err != nil {
+		return nil, err
+	}
+
+	b := &benchmark{
+		opts:      opts,
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+	for _, operation := range []string{OperationPush, OperationPull, OperationReferrers} {
+		for i := 0; i < opts.Mix[operation]; i++ {
+			b.weights = append(b.weights, operation)
+		}
+	}
+
+	random := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < opts.Repositories; i++ {
+		named, err := reference.WithName(fmt.Sprintf("%s/repo%d", opts.Prefix, i))
+		if err != nil {
+			return nil, err
+		}
+		transport := opts.Transport(named)
+		repo, err := client.NewRepository(named, opts.Endpoint, transport)
+		if err != nil {
+			return nil, err
+		}
+		r := &repository{Repository: repo, client: &http.Client{Transport: transport}}
+		img, err := b.pushImage(ctx, r, "seed")
+		if err != nil {
+			return nil, fmt.Errorf("failed to push an image to %s: %v", named, err)
+		}
+		if err := b.pushReferrer(ctx, r, img); err != nil {
+			return nil, fmt.Errorf("failed to push a referrer to %s: %v", named, err)
+		}
+		r.add(img)
+		b.repositories = append(b.repositories, r)
+	}
+
+	var before map[string]float64
+	if opts.StorageActions != nil {
+		var err error
+		if before, err = opts.StorageActions(); err != nil {
+			return nil, fmt.Errorf("failed to read storage actions: %v", err)
+		}
+	}
+
+	start := time.Now()
+	deadline := start.Add(opts.Duration)
+	operations := make(chan string)
+	go func() {
+		defer close(operations)
+		for n := 0; opts.Operations == 0 || n < opts.Operations; n++ {
+			if time.Now().After(deadline) || ctx.Err() != nil {
+				return
+			}
+			operations <- b.weights[random.Intn(len(b.weights))]
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			random := mrand.New(mrand.NewSource(seed))
+			for operation := range operations {
+				b.run(ctx, random, operation)
+			}
+		}(random.Int63())
+	}
+	wg.Wait()
+
+	result := &Result{Duration: time.Since(start)}
+	for _, operation := range []string{OperationPull, OperationPush, OperationReferrers} {
+		if latencies, ok := b.latencies[operation]; ok || b.errors[operation] > 0 {
+			result.Operations = append(result.Operations, summarize(operation, latencies, b.errors[operation]))
+		}
+	}
+
+	if opts.StorageActions != nil {
+		after, err := opts.StorageActions()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read storage actions: %v", err)
+		}
+		result.StorageActions = make(map[string]int64, len(after))
+		for action, count := range after {
+			if delta := int64(math.Round(count - before[action])); delta > 0 {
+				result.StorageActions[action] = delta
+			}
+		}
+	}
+	return result, nil
+}
+
+func setDefaults(opts *Options) error {
+	if opts.Endpoint == "" {
+		return fmt.Errorf("no endpoint")
+	}
+	if opts.Transport == nil {
+		opts.Transport = func(reference.Named) http.RoundTripper { return http.DefaultTransport }
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "bench"
+	}
+	if opts.Repositories <= 0 {
+		opts.Repositories = 4
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = 30 * time.Second
+	}
+	if opts.Layers <= 0 {
+		opts.Layers = 2
+	}
+	if opts.LayerSize <= 0 {
+		opts.LayerSize = 1 << 20
+	}
+	if len(opts.Mix) == 0 {
+		opts.Mix = map[string]int{OperationPush: 1, OperationPull: 8, OperationReferrers: 1}
+	}
+
+	total := 0
+	for operation, weight := range opts.Mix {
+		switch operation {
+		case OperationPush, OperationPull, OperationReferrers:
+		default:
+			return fmt.Errorf("unknown operation %q", operation)
+		}
+		if weight < 0 {
+			return fmt.Errorf("negative weight of %s", operation)
+		}
+		total += weight
+	}
+	if total == 0 {
+		return fmt.Errorf("no operation weighed")
+	}
+	return nil
+}
+
+// run runs an operation on a random repository and records its latency.
+func (b *benchmark) run(ctx context.Context, random *mrand.Rand, operation string) {
+	r := b.repositories[random.Intn(len(b.repositories))]
+
+	start := time.Now()
+	var err error
+	switch operation {
+	case OperationPush:
+		b.mu.Lock()
+		b.pushed++
+		tag := fmt.Sprintf("push%d", b.pushed)
+		b.mu.Unlock()
+		var img image
+		if img, err = b.pushImage(ctx, r, tag); err == nil {
+			r.add(img)
+		}
+	case OperationPull:
+		err = b.pullImage(ctx, r, r.pick(random))
+	case OperationReferrers:
+		err = b.listReferrers(ctx, r, r.pick(random))
+	}
+	elapsed := time.Since(start)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		dcontext.GetLogger(ctx).Debugf("%s failed on %s: %v", operation, r.Named(), err)
+		b.errors[operation]++
+		return
+	}
+	b.latencies[operation] = append(b.latencies[operation], elapsed)
+}
+
+// pushImage pushes an image of random layers, tagged with tag.
+func (b *benchmark) pushImage(ctx context.Context, r *repository, tag string) (image, error) {
+	blobs := r.Blobs(ctx)
+	m := ocischema.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+	}
+	var img image
+
+	for i := 0; i < b.opts.Layers; i++ {
+		layer := make([]byte, b.opts.LayerSize)
+		if _, err := rand.Read(layer); err != nil {
+			return image{}, err
+		}
+		desc, err := blobs.Put(ctx, v1.MediaTypeImageLayer, layer)
+		if err != nil {
+			return image{}, err
+		}
+		desc.MediaType = v1.MediaTypeImageLayer
+		m.Layers = append(m.Layers, desc)
+		img.blobs = append(img.blobs, desc.Digest)
+	}
+
+	config := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]},"created":%q}`, time.Now().UTC().Format(time.RFC3339Nano)))
+	desc, err := blobs.Put(ctx, v1.MediaTypeImageConfig, config)
+	if err != nil {
+		return image{}, err
+	}
+	desc.MediaType = v1.MediaTypeImageConfig
+	m.Config = desc
+	img.blobs = append(img.blobs, desc.Digest)
+
+	dm, err := ocischema.FromStruct(m)
+	if err != nil {
+		return image{}, err
+	}
+	manifests, err := r.Manifests(ctx)
+	if err != nil {
+		return image{}, err
+	}
+	if img.manifest, err = manifests.Put(ctx, dm, distribution.WithTag(tag)); err != nil {
+		return image{}, err
+	}
+	return img, nil
+}
+
+// pushReferrer pushes an artifact referring to img, such as a signature.
+func (b *benchmark) pushReferrer(ctx context.Context, r *repository, img image) error {
+	manifests, err := r.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	m, err := manifests.Get(ctx, img.manifest)
+	if err != nil {
+		return err
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		return err
+	}
+
+	config := []byte(`{}`)
+	desc, err := r.Blobs(ctx).Put(ctx, signatureArtifactType, config)
+	if err != nil {
+		return err
+	}
+	desc.MediaType = signatureArtifactType
+	dm, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+		Config:    desc,
+		Subject:   &distribution.Descriptor{MediaType: mediaType, Digest: img.manifest, Size: int64(len(payload))},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = manifests.Put(ctx, dm)
+	return err
+}
+
+// pullImage pulls the manifest and the blobs of img.
+func (b *benchmark) pullImage(ctx context.Context, r *repository, img image) error {
+	manifests, err := r.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := manifests.Get(ctx, img.manifest); err != nil {
+		return err
+	}
+
+	blobs := r.Blobs(ctx)
+	for _, dgst := range img.blobs {
+		rc, err := blobs.Open(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listReferrers lists the referrers of img, which requires the referrers
+// component of the oci extension.
+func (b *benchmark) listReferrers(ctx context.Context, r *repository, img image) error {
+	u := fmt.Sprintf("%s/v2/%s/referrers/%s", b.opts.Endpoint, r.Named().Name(), img.manifest)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status listing referrers: %s", resp.Status)
+	}
+	return nil
+}
+
+// summarize returns the percentiles of the latencies of an operation.
+func summarize(name string, latencies []time.Duration, errors int) OperationResult {
+	result := OperationResult{Name: name, Count: len(latencies), Errors: errors}
+	if len(latencies) == 0 {
+		return result
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		return latencies[int(math.Ceil(p*float64(len(latencies))))-1]
+	}
+	result.P50 = percentile(0.5)
+	result.P90 = percentile(0.9)
+	result.P99 = percentile(0.99)
+	result.Max = latencies[len(latencies)-1]
+	return result
+}
+
+// Write writes the result as tables of the operations and storage actions.
+func (r *Result) Write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%-10s %8s %8s %12s %12s %12s %12s %10s\n", "OPERATION", "COUNT", "ERRORS", "P50", "P90", "P99", "MAX", "OPS/S"); err != nil {
+		return err
+	}
+	for _, op := range r.Operations {
+		rate := float64(op.Count) / r.Duration.Seconds()
+		if _, err := fmt.Fprintf(w, "%-10s %8d %8d %12s %12s %12s %12s %10.1f\n", op.Name, op.Count, op.Errors,
+			op.P50.Round(time.Microsecond), op.P90.Round(time.Microsecond), op.P99.Round(time.Microsecond), op.Max.Round(time.Microsecond), rate); err != nil {
+			return err
+		}
+	}
+
+	if r.StorageActions == nil {
+		return nil
+	}
+	actions := make([]string, 0, len(r.StorageActions))
+	for action := range r.StorageActions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	total := 0
+	for _, op := range r.Operations {
+		total += op.Count
+	}
+	if _, err := fmt.Fprintf(w, "\n%-16s %10s %12s\n", "STORAGE ACTION", "COUNT", "PER OP"); err != nil {
+		return err
+	}
+	for _, action := range actions {
+		perOperation := 0.0
+		if total > 0 {
+			perOperation = float64(r.StorageActions[action]) / float64(total)
+		}
+		if _, err := fmt.Fprintf(w, "%-16s %10d %12.2f\n", action, r.StorageActions[action], perOperation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/prometheus/client_golang/prometheus"
+
+	_ "github.com/distribution/distribution/v3/registry/extension/oci"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestRun(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{"enabled": false}},
+		},
+		Extensions: map[string]configuration.ExtensionConfig{
+			"oci": map[string]interface{}{"artifacts": []string{"referrers"}},
+		},
+	}
+	config.Log.AccessLog.Disabled = true
+	server := httptest.NewServer(handlers.NewApp(context.Background(), config))
+	defer server.Close()
+
+	result, err := Run(context.Background(), Options{
+		Endpoint:       server.URL,
+		Repositories:   2,
+		Concurrency:    4,
+		Duration:       time.Minute,
+		Operations:     30,
+		Mix:            map[string]int{OperationPush: 1, OperationPull: 1, OperationReferrers: 1},
+		Layers:         1,
+		LayerSize:      1 << 10,
+		StorageActions: GatheredStorageActions(prometheus.DefaultGatherer),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for _, operation := range result.Operations {
+		if operation.Errors != 0 {
+			t.Errorf("%d errors running %s", operation.Errors, operation.Name)
+		}
+		if operation.Count > 0 && (operation.P50 > operation.P99 || operation.P99 > operation.Max) {
+			t.Errorf("unordered percentiles of %s: %+v", operation.Name, operation)
+		}
+		count += operation.Count + operation.Errors
+	}
+	if count != 30 {
+		t.Errorf("ran %d operations, expected 30", count)
+	}
+	if len(result.StorageActions) == 0 {
+		t.Error("no storage actions reported")
+	}
+
+	var buf bytes.Buffer
+	if err := result.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, operation := range []string{OperationPush, OperationPull, OperationReferrers} {
+		if !strings.Contains(buf.String(), operation) {
+			t.Errorf("%s missing from the results:\n%s", operation, buf.String())
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{},
+		{Endpoint: "http://localhost:5000", Mix: map[string]int{"delete": 1}},
+		{Endpoint: "http://localhost:5000", Mix: map[string]int{OperationPush: -1, OperationPull: 2}},
+		{Endpoint: "http://localhost:5000", Mix: map[string]int{OperationPush: 0}},
+	} {
+		if err := setDefaults(&opts); err == nil {
+			t.Errorf("expected an error with options %+v", opts)
+		}
+	}
+
+	opts := Options{Endpoint: "http://localhost:5000"}
+	if err := setDefaults(&opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Concurrency != 8 || opts.Repositories != 4 || opts.Mix[OperationPull] != 8 {
+		t.Errorf("unexpected defaults: %+v", opts)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	result := summarize(OperationPull, latencies, 2)
+	if result.Count != 100 || result.Errors != 2 {
+		t.Fatalf("unexpected counts: %+v", result)
+	}
+	if result.P50 != 50*time.Millisecond || result.P99 != 99*time.Millisecond || result.Max != 100*time.Millisecond {
+		t.Fatalf("unexpected percentiles: %+v", result)
+	}
+	if empty := summarize(OperationPush, nil, 1); empty.Count != 0 || empty.Max != 0 {
+		t.Fatalf("unexpected summary of no latencies: %+v", empty)
+	}
+}
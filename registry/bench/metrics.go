@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// storageActionMetric is the histogram of the durations of the actions of
+// the storage drivers, labeled by driver and action, which all drivers
+// record.
+const storageActionMetric = "registry_storage_action_seconds"
+
+// GatheredStorageActions returns a function reading the number of storage
+// operations from the metrics of the registry running in this process.
+func GatheredStorageActions(gatherer prometheus.Gatherer) func() (map[string]float64, error) {
+	return func() (map[string]float64, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return nil, err
+		}
+		for _, family := range families {
+			if family.GetName() == storageActionMetric {
+				return storageActions(family), nil
+			}
+		}
+		return map[string]float64{}, nil
+	}
+}
+
+// ScrapedStorageActions returns a function reading the number of storage
+// operations from the Prometheus endpoint of a registry, such as
+// http://localhost:5001/metrics.
+func ScrapedStorageActions(metricsURL string) func() (map[string]float64, error) {
+	return func() (map[string]float64, error) {
+		resp, err := http.Get(metricsURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status scraping %s: %s", metricsURL, resp.Status)
+		}
+
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		family, ok := families[storageActionMetric]
+		if !ok {
+			return map[string]float64{}, nil
+		}
+		return storageActions(family), nil
+	}
+}
+
+// storageActions sums the number of operations of each action of the
+// storage drivers.
+func storageActions(family *dto.MetricFamily) map[string]float64 {
+	actions := make(map[string]float64)
+	for _, metric := range family.GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "action" {
+				actions[label.GetValue()] += float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return actions
+}
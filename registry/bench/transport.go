@@ -0,0 +1,33 @@
+package bench
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/client/auth"
+	"github.com/distribution/distribution/v3/registry/client/auth/challenge"
+	"github.com/distribution/distribution/v3/registry/client/transport"
+)
+
+// AuthTransport returns the transports of the requests to the repositories
+// of the registry at endpoint, answering its authentication challenges with
+// the credentials of creds.
+func AuthTransport(endpoint string, creds auth.CredentialStore) (func(repository reference.Named) http.RoundTripper, error) {
+	resp, err := http.Get(endpoint + "/v2/")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	manager := challenge.NewSimpleManager()
+	if err := manager.AddResponse(resp); err != nil {
+		return nil, fmt.Errorf("failed to read the challenges of %s: %v", endpoint, err)
+	}
+
+	return func(repository reference.Named) http.RoundTripper {
+		return transport.NewTransport(http.DefaultTransport, auth.NewAuthorizer(manager,
+			auth.NewTokenHandler(http.DefaultTransport, creds, repository.Name(), "pull", "push"),
+			auth.NewBasicHandler(creds)))
+	}, nil
+}
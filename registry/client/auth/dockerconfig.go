@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// dockerHubConfigKey is the key the docker CLI uses for Docker Hub
+	// credentials, regardless of the registry host being accessed.
+	dockerHubConfigKey = "https://index.docker.io/v1/"
+
+	// tokenUsername is the username reported by credential helpers for
+	// identity tokens rather than passwords.
+	tokenUsername = "<token>"
+)
+
+// ErrCredentialsNotFound is returned by a credential helper when it holds
+// no credentials for the requested server.
+var ErrCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// DockerAuthConfig is an entry of the "auths" section of a docker
+// config.json file.
+type DockerAuthConfig struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// DockerConfig holds the credential related parts of a docker config.json
+// file.
+type DockerConfig struct {
+	// Auths holds credentials stored directly in the file, keyed by
+	// registry.
+	Auths map[string]DockerAuthConfig `json:"auths,omitempty"`
+
+	// CredentialsStore names the credential helper used for registries
+	// without an entry in CredentialHelpers, such as "osxkeychain",
+	// "secretservice", "wincred" or "pass".
+	CredentialsStore string `json:"credsStore,omitempty"`
+
+	// CredentialHelpers names the credential helper to use per registry.
+	CredentialHelpers map[string]string `json:"credHelpers,omitempty"`
+}
+
+// DefaultDockerConfigPath returns the location of the docker config.json
+// file, honoring the DOCKER_CONFIG environment variable.
+func DefaultDockerConfigPath() string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// LoadDockerConfig reads the docker config.json file at path. An empty path
+// selects the default location.
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	if path == "" {
+		path = DefaultDockerConfigPath()
+	}
+
+	p, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config DockerConfig
+	if err := json.Unmarshal(p, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse docker config %s: %v", path, err)
+	}
+
+	return &config, nil
+}
+
+// CredentialHelper retrieves credentials from an external store.
+type CredentialHelper interface {
+	// Get returns the username and secret stored for serverURL, or
+	// ErrCredentialsNotFound.
+	Get(serverURL string) (username, secret string, err error)
+}
+
+// execCredentialHelper runs a docker-credential-<name> program using the
+// docker credential helper protocol.
+type execCredentialHelper struct {
+	program string
+}
+
+// NewExecCredentialHelper returns a CredentialHelper which runs the
+// docker-credential-<name> program found in PATH.
+func NewExecCredentialHelper(name string) CredentialHelper {
+	return execCredentialHelper{program: "docker-credential-" + name}
+}
+
+func (h execCredentialHelper) Get(serverURL string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(h.program, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(msg, ErrCredentialsNotFound.Error()) {
+			return "", "", ErrCredentialsNotFound
+		}
+		return "", "", fmt.Errorf("%s get: %v: %s", h.program, err, msg)
+	}
+
+	var resp struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return "", "", fmt.Errorf("%s get: unable to parse response: %v", h.program, err)
+	}
+
+	return resp.Username, resp.Secret, nil
+}
+
+// dockerCredentialStore is a CredentialStore providing the credentials
+// stored in a docker config for a single registry.
+type dockerCredentialStore struct {
+	registry  string
+	config    *DockerConfig
+	newHelper func(name string) CredentialHelper
+
+	mu            sync.Mutex
+	refreshTokens map[string]string
+}
+
+// NewDockerCredentialStore returns a CredentialStore which answers with the
+// credentials the docker config holds for the registry at registryURL. The
+// credentials are looked up on each request so that credential helpers
+// handing out short lived secrets are consulted again as they rotate.
+// Since the store is scoped to a single registry it answers for any realm
+// that registry delegates authentication to.
+func NewDockerCredentialStore(config *DockerConfig, registryURL string) CredentialStore {
+	return &dockerCredentialStore{
+		registry:      normalizeRegistryHost(registryURL),
+		config:        config,
+		newHelper:     NewExecCredentialHelper,
+		refreshTokens: make(map[string]string),
+	}
+}
+
+func (s *dockerCredentialStore) Basic(*url.URL) (string, string) {
+	username, secret, err := s.lookup()
+	if err != nil || username == tokenUsername {
+		return "", ""
+	}
+	return username, secret
+}
+
+func (s *dockerCredentialStore) RefreshToken(u *url.URL, service string) string {
+	s.mu.Lock()
+	token, ok := s.refreshTokens[service]
+	s.mu.Unlock()
+	if ok {
+		return token
+	}
+
+	username, secret, err := s.lookup()
+	if err != nil || username != tokenUsername {
+		return ""
+	}
+	return secret
+}
+
+func (s *dockerCredentialStore) SetRefreshToken(realm *url.URL, service, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshTokens[service] = token
+}
+
+// lookup resolves the credentials for the registry, preferring a registry
+// specific credential helper, then the default credentials store and
+// finally the entries stored in the config file itself. Identity tokens
+// are reported with the "<token>" username, as credential helpers do.
+func (s *dockerCredentialStore) lookup() (string, string, error) {
+	if s.config == nil {
+		return "", "", ErrCredentialsNotFound
+	}
+
+	for key, helper := range s.config.CredentialHelpers {
+		if normalizeRegistryHost(key) == s.registry {
+			return s.newHelper(helper).Get(key)
+		}
+	}
+
+	if s.config.CredentialsStore != "" {
+		username, secret, err := s.newHelper(s.config.CredentialsStore).Get(s.serverURL())
+		if err != ErrCredentialsNotFound {
+			return username, secret, err
+		}
+	}
+
+	for key, entry := range s.config.Auths {
+		if normalizeRegistryHost(key) != s.registry {
+			continue
+		}
+
+		if entry.IdentityToken != "" {
+			return tokenUsername, entry.IdentityToken, nil
+		}
+
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid auth entry for %s: %v", key, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return "", "", fmt.Errorf("invalid auth entry for %s", key)
+			}
+			return username, password, nil
+		}
+
+		return entry.Username, entry.Password, nil
+	}
+
+	return "", "", ErrCredentialsNotFound
+}
+
+// serverURL returns the server URL credential stores index the registry
+// by.
+func (s *dockerCredentialStore) serverURL() string {
+	if s.registry == "docker.io" {
+		return dockerHubConfigKey
+	}
+	return s.registry
+}
+
+// normalizeRegistryHost reduces the various forms a registry is keyed by in
+// a docker config, such as "https://host/v1/" or "host", to the host name,
+// folding the Docker Hub aliases together.
+func normalizeRegistryHost(registry string) string {
+	host := registry
+	if strings.Contains(host, "://") {
+		if u, err := url.Parse(host); err == nil {
+			host = u.Host
+		}
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return host
+}
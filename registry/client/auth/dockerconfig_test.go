@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testCredentialHelper struct {
+	creds map[string][2]string
+	calls *int
+}
+
+func (h testCredentialHelper) Get(serverURL string) (string, string, error) {
+	*h.calls++
+	c, ok := h.creds[serverURL]
+	if !ok {
+		return "", "", ErrCredentialsNotFound
+	}
+	return c[0], c[1], nil
+}
+
+func TestLoadDockerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	content := `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("batman:robin")) + `"},
+			"ghcr.io": {"identitytoken": "refresh-me"}
+		},
+		"credsStore": "desktop",
+		"credHelpers": {"123456789.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"}
+	}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadDockerConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CredentialsStore != "desktop" {
+		t.Fatalf("unexpected credentials store: %q", config.CredentialsStore)
+	}
+	if config.CredentialHelpers["123456789.dkr.ecr.us-east-1.amazonaws.com"] != "ecr-login" {
+		t.Fatalf("unexpected credential helpers: %v", config.CredentialHelpers)
+	}
+	if len(config.Auths) != 2 {
+		t.Fatalf("unexpected auths: %v", config.Auths)
+	}
+}
+
+func TestDockerCredentialStore(t *testing.T) {
+	config := &DockerConfig{
+		Auths: map[string]DockerAuthConfig{
+			"https://index.docker.io/v1/": {Auth: base64.StdEncoding.EncodeToString([]byte("batman:robin"))},
+			"ghcr.io":                     {IdentityToken: "refresh-me"},
+			"quay.io":                     {Username: "joker", Password: "harley"},
+		},
+		CredentialHelpers: map[string]string{
+			"123456789.dkr.ecr.us-east-1.amazonaws.com": "ecr-login",
+		},
+	}
+
+	calls := 0
+	helperFactory := func(name string) CredentialHelper {
+		if name != "ecr-login" {
+			t.Fatalf("unexpected helper %q", name)
+		}
+		return testCredentialHelper{
+			calls: &calls,
+			creds: map[string][2]string{
+				"123456789.dkr.ecr.us-east-1.amazonaws.com": {"AWS", "ecr-password"},
+			},
+		}
+	}
+
+	realm, _ := url.Parse("https://auth.example.com/token")
+
+	for _, tc := range []struct {
+		registry string
+		username string
+		password string
+		token    string
+	}{
+		{registry: "https://registry-1.docker.io", username: "batman", password: "robin"},
+		{registry: "https://ghcr.io", token: "refresh-me"},
+		{registry: "quay.io", username: "joker", password: "harley"},
+		{registry: "https://123456789.dkr.ecr.us-east-1.amazonaws.com", username: "AWS", password: "ecr-password"},
+		{registry: "https://unknown.example.com"},
+	} {
+		store := NewDockerCredentialStore(config, tc.registry).(*dockerCredentialStore)
+		store.newHelper = helperFactory
+
+		username, password := store.Basic(realm)
+		if username != tc.username || password != tc.password {
+			t.Errorf("%s: unexpected basic credentials %q:%q", tc.registry, username, password)
+		}
+		if token := store.RefreshToken(realm, "service"); token != tc.token {
+			t.Errorf("%s: unexpected refresh token %q", tc.registry, token)
+		}
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected the credential helper to be consulted on each lookup, got %d calls", calls)
+	}
+
+	store := NewDockerCredentialStore(config, "https://ghcr.io")
+	store.SetRefreshToken(realm, "service", "new-token")
+	if token := store.RefreshToken(realm, "service"); token != "new-token" {
+		t.Fatalf("unexpected refresh token after update: %q", token)
+	}
+}
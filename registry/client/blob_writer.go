@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/distribution/distribution/v3"
@@ -22,6 +23,38 @@ type httpBlobUpload struct {
 	location string // always the last value of the location header.
 	offset   int64
 	closed   bool
+
+	// minChunk and maxChunk are the bounds of the length of the chunks
+	// advertised by the registry, unbounded when zero. The data written
+	// is buffered in pending until it fills a chunk of minChunk bytes.
+	minChunk int64
+	maxChunk int64
+	pending  []byte
+}
+
+// The headers of the responses of the upload endpoints advertising the bounds
+// of the length of chunks, defined by the OCI distribution spec.
+const (
+	chunkMinLengthHeader = "OCI-Chunk-Min-Length"
+	chunkMaxLengthHeader = "OCI-Chunk-Max-Length"
+)
+
+// setChunkLengths sets the bounds of the length of chunks advertised by the
+// headers of an upload response. Invalid values are ignored, and a minimum
+// above the maximum is lowered to it, as chunks cannot exceed the maximum.
+func (hbu *httpBlobUpload) setChunkLengths(h http.Header) {
+	parse := func(name string) int64 {
+		n, err := strconv.ParseInt(h.Get(name), 10, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n
+	}
+	hbu.minChunk = parse(chunkMinLengthHeader)
+	hbu.maxChunk = parse(chunkMaxLengthHeader)
+	if hbu.maxChunk > 0 && hbu.minChunk > hbu.maxChunk {
+		hbu.minChunk = hbu.maxChunk
+	}
 }
 
 func (hbu *httpBlobUpload) Reader() (io.ReadCloser, error) {
@@ -36,6 +69,12 @@ func (hbu *httpBlobUpload) handleErrorResponse(resp *http.Response) error {
 }
 
 func (hbu *httpBlobUpload) ReadFrom(r io.Reader) (n int64, err error) {
+	if hbu.maxChunk > 0 || len(hbu.pending) > 0 {
+		// The data cannot be streamed in a single chunk, so it is written
+		// in chunks of the lengths accepted.
+		return io.Copy(struct{ io.Writer }{hbu}, r)
+	}
+
 	req, err := http.NewRequest("PATCH", hbu.location, ioutil.NopCloser(r))
 	if err != nil {
 		return 0, err
@@ -63,12 +102,60 @@ func (hbu *httpBlobUpload) ReadFrom(r io.Reader) (n int64, err error) {
 	} else if n != 2 || end < start {
 		return 0, fmt.Errorf("bad range format: %s", rng)
 	}
+	hbu.setChunkLengths(resp.Header)
 
-	hbu.offset += end - start + 1
-	return (end - start + 1), nil
+	n = end + 1 - hbu.offset
+	hbu.offset = end + 1
+	return n, nil
 }
 
+// Write writes p to the upload. When the registry bounds the length of
+// chunks, p is split into chunks no longer than the maximum, and buffered
+// until it fills a chunk of the minimum, the rest being sent on Commit.
 func (hbu *httpBlobUpload) Write(p []byte) (n int, err error) {
+	if hbu.minChunk <= 0 && hbu.maxChunk <= 0 && len(hbu.pending) == 0 {
+		return hbu.writeChunk(p)
+	}
+
+	buffered := len(hbu.pending)
+	hbu.pending = append(hbu.pending, p...)
+	sent := 0
+	for len(hbu.pending) > 0 && int64(len(hbu.pending)) >= hbu.minChunk {
+		chunk := len(hbu.pending)
+		if hbu.maxChunk > 0 && int64(chunk) > hbu.maxChunk {
+			chunk = int(hbu.maxChunk)
+		}
+		if _, err := hbu.writeChunk(hbu.pending[:chunk]); err != nil {
+			// Only the part of the data buffered before p which was not
+			// sent remains pending, the rest of p being reported unwritten.
+			if n = sent - buffered; n < 0 {
+				hbu.pending = hbu.pending[:-n]
+				n = 0
+			} else {
+				hbu.pending = hbu.pending[:0]
+			}
+			return n, err
+		}
+		sent += chunk
+		hbu.pending = hbu.pending[chunk:]
+	}
+	return len(p), nil
+}
+
+// flush sends the data pending as the last chunk of the upload.
+func (hbu *httpBlobUpload) flush() error {
+	if len(hbu.pending) == 0 {
+		return nil
+	}
+	if _, err := hbu.writeChunk(hbu.pending); err != nil {
+		return err
+	}
+	hbu.pending = nil
+	return nil
+}
+
+// writeChunk sends p in a single chunk.
+func (hbu *httpBlobUpload) writeChunk(p []byte) (n int, err error) {
 	req, err := http.NewRequest("PATCH", hbu.location, bytes.NewReader(p))
 	if err != nil {
 		return 0, err
@@ -98,13 +185,15 @@ func (hbu *httpBlobUpload) Write(p []byte) (n int, err error) {
 	} else if n != 2 || end < start {
 		return 0, fmt.Errorf("bad range format: %s", rng)
 	}
+	hbu.setChunkLengths(resp.Header)
 
-	hbu.offset += int64(end - start + 1)
-	return (end - start + 1), nil
+	n = end + 1 - int(hbu.offset)
+	hbu.offset = int64(end + 1)
+	return n, nil
 }
 
 func (hbu *httpBlobUpload) Size() int64 {
-	return hbu.offset
+	return hbu.offset + int64(len(hbu.pending))
 }
 
 func (hbu *httpBlobUpload) ID() string {
@@ -117,6 +206,10 @@ func (hbu *httpBlobUpload) StartedAt() time.Time {
 
 func (hbu *httpBlobUpload) Commit(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
 	// TODO(dmcgowan): Check if already finished, if so just fetch
+	if err := hbu.flush(); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
 	req, err := http.NewRequest("PUT", hbu.location, nil)
 	if err != nil {
 		return distribution.Descriptor{}, err
@@ -140,6 +233,7 @@ func (hbu *httpBlobUpload) Commit(ctx context.Context, desc distribution.Descrip
 }
 
 func (hbu *httpBlobUpload) Cancel(ctx context.Context) error {
+	hbu.pending = nil
 	req, err := http.NewRequest("DELETE", hbu.location, nil)
 	if err != nil {
 		return err
@@ -492,3 +492,75 @@ func TestUploadWrite(t *testing.T) {
 		t.Fatalf("Unexpected response status: %s, expected %s", uploadErr.Status, expected)
 	}
 }
+
+func TestUploadWriteChunkLengths(t *testing.T) {
+	_, b := newRandomBlob(40)
+	locationPath := "/v2/test/upload/chunks/uploads/testid"
+	chunkLengths := http.Header{}
+	chunkLengths.Set("Docker-Upload-UUID", "46603072-7a1b-4b41-98f9-fd8a7da89f9b")
+	chunkLengths.Set("Location", locationPath)
+	chunkLengths.Set("OCI-Chunk-Min-Length", "16")
+	chunkLengths.Set("OCI-Chunk-Max-Length", "32")
+	withRange := func(rng string) http.Header {
+		h := chunkLengths.Clone()
+		h.Set("Range", rng)
+		return h
+	}
+
+	m := testutil.RequestResponseMap([]testutil.RequestResponseMapping{
+		{
+			Request: testutil.Request{
+				Method: "PATCH",
+				Route:  locationPath,
+				Body:   b[:32],
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusAccepted,
+				Headers:    withRange("0-31"),
+			},
+		},
+		{
+			Request: testutil.Request{
+				Method: "PATCH",
+				Route:  locationPath,
+				Body:   b[32:],
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusAccepted,
+				Headers:    withRange("0-39"),
+			},
+		},
+	})
+
+	e, c := testServer(m)
+	defer c()
+
+	blobUpload := &httpBlobUpload{
+		client:   &http.Client{},
+		location: e + locationPath,
+	}
+	blobUpload.setChunkLengths(chunkLengths)
+
+	// Data shorter than the minimum is buffered.
+	if n, err := blobUpload.Write(b[:10]); err != nil || n != 10 {
+		t.Fatalf("Error calling Write: %d, %v", n, err)
+	}
+	if blobUpload.Size() != 10 {
+		t.Fatalf("Wrong size returned from Size: %d, expected 10", blobUpload.Size())
+	}
+
+	// Chunks are no longer than the maximum, and the rest shorter than the
+	// minimum is sent as the last chunk.
+	if n, err := blobUpload.Write(b[10:]); err != nil || n != 30 {
+		t.Fatalf("Error calling Write: %d, %v", n, err)
+	}
+	if len(blobUpload.pending) != 8 {
+		t.Fatalf("Wrong length of data pending: %d, expected 8", len(blobUpload.pending))
+	}
+	if err := blobUpload.flush(); err != nil {
+		t.Fatalf("Error sending the last chunk: %s", err)
+	}
+	if blobUpload.Size() != 40 {
+		t.Fatalf("Wrong size returned from Size: %d, expected 40", blobUpload.Size())
+	}
+}
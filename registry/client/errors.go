@@ -8,7 +8,9 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/client/auth/challenge"
 )
 
@@ -38,6 +40,52 @@ func (e *UnexpectedHTTPResponseError) Error() string {
 	return fmt.Sprintf("error parsing HTTP %d response body: %s: %q", e.StatusCode, e.ParseErr.Error(), string(e.Response))
 }
 
+// registryError is an error response of the registry which also matches, with
+// errors.Is and errors.As, the error of the distribution package its error
+// code stands for, such as distribution.ErrTagUnknown for the unknown
+// manifest of a tag. It unwraps to the errcode.Errors of the response, which
+// errors.As rather than a type assertion then returns.
+type registryError struct {
+	errcode.Errors
+	typed error
+}
+
+func (err registryError) Is(target error) bool {
+	return errors.Is(err.typed, target) || err.Errors.Is(target)
+}
+
+func (err registryError) As(target interface{}) bool {
+	return errors.As(err.typed, target) || err.Errors.As(target)
+}
+
+// Unwrap returns the errors of the response.
+func (err registryError) Unwrap() error {
+	return err.Errors
+}
+
+// typedError returns err, the error response of a request about the
+// repository name, matching unknown when its error code is that of an
+// unknown manifest or blob, and distribution.ErrRepositoryUnknown when it is
+// that of an unknown repository. Other errors are returned as is, so that
+// they remain errcode.Errors.
+func typedError(err error, name string, unknown error) error {
+	errs, ok := err.(errcode.Errors)
+	if !ok {
+		return err
+	}
+
+	var typed error
+	switch {
+	case errs.Is(v2.ErrorCodeNameUnknown):
+		typed = distribution.ErrRepositoryUnknown{Name: name}
+	case unknown != nil && (errs.Is(v2.ErrorCodeManifestUnknown) || errs.Is(v2.ErrorCodeBlobUnknown)):
+		typed = unknown
+	default:
+		return err
+	}
+	return registryError{Errors: errs, typed: typed}
+}
+
 func parseHTTPErrorResponse(statusCode int, r io.Reader) error {
 	var errors errcode.Errors
 	body, err := ioutil.ReadAll(r)
@@ -2,10 +2,15 @@ package client
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 )
 
 type nopCloser struct {
@@ -102,3 +107,30 @@ func TestHandleErrorResponseUnexpectedStatusCode501(t *testing.T) {
 		t.Errorf("Expected \"%s\", got: \"%s\"", expectedMsg, err.Error())
 	}
 }
+
+func TestTypedErrorErrcodeErrors(t *testing.T) {
+	// Error responses without a typed error remain errcode.Errors.
+	denied := errcode.Errors{errcode.ErrorCodeDenied.WithMessage("denied")}
+	err := typedError(denied, "foo/bar", distribution.ErrTagUnknown{Tag: "latest"})
+	errs, ok := err.(errcode.Errors)
+	if !ok || len(errs) != 1 || errs[0].(errcode.Error).Code != errcode.ErrorCodeDenied {
+		t.Fatalf("expected the errcode.Errors of the response, got %#v", err)
+	}
+
+	// Typed ones unwrap to them.
+	unknown := errcode.Errors{v2.ErrorCodeManifestUnknown.WithMessage("manifest unknown")}
+	err = typedError(unknown, "foo/bar", distribution.ErrTagUnknown{Tag: "latest"})
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].(errcode.Error).Code != v2.ErrorCodeManifestUnknown {
+		t.Fatalf("expected the error to unwrap to the errcode.Errors of the response, got %#v", err)
+	}
+	if errors.Unwrap(err).(errcode.Errors)[0] != unknown[0] {
+		t.Fatalf("expected the error to unwrap to the errcode.Errors of the response, got %#v", errors.Unwrap(err))
+	}
+	var e errcode.Error
+	if !errors.As(err, &e) || e.Code != v2.ErrorCodeManifestUnknown {
+		t.Fatalf("expected the error to match the errcode.Error of the response, got %#v", err)
+	}
+	if !errors.Is(err, distribution.ErrTagUnknown{Tag: "latest"}) {
+		t.Fatalf("expected the error to match the unknown tag, got %#v", err)
+	}
+}
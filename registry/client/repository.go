@@ -15,14 +15,21 @@ import (
 	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/client/transport"
 	"github.com/distribution/distribution/v3/registry/storage/cache"
 	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// catalogPageSize is the number of repositories requested at once when
+// enumerating the catalog.
+const catalogPageSize = 100
+
 // Registry provides an interface for calling Repositories, which returns a catalog of repositories.
 type Registry interface {
 	Repositories(ctx context.Context, repos []string, last string) (n int, err error)
@@ -134,6 +141,28 @@ func (r *registry) Repositories(ctx context.Context, entries []string, last stri
 	return numFilled, returnErr
 }
 
+// Enumerate calls ingester for each repository of the registry, in
+// lexicographical order, paging through the catalog.
+func (r *registry) Enumerate(ctx context.Context, ingester func(string) error) error {
+	entries := make([]string, catalogPageSize)
+	last := ""
+	for {
+		n, err := r.Repositories(ctx, entries, last)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		for _, name := range entries[:n] {
+			if err := ingester(name); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || n == 0 {
+			return nil
+		}
+		last = entries[n-1]
+	}
+}
+
 // NewRepository creates a new Repository for the given repository name and base URL.
 func NewRepository(name reference.Named, baseURL string, transport http.RoundTripper) (distribution.Repository, error) {
 	ub, err := v2.NewURLBuilderFromString(baseURL, false)
@@ -253,7 +282,7 @@ func (t *tags) All(ctx context.Context) ([]string, error) {
 				return tags, nil
 			}
 		} else {
-			return tags, HandleErrorResponse(resp)
+			return tags, typedError(HandleErrorResponse(resp), t.name.Name(), nil)
 		}
 	}
 }
@@ -351,7 +380,7 @@ func (t *tags) Get(ctx context.Context, tag string) (distribution.Descriptor, er
 		if resp.StatusCode >= 200 && resp.StatusCode < 400 {
 			return descriptorFromResponse(resp)
 		}
-		return distribution.Descriptor{}, HandleErrorResponse(resp)
+		return distribution.Descriptor{}, typedError(HandleErrorResponse(resp), t.name.Name(), distribution.ErrTagUnknown{Tag: tag})
 	}
 }
 
@@ -387,7 +416,7 @@ func (t *tags) Untag(ctx context.Context, tag string) error {
 	if SuccessStatus(resp.StatusCode) {
 		return nil
 	}
-	return HandleErrorResponse(resp)
+	return typedError(HandleErrorResponse(resp), t.name.Name(), distribution.ErrTagUnknown{Tag: tag})
 }
 
 type manifests struct {
@@ -541,7 +570,12 @@ func (ms *manifests) Get(ctx context.Context, dgst digest.Digest, options ...dis
 		}
 		return m, nil
 	}
-	return nil, HandleErrorResponse(resp)
+
+	var unknown error = distribution.ErrManifestUnknownRevision{Name: ms.name.Name(), Revision: dgst}
+	if digestOrTag != dgst.String() {
+		unknown = distribution.ErrManifestUnknown{Name: ms.name.Name(), Tag: digestOrTag}
+	}
+	return nil, typedError(HandleErrorResponse(resp), ms.name.Name(), unknown)
 }
 
 // Put puts a manifest.  A tag can be specified using an options parameter which uses some shared state to hold the
@@ -636,13 +670,118 @@ func (ms *manifests) Delete(ctx context.Context, dgst digest.Digest) error {
 	if SuccessStatus(resp.StatusCode) {
 		return nil
 	}
-	return HandleErrorResponse(resp)
+	return typedError(HandleErrorResponse(resp), ms.name.Name(), distribution.ErrManifestUnknownRevision{Name: ms.name.Name(), Revision: dgst})
+}
+
+// Enumerate calls ingester for each manifest of the repository. It lists
+// the manifests with the manifests component of the distribution extension
+// if the registry enables it. Otherwise, it falls back to the manifests
+// reachable from the tags of the repository: those the tags point to and,
+// for manifest lists and image indexes, the manifests they list. Untagged
+// manifests are only enumerated in the first case.
+func (ms *manifests) Enumerate(ctx context.Context, ingester func(digest.Digest) error) error {
+	dgsts, err := ms.listManifests(ctx)
+	if err == nil {
+		for _, dgst := range dgsts {
+			if err := ingester(dgst); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != errExtensionUnsupported {
+		return err
+	}
+
+	tags := &tags{client: ms.client, ub: ms.ub, name: ms.name}
+	all, err := tags.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[digest.Digest]bool)
+	var walk func(desc distribution.Descriptor) error
+	walk = func(desc distribution.Descriptor) error {
+		if seen[desc.Digest] {
+			return nil
+		}
+		seen[desc.Digest] = true
+		if err := ingester(desc.Digest); err != nil {
+			return err
+		}
+		if desc.MediaType != manifestlist.MediaTypeManifestList && desc.MediaType != v1.MediaTypeImageIndex {
+			return nil
+		}
+
+		m, err := ms.Get(ctx, desc.Digest)
+		if err != nil {
+			return err
+		}
+		for _, child := range m.References() {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, tag := range all {
+		desc, err := tags.Get(ctx, tag)
+		if err != nil {
+			if errors.As(err, &distribution.ErrTagUnknown{}) {
+				// The tag was removed since it was listed.
+				continue
+			}
+			return err
+		}
+		if err := walk(desc); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// todo(richardscothern): Restore interface and implementation with merge of #1050
-/*func (ms *manifests) Enumerate(ctx context.Context, manifests []distribution.Manifest, last distribution.Manifest) (n int, err error) {
-	panic("not supported")
-}*/
+// errExtensionUnsupported is returned when the registry does not serve an
+// extension route.
+var errExtensionUnsupported = errors.New("extension not supported by the registry")
+
+// listManifests lists the digests of the manifests of the repository with the
+// manifests component of the distribution extension.
+func (ms *manifests) listManifests(ctx context.Context) ([]digest.Digest, error) {
+	u, err := ms.ub.BuildBaseURL()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u+ms.name.Name()+"/_distribution/registry/manifests", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ms.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !SuccessStatus(resp.StatusCode) {
+		err := HandleErrorResponse(resp)
+		var errs errcode.Errors
+		if !errors.As(err, &errs) && resp.StatusCode == http.StatusNotFound {
+			// Registries without the extension answer with a 404 without
+			// error code, as for any unknown route.
+			return nil, errExtensionUnsupported
+		}
+		return nil, err
+	}
+
+	var manifests struct {
+		Digests []digest.Digest `json:"digests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&manifests); err != nil {
+		return nil, err
+	}
+	return manifests.Digests, nil
+}
 
 type blobs struct {
 	name   reference.Named
@@ -826,13 +965,15 @@ func (bs *blobs) Create(ctx context.Context, options ...distribution.BlobCreateO
 			return nil, err
 		}
 
-		return &httpBlobUpload{
+		upload := &httpBlobUpload{
 			statter:   bs.statter,
 			client:    bs.client,
 			uuid:      uuid,
 			startedAt: time.Now(),
 			location:  location,
-		}, nil
+		}
+		upload.setChunkLengths(resp.Header)
+		return upload, nil
 	default:
 		return nil, HandleErrorResponse(resp)
 	}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -20,6 +21,7 @@ import (
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/manifest/schema1"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
@@ -28,6 +30,7 @@ import (
 	"github.com/distribution/distribution/v3/uuid"
 	"github.com/docker/libtrust"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 func testServer(rrm testutil.RequestResponseMap) (string, func()) {
@@ -1466,9 +1469,9 @@ func TestObtainsErrorForMissingTag(t *testing.T) {
 	repo, _ := reference.WithName("test.example.com/repo")
 
 	var m testutil.RequestResponseMap
-	var errors errcode.Errors
-	errors = append(errors, v2.ErrorCodeManifestUnknown.WithDetail("unknown manifest"))
-	errBytes, err := json.Marshal(errors)
+	var respErrors errcode.Errors
+	respErrors = append(respErrors, v2.ErrorCodeManifestUnknown.WithDetail("unknown manifest"))
+	errBytes, err := json.Marshal(respErrors)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1503,6 +1506,17 @@ func TestObtainsErrorForMissingTag(t *testing.T) {
 	if !strings.Contains(err.Error(), "manifest unknown") {
 		t.Fatalf("Expected unknown manifest error message")
 	}
+	var unknown distribution.ErrTagUnknown
+	if !errors.As(err, &unknown) || unknown.Tag != "1.0.0" {
+		t.Fatalf("Expected an unknown tag error, got %#v", err)
+	}
+	if !errors.Is(err, v2.ErrorCodeManifestUnknown) {
+		t.Fatalf("Expected the error to match its error code")
+	}
+	var errs errcode.Errors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("Expected the errors of the response, got %#v", err)
+	}
 }
 
 func TestObtainsManifestForTagWithoutHeaders(t *testing.T) {
@@ -1734,6 +1748,154 @@ func TestCatalogInParts(t *testing.T) {
 	}
 }
 
+func TestCatalogEnumerate(t *testing.T) {
+	var m testutil.RequestResponseMap
+	addTestCatalog(
+		"/v2/_catalog?n=100",
+		[]byte("{\"repositories\":[\"bar\", \"baz\"]}"),
+		"</v2/_catalog?last=baz&n=100>", &m)
+	addTestCatalog(
+		"/v2/_catalog?last=baz&n=100",
+		[]byte("{\"repositories\":[\"foo\"]}"),
+		"", &m)
+
+	e, c := testServer(m)
+	defer c()
+
+	r, err := NewRegistry(e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var repos []string
+	err = r.(distribution.RepositoryEnumerator).Enumerate(context.Background(), func(name string) error {
+		repos = append(repos, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(repos, []string{"bar", "baz", "foo"}) {
+		t.Fatalf("unexpected repositories: %v", repos)
+	}
+}
+
+func TestManifestEnumerateExtension(t *testing.T) {
+	repo, _ := reference.WithName("test.example.com/repo")
+	dgsts := []digest.Digest{digest.FromString("a"), digest.FromString("b")}
+	body, _ := json.Marshal(map[string]interface{}{"name": repo.Name(), "digests": dgsts})
+
+	var m testutil.RequestResponseMap
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method: "GET",
+			Route:  "/v2/" + repo.Name() + "/_distribution/registry/manifests",
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusOK,
+			Body:       body,
+			Headers:    http.Header{"Content-Type": {"application/json"}},
+		},
+	})
+	e, c := testServer(m)
+	defer c()
+
+	enumerated := enumerateManifests(t, repo, e)
+	if !reflect.DeepEqual(enumerated, dgsts) {
+		t.Fatalf("unexpected manifests: %v", enumerated)
+	}
+}
+
+// TestManifestEnumerateTags ensures that the manifests reachable from the
+// tags are enumerated from registries without the manifests extension.
+func TestManifestEnumerateTags(t *testing.T) {
+	repo, _ := reference.WithName("test.example.com/repo")
+	image, child := digest.FromString("image"), digest.FromString("child")
+
+	index, err := manifestlist.FromDescriptorsWithMediaType([]manifestlist.ManifestDescriptor{
+		{Descriptor: distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: image, Size: 5}},
+		{Descriptor: distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: child, Size: 5}},
+	}, v1.MediaTypeImageIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, indexPayload, err := index.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest := digest.FromBytes(indexPayload)
+
+	tagsList := []byte(`{"name": "test.example.com/repo", "tags": ["latest", "multi"]}`)
+	var m testutil.RequestResponseMap
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{Method: "GET", Route: "/v2/" + repo.Name() + "/tags/list"},
+		Response: testutil.Response{
+			StatusCode: http.StatusOK,
+			Body:       tagsList,
+		},
+	})
+	for _, tag := range []struct {
+		name      string
+		mediaType string
+		dgst      digest.Digest
+	}{
+		{"latest", v1.MediaTypeImageManifest, image},
+		{"multi", v1.MediaTypeImageIndex, indexDigest},
+	} {
+		m = append(m, testutil.RequestResponseMapping{
+			Request: testutil.Request{Method: "HEAD", Route: "/v2/" + repo.Name() + "/manifests/" + tag.name},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Headers: http.Header{
+					"Content-Type":          {tag.mediaType},
+					"Content-Length":        {"5"},
+					"Docker-Content-Digest": {tag.dgst.String()},
+				},
+			},
+		})
+	}
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{Method: "GET", Route: "/v2/" + repo.Name() + "/manifests/" + indexDigest.String()},
+		Response: testutil.Response{
+			StatusCode: http.StatusOK,
+			Body:       indexPayload,
+			Headers: http.Header{
+				"Content-Type":          {v1.MediaTypeImageIndex},
+				"Docker-Content-Digest": {indexDigest.String()},
+			},
+		},
+	})
+	e, c := testServer(m)
+	defer c()
+
+	enumerated := enumerateManifests(t, repo, e)
+	if !reflect.DeepEqual(enumerated, []digest.Digest{image, indexDigest, child}) {
+		t.Fatalf("unexpected manifests: %v", enumerated)
+	}
+}
+
+func enumerateManifests(t *testing.T, repo reference.Named, url string) []digest.Digest {
+	r, err := NewRepository(repo, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ms, err := r.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dgsts []digest.Digest
+	err = ms.(distribution.ManifestEnumerator).Enumerate(ctx, func(dgst digest.Digest) error {
+		dgsts = append(dgsts, dgst)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error enumerating manifests: %v", err)
+	}
+	return dgsts
+}
+
 func TestSanitizeLocation(t *testing.T) {
 	for _, testcase := range []struct {
 		description string
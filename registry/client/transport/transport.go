@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"time"
 )
 
 func identityTransportWrapper(rt http.RoundTripper) http.RoundTripper {
@@ -35,13 +36,58 @@ func (h headerModifier) ModifyRequest(req *http.Request) error {
 	return nil
 }
 
+// RequestObserver is called with each request a transport sends, once
+// modified. Returning an error fails the request without sending it, which
+// allows injecting failures.
+type RequestObserver interface {
+	ObserveRequest(req *http.Request) error
+}
+
+// ResponseObserver is called with the outcome of each request a transport
+// sent: the response, whose body must not be read, or the error, and the time
+// the response took to arrive.
+type ResponseObserver interface {
+	ObserveResponse(req *http.Request, resp *http.Response, err error, elapsed time.Duration)
+}
+
+// RetryDecider decides whether a request is sent again after an attempt,
+// numbered from 1, returned resp or err, and the delay to wait before. The
+// response of the attempt is closed before the request is sent again.
+type RetryDecider interface {
+	Retry(req *http.Request, resp *http.Response, err error, attempt int) (bool, time.Duration)
+}
+
+// Hooks instrument the requests of a transport, to add metrics, logging or
+// fault injection without wrapping its http.RoundTripper. The observers
+// are called for every attempt of a request.
+type Hooks struct {
+	Requests  []RequestObserver
+	Responses []ResponseObserver
+
+	// Retry, if set, decides whether requests are sent again. Requests
+	// whose body cannot be obtained again, because their GetBody is not
+	// set, are never sent again.
+	Retry RetryDecider
+}
+
+// DefaultHooks are the hooks of the transports created by NewTransport,
+// including those the registry creates for its own clients.
+var DefaultHooks Hooks
+
 // NewTransport creates a new transport which will apply modifiers to
 // the request on a RoundTrip call.
 func NewTransport(base http.RoundTripper, modifiers ...RequestModifier) http.RoundTripper {
+	return NewTransportWithHooks(base, DefaultHooks, modifiers...)
+}
+
+// NewTransportWithHooks creates a new transport which will apply modifiers
+// to the request on a RoundTrip call, and call hooks.
+func NewTransportWithHooks(base http.RoundTripper, hooks Hooks, modifiers ...RequestModifier) http.RoundTripper {
 	return DefaultTransportWrapper(
 		&transport{
 			Modifiers: modifiers,
 			Base:      base,
+			Hooks:     hooks,
 		})
 }
 
@@ -50,6 +96,7 @@ func NewTransport(base http.RoundTripper, modifiers ...RequestModifier) http.Rou
 type transport struct {
 	Modifiers []RequestModifier
 	Base      http.RoundTripper
+	Hooks     Hooks
 
 	mu     sync.Mutex                      // guards modReq
 	modReq map[*http.Request]*http.Request // original -> modified
@@ -59,15 +106,63 @@ type transport struct {
 // access token. If no token exists or token is expired,
 // tries to refresh/fetch a new token.
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		res, err := t.roundTrip(req, attempt)
+		if t.Hooks.Retry == nil || !replayable(req) {
+			return res, err
+		}
+
+		retry, delay := t.Hooks.Retry.Retry(req, res, err, attempt)
+		if !retry {
+			return res, err
+		}
+		if res != nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// replayable reports whether req can be sent again.
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// roundTrip makes an attempt at the request.
+func (t *transport) roundTrip(req *http.Request, attempt int) (*http.Response, error) {
 	req2 := cloneRequest(req)
+	if attempt > 1 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req2.Body = body
+	}
 	for _, modifier := range t.Modifiers {
 		if err := modifier.ModifyRequest(req2); err != nil {
 			return nil, err
 		}
 	}
+	for _, observer := range t.Hooks.Requests {
+		if err := observer.ObserveRequest(req2); err != nil {
+			return nil, err
+		}
+	}
 
 	t.setModReq(req, req2)
+	start := time.Now()
 	res, err := t.base().RoundTrip(req2)
+	for _, observer := range t.Hooks.Responses {
+		observer.ObserveResponse(req2, res, err, time.Since(start))
+	}
 	if err != nil {
 		t.setModReq(req, nil)
 		return nil, err
@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	requests  []string
+	responses []int
+	errs      int
+	fail      error
+}
+
+func (o *recordingObserver) ObserveRequest(req *http.Request) error {
+	o.requests = append(o.requests, req.Header.Get("X-Test"))
+	return o.fail
+}
+
+func (o *recordingObserver) ObserveResponse(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+	if err != nil {
+		o.errs++
+		return
+	}
+	o.responses = append(o.responses, resp.StatusCode)
+}
+
+// retryUnavailable retries the requests answered with 503 up to max
+// attempts.
+type retryUnavailable struct {
+	max int
+}
+
+func (r retryUnavailable) Retry(req *http.Request, resp *http.Response, err error, attempt int) (bool, time.Duration) {
+	return resp != nil && resp.StatusCode == http.StatusServiceUnavailable && attempt < r.max, time.Millisecond
+}
+
+func TestTransportHooks(t *testing.T) {
+	var served int
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if served < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	observer := &recordingObserver{}
+	client := &http.Client{Transport: NewTransportWithHooks(nil, Hooks{
+		Requests:  []RequestObserver{observer},
+		Responses: []ResponseObserver{observer},
+		Retry:     retryUnavailable{max: 5},
+	}, NewHeaderRequestModifier(http.Header{"X-Test": {"modified"}}))}
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, bytes.NewReader([]byte("content")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated || served != 3 {
+		t.Fatalf("unexpected response after %d attempts: %d", served, resp.StatusCode)
+	}
+	for _, body := range bodies {
+		if body != "content" {
+			t.Errorf("unexpected body sent again: %q", body)
+		}
+	}
+	if len(observer.requests) != 3 || observer.requests[2] != "modified" {
+		t.Errorf("unexpected observed requests: %v", observer.requests)
+	}
+	if len(observer.responses) != 3 || observer.responses[2] != http.StatusCreated {
+		t.Errorf("unexpected observed responses: %v", observer.responses)
+	}
+
+	// Requests whose body cannot be obtained again are not retried.
+	served = 0
+	req, err = http.NewRequest(http.MethodPut, server.URL, io.NopCloser(bytes.NewReader([]byte("content"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || served != 1 {
+		t.Fatalf("unexpected response after %d attempts: %d", served, resp.StatusCode)
+	}
+
+	// Request observers can fail requests before they are sent.
+	served = 0
+	observer.fail = errors.New("injected")
+	if _, err := client.Get(server.URL); !errors.Is(err, observer.fail) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+	if served != 0 {
+		t.Fatalf("expected the failed request not to be sent, served %d", served)
+	}
+}
@@ -0,0 +1,94 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/option"
+)
+
+func init() {
+	Register("bigquery", newBigQuerySink)
+}
+
+// bigQuerySink appends each export to the tables of a BigQuery dataset,
+// created unless they exist, with a load job per table.
+type bigQuerySink struct {
+	client  *bigquery.Client
+	dataset string
+}
+
+func newBigQuerySink(parameters map[string]interface{}) (Sink, error) {
+	var options []option.ClientOption
+	for _, name := range []string{"projectid", "dataset", "keyfile"} {
+		if v, ok := parameters[name]; ok {
+			if s, ok := v.(string); !ok || s == "" {
+				return nil, fmt.Errorf("the %q parameter of the bigquery export sink must be a string", name)
+			}
+		}
+	}
+	projectID, _ := parameters["projectid"].(string)
+	dataset, _ := parameters["dataset"].(string)
+	if projectID == "" || dataset == "" {
+		return nil, fmt.Errorf(`the bigquery export sink requires "projectid" and "dataset" parameters`)
+	}
+	if keyfile, ok := parameters["keyfile"].(string); ok {
+		options = append(options, option.WithCredentialsFile(keyfile))
+	}
+
+	client, err := bigquery.NewClient(context.Background(), projectID, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %v", err)
+	}
+	return &bigQuerySink{client: client, dataset: dataset}, nil
+}
+
+// Export loads the rows of every table of the export, as newline delimited
+// JSON. Tables without rows are skipped.
+func (s *bigQuerySink) Export(ctx context.Context, exportedAt time.Time, tables []Table) error {
+	for _, table := range tables {
+		if len(table.Rows) == 0 {
+			continue
+		}
+		if err := s.load(ctx, table); err != nil {
+			return fmt.Errorf("failed to export table %s: %v", table.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *bigQuerySink) load(ctx context.Context, table Table) error {
+	var buf bytes.Buffer
+	if err := encodeJSONL(&buf, table); err != nil {
+		return err
+	}
+
+	source := bigquery.NewReaderSource(&buf)
+	source.SourceFormat = bigquery.JSON
+	source.Schema = bigQuerySchema(table)
+
+	loader := s.client.Dataset(s.dataset).Table(table.Name).LoaderFrom(source)
+	loader.CreateDisposition = bigquery.CreateIfNeeded
+	loader.WriteDisposition = bigquery.WriteAppend
+	job, err := loader.Run(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// bigQuerySchema returns the schema of table, with nullable columns.
+func bigQuerySchema(table Table) bigquery.Schema {
+	schema := make(bigquery.Schema, len(table.Columns))
+	for i, column := range table.Columns {
+		schema[i] = &bigquery.FieldSchema{Name: column.Name, Type: bigquery.FieldType(bigQueryTypes[column.Type])}
+	}
+	return schema
+}
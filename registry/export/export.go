@@ -0,0 +1,316 @@
+// Package export dumps the metadata of a registry, its repositories, tags,
+// manifests and referrers, along with usage counters, to analytical sinks, so
+// that organizations can query their registries without going through the
+// API.
+//
+// Sinks are registered by name with Register, as storage drivers are, so
+// that sinks for other systems can be compiled into the registry by importing
+// the package which registers them. The postgres and bigquery sinks append
+// the tables to databases, the parquet sink writes Parquet files, the jsonl
+// sink newline delimited JSON files, which most analytical databases load as
+// is, and the sqlscript sink scripts loading the tables into PostgreSQL.
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// The types of the columns of tables. Values of string columns are strings,
+// of integer columns int64, and of timestamp columns time.Time. Any value may
+// be nil.
+const (
+	TypeString    = "string"
+	TypeInteger   = "integer"
+	TypeTimestamp = "timestamp"
+)
+
+// Column describes a column of a table.
+type Column struct {
+	Name string
+	Type string
+}
+
+// Table is a table of an export. Every table has an exported_at column, the
+// time of the export, so that exports can be appended to the same tables.
+type Table struct {
+	Name    string
+	Columns []Column
+	Rows    [][]interface{}
+}
+
+// Sink receives the tables of exports.
+type Sink interface {
+	// Export writes the tables of the export taken at exportedAt.
+	Export(ctx context.Context, exportedAt time.Time, tables []Table) error
+}
+
+// Factory creates a sink from its parameters in the configuration.
+type Factory func(parameters map[string]interface{}) (Sink, error)
+
+var factories = make(map[string]Factory)
+
+// Register makes a sink available by name. It is meant to be called from the
+// init function of sink packages, and panics when the name is registered
+// twice.
+func Register(name string, factory Factory) {
+	if factory == nil {
+		panic("export sink factory cannot be nil")
+	}
+	if _, registered := factories[name]; registered {
+		panic(fmt.Sprintf("export sink named %s already registered", name))
+	}
+	factories[name] = factory
+}
+
+// Create creates the sink registered as name.
+func Create(name string, parameters map[string]interface{}) (Sink, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("export sink not registered: %s", name)
+	}
+	return factory(parameters)
+}
+
+var (
+	exportedAtColumn = Column{Name: "exported_at", Type: TypeTimestamp}
+
+	repositoriesColumns = []Column{
+		{Name: "name", Type: TypeString},
+		{Name: "manifests", Type: TypeInteger},
+		{Name: "tags", Type: TypeInteger},
+		{Name: "size", Type: TypeInteger},
+		exportedAtColumn,
+	}
+	tagsColumns = []Column{
+		{Name: "repository", Type: TypeString},
+		{Name: "tag", Type: TypeString},
+		{Name: "digest", Type: TypeString},
+		exportedAtColumn,
+	}
+	manifestsColumns = []Column{
+		{Name: "repository", Type: TypeString},
+		{Name: "digest", Type: TypeString},
+		{Name: "media_type", Type: TypeString},
+		{Name: "size", Type: TypeInteger},
+		{Name: "artifact_type", Type: TypeString},
+		{Name: "subject", Type: TypeString},
+		exportedAtColumn,
+	}
+	referrersColumns = []Column{
+		{Name: "repository", Type: TypeString},
+		{Name: "subject", Type: TypeString},
+		{Name: "digest", Type: TypeString},
+		{Name: "artifact_type", Type: TypeString},
+		exportedAtColumn,
+	}
+	uploadsColumns = []Column{
+		{Name: "repository", Type: TypeString},
+		{Name: "uploads", Type: TypeInteger},
+		{Name: "size", Type: TypeInteger},
+		{Name: "oldest", Type: TypeTimestamp},
+		exportedAtColumn,
+	}
+)
+
+// Collect reads the metadata of the repositories of registry into the
+// repositories, tags, manifests, referrers and uploads tables. The size of a
+// repository is the total size of its manifests and of the distinct blobs
+// they reference, and the uploads table holds the space taken by the
+// incomplete uploads of the repositories having some, which their size does
+// not include.
+func Collect(ctx context.Context, registry distribution.Namespace, exportedAt time.Time) ([]Table, error) {
+	repositories := Table{Name: "repositories", Columns: repositoriesColumns}
+	tags := Table{Name: "tags", Columns: tagsColumns}
+	manifests := Table{Name: "manifests", Columns: manifestsColumns}
+	referrers := Table{Name: "referrers", Columns: referrersColumns}
+	uploads := Table{Name: "uploads", Columns: uploadsColumns}
+
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+
+		manifestService, err := repository.Manifests(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to construct manifest service: %v", err)
+		}
+		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+		if !ok {
+			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+		}
+
+		var manifestCount int64
+		sizes := make(map[digest.Digest]int64)
+		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			manifest, err := manifestService.Get(ctx, dgst)
+			if err != nil {
+				dcontext.GetLogger(ctx).Warnf("export: skipping unreadable manifest %s@%s: %v", repoName, dgst, err)
+				return nil
+			}
+			mediaType, payload, err := manifest.Payload()
+			if err != nil {
+				return err
+			}
+			manifestCount++
+			sizes[dgst] = int64(len(payload))
+			for _, desc := range manifest.References() {
+				sizes[desc.Digest] = desc.Size
+			}
+
+			var fields struct {
+				ArtifactType string `json:"artifactType"`
+				Config       struct {
+					MediaType string `json:"mediaType"`
+				} `json:"config"`
+				Subject *distribution.Descriptor `json:"subject"`
+			}
+			// Schema 1 manifests have none of the fields.
+			_ = json.Unmarshal(payload, &fields)
+			artifactType := fields.ArtifactType
+			if artifactType == "" {
+				artifactType = fields.Config.MediaType
+			}
+
+			var subject interface{}
+			if fields.Subject != nil {
+				subject = fields.Subject.Digest.String()
+				referrers.Rows = append(referrers.Rows, []interface{}{repoName, subject, dgst.String(), nullable(artifactType), exportedAt})
+			}
+			manifests.Rows = append(manifests.Rows, []interface{}{repoName, dgst.String(), mediaType, int64(len(payload)), nullable(artifactType), subject, exportedAt})
+			return nil
+		})
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			// the repository stores no manifests
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to enumerate manifests of %s: %v", repoName, err)
+		}
+
+		tagService := repository.Tags(ctx)
+		allTags, err := tagService.All(ctx)
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			// the repository has no tags
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to retrieve tags of %s: %v", repoName, err)
+		}
+		sort.Strings(allTags)
+		for _, tag := range allTags {
+			desc, err := tagService.Get(ctx, tag)
+			if err != nil {
+				if _, ok := err.(distribution.ErrTagUnknown); ok {
+					continue
+				}
+				return fmt.Errorf("failed to resolve tag %s of %s: %v", tag, repoName, err)
+			}
+			tags.Rows = append(tags.Rows, []interface{}{repoName, tag, desc.Digest.String(), exportedAt})
+		}
+
+		if repositoryUploads, ok := repository.(storage.RepositoryUploads); ok {
+			usage, err := repositoryUploads.UploadUsage(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to account for the uploads of %s: %v", repoName, err)
+			}
+			if usage.Uploads > 0 {
+				var oldest interface{}
+				if !usage.Oldest.IsZero() {
+					oldest = usage.Oldest.UTC()
+				}
+				uploads.Rows = append(uploads.Rows, []interface{}{repoName, usage.Uploads, usage.Size, oldest, exportedAt})
+			}
+		}
+
+		var size int64
+		for _, s := range sizes {
+			size += s
+		}
+		repositories.Rows = append(repositories.Rows, []interface{}{repoName, manifestCount, int64(len(allTags)), size, exportedAt})
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// the registry stores no repositories
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []Table{repositories, tags, manifests, referrers, uploads}, nil
+}
+
+// nullable returns nil for empty strings.
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Exporter exports the metadata of a registry to sinks.
+type Exporter struct {
+	registry distribution.Namespace
+	sinks    map[string]Sink
+	usage    *Usage
+}
+
+// NewExporter returns an exporter of the metadata of registry to sinks,
+// keyed by their names. The usage table is exported when usage is given.
+func NewExporter(registry distribution.Namespace, sinks map[string]Sink, usage *Usage) *Exporter {
+	return &Exporter{
+		registry: registry,
+		sinks:    sinks,
+		usage:    usage,
+	}
+}
+
+// Export collects the metadata of the registry and writes it to every sink.
+// A sink failing does not keep the others from being written, and the error
+// of the first one failing is returned.
+func (e *Exporter) Export(ctx context.Context) error {
+	exportedAt := time.Now().UTC()
+	tables, err := Collect(ctx, e.registry, exportedAt)
+	if err != nil {
+		return fmt.Errorf("failed to collect metadata: %v", err)
+	}
+	if e.usage != nil {
+		tables = append(tables, e.usage.table(exportedAt))
+	}
+
+	names := make([]string, 0, len(e.sinks))
+	for name := range e.sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var firstErr error
+	for _, name := range names {
+		if err := e.sinks[name].Export(ctx, exportedAt, tables); err != nil {
+			dcontext.GetLogger(ctx).Errorf("export: failed to write to sink %s: %v", name, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to write to sink %s: %v", name, err)
+			}
+		}
+	}
+	return firstErr
+}
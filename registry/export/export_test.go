@@ -0,0 +1,366 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+type image struct {
+	manifest, referrer digest.Digest
+	artifactType       string
+}
+
+func setupRegistry(t *testing.T) (distribution.Namespace, image) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layers, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(repo, layers); err != nil {
+		t.Fatal(err)
+	}
+	var digests []digest.Digest
+	for dgst := range layers {
+		digests = append(digests, dgst)
+	}
+	m, err := testutil.MakeSchema2Manifest(repo, digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var img image
+	img.manifest, err = manifests.Put(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: img.manifest}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrer, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config:  config,
+		Subject: &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: img.manifest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.referrer, err = manifests.Put(ctx, referrer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.artifactType = config.MediaType
+	return registry, img
+}
+
+func TestCollect(t *testing.T) {
+	registry, img := setupRegistry(t)
+	exportedAt := time.Now().UTC()
+
+	ctx := context.Background()
+	named, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := repo.Blobs(ctx).Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload.Write([]byte("incomplete")); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := Collect(ctx, registry, exportedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]Table)
+	for _, table := range tables {
+		byName[table.Name] = table
+		for _, row := range table.Rows {
+			if len(row) != len(table.Columns) {
+				t.Fatalf("row of table %s has %d values for %d columns", table.Name, len(row), len(table.Columns))
+			}
+		}
+	}
+
+	repositories := byName["repositories"].Rows
+	if len(repositories) != 1 || repositories[0][0] != "foo/bar" || repositories[0][1] != int64(2) || repositories[0][2] != int64(1) {
+		t.Fatalf("unexpected repositories: %v", repositories)
+	}
+	if tags := byName["tags"].Rows; !reflect.DeepEqual(tags, [][]interface{}{{"foo/bar", "latest", img.manifest.String(), exportedAt}}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+	if referrers := byName["referrers"].Rows; !reflect.DeepEqual(referrers, [][]interface{}{{"foo/bar", img.manifest.String(), img.referrer.String(), img.artifactType, exportedAt}}) {
+		t.Fatalf("unexpected referrers: %v", referrers)
+	}
+	if manifests := byName["manifests"].Rows; len(manifests) != 2 {
+		t.Fatalf("unexpected manifests: %v", manifests)
+	}
+	if uploads := byName["uploads"].Rows; len(uploads) != 1 || uploads[0][0] != "foo/bar" || uploads[0][1] != int64(1) || uploads[0][2].(int64) < int64(len("incomplete")) || uploads[0][3] == nil {
+		t.Fatalf("unexpected uploads: %v", uploads)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	usage := NewUsage()
+	var event notifications.Event
+	event.Target.MediaType = v1.MediaTypeImageManifest
+	event.Target.Repository = "foo/bar"
+	for _, action := range []string{notifications.EventActionPull, notifications.EventActionPull, notifications.EventActionPush} {
+		event.Action = action
+		if err := usage.Write(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// pulls of blobs are not counted
+	event.Action = notifications.EventActionPull
+	event.Target.MediaType = "application/octet-stream"
+	if err := usage.Write(event); err != nil {
+		t.Fatal(err)
+	}
+
+	since := usage.since
+	exportedAt := time.Now().UTC()
+	table := usage.table(exportedAt)
+	if !reflect.DeepEqual(table.Rows, [][]interface{}{{"foo/bar", int64(2), int64(1), since, exportedAt, exportedAt}}) {
+		t.Fatalf("unexpected usage: %v", table.Rows)
+	}
+	if table = usage.table(time.Now().UTC()); len(table.Rows) != 0 {
+		t.Fatalf("usage not reset: %v", table.Rows)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	registry, img := setupRegistry(t)
+	dir := t.TempDir()
+
+	sinks := make(map[string]Sink)
+	for _, name := range []string{"jsonl", "parquet", "sqlscript"} {
+		sink, err := Create(name, map[string]interface{}{"directory": filepath.Join(dir, name)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sinks[name] = sink
+	}
+	if _, err := Create("jsonl", nil); err == nil {
+		t.Fatal("expected an error creating a sink without directory")
+	}
+
+	if err := NewExporter(registry, sinks, NewUsage()).Export(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	exports, err := filepath.Glob(filepath.Join(dir, "jsonl", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exports) != 1 {
+		t.Fatalf("unexpected exports: %v", exports)
+	}
+	for _, table := range []string{"repositories", "tags", "manifests", "referrers", "uploads", "usage"} {
+		for _, suffix := range []string{".jsonl", ".schema.json"} {
+			if _, err := os.Stat(filepath.Join(exports[0], table+suffix)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	f, err := os.Open(filepath.Join(exports[0], "referrers.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("no referrers exported")
+	}
+	var referrer map[string]interface{}
+	if err := json.Unmarshal(scanner.Bytes(), &referrer); err != nil {
+		t.Fatal(err)
+	}
+	if referrer["subject"] != img.manifest.String() || referrer["digest"] != img.referrer.String() {
+		t.Fatalf("unexpected referrer: %v", referrer)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, referrer["exported_at"].(string)); err != nil {
+		t.Fatalf("unexpected exported_at: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "parquet", "*", "tags.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("unexpected parquet files: %v", files)
+	}
+	rows := readParquet(t, files[0])
+	if len(rows) != 1 || rows[0]["repository"] != "foo/bar" || rows[0]["tag"] != "latest" || rows[0]["digest"] != img.manifest.String() {
+		t.Fatalf("unexpected tags: %v", rows)
+	}
+
+	scripts, err := filepath.Glob(filepath.Join(dir, "sqlscript", "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) != 1 {
+		t.Fatalf("unexpected scripts: %v", scripts)
+	}
+	content, err := os.ReadFile(scripts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := string(content)
+	for _, expected := range []string{
+		"BEGIN;\n",
+		`CREATE TABLE IF NOT EXISTS "tags" ("repository" text, "tag" text, "digest" text, "exported_at" timestamptz);`,
+		`('foo/bar', 'latest', '` + img.manifest.String() + `', '`,
+		`CREATE TABLE IF NOT EXISTS "usage"`,
+		"COMMIT;\n",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Fatalf("script does not contain %q:\n%s", expected, script)
+		}
+	}
+	// the usage table is empty, no rows are inserted
+	if strings.Contains(script, `INSERT INTO "usage"`) {
+		t.Fatalf("unexpected usage rows:\n%s", script)
+	}
+}
+
+// readParquet returns the rows of the Parquet file at path, as JSON objects
+// keyed by column.
+func readParquet(t *testing.T, path string) []map[string]interface{} {
+	f, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pr, err := reader.NewParquetReader(f, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	rows, err := pr.ReadByNumber(int(pr.GetNumRows()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(content, &objects); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader capitalizes the names of the columns, which are lower
+	// case in the file.
+	for i, object := range objects {
+		row := make(map[string]interface{}, len(object))
+		for key, value := range object {
+			row[strings.ToLower(key)] = value
+		}
+		objects[i] = row
+	}
+	return objects
+}
+
+func TestParquetNulls(t *testing.T) {
+	dir := t.TempDir()
+	exportedAt := time.Unix(1700000000, 0).UTC()
+	table := Table{
+		Name:    "referrers",
+		Columns: referrersColumns,
+		Rows:    [][]interface{}{{"foo/bar", "sha256:a", "sha256:b", nil, exportedAt}},
+	}
+	if err := writeParquetTable(dir, table); err != nil {
+		t.Fatal(err)
+	}
+	rows := readParquet(t, filepath.Join(dir, "referrers.parquet"))
+	if len(rows) != 1 {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	if artifactType, ok := rows[0]["artifact_type"]; !ok || artifactType != nil {
+		t.Fatalf("expected a null artifact type: %v", rows[0])
+	}
+	if rows[0]["exported_at"] != float64(exportedAt.UnixMicro()) {
+		t.Fatalf("unexpected exported_at: %v", rows[0])
+	}
+}
+
+func TestSinkParameters(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		parameters map[string]interface{}
+	}{
+		{"parquet", nil},
+		{"sqlscript", map[string]interface{}{"directory": ""}},
+		{"postgres", nil},
+		{"postgres", map[string]interface{}{"dsn": "postgres://localhost/registry", "driver": "unknown"}},
+		{"bigquery", map[string]interface{}{"projectid": "project"}},
+		{"bigquery", map[string]interface{}{"projectid": "project", "dataset": "registry", "keyfile": 1}},
+	} {
+		if _, err := Create(tc.name, tc.parameters); err == nil {
+			t.Errorf("expected an error creating a %s sink with %v", tc.name, tc.parameters)
+		}
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	for value, expected := range map[interface{}]string{
+		nil:             "NULL",
+		int64(42):       "42",
+		"it's":          "'it''s'",
+		time.Unix(0, 0): "'1970-01-01T00:00:00Z'",
+	} {
+		if literal := sqlLiteral(value); literal != expected {
+			t.Errorf("sqlLiteral(%v) = %s, expected %s", value, literal, expected)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	Register("jsonl", newJSONLSink)
+}
+
+// exportName returns the name of the files or directories of the export
+// taken at exportedAt, which sort by time.
+func exportName(exportedAt time.Time) string {
+	return exportedAt.UTC().Format("20060102T150405.000000000Z")
+}
+
+// jsonlSink writes each export into a directory of its own, holding a file
+// of newline delimited JSON objects per table, keyed by column, along with
+// the BigQuery schema of the table.
+type jsonlSink struct {
+	directory string
+}
+
+func newJSONLSink(parameters map[string]interface{}) (Sink, error) {
+	directory, ok := parameters["directory"].(string)
+	if !ok || directory == "" {
+		return nil, fmt.Errorf(`the jsonl export sink requires a "directory" parameter`)
+	}
+	return &jsonlSink{directory: directory}, nil
+}
+
+// bigQueryTypes maps the types of columns to those of BigQuery.
+var bigQueryTypes = map[string]string{
+	TypeString:    "STRING",
+	TypeInteger:   "INTEGER",
+	TypeTimestamp: "TIMESTAMP",
+}
+
+// Export writes the export into a temporary directory, renamed once
+// complete, so that loaders never read partial exports.
+func (s *jsonlSink) Export(ctx context.Context, exportedAt time.Time, tables []Table) error {
+	dir := filepath.Join(s.directory, exportName(exportedAt))
+	tmp := dir + ".tmp"
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err := writeJSONLTable(tmp, table); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, dir)
+}
+
+func writeJSONLTable(dir string, table Table) error {
+	type field struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Mode string `json:"mode"`
+	}
+	schema := make([]field, len(table.Columns))
+	for i, column := range table.Columns {
+		schema[i] = field{Name: column.Name, Type: bigQueryTypes[column.Type], Mode: "NULLABLE"}
+	}
+	content, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, table.Name+".schema.json"), content, 0644); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, table.Name+".jsonl"))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := encodeJSONL(w, table); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// encodeJSONL writes the rows of table to w as newline delimited JSON
+// objects, keyed by column.
+func encodeJSONL(w io.Writer, table Table) error {
+	enc := json.NewEncoder(w)
+	for _, row := range table.Rows {
+		object := make(map[string]interface{}, len(table.Columns))
+		for i, column := range table.Columns {
+			object[column.Name] = row[i]
+		}
+		if err := enc.Encode(object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func init() {
+	Register("parquet", newParquetSink)
+}
+
+// parquetSink writes each export into a directory of its own, holding a
+// Parquet file per table.
+type parquetSink struct {
+	directory string
+}
+
+func newParquetSink(parameters map[string]interface{}) (Sink, error) {
+	directory, ok := parameters["directory"].(string)
+	if !ok || directory == "" {
+		return nil, fmt.Errorf(`the parquet export sink requires a "directory" parameter`)
+	}
+	return &parquetSink{directory: directory}, nil
+}
+
+// parquetTypes maps the types of columns to the tags of Parquet fields.
+// Timestamps are stored in microseconds, as BigQuery and most query engines
+// read them.
+var parquetTypes = map[string]string{
+	TypeString:    "type=BYTE_ARRAY, convertedtype=UTF8",
+	TypeInteger:   "type=INT64",
+	TypeTimestamp: "type=INT64, convertedtype=TIMESTAMP_MICROS",
+}
+
+// Export writes the export into a temporary directory, renamed once
+// complete, so that loaders never read partial exports.
+func (s *parquetSink) Export(ctx context.Context, exportedAt time.Time, tables []Table) error {
+	dir := filepath.Join(s.directory, exportName(exportedAt))
+	tmp := dir + ".tmp"
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err := writeParquetTable(tmp, table); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, dir)
+}
+
+// parquetSchema returns the schema of table, with optional columns, in the
+// JSON form of the Parquet writer.
+func parquetSchema(table Table) (string, error) {
+	type field struct {
+		Tag    string
+		Fields []field `json:",omitempty"`
+	}
+	root := field{Tag: fmt.Sprintf("name=%s, repetitiontype=REQUIRED", table.Name)}
+	for _, column := range table.Columns {
+		if strings.ContainsAny(column.Name, ",=") {
+			return "", fmt.Errorf("invalid column name %q", column.Name)
+		}
+		root.Fields = append(root.Fields, field{Tag: fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", column.Name, parquetTypes[column.Type])})
+	}
+	schema, err := json.Marshal(root)
+	return string(schema), err
+}
+
+func writeParquetTable(dir string, table Table) error {
+	schema, err := parquetSchema(table)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, table.Name+".parquet"))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	pw, err := writer.NewJSONWriterFromWriter(schema, w, 1)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for _, row := range table.Rows {
+		object := make(map[string]interface{}, len(table.Columns))
+		for i, column := range table.Columns {
+			if t, ok := row[i].(time.Time); ok {
+				object[column.Name] = t.UnixMicro()
+			} else {
+				object[column.Name] = row[i]
+			}
+		}
+		record, err := json.Marshal(object)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if err := pw.Write(string(record)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("postgres", newPostgresSink)
+}
+
+// postgresSink inserts each export into tables of a PostgreSQL database,
+// created unless they exist, in a single transaction.
+type postgresSink struct {
+	db *sql.DB
+}
+
+func newPostgresSink(parameters map[string]interface{}) (Sink, error) {
+	dsn, ok := parameters["dsn"].(string)
+	if !ok || dsn == "" {
+		return nil, fmt.Errorf(`the postgres export sink requires a "dsn" parameter`)
+	}
+	driverName := "postgres"
+	if v, ok := parameters["driver"]; ok {
+		if driverName, ok = v.(string); !ok || driverName == "" {
+			return nil, fmt.Errorf(`the "driver" parameter of the postgres export sink must be a string`)
+		}
+	}
+	if !registeredDriver(driverName) {
+		return nil, fmt.Errorf("database/sql driver %q is not compiled into the registry", driverName)
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export database: %v", err)
+	}
+	return &postgresSink{db: db}, nil
+}
+
+// registeredDriver reports whether the database/sql driver named name is
+// registered.
+func registeredDriver(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Export inserts the rows of the export in a transaction, so that queries
+// never see partial exports.
+func (s *postgresSink) Export(ctx context.Context, exportedAt time.Time, tables []Table) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err := insertTable(ctx, tx, table); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to export table %s: %v", table.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func insertTable(ctx context.Context, tx *sql.Tx, table Table) error {
+	columns := make([]string, len(table.Columns))
+	definitions := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		columns[i] = quoteIdentifier(column.Name)
+		definitions[i] = columns[i] + " " + postgresTypes[column.Type]
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quoteIdentifier(table.Name), strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(table.Rows); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(table.Rows) {
+			end = len(table.Rows)
+		}
+
+		tuples := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(table.Columns))
+		for _, row := range table.Rows[start:end] {
+			placeholders := make([]string, len(row))
+			for i, value := range row {
+				args = append(args, value)
+				placeholders[i] = fmt.Sprintf("$%d", len(args))
+			}
+			tuples = append(tuples, "("+strings.Join(placeholders, ", ")+")")
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", quoteIdentifier(table.Name), strings.Join(columns, ", "), strings.Join(tuples, ", ")), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
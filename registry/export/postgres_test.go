@@ -0,0 +1,55 @@
+package export
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// TestPostgresSink exports to the database REGISTRY_EXPORT_DSN, in tables
+// dropped once the test completes, skipping the test if the variable is not
+// set.
+func TestPostgresSink(t *testing.T) {
+	dsn := os.Getenv("REGISTRY_EXPORT_DSN")
+	if dsn == "" {
+		t.Skip("Must set REGISTRY_EXPORT_DSN to run export database tests")
+	}
+	sink, err := Create("postgres", map[string]interface{}{"dsn": dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sink.(*postgresSink).db
+	t.Cleanup(func() {
+		db.Exec(`DROP TABLE "export_test"`)
+		db.Close()
+	})
+
+	ctx := context.Background()
+	table := Table{
+		Name: "export_test",
+		Columns: []Column{
+			{Name: "name", Type: TypeString},
+			{Name: "size", Type: TypeInteger},
+			exportedAtColumn,
+		},
+	}
+	for i := 0; i < sqlBatchSize+1; i++ {
+		table.Rows = append(table.Rows, []interface{}{"foo/bar", int64(i), nil})
+	}
+	for i := 0; i < 2; i++ {
+		if err := sink.Export(ctx, time.Now(), []Table{table}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var count, nulls int
+	if err := db.QueryRow(`SELECT count(*), count(*) - count("exported_at") FROM "export_test"`).Scan(&count, &nulls); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2*(sqlBatchSize+1) || nulls != count {
+		t.Fatalf("unexpected rows: %d, %d null", count, nulls)
+	}
+}
@@ -0,0 +1,116 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("sqlscript", newSQLScriptSink)
+}
+
+// sqlBatchSize is the number of rows inserted by each statement.
+const sqlBatchSize = 1000
+
+// sqlScriptSink writes each export into a script creating the tables, unless
+// they exist, and inserting the rows of the export in a single transaction,
+// as loaded into PostgreSQL by psql.
+type sqlScriptSink struct {
+	directory string
+}
+
+func newSQLScriptSink(parameters map[string]interface{}) (Sink, error) {
+	directory, ok := parameters["directory"].(string)
+	if !ok || directory == "" {
+		return nil, fmt.Errorf(`the sqlscript export sink requires a "directory" parameter`)
+	}
+	return &sqlScriptSink{directory: directory}, nil
+}
+
+// postgresTypes maps the types of columns to those of PostgreSQL.
+var postgresTypes = map[string]string{
+	TypeString:    "text",
+	TypeInteger:   "bigint",
+	TypeTimestamp: "timestamptz",
+}
+
+// Export writes the script into a temporary file, renamed once complete.
+func (s *sqlScriptSink) Export(ctx context.Context, exportedAt time.Time, tables []Table) error {
+	if err := os.MkdirAll(s.directory, 0755); err != nil {
+		return err
+	}
+	p := filepath.Join(s.directory, exportName(exportedAt)+".sql")
+	f, err := os.Create(p + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	writeSQL(w, tables)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(p + ".tmp")
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(p + ".tmp")
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+func writeSQL(w *bufio.Writer, tables []Table) {
+	w.WriteString("BEGIN;\n")
+	for _, table := range tables {
+		columns := make([]string, len(table.Columns))
+		definitions := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+			columns[i] = quoteIdentifier(column.Name)
+			definitions[i] = columns[i] + " " + postgresTypes[column.Type]
+		}
+		fmt.Fprintf(w, "CREATE TABLE IF NOT EXISTS %s (%s);\n", quoteIdentifier(table.Name), strings.Join(definitions, ", "))
+
+		for start := 0; start < len(table.Rows); start += sqlBatchSize {
+			end := start + sqlBatchSize
+			if end > len(table.Rows) {
+				end = len(table.Rows)
+			}
+			fmt.Fprintf(w, "INSERT INTO %s (%s) VALUES\n", quoteIdentifier(table.Name), strings.Join(columns, ", "))
+			for i, row := range table.Rows[start:end] {
+				values := make([]string, len(row))
+				for j, value := range row {
+					values[j] = sqlLiteral(value)
+				}
+				separator := ","
+				if start+i == end-1 {
+					separator = ";"
+				}
+				fmt.Fprintf(w, "(%s)%s\n", strings.Join(values, ", "), separator)
+			}
+		}
+	}
+	w.WriteString("COMMIT;\n")
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func sqlLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return fmt.Sprint(v)
+	case time.Time:
+		return "'" + v.UTC().Format(time.RFC3339Nano) + "'"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	default:
+		return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", "''") + "'"
+	}
+}
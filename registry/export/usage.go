@@ -0,0 +1,99 @@
+package export
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/notifications"
+	events "github.com/docker/go-events"
+)
+
+var usageColumns = []Column{
+	{Name: "repository", Type: TypeString},
+	{Name: "pulls", Type: TypeInteger},
+	{Name: "pushes", Type: TypeInteger},
+	{Name: "since", Type: TypeTimestamp},
+	{Name: "until", Type: TypeTimestamp},
+	exportedAtColumn,
+}
+
+// Usage counts the pulls and pushes of the manifests of each repository,
+// from the events of the registry it is written. Each export reports the
+// counts since the previous one, or since the usage was created.
+type Usage struct {
+	mu            sync.Mutex
+	since         time.Time
+	pulls, pushes map[string]int64
+	manifestTypes map[string]bool
+}
+
+var _ events.Sink = &Usage{}
+
+// NewUsage returns usage counters starting from now.
+func NewUsage() *Usage {
+	manifestTypes := make(map[string]bool)
+	for _, mediaType := range distribution.ManifestMediaTypes() {
+		manifestTypes[mediaType] = true
+	}
+	return &Usage{
+		since:         time.Now().UTC(),
+		pulls:         make(map[string]int64),
+		pushes:        make(map[string]int64),
+		manifestTypes: manifestTypes,
+	}
+}
+
+// Write counts the pulls and pushes of manifests.
+func (u *Usage) Write(event events.Event) error {
+	e, ok := event.(notifications.Event)
+	if !ok || !u.manifestTypes[e.Target.MediaType] {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch e.Action {
+	case notifications.EventActionPull:
+		u.pulls[e.Target.Repository]++
+	case notifications.EventActionPush:
+		u.pushes[e.Target.Repository]++
+	}
+	return nil
+}
+
+// Close implements events.Sink.
+func (u *Usage) Close() error {
+	return nil
+}
+
+// table returns the usage table of the counts until exportedAt, and resets
+// the counts.
+func (u *Usage) table(exportedAt time.Time) Table {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	repositories := make(map[string]struct{})
+	for name := range u.pulls {
+		repositories[name] = struct{}{}
+	}
+	for name := range u.pushes {
+		repositories[name] = struct{}{}
+	}
+	names := make([]string, 0, len(repositories))
+	for name := range repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table := Table{Name: "usage", Columns: usageColumns}
+	for _, name := range names {
+		table.Rows = append(table.Rows, []interface{}{name, u.pulls[name], u.pushes[name], u.since, exportedAt, exportedAt})
+	}
+
+	u.since = exportedAt
+	u.pulls = make(map[string]int64)
+	u.pushes = make(map[string]int64)
+	return table
+}
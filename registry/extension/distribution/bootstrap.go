@@ -0,0 +1,43 @@
+package distribution
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+type bootstrapAPIResponse struct {
+	Name      string                       `json:"name"`
+	Bootstrap *storage.RepositoryBootstrap `json:"bootstrap"`
+}
+
+// bootstrapHandler handles requests for the bootstrap of a repository.
+type bootstrapHandler struct {
+	*extension.Context
+	storageDriver driver.StorageDriver
+}
+
+func (bh *bootstrapHandler) getBootstrap(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	bootstrap, err := storage.GetRepositoryBootstrap(bh.Context, bh.storageDriver, bh.Repository.Named().Name())
+	if err != nil {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(bootstrapAPIResponse{
+		Name:      bh.Repository.Named().Name(),
+		Bootstrap: bootstrap,
+	}); err != nil {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
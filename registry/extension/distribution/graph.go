@@ -0,0 +1,57 @@
+package distribution
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+type graphAPIResponse struct {
+	Name string `json:"name"`
+	storage.Graph
+}
+
+// graphHandler handles requests for the graph rooted at a manifest.
+type graphHandler struct {
+	*extension.Context
+	storageDriver driver.StorageDriver
+}
+
+func (gh *graphHandler) getGraph(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	dgst, err := digest.Parse(r.URL.Query().Get("digest"))
+	if err != nil {
+		gh.Errors = append(gh.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		return
+	}
+
+	graph, err := storage.ManifestGraph(gh.Context, gh.Repository, gh.storageDriver, dgst)
+	if err != nil {
+		switch err := err.(type) {
+		case distribution.ErrManifestUnknownRevision:
+			gh.Errors = append(gh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		default:
+			gh.Errors = append(gh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(graphAPIResponse{
+		Name:  gh.Repository.Named().Name(),
+		Graph: graph,
+	}); err != nil {
+		gh.Errors = append(gh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,56 @@
+package distribution
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+type provenanceAPIResponse struct {
+	Name       string               `json:"name"`
+	Digest     digest.Digest        `json:"digest"`
+	Provenance []storage.Provenance `json:"provenance"`
+}
+
+// provenanceHandler handles requests for the provenance of a manifest.
+type provenanceHandler struct {
+	*extension.Context
+	storageDriver driver.StorageDriver
+}
+
+func (ph *provenanceHandler) getProvenance(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	dgst, err := digest.Parse(r.URL.Query().Get("digest"))
+	if err != nil {
+		ph.Errors = append(ph.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		return
+	}
+
+	provenance, err := storage.ManifestProvenance(ph.Context, ph.storageDriver, ph.Repository.Named().Name(), dgst)
+	if err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if provenance == nil {
+		provenance = []storage.Provenance{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(provenanceAPIResponse{
+		Name:       ph.Repository.Named().Name(),
+		Digest:     dgst,
+		Provenance: provenance,
+	}); err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
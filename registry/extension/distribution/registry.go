@@ -19,14 +19,20 @@ const (
 	extensionName           = "registry"
 	manifestsComponentName  = "manifests"
 	tagHistoryComponentName = "taghistory"
+	provenanceComponentName = "provenance"
+	graphComponentName      = "graph"
+	bootstrapComponentName  = "bootstrap"
 	namespaceUrl            = "insert link"
-	namespaceDescription    = "distribution extension adds tag history and manifest list functionality"
+	namespaceDescription    = "distribution extension adds tag history, manifest list, manifest provenance, manifest graph and repository bootstrap functionality"
 )
 
 type distributionNamespace struct {
 	storageDriver     driver.StorageDriver
 	manifestsEnabled  bool
 	tagHistoryEnabled bool
+	provenanceEnabled bool
+	graphEnabled      bool
+	bootstrapEnabled  bool
 }
 
 type distributionOptions struct {
@@ -49,12 +55,21 @@ func newDistNamespace(ctx context.Context, storageDriver driver.StorageDriver, o
 
 	manifestsEnabled := false
 	tagHistoryEnabled := false
+	provenanceEnabled := false
+	graphEnabled := false
+	bootstrapEnabled := false
 	for _, component := range distOptions.RegExtensionComponents {
 		switch component {
 		case "manifests":
 			manifestsEnabled = true
 		case "taghistory":
 			tagHistoryEnabled = true
+		case "provenance":
+			provenanceEnabled = true
+		case "graph":
+			graphEnabled = true
+		case "bootstrap":
+			bootstrapEnabled = true
 		}
 	}
 
@@ -62,6 +77,9 @@ func newDistNamespace(ctx context.Context, storageDriver driver.StorageDriver, o
 		storageDriver:     storageDriver,
 		manifestsEnabled:  manifestsEnabled,
 		tagHistoryEnabled: tagHistoryEnabled,
+		provenanceEnabled: provenanceEnabled,
+		graphEnabled:      graphEnabled,
+		bootstrapEnabled:  bootstrapEnabled,
 	}, nil
 }
 
@@ -127,6 +145,82 @@ func (d *distributionNamespace) GetRepositoryRoutes() []extension.Route {
 		})
 	}
 
+	if d.provenanceEnabled {
+		routes = append(routes, extension.Route{
+			Namespace: namespaceName,
+			Extension: extensionName,
+			Component: provenanceComponentName,
+			Descriptor: v2.RouteDescriptor{
+				Entity: "Provenance",
+				Methods: []v2.MethodDescriptor{
+					{
+						Method:      "GET",
+						Description: "Get the provenance of the pushes of a manifest: the user, client address, user agent and request of each push, oldest first",
+						Requests: []v2.RequestDescriptor{
+							{
+								QueryParameters: []v2.ParameterDescriptor{
+									{
+										Name:     "digest",
+										Type:     "string",
+										Required: true,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			Dispatcher: d.provenanceDispatcher,
+		})
+	}
+
+	if d.graphEnabled {
+		routes = append(routes, extension.Route{
+			Namespace: namespaceName,
+			Extension: extensionName,
+			Component: graphComponentName,
+			Descriptor: v2.RouteDescriptor{
+				Entity: "Graph",
+				Methods: []v2.MethodDescriptor{
+					{
+						Method:      "GET",
+						Description: "Get the graph rooted at a manifest: the manifests it lists, the blobs they reference, their referrers and subjects, with their sizes",
+						Requests: []v2.RequestDescriptor{
+							{
+								QueryParameters: []v2.ParameterDescriptor{
+									{
+										Name:     "digest",
+										Type:     "string",
+										Required: true,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			Dispatcher: d.graphDispatcher,
+		})
+	}
+
+	if d.bootstrapEnabled {
+		routes = append(routes, extension.Route{
+			Namespace: namespaceName,
+			Extension: extensionName,
+			Component: bootstrapComponentName,
+			Descriptor: v2.RouteDescriptor{
+				Entity: "Bootstrap",
+				Methods: []v2.MethodDescriptor{
+					{
+						Method:      "GET",
+						Description: "Get the template a repository was bootstrapped with when it was created, along with the settings it applied",
+					},
+				},
+			},
+			Dispatcher: d.bootstrapDispatcher,
+		})
+	}
+
 	return routes
 }
 
@@ -162,6 +256,39 @@ func (d *distributionNamespace) tagHistoryDispatcher(ctx *extension.Context, r *
 	}
 }
 
+func (d *distributionNamespace) provenanceDispatcher(ctx *extension.Context, r *http.Request) http.Handler {
+	provenanceHandler := &provenanceHandler{
+		Context:       ctx,
+		storageDriver: d.storageDriver,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(provenanceHandler.getProvenance),
+	}
+}
+
+func (d *distributionNamespace) graphDispatcher(ctx *extension.Context, r *http.Request) http.Handler {
+	graphHandler := &graphHandler{
+		Context:       ctx,
+		storageDriver: d.storageDriver,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(graphHandler.getGraph),
+	}
+}
+
+func (d *distributionNamespace) bootstrapDispatcher(ctx *extension.Context, r *http.Request) http.Handler {
+	bootstrapHandler := &bootstrapHandler{
+		Context:       ctx,
+		storageDriver: d.storageDriver,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(bootstrapHandler.getBootstrap),
+	}
+}
+
 func (d *distributionNamespace) manifestsDispatcher(ctx *extension.Context, r *http.Request) http.Handler {
 	manifestsHandler := &manifestHandler{
 		Context:       ctx,
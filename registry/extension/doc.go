@@ -0,0 +1,30 @@
+// Package extension provides the means to extend the API of the registry with
+// routes served alongside the distribution API.
+//
+// Extension namespaces, registered with Register, are enabled and configured
+// in the extensions section of the configuration, and may provide extended
+// storage to the registry.
+//
+// Vendor routes, registered with RegisterRoute, are mounted below
+// /v2/_ext/<vendor>/ for registry scoped routes and below
+// /v2/<name>/_ext/<vendor>/ for repository scoped routes. They are served by
+// any registry binary which imports the package registering them, so that
+// features such as search or scanning status can be developed out of tree:
+//
+//	func init() {
+//		extension.RegisterRoute(extension.VendorRoute{
+//			Vendor:     "acme",
+//			Path:       "scans/{digest}",
+//			Repository: true,
+//			Dispatcher: func(ctx *extension.Context, r *http.Request) http.Handler {
+//				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//					// ctx.Repository is the repository named in the path.
+//				})
+//			},
+//		})
+//	}
+//
+// Requests to vendor routes are authenticated and authorized by the access
+// controller of the registry before reaching the dispatcher. Routes are
+// listed by the /v2/_ext/discover and /v2/<name>/_ext/discover endpoints.
+package extension
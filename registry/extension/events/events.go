@@ -0,0 +1,144 @@
+// Package events streams the events of a repository to clients as
+// Server-Sent Events, so that user interfaces and operators can watch the
+// activity of a repository without polling it or running a notification
+// endpoint.
+//
+// Importing the package serves the stream at /v2/<name>/_ext/events. Clients
+// need pull access to the repository, and receive the events of that
+// repository only, from the time they connect. Pulls are not streamed.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/gorilla/handlers"
+)
+
+// EventActionAttach is the type of the server-sent events of manifests
+// pushed with a subject, such as signatures and SBOMs.
+const EventActionAttach = "attach"
+
+// bufferSize is the number of events buffered for each client. Clients
+// falling further behind are disconnected, and may reconnect.
+const bufferSize = 256
+
+// keepaliveInterval is the interval between the comments sent to keep idle
+// streams from being closed by proxies.
+var keepaliveInterval = 30 * time.Second
+
+func init() {
+	extension.RegisterRoute(extension.VendorRoute{
+		Vendor:      "events",
+		Description: "Streams the push, mount, delete and attach events of the repository as Server-Sent Events.",
+		Repository:  true,
+		Dispatcher:  eventsDispatcher,
+	})
+}
+
+func eventsDispatcher(ctx *extension.Context, r *http.Request) http.Handler {
+	h := &eventsHandler{Context: ctx}
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(h.streamEvents),
+	}
+}
+
+type eventsHandler struct {
+	*extension.Context
+}
+
+// streamEvents writes the events of the repository until the client goes
+// away. The action query parameter, which may be repeated or list actions
+// separated by commas, restricts the events streamed to those actions.
+func (h *eventsHandler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || h.Events == nil {
+		h.Errors = append(h.Errors, errcode.ErrorCodeUnsupported.WithDetail("event streaming is not supported"))
+		return
+	}
+
+	var actions map[string]bool
+	for _, values := range r.URL.Query()["action"] {
+		for _, action := range strings.Split(values, ",") {
+			if actions == nil {
+				actions = make(map[string]bool)
+			}
+			actions[strings.TrimSpace(action)] = true
+		}
+	}
+
+	subscription, unsubscribe := h.Events.Subscribe(bufferSize)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	name := h.Repository.Named().Name()
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-subscription:
+			if !ok {
+				// The client fell behind, or the registry is stopping.
+				return
+			}
+			if event.Target.Repository != name || event.Action == notifications.EventActionPull {
+				continue
+			}
+			typ := eventType(event)
+			if actions != nil && !actions[typ] {
+				continue
+			}
+			if err := writeEvent(w, typ, redact(event)); err != nil {
+				dcontext.GetLogger(h).Errorf("error writing event: %v", err)
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// eventType returns the type of the server-sent event of the event, which is
+// its action, except for manifests pushed with a subject.
+func eventType(event notifications.Event) string {
+	if event.Action == notifications.EventActionPush && event.Target.Subject != nil {
+		return EventActionAttach
+	}
+	return event.Action
+}
+
+// redact removes the details of the event which clients with pull access to
+// the repository are not entitled to: the address and user agent of the
+// requests of other clients, and the repositories blobs are mounted from.
+func redact(event notifications.Event) notifications.Event {
+	event.Request = notifications.RequestRecord{ID: event.Request.ID, Method: event.Request.Method}
+	event.Target.FromRepository = ""
+	return event
+}
+
+func writeEvent(w http.ResponseWriter, typ string, event notifications.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, typ, data)
+	return err
+}
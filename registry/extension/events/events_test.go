@@ -0,0 +1,100 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func newEvent(action, repository string) notifications.Event {
+	event := notifications.Event{ID: action + " " + repository, Action: action}
+	event.Target.Repository = repository
+	event.Request.Addr = "192.0.2.1:4242"
+	return event
+}
+
+func TestStreamEvents(t *testing.T) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hub := notifications.NewHub()
+	server := httptest.NewServer(eventsDispatcher(&extension.Context{
+		Context:    ctx,
+		Repository: repo,
+		Events:     hub,
+	}, nil))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?action=push,attach&action=mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	attach := newEvent(notifications.EventActionPush, "foo/bar")
+	attach.ID = "attach foo/bar"
+	attach.Target.Subject = &distribution.Descriptor{Digest: digest.FromString("subject")}
+	mount := newEvent(notifications.EventActionMount, "foo/bar")
+	mount.Target.FromRepository = "secret/repository"
+	for _, event := range []notifications.Event{
+		newEvent(notifications.EventActionPull, "foo/bar"),
+		newEvent(notifications.EventActionPush, "other/repository"),
+		newEvent(notifications.EventActionDelete, "foo/bar"),
+		newEvent(notifications.EventActionPush, "foo/bar"),
+		attach,
+		mount,
+	} {
+		if err := hub.Write(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for _, expected := range []struct{ id, typ string }{
+		{"push foo/bar", "push"},
+		{"attach foo/bar", "attach"},
+		{"mount foo/bar", "mount"},
+	} {
+		var lines []string
+		for scanner.Scan() && scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+		if len(lines) != 3 || lines[0] != "id: "+expected.id || lines[1] != "event: "+expected.typ || !strings.HasPrefix(lines[2], "data: ") {
+			t.Fatalf("unexpected event: %q", lines)
+		}
+
+		var event notifications.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.ID != expected.id || event.Request.Addr != "" || event.Target.FromRepository != "" {
+			t.Fatalf("unexpected event data: %+v", event)
+		}
+	}
+}
@@ -7,8 +7,10 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 )
@@ -21,6 +23,12 @@ type Context struct {
 	Registry distribution.Namespace
 	// Repository is a reference to a named repository
 	Repository distribution.Repository
+	// Driver is the storage driver of the registry
+	Driver driver.StorageDriver
+	// ReadRetry retries the reads of the links found missing in Driver
+	ReadRetry storage.ReadRetry
+	// Events passes the events of the registry along to its subscribers
+	Events *notifications.Hub
 	// Errors are the set of errors that occurred within this request context
 	Errors errcode.Errors
 }
@@ -38,6 +46,10 @@ type Route struct {
 	Component string
 	// Descriptor is the route descriptor that gives its path
 	Descriptor v2.RouteDescriptor
+	// Access returns the access required for a request to a registry scoped
+	// route, in addition to authentication. Repository scoped routes are
+	// authorized as other repository requests.
+	Access func(r *http.Request) []auth.Access
 	// Dispatcher if present signifies that the route is http route with a dispatcher
 	Dispatcher RouteDispatchFunc
 }
@@ -0,0 +1,292 @@
+// Package federation provides the federation extension namespace, which
+// presents the repositories and tags of configured upstream registries
+// alongside those of the registry, as a single merged view.
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/gorilla/handlers"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	namespaceName          = "federation"
+	extensionName          = "registry"
+	catalogComponentName   = "catalog"
+	tagsComponentName      = "tags"
+	namespaceUrl           = "https://github.com/distribution/distribution/blob/main/docs/federation.md"
+	namespaceDescription   = "federation extension merges the catalogs and tags of upstream registries with those of the registry"
+	defaultCacheTTL        = time.Minute
+	maximumReturnedEntries = 100
+)
+
+// upstreamNameRegexp matches the names of upstreams, which prefix the names
+// of their repositories in the merged view.
+var upstreamNameRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+type federationNamespace struct {
+	upstreams []*upstream
+	cache     *listCache
+}
+
+type federationOptions struct {
+	CacheTTL  time.Duration     `yaml:"cachettl,omitempty"`
+	Upstreams []upstreamOptions `yaml:"upstreams"`
+}
+
+type upstreamOptions struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// newFederationNamespace creates a new extension namespace with the name
+// "federation"
+func newFederationNamespace(ctx context.Context, storageDriver driver.StorageDriver, options configuration.ExtensionConfig) (extension.Namespace, error) {
+	optionsYaml, err := yaml.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var fedOptions federationOptions
+	err = yaml.Unmarshal(optionsYaml, &fedOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if fedOptions.CacheTTL < 0 {
+		return nil, fmt.Errorf("cachettl must not be negative")
+	}
+	if fedOptions.CacheTTL == 0 {
+		fedOptions.CacheTTL = defaultCacheTTL
+	}
+	if len(fedOptions.Upstreams) == 0 {
+		return nil, fmt.Errorf("no upstreams configured")
+	}
+
+	var upstreams []*upstream
+	names := make(map[string]bool)
+	for _, options := range fedOptions.Upstreams {
+		if !upstreamNameRegexp.MatchString(options.Name) {
+			return nil, fmt.Errorf("invalid upstream name: %q", options.Name)
+		}
+		if names[options.Name] {
+			return nil, fmt.Errorf("duplicate upstream name: %s", options.Name)
+		}
+		names[options.Name] = true
+
+		u, err := url.Parse(options.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid url of upstream %s: %q", options.Name, options.URL)
+		}
+		upstreams = append(upstreams, newUpstream(options.Name, u, options.Username, options.Password))
+	}
+
+	return &federationNamespace{
+		upstreams: upstreams,
+		cache:     newListCache(fedOptions.CacheTTL),
+	}, nil
+}
+
+func init() {
+	// register the extension namespace.
+	extension.Register(namespaceName, newFederationNamespace)
+}
+
+// GetManifestHandlers returns a list of manifest handlers that will be registered in the manifest store.
+func (f *federationNamespace) GetManifestHandlers(repo distribution.Repository, blobStore distribution.BlobStore) []storage.ManifestHandler {
+	// This extension doesn't extend any manifest store operations.
+	return []storage.ManifestHandler{}
+}
+
+// GetRepositoryRoutes returns a list of extension routes scoped at a repository level
+func (f *federationNamespace) GetRepositoryRoutes() []extension.Route {
+	return []extension.Route{
+		{
+			Namespace: namespaceName,
+			Extension: extensionName,
+			Component: tagsComponentName,
+			Descriptor: v2.RouteDescriptor{
+				Entity: "Tags",
+				Methods: []v2.MethodDescriptor{
+					{
+						Method:      "GET",
+						Description: "List the tags of a repository of the merged view, fetched from its upstream when its name starts with the name of an upstream.",
+					},
+				},
+			},
+			Dispatcher: f.tagsDispatcher,
+		},
+	}
+}
+
+// GetRegistryRoutes returns a list of extension routes scoped at a registry level
+func (f *federationNamespace) GetRegistryRoutes() []extension.Route {
+	return []extension.Route{
+		{
+			Namespace: namespaceName,
+			Extension: extensionName,
+			Component: catalogComponentName,
+			Descriptor: v2.RouteDescriptor{
+				Entity: "Catalog",
+				Methods: []v2.MethodDescriptor{
+					{
+						Method:      "GET",
+						Description: "List the repositories of the registry merged with those of the upstreams, prefixed with the name of their upstream. Paginated as the catalog.",
+						Requests: []v2.RequestDescriptor{
+							{
+								QueryParameters: []v2.ParameterDescriptor{
+									{
+										Name:        "n",
+										Type:        "integer",
+										Description: "Maximum number of entries in the response body.",
+									},
+									{
+										Name:        "last",
+										Type:        "string",
+										Description: "The last repository of the previous response.",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			// The merged catalog reveals as much as the catalog.
+			Access: func(r *http.Request) []auth.Access {
+				return []auth.Access{{
+					Resource: auth.Resource{Type: "registry", Name: "catalog"},
+					Action:   "*",
+				}}
+			},
+			Dispatcher: f.catalogDispatcher,
+		},
+	}
+}
+
+// GetNamespaceName returns the name associated with the namespace
+func (f *federationNamespace) GetNamespaceName() string {
+	return namespaceName
+}
+
+// GetNamespaceUrl returns the url link to the documentation where the namespace's extension and endpoints are defined
+func (f *federationNamespace) GetNamespaceUrl() string {
+	return namespaceUrl
+}
+
+// GetNamespaceDescription returns the description associated with the namespace
+func (f *federationNamespace) GetNamespaceDescription() string {
+	return namespaceDescription
+}
+
+func (f *federationNamespace) catalogDispatcher(ctx *extension.Context, r *http.Request) http.Handler {
+	catalogHandler := &catalogHandler{
+		Context:   ctx,
+		namespace: f,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(catalogHandler.getCatalog),
+	}
+}
+
+func (f *federationNamespace) tagsDispatcher(ctx *extension.Context, r *http.Request) http.Handler {
+	tagsHandler := &tagsHandler{
+		Context:   ctx,
+		namespace: f,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(tagsHandler.getTags),
+	}
+}
+
+// upstreamOf returns the upstream of the repository of the merged view named
+// name, and the name of the repository in the upstream, or nil when the
+// repository is local.
+func (f *federationNamespace) upstreamOf(name string) (*upstream, string) {
+	for _, u := range f.upstreams {
+		if strings.HasPrefix(name, u.name+"/") {
+			return u, strings.TrimPrefix(name, u.name+"/")
+		}
+	}
+	return nil, ""
+}
+
+// repositories returns the repositories of the upstream u, from the cache
+// when fetched within the cache ttl.
+func (f *federationNamespace) repositories(ctx context.Context, u *upstream) ([]string, error) {
+	return f.cache.get(u.name, func() ([]string, error) {
+		return u.repositories(ctx)
+	})
+}
+
+// tags returns the tags of the repository name of the upstream u, from the
+// cache when fetched within the cache ttl.
+func (f *federationNamespace) tags(ctx context.Context, u *upstream, name string) ([]string, error) {
+	return f.cache.get(u.name+"/"+name+":tags", func() ([]string, error) {
+		return u.tags(ctx, name)
+	})
+}
+
+// listCache caches the lists fetched from upstreams for a ttl. Failed
+// fetches are not cached.
+type listCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	expires time.Time
+	values  []string
+}
+
+func newListCache(ttl time.Duration) *listCache {
+	return &listCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (c *listCache) get(key string, fetch func() ([]string, error)) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.values, nil
+	}
+
+	values, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry{expires: now.Add(c.ttl), values: values}
+	return values, nil
+}
@@ -0,0 +1,252 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// fakeUpstream serves the catalog and tags of repositories, to clients
+// authenticating with user and password.
+type fakeUpstream struct {
+	tags            map[string][]string
+	catalogRequests int32
+}
+
+func (f *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="upstream"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v2/":
+	case r.URL.Path == "/v2/_catalog":
+		atomic.AddInt32(&f.catalogRequests, 1)
+		var names []string
+		for name := range f.tags {
+			if name > r.URL.Query().Get("last") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		// Serve a single repository per page.
+		if len(names) > 1 {
+			names = names[:1]
+			w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=1>; rel="next"`, names[0]))
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"repositories": names})
+	case strings.HasSuffix(r.URL.Path, "/tags/list"):
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/"), "/tags/list")
+		tags, ok := f.tags[name]
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(errcode.Errors{v2.ErrorCodeNameUnknown})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "tags": tags})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type testEnv struct {
+	ctx       context.Context
+	registry  distribution.Namespace
+	upstream  *fakeUpstream
+	namespace *federationNamespace
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	ctx := context.Background()
+	d := inmemory.New()
+	for _, name := range []string{"alpha", "zeta", "hub/shadowed"} {
+		path := fmt.Sprintf("/docker/registry/v2/repositories/%s/_manifests/tags/latest/current/link", name)
+		if err := d.PutContent(ctx, path, []byte("sha256:0000000000000000000000000000000000000000000000000000000000000000")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := &fakeUpstream{tags: map[string][]string{
+		"library/ubuntu": {"22.04", "20.04"},
+		"library/alpine": {"3"},
+		"team/app":       {},
+	}}
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	unavailable.Close()
+
+	ns, err := newFederationNamespace(ctx, d, map[interface{}]interface{}{
+		"upstreams": []interface{}{
+			map[interface{}]interface{}{"name": "hub", "url": server.URL, "username": "user", "password": "password"},
+			map[interface{}]interface{}{"name": "down", "url": unavailable.URL},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating namespace: %v", err)
+	}
+
+	return &testEnv{
+		ctx:       ctx,
+		registry:  registry,
+		upstream:  upstream,
+		namespace: ns.(*federationNamespace),
+	}
+}
+
+func (env *testEnv) get(t *testing.T, dispatcher extension.RouteDispatchFunc, name, query string, v interface{}) (*httptest.ResponseRecorder, errcode.Errors) {
+	extCtx := &extension.Context{
+		Context:  env.ctx,
+		Registry: env.registry,
+	}
+	if name != "" {
+		named, err := reference.WithName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extCtx.Repository, err = env.registry.Repository(env.ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/v2/_federation/registry/catalog?"+query, nil)
+	w := httptest.NewRecorder()
+	dispatcher(extCtx, r).ServeHTTP(w, r)
+	if len(extCtx.Errors) == 0 && v != nil {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatalf("unexpected error decoding response: %v", err)
+		}
+	}
+	return w, extCtx.Errors
+}
+
+func TestCatalog(t *testing.T) {
+	env := newTestEnv(t)
+
+	expected := []string{"alpha", "hub/library/alpine", "hub/library/ubuntu", "hub/team/app", "zeta"}
+
+	for _, n := range []int{1, 2, 3, 100} {
+		var repositories []string
+		query := url.Values{"n": {strconv.Itoa(n)}}
+		for {
+			var resp catalogAPIResponse
+			w, errs := env.get(t, env.namespace.catalogDispatcher, "", query.Encode(), &resp)
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors listing catalog: %v", errs)
+			}
+			if len(resp.Repositories) > n {
+				t.Fatalf("expected at most %d repositories, got %v", n, resp.Repositories)
+			}
+			if len(resp.Unavailable) != 1 || resp.Unavailable[0] != "down" {
+				t.Fatalf("expected upstream down to be unavailable, got %v", resp.Unavailable)
+			}
+			repositories = append(repositories, resp.Repositories...)
+
+			link := w.Header().Get("Link")
+			if link == "" {
+				break
+			}
+			next, err := url.Parse(strings.Trim(strings.Split(link, ";")[0], "<>"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			query = next.Query()
+		}
+
+		if strings.Join(repositories, ",") != strings.Join(expected, ",") {
+			t.Fatalf("unexpected catalog with %d entries per page: %v", n, repositories)
+		}
+	}
+
+	// The repositories of the upstream were fetched once, and cached.
+	if requests := atomic.LoadInt32(&env.upstream.catalogRequests); requests != 3 {
+		t.Fatalf("expected the upstream catalog to be fetched once in 3 pages, got %d requests", requests)
+	}
+}
+
+func TestTags(t *testing.T) {
+	env := newTestEnv(t)
+
+	for _, tc := range []struct {
+		name     string
+		expected []string
+		err      errcode.ErrorCode
+	}{
+		{name: "alpha", expected: []string{"latest"}},
+		{name: "hub/library/ubuntu", expected: []string{"20.04", "22.04"}},
+		{name: "hub/team/app", expected: []string{}},
+		{name: "hub/missing", err: v2.ErrorCodeNameUnknown},
+		{name: "down/library/ubuntu", err: errcode.ErrorCodeUnavailable},
+		{name: "missing", err: v2.ErrorCodeNameUnknown},
+	} {
+		var resp tagsAPIResponse
+		_, errs := env.get(t, env.namespace.tagsDispatcher, tc.name, "", &resp)
+		if tc.err != (errcode.ErrorCode(0)) {
+			if len(errs) != 1 || errs[0].(errcode.Error).Code != tc.err {
+				t.Errorf("expected error %v listing tags of %s, got %v", tc.err, tc.name, errs)
+			}
+			continue
+		}
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors listing tags of %s: %v", tc.name, errs)
+			continue
+		}
+		if resp.Name != tc.name || strings.Join(resp.Tags, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("unexpected tags of %s: %v", tc.name, resp)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, options := range []map[interface{}]interface{}{
+		{},
+		{"upstreams": []interface{}{map[interface{}]interface{}{"url": "https://example.com"}}},
+		{"upstreams": []interface{}{map[interface{}]interface{}{"name": "Hub", "url": "https://example.com"}}},
+		{"upstreams": []interface{}{map[interface{}]interface{}{"name": "hub", "url": "example.com"}}},
+		{"upstreams": []interface{}{
+			map[interface{}]interface{}{"name": "hub", "url": "https://example.com"},
+			map[interface{}]interface{}{"name": "hub", "url": "https://example.org"},
+		}},
+		{"cachettl": "-1m", "upstreams": []interface{}{map[interface{}]interface{}{"name": "hub", "url": "https://example.com"}}},
+	} {
+		if _, err := newFederationNamespace(context.Background(), inmemory.New(), options); err == nil {
+			t.Errorf("expected an error creating namespace with options %v", options)
+		}
+	}
+
+	ns, err := newFederationNamespace(context.Background(), inmemory.New(), map[interface{}]interface{}{
+		"cachettl":  "5m",
+		"upstreams": []interface{}{map[interface{}]interface{}{"name": "hub", "url": "https://example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating namespace: %v", err)
+	}
+	if ttl := ns.(*federationNamespace).cache.ttl; ttl.Minutes() != 5 {
+		t.Fatalf("unexpected cache ttl: %v", ttl)
+	}
+}
@@ -0,0 +1,201 @@
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+type catalogHandler struct {
+	*extension.Context
+	namespace *federationNamespace
+}
+
+type catalogAPIResponse struct {
+	Repositories []string `json:"repositories"`
+	// Unavailable lists the upstreams whose repositories could not be listed.
+	Unavailable []string `json:"unavailable,omitempty"`
+}
+
+// getCatalog returns a page of the repositories of the registry merged with
+// those of the upstreams. Local repositories whose names start with the name
+// of an upstream are left out, as the tags of these names are those of the
+// upstream. Upstreams which cannot be listed are reported as unavailable
+// rather than failing the request.
+func (ch *catalogHandler) getCatalog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lastEntry := q.Get("last")
+	maxEntries, err := strconv.Atoi(q.Get("n"))
+	if err != nil || maxEntries < 0 {
+		maxEntries = maximumReturnedEntries
+	}
+
+	local := make([]string, maxEntries)
+	filled, err := ch.Registry.Repositories(ch, local, lastEntry)
+	_, pathNotFound := err.(driver.PathNotFoundError)
+	moreEntries := true
+	if err == io.EOF || pathNotFound {
+		moreEntries = false
+	} else if err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	var repos []string
+	for _, name := range local[:filled] {
+		if u, _ := ch.namespace.upstreamOf(name); u == nil {
+			repos = append(repos, name)
+		}
+	}
+
+	var unavailable []string
+	for _, u := range ch.namespace.upstreams {
+		remote, err := ch.namespace.repositories(ch, u)
+		if err != nil {
+			dcontext.GetLogger(ch).Warnf("failed to list repositories of upstream %s: %v", u.name, err)
+			unavailable = append(unavailable, u.name)
+			continue
+		}
+		for _, name := range remote {
+			if name := u.name + "/" + name; name > lastEntry {
+				repos = append(repos, name)
+			}
+		}
+	}
+
+	sort.Strings(repos)
+	if moreEntries && filled > 0 {
+		// Entries after the last local one are listed by the following
+		// pages, along with the local repositories before them.
+		nextEntry := local[filled-1]
+		i := sort.Search(len(repos), func(i int) bool { return repos[i] > nextEntry })
+		repos = repos[:i]
+		lastEntry = nextEntry
+	} else {
+		moreEntries = false
+	}
+	if len(repos) > maxEntries {
+		repos = repos[:maxEntries]
+		lastEntry = repos[maxEntries-1]
+		moreEntries = true
+	}
+	if repos == nil {
+		repos = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Add a link header if there are more entries to retrieve
+	if moreEntries {
+		urlStr, err := createLinkEntry(r.URL.String(), maxEntries, lastEntry)
+		if err != nil {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		w.Header().Set("Link", urlStr)
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(catalogAPIResponse{
+		Repositories: repos,
+		Unavailable:  unavailable,
+	}); err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// Use the original URL from the request to create a new URL for
+// the link header
+func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
+	calledURL, err := url.Parse(origURL)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Add("n", strconv.Itoa(maxEntries))
+	v.Add("last", lastEntry)
+
+	calledURL.RawQuery = v.Encode()
+
+	calledURL.Fragment = ""
+	urlStr := fmt.Sprintf("<%s>; rel=\"next\"", calledURL.String())
+
+	return urlStr, nil
+}
+
+type tagsHandler struct {
+	*extension.Context
+	namespace *federationNamespace
+}
+
+type tagsAPIResponse struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// getTags returns the tags of a repository of the merged view, those of its
+// upstream when its name starts with the name of an upstream.
+func (th *tagsHandler) getTags(w http.ResponseWriter, r *http.Request) {
+	name := th.Repository.Named().Name()
+
+	var tags []string
+	var err error
+	if u, remoteName := th.namespace.upstreamOf(name); u != nil {
+		tags, err = th.namespace.tags(th, u, remoteName)
+		if err != nil {
+			if nameUnknown(err) {
+				th.Errors = append(th.Errors, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": name}))
+			} else {
+				th.Errors = append(th.Errors, errcode.ErrorCodeUnavailable.WithDetail(fmt.Sprintf("upstream %s: %v", u.name, err)))
+			}
+			return
+		}
+	} else {
+		tags, err = th.Repository.Tags(th).All(th)
+		if err != nil {
+			if errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+				th.Errors = append(th.Errors, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": name}))
+			} else if err, ok := err.(errcode.Error); ok {
+				th.Errors = append(th.Errors, err)
+			} else {
+				th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			}
+			return
+		}
+		sort.Strings(tags)
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(tagsAPIResponse{
+		Name: name,
+		Tags: tags,
+	}); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// nameUnknown reports whether err is the error of an upstream which does not
+// know the repository requested.
+func nameUnknown(err error) bool {
+	return errors.Is(err, v2.ErrorCodeNameUnknown)
+}
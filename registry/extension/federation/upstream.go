@@ -0,0 +1,162 @@
+package federation
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/client"
+	"github.com/distribution/distribution/v3/registry/client/auth"
+	"github.com/distribution/distribution/v3/registry/client/auth/challenge"
+	"github.com/distribution/distribution/v3/registry/client/transport"
+)
+
+// catalogPageSize is the number of repositories requested from upstreams at
+// once.
+const catalogPageSize = 1000
+
+// upstream is a registry whose repositories are presented in the merged view,
+// prefixed with its name. Upstreams are accessed with the credentials
+// configured for them, so the merged view holds the repositories and tags
+// these credentials may list.
+type upstream struct {
+	name  string
+	url   *url.URL
+	creds auth.CredentialStore
+
+	mu sync.Mutex
+	cm challenge.Manager
+}
+
+func newUpstream(name string, u *url.URL, username, password string) *upstream {
+	return &upstream{
+		name:  name,
+		url:   u,
+		creds: credentials{username: username, password: password},
+		cm:    challenge.NewSimpleManager(),
+	}
+}
+
+// credentials answers the challenges of an upstream with the username and
+// password configured for it.
+type credentials struct {
+	username string
+	password string
+}
+
+func (c credentials) Basic(u *url.URL) (string, string) {
+	return c.username, c.password
+}
+
+func (c credentials) RefreshToken(u *url.URL, service string) string {
+	return ""
+}
+
+func (c credentials) SetRefreshToken(u *url.URL, service, token string) {
+}
+
+// transport returns a transport authorizing requests to the upstream for
+// scope.
+func (u *upstream) transport(ctx context.Context, scope auth.Scope) (http.RoundTripper, error) {
+	if err := u.establishChallenges(ctx); err != nil {
+		return nil, err
+	}
+
+	tkopts := auth.TokenHandlerOptions{
+		Transport:   http.DefaultTransport,
+		Credentials: u.creds,
+		Scopes:      []auth.Scope{scope},
+		Logger:      dcontext.GetLogger(ctx),
+	}
+	return transport.NewTransport(http.DefaultTransport,
+		auth.NewAuthorizer(u.cm,
+			auth.NewTokenHandlerWithOptions(tkopts),
+			auth.NewBasicHandler(u.creds))), nil
+}
+
+// establishChallenges pings the upstream for its authentication challenges,
+// unless they were already received.
+func (u *upstream) establishChallenges(ctx context.Context) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	base := *u.url
+	base.Path = "/v2/"
+	challenges, err := u.cm.GetChallenges(base)
+	if err != nil {
+		return err
+	}
+	if len(challenges) > 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", base.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return u.cm.AddResponse(resp)
+}
+
+// repositories returns the sorted names of the repositories of the upstream.
+func (u *upstream) repositories(ctx context.Context) ([]string, error) {
+	tr, err := u.transport(ctx, auth.RegistryScope{Name: "catalog", Actions: []string{"*"}})
+	if err != nil {
+		return nil, err
+	}
+	registry, err := client.NewRegistry(u.url.String(), tr)
+	if err != nil {
+		return nil, err
+	}
+
+	var repositories []string
+	var last string
+	for {
+		entries := make([]string, catalogPageSize)
+		n, err := registry.Repositories(ctx, entries, last)
+		repositories = append(repositories, entries[:n]...)
+		if err == io.EOF || (err == nil && n == 0) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		last = entries[n-1]
+	}
+
+	sort.Strings(repositories)
+	return repositories, nil
+}
+
+// tags returns the sorted tags of the repository name of the upstream.
+func (u *upstream) tags(ctx context.Context, name string) ([]string, error) {
+	named, err := reference.WithName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := u.transport(ctx, auth.RepositoryScope{Repository: name, Actions: []string{"pull"}})
+	if err != nil {
+		return nil, err
+	}
+	repository, err := client.NewRepository(named, u.url.String(), tr)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := repository.Tags(ctx).All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
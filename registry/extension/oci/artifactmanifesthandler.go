@@ -7,8 +7,10 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // artifactManifestHandler is a ManifestHandler that covers ORAS Artifacts.
@@ -57,7 +59,7 @@ func (ah *artifactManifestHandler) Put(ctx context.Context, man distribution.Man
 		return "", err
 	}
 
-	err = ah.indexReferrers(ctx, *da, revision.Digest)
+	err = ah.indexReferrers(ctx, *da, mt, revision)
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("error indexing referrers: %v", err)
 		return "", err
@@ -118,23 +120,19 @@ func (amh *artifactManifestHandler) verifyManifest(ctx context.Context, dm Deser
 }
 
 // indexReferrers indexes the subject of the given revision in its referrers index store.
-func (amh *artifactManifestHandler) indexReferrers(ctx context.Context, dm DeserializedManifest, revision digest.Digest) error {
-	// [TODO] We can use artifact type in the link path to support filtering by artifact type
-	//  but need to consider the max path length in different os
-	//artifactType := dm.ArtifactType()
+func (amh *artifactManifestHandler) indexReferrers(ctx context.Context, dm DeserializedManifest, mediaType string, revision distribution.Descriptor) error {
 	subject := dm.Subject()
 	if subject == nil {
 		return nil
 	}
-	subjectRevision := subject.Digest
 
-	rootPath := path.Join(referrersLinkPath(amh.repository.Named().Name()), subjectRevision.Algorithm().String(), subjectRevision.Hex())
-	referenceLinkPath := path.Join(rootPath, revision.Algorithm().String(), revision.Hex(), "link")
-	if err := amh.storageDriver.PutContent(ctx, referenceLinkPath, []byte(revision.String())); err != nil {
-		return err
-	}
-
-	return nil
+	return storage.IndexReferrer(ctx, amh.storageDriver, amh.repository.Named().Name(), subject.Digest, v1.Descriptor{
+		MediaType:    mediaType,
+		Digest:       revision.Digest,
+		Size:         revision.Size,
+		ArtifactType: dm.inner.ArtifactType,
+		Annotations:  dm.inner.Annotations,
+	})
 }
 
 func referrersLinkPath(name string) string {
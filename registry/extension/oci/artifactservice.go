@@ -2,19 +2,19 @@ package oci
 
 import (
 	"context"
-	"path"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type ArtifactService interface {
-	Referrers(ctx context.Context, revision digest.Digest, referrerType string) ([]v1.Descriptor, error)
+	Referrers(ctx context.Context, revision digest.Digest, opts storage.ReferrersOptions) ([]v1.Descriptor, bool, error)
 }
 
 // referrersHandler handles http operations on manifest referrers.
@@ -24,115 +24,59 @@ type referrersHandler struct {
 
 	// Digest is the target manifest's digest.
 	Digest digest.Digest
+
+	// formats are the formats of the responses listing referrers, the
+	// first of which is the default.
+	formats []string
 }
 
-func (h *referrersHandler) Referrers(ctx context.Context, revision digest.Digest, referrerType string) ([]v1.Descriptor, error) {
+// Referrers returns the descriptors of the referrers of the manifest revision
+// narrowed by opts, and whether more referrers follow them.
+func (h *referrersHandler) Referrers(ctx context.Context, revision digest.Digest, opts storage.ReferrersOptions) ([]v1.Descriptor, bool, error) {
 	dcontext.GetLogger(ctx).Debug("(*manifestStore).Referrers")
 
-	var referrers []v1.Descriptor
+	opts.Describe = h.describe
+	opts.Retry = h.extContext.ReadRetry
+	return storage.ListReferrers(ctx, h.storageDriver, h.extContext.Repository.Named().Name(), revision, opts)
+}
 
-	repo := h.extContext.Repository
-	manifests, err := repo.Manifests(ctx)
+// describe returns the descriptor of the referrer manifest revision, for the
+// referrers indexed before their descriptors were stored.
+func (h *referrersHandler) describe(ctx context.Context, revision digest.Digest) (*v1.Descriptor, error) {
+	manifests, err := h.extContext.Repository.Manifests(ctx)
 	if err != nil {
 		return nil, err
 	}
-
-	blobStatter := h.extContext.Registry.BlobStatter()
-	rootPath := path.Join(referrersLinkPath(repo.Named().Name()), revision.Algorithm().String(), revision.Hex())
-	err = h.enumerateReferrerLinks(ctx, rootPath, func(referrerRevision digest.Digest) error {
-		man, err := manifests.Get(ctx, referrerRevision)
-		if err != nil {
-			return err
-		}
-		// need to handle artifact manifest and oci manifest
-		var artifactType string
-		var annotations map[string]string
-		if m, ok := man.(*DeserializedManifest); ok {
-			artifactType = m.inner.ArtifactType
-			annotations = m.inner.Annotations
-		} else if m, ok := man.(*ocischema.DeserializedManifest); ok {
-			artifactType = m.Config.MediaType
-			annotations = m.Annotations
-		} else {
-			return nil
-		}
-
-		desc, err := blobStatter.Stat(ctx, referrerRevision)
-		if err != nil {
-			return err
-		}
-		desc.MediaType, _, _ = man.Payload()
-		referrers = append(referrers, v1.Descriptor{
-			MediaType:    desc.MediaType,
-			Size:         desc.Size,
-			Digest:       desc.Digest,
-			ArtifactType: artifactType,
-			Annotations:  annotations,
-		})
-		return nil
-	})
-
+	man, err := manifests.Get(ctx, revision)
 	if err != nil {
-		switch err.(type) {
-		case driver.PathNotFoundError:
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
 			return nil, nil
 		}
 		return nil, err
 	}
 
-	return referrers, nil
-}
-func (h *referrersHandler) enumerateReferrerLinks(ctx context.Context, rootPath string, ingestor func(digest.Digest) error) error {
-	blobStatter := h.extContext.Registry.BlobStatter()
-
-	return h.storageDriver.Walk(ctx, rootPath, func(fileInfo driver.FileInfo) error {
-		// exit early if directory...
-		if fileInfo.IsDir() {
-			return nil
-		}
-		filePath := fileInfo.Path()
-
-		// check if it's a link
-		_, fileName := path.Split(filePath)
-		if fileName != "link" {
-			return nil
-		}
-
-		// read the digest found in link
-		digest, err := h.readlink(ctx, filePath)
-		if err != nil {
-			return err
-		}
-
-		// ensure this conforms to the linkPathFns
-		_, err = blobStatter.Stat(ctx, digest)
-		if err != nil {
-			// we expect this error to occur so we move on
-			if err == distribution.ErrBlobUnknown {
-				return nil
-			}
-			return err
-		}
-
-		err = ingestor(digest)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
-}
-
-func (h *referrersHandler) readlink(ctx context.Context, path string) (digest.Digest, error) {
-	content, err := h.storageDriver.GetContent(ctx, path)
-	if err != nil {
-		return "", err
+	// need to handle artifact manifest and oci manifest
+	var artifactType string
+	var annotations map[string]string
+	if m, ok := man.(*DeserializedManifest); ok {
+		artifactType = m.inner.ArtifactType
+		annotations = m.inner.Annotations
+	} else if m, ok := man.(*ocischema.DeserializedManifest); ok {
+		artifactType = m.Config.MediaType
+		annotations = m.Annotations
+	} else {
+		return nil, nil
 	}
 
-	linked, err := digest.Parse(string(content))
+	mediaType, payload, err := man.Payload()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return linked, nil
+	return &v1.Descriptor{
+		MediaType:    mediaType,
+		Size:         int64(len(payload)),
+		Digest:       revision,
+		ArtifactType: artifactType,
+		Annotations:  annotations,
+	}, nil
 }
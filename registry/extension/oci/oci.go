@@ -13,7 +13,7 @@ import (
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/gorilla/handlers"
-	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"gopkg.in/yaml.v2"
 )
 
@@ -26,17 +26,27 @@ const (
 
 	artifactsExtensiontName = "artifacts"
 	referrersComponentName  = "referrers"
+	sbomsComponentName      = "sboms"
 )
 
 type ociNamespace struct {
 	storageDriver    driver.StorageDriver
 	discoverEnabled  bool
 	referrersEnabled bool
+	sbomsEnabled     bool
+	sbomTypes        []string
+	referrersFormats []string
 }
 
 type ociOptions struct {
 	RegExtensionComponents      []string `yaml:"ext,omitempty"`
 	ArtifactExtensionComponents []string `yaml:"artifacts,omitempty"`
+	// SBOMTypes lists the artifact types of the referrers listed by the
+	// sboms component.
+	SBOMTypes []string `yaml:"sbomtypes,omitempty"`
+	// ReferrersFormats lists the formats of the responses of the referrers
+	// component, the first of which is served to clients accepting none.
+	ReferrersFormats []string `yaml:"referrersformats,omitempty"`
 }
 
 // newOciNamespace creates a new extension namespace with the name "oci"
@@ -62,18 +72,37 @@ func newOciNamespace(ctx context.Context, storageDriver driver.StorageDriver, op
 	}
 
 	referrersEnabled := false
+	sbomsEnabled := false
 	for _, component := range ociOption.ArtifactExtensionComponents {
 		switch component {
 		case "referrers":
 			referrersEnabled = true
+		case "sboms":
+			sbomsEnabled = true
 		}
 		fmt.Println(component)
 	}
 
+	sbomTypes := ociOption.SBOMTypes
+	if len(sbomTypes) == 0 {
+		sbomTypes = defaultSBOMTypes
+	}
+
+	referrersFormats := ociOption.ReferrersFormats
+	if len(referrersFormats) == 0 {
+		referrersFormats = defaultReferrersFormats
+	}
+	if err := checkReferrersFormats(referrersFormats); err != nil {
+		return nil, err
+	}
+
 	return &ociNamespace{
 		storageDriver:    storageDriver,
 		discoverEnabled:  discoverEnabled,
 		referrersEnabled: referrersEnabled,
+		sbomsEnabled:     sbomsEnabled,
+		sbomTypes:        sbomTypes,
+		referrersFormats: referrersFormats,
 	}, nil
 }
 
@@ -128,7 +157,36 @@ func (o *ociNamespace) GetRepositoryRoutes() []extension.Route {
 				Methods: []v2.MethodDescriptor{
 					{
 						Method:      "GET",
-						Description: "Get all referrers for the given digest. Currently the API doesn't support pagination.",
+						Description: "Get the referrers of the given digest, a page at a time, optionally filtered by artifact type.",
+						Requests: []v2.RequestDescriptor{
+							{
+								Headers: []v2.ParameterDescriptor{
+									{
+										Name:        "Accept",
+										Type:        "string",
+										Description: "The format of the response: an image index, or the artifact list of clients predating the OCI distribution spec 1.1.",
+										Format:      v1.MediaTypeImageIndex,
+									},
+								},
+								QueryParameters: []v2.ParameterDescriptor{
+									{
+										Name:        "artifactType",
+										Type:        "string",
+										Description: "The artifact type of the referrers listed.",
+									},
+									{
+										Name:        "n",
+										Type:        "integer",
+										Description: "The maximum number of referrers listed, at most 100.",
+									},
+									{
+										Name:        "last",
+										Type:        "string",
+										Description: "The digest of the last referrer of the previous page.",
+									},
+								},
+							},
+						},
 					},
 				},
 			},
@@ -136,6 +194,41 @@ func (o *ociNamespace) GetRepositoryRoutes() []extension.Route {
 		})
 	}
 
+	if o.sbomsEnabled {
+		routes = append(routes, extension.Route{
+			Namespace: namespaceName,
+			Extension: artifactsExtensiontName,
+			Component: sbomsComponentName,
+			Descriptor: v2.RouteDescriptor{
+				Entity: "SBOMs",
+				Methods: []v2.MethodDescriptor{
+					{
+						Method:      "GET",
+						Description: "Get the SBOM referrers of a manifest and, when it is an image index, those of each of its manifests.",
+						Requests: []v2.RequestDescriptor{
+							{
+								QueryParameters: []v2.ParameterDescriptor{
+									{
+										Name:        "digest",
+										Type:        "string",
+										Required:    true,
+										Description: "The digest of the manifest.",
+									},
+									{
+										Name:        "artifactType",
+										Type:        "string",
+										Description: "The artifact type of the referrers listed, instead of the SBOM types.",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			Dispatcher: o.sbomsDispatcher,
+		})
+	}
+
 	return routes
 }
 
@@ -196,11 +289,12 @@ func (o *ociNamespace) referrersDispatcher(extCtx *extension.Context, r *http.Re
 	handler := &referrersHandler{
 		storageDriver: o.storageDriver,
 		extContext:    extCtx,
+		formats:       o.referrersFormats,
 	}
-	if dgstStr := dcontext.GetStringValue(extCtx, "vars.digest"); dgstStr == "" {
+	if d, err := dcontext.GetDigest(extCtx); err == dcontext.ErrDigestNotAvailable {
 		dcontext.GetLogger(extCtx).Errorf("digest not available")
-	} else if d, err := digest.Parse(dgstStr); err != nil {
-		dcontext.GetLogger(extCtx).Errorf("error parsing digest=%q: %v", dgstStr, err)
+	} else if err != nil {
+		dcontext.GetLogger(extCtx).Errorf("error parsing digest=%q: %v", dcontext.GetStringValue(extCtx, dcontext.DigestKey), err)
 	} else {
 		handler.Digest = d
 	}
@@ -211,3 +305,17 @@ func (o *ociNamespace) referrersDispatcher(extCtx *extension.Context, r *http.Re
 
 	return mhandler
 }
+
+func (o *ociNamespace) sbomsDispatcher(extCtx *extension.Context, r *http.Request) http.Handler {
+	handler := &sbomsHandler{
+		referrersHandler: &referrersHandler{
+			storageDriver: o.storageDriver,
+			extContext:    extCtx,
+		},
+		sbomTypes: o.sbomTypes,
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(handler.getSBOMs),
+	}
+}
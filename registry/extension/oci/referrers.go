@@ -2,16 +2,29 @@ package oci
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// maximumReturnedReferrers bounds the number of referrers listed per page,
+// when no or a larger number is requested.
+const maximumReturnedReferrers = 100
+
+// filtersAppliedHeader tells clients the filters applied to the referrers
+// listed, as defined by the OCI distribution spec.
+const filtersAppliedHeader = "OCI-Filters-Applied"
+
 // referrersResponse describes the response body of the referrers API.
 //sajayantony - use the index type here.
 // type referrersResponse struct {
@@ -21,43 +34,120 @@ import (
 func (h *referrersHandler) getReferrers(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(h.extContext).Debug("Get")
 
+	q := r.URL.Query()
 	// This can be empty
-	artifactType := r.URL.Query().Get("artifactType")
+	artifactType := q.Get("artifactType")
 
 	if h.Digest == "" {
 		h.extContext.Errors = append(h.extContext.Errors, v2.ErrorCodeManifestUnknown.WithDetail("digest not specified"))
 		return
 	}
 
-	referrers, err := h.Referrers(h.extContext, h.Digest, artifactType)
-	if err != nil {
-		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
-			h.extContext.Errors = append(h.extContext.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
-		} else {
-			h.extContext.Errors = append(h.extContext.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	maxEntries := maximumReturnedReferrers
+	if n := q.Get("n"); n != "" {
+		requested, err := strconv.Atoi(n)
+		if err != nil || requested < 0 {
+			h.extContext.Errors = append(h.extContext.Errors, v2.ErrorCodePaginationNumberInvalid.WithDetail(map[string]string{"n": n}))
+			return
 		}
-		return
+		if requested < maxEntries {
+			maxEntries = requested
+		}
+	}
+
+	var last digest.Digest
+	if lastEntry := q.Get("last"); lastEntry != "" {
+		dgst, err := digest.Parse(lastEntry)
+		if err != nil {
+			h.extContext.Errors = append(h.extContext.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+			return
+		}
+		last = dgst
 	}
 
-	if referrers == nil {
-		referrers = []v1.Descriptor{}
+	referrers := []v1.Descriptor{}
+	var moreEntries bool
+	if maxEntries > 0 {
+		var err error
+		referrers, moreEntries, err = h.Referrers(h.extContext, h.Digest, storage.ReferrersOptions{
+			ArtifactType: artifactType,
+			Last:         last,
+			Limit:        maxEntries,
+		})
+		if err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				h.extContext.Errors = append(h.extContext.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+			} else {
+				h.extContext.Errors = append(h.extContext.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			}
+			return
+		}
 	}
 
-	response := v1.Index{
-		Versioned: specs.Versioned{
-			SchemaVersion: 2,
-		},
-		MediaType:   v1.MediaTypeImageIndex,
-		Manifests:   referrers,
-		Annotations: map[string]string{},
+	formats := h.formats
+	if len(formats) == 0 {
+		formats = defaultReferrersFormats
 	}
+	format := negotiateReferrersFormat(r.Header["Accept"], formats)
 
-	// set Content-Type header to be OCI index media type (manifest list)
-	w.Header().Set("Content-Type", v1.MediaTypeImageIndex)
+	var response interface{}
+	switch format {
+	case referrersFormatArtifactList:
+		response = artifactListResponse{
+			MediaType: mediaTypeArtifactList,
+			Referrers: referrers,
+		}
+	default:
+		response = v1.Index{
+			Versioned: specs.Versioned{
+				SchemaVersion: 2,
+			},
+			MediaType:   v1.MediaTypeImageIndex,
+			Manifests:   referrers,
+			Annotations: map[string]string{},
+		}
+	}
+
+	w.Header().Set("Content-Type", referrersFormatMediaTypes[format])
+	if len(formats) > 1 {
+		w.Header().Add("Vary", "Accept")
+	}
+	if artifactType != "" {
+		w.Header().Set(filtersAppliedHeader, "artifactType")
+	}
+	if moreEntries {
+		urlStr, err := createReferrersLinkEntry(r.URL.String(), artifactType, maxEntries, referrers[len(referrers)-1].Digest)
+		if err != nil {
+			h.extContext.Errors = append(h.extContext.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		w.Header().Set("Link", urlStr)
+	}
 
 	enc := json.NewEncoder(w)
-	if err = enc.Encode(response); err != nil {
+	if err := enc.Encode(response); err != nil {
 		h.extContext.Errors = append(h.extContext.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 }
+
+// createReferrersLinkEntry returns the Link header of the page of referrers
+// following lastEntry, keeping the artifact type filter of the request.
+func createReferrersLinkEntry(origURL string, artifactType string, maxEntries int, lastEntry digest.Digest) (string, error) {
+	calledURL, err := url.Parse(origURL)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	if artifactType != "" {
+		v.Add("artifactType", artifactType)
+	}
+	v.Add("n", strconv.Itoa(maxEntries))
+	v.Add("last", lastEntry.String())
+
+	calledURL.RawQuery = v.Encode()
+
+	calledURL.Fragment = ""
+	return fmt.Sprintf("<%s>; rel=\"next\"", calledURL.String()), nil
+}
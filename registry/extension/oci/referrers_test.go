@@ -0,0 +1,253 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReferrers(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := func(config string, subject *digest.Digest, created string) digest.Digest {
+		m := ocischema.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			Config:    distribution.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromString(config), Size: int64(len(config))},
+		}
+		if subject != nil {
+			m.Config.MediaType = config
+			m.Subject = &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: *subject}
+			m.Annotations = map[string]string{"created": created}
+		}
+		dm, err := ocischema.FromStruct(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dgst, err := manifests.Put(ctx, dm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dgst
+	}
+
+	subject := image("subject", nil, "")
+	var signatures, sboms []digest.Digest
+	for i := 0; i < 3; i++ {
+		created := strconv.Itoa(i)
+		signatures = append(signatures, image("application/vnd.dev.cosign.artifact.sig.v1+json", &subject, created))
+		sboms = append(sboms, image("application/spdx+json", &subject, created))
+	}
+	all := append(append([]digest.Digest{}, signatures...), sboms...)
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	o := &ociNamespace{storageDriver: d, referrersEnabled: true}
+	serve := func(query string, accept string) (*httptest.ResponseRecorder, errcode.Errors) {
+		r := httptest.NewRequest("GET", "/v2/foo/bar/_oci/artifacts/v1/"+subject.String()+"/referrers?"+query, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		r = mux.SetURLVars(r, map[string]string{"digest": subject.String()})
+		extCtx := &extension.Context{
+			Context:    dcontext.WithVars(ctx, r),
+			Registry:   registry,
+			Repository: repo,
+			Driver:     d,
+		}
+		w := httptest.NewRecorder()
+		o.referrersDispatcher(extCtx, r).ServeHTTP(w, r)
+		return w, extCtx.Errors
+	}
+	get := func(query string) (v1.Index, *httptest.ResponseRecorder, errcode.Errors) {
+		w, errs := serve(query, "")
+		var index v1.Index
+		if len(errs) == 0 {
+			if err := json.NewDecoder(w.Body).Decode(&index); err != nil {
+				t.Fatalf("unexpected error decoding response: %v", err)
+			}
+		}
+		return index, w, errs
+	}
+	digests := func(descs []v1.Descriptor) []digest.Digest {
+		digests := []digest.Digest{}
+		for _, desc := range descs {
+			digests = append(digests, desc.Digest)
+		}
+		return digests
+	}
+
+	index, w, errs := get("")
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(index.Manifests) != len(all) {
+		t.Fatalf("expected %d referrers, got %v", len(all), digests(index.Manifests))
+	}
+	for i, desc := range index.Manifests {
+		if desc.Digest != all[i] || desc.MediaType != v1.MediaTypeImageManifest || desc.Size == 0 || desc.Annotations["created"] == "" {
+			t.Errorf("unexpected referrer %d: %+v", i, desc)
+		}
+	}
+	if w.Header().Get(filtersAppliedHeader) != "" || w.Header().Get("Link") != "" {
+		t.Errorf("unexpected headers: %v", w.Header())
+	}
+
+	// The format of the response is negotiated by the Accept header.
+	for accept, mediaType := range map[string]string{
+		"":                    v1.MediaTypeImageIndex,
+		"application/json":    v1.MediaTypeImageIndex,
+		mediaTypeArtifactList: mediaTypeArtifactList,
+		"application/json;q=0.5, " + mediaTypeArtifactList: mediaTypeArtifactList,
+	} {
+		w, errs := serve("", accept)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != mediaType {
+			t.Errorf("unexpected Content-Type accepting %q: %s", accept, ct)
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("expected the response to vary by Accept, got %q", w.Header().Get("Vary"))
+		}
+		if mediaType == mediaTypeArtifactList {
+			var list artifactListResponse
+			if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+				t.Fatal(err)
+			}
+			if list.MediaType != mediaTypeArtifactList || len(list.Referrers) != len(all) {
+				t.Errorf("unexpected artifact list: %+v", list)
+			}
+		}
+	}
+	o.referrersFormats = []string{referrersFormatIndex}
+	if w, _ := serve("", mediaTypeArtifactList); w.Header().Get("Content-Type") != v1.MediaTypeImageIndex || w.Header().Get("Vary") != "" {
+		t.Errorf("expected an image index when the artifact list is not served, got %v", w.Header())
+	}
+	o.referrersFormats = nil
+
+	// Pages follow each other by the Link header, keeping the filter.
+	var listed []digest.Digest
+	query := "artifactType=application/spdx%2Bjson&n=2"
+	for pages := 0; query != ""; pages++ {
+		if pages == 3 {
+			t.Fatal("too many pages")
+		}
+		index, w, errs = get(query)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if w.Header().Get(filtersAppliedHeader) != "artifactType" {
+			t.Errorf("expected the artifactType filter to be applied, got %q", w.Header().Get(filtersAppliedHeader))
+		}
+		for _, desc := range index.Manifests {
+			if desc.ArtifactType != "application/spdx+json" {
+				t.Errorf("unexpected artifact type of referrer %s: %s", desc.Digest, desc.ArtifactType)
+			}
+		}
+		listed = append(listed, digests(index.Manifests)...)
+
+		query = ""
+		if link := w.Header().Get("Link"); link != "" {
+			if !strings.HasSuffix(link, `>; rel="next"`) {
+				t.Fatalf("unexpected Link header: %s", link)
+			}
+			query = link[strings.Index(link, "?")+1 : strings.Index(link, ">")]
+		}
+	}
+	expected := append([]digest.Digest{}, sboms...)
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+	if len(listed) != len(expected) {
+		t.Fatalf("expected the SBOMs %v, got %v", expected, listed)
+	}
+	for i := range expected {
+		if listed[i] != expected[i] {
+			t.Fatalf("expected the SBOMs %v, got %v", expected, listed)
+		}
+	}
+
+	// Referrers indexed before their descriptors were stored are described
+	// by their manifests.
+	descriptorPath := path.Join(referrersLinkPath("foo/bar"), subject.Algorithm().String(), subject.Hex(), all[0].Algorithm().String(), all[0].Hex(), "descriptor")
+	if err := d.Delete(ctx, descriptorPath); err != nil {
+		t.Fatal(err)
+	}
+	index, _, errs = get("n=1")
+	if len(errs) > 0 || len(index.Manifests) != 1 || index.Manifests[0].Digest != all[0] || index.Manifests[0].ArtifactType == "" {
+		t.Fatalf("unexpected referrers: %v, %v", index.Manifests, errs)
+	}
+	if _, err := d.GetContent(ctx, descriptorPath); err != nil {
+		t.Errorf("expected the descriptor to be stored again: %v", err)
+	}
+
+	for query, code := range map[string]errcode.ErrorCode{
+		"n=-1":         v2.ErrorCodePaginationNumberInvalid,
+		"last=invalid": v2.ErrorCodeDigestInvalid,
+	} {
+		_, _, errs := get(query)
+		if len(errs) != 1 || errs[0].(errcode.Error).Code != code {
+			t.Errorf("expected error %v for %s, got %v", code, query, errs)
+		}
+	}
+}
+
+func TestNegotiateReferrersFormat(t *testing.T) {
+	both := []string{referrersFormatIndex, referrersFormatArtifactList}
+	for _, tc := range []struct {
+		accept   []string
+		formats  []string
+		expected string
+	}{
+		{nil, both, referrersFormatIndex},
+		{nil, []string{referrersFormatArtifactList, referrersFormatIndex}, referrersFormatArtifactList},
+		{[]string{"*/*"}, both, referrersFormatIndex},
+		{[]string{v1.MediaTypeImageIndex}, both, referrersFormatIndex},
+		{[]string{mediaTypeArtifactList}, both, referrersFormatArtifactList},
+		{[]string{v1.MediaTypeImageIndex + ";q=0.8", mediaTypeArtifactList}, both, referrersFormatArtifactList},
+		{[]string{v1.MediaTypeImageIndex + ";q=0.8, " + mediaTypeArtifactList + ";q=0.9"}, both, referrersFormatArtifactList},
+		{[]string{"*/*;q=0.1, " + v1.MediaTypeImageIndex + ";q=0"}, both, referrersFormatArtifactList},
+		{[]string{"text/plain"}, []string{referrersFormatArtifactList, referrersFormatIndex}, referrersFormatArtifactList},
+		{[]string{mediaTypeArtifactList}, []string{referrersFormatIndex}, referrersFormatIndex},
+	} {
+		if format := negotiateReferrersFormat(tc.accept, tc.formats); format != tc.expected {
+			t.Errorf("negotiating %v among %v: got %s, expected %s", tc.accept, tc.formats, format, tc.expected)
+		}
+	}
+
+	for _, formats := range [][]string{{"list"}, {referrersFormatIndex, referrersFormatIndex}} {
+		if err := checkReferrersFormats(formats); err == nil {
+			t.Errorf("expected an error checking formats %v", formats)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package oci
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The formats of the responses of the referrers API.
+const (
+	// referrersFormatIndex lists referrers in an image index, as defined by
+	// the OCI distribution spec 1.1.
+	referrersFormatIndex = "index"
+
+	// referrersFormatArtifactList lists referrers in the referrers field of
+	// a JSON object, as clients of the drafts of the spec preceding 1.1
+	// expect.
+	referrersFormatArtifactList = "artifactlist"
+)
+
+// mediaTypeArtifactList is the media type of the artifact list form of the
+// responses of the referrers API.
+const mediaTypeArtifactList = "application/vnd.oci.artifact.referrers.v1+json"
+
+// defaultReferrersFormats are the formats served, unless configured with the
+// referrersformats option.
+var defaultReferrersFormats = []string{referrersFormatIndex, referrersFormatArtifactList}
+
+// referrersFormatMediaTypes are the media types of the formats.
+var referrersFormatMediaTypes = map[string]string{
+	referrersFormatIndex:        v1.MediaTypeImageIndex,
+	referrersFormatArtifactList: mediaTypeArtifactList,
+}
+
+// artifactListResponse is the artifact list form of the responses of the
+// referrers API.
+type artifactListResponse struct {
+	MediaType string          `json:"mediaType"`
+	Referrers []v1.Descriptor `json:"referrers"`
+}
+
+// checkReferrersFormats returns an error if formats lists an unknown format,
+// or one more than once.
+func checkReferrersFormats(formats []string) error {
+	seen := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		if _, ok := referrersFormatMediaTypes[format]; !ok {
+			return fmt.Errorf("unknown referrers format %q", format)
+		}
+		if seen[format] {
+			return fmt.Errorf("duplicate referrers format %q", format)
+		}
+		seen[format] = true
+	}
+	return nil
+}
+
+// negotiateReferrersFormat returns the format of formats the Accept headers
+// accept prefers. A media type accepts its format, and application/json and
+// wildcards accept all formats. Of the formats preferred alike, the first of
+// formats is chosen, and the first is also chosen when none is accepted, as
+// clients may not list the form they expect.
+func negotiateReferrersFormat(accept []string, formats []string) string {
+	best, bestQ := formats[0], 0.0
+	for _, format := range formats {
+		q := acceptQuality(accept, referrersFormatMediaTypes[format])
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality the Accept headers give to mediaType, or
+// zero if they do not accept it.
+func acceptQuality(accept []string, mediaType string) float64 {
+	quality := 0.0
+	for _, header := range accept {
+		for _, value := range strings.Split(header, ",") {
+			accepted, params, err := mime.ParseMediaType(value)
+			if err != nil {
+				continue
+			}
+			switch accepted {
+			case mediaType, "application/json", "application/*", "*/*":
+			default:
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+			// The media type itself takes precedence over the ranges
+			// including it.
+			if accepted == mediaType {
+				return q
+			}
+			if q > quality {
+				quality = q
+			}
+		}
+	}
+	return quality
+}
@@ -0,0 +1,138 @@
+package oci
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// defaultSBOMTypes are the artifact types of the referrers listed as SBOMs,
+// unless configured with the sbomtypes option.
+var defaultSBOMTypes = []string{
+	"application/spdx+json",
+	"text/spdx",
+	"application/vnd.cyclonedx+json",
+	"application/vnd.cyclonedx+xml",
+	"application/vnd.syft+json",
+}
+
+// sbomsResponse describes the response body of the sboms API.
+type sbomsResponse struct {
+	// Digest is the digest of the manifest requested.
+	Digest digest.Digest `json:"digest"`
+	// SBOMs are the SBOMs referring to the manifest requested itself.
+	SBOMs []v1.Descriptor `json:"sboms"`
+	// Manifests are the manifests of the index requested, with their SBOMs.
+	Manifests []manifestSBOMs `json:"manifests"`
+}
+
+type manifestSBOMs struct {
+	MediaType string                     `json:"mediaType"`
+	Digest    digest.Digest              `json:"digest"`
+	Platform  *manifestlist.PlatformSpec `json:"platform,omitempty"`
+	SBOMs     []v1.Descriptor            `json:"sboms"`
+}
+
+// sbomsHandler handles requests for the SBOMs of an image index.
+type sbomsHandler struct {
+	*referrersHandler
+	sbomTypes []string
+}
+
+// getSBOMs returns the SBOM referrers of a manifest and, when it is an index,
+// those of each of its manifests, so that clients need not query the
+// referrers of each platform.
+func (h *sbomsHandler) getSBOMs(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(h.extContext).Debug("GetSBOMs")
+
+	q := r.URL.Query()
+	dgst, err := digest.Parse(q.Get("digest"))
+	if err != nil {
+		h.extContext.Errors = append(h.extContext.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		return
+	}
+	// This can be empty
+	artifactType := q.Get("artifactType")
+
+	manifests, err := h.extContext.Repository.Manifests(h.extContext)
+	if err != nil {
+		h.extContext.Errors = append(h.extContext.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	manifest, err := manifests.Get(h.extContext, dgst)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			h.extContext.Errors = append(h.extContext.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		} else {
+			h.extContext.Errors = append(h.extContext.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	response := sbomsResponse{
+		Digest:    dgst,
+		Manifests: []manifestSBOMs{},
+	}
+	if response.SBOMs, err = h.sboms(dgst, artifactType); err != nil {
+		h.extContext.Errors = append(h.extContext.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	if index, ok := manifest.(*manifestlist.DeserializedManifestList); ok {
+		for _, m := range index.Manifests {
+			entry := manifestSBOMs{
+				MediaType: m.MediaType,
+				Digest:    m.Digest,
+			}
+			if m.Platform.OS != "" || m.Platform.Architecture != "" {
+				platform := m.Platform
+				entry.Platform = &platform
+			}
+			if entry.SBOMs, err = h.sboms(m.Digest, artifactType); err != nil {
+				h.extContext.Errors = append(h.extContext.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				return
+			}
+			response.Manifests = append(response.Manifests, entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(response); err != nil {
+		h.extContext.Errors = append(h.extContext.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// sboms returns the referrers of the manifest dgst whose artifact type is one
+// of the SBOM types, or artifactType when set.
+func (h *sbomsHandler) sboms(dgst digest.Digest, artifactType string) ([]v1.Descriptor, error) {
+	referrers, _, err := h.Referrers(h.extContext, dgst, storage.ReferrersOptions{ArtifactType: artifactType})
+	if err != nil {
+		return nil, err
+	}
+
+	if artifactType != "" {
+		return referrers, nil
+	}
+
+	sboms := []v1.Descriptor{}
+	for _, referrer := range referrers {
+		for _, t := range h.sbomTypes {
+			if referrer.ArtifactType == t {
+				sboms = append(sboms, referrer)
+				break
+			}
+		}
+	}
+	return sboms, nil
+}
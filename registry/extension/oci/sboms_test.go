@@ -0,0 +1,163 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSBOMs(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(m distribution.Manifest) digest.Digest {
+		dgst, err := manifests.Put(ctx, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dgst
+	}
+	image := func(config string, subject *digest.Digest) digest.Digest {
+		m := ocischema.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			Config:    distribution.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromString(config), Size: int64(len(config))},
+		}
+		if subject != nil {
+			m.Config.MediaType = config
+			m.Subject = &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: *subject}
+		}
+		dm, err := ocischema.FromStruct(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return put(dm)
+	}
+
+	amd64 := image("amd64", nil)
+	arm64 := image("arm64", nil)
+	index, err := manifestlist.FromDescriptorsWithMediaType([]manifestlist.ManifestDescriptor{
+		{
+			Descriptor: distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: amd64, Size: 1},
+			Platform:   manifestlist.PlatformSpec{OS: "linux", Architecture: "amd64"},
+		},
+		{
+			Descriptor: distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: arm64, Size: 1},
+			Platform:   manifestlist.PlatformSpec{OS: "linux", Architecture: "arm64"},
+		},
+	}, v1.MediaTypeImageIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest := put(index)
+
+	spdx := image("application/spdx+json", &amd64)
+	cyclonedx := image("application/vnd.cyclonedx+json", &arm64)
+	image("application/vnd.dev.cosign.artifact.sig.v1+json", &arm64)
+	indexSBOM := image("application/spdx+json", &indexDigest)
+
+	o := &ociNamespace{storageDriver: d, sbomsEnabled: true, sbomTypes: defaultSBOMTypes}
+	get := func(query string) (sbomsResponse, errcode.Errors) {
+		extCtx := &extension.Context{
+			Context:    ctx,
+			Registry:   registry,
+			Repository: repo,
+			Driver:     d,
+		}
+		r := httptest.NewRequest("GET", "/v2/foo/bar/_oci/artifacts/sboms?"+query, nil)
+		w := httptest.NewRecorder()
+		o.sbomsDispatcher(extCtx, r).ServeHTTP(w, r)
+
+		var resp sbomsResponse
+		if len(extCtx.Errors) == 0 {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("unexpected error decoding response: %v", err)
+			}
+		}
+		return resp, extCtx.Errors
+	}
+	digests := func(descs []v1.Descriptor) []digest.Digest {
+		var digests []digest.Digest
+		for _, desc := range descs {
+			digests = append(digests, desc.Digest)
+		}
+		return digests
+	}
+
+	resp, errs := get("digest=" + indexDigest.String())
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(resp.SBOMs) != 1 || resp.SBOMs[0].Digest != indexSBOM {
+		t.Fatalf("unexpected SBOMs of the index: %v", digests(resp.SBOMs))
+	}
+	if len(resp.Manifests) != 2 {
+		t.Fatalf("expected the SBOMs of 2 manifests, got %v", resp.Manifests)
+	}
+	for i, expected := range []struct {
+		digest       digest.Digest
+		architecture string
+		sbom         digest.Digest
+	}{
+		{amd64, "amd64", spdx},
+		{arm64, "arm64", cyclonedx},
+	} {
+		m := resp.Manifests[i]
+		if m.Digest != expected.digest || m.Platform == nil || m.Platform.Architecture != expected.architecture {
+			t.Errorf("unexpected manifest %d: %v", i, m)
+		}
+		if len(m.SBOMs) != 1 || m.SBOMs[0].Digest != expected.sbom {
+			t.Errorf("unexpected SBOMs of manifest %s: %v", m.Digest, digests(m.SBOMs))
+		}
+	}
+
+	resp, errs = get("artifactType=application/vnd.cyclonedx%2Bjson&digest=" + indexDigest.String())
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(resp.SBOMs) != 0 || len(resp.Manifests[0].SBOMs) != 0 || len(resp.Manifests[1].SBOMs) != 1 {
+		t.Fatalf("expected only the CycloneDX SBOM, got %v", resp)
+	}
+
+	// A manifest which is not an index lists its own SBOMs.
+	resp, errs = get("digest=" + amd64.String())
+	if len(errs) > 0 || len(resp.SBOMs) != 1 || len(resp.Manifests) != 0 {
+		t.Fatalf("unexpected SBOMs of an image: %v %v", resp, errs)
+	}
+
+	for query, code := range map[string]errcode.ErrorCode{
+		"digest=invalid": v2.ErrorCodeDigestInvalid,
+		"digest=" + digest.FromString("none").String(): v2.ErrorCodeManifestUnknown,
+	} {
+		_, errs := get(query)
+		if len(errs) != 1 || errs[0].(errcode.Error).Code != code {
+			t.Errorf("expected error %v for %s, got %v", code, query, errs)
+		}
+	}
+}
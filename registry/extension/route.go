@@ -0,0 +1,90 @@
+package extension
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// vendorRegexp matches the names of vendors of extension routes.
+var vendorRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// VendorRoute is a route mounted by a compiled-in extension below
+// /v2/_ext/<vendor>/ when registry scoped, or below
+// /v2/<name>/_ext/<vendor>/ when scoped to a repository. Unlike extension
+// namespaces, vendor routes do not need to be enabled in the configuration,
+// which makes them suited to features developed out of tree, such as search or
+// scanning status, compiled into the registry by importing the package which
+// registers them.
+type VendorRoute struct {
+	// Vendor names the extension, such as "acme" or "example.com". It must
+	// be made of lower case alphanumeric components separated by periods,
+	// underscores or hyphens.
+	Vendor string
+
+	// Path is the path of the route below the vendor. It may contain
+	// variables in the syntax of gorilla/mux, such as "scans/{digest}",
+	// available to the dispatcher with mux.Vars.
+	Path string
+
+	// Description describes the route in the API descriptor.
+	Description string
+
+	// Repository scopes the route to a repository. Its requests are then
+	// authorized as other repository requests: pull access is required for
+	// GET and HEAD requests, and push access for requests making changes.
+	Repository bool
+
+	// Access returns the access required for a request to a registry scoped
+	// route. When nil, registry scoped routes only require the client to
+	// authenticate, when authentication is enabled.
+	Access func(r *http.Request) []auth.Access
+
+	// Dispatcher returns the handler of a request. The extension context
+	// provides the repository of repository scoped routes, and the registry
+	// and storage driver of the application.
+	Dispatcher RouteDispatchFunc
+}
+
+var vendorRoutes []VendorRoute
+
+// RegisterRoute registers a vendor route. It is meant to be called from the
+// init function of an extension package, and panics when the route is
+// invalid or a route with the same scope and path was already registered.
+func RegisterRoute(route VendorRoute) {
+	if !vendorRegexp.MatchString(route.Vendor) {
+		panic(fmt.Sprintf("invalid extension vendor: %q", route.Vendor))
+	}
+	if route.Vendor == "discover" {
+		panic("extension vendor name is reserved: discover")
+	}
+	if strings.HasPrefix(route.Path, "/") || strings.HasSuffix(route.Path, "/") {
+		panic(fmt.Sprintf("extension route path must not start or end with a slash: %q", route.Path))
+	}
+	if route.Dispatcher == nil {
+		panic(fmt.Sprintf("extension route has no dispatcher: %s/%s", route.Vendor, route.Path))
+	}
+
+	for _, r := range vendorRoutes {
+		if r.Repository == route.Repository && r.Vendor == route.Vendor && r.Path == route.Path {
+			panic(fmt.Sprintf("extension route already registered: %s/%s", route.Vendor, route.Path))
+		}
+	}
+
+	vendorRoutes = append(vendorRoutes, route)
+}
+
+// VendorRoutes returns the registered vendor routes.
+func VendorRoutes() []VendorRoute {
+	return vendorRoutes
+}
+
+// UserName returns the name of the user the request was authenticated as, or
+// an empty string when authentication is disabled.
+func (c *Context) UserName() string {
+	return dcontext.GetUserName(c)
+}
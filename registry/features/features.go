@@ -0,0 +1,95 @@
+// Package features rolls experimental features of the registry out
+// incrementally, enabling them for the repositories of namespaces and for a
+// percentage of the other repositories, as configured in the features section
+// of the configuration, so that they can be tried on shared registries before
+// being enabled for all repositories.
+//
+// The repositories a percentage enables are chosen by hashing their names
+// along with the name of the feature, so that each feature is tried on
+// different repositories, and a repository enabled at a percentage stays
+// enabled as the percentage is raised.
+package features
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// buckets is the number of buckets repositories are hashed into, giving
+// percentages a resolution of a hundredth.
+const buckets = 10000
+
+// Flags tells the features enabled for each repository. A nil *Flags enables
+// no feature.
+type Flags struct {
+	flags map[string]flag
+}
+
+type flag struct {
+	namespaces []string
+	buckets    uint32
+}
+
+// New returns the flags of the configuration, for the features named in
+// known, the features the registry implements.
+func New(config map[string]configuration.FeatureFlag, known []string) (*Flags, error) {
+	knownFeatures := make(map[string]bool, len(known))
+	for _, name := range known {
+		knownFeatures[name] = true
+	}
+
+	f := &Flags{flags: make(map[string]flag, len(config))}
+	for name, c := range config {
+		if !knownFeatures[name] {
+			names := append([]string(nil), known...)
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown feature %q, known features are %s", name, strings.Join(names, ", "))
+		}
+		if c.Percent < 0 || c.Percent > 100 {
+			return nil, fmt.Errorf("feature %q: percent must be between 0 and 100", name)
+		}
+		namespaces := make([]string, 0, len(c.Namespaces))
+		for _, namespace := range c.Namespaces {
+			if namespace = strings.Trim(namespace, "/"); namespace == "" {
+				return nil, fmt.Errorf("feature %q: namespaces cannot be empty", name)
+			}
+			namespaces = append(namespaces, namespace)
+		}
+		f.flags[name] = flag{
+			namespaces: namespaces,
+			buckets:    uint32(c.Percent * buckets / 100),
+		}
+	}
+	return f, nil
+}
+
+// Enabled reports whether feature is enabled for the repository.
+func (f *Flags) Enabled(feature, repository string) bool {
+	if f == nil {
+		return false
+	}
+	fl, ok := f.flags[feature]
+	if !ok {
+		return false
+	}
+
+	for _, namespace := range fl.namespaces {
+		if repository == namespace || strings.HasPrefix(repository, namespace+"/") {
+			return true
+		}
+	}
+	return fl.buckets > 0 && bucket(feature, repository) < fl.buckets
+}
+
+// bucket returns the bucket of the repository for feature.
+func bucket(feature, repository string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(feature))
+	h.Write([]byte{0})
+	h.Write([]byte(repository))
+	return h.Sum32() % buckets
+}
@@ -0,0 +1,76 @@
+package features
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+var known = []string{"taghistory", "linkmetadata"}
+
+func TestNew(t *testing.T) {
+	for _, config := range []map[string]configuration.FeatureFlag{
+		{"unknown": {Percent: 10}},
+		{"taghistory": {Percent: 101}},
+		{"taghistory": {Percent: -1}},
+		{"taghistory": {Namespaces: []string{"/"}}},
+	} {
+		if _, err := New(config, known); err == nil {
+			t.Errorf("expected an error configuring %v", config)
+		}
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	var flags *Flags
+	if flags.Enabled("taghistory", "foo/bar") {
+		t.Error("expected no feature enabled without flags")
+	}
+
+	newFlags := func(config map[string]configuration.FeatureFlag) *Flags {
+		flags, err := New(config, known)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return flags
+	}
+	flags = newFlags(map[string]configuration.FeatureFlag{
+		"taghistory": {Namespaces: []string{"team/"}},
+	})
+	for repository, expected := range map[string]bool{
+		"team":       true,
+		"team/app":   true,
+		"team/a/b":   true,
+		"teams/app":  false,
+		"other/team": false,
+	} {
+		if enabled := flags.Enabled("taghistory", repository); enabled != expected {
+			t.Errorf("unexpected flag of %s: %t", repository, enabled)
+		}
+	}
+	if flags.Enabled("linkmetadata", "team/app") {
+		t.Error("expected a feature not configured to be disabled")
+	}
+
+	// Repositories enabled at a percentage stay enabled at higher ones.
+	quarter := newFlags(map[string]configuration.FeatureFlag{"taghistory": {Percent: 25}})
+	half := newFlags(map[string]configuration.FeatureFlag{"taghistory": {Percent: 50}})
+	all := newFlags(map[string]configuration.FeatureFlag{"taghistory": {Percent: 100}})
+	var enabled int
+	for i := 0; i < 1000; i++ {
+		repository := fmt.Sprintf("repo/%d", i)
+		if quarter.Enabled("taghistory", repository) {
+			enabled++
+			if !half.Enabled("taghistory", repository) {
+				t.Fatalf("expected %s to stay enabled at a higher percentage", repository)
+			}
+		}
+		if !all.Enabled("taghistory", repository) {
+			t.Fatalf("expected %s to be enabled at 100 percent", repository)
+		}
+	}
+	if enabled < 200 || enabled > 300 {
+		t.Errorf("expected about a quarter of the repositories to be enabled, got %d of 1000", enabled)
+	}
+}
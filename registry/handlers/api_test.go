@@ -565,6 +565,149 @@ func TestBlobDeleteDisabled(t *testing.T) {
 	checkResponse(t, "status of disabled delete", resp, http.StatusMethodNotAllowed)
 }
 
+func TestBlobUploadChunkLengths(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Uploads.MinChunkLength = 8
+	config.Uploads.MaxChunkLength = 32
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/chunks")
+	checkErr(t, err, "building image name")
+	content := bytes.Repeat([]byte("chunk"), 10)[:48]
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+
+	// A chunk longer than the maximum is refused, as the upload stands.
+	resp, err := doPushChunk(t, uploadURLBase, bytes.NewReader(content), chunkOptions{contentRange: "0-47"})
+	checkErr(t, err, "pushing a chunk too long")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing a chunk too long", resp, http.StatusRequestedRangeNotSatisfiable)
+	checkHeaders(t, resp, http.Header{
+		"Range":                []string{"0-0"},
+		"OCI-Chunk-Min-Length": []string{"8"},
+		"OCI-Chunk-Max-Length": []string{"32"},
+	})
+	checkBodyHasErrorCodes(t, "pushing a chunk too long", resp, v2.ErrorCodeRangeInvalid)
+
+	resp, err = doPushChunk(t, uploadURLBase, bytes.NewReader(content[:32]), chunkOptions{contentRange: "0-31"})
+	checkErr(t, err, "pushing a chunk")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing a chunk", resp, http.StatusAccepted)
+	checkHeaders(t, resp, http.Header{
+		"Range":                []string{"0-31"},
+		"OCI-Chunk-Min-Length": []string{"8"},
+		"OCI-Chunk-Max-Length": []string{"32"},
+	})
+
+	resp, err = doPushChunk(t, resp.Header.Get("Location"), bytes.NewReader(content[32:]), chunkOptions{contentRange: "32-47"})
+	checkErr(t, err, "pushing the last chunk")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing the last chunk", resp, http.StatusAccepted)
+	finishUpload(t, env.builder, imageName, resp.Header.Get("Location"), digest.FromBytes(content))
+}
+
+func TestBlobUploadOverlappingChunks(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/overlap")
+	checkErr(t, err, "building image name")
+	content := []byte("0123456789abcdefghij")
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	resp, err := doPushChunk(t, uploadURLBase, bytes.NewReader(content[:10]), chunkOptions{contentRange: "0-9"})
+	checkErr(t, err, "pushing a chunk")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing a chunk", resp, http.StatusAccepted)
+	uploadURLBase = resp.Header.Get("Location")
+
+	// A chunk sent again, or sent ahead of the data received, is refused
+	// with the range received so far.
+	for _, chunk := range []struct {
+		contentRange string
+		body         []byte
+	}{
+		{"0-9", content[:10]},
+		{"5-14", content[5:15]},
+		{"12-19", content[12:]},
+	} {
+		resp, err := doPushChunk(t, uploadURLBase, bytes.NewReader(chunk.body), chunkOptions{contentRange: chunk.contentRange})
+		checkErr(t, err, "pushing an out of order chunk")
+		defer resp.Body.Close()
+		checkResponse(t, "pushing chunk "+chunk.contentRange, resp, http.StatusRequestedRangeNotSatisfiable)
+		checkHeaders(t, resp, http.Header{
+			"Range":    []string{"0-9"},
+			"Location": []string{"*"},
+		})
+		checkBodyHasErrorCodes(t, "pushing chunk "+chunk.contentRange, resp, v2.ErrorCodeRangeInvalid)
+	}
+
+	resp, err = doPushChunk(t, uploadURLBase, bytes.NewReader(content[10:]), chunkOptions{contentRange: "10-19"})
+	checkErr(t, err, "pushing the next chunk")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing the next chunk", resp, http.StatusAccepted)
+	finishUpload(t, env.builder, imageName, resp.Header.Get("Location"), digest.FromBytes(content))
+}
+
+func TestBlobDeleteReferenced(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"delete":     configuration.Parameters{"enabled": true, "protectreferenced": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+
+	repo, err := env.app.registry.Repository(env.ctx, imageName)
+	checkErr(t, err, "getting repository")
+	manifests, err := repo.Manifests(env.ctx)
+	checkErr(t, err, "getting manifest service")
+	m, err := manifests.Get(env.ctx, dgst)
+	checkErr(t, err, "getting manifest")
+
+	ref, _ := reference.WithDigest(imageName, m.References()[0].Digest)
+	layerURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building layer url")
+
+	resp, err := httpDelete(layerURL)
+	checkErr(t, err, "deleting referenced layer")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting referenced layer", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "deleting referenced layer", resp, v2.ErrorCodeBlobReferenced)
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+
+	resp, err = httpDelete(layerURL)
+	checkErr(t, err, "deleting unreferenced layer")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting unreferenced layer", resp, http.StatusAccepted)
+}
+
 func testBlobAPI(t *testing.T, env *testEnv, args blobArgs) *testEnv {
 	// TODO(stevvooe): This test code is complete junk but it should cover the
 	// complete flow. This must be broken down and checked against the
@@ -770,8 +913,11 @@ func testBlobAPI(t *testing.T, env *testEnv, args blobArgs) *testEnv {
 		"Content-Length":        []string{fmt.Sprint(layerLength)},
 		"Docker-Content-Digest": []string{canonicalDigest.String()},
 		"ETag":                  []string{fmt.Sprintf(`"%s"`, canonicalDigest)},
-		"Cache-Control":         []string{"max-age=31536000"},
 	})
+	// Caching is left to the configuration of the response headers.
+	if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != "" {
+		t.Fatalf("unexpected Cache-Control header: %q", cacheControl)
+	}
 
 	// Matching etag, gives 304
 	etag := resp.Header.Get("Etag")
@@ -1043,6 +1189,54 @@ func TestManifestAPI_DeleteTag(t *testing.T) {
 	checkResponse(t, msg, resp, http.StatusOK)
 }
 
+func TestManifestDeleteProtectTagged(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"delete":     configuration.Parameters{"enabled": true, "protecttagged": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+
+	digestRef, err := reference.WithDigest(imageName, dgst)
+	checkErr(t, err, "building manifest digest reference")
+	u, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest URL")
+
+	msg := "deleting tagged manifest"
+	resp, err := httpDelete(u)
+	checkErr(t, err, msg)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeManifestTagged)
+
+	msg = "checking manifest still exists"
+	resp, err = http.Head(u)
+	checkErr(t, err, msg)
+	checkResponse(t, msg, resp, http.StatusOK)
+
+	msg = "force deleting tagged manifest"
+	resp, err = httpDelete(u + "?force=true")
+	checkErr(t, err, msg)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusAccepted)
+
+	msg = "checking manifest no longer exists"
+	resp, err = http.Head(u)
+	checkErr(t, err, msg)
+	checkResponse(t, msg, resp, http.StatusNotFound)
+}
+
 func TestManifestAPI_DeleteTag_Unknown(t *testing.T) {
 	env := newTestEnv(t, false)
 	defer env.Shutdown()
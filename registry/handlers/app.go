@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"expvar"
 	"fmt"
 	"math"
@@ -11,7 +12,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
 	"runtime"
 	"sort"
 	"strings"
@@ -25,12 +25,17 @@ import (
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/annotation"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/artifacttype"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/export"
 	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/distribution/distribution/v3/registry/features"
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
+	"github.com/distribution/distribution/v3/registry/policy"
 	"github.com/distribution/distribution/v3/registry/proxy"
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
@@ -38,12 +43,14 @@ import (
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/distribution/distribution/v3/registry/storage/driver/storageclass"
 	"github.com/distribution/distribution/v3/version"
 	events "github.com/docker/go-events"
 	"github.com/docker/go-metrics"
 	"github.com/docker/libtrust"
 	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 )
 
@@ -54,6 +61,22 @@ const randomSecretSize = 32
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
+// defaultCoalesceMaxSize is the default size of the largest blob whose
+// concurrent reads are coalesced
+const defaultCoalesceMaxSize = 256 << 20
+
+// defaultCoalesceMaxBuffered is the default size of all the blobs buffered at
+// once by coalesced reads
+const defaultCoalesceMaxBuffered = 1 << 30
+
+// defaultCatalogSnapshotTTL is the default time catalog snapshots are kept
+// for clients paginating through them
+const defaultCatalogSnapshotTTL = 10 * time.Minute
+
+// defaultLinkReadRetryDelay is the default wait before retrying the read of
+// a missing link
+const defaultLinkReadRetryDelay = 50 * time.Millisecond
+
 // App is a global registry application object. Shared resources can be placed
 // on this object that will be accessible from all requests. Any writable
 // fields should be protected.
@@ -76,6 +99,8 @@ type App struct {
 	events struct {
 		sink   events.Sink
 		source notifications.SourceRecord
+		// hub passes the events along to the clients watching them.
+		hub *notifications.Hub
 	}
 
 	redis *redis.Pool
@@ -91,6 +116,10 @@ type App struct {
 	// readOnly is true if the registry is in a read-only maintenance mode
 	readOnly bool
 
+	// protectTagged is true if deleting a manifest which tags point to
+	// requires the force override
+	protectTagged bool
+
 	// registryExtensions is a list of registry scoped extension names
 	registryExtensions []string
 
@@ -99,6 +128,95 @@ type App struct {
 
 	// extensionNamespaces is a list of namespaces that are configured as extensions to the distribution
 	extensionNamespaces []extension.Namespace
+
+	// extensionAccess returns the access required by registry scoped
+	// extension routes, by route name
+	extensionAccess map[string]func(r *http.Request) []auth.Access
+
+	// profile is the profile the configuration is tuned with, if any
+	profile string
+
+	// headers sets the configured headers on the responses of the routes,
+	// if any
+	headers *responseHeaders
+
+	// hideUnauthorized answers requests denied access to a repository as
+	// if the repository did not exist
+	hideUnauthorized bool
+
+	// processingInterval is the interval of the 102 Processing responses
+	// sent while manifest pushes are verified, if enabled
+	processingInterval time.Duration
+
+	// faults injects faults into the requests of the routes, if configured
+	faults *faultInjector
+
+	// egress limits the bandwidth of blob downloads, if configured
+	egress *egressLimiter
+
+	// uploads refuses new blob uploads while the registry is busy, if
+	// configured
+	uploads *uploadGuard
+
+	// uploadQuota limits the blob bytes each subject pushes per window, if
+	// configured
+	uploadQuota *uploadQuota
+
+	// policy is the content policy evaluated on manifests, if configured
+	policy *policy.Policy
+
+	// storageClasses routes the blobs of the manifests pushed to storage
+	// classes, if configured
+	storageClasses *policy.StorageClasses
+
+	// artifactTypes validates the artifacts pushed, if configured
+	artifactTypes *artifacttype.Registry
+
+	// annotations validates the annotation keys of the manifests pushed, if
+	// configured
+	annotations *annotation.Validator
+
+	// holds stores the legal holds managed through the API, if enabled
+	holds *storage.HoldStore
+
+	// restores restores the blobs archived by the storage driver, if it has
+	// archive classes
+	restores *restores
+
+	// tagOperationsActor identifies the registry in the operations on tags
+	// it records, if enabled
+	tagOperationsActor string
+
+	// tagHistory reports whether the writes to tags are recorded, so that
+	// tags can be resolved at a past time
+	tagHistory bool
+
+	// features tells the experimental features rolled out to some
+	// repositories only, if configured
+	features *features.Flags
+
+	// templates bootstraps the repositories created, if configured
+	templates *repositoryTemplates
+
+	// replication tells the sources trusted with the verification of the
+	// blobs they upload, if configured
+	replication *replicationSources
+
+	// catalogSnapshots keeps the catalog consistent across pages, if
+	// enabled
+	catalogSnapshots *storage.CatalogSnapshots
+
+	// digestAliases redirects the requests of content re-addressed to
+	// another digest, if enabled
+	digestAliases *storage.DigestAliases
+
+	// linkReadRetry retries the reads of the links found missing in
+	// eventually consistent storage backends, if configured
+	linkReadRetry storage.ReadRetry
+
+	// exportUsage counts the pulls and pushes exported with the metadata
+	// of the registry, if exports are configured
+	exportUsage *export.Usage
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -112,6 +230,19 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		isCache: config.Proxy.RemoteURL != "",
 	}
 
+	var err error
+	app.headers, err = newResponseHeaders(config.HTTP.ResponseHeaders)
+	if err != nil {
+		panic(fmt.Sprintf("http.responseheaders: %v", err))
+	}
+	app.faults, err = newFaultInjector(config.Faults)
+	if err != nil {
+		panic(fmt.Sprintf("faults: %v", err))
+	}
+	if app.faults != nil {
+		dcontext.GetLogger(app).Warn("fault injection is configured: requests will be delayed, failed or truncated")
+	}
+
 	// Register the handler dispatchers.
 	app.register(v2.RouteNameBase, func(ctx *Context, r *http.Request) http.Handler {
 		return http.HandlerFunc(apiBase)
@@ -119,12 +250,25 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app.register(v2.RouteNameManifest, manifestDispatcher)
 	app.register(v2.RouteNameCatalog, catalogDispatcher)
 	app.register(v2.RouteNameTags, tagsDispatcher)
+	app.register(v2.RouteNameTagOperations, tagOperationsDispatcher)
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
+	app.register(v2.RouteNameHolds, holdsDispatcher)
+	app.register(v2.RouteNameHold, holdDispatcher)
+	app.register(v2.RouteNameRestores, restoresDispatcher)
+	app.register(v2.RouteNameRestore, restoreDispatcher)
 	app.register(v2.RouteNameExtensionsRegistry, extensionsDispatcher)
 	app.register(v2.RouteNameExtensionsRepository, extensionsDispatcher)
 
+	if err := applyProfile(config); err != nil {
+		panic(fmt.Sprintf("profile: %v", err))
+	}
+	if config.Profile != "" {
+		dcontext.GetLogger(app).Infof("using the %s profile", config.Profile)
+	}
+	app.profile = config.Profile
+
 	// override the storage driver's UA string for registry outbound HTTP requests
 	storageParams := config.Storage.Parameters()
 	if storageParams == nil {
@@ -132,7 +276,6 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 	storageParams["useragent"] = fmt.Sprintf("docker-distribution/%s %s", version.Version, runtime.Version())
 
-	var err error
 	app.driver, err = factory.Create(config.Storage.Type(), storageParams)
 	if err != nil {
 		// TODO(stevvooe): Move the creation of a service into a protected
@@ -165,6 +308,20 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 
 	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
 
+	if classes, ok := app.driver.(*storageclass.Driver); ok && classes.Archives() {
+		app.restores = newRestores(app, classes)
+	}
+	if len(config.Policy.StorageClasses) > 0 {
+		classes, ok := app.driver.(*storageclass.Driver)
+		if !ok {
+			panic(fmt.Sprintf("policy.storageclasses requires the storageclass storage driver, not %s", app.driver.Name()))
+		}
+		app.storageClasses, err = policy.NewStorageClasses(config.Policy.StorageClasses, classes.Classes(), classes.Route)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	app.driver, err = applyStorageMiddleware(app.driver, config.Middleware["storage"])
 	if err != nil {
 		panic(err)
@@ -172,6 +329,31 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 
 	app.configureSecret(config)
 	app.configureEvents(config)
+	app.egress = newEgressLimiter(config.Egress)
+	if minLength, maxLength := config.Uploads.MinChunkLength, config.Uploads.MaxChunkLength; minLength < 0 || maxLength < 0 || (maxLength > 0 && minLength > maxLength) {
+		panic(fmt.Sprintf("invalid uploads chunk lengths: minchunklength %d, maxchunklength %d", minLength, maxLength))
+	}
+	app.uploads = newUploadGuard(config.Uploads)
+	app.uploadQuota = newUploadQuota(config.Uploads.Quota)
+	app.processingInterval = config.HTTP.ProcessingInterval
+	if app.processingInterval > 0 && !processingSupported {
+		dcontext.GetLogger(app).Warnf("http.processinginterval is ignored: the registry was built with %s, which cannot send 102 Processing responses", runtime.Version())
+	}
+	// The allow and deny options of the configuration are evaluated as
+	// rules of the policy, before those it lists.
+	rules, err := policy.OptionRules(config)
+	if err != nil {
+		panic(err)
+	}
+	rules = append(rules, config.Policy.Rules...)
+	if len(rules) > 0 {
+		app.policy, err = policy.New(rules, func(ctx context.Context, repository reference.Named, subject digest.Digest) ([]digest.Digest, error) {
+			return storage.Referrers(ctx, app.driver, repository.Name(), subject)
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
 	app.configureRedis(config)
 	app.configureLogHook(config)
 
@@ -208,6 +390,14 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.DisableDigestResumption)
 	}
 
+	switch config.Policy.Repository.Unauthorized {
+	case "", "unauthorized":
+	case "notfound":
+		app.hideUnauthorized = true
+	default:
+		panic(fmt.Sprintf("invalid policy.repository.unauthorized %q, expected unauthorized or notfound", config.Policy.Repository.Unauthorized))
+	}
+
 	// configure deletion
 	if d, ok := config.Storage["delete"]; ok {
 		e, ok := d["enabled"]
@@ -216,6 +406,155 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				options = append(options, storage.EnableDelete)
 			}
 		}
+		if protectTagged, ok := d["protecttagged"].(bool); ok {
+			app.protectTagged = protectTagged
+		}
+		if protectReferenced, ok := d["protectreferenced"].(bool); ok && protectReferenced && !app.isCache {
+			options = append(options, storage.EnableBlobReferenceChecks)
+		}
+	}
+
+	// configure the journals of the batches of metadata writes
+	journalBatches := false
+	if j, ok := config.Storage["journal"]; ok {
+		if v, ok := j["enabled"]; ok {
+			if journalBatches, ok = v.(bool); !ok {
+				panic("journal's enabled config key must be a boolean")
+			}
+		}
+	}
+	if journalBatches {
+		options = append(options, storage.JournalBatches)
+	}
+
+	// configure the legal holds API
+	if h, ok := config.Storage["holds"]; ok {
+		if enabled, ok := h["enabled"].(bool); ok && enabled {
+			app.holds = storage.NewHoldStore(app.driver)
+		}
+	}
+
+	// configure tag operations
+	if o, ok := config.Storage["tagoperations"]; ok {
+		if enabled, ok := o["enabled"].(bool); ok && enabled {
+			actor, ok := o["actor"].(string)
+			if !ok || actor == "" {
+				panic(`tag operations require an "actor" parameter`)
+			}
+			app.tagOperationsActor = actor
+			options = append(options, storage.TagOperations(actor))
+		}
+	}
+
+	// configure the rollout of features
+	if len(config.Features) > 0 {
+		app.features, err = features.New(config.Features, storage.Features())
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, storage.EnableFeatures(app.features.Enabled))
+	}
+
+	// configure the bootstrap of repositories
+	if len(config.Templates) > 0 {
+		app.templates, err = newRepositoryTemplates(config.Templates, app.driver)
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, storage.BootstrapRepositories(app.templates.bootstrap))
+	}
+
+	// configure the sources of replication trusted with the verification
+	// of blobs
+	app.replication, err = newReplicationSources(config.Replication)
+	if err != nil {
+		panic(err)
+	}
+
+	// configure tag history
+	if h, ok := config.Storage["taghistory"]; ok {
+		if enabled, ok := h["enabled"].(bool); ok && enabled {
+			app.tagHistory = true
+			options = append(options, storage.RecordTagHistory)
+		}
+	}
+
+	// configure the provenance of manifests
+	if p, ok := config.Storage["provenance"]; ok {
+		if enabled, ok := p["enabled"].(bool); ok && enabled {
+			options = append(options, storage.RecordProvenance)
+		}
+	}
+
+	// configure catalog snapshots
+	if c, ok := config.Storage["catalogsnapshots"]; ok {
+		if enabled, ok := c["enabled"].(bool); ok && enabled {
+			ttl := defaultCatalogSnapshotTTL
+			if v, ok := c["ttl"]; ok {
+				s, ok := v.(string)
+				if !ok {
+					panic("catalogsnapshots' ttl config key must be a duration string")
+				}
+				ttl, err = time.ParseDuration(s)
+				if err != nil || ttl <= 0 {
+					panic(fmt.Sprintf("invalid catalogsnapshots ttl %q", s))
+				}
+			}
+			app.catalogSnapshots = storage.NewCatalogSnapshots(app.driver, ttl)
+		}
+	}
+
+	// configure digest aliases
+	if a, ok := config.Storage["digestaliases"]; ok {
+		if enabled, ok := a["enabled"].(bool); ok && enabled {
+			app.digestAliases = storage.NewDigestAliases(app.driver)
+		}
+	}
+
+	// configure link metadata
+	if l, ok := config.Storage["links"]; ok {
+		if metadata, ok := l["metadata"].(bool); ok && metadata {
+			options = append(options, storage.EnableLinkMetadata)
+		}
+		switch v := l["readretries"].(type) {
+		case nil:
+		case int:
+			app.linkReadRetry.Retries = v
+		default:
+			panic(fmt.Sprintf("invalid type for links readretries: %#v", v))
+		}
+		app.linkReadRetry.Delay = defaultLinkReadRetryDelay
+		if v, ok := l["readretrydelay"]; ok {
+			s, ok := v.(string)
+			if !ok {
+				panic("links' readretrydelay config key must be a duration string")
+			}
+			app.linkReadRetry.Delay, err = time.ParseDuration(s)
+			if err != nil || app.linkReadRetry.Delay <= 0 {
+				panic(fmt.Sprintf("invalid links readretrydelay %q", s))
+			}
+		}
+		if app.linkReadRetry.Retries > 0 {
+			dcontext.GetLogger(app).Infof("retrying reads of missing links %d times", app.linkReadRetry.Retries)
+			options = append(options, storage.RetryLinkReads(app.linkReadRetry))
+		}
+	}
+
+	// configure the bytes of manifests read at once
+	if m, ok := config.Storage["manifests"]; ok {
+		var maxBytes int64
+		switch v := m["maxbytesinflight"].(type) {
+		case nil:
+		case int:
+			maxBytes = int64(v)
+		case int64:
+			maxBytes = v
+		default:
+			panic(fmt.Sprintf("invalid type for manifests maxbytesinflight: %#v", v))
+		}
+		if maxBytes > 0 {
+			options = append(options, storage.ManifestBytesInFlight(maxBytes))
+		}
 	}
 
 	// configure redirects
@@ -235,41 +574,58 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.EnableRedirect)
 	}
 
+	// configure read coalescing
+	if coalesceConfig, ok := config.Storage["coalesce"]; ok {
+		if enabled, ok := coalesceConfig["enabled"].(bool); ok && enabled {
+			maxSize := int64(defaultCoalesceMaxSize)
+			if v, ok := coalesceConfig["maxsize"]; ok {
+				switch v := v.(type) {
+				case int:
+					maxSize = int64(v)
+				case int64:
+					maxSize = v
+				default:
+					panic(fmt.Sprintf("invalid type for coalesce maxsize: %#v", v))
+				}
+			}
+			maxBuffered := int64(defaultCoalesceMaxBuffered)
+			if v, ok := coalesceConfig["maxbuffered"]; ok {
+				switch v := v.(type) {
+				case int:
+					maxBuffered = int64(v)
+				case int64:
+					maxBuffered = v
+				default:
+					panic(fmt.Sprintf("invalid type for coalesce maxbuffered: %#v", v))
+				}
+			}
+			dcontext.GetLogger(app).Infof("coalescing concurrent reads of blobs up to %d bytes, buffering up to %d bytes", maxSize, maxBuffered)
+			options = append(options, storage.CoalesceBlobReads(maxSize, maxBuffered))
+		}
+	}
+
 	if !config.Validation.Enabled {
 		config.Validation.Enabled = !config.Validation.Disabled
 	}
 
 	// configure validation
 	if config.Validation.Enabled {
-		if len(config.Validation.Manifests.URLs.Allow) == 0 && len(config.Validation.Manifests.URLs.Deny) == 0 {
-			// If Allow and Deny are empty, allow nothing.
-			options = append(options, storage.ManifestURLsAllowRegexp(regexp.MustCompile("^$")))
-		} else {
-			if len(config.Validation.Manifests.URLs.Allow) > 0 {
-				for i, s := range config.Validation.Manifests.URLs.Allow {
-					// Validate via compilation.
-					if _, err := regexp.Compile(s); err != nil {
-						panic(fmt.Sprintf("validation.manifests.urls.allow: %s", err))
-					}
-					// Wrap with non-capturing group.
-					config.Validation.Manifests.URLs.Allow[i] = fmt.Sprintf("(?:%s)", s)
-				}
-				re := regexp.MustCompile(strings.Join(config.Validation.Manifests.URLs.Allow, "|"))
-				options = append(options, storage.ManifestURLsAllowRegexp(re))
+		if len(config.Validation.ArtifactTypes.Types) > 0 || config.Validation.ArtifactTypes.Restrict {
+			app.artifactTypes, err = artifacttype.New(config.Validation.ArtifactTypes)
+			if err != nil {
+				panic(fmt.Sprintf("validation.artifacttypes: %s", err))
 			}
-			if len(config.Validation.Manifests.URLs.Deny) > 0 {
-				for i, s := range config.Validation.Manifests.URLs.Deny {
-					// Validate via compilation.
-					if _, err := regexp.Compile(s); err != nil {
-						panic(fmt.Sprintf("validation.manifests.urls.deny: %s", err))
-					}
-					// Wrap with non-capturing group.
-					config.Validation.Manifests.URLs.Deny[i] = fmt.Sprintf("(?:%s)", s)
-				}
-				re := regexp.MustCompile(strings.Join(config.Validation.Manifests.URLs.Deny, "|"))
-				options = append(options, storage.ManifestURLsDenyRegexp(re))
+		}
+		if config.Validation.Annotations.Mode != "" || len(config.Validation.Annotations.Namespaces) > 0 {
+			app.annotations, err = annotation.New(config.Validation.Annotations)
+			if err != nil {
+				panic(fmt.Sprintf("validation.annotations: %s", err))
 			}
 		}
+		if n := config.Validation.Manifests.Concurrency; n != 0 {
+			options = append(options, storage.ManifestVerificationConcurrency(n))
+		}
+
 	}
 
 	// initialize the extension namespaces based on the configuration
@@ -344,7 +700,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 
 	// configure as a pull through cache
 	if config.Proxy.RemoteURL != "" {
-		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
+		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, app.redis, config.Proxy)
 		if err != nil {
 			panic(err.Error())
 		}
@@ -357,6 +713,59 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		dcontext.GetLogger(app).Warnf("Registry does not implement RepositoryRemover. Will not be able to delete repos and tags")
 	}
 
+	// repository garbage collection runs on demand from the debug server,
+	// marking with a registry without middleware as the command does
+	if !app.isCache {
+		gcRegistry, err := storage.NewRegistry(app, app.driver, storage.Schema1SigningKey(app.trustKey))
+		if err != nil {
+			panic("could not create registry: " + err.Error())
+		}
+		setActiveGC(newRepositoryGC(app, app.driver, gcRegistry, dcontext.GetLogger(app), app.readOnly))
+	}
+
+	startExporter(app, app.registry, app.exportUsage, dcontext.GetLogger(app), config.Export)
+	startCluster(app, config, app.redis, dcontext.GetLogger(app))
+
+	// configure the scans of the referrers index
+	if r, ok := config.Storage["referrers"]; ok {
+		if v, ok := r["statsinterval"]; ok {
+			s, ok := v.(string)
+			if !ok {
+				panic("referrers' statsinterval config key must be a duration string")
+			}
+			interval, err := time.ParseDuration(s)
+			if err != nil || interval <= 0 {
+				panic(fmt.Sprintf("invalid referrers statsinterval %q", s))
+			}
+			startReferrersScanner(app, app.driver, app.registry, dcontext.GetLogger(app), interval)
+		}
+	}
+
+	// configure the recovery of the batches of metadata writes interrupted
+	if journalBatches && !app.readOnly {
+		recoverJournals, recoverAfter := true, defaultJournalRecoverAfter
+		if j, ok := config.Storage["journal"]; ok {
+			if v, ok := j["recover"]; ok {
+				if recoverJournals, ok = v.(bool); !ok {
+					panic("journal's recover config key must be a boolean")
+				}
+			}
+			if v, ok := j["recoverafter"]; ok {
+				s, ok := v.(string)
+				if !ok {
+					panic("journal's recoverafter config key must be a duration string")
+				}
+				recoverAfter, err = time.ParseDuration(s)
+				if err != nil || recoverAfter <= 0 {
+					panic(fmt.Sprintf("invalid journal recoverafter %q", s))
+				}
+			}
+		}
+		if recoverJournals {
+			startJournalRecovery(app, app.driver, dcontext.GetLogger(app), recoverAfter)
+		}
+	}
+
 	// register the routes exposed by the extension in the app.
 	err = app.registerExtensionRoutes(app)
 	if err != nil {
@@ -456,7 +865,7 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 // passed through the application filters and context will be constructed at
 // request time.
 func (app *App) register(routeName string, dispatch dispatchFunc) {
-	handler := app.dispatcher(dispatch)
+	handler := app.headers.handler(routeName, app.faults.handler(routeName, app.dispatcher(dispatch)))
 
 	// Chain the handler with prometheus instrumented handler
 	if app.Config.HTTP.Debug.Prometheus.Enabled {
@@ -502,6 +911,12 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// replacing broadcaster with a rabbitmq implementation. It's recommended
 	// that the registry instances also act as the workers to keep deployment
 	// simple.
+	app.events.hub = notifications.NewHub()
+	sinks = append(sinks, app.events.hub)
+	if len(configuration.Export.Sinks) > 0 {
+		app.exportUsage = export.NewUsage()
+		sinks = append(sinks, app.exportUsage)
+	}
 	app.events.sink = events.NewBroadcaster(sinks...)
 
 	// Populate registry event source
@@ -652,6 +1067,11 @@ func (app *App) configureSecret(configuration *configuration.Configuration) {
 }
 
 func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app.serve(w, r, app.router)
+}
+
+// serve serves the request with router, a router of the routes of the app.
+func (app *App) serve(w http.ResponseWriter, r *http.Request, router *mux.Router) {
 	defer r.Body.Close() // ensure that request body is always closed.
 
 	// Prepare the context with our own little decorations.
@@ -670,7 +1090,7 @@ func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Set a header with the Docker Distribution API Version for all responses.
 	w.Header().Add("Docker-Distribution-API-Version", "registry/2.0")
-	app.router.ServeHTTP(w, r)
+	router.ServeHTTP(w, r)
 }
 
 // dispatchFunc takes a context and request and returns a constructed handler
@@ -702,6 +1122,18 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		// Add username to request logging
 		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context, auth.UserNameKey))
 
+		if dryRun(r) {
+			// requests which cannot be evaluated are refused rather
+			// than made.
+			if !dryRunSupported(r) {
+				if err := errcode.ServeJSON(w, errcode.ErrorCodeUnsupported.WithMessage("dry run not supported")); err != nil {
+					dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+				}
+				return
+			}
+			context.Context = distribution.WithDryRun(context.Context)
+		}
+
 		// sync up context on the request.
 		r = r.WithContext(context)
 
@@ -754,6 +1186,26 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				}
 				return
 			}
+
+			// The policy wraps the middlewares, so that it is evaluated
+			// with the manifests exchanged with clients.
+			if app.storageClasses != nil {
+				context.Repository = app.storageClasses.Repository(context.Repository)
+			}
+			if app.policy != nil {
+				context.Repository = app.policy.Repository(context.Repository)
+			}
+			if app.artifactTypes != nil {
+				context.Repository = app.artifactTypes.Repository(context.Repository)
+			}
+			if app.templates != nil {
+				context.Repository = app.templates.Repository(context.Repository)
+			}
+			// Annotations are normalized before the manifests pushed
+			// are validated and evaluated against the policy.
+			if app.annotations != nil {
+				context.Repository = app.annotations.Repository(context.Repository)
+			}
 		}
 
 		dispatch(context, r).ServeHTTP(w, r)
@@ -761,6 +1213,7 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		// own errors if they need different behavior (such as range errors
 		// for layer upload).
 		if context.Errors.Len() > 0 {
+			context.Errors = storageTimeoutErrors(context.Errors)
 			if err := errcode.ServeJSON(w, context.Errors); err != nil {
 				dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
 			}
@@ -814,10 +1267,10 @@ func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
 	ctx := r.Context()
 	ctx = dcontext.WithVars(ctx, r)
 	ctx = dcontext.WithLogger(ctx, dcontext.GetLogger(ctx,
-		"vars.name",
-		"vars.reference",
-		"vars.digest",
-		"vars.uuid"))
+		dcontext.RepositoryNameKey,
+		dcontext.ReferenceKey,
+		dcontext.DigestKey,
+		dcontext.UploadUUIDKey))
 
 	context := &Context{
 		App:     app,
@@ -856,6 +1309,18 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			// access to the source repository.
 			accessRecords = appendAccessRecords(accessRecords, "GET", fromRepo)
 		}
+		if r.Method == http.MethodDelete && (app.protectTagged && forceDelete(r) || dryRun(r)) {
+			// overriding the protection of tagged manifests, and
+			// evaluating what a deletion would delete, require full
+			// access to the repository.
+			accessRecords = append(accessRecords, auth.Access{
+				Resource: auth.Resource{
+					Type: "repository",
+					Name: repo,
+				},
+				Action: "*",
+			})
+		}
 	} else {
 		// Only allow the name not to be set on the base route.
 		if app.nameRequired(r) {
@@ -871,12 +1336,25 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			return fmt.Errorf("forbidden: no repository name")
 		}
 		accessRecords = appendCatalogAccessRecord(accessRecords, r)
+		accessRecords = appendHoldsAccessRecord(accessRecords, r)
+		accessRecords = appendRestoresAccessRecord(accessRecords, r)
+		accessRecords = app.appendExtensionAccessRecords(accessRecords, r)
 	}
 
 	ctx, err := app.accessController.Authorized(context.Context, accessRecords...)
 	if err != nil {
 		switch err := err.(type) {
 		case auth.Challenge:
+			// Requests denied access to a repository are answered as if
+			// the repository did not exist, if configured.
+			if repo != "" && app.hideUnauthorized && errors.Is(err, auth.ErrInsufficientScope) {
+				dcontext.GetLogger(context).Infof("responding not found to request denied access to %s: %v", repo, err)
+				if err := errcode.ServeJSON(w, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": repo})); err != nil {
+					dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+				}
+				return err
+			}
+
 			// Add the appropriate WWW-Auth header
 			err.SetHeaders(r, w)
 
@@ -922,6 +1400,8 @@ func (app *App) nameRequired(r *http.Request) bool {
 	}
 	routeName := route.GetName()
 	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog &&
+		routeName != v2.RouteNameHolds && routeName != v2.RouteNameHold &&
+		routeName != v2.RouteNameRestores && routeName != v2.RouteNameRestore &&
 		!strings.HasPrefix(routeName, v2.RouteNameExtensionsRegistry)
 }
 
@@ -941,6 +1421,8 @@ func (app *App) initializeExtensionNamespaces(ctx context.Context, extensions ma
 }
 
 func (app *App) registerExtensionRoutes(ctx context.Context) error {
+	app.extensionAccess = make(map[string]func(r *http.Request) []auth.Access)
+
 	var repositoryExtensions []string
 	var registryExtensions []string
 	for _, ns := range app.extensionNamespaces {
@@ -972,6 +1454,29 @@ func (app *App) registerExtensionRoutes(ctx context.Context) error {
 			registryExtensions = append(registryExtensions, extName)
 		}
 	}
+	for _, route := range extension.VendorRoutes() {
+		// The route descriptor exists when registered by a previous
+		// application, in which case the router was built with it.
+		desc, _ := v2.ExtendVendorRoute(route.Vendor, route.Path, route.Description, route.Repository)
+		if app.router.GetRoute(desc.Name) == nil {
+			app.router.Path(desc.Path).Name(desc.Name)
+		}
+		app.register(desc.Name, app.extensionDispatcher(route.Dispatcher))
+		if !route.Repository && route.Access != nil {
+			app.extensionAccess[desc.Name] = route.Access
+		}
+
+		extName := "_ext/" + route.Vendor
+		if route.Path != "" {
+			extName += "/" + route.Path
+		}
+		if route.Repository {
+			repositoryExtensions = append(repositoryExtensions, extName)
+		} else {
+			registryExtensions = append(registryExtensions, extName)
+		}
+	}
+
 	sort.Strings(repositoryExtensions)
 	app.repositoryExtensions = repositoryExtensions
 	sort.Strings(registryExtensions)
@@ -994,20 +1499,31 @@ func (app *App) registerExtensionRoute(route extension.Route, nameRequired bool)
 		return fmt.Errorf("duplicated route: %s", desc.Name)
 	}
 	app.router.Path(desc.Path).Name(desc.Name)
-	dispatch := route.Dispatcher
-	app.register(desc.Name, func(ctx *Context, r *http.Request) http.Handler {
+	app.register(desc.Name, app.extensionDispatcher(route.Dispatcher))
+	if !nameRequired && route.Access != nil {
+		app.extensionAccess[desc.Name] = route.Access
+	}
+	return nil
+}
+
+// extensionDispatcher adapts the dispatcher of an extension route to the
+// dispatchers of the app.
+func (app *App) extensionDispatcher(dispatch extension.RouteDispatchFunc) dispatchFunc {
+	return func(ctx *Context, r *http.Request) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 			extCtx := &extension.Context{
 				Context:    ctx.Context,
 				Repository: ctx.Repository,
 				Errors:     ctx.Errors,
 				Registry:   app.registry,
+				Driver:     app.driver,
+				ReadRetry:  app.linkReadRetry,
+				Events:     app.events.hub,
 			}
 			dispatch(extCtx, r).ServeHTTP(rw, r)
 			ctx.Errors = extCtx.Errors
 		})
-	})
-	return nil
+	}
 }
 
 // apiBase implements a simple yes-man for doing overall checks against the
@@ -1075,6 +1591,61 @@ func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []a
 	return accessRecords
 }
 
+// Add the access record for legal holds if they are our current route
+func appendHoldsAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
+	route := mux.CurrentRoute(r)
+	routeName := route.GetName()
+
+	if routeName == v2.RouteNameHolds || routeName == v2.RouteNameHold {
+		resource := auth.Resource{
+			Type: "registry",
+			Name: "holds",
+		}
+
+		accessRecords = append(accessRecords,
+			auth.Access{
+				Resource: resource,
+				Action:   "*",
+			})
+	}
+	return accessRecords
+}
+
+// Add the access record for the restores of archived blobs if they are our
+// current route
+func appendRestoresAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
+	route := mux.CurrentRoute(r)
+	routeName := route.GetName()
+
+	if routeName == v2.RouteNameRestores || routeName == v2.RouteNameRestore {
+		resource := auth.Resource{
+			Type: "registry",
+			Name: "restores",
+		}
+
+		accessRecords = append(accessRecords,
+			auth.Access{
+				Resource: resource,
+				Action:   "*",
+			})
+	}
+	return accessRecords
+}
+
+// appendExtensionAccessRecords adds the access records required by registry
+// scoped extension routes.
+func (app *App) appendExtensionAccessRecords(accessRecords []auth.Access, r *http.Request) []auth.Access {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return accessRecords
+	}
+
+	if access, ok := app.extensionAccess[route.GetName()]; ok {
+		accessRecords = append(accessRecords, access(r)...)
+	}
+	return accessRecords
+}
+
 // applyRegistryMiddleware wraps a registry instance with the configured middlewares
 func applyRegistryMiddleware(ctx context.Context, registry distribution.Namespace, middlewares []configuration.Middleware) (distribution.Namespace, error) {
 	for _, mw := range middlewares {
@@ -1178,21 +1749,88 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		badPurgeUploadConfig("dryrun missing")
 	}
 
+	randInt, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		log.Infof("Failed to generate random jitter: %v", err)
+		// sleep 30min for failure case
+		randInt = big.NewInt(30)
+	}
+	jitter := time.Duration(randInt.Int64()%60) * time.Minute
+
+	purger := newUploadPurger(ctx, storageDriver, log, purgeAgeDuration, intervalDuration, dryRunBool)
+	setActivePurger(purger)
+	go purger.run(jitter)
+}
+
+// startReferrersScanner schedules a goroutine which will periodically scan
+// the referrers index, recording its size in metrics
+func startReferrersScanner(ctx context.Context, storageDriver storagedriver.StorageDriver, registry distribution.Namespace, log dcontext.Logger, interval time.Duration) {
+	go func() {
+		for {
+			stats, err := storage.ScanReferrers(ctx, storageDriver, registry)
+			if err != nil {
+				log.Errorf("Referrers index scan failed: %v", err)
+			} else {
+				log.Infof("Scanned referrers index: %d referrers of %d subjects", stats.Links, stats.Subjects)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// defaultJournalRecoverAfter is the age of the journals of the batches of
+// metadata writes after which they are recovered, unless configured.
+const defaultJournalRecoverAfter = time.Minute
+
+// startJournalRecovery schedules a goroutine which will periodically complete
+// the batches of metadata writes whose journal is older than recoverAfter, as
+// they were interrupted.
+func startJournalRecovery(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, recoverAfter time.Duration) {
 	go func() {
-		randInt, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+		for {
+			n, err := storage.RecoverBatches(ctx, storageDriver, recoverAfter)
+			if err != nil {
+				log.Errorf("Recovering batch journals failed: %v", err)
+			} else if n > 0 {
+				log.Infof("Recovered %d interrupted batches of metadata writes", n)
+			}
+			time.Sleep(recoverAfter)
+		}
+	}()
+}
+
+// defaultExportInterval is the time between exports unless configured.
+const defaultExportInterval = 24 * time.Hour
+
+// startExporter schedules a goroutine which will periodically export the
+// metadata of the registry, and its usage, to the configured sinks
+func startExporter(ctx context.Context, registry distribution.Namespace, usage *export.Usage, log dcontext.Logger, config configuration.Export) {
+	if len(config.Sinks) == 0 {
+		return
+	}
+
+	sinks := make(map[string]export.Sink, len(config.Sinks))
+	for name, parameters := range config.Sinks {
+		sink, err := export.Create(name, parameters)
 		if err != nil {
-			log.Infof("Failed to generate random jitter: %v", err)
-			// sleep 30min for failure case
-			randInt = big.NewInt(30)
+			panic(fmt.Sprintf("unable to configure export sink %s: %v", name, err))
 		}
-		jitter := time.Duration(randInt.Int64()%60) * time.Minute
-		log.Infof("Starting upload purge in %s", jitter)
-		time.Sleep(jitter)
+		sinks[name] = sink
+	}
+	exporter := export.NewExporter(registry, sinks, usage)
 
+	interval := config.Interval
+	if interval <= 0 {
+		interval = defaultExportInterval
+	}
+
+	go func() {
 		for {
-			storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
-			log.Infof("Starting upload purge in %s", intervalDuration)
-			time.Sleep(intervalDuration)
+			log.Infof("Starting metadata export in %s", interval)
+			time.Sleep(interval)
+			if err := exporter.Export(ctx); err != nil {
+				log.Errorf("Metadata export failed: %v", err)
+			}
 		}
 	}()
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -9,7 +10,7 @@ import (
 	"testing"
 
 	"github.com/distribution/distribution/v3/configuration"
-	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
@@ -212,6 +213,83 @@ func TestNewApp(t *testing.T) {
 	}
 }
 
+// denyingAccessController denies all requests for lack of scope.
+type denyingAccessController struct{}
+
+type insufficientScopeChallenge struct{}
+
+func (insufficientScopeChallenge) Error() string { return auth.ErrInsufficientScope.Error() }
+
+func (insufficientScopeChallenge) Unwrap() error { return auth.ErrInsufficientScope }
+
+func (insufficientScopeChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="realm-test",error="insufficient_scope"`)
+}
+
+func (denyingAccessController) Authorized(ctx context.Context, access ...auth.Access) (context.Context, error) {
+	return nil, insufficientScopeChallenge{}
+}
+
+// TestHideUnauthorized ensures that requests denied access to a repository
+// are answered with 404 if policy.repository.unauthorized is notfound, and
+// with a challenge otherwise.
+func TestHideUnauthorized(t *testing.T) {
+	for _, tc := range []struct {
+		unauthorized string
+		status       int
+		code         errcode.ErrorCode
+	}{
+		{"", http.StatusUnauthorized, errcode.ErrorCodeUnauthorized},
+		{"unauthorized", http.StatusUnauthorized, errcode.ErrorCodeUnauthorized},
+		{"notfound", http.StatusNotFound, v2.ErrorCodeNameUnknown},
+	} {
+		config := configuration.Configuration{
+			Storage: configuration.Storage{
+				"testdriver": nil,
+				"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+					"enabled": false,
+				}},
+			},
+		}
+		config.Policy.Repository.Unauthorized = tc.unauthorized
+		app := NewApp(context.Background(), &config)
+		app.accessController = denyingAccessController{}
+
+		server := httptest.NewServer(app)
+		builder, err := v2.NewURLBuilderFromString(server.URL, false)
+		if err != nil {
+			t.Fatalf("error creating urlbuilder: %v", err)
+		}
+		ref, _ := reference.WithName("foo/bar")
+		tagsURL, err := builder.BuildTagsURL(ref)
+		if err != nil {
+			t.Fatalf("error building tags url: %v", err)
+		}
+
+		resp, err := http.Get(tagsURL)
+		if err != nil {
+			t.Fatalf("unexpected error during GET: %v", err)
+		}
+		var errs errcode.Errors
+		err = json.NewDecoder(resp.Body).Decode(&errs)
+		resp.Body.Close()
+		server.Close()
+		if err != nil {
+			t.Fatalf("error decoding error response: %v", err)
+		}
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("%q: unexpected status code: %d != %d", tc.unauthorized, resp.StatusCode, tc.status)
+		}
+		if challenged := resp.Header.Get("WWW-Authenticate") != ""; challenged != (tc.status == http.StatusUnauthorized) {
+			t.Errorf("%q: unexpected WWW-Authenticate header: %q", tc.unauthorized, resp.Header.Get("WWW-Authenticate"))
+		}
+		if len(errs) != 1 || errs[0].(errcode.ErrorCoder).ErrorCode() != tc.code {
+			t.Errorf("%q: unexpected errors: %v", tc.unauthorized, errs)
+		}
+	}
+}
+
 // Test the access record accumulator
 func TestAppendAccessRecords(t *testing.T) {
 	repo := "testRepo"
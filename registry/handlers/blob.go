@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/gorilla/handlers"
@@ -16,7 +18,7 @@ func blobDispatcher(ctx *Context, r *http.Request) http.Handler {
 	dgst, err := getDigest(ctx)
 	if err != nil {
 
-		if err == errDigestNotAvailable {
+		if err == context.ErrDigestNotAvailable {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				ctx.Errors = append(ctx.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
 			})
@@ -58,14 +60,26 @@ func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 	blobs := bh.Repository.Blobs(bh)
 	desc, err := blobs.Stat(bh, bh.Digest)
 	if err != nil {
-		if err == distribution.ErrBlobUnknown {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			if redirectDigestAlias(bh.Context, w, r, bh.Digest, func(ref reference.Canonical) (string, error) {
+				return bh.urlBuilder.BuildBlobURL(ref)
+			}) {
+				return
+			}
 			bh.Errors = append(bh.Errors, v2.ErrorCodeBlobUnknown.WithDetail(bh.Digest))
 		} else {
 			bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
 		return
 	}
+	if serveArchived(bh.Context, w, desc.Digest) {
+		return
+	}
 
+	w = bh.App.egress.limit(bh.Context, w, r)
+	if bh.App.profile == profileModels {
+		w = timeFirstByte(bh.Context, w, desc.Size)
+	}
 	if err := blobs.ServeBlob(bh, w, r, desc.Digest); err != nil {
 		context.GetLogger(bh).Debugf("unexpected error getting blob HTTP handler: %v", err)
 		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -80,13 +94,26 @@ func (bh *blobHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 	blobs := bh.Repository.Blobs(bh)
 	err := blobs.Delete(bh, bh.Digest)
 	if err != nil {
+		var (
+			held       distribution.ErrContentHeld
+			referenced distribution.ErrBlobReferenced
+		)
+		switch {
+		case errors.As(err, &held):
+			bh.Errors = append(bh.Errors, errcode.ErrorCodeDenied.WithMessage(held.Error()))
+			return
+		case errors.As(err, &referenced):
+			bh.Errors = append(bh.Errors, v2.ErrorCodeBlobReferenced.WithDetail(map[string]digest.Digest{"manifest": referenced.Manifest}))
+			return
+		case errors.Is(err, distribution.ErrBlobUnknown):
+			bh.Errors = append(bh.Errors, v2.ErrorCodeBlobUnknown)
+			return
+		}
+
 		switch err {
 		case distribution.ErrUnsupported:
 			bh.Errors = append(bh.Errors, errcode.ErrorCodeUnsupported)
 			return
-		case distribution.ErrBlobUnknown:
-			bh.Errors = append(bh.Errors, v2.ErrorCodeBlobUnknown)
-			return
 		default:
 			bh.Errors = append(bh.Errors, err)
 			context.GetLogger(bh).Errorf("Unknown error deleting blob: %s", err.Error())
@@ -94,6 +121,10 @@ func (bh *blobHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if distribution.IsDryRun(bh) {
+		serveDryRun(bh.Context, w, dryRunAPIResponse{Blobs: []digest.Digest{bh.Digest}})
+		return
+	}
 	w.Header().Set("Content-Length", "0")
 	w.WriteHeader(http.StatusAccepted)
 }
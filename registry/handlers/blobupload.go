@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
@@ -15,9 +16,20 @@ import (
 	"github.com/opencontainers/go-digest"
 )
 
+// The headers of the upload responses advertising the bounds of the length of
+// chunks, defined by the OCI distribution spec.
+const (
+	chunkMinLengthHeader = "OCI-Chunk-Min-Length"
+	chunkMaxLengthHeader = "OCI-Chunk-Max-Length"
+)
+
 // blobUploadDispatcher constructs and returns the blob upload handler for the
 // given request context.
 func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
+	if source := ctx.App.replication.trusted(ctx, r); source != "" {
+		ctx.Context = storage.WithDelegatedVerification(ctx.Context, source)
+	}
+
 	buh := &blobUploadHandler{
 		Context: ctx,
 		UUID:    getUploadUUID(ctx),
@@ -61,11 +73,21 @@ type blobUploadHandler struct {
 // StartBlobUpload begins the blob upload process and allocates a server-side
 // blob writer session, optionally mounting the blob from a separate repository.
 func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Request) {
+	if !buh.App.uploads.admit(buh.Context, w) {
+		return
+	}
+	defer buh.App.uploads.track()()
+
 	var options []distribution.BlobCreateOption
 
 	fromRepo := r.FormValue("from")
 	mountDigest := r.FormValue("mount")
 
+	// Mounts push no data, so they are not refused by upload quotas.
+	if mountDigest == "" && !buh.App.uploadQuota.admit(buh.Context, w, r) {
+		return
+	}
+
 	if mountDigest != "" && fromRepo != "" {
 		opt, err := buh.createBlobMountOption(fromRepo, mountDigest)
 		if opt != nil && err == nil {
@@ -121,11 +143,17 @@ func (buh *blobUploadHandler) GetUploadStatus(w http.ResponseWriter, r *http.Req
 
 // PatchBlobData writes data to an upload.
 func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Request) {
+	defer buh.App.uploads.track()()
+
 	if buh.Upload == nil {
 		buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadUnknown)
 		return
 	}
 
+	if !buh.App.uploadQuota.admit(buh.Context, w, r) {
+		return
+	}
+
 	ct := r.Header.Get("Content-Type")
 	if ct != "" && ct != "application/octet-stream" {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(fmt.Errorf("bad Content-Type")))
@@ -133,6 +161,10 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if buh.chunkTooLong(w, r) {
+		return
+	}
+
 	cr := r.Header.Get("Content-Range")
 	cl := r.Header.Get("Content-Length")
 	if cr != "" && cl != "" {
@@ -141,8 +173,17 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
 			return
 		}
-		if start > end || start != buh.Upload.Size() {
-			buh.Errors = append(buh.Errors, v2.ErrorCodeRangeInvalid)
+		switch size := buh.Upload.Size(); {
+		case start > end:
+			buh.refuseChunk(w, r, fmt.Sprintf("invalid range %d-%d", start, end))
+			return
+		case start < size:
+			// A chunk sent again, as its response was lost, must not be
+			// appended twice.
+			buh.refuseChunk(w, r, fmt.Sprintf("range %d-%d overlaps the %d bytes received", start, end, size))
+			return
+		case start > size:
+			buh.refuseChunk(w, r, fmt.Sprintf("range %d-%d leaves a gap after the %d bytes received", start, end, size))
 			return
 		}
 
@@ -181,6 +222,8 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 // into the blob store and 201 Created is returned with the canonical
 // url of the blob.
 func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *http.Request) {
+	defer buh.App.uploads.track()()
+
 	if buh.Upload == nil {
 		buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadUnknown)
 		return
@@ -201,6 +244,14 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 		return
 	}
 
+	if buh.chunkTooLong(w, r) {
+		return
+	}
+
+	if !buh.App.uploadQuota.admit(buh.Context, w, r) {
+		return
+	}
+
 	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PUT"); err != nil {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
 		return
@@ -355,10 +406,40 @@ func (buh *blobUploadHandler) blobUploadResponse(w http.ResponseWriter, r *http.
 
 	w.Header().Set("Content-Length", "0")
 	w.Header().Set("Range", fmt.Sprintf("0-%d", endRange))
+	if minLength := buh.Config.Uploads.MinChunkLength; minLength > 0 {
+		w.Header().Set(chunkMinLengthHeader, strconv.FormatInt(minLength, 10))
+	}
+	if maxLength := buh.Config.Uploads.MaxChunkLength; maxLength > 0 {
+		w.Header().Set(chunkMaxLengthHeader, strconv.FormatInt(maxLength, 10))
+	}
 
 	return nil
 }
 
+// chunkTooLong reports whether the chunk of r is longer than the maximum
+// length configured, refusing it if it is.
+func (buh *blobUploadHandler) chunkTooLong(w http.ResponseWriter, r *http.Request) bool {
+	maxLength := buh.Config.Uploads.MaxChunkLength
+	if maxLength <= 0 || r.ContentLength <= maxLength {
+		return false
+	}
+	buh.refuseChunk(w, r, fmt.Sprintf("chunk of %d bytes exceeds the maximum of %d", r.ContentLength, maxLength))
+	return true
+}
+
+// refuseChunk refuses the chunk of r with a 416 Requested Range Not
+// Satisfiable response, whose Range and Location headers tell the client
+// where the upload stands so that it can resume from there.
+func (buh *blobUploadHandler) refuseChunk(w http.ResponseWriter, r *http.Request, detail string) {
+	if err := buh.blobUploadResponse(w, r, false); err != nil {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	// The response carries the error.
+	w.Header().Del("Content-Length")
+	buh.Errors = append(buh.Errors, v2.ErrorCodeRangeInvalid.WithDetail(detail))
+}
+
 // mountBlob attempts to mount a blob from another repository by its digest. If
 // successful, the blob is linked into the blob store and 201 Created is
 // returned with the canonical url of the blob.
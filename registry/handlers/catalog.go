@@ -7,8 +7,13 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/gorilla/handlers"
 )
@@ -43,6 +48,13 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 		maxEntries = maximumReturnedEntries
 	}
 
+	cursor := q.Get("cursor")
+	if ch.App.catalogSnapshots != nil && maxEntries > 0 && (cursor != "" || lastEntry == "") {
+		if ch.getCatalogSnapshot(w, r, cursor, maxEntries) {
+			return
+		}
+	}
+
 	repos := make([]string, maxEntries)
 
 	filled, err := ch.App.registry.Repositories(ch.Context, repos, lastEntry)
@@ -77,6 +89,119 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getCatalogSnapshot serves a page of a snapshot of the catalog, taken for
+// the first page when more pages follow. It returns false when the snapshot
+// of the cursor has expired, for the page to be listed from the current
+// catalog after the last entry instead.
+func (ch *catalogHandler) getCatalogSnapshot(w http.ResponseWriter, r *http.Request, cursor string, maxEntries int) bool {
+	var generation string
+	var repos []string
+	offset := 0
+	if cursor == "" {
+		enumerator, ok := ch.App.registry.(distribution.RepositoryEnumerator)
+		if !ok {
+			return false
+		}
+		err := enumerator.Enumerate(ch, func(repo string) error {
+			repos = append(repos, repo)
+			return nil
+		})
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			err = nil
+		}
+		if err != nil {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return true
+		}
+		if len(repos) > maxEntries {
+			generation, err = ch.App.catalogSnapshots.Save(ch, repos)
+			if err != nil {
+				ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				return true
+			}
+		}
+	} else {
+		var err error
+		generation, offset, err = parseCatalogCursor(cursor)
+		if err != nil {
+			ch.Errors = append(ch.Errors, v2.ErrorCodePaginationCursorInvalid.WithDetail(err))
+			return true
+		}
+		repos, err = ch.App.catalogSnapshots.Load(ch, generation)
+		if err == storage.ErrCatalogSnapshotUnknown {
+			dcontext.GetLogger(ch).Infof("catalog snapshot %s is unknown or expired, listing the current catalog", generation)
+			return false
+		}
+		if err != nil {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return true
+		}
+		if offset > len(repos) {
+			offset = len(repos)
+		}
+	}
+
+	end := offset + maxEntries
+	if end > len(repos) {
+		end = len(repos)
+	}
+	page := repos[offset:end]
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Add a link header continuing the snapshot if there are more entries
+	if end < len(repos) {
+		urlStr, err := createCursorLinkEntry(r.URL.String(), maxEntries, page[len(page)-1], fmt.Sprintf("%s.%d", generation, end))
+		if err != nil {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return true
+		}
+		w.Header().Set("Link", urlStr)
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(catalogAPIResponse{
+		Repositories: page,
+	}); err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+	return true
+}
+
+// parseCatalogCursor parses a cursor into the generation of the snapshot it
+// reads and the offset of the next page in it.
+func parseCatalogCursor(cursor string) (string, int, error) {
+	generation, offset, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return "", 0, fmt.Errorf("malformed cursor %q", cursor)
+	}
+	n, err := strconv.Atoi(offset)
+	if err != nil || n < 0 {
+		return "", 0, fmt.Errorf("malformed cursor %q", cursor)
+	}
+	return generation, n, nil
+}
+
+// createCursorLinkEntry creates the URL for the link header to the next page
+// of a snapshot of the catalog. The last entry is kept, for the page to be
+// listed from the current catalog should the snapshot expire.
+func createCursorLinkEntry(origURL string, maxEntries int, lastEntry, cursor string) (string, error) {
+	calledURL, err := url.Parse(origURL)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Add("n", strconv.Itoa(maxEntries))
+	v.Add("last", lastEntry)
+	v.Add("cursor", cursor)
+
+	calledURL.RawQuery = v.Encode()
+
+	calledURL.Fragment = ""
+	return fmt.Sprintf("<%s>; rel=\"next\"", calledURL.String()), nil
+}
+
 // Use the original URL from the request to create a new URL for
 // the link header
 func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
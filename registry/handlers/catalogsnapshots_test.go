@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+)
+
+func TestCatalogSnapshots(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver":       configuration.Parameters{},
+			"catalogsnapshots": configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	for _, image := range []string{"foo/aaaa", "foo/bbbb", "foo/cccc"} {
+		createRepository(env, t, image, "sometag")
+	}
+
+	getCatalog := func(values url.Values) ([]string, url.Values) {
+		catalogURL, err := env.builder.BuildCatalogURL(values)
+		checkErr(t, err, "building catalog url")
+		resp, err := http.Get(catalogURL)
+		checkErr(t, err, "fetching catalog")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching catalog", resp, http.StatusOK)
+
+		var ctlg catalogAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		link := resp.Header.Get("Link")
+		if link == "" {
+			return ctlg.Repositories, nil
+		}
+		matches := regexp.MustCompile("<(/v2/_catalog.*)>; rel=\"next\"").FindStringSubmatch(link)
+		if len(matches) != 2 {
+			t.Fatalf("unexpected link: %s", link)
+		}
+		linkURL, err := url.Parse(matches[1])
+		checkErr(t, err, "parsing link")
+		return ctlg.Repositories, linkURL.Query()
+	}
+
+	repos, next := getCatalog(url.Values{"n": []string{"2"}})
+	if !reflect.DeepEqual(repos, []string{"foo/aaaa", "foo/bbbb"}) {
+		t.Fatalf("unexpected first page: %v", repos)
+	}
+	if next.Get("cursor") == "" || next.Get("last") != "foo/bbbb" {
+		t.Fatalf("unexpected next page: %v", next)
+	}
+
+	// repositories created in between pages are not listed by the snapshot
+	createRepository(env, t, "foo/bbbc", "sometag")
+	repos, last := getCatalog(next)
+	if !reflect.DeepEqual(repos, []string{"foo/cccc"}) || last != nil {
+		t.Fatalf("unexpected second page: %v, %v", repos, last)
+	}
+
+	// pages of unknown snapshots are listed from the current catalog
+	next.Set("cursor", "00000000000000000001.2")
+	repos, _ = getCatalog(next)
+	if !reflect.DeepEqual(repos, []string{"foo/bbbc", "foo/cccc"}) {
+		t.Fatalf("unexpected page of an unknown snapshot: %v", repos)
+	}
+
+	catalogURL, err := env.builder.BuildCatalogURL(url.Values{"n": []string{"2"}, "cursor": []string{"malformed"}})
+	checkErr(t, err, "building catalog url")
+	resp, err := http.Get(catalogURL)
+	checkErr(t, err, "fetching catalog")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching catalog with a malformed cursor", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "fetching catalog with a malformed cursor", resp, v2.ErrorCodePaginationCursorInvalid)
+}
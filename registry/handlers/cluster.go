@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/health"
+	"github.com/distribution/distribution/v3/version"
+	"github.com/gomodule/redigo/redis"
+)
+
+// defaultClusterInterval is the time between heartbeats unless configured.
+const defaultClusterInterval = 10 * time.Second
+
+// clusterRedisKey names the redis hash of the heartbeats of the instances,
+// keyed by their names.
+const clusterRedisKey = "registry:cluster:instances"
+
+// The roles of registry instances.
+const (
+	clusterRolePrimary = "primary"
+	clusterRoleReplica = "replica"
+	clusterRoleProxy   = "proxy"
+)
+
+// clusterInstance is the heartbeat of a registry instance.
+type clusterInstance struct {
+	Name     string
+	Role     string
+	Version  string
+	Revision string
+
+	// Addr is the address the instance serves the API at, as configured.
+	Addr string
+
+	StartedAt time.Time
+	Heartbeat time.Time
+
+	// ExpiresAt is the time the instance stops being listed, unless it
+	// sends another heartbeat.
+	ExpiresAt time.Time
+
+	// Healthy is set when none of the health checks of the instance fail,
+	// and Checks holds the errors of those failing.
+	Healthy bool
+	Checks  map[string]string `json:",omitempty"`
+}
+
+// clusterStore keeps the heartbeats of the instances.
+type clusterStore interface {
+	// put records the heartbeat of an instance, replacing its previous one.
+	put(ctx context.Context, instance clusterInstance) error
+
+	// list returns the instances whose heartbeats have not expired by now,
+	// sorted by name.
+	list(ctx context.Context, now time.Time) ([]clusterInstance, error)
+}
+
+// redisClusterStore keeps the heartbeats of the instances in a redis hash,
+// which the instances listing it clear of the expired ones.
+type redisClusterStore struct {
+	pool *redis.Pool
+	key  string
+}
+
+func (rs *redisClusterStore) put(ctx context.Context, instance clusterInstance) error {
+	value, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	conn := rs.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("HSET", rs.key, instance.Name, value)
+	return err
+}
+
+func (rs *redisClusterStore) list(ctx context.Context, now time.Time) ([]clusterInstance, error) {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", rs.key))
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]clusterInstance, 0, len(values))
+	for name, value := range values {
+		var instance clusterInstance
+		if err := json.Unmarshal([]byte(value), &instance); err != nil || !instance.ExpiresAt.After(now) {
+			if _, err := conn.Do("HDEL", rs.key, name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+	return instances, nil
+}
+
+// cluster sends the heartbeats of the instance, and lists the instances of
+// the fleet from theirs.
+type cluster struct {
+	store    clusterStore
+	interval time.Duration
+	log      dcontext.Logger
+
+	// checkStatus returns the errors of the failing health checks; replaced
+	// in tests.
+	checkStatus func() map[string]string
+
+	mu   sync.Mutex
+	self clusterInstance
+}
+
+// newCluster returns the cluster of the configuration, keeping the heartbeats
+// in store.
+func newCluster(config *configuration.Configuration, store clusterStore, log dcontext.Logger) (*cluster, error) {
+	name := config.Cluster.Name
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("unable to name the instance of the cluster: %v", err)
+		}
+		name = hostname
+	}
+
+	role := config.Cluster.Role
+	switch role {
+	case "":
+		role = clusterRolePrimary
+		if config.Proxy.RemoteURL != "" {
+			role = clusterRoleProxy
+		}
+	case clusterRolePrimary, clusterRoleReplica, clusterRoleProxy:
+	default:
+		return nil, fmt.Errorf("unknown cluster role: %q", role)
+	}
+
+	interval := config.Cluster.Interval
+	if interval <= 0 {
+		interval = defaultClusterInterval
+	}
+
+	return &cluster{
+		store:       store,
+		interval:    interval,
+		log:         log,
+		checkStatus: health.CheckStatus,
+		self: clusterInstance{
+			Name:      name,
+			Role:      role,
+			Version:   version.Version,
+			Revision:  version.Revision,
+			Addr:      config.HTTP.Addr,
+			StartedAt: time.Now().UTC(),
+		},
+	}, nil
+}
+
+// run sends a heartbeat every interval.
+func (c *cluster) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.beat(ctx, time.Now().UTC()); err != nil {
+			c.log.Errorf("cluster: failed to send heartbeat: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// beat sends the heartbeat of the instance at now, with the state of its
+// health checks.
+func (c *cluster) beat(ctx context.Context, now time.Time) error {
+	checks := c.checkStatus()
+
+	c.mu.Lock()
+	c.self.Heartbeat = now
+	c.self.ExpiresAt = now.Add(3 * c.interval)
+	c.self.Healthy = len(checks) == 0
+	c.self.Checks = checks
+	self := c.self
+	c.mu.Unlock()
+
+	return c.store.put(ctx, self)
+}
+
+// Self returns the last heartbeat of the instance.
+func (c *cluster) Self() clusterInstance {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.self
+}
+
+// Instances returns the instances of the fleet, including this one, which
+// sent a heartbeat recently enough.
+func (c *cluster) Instances(ctx context.Context) ([]clusterInstance, error) {
+	return c.store.list(ctx, time.Now().UTC())
+}
+
+// startCluster schedules a goroutine which will periodically send the
+// heartbeats of the instance to redis, if clustering is enabled.
+func startCluster(ctx context.Context, config *configuration.Configuration, pool *redis.Pool, log dcontext.Logger) {
+	if !config.Cluster.Enabled {
+		return
+	}
+	if pool == nil {
+		panic("redis configuration required to enable the cluster")
+	}
+
+	c, err := newCluster(config, &redisClusterStore{pool: pool, key: clusterRedisKey}, log)
+	if err != nil {
+		panic(err)
+	}
+	setActiveCluster(c)
+	log.Infof("Sending the heartbeats of %s instance %s every %s", c.self.Role, c.self.Name, c.interval)
+	go c.run(ctx)
+}
+
+var (
+	// activeCluster is the cluster of the application last created,
+	// reported at /debug/vars and listed at /debug/cluster.
+	activeClusterMu sync.Mutex
+	activeCluster   *cluster
+)
+
+func setActiveCluster(c *cluster) {
+	activeClusterMu.Lock()
+	defer activeClusterMu.Unlock()
+	activeCluster = c
+}
+
+func getActiveCluster() *cluster {
+	activeClusterMu.Lock()
+	defer activeClusterMu.Unlock()
+	return activeCluster
+}
+
+// ClusterHandler responds, on GET, with the instances of the fleet sharing
+// the redis of the registry, their versions, roles and health.
+func ClusterHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c := getActiveCluster()
+	if c == nil {
+		http.Error(w, "the cluster is disabled", http.StatusNotFound)
+		return
+	}
+
+	instances, err := c.Instances(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Self      string
+		Instances []clusterInstance
+	}{c.Self().Name, instances})
+}
+
+func init() {
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+
+	registry.(*expvar.Map).Set("cluster", expvar.Func(func() interface{} {
+		if c := getActiveCluster(); c != nil {
+			return c.Self()
+		}
+		return nil
+	}))
+
+	http.HandleFunc("/debug/cluster", ClusterHandler)
+}
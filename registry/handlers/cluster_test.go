@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+)
+
+// memoryClusterStore keeps heartbeats in memory, as redis would for the
+// instances sharing it.
+type memoryClusterStore struct {
+	mu        sync.Mutex
+	instances map[string]clusterInstance
+}
+
+func (ms *memoryClusterStore) put(ctx context.Context, instance clusterInstance) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.instances[instance.Name] = instance
+	return nil
+}
+
+func (ms *memoryClusterStore) list(ctx context.Context, now time.Time) ([]clusterInstance, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var instances []clusterInstance
+	for name, instance := range ms.instances {
+		if !instance.ExpiresAt.After(now) {
+			delete(ms.instances, name)
+			continue
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+	return instances, nil
+}
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryClusterStore{instances: make(map[string]clusterInstance)}
+	newInstance := func(config configuration.Configuration) *cluster {
+		c, err := newCluster(&config, store, dcontext.GetLogger(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	var config configuration.Configuration
+	config.Cluster.Name = "registry-0"
+	primary := newInstance(config)
+	config.Cluster.Name = "cache-0"
+	config.Proxy.RemoteURL = "https://registry-1.docker.io"
+	proxy := newInstance(config)
+	proxy.checkStatus = func() map[string]string {
+		return map[string]string{"storagedriver_filesystem": "unavailable"}
+	}
+	config.Cluster.Name = "cache-1"
+	gone := newInstance(config)
+
+	if _, err := newCluster(&configuration.Configuration{Cluster: configuration.Cluster{Role: "leader"}}, store, dcontext.GetLogger(ctx)); err == nil {
+		t.Fatal("expected an error configuring an unknown role")
+	}
+
+	now := time.Now().UTC()
+	for _, c := range []*cluster{primary, proxy} {
+		if err := c.beat(ctx, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gone.beat(ctx, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	setActiveCluster(primary)
+	defer setActiveCluster(nil)
+
+	recorder := httptest.NewRecorder()
+	ClusterHandler(recorder, httptest.NewRequest(http.MethodPost, "/debug/cluster", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status of POST: %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	ClusterHandler(recorder, httptest.NewRequest(http.MethodGet, "/debug/cluster", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("unexpected status of GET: %d", recorder.Code)
+	}
+	var status struct {
+		Self      string
+		Instances []clusterInstance
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Self != "registry-0" || len(status.Instances) != 2 {
+		t.Fatalf("unexpected cluster: %+v", status)
+	}
+	if i := status.Instances[0]; i.Name != "cache-0" || i.Role != clusterRoleProxy || i.Healthy || len(i.Checks) != 1 {
+		t.Errorf("unexpected proxy instance: %+v", i)
+	}
+	if i := status.Instances[1]; i.Name != "registry-0" || i.Role != clusterRolePrimary || !i.Healthy || i.Version == "" {
+		t.Errorf("unexpected primary instance: %+v", i)
+	}
+}
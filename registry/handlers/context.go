@@ -2,14 +2,12 @@ package handlers
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
-	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -47,26 +45,20 @@ func (ctx *Context) Value(key interface{}) interface{} {
 }
 
 func getName(ctx context.Context) (name string) {
-	return dcontext.GetStringValue(ctx, "vars.name")
+	return dcontext.GetRepositoryName(ctx)
 }
 
 func getReference(ctx context.Context) (reference string) {
-	return dcontext.GetStringValue(ctx, "vars.reference")
+	return dcontext.GetReference(ctx)
 }
 
-var errDigestNotAvailable = fmt.Errorf("digest not available in context")
-
 func getDigest(ctx context.Context) (dgst digest.Digest, err error) {
-	dgstStr := dcontext.GetStringValue(ctx, "vars.digest")
-
-	if dgstStr == "" {
+	d, err := dcontext.GetDigest(ctx)
+	if err == dcontext.ErrDigestNotAvailable {
 		dcontext.GetLogger(ctx).Errorf("digest not available")
-		return "", errDigestNotAvailable
-	}
-
-	d, err := digest.Parse(dgstStr)
-	if err != nil {
-		dcontext.GetLogger(ctx).Errorf("error parsing digest=%q: %v", dgstStr, err)
+		return "", err
+	} else if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error parsing digest=%q: %v", dcontext.GetStringValue(ctx, dcontext.DigestKey), err)
 		return "", err
 	}
 
@@ -74,13 +66,13 @@ func getDigest(ctx context.Context) (dgst digest.Digest, err error) {
 }
 
 func getUploadUUID(ctx context.Context) (uuid string) {
-	return dcontext.GetStringValue(ctx, "vars.uuid")
+	return dcontext.GetUploadUUID(ctx)
 }
 
 // getUserName attempts to resolve a username from the context and request. If
 // a username cannot be resolved, the empty string is returned.
 func getUserName(ctx context.Context, r *http.Request) string {
-	username := dcontext.GetStringValue(ctx, auth.UserNameKey)
+	username := dcontext.GetUserName(ctx)
 
 	// Fallback to request user with basic auth
 	if username == "" {
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// defaultCORSMethods are the methods allowed by the CORS rules listing none,
+// those of pulls.
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead}
+
+// defaultCORSHeaders are the request headers allowed by the CORS rules
+// listing none, those clients send to authenticate, negotiate manifests and
+// upload blobs in chunks.
+var defaultCORSHeaders = []string{"Authorization", "Accept", "Content-Type", "Content-Range"}
+
+// defaultCORSExposedHeaders are the response headers exposed by the CORS
+// rules listing none, those clients read to follow the protocol: the digests
+// of what they pulled or pushed, the location, progress and chunk lengths of
+// uploads, pagination links and authentication challenges.
+var defaultCORSExposedHeaders = []string{
+	"Docker-Content-Digest",
+	"Docker-Distribution-API-Version",
+	"Docker-Upload-UUID",
+	"Location",
+	"Range",
+	"Link",
+	"WWW-Authenticate",
+	"OCI-Subject",
+	chunkMinLengthHeader,
+	chunkMaxLengthHeader,
+}
+
+// corsRule allows cross-origin requests from browsers for a list of origins.
+type corsRule struct {
+	origins   map[string]bool
+	wildcards []string
+	anyOrigin bool
+
+	methods        map[string]bool
+	allowMethods   string
+	headers        map[string]bool
+	allowHeaders   string
+	exposedHeaders string
+	credentials    bool
+	maxAge         string
+}
+
+func newCORSRule(config configuration.CORSRule) (*corsRule, error) {
+	if len(config.Origins) == 0 {
+		return nil, errors.New("no origin listed")
+	}
+
+	rule := &corsRule{
+		origins:     make(map[string]bool, len(config.Origins)),
+		methods:     make(map[string]bool),
+		headers:     make(map[string]bool),
+		credentials: config.Credentials,
+	}
+
+	for _, origin := range config.Origins {
+		origin = strings.TrimSuffix(origin, "/")
+		switch n := strings.Count(origin, "*"); {
+		case origin == "*":
+			rule.anyOrigin = true
+		case n == 0:
+			rule.origins[origin] = true
+		case n == 1 && strings.Contains(origin, "://*."):
+			rule.wildcards = append(rule.wildcards, origin)
+		default:
+			return nil, fmt.Errorf("invalid origin %q, expected *, an origin or an origin whose host starts with *.", origin)
+		}
+	}
+
+	methods := defaultCORSMethods
+	if len(config.Methods) > 0 {
+		methods = make([]string, len(config.Methods))
+		for i, method := range config.Methods {
+			methods[i] = strings.ToUpper(method)
+		}
+	}
+	for _, method := range methods {
+		rule.methods[method] = true
+	}
+	rule.allowMethods = strings.Join(methods, ", ")
+
+	headers := defaultCORSHeaders
+	if len(config.Headers) > 0 {
+		headers = config.Headers
+	}
+	for _, header := range headers {
+		rule.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	rule.allowHeaders = strings.Join(headers, ", ")
+
+	exposed := defaultCORSExposedHeaders
+	if len(config.ExposedHeaders) > 0 {
+		exposed = config.ExposedHeaders
+	}
+	rule.exposedHeaders = strings.Join(exposed, ", ")
+
+	if config.MaxAge < 0 {
+		return nil, errors.New("maxage must not be negative")
+	}
+	if config.MaxAge > 0 {
+		rule.maxAge = strconv.FormatInt(int64(config.MaxAge.Seconds()), 10)
+	}
+
+	return rule, nil
+}
+
+// matches reports whether the rule applies to origin. A wildcard such as
+// https://*.example.com matches the subdomains of example.com, at any depth,
+// but not example.com itself.
+func (rule *corsRule) matches(origin string) bool {
+	if rule.anyOrigin || rule.origins[origin] {
+		return true
+	}
+	for _, wildcard := range rule.wildcards {
+		prefix, suffix, _ := strings.Cut(wildcard, "*")
+		if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the rule allows the method and the headers a
+// preflight request asks for.
+func (rule *corsRule) allows(r *http.Request) bool {
+	if !rule.methods[r.Header.Get("Access-Control-Request-Method")] {
+		return false
+	}
+	for _, requested := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(requested, ",") {
+			header = strings.TrimSpace(header)
+			if header != "" && !rule.headers[http.CanonicalHeaderKey(header)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// preflight answers a CORS preflight request, without authenticating it as
+// browsers send no credentials with them. Requests for methods or headers
+// the rule does not allow are refused.
+func (rule *corsRule) preflight(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	if !rule.allows(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	header.Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+	if rule.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	header.Set("Access-Control-Allow-Methods", rule.allowMethods)
+	header.Set("Access-Control-Allow-Headers", rule.allowHeaders)
+	if rule.maxAge != "" {
+		header.Set("Access-Control-Max-Age", rule.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setHeaders sets the headers of the responses to actual cross-origin
+// requests. The origin is echoed rather than answered with *, which browsers
+// reject for requests with credentials.
+func (rule *corsRule) setHeaders(header http.Header, origin string) {
+	header.Add("Vary", "Origin")
+	header.Set("Access-Control-Allow-Origin", origin)
+	if rule.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	header.Set("Access-Control-Expose-Headers", rule.exposedHeaders)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+)
+
+func TestCORSRuleMatches(t *testing.T) {
+	if _, err := newCORSRule(configuration.CORSRule{}); err == nil {
+		t.Fatal("expected an error for a rule without origin")
+	}
+	if _, err := newCORSRule(configuration.CORSRule{Origins: []string{"https://ui.*.com"}}); err == nil {
+		t.Fatal("expected an error for a wildcard not covering the start of the host")
+	}
+
+	rule, err := newCORSRule(configuration.CORSRule{Origins: []string{"https://ui.example.com/", "https://*.example.org"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for origin, expected := range map[string]bool{
+		"https://ui.example.com":        true,
+		"http://ui.example.com":         false,
+		"https://ui.example.com.evil":   false,
+		"https://ui.example.org":        true,
+		"https://a.b.example.org":       true,
+		"https://example.org":           false,
+		"https://.example.org":          false,
+		"https://evil.com/.example.org": false,
+		"https://ui.example.org:8443":   false,
+		"https://evilexample.org":       false,
+	} {
+		if matches := rule.matches(origin); matches != expected {
+			t.Errorf("%s: unexpected match: %v != %v", origin, matches, expected)
+		}
+	}
+}
+
+// TestCORSUploads ensures browsers can push blobs: the preflight requests of
+// uploads are answered, and the headers of the responses clients need to
+// follow uploads through are exposed.
+func TestCORSUploads(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.ResponseHeaders.CORS = []configuration.CORSRule{{
+		Origins:     []string{"https://*.example.com"},
+		Methods:     []string{"GET", "HEAD", "POST", "PATCH", "PUT"},
+		Credentials: true,
+	}}
+	app := NewApp(context.Background(), &config)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	builder, err := v2.NewURLBuilderFromString(server.URL, false)
+	if err != nil {
+		t.Fatalf("error creating urlbuilder: %v", err)
+	}
+	ref, _ := reference.WithName("foo/bar")
+	uploadURL, err := builder.BuildBlobUploadURL(ref)
+	if err != nil {
+		t.Fatalf("error building upload url: %v", err)
+	}
+
+	do := func(method, requestMethod, requestHeaders string) *http.Response {
+		req, _ := http.NewRequest(method, uploadURL, nil)
+		req.Header.Set("Origin", "https://ui.example.com")
+		if requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", requestMethod)
+			req.Header.Set("Access-Control-Request-Headers", requestHeaders)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error during %s: %v", method, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do(http.MethodOptions, http.MethodPatch, "content-type, content-range")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status code of preflight request: %d", resp.StatusCode)
+	}
+	for name, expected := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://ui.example.com",
+		"Access-Control-Allow-Methods":     "GET, HEAD, POST, PATCH, PUT",
+		"Access-Control-Allow-Headers":     strings.Join(defaultCORSHeaders, ", "),
+		"Access-Control-Allow-Credentials": "true",
+	} {
+		if actual := resp.Header.Get(name); actual != expected {
+			t.Errorf("unexpected %s header: %q != %q", name, actual, expected)
+		}
+	}
+
+	if resp := do(http.MethodOptions, http.MethodDelete, ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected status code of preflight request for a method not allowed: %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodOptions, http.MethodPatch, "X-Custom"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected status code of preflight request for a header not allowed: %d", resp.StatusCode)
+	}
+
+	resp = do(http.MethodPost, "", "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status code starting upload: %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Location") == "" {
+		t.Fatal("expected a Location header starting upload")
+	}
+	exposed := resp.Header.Get("Access-Control-Expose-Headers")
+	for _, name := range []string{"Location", "Docker-Upload-UUID", "Range", "Docker-Content-Digest"} {
+		if !strings.Contains(exposed, name) {
+			t.Errorf("expected %s to be exposed, got %q", name, exposed)
+		}
+	}
+}
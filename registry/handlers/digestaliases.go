@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/opencontainers/go-digest"
+)
+
+// redirectDigestAlias redirects a request of the content addressed by dgst,
+// unknown to the repository, to the digest the content was re-addressed to,
+// when the digest aliases map dgst, with the URL built by buildURL for the
+// new digest. It reports whether the request was redirected.
+func redirectDigestAlias(ctx *Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest, buildURL func(reference.Canonical) (string, error)) bool {
+	if ctx.App.digestAliases == nil {
+		return false
+	}
+
+	to, err := ctx.App.digestAliases.Get(ctx, dgst)
+	if err != nil {
+		if err != storage.ErrDigestAliasUnknown {
+			dcontext.GetLogger(ctx).Errorf("failed to resolve the alias of %s: %v", dgst, err)
+		}
+		return false
+	}
+	ref, err := reference.WithDigest(ctx.Repository.Named(), to)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("invalid alias %s of %s: %v", to, dgst, err)
+		return false
+	}
+	location, err := buildURL(ref)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("failed to build the URL of alias %s of %s: %v", to, dgst, err)
+		return false
+	}
+
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusPermanentRedirect)
+	return true
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDigestAliases(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver":    configuration.Parameters{},
+			"digestaliases": configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	dgst := createRepository(env, t, imageName.Name(), "sometag")
+	layerFile, layerDigest, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, layerFile)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name     string
+		to       digest.Digest
+		buildURL func(reference.Canonical) (string, error)
+	}{
+		{"manifest", dgst, func(ref reference.Canonical) (string, error) { return env.builder.BuildManifestURL(ref) }},
+		{"blob", layerDigest, func(ref reference.Canonical) (string, error) { return env.builder.BuildBlobURL(ref) }},
+	} {
+		from := digest.SHA512.FromString(tc.name)
+		fromRef, _ := reference.WithDigest(imageName, from)
+		toRef, _ := reference.WithDigest(imageName, tc.to)
+		fromURL, err := tc.buildURL(fromRef)
+		checkErr(t, err, "building url")
+		toURL, err := tc.buildURL(toRef)
+		checkErr(t, err, "building url")
+
+		resp, err := client.Get(fromURL)
+		checkErr(t, err, "fetching "+tc.name)
+		resp.Body.Close()
+		checkResponse(t, "fetching "+tc.name+" without an alias", resp, http.StatusNotFound)
+
+		if err := env.app.digestAliases.Set(ctx, from, tc.to); err != nil {
+			t.Fatal(err)
+		}
+		resp, err = client.Get(fromURL)
+		checkErr(t, err, "fetching "+tc.name)
+		resp.Body.Close()
+		checkResponse(t, "fetching "+tc.name+" by its alias", resp, http.StatusPermanentRedirect)
+		checkHeaders(t, resp, http.Header{"Location": []string{toURL}})
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+)
+
+// dryRunHeader is the header of the delete requests which evaluate the
+// deletion, and report what it would delete, without deleting.
+const dryRunHeader = "X-Dry-Run"
+
+// dryRun reports whether the request only evaluates what it would delete.
+func dryRun(r *http.Request) bool {
+	return r.Header.Get(dryRunHeader) == "true"
+}
+
+// dryRunSupported reports whether the request can be evaluated without
+// being made: deletions of manifests, tags and blobs.
+func dryRunSupported(r *http.Request) bool {
+	if r.Method != http.MethodDelete {
+		return false
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	switch route.GetName() {
+	case v2.RouteNameManifest, v2.RouteNameBlob:
+		return true
+	}
+	return false
+}
+
+// dryRunAPIResponse lists what a delete request would have deleted.
+type dryRunAPIResponse struct {
+	Name      string          `json:"name"`
+	Manifests []digest.Digest `json:"manifests,omitempty"`
+	Tags      []string        `json:"tags,omitempty"`
+	Blobs     []digest.Digest `json:"blobs,omitempty"`
+}
+
+// serveDryRun responds to a delete request evaluated without deleting with
+// what it would have deleted.
+func serveDryRun(ctx *Context, w http.ResponseWriter, response dryRunAPIResponse) {
+	response.Name = ctx.Repository.Named().Name()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(dryRunHeader, "true")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+}
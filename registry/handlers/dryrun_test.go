@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDeleteDryRun(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"delete":     configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+	layerFile, layerDigest, err := testutil.CreateRandomTarFile()
+	checkErr(t, err, "creating random layer")
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, layerFile)
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building tag URL")
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest URL")
+	layerRef, _ := reference.WithDigest(imageName, layerDigest)
+	blobURL, err := env.builder.BuildBlobURL(layerRef)
+	checkErr(t, err, "building blob URL")
+	unknownRef, _ := reference.WithTag(imageName, "unknown")
+	unknownURL, err := env.builder.BuildManifestURL(unknownRef)
+	checkErr(t, err, "building tag URL")
+
+	deleteDryRun := func(u string) *http.Response {
+		req, err := http.NewRequest(http.MethodDelete, u, nil)
+		checkErr(t, err, "building delete request")
+		req.Header.Set("X-Dry-Run", "true")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "deleting with dry run")
+		return resp
+	}
+
+	for _, tc := range []struct {
+		url      string
+		expected dryRunAPIResponse
+	}{
+		{tagURL, dryRunAPIResponse{Name: "foo/bar", Tags: []string{"latest"}}},
+		{manifestURL, dryRunAPIResponse{Name: "foo/bar", Manifests: []digest.Digest{dgst}, Tags: []string{"latest"}}},
+		{blobURL, dryRunAPIResponse{Name: "foo/bar", Blobs: []digest.Digest{layerDigest}}},
+	} {
+		resp := deleteDryRun(tc.url)
+		checkResponse(t, "deleting with dry run", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{"X-Dry-Run": []string{"true"}})
+		var deleted dryRunAPIResponse
+		err := json.NewDecoder(resp.Body).Decode(&deleted)
+		resp.Body.Close()
+		checkErr(t, err, "decoding dry run response")
+		if !reflect.DeepEqual(deleted, tc.expected) {
+			t.Fatalf("unexpected dry run of %s: %v", tc.url, deleted)
+		}
+
+		resp, err = http.Head(tc.url)
+		checkErr(t, err, "checking content still exists")
+		checkResponse(t, "checking content still exists", resp, http.StatusOK)
+	}
+
+	resp := deleteDryRun(unknownURL)
+	resp.Body.Close()
+	checkResponse(t, "deleting unknown tag with dry run", resp, http.StatusNotFound)
+
+	// requests which cannot be evaluated are refused
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	resp = deleteDryRun(uploadURLBase)
+	resp.Body.Close()
+	checkResponse(t, "cancelling upload with dry run", resp, http.StatusMethodNotAllowed)
+}
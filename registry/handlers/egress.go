@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+// maxEgressChunk bounds the number of bytes written between two rate limit
+// checks, so that limits are enforced smoothly rather than in large steps.
+const maxEgressChunk = 32 * 1024
+
+// maxIdleBuckets is the number of per subject or per repository buckets
+// kept before idle ones are dropped.
+const maxIdleBuckets = 4096
+
+var (
+	// throttledBytes counts the bytes whose sending was delayed by an
+	// egress limit, labeled by the scope of the limit which delayed it most.
+	throttledBytes = prometheus.EgressNamespace.NewLabeledCounter("throttled_bytes", "The number of blob bytes delayed by egress rate limits", "scope")
+
+	// throttleDelay tracks the time blob downloads spend waiting on egress
+	// limits.
+	throttleDelay = prometheus.EgressNamespace.NewLabeledTimer("throttle_delay", "The number of seconds blob writes are delayed by egress rate limits", "scope")
+)
+
+func init() {
+	metrics.Register(prometheus.EgressNamespace)
+}
+
+// tokenBucket is a token bucket holding up to burst tokens, refilled at rate
+// tokens per second. Callers are allowed to take more tokens than are
+// available, leaving the bucket in debt, and wait for the time it takes to
+// pay the debt back. This keeps concurrent users of a shared bucket within
+// its rate while serving them in the order they arrived.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit configuration.EgressLimit, now time.Time) *tokenBucket {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Rate
+	}
+
+	return &tokenBucket{
+		rate:   float64(limit.Rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// take removes n tokens from the bucket and returns how long the caller has
+// to wait before sending them.
+func (b *tokenBucket) take(now time.Time, n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// idle reports whether the bucket is full, in which case dropping it and
+// later starting over with a new one makes no difference.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// bucketSet holds the token buckets of a limit applied per key, such as per
+// subject or per repository.
+type bucketSet struct {
+	// newBucket returns a full bucket for key.
+	newBucket func(key string, now time.Time) *tokenBucket
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newBucketSet(limit configuration.EgressLimit) *bucketSet {
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	return &bucketSet{
+		newBucket: func(key string, now time.Time) *tokenBucket {
+			return newTokenBucket(limit, now)
+		},
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (s *bucketSet) get(key string, now time.Time) *tokenBucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, ok := s.buckets[key]; ok {
+		return b
+	}
+
+	if len(s.buckets) >= maxIdleBuckets {
+		for k, b := range s.buckets {
+			if b.idle(now) {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	b := s.newBucket(key, now)
+	s.buckets[key] = b
+	return b
+}
+
+// egressLimiter applies the configured egress limits to blob downloads.
+type egressLimiter struct {
+	connection configuration.EgressLimit
+	subjects   *bucketSet
+	repos      *bucketSet
+}
+
+// newEgressLimiter returns a limiter for the configured limits, or nil when
+// no limit is configured.
+func newEgressLimiter(config configuration.Egress) *egressLimiter {
+	if config.Connection.Rate <= 0 && config.Subject.Rate <= 0 && config.Repository.Rate <= 0 {
+		return nil
+	}
+
+	return &egressLimiter{
+		connection: config.Connection,
+		subjects:   newBucketSet(config.Subject),
+		repos:      newBucketSet(config.Repository),
+	}
+}
+
+// limit returns a response writer sending the body of a blob download no
+// faster than the limits applying to it allow.
+func (l *egressLimiter) limit(ctx *Context, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if l == nil {
+		return w
+	}
+
+	now := time.Now()
+	lw := &limitedResponseWriter{
+		ResponseWriter: w,
+		ctx:            ctx,
+		chunk:          maxEgressChunk,
+	}
+
+	if l.connection.Rate > 0 {
+		lw.add("connection", newTokenBucket(l.connection, now))
+	}
+
+	if l.subjects != nil {
+		subject := getUserName(ctx, r)
+		if subject == "" {
+			subject = dcontext.RemoteIP(r)
+		}
+		lw.add("subject", l.subjects.get(subject, now))
+	}
+
+	if l.repos != nil && ctx.Repository != nil {
+		lw.add("repository", l.repos.get(ctx.Repository.Named().Name(), now))
+	}
+
+	return lw
+}
+
+// limitedResponseWriter delays writes to the response as required by a set
+// of token buckets.
+type limitedResponseWriter struct {
+	http.ResponseWriter
+
+	ctx     context.Context
+	chunk   int
+	scopes  []string
+	buckets []*tokenBucket
+}
+
+func (w *limitedResponseWriter) add(scope string, b *tokenBucket) {
+	w.scopes = append(w.scopes, scope)
+	w.buckets = append(w.buckets, b)
+	if burst := int(b.burst); burst > 0 && burst < w.chunk {
+		w.chunk = burst
+	}
+}
+
+func (w *limitedResponseWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if n > w.chunk {
+			n = w.chunk
+		}
+
+		if err := w.wait(n); err != nil {
+			return written, err
+		}
+
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// wait takes n tokens from every bucket and sleeps for the longest delay
+// any of them requires, or until the request is canceled.
+func (w *limitedResponseWriter) wait(n int) error {
+	now := time.Now()
+
+	var (
+		delay time.Duration
+		scope string
+	)
+	for i, b := range w.buckets {
+		if d := b.take(now, n); d > delay {
+			delay, scope = d, w.scopes[i]
+		}
+	}
+
+	if delay <= 0 {
+		return nil
+	}
+
+	throttledBytes.WithValues(scope).Inc(float64(n))
+	defer throttleDelay.WithValues(scope).UpdateSince(now)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(configuration.EgressLimit{Rate: 1000, Burst: 500}, now)
+
+	if d := b.take(now, 500); d != 0 {
+		t.Fatalf("expected burst to be available immediately, got delay %v", d)
+	}
+	if d := b.take(now, 250); d != 250*time.Millisecond {
+		t.Fatalf("expected 250ms delay, got %v", d)
+	}
+
+	// A second taker of the same bucket queues behind the first.
+	if d := b.take(now, 250); d != 500*time.Millisecond {
+		t.Fatalf("expected 500ms delay, got %v", d)
+	}
+
+	// Refilling never exceeds the burst.
+	later := now.Add(time.Hour)
+	if !b.idle(later) {
+		t.Fatal("expected bucket to be idle after an hour")
+	}
+	if d := b.take(later, 600); d != 100*time.Millisecond {
+		t.Fatalf("expected 100ms delay beyond burst, got %v", d)
+	}
+}
+
+func TestLimitedResponseWriter(t *testing.T) {
+	limit := configuration.EgressLimit{Rate: 1 << 20, Burst: 64 << 10}
+	rec := httptest.NewRecorder()
+	w := &limitedResponseWriter{ResponseWriter: rec, ctx: context.Background(), chunk: maxEgressChunk}
+	w.add("connection", newTokenBucket(limit, time.Now()))
+
+	if w.chunk != maxEgressChunk {
+		t.Fatalf("unexpected chunk size %d", w.chunk)
+	}
+
+	payload := bytes.Repeat([]byte("a"), 320<<10)
+	start := time.Now()
+	n, err := w.Write(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(payload) || !bytes.Equal(rec.Body.Bytes(), payload) {
+		t.Fatalf("payload was not written completely: %d bytes", n)
+	}
+
+	// 64KiB are sent immediately, the remaining 256KiB at 1MiB/s.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("write was not throttled: took %v", elapsed)
+	}
+}
+
+func TestLimitedResponseWriterCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	limit := configuration.EgressLimit{Rate: 1024}
+	rec := httptest.NewRecorder()
+	w := &limitedResponseWriter{ResponseWriter: rec, ctx: ctx, chunk: maxEgressChunk}
+	w.add("subject", newTokenBucket(limit, time.Now()))
+
+	if w.chunk != 1024 {
+		t.Fatalf("expected chunk to be bounded by the burst, got %d", w.chunk)
+	}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	n, err := w.Write(make([]byte, 1<<20))
+	if err != context.Canceled {
+		t.Fatalf("expected write to be canceled, got %v", err)
+	}
+	if n != 1024 {
+		t.Fatalf("expected only the burst to be written, got %d bytes", n)
+	}
+}
+
+func TestNewEgressLimiterDisabled(t *testing.T) {
+	if l := newEgressLimiter(configuration.Egress{}); l != nil {
+		t.Fatal("expected no limiter without configured limits")
+	}
+
+	var l *egressLimiter
+	rec := httptest.NewRecorder()
+	if w := l.limit(nil, rec, nil); w != rec {
+		t.Fatal("expected a nil limiter to return the response writer unchanged")
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/extension"
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	extension.RegisterRoute(extension.VendorRoute{
+		Vendor: "acme",
+		Path:   "hello",
+		Dispatcher: func(ctx *extension.Context, r *http.Request) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "hello %s", ctx.Driver.Name())
+			})
+		},
+	})
+
+	extension.RegisterRoute(extension.VendorRoute{
+		Vendor:     "acme",
+		Path:       "status/{id}",
+		Repository: true,
+		Dispatcher: func(ctx *extension.Context, r *http.Request) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "%s %s", ctx.Repository.Named().Name(), mux.Vars(r)["id"])
+			})
+		},
+	})
+}
+
+// TestVendorRoutes checks that the routes of vendor extensions are served by
+// apps, and that they are served again by apps created afterwards.
+func TestVendorRoutes(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		server := httptest.NewServer(NewApp(context.Background(), &config))
+
+		for path, expected := range map[string]string{
+			"/v2/_ext/acme/hello":             "hello inmemory",
+			"/v2/foo/bar/_ext/acme/status/42": "foo/bar 42",
+		} {
+			resp, err := http.Get(server.URL + path)
+			if err != nil {
+				t.Fatalf("unexpected error during GET: %v", err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != http.StatusOK || string(body) != expected {
+				t.Fatalf("unexpected response for %s: %d %q", path, resp.StatusCode, body)
+			}
+		}
+
+		server.Close()
+	}
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/docker/go-metrics"
+)
+
+// errFaultTruncated is returned by the writes of responses truncated by an
+// injected fault, so that the handler stops writing the body.
+var errFaultTruncated = errors.New("response truncated by an injected fault")
+
+// injectedFaults counts the faults injected, labeled by the class of the
+// route and the kind of fault.
+var injectedFaults = prometheus.FaultsNamespace.NewLabeledCounter("injected", "The number of faults injected into requests", "class", "fault")
+
+func init() {
+	metrics.Register(prometheus.FaultsNamespace)
+}
+
+// faultInjector injects the configured faults into the requests of the
+// classes of routes.
+type faultInjector struct {
+	rules map[string]configuration.FaultRule
+
+	// mu guards random, which is not safe for concurrent use.
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// newFaultInjector returns the injector of the faults of config, or nil if
+// none is configured.
+func newFaultInjector(config configuration.Faults) (*faultInjector, error) {
+	if len(config.Routes) == 0 {
+		return nil, nil
+	}
+
+	rules := make(map[string]configuration.FaultRule, len(config.Routes))
+	for class, rule := range config.Routes {
+		if class != defaultRouteClass && !knownRouteClass(class) {
+			return nil, fmt.Errorf("unknown route class %q", class)
+		}
+		for name, percent := range map[string]float64{
+			"latencypercent":  rule.LatencyPercent,
+			"errorpercent":    rule.ErrorPercent,
+			"truncatepercent": rule.TruncatePercent,
+		} {
+			if percent < 0 || percent > 100 {
+				return nil, fmt.Errorf("%s: %s must be between 0 and 100", class, name)
+			}
+		}
+		if rule.Latency < 0 {
+			return nil, fmt.Errorf("%s: latency must not be negative", class)
+		}
+		if rule.ErrorStatus == 0 {
+			rule.ErrorStatus = http.StatusServiceUnavailable
+		}
+		if rule.ErrorStatus < 500 || rule.ErrorStatus > 599 {
+			return nil, fmt.Errorf("%s: errorstatus must be a 5xx status", class)
+		}
+		rules[class] = rule
+	}
+
+	return &faultInjector{
+		rules:  rules,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// roll reports whether a fault injected into percent of the requests is
+// injected into this one.
+func (fi *faultInjector) roll(percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.random.Float64()*100 < percent
+}
+
+// handler wraps the handler of the route named routeName.
+func (fi *faultInjector) handler(routeName string, handler http.Handler) http.Handler {
+	if fi == nil {
+		return handler
+	}
+
+	class := routeClass(routeName)
+	rule, ok := fi.rules[class]
+	if !ok {
+		rule, ok = fi.rules[defaultRouteClass]
+	}
+	if !ok {
+		return handler
+	}
+	if class == "" {
+		class = defaultRouteClass
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fi.roll(rule.LatencyPercent) {
+			injectedFaults.WithValues(class, "latency").Inc(1)
+			select {
+			case <-time.After(rule.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if fi.roll(rule.ErrorPercent) {
+			injectedFaults.WithValues(class, "error").Inc(1)
+			dcontext.GetLogger(r.Context()).Warnf("injecting fault: responding %d", rule.ErrorStatus)
+			code := errcode.ErrorCodeUnknown
+			if rule.ErrorStatus == http.StatusServiceUnavailable {
+				code = errcode.ErrorCodeUnavailable
+			}
+			// errcode.ServeJSON would respond with the status of the
+			// code rather than the one configured.
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(rule.ErrorStatus)
+			if err := json.NewEncoder(w).Encode(errcode.Errors{code.WithMessage("fault injected")}); err != nil {
+				dcontext.GetLogger(r.Context()).Errorf("error serving error json: %v", err)
+			}
+			return
+		}
+
+		if r.Method == http.MethodGet && fi.roll(rule.TruncatePercent) {
+			tw := &truncatingResponseWriter{ResponseWriter: w, limit: -1}
+			handler.ServeHTTP(tw, r)
+			if tw.truncated {
+				injectedFaults.WithValues(class, "truncate").Inc(1)
+				dcontext.GetLogger(r.Context()).Warnf("injecting fault: truncated response after %d bytes", tw.written)
+			}
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// truncatingResponseWriter cuts off the body of a successful response
+// declaring its length halfway. The server closes the connection of
+// responses shorter than their Content-Length.
+type truncatingResponseWriter struct {
+	http.ResponseWriter
+
+	// limit is the number of bytes of the body written, or -1 to write
+	// all of them.
+	limit       int64
+	written     int64
+	truncated   bool
+	wroteHeader bool
+}
+
+func (w *truncatingResponseWriter) WriteHeader(status int) {
+	// Informational responses are followed by the final one.
+	if status >= 200 {
+		w.wroteHeader = true
+	}
+	if status == http.StatusOK || status == http.StatusPartialContent {
+		if length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && length > 1 {
+			w.limit = length / 2
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *truncatingResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.limit < 0 {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.truncated {
+		return 0, errFaultTruncated
+	}
+	if remaining := w.limit - w.written; int64(len(p)) > remaining {
+		n, err := w.ResponseWriter.Write(p[:remaining])
+		w.written += int64(n)
+		w.truncated = true
+		if err != nil {
+			return n, err
+		}
+		return n, errFaultTruncated
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *truncatingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+)
+
+func TestFaultInjectorConfig(t *testing.T) {
+	if fi, err := newFaultInjector(configuration.Faults{}); fi != nil || err != nil {
+		t.Fatalf("expected no injector without faults: %v, %v", fi, err)
+	}
+
+	for _, rules := range []map[string]configuration.FaultRule{
+		{"unknown": {ErrorPercent: 10}},
+		{"blobs": {ErrorPercent: 150}},
+		{"blobs": {LatencyPercent: -1}},
+		{"blobs": {Latency: -time.Second, LatencyPercent: 10}},
+		{"blobs": {ErrorPercent: 10, ErrorStatus: http.StatusNotFound}},
+	} {
+		if _, err := newFaultInjector(configuration.Faults{Routes: rules}); err == nil {
+			t.Errorf("expected an error configuring faults %v", rules)
+		}
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	fi, err := newFaultInjector(configuration.Faults{Routes: map[string]configuration.FaultRule{
+		"blobs":     {TruncatePercent: 100, Latency: 50 * time.Millisecond, LatencyPercent: 100},
+		"manifests": {ErrorPercent: 100, ErrorStatus: http.StatusBadGateway},
+		"tags":      {ErrorPercent: 100},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := strings.Repeat("a", 1000)
+	served := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		io.WriteString(w, body)
+	})
+
+	// routes of classes without faults are served as they are
+	w := httptest.NewRecorder()
+	fi.handler(v2.RouteNameCatalog, served).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/_catalog", nil))
+	if w.Code != http.StatusOK || w.Body.String() != body {
+		t.Fatalf("unexpected response of a route without faults: %d", w.Code)
+	}
+
+	for routeName, expected := range map[string]struct {
+		status int
+		code   errcode.ErrorCode
+	}{
+		v2.RouteNameManifest: {http.StatusBadGateway, errcode.ErrorCodeUnknown},
+		v2.RouteNameTags:     {http.StatusServiceUnavailable, errcode.ErrorCodeUnavailable},
+	} {
+		w := httptest.NewRecorder()
+		fi.handler(routeName, served).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/foo/bar/", nil))
+		if w.Code != expected.status {
+			t.Fatalf("unexpected status of %s: %d", routeName, w.Code)
+		}
+		var errs struct {
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&errs); err != nil {
+			t.Fatal(err)
+		}
+		if len(errs.Errors) != 1 || errs.Errors[0].Code != expected.code.String() {
+			t.Fatalf("unexpected errors of %s: %v", routeName, errs)
+		}
+	}
+
+	// blob downloads are delayed, and their connection closed halfway
+	server := httptest.NewServer(fi.handler(v2.RouteNameBlob, served))
+	defer server.Close()
+	start := time.Now()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("response not delayed: %v", elapsed)
+	}
+	read, err := io.ReadAll(resp.Body)
+	if err != io.ErrUnexpectedEOF || len(read) != 500 {
+		t.Fatalf("unexpected truncated body: %d bytes, %v", len(read), err)
+	}
+
+	// other methods are not truncated
+	req, _ := http.NewRequest(http.MethodHead, server.URL, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status of a HEAD request: %d", resp.StatusCode)
+	}
+}
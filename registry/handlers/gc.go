@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// repositoryGCStatus describes the runs of a repository garbage collector.
+type repositoryGCStatus struct {
+	// Running is set while a collection runs, of Repository.
+	Running    bool
+	Repository string `json:",omitempty"`
+
+	// LastRepository is the repository of the last collection finished,
+	// LastRun the time it finished, zero if none has, and LastDuration the
+	// time it took. LastError is the error it failed with, if any.
+	LastRepository string `json:",omitempty"`
+	LastRun        time.Time
+	LastDuration   time.Duration
+	LastDryRun     bool
+	LastError      string `json:",omitempty"`
+}
+
+// repositoryGC runs, on demand, the garbage collection of one repository at
+// a time, as `garbage-collect --repository` does.
+type repositoryGC struct {
+	ctx      context.Context
+	driver   storagedriver.StorageDriver
+	registry distribution.Namespace
+	log      dcontext.Logger
+
+	// readOnly is set when the registry is in read-only mode, which the
+	// collection requires.
+	readOnly bool
+
+	mu     sync.Mutex
+	status repositoryGCStatus
+}
+
+func newRepositoryGC(ctx context.Context, storageDriver storagedriver.StorageDriver, registry distribution.Namespace, log dcontext.Logger, readOnly bool) *repositoryGC {
+	return &repositoryGC{
+		ctx:      ctx,
+		driver:   storageDriver,
+		registry: registry,
+		log:      log,
+		readOnly: readOnly,
+	}
+}
+
+// errGCRunning is returned when a collection is requested while one runs.
+var errGCRunning = errors.New("a garbage collection is already running")
+
+// Start starts the collection of the repository in the background, unless
+// one already runs.
+func (gc *repositoryGC) Start(opts storage.GCOpts) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.status.Running {
+		return errGCRunning
+	}
+	gc.status.Running = true
+	gc.status.Repository = opts.Repository
+
+	go gc.run(opts)
+	return nil
+}
+
+func (gc *repositoryGC) run(opts storage.GCOpts) {
+	gc.log.Infof("Starting garbage collection of %s", opts.Repository)
+	start := time.Now()
+	err := storage.MarkAndSweep(gc.ctx, gc.driver, gc.registry, opts)
+	end := time.Now()
+	if err != nil {
+		gc.log.Errorf("Garbage collection of %s failed: %v", opts.Repository, err)
+	} else {
+		gc.log.Infof("Garbage collection of %s finished in %s", opts.Repository, end.Sub(start))
+	}
+
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.status = repositoryGCStatus{
+		LastRepository: opts.Repository,
+		LastRun:        end,
+		LastDuration:   end.Sub(start),
+		LastDryRun:     opts.DryRun,
+	}
+	if err != nil {
+		gc.status.LastError = err.Error()
+	}
+}
+
+// Status returns the state of the runs of the collector.
+func (gc *repositoryGC) Status() repositoryGCStatus {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.status
+}
+
+var (
+	// activeGC is the repository garbage collector of the application last
+	// created, reported at /debug/vars and started at /debug/gc.
+	activeGCMu sync.Mutex
+	activeGC   *repositoryGC
+)
+
+func setActiveGC(gc *repositoryGC) {
+	activeGCMu.Lock()
+	defer activeGCMu.Unlock()
+	activeGC = gc
+}
+
+func getActiveGC() *repositoryGC {
+	activeGCMu.Lock()
+	defer activeGCMu.Unlock()
+	return activeGC
+}
+
+// GCHandler starts, on POST, the garbage collection of the repository named by
+// the repository query parameter, with the dryrun and deleteuntagged boolean
+// parameters matching the flags of the command, and responds with the state of
+// the collector. The registry must be in read-only mode.
+func GCHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	gc := getActiveGC()
+	if gc == nil {
+		http.Error(w, "garbage collection is not available", http.StatusNotFound)
+		return
+	}
+	if !gc.readOnly {
+		http.Error(w, "garbage collection requires the registry to be in read-only mode", http.StatusConflict)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := storage.GCOpts{Repository: query.Get("repository")}
+	if _, err := reference.WithName(opts.Repository); err != nil {
+		http.Error(w, fmt.Sprintf("invalid repository %q", opts.Repository), http.StatusBadRequest)
+		return
+	}
+	for name, value := range map[string]*bool{"dryrun": &opts.DryRun, "deleteuntagged": &opts.RemoveUntagged} {
+		if v := query.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s %q", name, v), http.StatusBadRequest)
+				return
+			}
+			*value = b
+		}
+	}
+
+	if err := gc.Start(opts); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(gc.Status())
+}
+
+func init() {
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+
+	registry.(*expvar.Map).Set("gc", expvar.Func(func() interface{} {
+		if gc := getActiveGC(); gc != nil {
+			return gc.Status()
+		}
+		return nil
+	}))
+}
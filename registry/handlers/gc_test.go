@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestGCHandler(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(gc *repositoryGC, query string) int {
+		setActiveGC(gc)
+		defer setActiveGC(nil)
+
+		recorder := httptest.NewRecorder()
+		GCHandler(recorder, httptest.NewRequest(http.MethodPost, "/debug/gc?"+query, nil))
+		return recorder.Code
+	}
+
+	writable := newRepositoryGC(ctx, driver, registry, dcontext.GetLogger(ctx), false)
+	if code := post(writable, "repository=foo"); code != http.StatusConflict {
+		t.Fatalf("unexpected status of POST in read-write mode: %d", code)
+	}
+
+	gc := newRepositoryGC(ctx, driver, registry, dcontext.GetLogger(ctx), true)
+	for _, query := range []string{"", "repository=Foo", "repository=foo&dryrun=maybe"} {
+		if code := post(gc, query); code != http.StatusBadRequest {
+			t.Fatalf("unexpected status of POST with %q: %d", query, code)
+		}
+	}
+
+	if code := post(gc, "repository=foo&dryrun=true"); code != http.StatusAccepted {
+		t.Fatalf("unexpected status of POST: %d", code)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for gc.Status().LastRun.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the collection to run once started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := gc.Status()
+	if status.Running || status.LastRepository != "foo" || !status.LastDryRun || !strings.Contains(status.LastError, "not found") {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
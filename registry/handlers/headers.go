@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+)
+
+// defaultRouteClass is the class whose headers apply to all routes.
+const defaultRouteClass = "default"
+
+// routeClasses maps the names of the routes to the classes their headers
+// are configured by. The routes of extensions are classed by prefix.
+var routeClasses = map[string]string{
+	v2.RouteNameBase:            "base",
+	v2.RouteNameManifest:        "manifests",
+	v2.RouteNameBlob:            "blobs",
+	v2.RouteNameBlobUpload:      "uploads",
+	v2.RouteNameBlobUploadChunk: "uploads",
+	v2.RouteNameTags:            "tags",
+	v2.RouteNameTagOperations:   "tags",
+	v2.RouteNameCatalog:         "catalog",
+	v2.RouteNameHolds:           "holds",
+	v2.RouteNameHold:            "holds",
+}
+
+func routeClass(routeName string) string {
+	if class, ok := routeClasses[routeName]; ok {
+		return class
+	}
+	if strings.HasPrefix(routeName, v2.RouteNameExtensionsRegistry) || strings.HasPrefix(routeName, v2.RouteNameExtensionsRepository) {
+		return "extensions"
+	}
+	return ""
+}
+
+// responseHeaders sets the configured headers on the responses of the
+// routes, once the handlers have set theirs, and answers CORS preflight
+// requests.
+type responseHeaders struct {
+	routes map[string]http.Header
+	hsts   string
+	cors   []*corsRule
+}
+
+// newResponseHeaders returns the headers of config, or nil if none is
+// configured.
+func newResponseHeaders(config configuration.ResponseHeaders) (*responseHeaders, error) {
+	if len(config.Routes) == 0 && config.HSTS.MaxAge == 0 && len(config.CORS) == 0 {
+		return nil, nil
+	}
+
+	h := &responseHeaders{routes: make(map[string]http.Header, len(config.Routes))}
+	for class, headers := range config.Routes {
+		if class != defaultRouteClass && !knownRouteClass(class) {
+			return nil, fmt.Errorf("unknown route class %q", class)
+		}
+		canonical := make(http.Header, len(headers))
+		for name, values := range headers {
+			canonical[http.CanonicalHeaderKey(name)] = values
+		}
+		h.routes[class] = canonical
+	}
+
+	if config.HSTS.MaxAge < 0 {
+		return nil, fmt.Errorf("hsts maxage must not be negative")
+	}
+	if config.HSTS.MaxAge > 0 {
+		h.hsts = "max-age=" + strconv.FormatInt(int64(config.HSTS.MaxAge.Seconds()), 10)
+		if config.HSTS.IncludeSubdomains {
+			h.hsts += "; includeSubDomains"
+		}
+		if config.HSTS.Preload {
+			h.hsts += "; preload"
+		}
+	}
+
+	for i, rule := range config.CORS {
+		r, err := newCORSRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("cors rule %d: %v", i, err)
+		}
+		h.cors = append(h.cors, r)
+	}
+
+	return h, nil
+}
+
+func knownRouteClass(class string) bool {
+	for _, c := range routeClasses {
+		if c == class {
+			return true
+		}
+	}
+	return class == "extensions"
+}
+
+// handler wraps the handler of the route named routeName.
+func (h *responseHeaders) handler(routeName string, handler http.Handler) http.Handler {
+	if h == nil {
+		return handler
+	}
+
+	// Merge the headers of the class of the route over the default ones,
+	// keeping the headers without value to remove them.
+	headers := make(http.Header)
+	for name, values := range h.routes[defaultRouteClass] {
+		headers[name] = values
+	}
+	for name, values := range h.routes[routeClass(routeName)] {
+		headers[name] = values
+	}
+	if h.hsts != "" {
+		headers.Set("Strict-Transport-Security", h.hsts)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := h.corsRule(r)
+		if rule != nil && r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			rule.preflight(w, r)
+			return
+		}
+
+		handler.ServeHTTP(&headersResponseWriter{
+			ResponseWriter: w,
+			headers:        headers,
+			rule:           rule,
+			origin:         r.Header.Get("Origin"),
+		}, r)
+	})
+}
+
+// corsRule returns the rule applying to the origin of r, if any.
+func (h *responseHeaders) corsRule(r *http.Request) *corsRule {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	for _, rule := range h.cors {
+		if rule.matches(origin) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// headersResponseWriter sets headers on the response when it starts, so that
+// they override those set by the handler.
+type headersResponseWriter struct {
+	http.ResponseWriter
+
+	headers http.Header
+	rule    *corsRule
+	origin  string
+	set     bool
+}
+
+func (w *headersResponseWriter) setHeaders() {
+	if w.set {
+		return
+	}
+	w.set = true
+
+	header := w.ResponseWriter.Header()
+	for name, values := range w.headers {
+		if len(values) == 0 {
+			header.Del(name)
+			continue
+		}
+		header[name] = append([]string(nil), values...)
+	}
+
+	if w.rule != nil {
+		w.rule.setHeaders(header, w.origin)
+	}
+}
+
+func (w *headersResponseWriter) WriteHeader(status int) {
+	// Informational responses are followed by the final one.
+	if status >= 200 {
+		w.setHeaders()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headersResponseWriter) Write(p []byte) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *headersResponseWriter) Flush() {
+	w.setHeaders()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
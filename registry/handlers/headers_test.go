@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	if h, err := newResponseHeaders(configuration.ResponseHeaders{}); err != nil || h != nil {
+		t.Fatalf("expected no headers without configuration, got %v, %v", h, err)
+	}
+	if _, err := newResponseHeaders(configuration.ResponseHeaders{Routes: map[string]http.Header{"layers": {}}}); err == nil {
+		t.Fatal("expected an error for an unknown route class")
+	}
+
+	h, err := newResponseHeaders(configuration.ResponseHeaders{
+		Routes: map[string]http.Header{
+			"default": {
+				"cache-control":          {"no-store"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+			"blobs": {
+				"Cache-Control":          {"max-age=31536000, immutable"},
+				"X-Content-Type-Options": {},
+			},
+		},
+		HSTS: configuration.HSTS{MaxAge: 365 * 24 * time.Hour, IncludeSubdomains: true},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The handler sets headers the configured ones override.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tc := range []struct {
+		route        string
+		cacheControl string
+		nosniff      bool
+	}{
+		{v2.RouteNameManifest, "no-store", true},
+		{v2.RouteNameBlob, "max-age=31536000, immutable", false},
+		{"extensions-registry-oci-ext-discover", "no-store", true},
+	} {
+		w := httptest.NewRecorder()
+		h.handler(tc.route, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/", nil))
+
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != tc.cacheControl {
+			t.Errorf("%s: unexpected Cache-Control header: %q != %q", tc.route, cacheControl, tc.cacheControl)
+		}
+		if nosniff := w.Header().Get("X-Content-Type-Options") != ""; nosniff != tc.nosniff {
+			t.Errorf("%s: unexpected X-Content-Type-Options header: %q", tc.route, w.Header().Get("X-Content-Type-Options"))
+		}
+		if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=31536000; includeSubDomains" {
+			t.Errorf("%s: unexpected Strict-Transport-Security header: %q", tc.route, hsts)
+		}
+	}
+}
+
+func TestResponseHeadersCORS(t *testing.T) {
+	h, err := newResponseHeaders(configuration.ResponseHeaders{
+		CORS: []configuration.CORSRule{{
+			Origins:        []string{"https://ui.example.com"},
+			Methods:        []string{"get", "head", "delete"},
+			Headers:        []string{"Authorization", "Accept"},
+			ExposedHeaders: []string{"Docker-Content-Digest"},
+			Credentials:    true,
+			MaxAge:         10 * time.Minute,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var served int
+	handler := h.handler(v2.RouteNameManifest, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(method, origin, requestMethod string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/v2/foo/manifests/latest", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", requestMethod)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Preflight requests are answered without reaching the handler.
+	w := request(http.MethodOptions, "https://ui.example.com", http.MethodDelete)
+	if w.Code != http.StatusNoContent || served != 0 {
+		t.Fatalf("unexpected preflight response: %d, served %d", w.Code, served)
+	}
+	for name, expected := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://ui.example.com",
+		"Access-Control-Allow-Methods":     "GET, HEAD, DELETE",
+		"Access-Control-Allow-Headers":     "Authorization, Accept",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+		"Vary":                             "Origin",
+	} {
+		if actual := w.Header().Get(name); actual != expected {
+			t.Errorf("unexpected %s header: %q != %q", name, actual, expected)
+		}
+	}
+
+	// Methods the rule does not allow are refused.
+	w = request(http.MethodOptions, "https://ui.example.com", http.MethodPut)
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("unexpected Access-Control-Allow-Origin header for a method not allowed: %q", origin)
+	}
+
+	// Preflight requests from other origins reach the handler.
+	request(http.MethodOptions, "https://evil.example.com", http.MethodGet)
+	if served != 1 {
+		t.Fatalf("expected the preflight request of another origin to be served, served %d", served)
+	}
+
+	w = request(http.MethodGet, "https://ui.example.com", "")
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://ui.example.com" {
+		t.Errorf("unexpected Access-Control-Allow-Origin header: %q", origin)
+	}
+	if exposed := w.Header().Get("Access-Control-Expose-Headers"); exposed != "Docker-Content-Digest" {
+		t.Errorf("unexpected Access-Control-Expose-Headers header: %q", exposed)
+	}
+
+	w = request(http.MethodGet, "", "")
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("unexpected Access-Control-Allow-Origin header without origin: %q", origin)
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"strings"
 
 	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
 // closeResources closes all the provided resources after running the target
@@ -84,3 +87,28 @@ func parseContentRange(cr string) (int64, int64, error) {
 
 	return start, end, nil
 }
+
+// storageTimeoutErrors replaces the unknown errors caused by storage
+// operations timing out with ErrorCodeStorageTimeout errors, so that clients
+// can tell them apart from other failures.
+func storageTimeoutErrors(errs errcode.Errors) errcode.Errors {
+	for i, err := range errs {
+		cause := err
+		if e, ok := err.(errcode.Error); ok {
+			if e.Code != errcode.ErrorCodeUnknown {
+				continue
+			}
+			detail, ok := e.Detail.(error)
+			if !ok {
+				continue
+			}
+			cause = detail
+		}
+
+		var timeout storagedriver.TimeoutError
+		if errors.As(cause, &timeout) {
+			errs[i] = v2.ErrorCodeStorageTimeout.WithDetail(timeout.Error())
+		}
+	}
+	return errs
+}
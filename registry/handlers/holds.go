@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+// holdsDispatcher lists and places the legal holds of the registry.
+func holdsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	holdsHandler := &holdsHandler{
+		Context: ctx,
+	}
+
+	if ctx.App.holds == nil {
+		return http.HandlerFunc(holdsHandler.Unsupported)
+	}
+
+	mhandler := handlers.MethodHandler{
+		"GET": http.HandlerFunc(holdsHandler.GetHolds),
+	}
+	if !ctx.readOnly {
+		mhandler["POST"] = http.HandlerFunc(holdsHandler.PlaceHold)
+	}
+	return mhandler
+}
+
+// holdDispatcher gets and releases a legal hold.
+func holdDispatcher(ctx *Context, r *http.Request) http.Handler {
+	holdsHandler := &holdsHandler{
+		Context: ctx,
+		ID:      mux.Vars(r)["id"],
+	}
+
+	if ctx.App.holds == nil {
+		return http.HandlerFunc(holdsHandler.Unsupported)
+	}
+
+	mhandler := handlers.MethodHandler{
+		"GET": http.HandlerFunc(holdsHandler.GetHold),
+	}
+	if !ctx.readOnly {
+		mhandler["DELETE"] = http.HandlerFunc(holdsHandler.ReleaseHold)
+	}
+	return mhandler
+}
+
+type holdsHandler struct {
+	*Context
+
+	// ID is the identifier of the hold of the request, if any.
+	ID string
+}
+
+type holdsAPIResponse struct {
+	Holds []storage.Hold `json:"holds"`
+}
+
+// Unsupported responds to requests when legal holds are disabled.
+func (hh *holdsHandler) Unsupported(w http.ResponseWriter, r *http.Request) {
+	hh.Errors = append(hh.Errors, errcode.ErrorCodeUnsupported)
+}
+
+// GetHolds lists the legal holds, in the order they were placed.
+func (hh *holdsHandler) GetHolds(w http.ResponseWriter, r *http.Request) {
+	holds, err := hh.App.holds.List(hh)
+	if err != nil {
+		hh.Errors = append(hh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if holds == nil {
+		holds = []storage.Hold{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(holdsAPIResponse{Holds: holds}); err != nil {
+		hh.Errors = append(hh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// PlaceHold places the legal hold described by the request body.
+func (hh *holdsHandler) PlaceHold(w http.ResponseWriter, r *http.Request) {
+	var hold storage.Hold
+	if err := json.NewDecoder(r.Body).Decode(&hold); err != nil {
+		hh.Errors = append(hh.Errors, v2.ErrorCodeHoldInvalid.WithDetail(err))
+		return
+	}
+	if err := hold.Validate(); err != nil {
+		hh.Errors = append(hh.Errors, v2.ErrorCodeHoldInvalid.WithDetail(err.Error()))
+		return
+	}
+	hold.CreatedBy = getUserName(hh, r)
+
+	hold, err := hh.App.holds.Place(hh, hold)
+	if err != nil {
+		hh.Errors = append(hh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	holdURL, err := hh.urlBuilder.BuildHoldURL(hold.ID)
+	if err != nil {
+		hh.Errors = append(hh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	if err := hh.holdListener(r).HoldPlaced(holdURL, hold.Repository, hold.Digest, hold.Tags); err != nil {
+		dcontext.GetLogger(hh).Errorf("error dispatching hold to listener: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", holdURL)
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(hold); err != nil {
+		hh.Errors = append(hh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// GetHold returns the legal hold of the request.
+func (hh *holdsHandler) GetHold(w http.ResponseWriter, r *http.Request) {
+	hold, err := hh.App.holds.Get(hh, hh.ID)
+	if err != nil {
+		hh.appendHoldError(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hold); err != nil {
+		hh.Errors = append(hh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// ReleaseHold releases the legal hold of the request.
+func (hh *holdsHandler) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	hold, err := hh.App.holds.Release(hh, hh.ID)
+	if err != nil {
+		hh.appendHoldError(err)
+		return
+	}
+
+	holdURL, err := hh.urlBuilder.BuildHoldURL(hold.ID)
+	if err != nil {
+		hh.Errors = append(hh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	if err := hh.holdListener(r).HoldReleased(holdURL, hold.Repository, hold.Digest, hold.Tags); err != nil {
+		dcontext.GetLogger(hh).Errorf("error dispatching hold release to listener: %v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (hh *holdsHandler) appendHoldError(err error) {
+	if err == storage.ErrHoldUnknown {
+		hh.Errors = append(hh.Errors, v2.ErrorCodeHoldUnknown.WithDetail(map[string]string{"id": hh.ID}))
+		return
+	}
+	hh.Errors = append(hh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+}
+
+// holdListener returns the listener notified of the holds placed and
+// released by the request.
+func (hh *holdsHandler) holdListener(r *http.Request) notifications.HoldListener {
+	return hh.App.eventBridge(hh.Context, r).(notifications.HoldListener)
+}
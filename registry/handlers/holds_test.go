@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+)
+
+func TestHoldsAPIDisabled(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	holdsURL, err := env.builder.BuildHoldsURL()
+	checkErr(t, err, "building holds url")
+
+	resp, err := http.Get(holdsURL)
+	checkErr(t, err, "listing holds")
+	defer resp.Body.Close()
+	checkResponse(t, "listing holds", resp, errcode.ErrorCodeUnsupported.Descriptor().HTTPStatusCode)
+	checkBodyHasErrorCodes(t, "listing holds", resp, errcode.ErrorCodeUnsupported)
+}
+
+func TestHoldsAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"delete":     configuration.Parameters{"enabled": true},
+			"holds":      configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	createRepository(env, t, imageName.Name(), "release-1")
+
+	holdsURL, err := env.builder.BuildHoldsURL()
+	checkErr(t, err, "building holds url")
+
+	placeHold := func(msg string, hold storage.Hold) *http.Response {
+		body, err := json.Marshal(hold)
+		checkErr(t, err, msg)
+		resp, err := http.Post(holdsURL, "application/json", bytes.NewReader(body))
+		checkErr(t, err, msg)
+		return resp
+	}
+
+	resp := placeHold("placing invalid hold", storage.Hold{Tags: "release-*"})
+	defer resp.Body.Close()
+	checkResponse(t, "placing invalid hold", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "placing invalid hold", resp, v2.ErrorCodeHoldInvalid)
+
+	resp = placeHold("placing hold", storage.Hold{Repository: "foo/bar", Tags: "release-*", Reason: "case 1"})
+	defer resp.Body.Close()
+	checkResponse(t, "placing hold", resp, http.StatusCreated)
+
+	var hold storage.Hold
+	if err := json.NewDecoder(resp.Body).Decode(&hold); err != nil {
+		t.Fatalf("error decoding hold: %v", err)
+	}
+	holdURL, err := env.builder.BuildHoldURL(hold.ID)
+	checkErr(t, err, "building hold url")
+	checkHeaders(t, resp, http.Header{
+		"Location": []string{holdURL},
+	})
+
+	resp, err = http.Get(holdsURL)
+	checkErr(t, err, "listing holds")
+	defer resp.Body.Close()
+	checkResponse(t, "listing holds", resp, http.StatusOK)
+
+	var list holdsAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("error decoding holds: %v", err)
+	}
+	if len(list.Holds) != 1 || list.Holds[0].ID != hold.ID || list.Holds[0].Reason != "case 1" {
+		t.Fatalf("unexpected holds: %v", list.Holds)
+	}
+
+	tagRef, err := reference.WithTag(imageName, "release-1")
+	checkErr(t, err, "building tag reference")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building tag url")
+
+	resp, err = httpDelete(tagURL)
+	checkErr(t, err, "deleting held tag")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting held tag", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "deleting held tag", resp, errcode.ErrorCodeDenied)
+
+	resp, err = httpDelete(holdURL)
+	checkErr(t, err, "releasing hold")
+	defer resp.Body.Close()
+	checkResponse(t, "releasing hold", resp, http.StatusAccepted)
+
+	resp, err = http.Get(holdURL)
+	checkErr(t, err, "getting released hold")
+	defer resp.Body.Close()
+	checkResponse(t, "getting released hold", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "getting released hold", resp, v2.ErrorCodeHoldUnknown)
+
+	resp, err = httpDelete(tagURL)
+	checkErr(t, err, "deleting released tag")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting released tag", resp, http.StatusAccepted)
+}
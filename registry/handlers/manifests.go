@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"mime"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
@@ -16,8 +18,10 @@ import (
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
-	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/storageclass"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -29,7 +33,6 @@ const (
 	defaultArch         = "amd64"
 	defaultOS           = "linux"
 	maxManifestBodySize = 4 << 20
-	imageClass          = "image"
 )
 
 type storageType int
@@ -119,11 +122,18 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 	}
 
 	if imh.Tag != "" {
-		tags := imh.Repository.Tags(imh)
-		desc, err := tags.Get(imh, imh.Tag)
+		var desc distribution.Descriptor
+		if at := r.URL.Query().Get("at"); at != "" {
+			desc, err = imh.tagAt(at)
+		} else {
+			desc, err = imh.Repository.Tags(imh).Get(imh, imh.Tag)
+		}
 		if err != nil {
-			if _, ok := err.(distribution.ErrTagUnknown); ok {
-				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+			var unknown distribution.ErrTagUnknown
+			if errors.As(err, &unknown) {
+				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(unknown))
+			} else if err, ok := err.(errcode.Error); ok {
+				imh.Errors = append(imh.Errors, err)
 			} else {
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
@@ -137,14 +147,26 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Repository middlewares may replace manifests pulled by tag.
 	var options []distribution.ManifestServiceOption
+	var replacement digest.Digest
 	if imh.Tag != "" {
-		options = append(options, distribution.WithTag(imh.Tag))
+		options = append(options, distribution.WithTag(imh.Tag), distribution.WithReplacementDigest(&replacement))
 	}
 	manifest, err := manifests.Get(imh, imh.Digest, options...)
 	if err != nil {
-		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
-			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		var unknown distribution.ErrManifestUnknownRevision
+		if errors.As(err, &unknown) {
+			if imh.Tag == "" && redirectDigestAlias(imh.Context, w, r, imh.Digest, func(ref reference.Canonical) (string, error) {
+				return imh.urlBuilder.BuildManifestURL(ref)
+			}) {
+				return
+			}
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(unknown))
+		} else if errors.As(err, &storageclass.ArchivedError{}) && serveArchived(imh.Context, w, imh.Digest) {
+			return
+		} else if err, ok := err.(errcode.Error); ok {
+			imh.Errors = append(imh.Errors, err)
 		} else {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
@@ -166,6 +188,10 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	if replacement != "" {
+		imh.Digest = replacement
+	}
+
 	if manifestType == ociSchema && !supports[ociSchema] {
 		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithMessage("OCI manifest found, but accept header does not support OCI manifests"))
 		return
@@ -206,8 +232,11 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 
 		manifest, err = manifests.Get(imh, manifestDigest)
 		if err != nil {
-			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
-				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+			var unknown distribution.ErrManifestUnknownRevision
+			if errors.As(err, &unknown) {
+				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(unknown))
+			} else if err, ok := err.(errcode.Error); ok {
+				imh.Errors = append(imh.Errors, err)
 			} else {
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
@@ -237,12 +266,36 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 	w.Write(p)
 }
 
+// tagAt resolves the tag of the request at the time at, in RFC 3339 format.
+// The history of the tag is read from the storage of the registry, as the tag
+// service of the request repository is wrapped by notifications and
+// middleware.
+func (imh *manifestHandler) tagAt(at string) (distribution.Descriptor, error) {
+	if !imh.App.tagHistory && !imh.App.features.Enabled(storage.FeatureTagHistory, imh.Repository.Named().Name()) {
+		return distribution.Descriptor{}, errcode.ErrorCodeUnsupported.WithDetail("tag history is not recorded")
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return distribution.Descriptor{}, v2.ErrorCodeTimestampInvalid.WithDetail(err.Error())
+	}
+
+	repository, err := imh.App.registry.Repository(imh, imh.Repository.Named())
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	history, ok := repository.Tags(imh).(storage.TagHistory)
+	if !ok {
+		return distribution.Descriptor{}, errcode.ErrorCodeUnsupported.WithDetail("tag history is not recorded")
+	}
+	return history.At(imh, imh.Tag, t)
+}
+
 func (imh *manifestHandler) convertSchema2Manifest(schema2Manifest *schema2.DeserializedManifest) (distribution.Manifest, error) {
 	targetDescriptor := schema2Manifest.Target()
 	blobs := imh.Repository.Blobs(imh)
 	configJSON, err := blobs.Get(imh, targetDescriptor.Digest)
 	if err != nil {
-		if err == distribution.ErrBlobUnknown {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
 			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(err))
 		} else {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -335,12 +388,12 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		options = append(options, distribution.WithTag(imh.Tag))
 	}
 
-	if err := imh.applyResourcePolicy(manifest); err != nil {
-		imh.Errors = append(imh.Errors, err)
-		return
-	}
+	// Keep the client informed while the descriptors are verified, which
+	// may take long for large indexes.
+	stopProcessing := keepProcessing(w, r, imh.App.processingInterval)
+	defer stopProcessing()
 
-	_, err = manifests.Put(imh, manifest, options...)
+	stored, err := manifests.Put(imh, manifest, options...)
 	if err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
 		// handled by an app global mapper.
@@ -378,16 +431,37 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Tag this manifest
+	// Repository middlewares may store another manifest in place of the one
+	// pushed by tag, but those pushed by digest must be stored as pushed.
+	if stored != desc.Digest {
+		if imh.Tag == "" {
+			dcontext.GetLogger(imh).Errorf("stored digest does not match: %q != %q", stored, desc.Digest)
+			imh.Errors = append(imh.Errors, v2.ErrorCodeDigestInvalid)
+			return
+		}
+		desc = distribution.Descriptor{Digest: stored}
+		imh.Digest = stored
+	}
+
+	// Tag this manifest, unless the tag already points to it
 	if imh.Tag != "" {
 		tags := imh.Repository.Tags(imh)
-		err = tags.Tag(imh, imh.Tag, desc)
+		current, err := tags.Get(storage.WithoutReadRetries(imh), imh.Tag)
+		if err != nil || current.Digest != desc.Digest {
+			err = tags.Tag(imh, imh.Tag, desc)
+		}
 		if err != nil {
-			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			switch err := err.(type) {
+			case errcode.Error:
+				imh.Errors = append(imh.Errors, err)
+			default:
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			}
 			return
 		}
 
 	}
+	stopProcessing()
 
 	// Construct a canonical url for the uploaded manifest.
 	ref, err := reference.WithDigest(imh.Repository.Named(), imh.Digest)
@@ -411,82 +485,17 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 	dcontext.GetLogger(imh).Debug("Succeeded in putting manifest!")
 }
 
-// applyResourcePolicy checks whether the resource class matches what has
-// been authorized and allowed by the policy configuration.
-func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest) error {
-	allowedClasses := imh.App.Config.Policy.Repository.Classes
-	if len(allowedClasses) == 0 {
-		return nil
-	}
-
-	var class string
-	switch m := manifest.(type) {
-	case *schema1.SignedManifest:
-		class = imageClass
-	case *schema2.DeserializedManifest:
-		switch m.Config.MediaType {
-		case schema2.MediaTypeImageConfig:
-			class = imageClass
-		case schema2.MediaTypePluginConfig:
-			class = "plugin"
-		default:
-			return errcode.ErrorCodeDenied.WithMessage("unknown manifest class for " + m.Config.MediaType)
-		}
-	case *ocischema.DeserializedManifest:
-		switch m.Config.MediaType {
-		case v1.MediaTypeImageConfig:
-			class = imageClass
-		default:
-			return errcode.ErrorCodeDenied.WithMessage("unknown manifest class for " + m.Config.MediaType)
-		}
-	}
-
-	if class == "" {
-		return nil
-	}
-
-	// Check to see if class is allowed in registry
-	var allowedClass bool
-	for _, c := range allowedClasses {
-		if class == c {
-			allowedClass = true
-			break
-		}
-	}
-	if !allowedClass {
-		return errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf("registry does not allow %s manifest", class))
-	}
-
-	resources := auth.AuthorizedResources(imh)
-	n := imh.Repository.Named().Name()
-
-	var foundResource bool
-	for _, r := range resources {
-		if r.Name == n {
-			if r.Class == "" {
-				r.Class = imageClass
-			}
-			if r.Class == class {
-				return nil
-			}
-			foundResource = true
-		}
-	}
-
-	// resource was found but no matching class was found
-	if foundResource {
-		return errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf("repository not authorized for %s manifest", class))
-	}
-
-	return nil
-
+// forceDelete reports whether a manifest delete request overrides the
+// protection of tagged manifests.
+func forceDelete(r *http.Request) bool {
+	return r.URL.Query().Get("force") == "true"
 }
 
 // DeleteManifest removes the manifest with the given digest or the tag with the given name from the registry.
 func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("DeleteImageManifest")
 
-	if imh.App.isCache {
+	if imh.App.isCache && !proxy.LocalOnly(imh.App.Config.Proxy, imh.Repository.Named().Name()) {
 		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported)
 		return
 	}
@@ -495,18 +504,47 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 		dcontext.GetLogger(imh).Debug("DeleteImageTag")
 		tagService := imh.Repository.Tags(imh.Context)
 		if err := tagService.Untag(imh.Context, imh.Tag); err != nil {
-			switch err.(type) {
-			case distribution.ErrTagUnknown, driver.PathNotFoundError:
-				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+			var (
+				unknown  distribution.ErrTagUnknown
+				notFound driver.PathNotFoundError
+				held     distribution.ErrContentHeld
+			)
+			switch {
+			case errors.As(err, &unknown):
+				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(unknown))
+			case errors.As(err, &notFound):
+				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(notFound))
+			case errors.As(err, &held):
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeDenied.WithMessage(held.Error()))
 			default:
-				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				if err, ok := err.(errcode.Error); ok {
+					imh.Errors = append(imh.Errors, err)
+				} else {
+					imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				}
 			}
 			return
 		}
+		if distribution.IsDryRun(imh) {
+			serveDryRun(imh.Context, w, dryRunAPIResponse{Tags: []string{imh.Tag}})
+			return
+		}
 		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 
+	if imh.App.protectTagged && !forceDelete(r) {
+		tags, err := imh.Repository.Tags(imh).Lookup(imh, distribution.Descriptor{Digest: imh.Digest})
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if len(tags) > 0 {
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestTagged.WithDetail(map[string][]string{"tags": tags}))
+			return
+		}
+	}
+
 	manifests, err := imh.Repository.Manifests(imh)
 	if err != nil {
 		imh.Errors = append(imh.Errors, err)
@@ -515,14 +553,25 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 
 	err = manifests.Delete(imh, imh.Digest)
 	if err != nil {
+		if err, ok := err.(errcode.Error); ok {
+			imh.Errors = append(imh.Errors, err)
+			return
+		}
+		var held distribution.ErrContentHeld
+		if errors.As(err, &held) {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeDenied.WithMessage(held.Error()))
+			return
+		}
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown)
+			return
+		}
+
 		switch err {
 		case digest.ErrDigestUnsupported:
 		case digest.ErrDigestInvalidFormat:
 			imh.Errors = append(imh.Errors, v2.ErrorCodeDigestInvalid)
 			return
-		case distribution.ErrBlobUnknown:
-			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown)
-			return
 		case distribution.ErrUnsupported:
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported)
 			return
@@ -546,5 +595,9 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	if distribution.IsDryRun(imh) {
+		serveDryRun(imh.Context, w, dryRunAPIResponse{Manifests: []digest.Digest{imh.Digest}, Tags: referencedTags})
+		return
+	}
 	w.WriteHeader(http.StatusAccepted)
 }
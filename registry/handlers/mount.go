@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/mux"
+)
+
+// Route is a route of the registry API, with the handler of an app serving
+// it.
+type Route struct {
+	// Name is the name of the route, one of the v2.RouteName constants or
+	// the name of an extension route.
+	Name string
+
+	// Path is the template of the path of the route, in the syntax of
+	// gorilla/mux, such as /v2/{name:...}/tags/list, below the prefix the
+	// route is mounted at.
+	Path string
+
+	// Handler serves the requests of the route. It matches the paths of
+	// requests against the route itself, to extract its variables, so that
+	// routers only have to dispatch requests to it, for instance by path
+	// prefix, without removing the prefix from their path.
+	Handler http.Handler
+}
+
+// Routes returns the routes of the API served by the app, including the
+// routes of extensions, for mounting them below prefix on other routers.
+// Unlike ServeHTTP, which serves all the routes below the prefix of the http
+// section of the configuration, the routes may be mounted at any prefix, and
+// individually, so that servers embed only the routes they need. The handlers
+// apply the authentication, headers, faults and metrics of the app, but not
+// the health checks, access logs and panic recovery of the registry server,
+// which the servers embedding them provide.
+//
+// The URLs responses hold, such as the locations of uploads, are built from
+// the requests, so that they keep the prefix, unless http.host is set.
+func (app *App) Routes(prefix string) []Route {
+	var routes []Route
+	app.walkRoutes(func(name, path string, handler http.Handler) {
+		root, router := mountRouter(prefix)
+		router.Path(path).Name(name).Handler(handler)
+		routes = append(routes, Route{
+			Name: name,
+			Path: path,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				app.serve(w, r, root)
+			}),
+		})
+	})
+	return routes
+}
+
+// Handler returns a handler serving all the routes of the API below prefix,
+// as mounted by Routes, for embedding the registry into existing servers and
+// their middleware stacks:
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/registry/", app.Handler("/registry"))
+//
+// The same app may be mounted at several prefixes.
+func (app *App) Handler(prefix string) http.Handler {
+	root, router := mountRouter(prefix)
+	app.walkRoutes(func(name, path string, handler http.Handler) {
+		router.Path(path).Name(name).Handler(handler)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.serve(w, r, root)
+	})
+}
+
+// walkRoutes calls fn with the routes the app serves, in the order of their
+// descriptors, which is the order the main router of the app matches them
+// in.
+func (app *App) walkRoutes(fn func(name, path string, handler http.Handler)) {
+	for _, desc := range v2.APIDescriptor.RouteDescriptors {
+		if route := app.router.GetRoute(desc.Name); route != nil && route.GetHandler() != nil {
+			fn(desc.Name, desc.Path, route.GetHandler())
+		}
+	}
+}
+
+// mountRouter returns a router, and the router of the routes below prefix
+// within it, matching paths as the main router of the app does.
+func mountRouter(prefix string) (root, router *mux.Router) {
+	root = mux.NewRouter()
+	router = root
+	if prefix = strings.TrimSuffix(prefix, "/"); prefix != "" {
+		router = root.PathPrefix(prefix).Subrouter()
+	}
+	router.StrictSlash(true)
+	return root, router
+}
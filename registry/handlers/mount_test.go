@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/reference"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+)
+
+func TestMountedRoutes(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	mux := http.NewServeMux()
+	mux.Handle("/registry/", env.app.Handler("/registry"))
+	for _, route := range env.app.Routes("/tags") {
+		if route.Name == v2.RouteNameTags {
+			mux.Handle("/tags/", route.Handler)
+		}
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	builder, err := v2.NewURLBuilderFromString(server.URL+"/registry/", false)
+	checkErr(t, err, "building url builder")
+	mounted := *env
+	mounted.builder = builder
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	location, _ := startPushLayer(t, &mounted, imageName)
+	if !strings.HasPrefix(location, server.URL+"/registry/v2/") {
+		t.Errorf("expected the location of the upload below the prefix: %s", location)
+	}
+	createRepository(&mounted, t, imageName.Name(), "latest")
+
+	for _, c := range []struct {
+		path   string
+		status int
+	}{
+		{"/registry/v2/", http.StatusOK},
+		{"/registry/v2/foo/bar/tags/list", http.StatusOK},
+		{"/tags/v2/foo/bar/tags/list", http.StatusOK},
+		{"/tags/v2/", http.StatusNotFound},
+		{"/v2/", http.StatusNotFound},
+	} {
+		resp, err := http.Get(server.URL + c.path)
+		checkErr(t, err, "fetching "+c.path)
+		defer resp.Body.Close()
+		checkResponse(t, "fetching "+c.path, resp, c.status)
+	}
+}
@@ -0,0 +1,60 @@
+//go:build go1.19
+// +build go1.19
+
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// processingSupported is true, as informational responses can be sent.
+const processingSupported = true
+
+// keepProcessing sends a "102 Processing" informational response on w every
+// interval, until the returned function is called. Verifying the descriptors
+// of large indexes can take long enough for clients, or proxies in front of
+// the registry, to drop a connection on which nothing was received; the
+// informational responses keep it active without committing to a status.
+//
+// The returned function waits for the last informational response to be
+// written, so that w can be used again once it returns. Nothing is sent when
+// interval is zero or the client does not speak HTTP/1.1.
+//
+// Only Go 1.19 and later send informational responses written with
+// WriteHeader, rather than taking them as the final status: registries built
+// with earlier versions send nothing, see processing_prego119.go.
+func keepProcessing(w http.ResponseWriter, r *http.Request, interval time.Duration) func() {
+	if interval <= 0 || !r.ProtoAtLeast(1, 1) {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.WriteHeader(http.StatusProcessing)
+			case <-done:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !go1.19
+// +build !go1.19
+
+package handlers
+
+import (
+	"net/http"
+	"time"
+)
+
+// NOTE: before Go 1.19, WriteHeader takes "102 Processing" as the final status
+// of the response, dropping the one written once the manifest is stored, so
+// no informational responses are sent when built with earlier versions.
+
+// processingSupported is false, as no informational responses can be sent.
+const processingSupported = false
+
+// keepProcessing does nothing and returns a function doing nothing.
+func keepProcessing(w http.ResponseWriter, r *http.Request, interval time.Duration) func() {
+	return func() {}
+}
@@ -0,0 +1,132 @@
+//go:build go1.19
+// +build go1.19
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func init() {
+	storagemiddleware.Register("slowstat", func(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+		return slowStatDriver{sd}, nil
+	})
+}
+
+// slowStatDriver delays stats, so that verifying the descriptors of manifests
+// outlasts the processing interval.
+type slowStatDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d slowStatDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	time.Sleep(5 * time.Millisecond)
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func TestKeepProcessing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stop := keepProcessing(w, r, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		stop()
+		stop()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	var informational int32
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusProcessing {
+				atomic.AddInt32(&informational, 1)
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequest(http.MethodPut, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error doing request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status: %d != %d", resp.StatusCode, http.StatusCreated)
+	}
+	if n := atomic.LoadInt32(&informational); n == 0 {
+		t.Fatal("expected 102 Processing responses before the final response")
+	}
+}
+
+func TestPutManifestProcessing(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Middleware = map[string][]configuration.Middleware{
+		"storage": {{Name: "slowstat"}},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.ProcessingInterval = time.Millisecond
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	repo, err := env.app.registry.Repository(env.ctx, imageName)
+	checkErr(t, err, "getting repository")
+	m, err := ocischema.NewManifestBuilder(repo.Blobs(env.ctx), []byte(`{}`), nil).Build(env.ctx)
+	checkErr(t, err, "building manifest")
+	_, payload, err := m.Payload()
+	checkErr(t, err, "getting manifest payload")
+
+	tagged, err := reference.WithTag(imageName, "latest")
+	checkErr(t, err, "building tagged reference")
+	manifestURL, err := env.builder.BuildManifestURL(tagged)
+	checkErr(t, err, "building manifest url")
+
+	var informational int32
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusProcessing {
+				atomic.AddInt32(&informational, 1)
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequest(http.MethodPut, manifestURL, bytes.NewReader(payload))
+	checkErr(t, err, "building manifest request")
+	req.Header.Set("Content-Type", v1.MediaTypeImageManifest)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "putting manifest")
+	defer resp.Body.Close()
+
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	if n := atomic.LoadInt32(&informational); n == 0 {
+		t.Fatal("expected 102 Processing responses before the final response")
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+)
+
+// profileModels tunes the registry for AI models, whose weights are stored
+// in blobs of several gigabytes.
+const profileModels = "models"
+
+// modelsStorageDefaults are the storage driver parameters set by the models
+// profile unless configured, by driver name. Larger chunks keep uploads of
+// hundreds of gigabytes within the part limits of the backends, and the
+// multipart copy parallelizes the move of uploads to their blob path on
+// commit.
+var modelsStorageDefaults = map[string]configuration.Parameters{
+	"s3":    modelsS3Defaults,
+	"s3aws": modelsS3Defaults,
+	"gcs": {
+		"chunksize": 64 << 20,
+	},
+}
+
+var modelsS3Defaults = configuration.Parameters{
+	"chunksize":                   128 << 20,
+	"multipartcopychunksize":      128 << 20,
+	"multipartcopymaxconcurrency": 100,
+	"multipartcopythresholdsize":  32 << 20,
+}
+
+// blobFirstByte tracks the time blob downloads take to send the first byte
+// of their response, labeled by the size of the blob and whether it was
+// redirected to the storage backend.
+var blobFirstByte = prometheus.StorageNamespace.NewLabeledTimer("blob_time_to_first_byte", "The number of seconds blob downloads take to send the first byte of their response", "size", "served")
+
+// applyProfile applies the profile of the configuration, setting the
+// defaults of the storage driver and checking that the options it relies on
+// are not disabled.
+func applyProfile(config *configuration.Configuration) error {
+	switch config.Profile {
+	case "":
+		return nil
+	case profileModels:
+	default:
+		return fmt.Errorf("unknown profile %q", config.Profile)
+	}
+
+	if defaults, ok := modelsStorageDefaults[config.Storage.Type()]; ok {
+		params := config.Storage.Parameters()
+		if params == nil {
+			params = make(configuration.Parameters)
+			config.Storage[config.Storage.Type()] = params
+		}
+		for k, v := range defaults {
+			if _, ok := params[k]; !ok {
+				params[k] = v
+			}
+		}
+	}
+
+	// Blobs of several gigabytes must be served by the storage backend, and
+	// are not kept in memory while being read.
+	if redirect, ok := config.Storage["redirect"]; ok {
+		if disabled, ok := redirect["disable"].(bool); ok && disabled {
+			return fmt.Errorf("the %s profile requires redirects to the storage backend", profileModels)
+		}
+	}
+	if coalesce, ok := config.Storage["coalesce"]; ok {
+		if enabled, ok := coalesce["enabled"].(bool); ok && enabled {
+			return fmt.Errorf("the %s profile does not allow coalescing reads in memory", profileModels)
+		}
+	}
+
+	return nil
+}
+
+// blobSizeClass returns the label of the size of a blob in the first byte
+// metrics.
+func blobSizeClass(size int64) string {
+	switch {
+	case size < 1<<30:
+		return "under_1gb"
+	case size < 10<<30:
+		return "1gb_to_10gb"
+	default:
+		return "over_10gb"
+	}
+}
+
+// firstByteWriter observes the time the response of a blob download takes
+// to start, measured from the start of the request.
+type firstByteWriter struct {
+	http.ResponseWriter
+	startedAt time.Time
+	size      string
+	observed  bool
+}
+
+func (w *firstByteWriter) observe(status int) {
+	if w.observed {
+		return
+	}
+	w.observed = true
+
+	served := "direct"
+	if status >= 300 && status < 400 {
+		served = "redirect"
+	}
+	blobFirstByte.WithValues(w.size, served).UpdateSince(w.startedAt)
+}
+
+func (w *firstByteWriter) WriteHeader(status int) {
+	w.observe(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *firstByteWriter) Write(p []byte) (int, error) {
+	w.observe(http.StatusOK)
+	return w.ResponseWriter.Write(p)
+}
+
+// timeFirstByte wraps the response writer of a blob download to observe its
+// time to first byte.
+func timeFirstByte(ctx *Context, w http.ResponseWriter, size int64) http.ResponseWriter {
+	startedAt, ok := ctx.Value("http.request.startedat").(time.Time)
+	if !ok {
+		startedAt = time.Now()
+	}
+	return &firstByteWriter{
+		ResponseWriter: w,
+		startedAt:      startedAt,
+		size:           blobSizeClass(size),
+	}
+}
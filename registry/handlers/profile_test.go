@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestApplyProfile(t *testing.T) {
+	config := &configuration.Configuration{
+		Profile: profileModels,
+		Storage: configuration.Storage{
+			"s3": configuration.Parameters{"bucket": "models", "chunksize": 64 << 20},
+		},
+	}
+	if err := applyProfile(config); err != nil {
+		t.Fatalf("unexpected error applying the models profile: %v", err)
+	}
+	params := config.Storage.Parameters()
+	if params["chunksize"] != 64<<20 {
+		t.Errorf("expected the configured chunk size to be kept, got %v", params["chunksize"])
+	}
+	if params["multipartcopychunksize"] != 128<<20 {
+		t.Errorf("expected the multipart copy chunk size to default to 128MiB, got %v", params["multipartcopychunksize"])
+	}
+
+	// Drivers without defaults are left alone.
+	config.Storage = configuration.Storage{"inmemory": nil}
+	if err := applyProfile(config); err != nil {
+		t.Fatalf("unexpected error applying the models profile: %v", err)
+	}
+	if config.Storage.Parameters() != nil {
+		t.Errorf("unexpected parameters of the inmemory driver: %v", config.Storage.Parameters())
+	}
+
+	for name, config := range map[string]*configuration.Configuration{
+		"unknown": {
+			Profile: "unknown",
+			Storage: configuration.Storage{"inmemory": nil},
+		},
+		"redirect": {
+			Profile: profileModels,
+			Storage: configuration.Storage{
+				"inmemory": nil,
+				"redirect": configuration.Parameters{"disable": true},
+			},
+		},
+		"coalesce": {
+			Profile: profileModels,
+			Storage: configuration.Storage{
+				"inmemory": nil,
+				"coalesce": configuration.Parameters{"enabled": true},
+			},
+		},
+	} {
+		if err := applyProfile(config); err == nil {
+			t.Errorf("expected an error applying %s", name)
+		}
+	}
+}
+
+func TestBlobSizeClass(t *testing.T) {
+	for size, class := range map[int64]string{
+		512 << 20: "under_1gb",
+		1 << 30:   "1gb_to_10gb",
+		80 << 30:  "over_10gb",
+	} {
+		if c := blobSizeClass(size); c != class {
+			t.Errorf("expected size class %s for %d bytes, got %s", class, size, c)
+		}
+	}
+}
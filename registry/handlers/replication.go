@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+)
+
+// replicationSources tells the sources of replication trusted with the
+// verification of the blobs they upload.
+type replicationSources struct {
+	sources []replicationSource
+}
+
+type replicationSource struct {
+	name     string
+	users    map[string]bool
+	networks []*net.IPNet
+}
+
+// newReplicationSources returns the sources configured, or nil if none are.
+func newReplicationSources(config configuration.Replication) (*replicationSources, error) {
+	if len(config.Trusted) == 0 {
+		return nil, nil
+	}
+
+	rs := &replicationSources{}
+	names := make(map[string]bool, len(config.Trusted))
+	for _, s := range config.Trusted {
+		if s.Name == "" {
+			return nil, fmt.Errorf("trusted replication sources must be named")
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("trusted replication source %s is configured twice", s.Name)
+		}
+		names[s.Name] = true
+		if len(s.Users) == 0 {
+			return nil, fmt.Errorf("trusted replication source %s: no users", s.Name)
+		}
+
+		source := replicationSource{name: s.Name, users: make(map[string]bool, len(s.Users))}
+		for _, user := range s.Users {
+			source.users[user] = true
+		}
+		for _, network := range s.Networks {
+			_, ipNet, err := net.ParseCIDR(network)
+			if err != nil {
+				return nil, fmt.Errorf("trusted replication source %s: invalid network %q", s.Name, network)
+			}
+			source.networks = append(source.networks, ipNet)
+		}
+		rs.sources = append(rs.sources, source)
+	}
+	return rs, nil
+}
+
+// trusted returns the name of the source the request r comes from, or an
+// empty string if it comes from no trusted source. Requests must be
+// authenticated as one of the users of the source, and come from one of its
+// networks, if any, as told by the address of the connection rather than by
+// the proxy headers clients can set.
+func (rs *replicationSources) trusted(ctx context.Context, r *http.Request) string {
+	if rs == nil {
+		return ""
+	}
+	user := dcontext.GetUserName(ctx)
+	if user == "" {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+
+	for _, s := range rs.sources {
+		if !s.users[user] {
+			continue
+		}
+		if len(s.networks) == 0 {
+			return s.name
+		}
+		for _, network := range s.networks {
+			if ip != nil && network.Contains(ip) {
+				return s.name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+)
+
+func TestReplicationSources(t *testing.T) {
+	for _, config := range []configuration.Replication{
+		{Trusted: []configuration.ReplicationSource{{Users: []string{"replicator"}}}},
+		{Trusted: []configuration.ReplicationSource{{Name: "primary"}}},
+		{Trusted: []configuration.ReplicationSource{{Name: "primary", Users: []string{"replicator"}, Networks: []string{"10.0.0.1"}}}},
+		{Trusted: []configuration.ReplicationSource{
+			{Name: "primary", Users: []string{"a"}},
+			{Name: "primary", Users: []string{"b"}},
+		}},
+	} {
+		if _, err := newReplicationSources(config); err == nil {
+			t.Errorf("expected an error configuring %+v", config)
+		}
+	}
+
+	var none *replicationSources
+	if source := none.trusted(context.Background(), httptest.NewRequest("PUT", "/", nil)); source != "" {
+		t.Errorf("unexpected source without configuration: %s", source)
+	}
+
+	sources, err := newReplicationSources(configuration.Replication{Trusted: []configuration.ReplicationSource{
+		{Name: "east", Users: []string{"replicator"}, Networks: []string{"10.1.0.0/16"}},
+		{Name: "west", Users: []string{"replicator", "sync"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		user, remoteAddr, forwardedFor string
+		expected                       string
+	}{
+		{"replicator", "10.1.2.3:5000", "", "east"},
+		{"replicator", "192.0.2.1:5000", "", "west"},
+		{"sync", "10.1.2.3:5000", "", "west"},
+		{"alice", "10.1.2.3:5000", "", ""},
+		{"", "10.1.2.3:5000", "", ""},
+		// Proxy headers do not place clients in the networks of a source.
+		{"replicator", "192.0.2.1:5000", "10.1.2.3", "west"},
+	} {
+		r := httptest.NewRequest("PUT", "/", nil)
+		r.RemoteAddr = c.remoteAddr
+		if c.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", c.forwardedFor)
+		}
+		ctx := context.Background()
+		if c.user != "" {
+			ctx = context.WithValue(ctx, dcontext.UserNameKey, c.user)
+		}
+		if source := sources.trusted(ctx, r); source != c.expected {
+			t.Errorf("unexpected source of %s from %s: %q, expected %q", c.user, c.remoteAddr, source, c.expected)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage/driver/storageclass"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// The statuses of restores.
+const (
+	restoreArchived  = "archived"
+	restoreRunning   = "restoring"
+	restoreCompleted = "restored"
+	restoreFailed    = "failed"
+)
+
+// errNotArchived is returned when requesting the restore of a blob which is
+// not archived.
+var errNotArchived = errors.New("blob is not archived")
+
+// restore is the status of the restore of an archived blob.
+type restore struct {
+	Digest digest.Digest `json:"digest"`
+
+	// Class is the archive class the blob is restored from.
+	Class string `json:"class,omitempty"`
+
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	RequestedBy string     `json:"requestedBy,omitempty"`
+	RequestedAt *time.Time `json:"requestedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// restores restores the blobs archived by the archive classes of the
+// storageclass storage driver to its default class, in the background.
+// Restores are tracked by the registry instance they are requested from,
+// while the blobs they restore are read by all instances once restored.
+type restores struct {
+	ctx     context.Context
+	classes *storageclass.Driver
+
+	mu       sync.Mutex
+	restores map[digest.Digest]*restore
+	order    []digest.Digest
+}
+
+// newRestores returns the restores of the blobs archived by classes, run
+// under ctx.
+func newRestores(ctx context.Context, classes *storageclass.Driver) *restores {
+	return &restores{
+		ctx:      ctx,
+		classes:  classes,
+		restores: make(map[digest.Digest]*restore),
+	}
+}
+
+// status returns the status of the restore of the blob dgst, or false if the
+// blob is neither archived nor restored by this instance.
+func (rs *restores) status(ctx context.Context, dgst digest.Digest) (restore, bool, error) {
+	class, err := rs.classes.Archived(ctx, dgst)
+	if err != nil {
+		return restore{}, false, err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	r, ok := rs.restores[dgst]
+	switch {
+	case ok && (class == "" || r.Status != restoreCompleted):
+		return *r, true, nil
+	case class != "":
+		// The blob was never restored, or was archived again since.
+		return restore{Digest: dgst, Class: class, Status: restoreArchived}, true, nil
+	}
+	return restore{}, false, nil
+}
+
+// start starts the restore of the archived blob dgst, requested by user,
+// unless it is already in progress.
+func (rs *restores) start(ctx context.Context, dgst digest.Digest, user string) (restore, error) {
+	class, err := rs.classes.Archived(ctx, dgst)
+	if err != nil {
+		return restore{}, err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if r, ok := rs.restores[dgst]; ok && r.Status == restoreRunning {
+		return *r, nil
+	}
+	if class == "" {
+		return restore{}, errNotArchived
+	}
+
+	now := time.Now().UTC()
+	r := &restore{
+		Digest:      dgst,
+		Class:       class,
+		Status:      restoreRunning,
+		RequestedBy: user,
+		RequestedAt: &now,
+	}
+	if _, ok := rs.restores[dgst]; !ok {
+		rs.order = append(rs.order, dgst)
+	}
+	rs.restores[dgst] = r
+	go rs.run(dgst, class)
+	return *r, nil
+}
+
+// run restores the blob dgst from the archive class named class, recording
+// the outcome in its restore.
+func (rs *restores) run(dgst digest.Digest, class string) {
+	logger := dcontext.GetLoggerWithField(rs.ctx, "digest", dgst)
+	err := rs.classes.Restore(rs.ctx, dgst)
+	now := time.Now().UTC()
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	r := rs.restores[dgst]
+	r.CompletedAt = &now
+	if err != nil {
+		r.Status = restoreFailed
+		r.Error = err.Error()
+		logger.Errorf("error restoring blob from storage class %s: %v", class, err)
+		return
+	}
+	r.Status = restoreCompleted
+	logger.Infof("restored blob from storage class %s", class)
+}
+
+// list returns the restores requested from this instance, in the order they
+// were first requested.
+func (rs *restores) list() []restore {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	list := make([]restore, 0, len(rs.order))
+	for _, dgst := range rs.order {
+		list = append(list, *rs.restores[dgst])
+	}
+	return list
+}
+
+// serveArchived answers the pull of the blob dgst, if archived, with a
+// 202 Accepted response locating the status of its restore, which pulls do
+// not start. It returns false if the blob is not archived.
+func serveArchived(ctx *Context, w http.ResponseWriter, dgst digest.Digest) bool {
+	if ctx.App.restores == nil {
+		return false
+	}
+	status, ok, err := ctx.App.restores.status(ctx, dgst)
+	if err != nil {
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return true
+	}
+	if !ok || status.Status == restoreCompleted {
+		return false
+	}
+
+	restoreURL, err := ctx.urlBuilder.BuildRestoreURL(dgst)
+	if err != nil {
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", restoreURL)
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error encoding the restore of %s: %v", dgst, err)
+	}
+	return true
+}
+
+// restoresDispatcher lists and requests the restores of archived blobs.
+func restoresDispatcher(ctx *Context, r *http.Request) http.Handler {
+	restoresHandler := &restoresHandler{
+		Context: ctx,
+	}
+
+	if ctx.App.restores == nil {
+		return http.HandlerFunc(restoresHandler.Unsupported)
+	}
+
+	mhandler := handlers.MethodHandler{
+		"GET": http.HandlerFunc(restoresHandler.GetRestores),
+	}
+	if !ctx.readOnly {
+		mhandler["POST"] = http.HandlerFunc(restoresHandler.StartRestore)
+	}
+	return mhandler
+}
+
+// restoreDispatcher gets the status of the restore of an archived blob.
+func restoreDispatcher(ctx *Context, r *http.Request) http.Handler {
+	dgst, err := getDigest(ctx)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		})
+	}
+
+	restoresHandler := &restoresHandler{
+		Context: ctx,
+		Digest:  dgst,
+	}
+
+	if ctx.App.restores == nil {
+		return http.HandlerFunc(restoresHandler.Unsupported)
+	}
+
+	return handlers.MethodHandler{
+		"GET": http.HandlerFunc(restoresHandler.GetRestore),
+	}
+}
+
+type restoresHandler struct {
+	*Context
+
+	// Digest is the digest of the blob of the request, if any.
+	Digest digest.Digest
+}
+
+type restoresAPIResponse struct {
+	Restores []restore `json:"restores"`
+}
+
+// Unsupported responds to requests when the storage driver has no archive
+// classes.
+func (rh *restoresHandler) Unsupported(w http.ResponseWriter, r *http.Request) {
+	rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+}
+
+// GetRestores lists the restores requested from this instance.
+func (rh *restoresHandler) GetRestores(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(restoresAPIResponse{Restores: rh.App.restores.list()}); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// StartRestore starts the restore of the archived blob whose digest is given
+// by the request body.
+func (rh *restoresHandler) StartRestore(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Digest digest.Digest `json:"digest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		rh.Errors = append(rh.Errors, v2.ErrorCodeRestoreInvalid.WithDetail(err))
+		return
+	}
+	if err := body.Digest.Validate(); err != nil {
+		rh.Errors = append(rh.Errors, v2.ErrorCodeRestoreInvalid.WithDetail(err.Error()))
+		return
+	}
+
+	status, err := rh.App.restores.start(rh, body.Digest, getUserName(rh, r))
+	if err != nil {
+		if err == errNotArchived {
+			rh.Errors = append(rh.Errors, v2.ErrorCodeRestoreInvalid.WithDetail(map[string]string{"digest": body.Digest.String(), "reason": err.Error()}))
+			return
+		}
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	restoreURL, err := rh.urlBuilder.BuildRestoreURL(body.Digest)
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", restoreURL)
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// GetRestore returns the status of the restore of the blob of the request.
+func (rh *restoresHandler) GetRestore(w http.ResponseWriter, r *http.Request) {
+	status, ok, err := rh.App.restores.status(rh, rh.Digest)
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if !ok {
+		rh.Errors = append(rh.Errors, v2.ErrorCodeRestoreUnknown.WithDetail(map[string]string{"digest": rh.Digest.String()}))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
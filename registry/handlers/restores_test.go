@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage/driver/storageclass"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestRestoresAPIDisabled(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	restoresURL, err := env.builder.BuildRestoresURL()
+	checkErr(t, err, "building restores url")
+
+	resp, err := http.Get(restoresURL)
+	checkErr(t, err, "listing restores")
+	defer resp.Body.Close()
+	checkResponse(t, "listing restores", resp, errcode.ErrorCodeUnsupported.Descriptor().HTTPStatusCode)
+	checkBodyHasErrorCodes(t, "listing restores", resp, errcode.ErrorCodeUnsupported)
+}
+
+func TestRestoresAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"storageclass": configuration.Parameters{"classes": []interface{}{
+				map[interface{}]interface{}{"name": "standard", "inmemory": nil},
+				map[interface{}]interface{}{"name": "glacier", "archive": true, "inmemory": nil},
+			}},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+	classes := env.app.driver.(*storageclass.Driver)
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	manifestDigest := createRepository(env, t, imageName.Name(), "latest")
+
+	content := []byte("archived layer")
+	layerDigest := digest.FromBytes(content)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, bytes.NewReader(content))
+
+	ref, err := reference.WithDigest(imageName, layerDigest)
+	checkErr(t, err, "building layer reference")
+	layerURL, err := env.builder.BuildBlobURL(ref)
+	checkErr(t, err, "building layer url")
+	restoreURL, err := env.builder.BuildRestoreURL(layerDigest)
+	checkErr(t, err, "building restore url")
+	restoresURL, err := env.builder.BuildRestoresURL()
+	checkErr(t, err, "building restores url")
+
+	resp, err := http.Get(restoreURL)
+	checkErr(t, err, "getting restore of a readable blob")
+	defer resp.Body.Close()
+	checkResponse(t, "getting restore of a readable blob", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "getting restore of a readable blob", resp, v2.ErrorCodeRestoreUnknown)
+
+	startRestore := func(msg string, dgst digest.Digest) *http.Response {
+		resp, err := http.Post(restoresURL, "application/json", strings.NewReader(`{"digest":"`+dgst.String()+`"}`))
+		checkErr(t, err, msg)
+		return resp
+	}
+	resp = startRestore("restoring a readable blob", layerDigest)
+	defer resp.Body.Close()
+	checkResponse(t, "restoring a readable blob", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "restoring a readable blob", resp, v2.ErrorCodeRestoreInvalid)
+
+	// Archive the layer and the manifest.
+	for _, dgst := range []digest.Digest{layerDigest, manifestDigest} {
+		if err := classes.Route(context.Background(), dgst, "glacier"); err != nil {
+			t.Fatalf("error archiving %s: %v", dgst, err)
+		}
+	}
+
+	decodeRestore := func(msg string, resp *http.Response) restore {
+		var status restore
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("error decoding %s: %v", msg, err)
+		}
+		return status
+	}
+
+	// Pulls are accepted, locating the status of the restore, but do not
+	// start it.
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequest(method, layerURL, nil)
+		checkErr(t, err, "building archived layer request")
+		resp, err = http.DefaultClient.Do(req)
+		checkErr(t, err, "pulling archived layer")
+		defer resp.Body.Close()
+		checkResponse(t, "pulling archived layer", resp, http.StatusAccepted)
+		checkHeaders(t, resp, http.Header{
+			"Location": []string{restoreURL},
+		})
+	}
+	if status := decodeRestore("status of pulled layer", resp); status.Status != restoreArchived || status.Class != "glacier" {
+		t.Fatalf("unexpected status of an archived layer: %+v", status)
+	}
+
+	tagged, err := reference.WithTag(imageName, "latest")
+	checkErr(t, err, "building tagged reference")
+	manifestURL, err := env.builder.BuildManifestURL(tagged)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "pulling archived manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "pulling archived manifest", resp, http.StatusAccepted)
+	manifestRestoreURL, err := env.builder.BuildRestoreURL(manifestDigest)
+	checkErr(t, err, "building manifest restore url")
+	checkHeaders(t, resp, http.Header{
+		"Location": []string{manifestRestoreURL},
+	})
+
+	resp = startRestore("restoring an archived layer", layerDigest)
+	defer resp.Body.Close()
+	checkResponse(t, "restoring an archived layer", resp, http.StatusAccepted)
+	checkHeaders(t, resp, http.Header{
+		"Location": []string{restoreURL},
+	})
+	if status := decodeRestore("started restore", resp); status.Status != restoreRunning && status.Status != restoreCompleted {
+		t.Fatalf("unexpected status of a started restore: %+v", status)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err = http.Get(restoreURL)
+		checkErr(t, err, "getting restore")
+		defer resp.Body.Close()
+		checkResponse(t, "getting restore", resp, http.StatusOK)
+		status := decodeRestore("restore", resp)
+		if status.Status == restoreCompleted {
+			if status.CompletedAt == nil || status.RequestedAt == nil {
+				t.Fatalf("unexpected status of a completed restore: %+v", status)
+			}
+			break
+		}
+		if status.Status != restoreRunning || time.Now().After(deadline) {
+			t.Fatalf("unexpected status of a restore: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err = http.Get(layerURL)
+	checkErr(t, err, "pulling restored layer")
+	defer resp.Body.Close()
+	checkResponse(t, "pulling restored layer", resp, http.StatusOK)
+
+	resp, err = http.Get(restoresURL)
+	checkErr(t, err, "listing restores")
+	defer resp.Body.Close()
+	checkResponse(t, "listing restores", resp, http.StatusOK)
+	var restores restoresAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&restores); err != nil {
+		t.Fatalf("error decoding restores: %v", err)
+	}
+	if len(restores.Restores) != 1 || restores.Restores[0].Digest != layerDigest || restores.Restores[0].Status != restoreCompleted {
+		t.Fatalf("unexpected restores: %+v", restores.Restores)
+	}
+
+	// The manifest is still archived.
+	resp, err = http.Get(manifestRestoreURL)
+	checkErr(t, err, "getting restore of the manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "getting restore of the manifest", resp, http.StatusOK)
+	if status := decodeRestore("restore of the manifest", resp); status.Status != restoreArchived {
+		t.Fatalf("unexpected status of the archived manifest: %+v", status)
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTagHistoryDisabled(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	tagRef, err := reference.WithTag(imageName, "latest")
+	checkErr(t, err, "building tag reference")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building tag url")
+
+	resp, err := http.Get(tagURL + "?at=" + url.QueryEscape(time.Now().Format(time.RFC3339)))
+	checkErr(t, err, "fetching tag at a past time")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching tag at a past time", resp, errcode.ErrorCodeUnsupported.Descriptor().HTTPStatusCode)
+	checkBodyHasErrorCodes(t, "fetching tag at a past time", resp, errcode.ErrorCodeUnsupported)
+}
+
+func TestTagHistory(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"taghistory": configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	dgst1 := createRepository(env, t, "foo/bar", "latest")
+	tagged1 := time.Now()
+	dgst2 := createRepository(env, t, "foo/bar", "latest")
+	tagged2 := time.Now()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	tagRef, err := reference.WithTag(imageName, "latest")
+	checkErr(t, err, "building tag reference")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building tag url")
+	getAt := func(msg, at string) *http.Response {
+		resp, err := http.Get(tagURL + "?at=" + url.QueryEscape(at))
+		checkErr(t, err, msg)
+		return resp
+	}
+
+	for _, c := range []struct {
+		at       time.Time
+		expected digest.Digest
+	}{
+		{tagged1, dgst1},
+		{tagged2, dgst2},
+	} {
+		resp := getAt("fetching tag at a past time", c.at.Format(time.RFC3339Nano))
+		defer resp.Body.Close()
+		checkResponse(t, "fetching tag at a past time", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{c.expected.String()},
+		})
+	}
+
+	resp := getAt("fetching tag before it was written", before.Format(time.RFC3339Nano))
+	defer resp.Body.Close()
+	checkResponse(t, "fetching tag before it was written", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching tag before it was written", resp, v2.ErrorCodeManifestUnknown)
+
+	resp = getAt("fetching tag at an invalid time", "last tuesday")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching tag at an invalid time", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "fetching tag at an invalid time", resp, v2.ErrorCodeTimestampInvalid)
+}
+
+func TestTagHistoryFeature(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Features: map[string]configuration.FeatureFlag{
+			"taghistory": {Namespaces: []string{"canary"}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	for _, c := range []struct {
+		name   string
+		status int
+	}{
+		{"canary/app", http.StatusOK},
+		{"stable/app", errcode.ErrorCodeUnsupported.Descriptor().HTTPStatusCode},
+	} {
+		createRepository(env, t, c.name, "latest")
+		tagged := time.Now()
+
+		imageName, err := reference.WithName(c.name)
+		checkErr(t, err, "building image name")
+		tagRef, err := reference.WithTag(imageName, "latest")
+		checkErr(t, err, "building tag reference")
+		tagURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building tag url")
+
+		resp, err := http.Get(tagURL + "?at=" + url.QueryEscape(tagged.Format(time.RFC3339Nano)))
+		checkErr(t, err, "fetching tag at a past time")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching tag of "+c.name+" at a past time", resp, c.status)
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// tagOperationsDispatcher lists and merges the operations on the tags of a
+// repository.
+func tagOperationsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagOperationsHandler := &tagOperationsHandler{
+		Context: ctx,
+	}
+
+	if ctx.App.tagOperationsActor == "" {
+		return http.HandlerFunc(tagOperationsHandler.Unsupported)
+	}
+
+	mhandler := handlers.MethodHandler{
+		"GET": http.HandlerFunc(tagOperationsHandler.GetTagOperations),
+	}
+	if !ctx.readOnly {
+		mhandler["POST"] = http.HandlerFunc(tagOperationsHandler.MergeTagOperations)
+	}
+	return mhandler
+}
+
+type tagOperationsHandler struct {
+	*Context
+}
+
+type tagOperationsAPIResponse struct {
+	Name       string                 `json:"name"`
+	Operations []storage.TagOperation `json:"operations"`
+}
+
+type tagOperationsAPIRequest struct {
+	Operations []storage.TagOperation `json:"operations"`
+}
+
+// Unsupported responds to requests when tag operations are disabled.
+func (toh *tagOperationsHandler) Unsupported(w http.ResponseWriter, r *http.Request) {
+	toh.Errors = append(toh.Errors, errcode.ErrorCodeUnsupported)
+}
+
+// log returns the operation log of the tags of the repository of the
+// request. It is read from the storage of the registry, as the tag service
+// of the request repository is wrapped by notifications and middleware.
+func (toh *tagOperationsHandler) log() (storage.TagOperationLog, error) {
+	repository, err := toh.App.registry.Repository(toh, toh.Repository.Named())
+	if err != nil {
+		return nil, err
+	}
+	log, ok := repository.Tags(toh).(storage.TagOperationLog)
+	if !ok {
+		return nil, errcode.ErrorCodeUnsupported
+	}
+	return log, nil
+}
+
+// GetTagOperations returns the latest operation on each tag of the
+// repository.
+func (toh *tagOperationsHandler) GetTagOperations(w http.ResponseWriter, r *http.Request) {
+	log, err := toh.log()
+	if err != nil {
+		toh.Errors = append(toh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	ops, err := log.Operations(toh)
+	if err != nil {
+		toh.Errors = append(toh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if ops == nil {
+		ops = []storage.TagOperation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tagOperationsAPIResponse{
+		Name:       toh.Repository.Named().Name(),
+		Operations: ops,
+	}); err != nil {
+		toh.Errors = append(toh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// MergeTagOperations merges the operations of the request body into the
+// tags of the repository.
+func (toh *tagOperationsHandler) MergeTagOperations(w http.ResponseWriter, r *http.Request) {
+	var request tagOperationsAPIRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		toh.Errors = append(toh.Errors, v2.ErrorCodeTagOperationInvalid.WithDetail(err))
+		return
+	}
+	for _, op := range request.Operations {
+		if err := op.Validate(); err != nil {
+			toh.Errors = append(toh.Errors, v2.ErrorCodeTagOperationInvalid.WithDetail(err.Error()))
+			return
+		}
+	}
+
+	log, err := toh.log()
+	if err != nil {
+		toh.Errors = append(toh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	if err := log.Merge(toh, request.Operations); err != nil {
+		switch err := err.(type) {
+		case distribution.ErrManifestUnknownRevision:
+			toh.Errors = append(toh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		case distribution.ErrContentHeld:
+			toh.Errors = append(toh.Errors, errcode.ErrorCodeDenied.WithMessage(err.Error()))
+		default:
+			toh.Errors = append(toh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTagOperationsAPIDisabled(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	tagOperationsURL, err := env.builder.BuildTagOperationsURL(imageName)
+	checkErr(t, err, "building tag operations url")
+
+	resp, err := http.Get(tagOperationsURL)
+	checkErr(t, err, "listing tag operations")
+	defer resp.Body.Close()
+	checkResponse(t, "listing tag operations", resp, errcode.ErrorCodeUnsupported.Descriptor().HTTPStatusCode)
+	checkBodyHasErrorCodes(t, "listing tag operations", resp, errcode.ErrorCodeUnsupported)
+}
+
+func TestTagOperationsAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver":    configuration.Parameters{},
+			"tagoperations": configuration.Parameters{"enabled": true, "actor": "east"},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+
+	tagOperationsURL, err := env.builder.BuildTagOperationsURL(imageName)
+	checkErr(t, err, "building tag operations url")
+
+	resp, err := http.Get(tagOperationsURL)
+	checkErr(t, err, "listing tag operations")
+	defer resp.Body.Close()
+	checkResponse(t, "listing tag operations", resp, http.StatusOK)
+
+	var list tagOperationsAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("error decoding tag operations: %v", err)
+	}
+	if list.Name != "foo/bar" || len(list.Operations) != 1 {
+		t.Fatalf("unexpected tag operations: %v", list)
+	}
+	op := list.Operations[0]
+	if op.Tag != "latest" || op.Digest != dgst || op.Actor != "east" {
+		t.Fatalf("unexpected tag operation: %v", op)
+	}
+
+	mergeOperations := func(msg string, ops ...storage.TagOperation) *http.Response {
+		body, err := json.Marshal(tagOperationsAPIRequest{Operations: ops})
+		checkErr(t, err, msg)
+		resp, err := http.Post(tagOperationsURL, "application/json", bytes.NewReader(body))
+		checkErr(t, err, msg)
+		return resp
+	}
+
+	resp = mergeOperations("merging invalid operation", storage.TagOperation{Tag: "latest", Timestamp: time.Now()})
+	defer resp.Body.Close()
+	checkResponse(t, "merging invalid operation", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "merging invalid operation", resp, v2.ErrorCodeTagOperationInvalid)
+
+	resp = mergeOperations("merging operation of unknown manifest", storage.TagOperation{Tag: "latest", Digest: digest.FromString("unknown"), Timestamp: op.Timestamp.Add(time.Second), Actor: "west"})
+	defer resp.Body.Close()
+	checkResponse(t, "merging operation of unknown manifest", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "merging operation of unknown manifest", resp, v2.ErrorCodeManifestUnknown)
+
+	// A later deletion in another region deletes the tag.
+	resp = mergeOperations("merging deletion", storage.TagOperation{Tag: "latest", Timestamp: op.Timestamp.Add(time.Second), Actor: "west"})
+	defer resp.Body.Close()
+	checkResponse(t, "merging deletion", resp, http.StatusNoContent)
+
+	tagRef, err := reference.WithTag(imageName, "latest")
+	checkErr(t, err, "building tag reference")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building tag url")
+
+	resp, err = http.Get(tagURL)
+	checkErr(t, err, "fetching deleted tag")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching deleted tag", resp, http.StatusNotFound)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+)
+
+func TestTagsByPlatform(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"links":      configuration.Parameters{"metadata": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+	repo, err := env.app.registry.Repository(env.ctx, imageName)
+	checkErr(t, err, "getting repository")
+	manifests, err := repo.Manifests(env.ctx)
+	checkErr(t, err, "getting manifest service")
+
+	for tag, config := range map[string]string{
+		"amd64": `{"os":"linux","architecture":"amd64"}`,
+		"arm64": `{"os":"linux","architecture":"arm64","variant":"v8"}`,
+		"arm":   `{"os":"linux","architecture":"arm","variant":"v7"}`,
+	} {
+		m, err := ocischema.NewManifestBuilder(repo.Blobs(env.ctx), []byte(config), nil).Build(env.ctx)
+		checkErr(t, err, "building manifest")
+		dgst, err := manifests.Put(env.ctx, m)
+		checkErr(t, err, "putting manifest")
+		checkErr(t, repo.Tags(env.ctx).Tag(env.ctx, tag, distribution.Descriptor{Digest: dgst}), "tagging manifest")
+	}
+
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	checkErr(t, err, "building tags url")
+	for platform, expected := range map[string][]string{
+		"linux/arm64":    {"arm64"},
+		"linux/arm":      {"arm"},
+		"linux/arm/v6":   {},
+		"windows/amd64":  {},
+		"linux/arm64/v8": {"arm64"},
+	} {
+		resp, err := http.Get(tagsURL + "?platform=" + url.QueryEscape(platform))
+		checkErr(t, err, "listing tags by platform")
+		defer resp.Body.Close()
+		checkResponse(t, "listing tags by platform", resp, http.StatusOK)
+
+		var body tagsAPIResponse
+		checkErr(t, json.NewDecoder(resp.Body).Decode(&body), "decoding tags")
+		if !reflect.DeepEqual(body.Tags, expected) {
+			t.Errorf("unexpected tags of %s: %v != %v", platform, body.Tags, expected)
+		}
+	}
+
+	resp, err := http.Get(tagsURL + "?platform=linux")
+	checkErr(t, err, "listing tags by invalid platform")
+	defer resp.Body.Close()
+	checkResponse(t, "listing tags by invalid platform", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "listing tags by invalid platform", resp, v2.ErrorCodePlatformInvalid)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/schema1"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+func TestTagPulls(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	config.Policy.TagPulls = []configuration.TagPullRule{
+		{
+			Name:         "legacy",
+			Repositories: []string{"legacy/*"},
+			MediaTypes:   []string{schema1.MediaTypeSignedManifest},
+			Message:      "schema1 images must be pulled by digest",
+		},
+		{
+			Name:       "schema1",
+			MediaTypes: []string{schema1.MediaTypeSignedManifest},
+			Mode:       "deprecate",
+		},
+	}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	get := func(msg string, ref reference.Named) *http.Response {
+		u, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		resp, err := http.Get(u)
+		checkErr(t, err, msg)
+		return resp
+	}
+
+	dgst := createRepository(env, t, "foo/bar", "latest")
+	imageName, _ := reference.WithName("foo/bar")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	resp := get("fetching deprecated manifest by tag", tagRef)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching deprecated manifest by tag", resp, http.StatusOK)
+	if warning := resp.Header.Get("Warning"); warning == "" {
+		t.Error("expected a warning pulling a deprecated manifest by tag")
+	}
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	resp = get("fetching deprecated manifest by digest", digestRef)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching deprecated manifest by digest", resp, http.StatusOK)
+	if warning := resp.Header.Get("Warning"); warning != "" {
+		t.Errorf("unexpected warning pulling by digest: %q", warning)
+	}
+
+	createRepository(env, t, "legacy/bar", "latest")
+	legacyName, _ := reference.WithName("legacy/bar")
+	tagRef, _ = reference.WithTag(legacyName, "latest")
+	resp = get("fetching disabled manifest by tag", tagRef)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching disabled manifest by tag", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "fetching disabled manifest by tag", resp, errcode.ErrorCodeDenied)
+}
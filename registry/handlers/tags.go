@@ -2,14 +2,18 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/gorilla/handlers"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // tagsDispatcher constructs the tags handler api endpoint.
@@ -40,19 +44,31 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 	tagService := th.Repository.Tags(th)
 	tags, err := tagService.All(th)
 	if err != nil {
-		switch err := err.(type) {
-		case distribution.ErrRepositoryUnknown:
+		if errors.As(err, &distribution.ErrRepositoryUnknown{}) {
 			th.Errors = append(th.Errors, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": th.Repository.Named().Name()}))
-		case errcode.Error:
+		} else if err, ok := err.(errcode.Error); ok {
 			th.Errors = append(th.Errors, err)
-		default:
+		} else {
 			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
 		return
 	}
 
-	// do pagination if requested
+	// filter by platform if requested
 	q := r.URL.Query()
+	if platform := q.Get("platform"); platform != "" {
+		tags, err = th.platformTags(tags, platform)
+		if err != nil {
+			if err, ok := err.(errcode.Error); ok {
+				th.Errors = append(th.Errors, err)
+			} else {
+				th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			}
+			return
+		}
+	}
+
+	// do pagination if requested
 	// get entries after latest, if any specified
 	if lastEntry := q.Get("last"); lastEntry != "" {
 		lastEntryIndex := sort.SearchStrings(tags, lastEntry)
@@ -107,3 +123,58 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// platformTags returns the tags of tags pointing at manifests with an image
+// of platform, in the os/architecture[/variant] format. The platforms are
+// read from the storage of the registry, where they are recorded when the
+// manifests are pushed, as the manifest service of the request repository
+// is wrapped by notifications and middleware.
+func (th *tagsHandler) platformTags(tags []string, platform string) ([]string, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, v2.ErrorCodePlatformInvalid.WithDetail(map[string]string{"platform": platform})
+	}
+	filter := v1.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		filter.Variant = parts[2]
+	}
+
+	repository, err := th.App.registry.Repository(th, th.Repository.Named())
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := repository.Manifests(th)
+	if err != nil {
+		return nil, err
+	}
+	platforms, ok := manifests.(storage.ManifestPlatforms)
+	if !ok {
+		return nil, errcode.ErrorCodeUnsupported.WithDetail("the platforms of manifests are not recorded")
+	}
+
+	matching := []string{}
+	for _, tag := range tags {
+		desc, err := repository.Tags(th).Get(th, tag)
+		if err != nil {
+			if errors.As(err, &distribution.ErrTagUnknown{}) {
+				// The tag was deleted since it was listed.
+				continue
+			}
+			return nil, err
+		}
+		manifestPlatforms, err := platforms.Platforms(th, desc.Digest)
+		if err != nil {
+			if errors.As(err, &distribution.ErrManifestUnknownRevision{}) {
+				continue
+			}
+			return nil, err
+		}
+		for _, p := range manifestPlatforms {
+			if storage.MatchPlatform(filter, p) {
+				matching = append(matching, tag)
+				break
+			}
+		}
+	}
+	return matching, nil
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// repositoryTemplates bootstraps the repositories created with the first
+// template matching them, and enforces the settings repositories were
+// bootstrapped with.
+type repositoryTemplates struct {
+	templates []configuration.RepositoryTemplate
+	driver    storagedriver.StorageDriver
+}
+
+// newRepositoryTemplates checks the templates configured, reading the
+// bootstraps of repositories from storageDriver.
+func newRepositoryTemplates(templates []configuration.RepositoryTemplate, storageDriver storagedriver.StorageDriver) (*repositoryTemplates, error) {
+	names := make(map[string]bool, len(templates))
+	for _, t := range templates {
+		if t.Name == "" {
+			return nil, fmt.Errorf("repository templates must be named")
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("repository template %s is configured twice", t.Name)
+		}
+		names[t.Name] = true
+		for _, pattern := range t.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("repository template %s: invalid pattern %q", t.Name, pattern)
+			}
+		}
+		for _, key := range t.RequiredAnnotations {
+			if strings.TrimSpace(key) == "" {
+				return nil, fmt.Errorf("repository template %s: required annotations cannot be empty", t.Name)
+			}
+		}
+	}
+	return &repositoryTemplates{templates: templates, driver: storageDriver}, nil
+}
+
+// bootstrap returns the bootstrap of the repository from the first template
+// matching it, or false if none does.
+func (rt *repositoryTemplates) bootstrap(repository string) (storage.RepositoryBootstrap, bool) {
+	for _, t := range rt.templates {
+		if len(t.Repositories) > 0 && !matchesAny(t.Repositories, repository) {
+			continue
+		}
+		return storage.RepositoryBootstrap{
+			Template:            t.Name,
+			RequiredAnnotations: t.RequiredAnnotations,
+		}, true
+	}
+	return storage.RepositoryBootstrap{}, false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// templateDetail is the detail of the errors denying manifests missing the
+// annotations required by the bootstrap of their repository.
+type templateDetail struct {
+	Template string   `json:"template"`
+	Missing  []string `json:"missing"`
+}
+
+// Repository returns the repository with the annotations required by its
+// bootstrap checked as manifests are pushed. Referrers, such as signatures,
+// are not checked.
+func (rt *repositoryTemplates) Repository(repository distribution.Repository) distribution.Repository {
+	return repositorymiddleware.WithHooks(repository, repositorymiddleware.Hooks{
+		PushManifest: func(ctx context.Context, named reference.Named, tag string, manifest distribution.Manifest) (distribution.Manifest, error) {
+			bootstrap, err := storage.GetRepositoryBootstrap(ctx, rt.driver, named.Name())
+			if err != nil || bootstrap == nil || len(bootstrap.RequiredAnnotations) == 0 {
+				return manifest, err
+			}
+
+			_, payload, err := manifest.Payload()
+			if err != nil {
+				return nil, err
+			}
+			var fields struct {
+				Subject     *distribution.Descriptor `json:"subject"`
+				Annotations map[string]string        `json:"annotations"`
+			}
+			if err := json.Unmarshal(payload, &fields); err != nil {
+				return nil, err
+			}
+			if fields.Subject != nil {
+				return manifest, nil
+			}
+
+			var missing []string
+			for _, key := range bootstrap.RequiredAnnotations {
+				if _, ok := fields.Annotations[key]; !ok {
+					missing = append(missing, key)
+				}
+			}
+			if len(missing) == 0 {
+				return manifest, nil
+			}
+
+			dcontext.GetLogger(ctx).Infof("manifest pushed into %s denied by repository template %s: missing annotations %s", named.Name(), bootstrap.Template, strings.Join(missing, ", "))
+			return nil, errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf("repository %s requires annotations %s", named.Name(), strings.Join(missing, ", "))).
+				WithDetail(templateDetail{
+					Template: bootstrap.Template,
+					Missing:  missing,
+				})
+		},
+	})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestRepositoryTemplates(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Templates: []configuration.RepositoryTemplate{
+			{Name: "team", Repositories: []string{"team/*"}, RequiredAnnotations: []string{"org.example.owner"}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	for _, c := range []struct {
+		name        string
+		annotations map[string]string
+		status      int
+	}{
+		{"team/app", nil, errcode.ErrorCodeDenied.Descriptor().HTTPStatusCode},
+		{"team/app", map[string]string{"org.example.owner": "team"}, http.StatusCreated},
+		{"other/app", nil, http.StatusCreated},
+	} {
+		imageName, err := reference.WithName(c.name)
+		checkErr(t, err, "building image name")
+		repo, err := env.app.registry.Repository(env.ctx, imageName)
+		checkErr(t, err, "getting repository")
+		m, err := ocischema.NewManifestBuilder(repo.Blobs(env.ctx), []byte(`{}`), c.annotations).Build(env.ctx)
+		checkErr(t, err, "building manifest")
+
+		tagRef, err := reference.WithTag(imageName, "latest")
+		checkErr(t, err, "building tag reference")
+		tagURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building tag url")
+		resp := putManifest(t, "pushing manifest", tagURL, v1.MediaTypeImageManifest, m)
+		defer resp.Body.Close()
+		checkResponse(t, "pushing manifest into "+c.name, resp, c.status)
+	}
+
+	bootstrap, err := storage.GetRepositoryBootstrap(env.ctx, env.app.driver, "team/app")
+	checkErr(t, err, "getting bootstrap")
+	if bootstrap == nil || bootstrap.Template != "team" {
+		t.Errorf("unexpected bootstrap of team/app: %+v", bootstrap)
+	}
+	if bootstrap, err := storage.GetRepositoryBootstrap(env.ctx, env.app.driver, "other/app"); err != nil || bootstrap != nil {
+		t.Errorf("unexpected bootstrap of other/app: %+v, %v", bootstrap, err)
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/docker/go-metrics"
+)
+
+var (
+	// purgeRuns counts the upload purges run, labeled by whether they were
+	// scheduled or requested at /debug/uploads/purge.
+	purgeRuns = prometheus.UploadsNamespace.NewLabeledCounter("purge_runs", "The number of upload purges run", "trigger")
+
+	// purgedUploads and purgeErrors count the upload directories deleted,
+	// and the errors met, by upload purges.
+	purgedUploads = prometheus.UploadsNamespace.NewCounter("purged", "The number of upload directories deleted by upload purges")
+	purgeErrors   = prometheus.UploadsNamespace.NewCounter("purge_errors", "The number of errors met by upload purges")
+
+	// purgeLastRun and purgeNextRun are the times the last upload purge
+	// finished and the next one is scheduled to start.
+	purgeLastRun = prometheus.UploadsNamespace.NewGauge("purge_last_run", "The time the last upload purge finished, in seconds since the epoch", metrics.Seconds)
+	purgeNextRun = prometheus.UploadsNamespace.NewGauge("purge_next_run", "The time the next upload purge is scheduled to start, in seconds since the epoch", metrics.Seconds)
+)
+
+// uploadPurgeStatus describes the runs of an upload purger.
+type uploadPurgeStatus struct {
+	// NextRun is the time the next purge is scheduled to start.
+	NextRun time.Time
+
+	// LastRun is the time the last purge finished, zero if none has, and
+	// LastDuration the time it took.
+	LastRun      time.Time
+	LastDuration time.Duration
+
+	// LastDeleted and LastErrors are the numbers of upload directories the
+	// last purge deleted, or would have in dry run, and of errors it met.
+	LastDeleted int
+	LastErrors  int
+
+	// DryRun is set when the purges only report the uploads to delete.
+	DryRun bool
+}
+
+// uploadPurger periodically deletes the uploads older than age, and on
+// demand when triggered.
+type uploadPurger struct {
+	ctx      context.Context
+	driver   storagedriver.StorageDriver
+	log      dcontext.Logger
+	age      time.Duration
+	interval time.Duration
+	dryRun   bool
+
+	// trigger requests a purge before the next scheduled one.
+	trigger chan struct{}
+
+	mu     sync.Mutex
+	status uploadPurgeStatus
+}
+
+func newUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, age, interval time.Duration, dryRun bool) *uploadPurger {
+	return &uploadPurger{
+		ctx:      ctx,
+		driver:   storageDriver,
+		log:      log,
+		age:      age,
+		interval: interval,
+		dryRun:   dryRun,
+		trigger:  make(chan struct{}, 1),
+		status:   uploadPurgeStatus{DryRun: dryRun},
+	}
+}
+
+// run purges uploads after delay, then every interval or when triggered.
+func (up *uploadPurger) run(delay time.Duration) {
+	trigger := "scheduled"
+	for {
+		up.setNextRun(time.Now().Add(delay))
+		up.log.Infof("Starting upload purge in %s", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-up.trigger:
+			timer.Stop()
+			trigger = "requested"
+		}
+
+		up.purge(trigger)
+		delay, trigger = up.interval, "scheduled"
+	}
+}
+
+func (up *uploadPurger) purge(trigger string) {
+	start := time.Now()
+	deleted, errs := storage.PurgeUploads(up.ctx, up.driver, start.Add(-up.age), !up.dryRun)
+	end := time.Now()
+
+	purgeRuns.WithValues(trigger).Inc(1)
+	purgedUploads.Inc(float64(len(deleted)))
+	purgeErrors.Inc(float64(len(errs)))
+	purgeLastRun.Set(float64(end.Unix()))
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	up.status.LastRun = end
+	up.status.LastDuration = end.Sub(start)
+	up.status.LastDeleted = len(deleted)
+	up.status.LastErrors = len(errs)
+}
+
+func (up *uploadPurger) setNextRun(t time.Time) {
+	purgeNextRun.Set(float64(t.Unix()))
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	up.status.NextRun = t
+}
+
+// Status returns the state of the runs of the purger.
+func (up *uploadPurger) Status() uploadPurgeStatus {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	return up.status
+}
+
+// Trigger requests a purge to start right away, unless one already is
+// requested.
+func (up *uploadPurger) Trigger() {
+	select {
+	case up.trigger <- struct{}{}:
+	default:
+	}
+}
+
+var (
+	// activePurger is the upload purger of the application last created,
+	// reported at /debug/vars and triggered at /debug/uploads/purge.
+	activePurgerMu sync.Mutex
+	activePurger   *uploadPurger
+)
+
+func setActivePurger(up *uploadPurger) {
+	activePurgerMu.Lock()
+	defer activePurgerMu.Unlock()
+	activePurger = up
+}
+
+func getActivePurger() *uploadPurger {
+	activePurgerMu.Lock()
+	defer activePurgerMu.Unlock()
+	return activePurger
+}
+
+// UploadPurgeHandler starts, on POST, an upload purge right away rather than
+// at its next scheduled run, and responds with the state of the last run.
+func UploadPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	up := getActivePurger()
+	if up == nil {
+		http.Error(w, "upload purging is disabled", http.StatusNotFound)
+		return
+	}
+
+	up.Trigger()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(up.Status())
+}
+
+func init() {
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+
+	registry.(*expvar.Map).Set("uploadpurging", expvar.Func(func() interface{} {
+		if up := getActivePurger(); up != nil {
+			return up.Status()
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestUploadPurgeHandler(t *testing.T) {
+	ctx := context.Background()
+	purger := newUploadPurger(ctx, inmemory.New(), dcontext.GetLogger(ctx), time.Hour, time.Hour, true)
+	setActivePurger(purger)
+	defer setActivePurger(nil)
+	go purger.run(time.Hour)
+
+	recorder := httptest.NewRecorder()
+	UploadPurgeHandler(recorder, httptest.NewRequest(http.MethodGet, "/debug/uploads/purge", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status of GET: %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	UploadPurgeHandler(recorder, httptest.NewRequest(http.MethodPost, "/debug/uploads/purge", nil))
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("unexpected status of POST: %d", recorder.Code)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for purger.Status().LastRun.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the purge to run once triggered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := purger.Status()
+	if until := time.Until(status.NextRun); until <= 50*time.Minute {
+		t.Fatalf("expected the next purge to be scheduled an interval later, not in %s", until)
+	}
+	if status.LastDeleted != 0 || !status.DryRun {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
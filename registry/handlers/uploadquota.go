@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+// defaultUploadQuotaWindow is the window of upload quotas, unless configured
+// otherwise.
+const defaultUploadQuotaWindow = time.Hour
+
+// quotaBytes counts the blob bytes pushed by the subjects of upload quotas.
+var quotaBytes = prometheus.UploadsNamespace.NewCounter("quota_bytes", "The number of blob bytes pushed by subjects with an upload quota")
+
+// uploadQuota limits the blob bytes each subject pushes per window, with a
+// token bucket per subject holding its quota and refilled over the window.
+// Subjects may push while their bucket is not empty: the bytes of a request
+// are taken as they are received, so the request which exhausts a quota
+// carries on, leaving the bucket in debt until enough of the window passes.
+type uploadQuota struct {
+	bytes    int64
+	window   time.Duration
+	subjects map[string]int64
+	buckets  *bucketSet
+}
+
+// newUploadQuota returns the quota configured, or nil if none is.
+func newUploadQuota(config configuration.UploadQuota) *uploadQuota {
+	enabled := config.Bytes > 0
+	for _, bytes := range config.Subjects {
+		enabled = enabled || bytes > 0
+	}
+	if !enabled {
+		return nil
+	}
+
+	window := config.Window
+	if window <= 0 {
+		window = defaultUploadQuotaWindow
+	}
+
+	q := &uploadQuota{
+		bytes:    config.Bytes,
+		window:   window,
+		subjects: config.Subjects,
+	}
+	q.buckets = &bucketSet{
+		newBucket: func(subject string, now time.Time) *tokenBucket {
+			bytes := float64(q.quota(subject))
+			return &tokenBucket{
+				rate:   bytes / window.Seconds(),
+				burst:  bytes,
+				tokens: bytes,
+				last:   now,
+			}
+		},
+		buckets: make(map[string]*tokenBucket),
+	}
+	return q
+}
+
+// quota returns the bytes subject may push per window, or a number below one
+// if it has no quota.
+func (q *uploadQuota) quota(subject string) int64 {
+	if bytes, ok := q.subjects[subject]; ok {
+		return bytes
+	}
+	return q.bytes
+}
+
+// admit reports whether the subject of the request may push blob data, and
+// if so meters the body of the request against its quota. If it may not,
+// the response is set up to ask the client to retry once its quota allows.
+// Requests starting uploads are refused as those sending data, but not the
+// requests completing uploads without sending data.
+func (q *uploadQuota) admit(ctx *Context, w http.ResponseWriter, r *http.Request) bool {
+	if q == nil || (r.Method != http.MethodPost && r.ContentLength == 0) {
+		return true
+	}
+
+	subject := getUserName(ctx, r)
+	if subject == "" {
+		subject = dcontext.RemoteIP(r)
+	}
+	if q.quota(subject) <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	b := q.buckets.get(subject, now)
+	if wait := b.debt(now); wait > 0 {
+		rejectedUploads.WithValues("quota").Inc(1)
+		dcontext.GetLogger(ctx).Warnf("upload quota of %s exceeded, refilled in %s", subject, wait)
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.WithMessage("upload quota exceeded, retry the upload later"))
+		return false
+	}
+
+	if r.Body != nil {
+		r.Body = &quotaReader{ReadCloser: r.Body, bucket: b}
+	}
+	return true
+}
+
+// debt returns how long it takes to refill the bucket until it is no longer
+// empty, or zero if it is not.
+func (b *tokenBucket) debt(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens > 0 {
+		return 0
+	}
+	// Wait until a byte may be pushed, rather than until the bucket is
+	// merely empty.
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// quotaReader takes the bytes read from the body of an upload from the
+// bucket of the quota of its subject.
+type quotaReader struct {
+	io.ReadCloser
+	bucket *tokenBucket
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.bucket.take(time.Now(), n)
+		quotaBytes.Inc(float64(n))
+	}
+	return n, err
+}
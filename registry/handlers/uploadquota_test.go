@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+func TestUploadQuota(t *testing.T) {
+	if q := newUploadQuota(configuration.UploadQuota{Subjects: map[string]int64{"ci": -1}}); q != nil {
+		t.Fatal("expected no quota without limits")
+	}
+
+	q := newUploadQuota(configuration.UploadQuota{
+		Bytes:    1000,
+		Window:   time.Hour,
+		Subjects: map[string]int64{"mirror": -1, "small": 10},
+	})
+
+	push := func(user, method string, body string) (*Context, *httptest.ResponseRecorder, bool) {
+		ctx := &Context{Context: context.Background()}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/v2/foo/blobs/uploads/", strings.NewReader(body))
+		if body == "" {
+			r.ContentLength = 0
+		}
+		r.SetBasicAuth(user, "password")
+		ok := q.admit(ctx, w, r)
+		if ok {
+			io.Copy(io.Discard, r.Body)
+		}
+		return ctx, w, ok
+	}
+
+	// The request exhausting the quota is read in full.
+	if _, _, ok := push("alice", http.MethodPatch, strings.Repeat("a", 1500)); !ok {
+		t.Fatal("expected an upload to be admitted within the quota")
+	}
+	ctx, w, ok := push("alice", http.MethodPatch, "a")
+	if ok {
+		t.Fatal("expected an upload to be refused over the quota")
+	}
+	// 501 bytes are refilled in 1804s at 1000 bytes per hour.
+	if retry := w.Header().Get("Retry-After"); retry != "1804" {
+		t.Fatalf("unexpected Retry-After header: %q", retry)
+	}
+	if len(ctx.Errors) != 1 || ctx.Errors[0].(errcode.Error).Code != errcode.ErrorCodeTooManyRequests {
+		t.Fatalf("unexpected errors: %v", ctx.Errors)
+	}
+	if _, _, ok := push("alice", http.MethodPost, ""); ok {
+		t.Fatal("expected a new upload to be refused over the quota")
+	}
+	if _, _, ok := push("alice", http.MethodPut, ""); !ok {
+		t.Fatal("expected an upload to be completed without data over the quota")
+	}
+
+	// Quotas are per subject, and overridden for the subjects listed.
+	if _, _, ok := push("bob", http.MethodPatch, "b"); !ok {
+		t.Fatal("expected another subject to be admitted")
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, ok := push("mirror", http.MethodPatch, strings.Repeat("m", 2000)); !ok {
+			t.Fatal("expected an exempt subject to be admitted")
+		}
+	}
+	push("small", http.MethodPatch, strings.Repeat("s", 20))
+	if _, _, ok := push("small", http.MethodPatch, "s"); ok {
+		t.Fatal("expected a subject to be refused over its own quota")
+	}
+}
+
+func TestUploadQuotaRefill(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{rate: 10, burst: 100, tokens: 100, last: now}
+	if d := b.debt(now); d != 0 {
+		t.Fatalf("unexpected debt of a full bucket: %s", d)
+	}
+	b.take(now, 150)
+	if d := b.debt(now); d != 5100*time.Millisecond {
+		t.Fatalf("unexpected debt: %s", d)
+	}
+	if d := b.debt(now.Add(6 * time.Second)); d != 0 {
+		t.Fatalf("unexpected debt after refilling: %s", d)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	runtimemetrics "runtime/metrics"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/docker/go-metrics"
+)
+
+// defaultUploadRetryAfter is the delay clients refused an upload are asked
+// to wait, unless configured otherwise.
+const defaultUploadRetryAfter = 10 * time.Second
+
+// heapMetric is the runtime metric of the memory held by heap objects, read
+// without stopping the world unlike runtime.ReadMemStats.
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// rejectedUploads counts the blob uploads refused by the guardrails, labeled
+// by the limit which was exceeded.
+var rejectedUploads = prometheus.UploadsNamespace.NewLabeledCounter("rejected", "The number of blob uploads refused while the registry is busy", "reason")
+
+func init() {
+	metrics.Register(prometheus.UploadsNamespace)
+}
+
+// uploadGuard refuses new blob uploads while too many upload requests are in
+// progress or the heap is above the memory watermark, so that push storms
+// slow down instead of getting the registry killed for running out of
+// memory.
+type uploadGuard struct {
+	maxConcurrent   int64
+	memoryWatermark uint64
+	retryAfter      time.Duration
+
+	// active is the number of upload requests in progress.
+	active int64
+
+	// heap returns the size of the heap; replaced in tests.
+	heap func() uint64
+}
+
+// newUploadGuard returns the guard enforcing the configured limits, or nil
+// if none is configured.
+func newUploadGuard(config configuration.Uploads) *uploadGuard {
+	if config.MaxConcurrent <= 0 && config.MemoryWatermark == 0 {
+		return nil
+	}
+
+	retryAfter := config.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultUploadRetryAfter
+	}
+
+	return &uploadGuard{
+		maxConcurrent:   int64(config.MaxConcurrent),
+		memoryWatermark: config.MemoryWatermark,
+		retryAfter:      retryAfter,
+		heap:            heapSize,
+	}
+}
+
+// admit reports whether a new upload may start. If it may not, the response
+// is set up to ask the client to retry later.
+func (g *uploadGuard) admit(ctx *Context, w http.ResponseWriter) bool {
+	if g == nil {
+		return true
+	}
+
+	var reason string
+	switch {
+	case g.maxConcurrent > 0 && atomic.LoadInt64(&g.active) >= g.maxConcurrent:
+		reason = "concurrency"
+	case g.memoryWatermark > 0 && g.heap() >= g.memoryWatermark:
+		reason = "memory"
+	default:
+		return true
+	}
+
+	rejectedUploads.WithValues(reason).Inc(1)
+	w.Header().Set("Retry-After", strconv.Itoa(int((g.retryAfter+time.Second-1)/time.Second)))
+	ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnavailable.WithMessage("the registry is busy, retry the upload later"))
+	return false
+}
+
+// track counts an upload request as in progress until the returned function
+// is called.
+func (g *uploadGuard) track() func() {
+	if g == nil {
+		return func() {}
+	}
+
+	atomic.AddInt64(&g.active, 1)
+	return func() {
+		atomic.AddInt64(&g.active, -1)
+	}
+}
+
+func heapSize() uint64 {
+	sample := []runtimemetrics.Sample{{Name: heapMetric}}
+	runtimemetrics.Read(sample)
+	if sample[0].Value.Kind() != runtimemetrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+func TestUploadGuard(t *testing.T) {
+	if g := newUploadGuard(configuration.Uploads{}); g != nil {
+		t.Fatal("expected no guard without limits")
+	}
+
+	g := newUploadGuard(configuration.Uploads{MaxConcurrent: 2, MemoryWatermark: 1 << 30, RetryAfter: 1500 * time.Millisecond})
+	var heap uint64
+	g.heap = func() uint64 { return heap }
+
+	admit := func() (*Context, *httptest.ResponseRecorder, bool) {
+		ctx := &Context{}
+		w := httptest.NewRecorder()
+		return ctx, w, g.admit(ctx, w)
+	}
+
+	done := g.track()
+	if _, _, ok := admit(); !ok {
+		t.Fatal("expected an upload to be admitted below the limits")
+	}
+
+	// Uploads are refused once as many as allowed are in progress.
+	doneSecond := g.track()
+	ctx, w, ok := admit()
+	if ok {
+		t.Fatal("expected an upload to be refused at the concurrency limit")
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "2" {
+		t.Fatalf("unexpected Retry-After header: %q", retry)
+	}
+	if len(ctx.Errors) != 1 || ctx.Errors[0].(errcode.Error).Code != errcode.ErrorCodeUnavailable {
+		t.Fatalf("unexpected errors: %v", ctx.Errors)
+	}
+	doneSecond()
+	done()
+
+	// Uploads are refused while the heap is above the watermark.
+	heap = 1 << 30
+	if _, _, ok := admit(); ok {
+		t.Fatal("expected an upload to be refused above the memory watermark")
+	}
+	heap = 1 << 20
+	if _, _, ok := admit(); !ok {
+		t.Fatal("expected an upload to be admitted below the memory watermark")
+	}
+}
+
+func TestHeapSize(t *testing.T) {
+	if heapSize() == 0 {
+		t.Fatal("expected the heap size to be read")
+	}
+}
@@ -0,0 +1,92 @@
+package middleware_test
+
+import (
+	"context"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
+)
+
+// This example denies pushes of tags reserved for release pipelines, which
+// are configured as a list of tag prefixes:
+//
+//	middleware:
+//	  repository:
+//	    - name: reservedtags
+//	      options:
+//	        prefixes: [release-]
+func ExampleWithHooks_denyByPolicy() {
+	repositorymiddleware.Register("reservedtags", func(ctx context.Context, repository distribution.Repository, options map[string]interface{}) (distribution.Repository, error) {
+		var prefixes []string
+		if p, ok := options["prefixes"].([]interface{}); ok {
+			for _, prefix := range p {
+				prefixes = append(prefixes, prefix.(string))
+			}
+		}
+
+		return repositorymiddleware.WithHooks(repository, repositorymiddleware.Hooks{
+			Tag: func(ctx context.Context, repository reference.Named, tag string, desc distribution.Descriptor) error {
+				for _, prefix := range prefixes {
+					if strings.HasPrefix(tag, prefix) {
+						return errcode.ErrorCodeDenied.WithMessage("tag is reserved for release pipelines")
+					}
+				}
+				return nil
+			},
+		}), nil
+	})
+}
+
+// This example records the repository an OCI image manifest was pushed to in
+// its annotations. The manifest is only replaced when pushed by tag, as a
+// manifest pushed by digest must match it.
+func ExampleWithHooks_annotateOnPush() {
+	repositorymiddleware.Register("annotate", func(ctx context.Context, repository distribution.Repository, options map[string]interface{}) (distribution.Repository, error) {
+		return repositorymiddleware.WithHooks(repository, repositorymiddleware.Hooks{
+			PushManifest: func(ctx context.Context, repository reference.Named, tag string, manifest distribution.Manifest) (distribution.Manifest, error) {
+				m, ok := manifest.(*ocischema.DeserializedManifest)
+				if !ok || tag == "" {
+					return manifest, nil
+				}
+
+				annotated := m.Manifest
+				annotated.Annotations = map[string]string{"org.example.repository": repository.Name()}
+				for k, v := range m.Annotations {
+					annotated.Annotations[k] = v
+				}
+				return ocischema.FromStruct(annotated)
+			},
+		}), nil
+	})
+}
+
+// This example serves image indexes pulled by tag with the linux/amd64 images
+// only, for clients which do not select a platform themselves.
+func ExampleWithHooks_rewriteOnPull() {
+	repositorymiddleware.Register("amd64only", func(ctx context.Context, repository distribution.Repository, options map[string]interface{}) (distribution.Repository, error) {
+		return repositorymiddleware.WithHooks(repository, repositorymiddleware.Hooks{
+			PullManifest: func(ctx context.Context, repository reference.Named, tag string, manifest distribution.Manifest) (distribution.Manifest, error) {
+				index, ok := manifest.(*manifestlist.DeserializedManifestList)
+				if !ok || tag == "" {
+					return manifest, nil
+				}
+
+				var descriptors []manifestlist.ManifestDescriptor
+				for _, desc := range index.Manifests {
+					if desc.Platform.OS == "linux" && desc.Platform.Architecture == "amd64" {
+						descriptors = append(descriptors, desc)
+					}
+				}
+				if len(descriptors) == 0 || len(descriptors) == len(index.Manifests) {
+					return manifest, nil
+				}
+				return manifestlist.FromDescriptorsWithMediaType(descriptors, index.MediaType)
+			},
+		}), nil
+	})
+}
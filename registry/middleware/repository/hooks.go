@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// Hooks are called as the manifests and tags of a repository are pulled,
+// pushed and deleted, so that repository middlewares can rewrite content or
+// enforce policies without reimplementing the manifest and tag services. All
+// hooks are optional. An error returned by a hook fails the request; errors
+// of type errcode.Error, such as errcode.ErrorCodeDenied, are returned to the
+// client as is.
+type Hooks struct {
+	// PullManifest is called with each manifest pulled and returns the
+	// manifest served in its place. tag is the tag the manifest is pulled
+	// by, empty when it is pulled by digest. Only manifests pulled by tag
+	// may be replaced, as those pulled by digest must match it. Replacement
+	// manifests are stored in the repository, so that clients resolving the
+	// tag to a digest can then pull them by digest.
+	PullManifest func(ctx context.Context, repository reference.Named, tag string, manifest distribution.Manifest) (distribution.Manifest, error)
+
+	// PushManifest is called with each manifest pushed, before it is
+	// stored, and returns the manifest stored in its place. tag is the tag
+	// the manifest is pushed to, empty when it is pushed by digest. Only
+	// manifests pushed by tag may be replaced.
+	PushManifest func(ctx context.Context, repository reference.Named, tag string, manifest distribution.Manifest) (distribution.Manifest, error)
+
+	// DeleteManifest is called before a manifest is deleted.
+	DeleteManifest func(ctx context.Context, repository reference.Named, dgst digest.Digest) error
+
+	// Tag is called before a tag is set to point at desc.
+	Tag func(ctx context.Context, repository reference.Named, tag string, desc distribution.Descriptor) error
+
+	// Untag is called before a tag is removed.
+	Untag func(ctx context.Context, repository reference.Named, tag string) error
+}
+
+// WithHooks returns a repository calling hooks as its manifests and tags are
+// accessed. It is meant to be returned by the InitFunc of repository
+// middlewares.
+func WithHooks(repository distribution.Repository, hooks Hooks) distribution.Repository {
+	return &hookedRepository{
+		Repository: repository,
+		hooks:      hooks,
+	}
+}
+
+type hookedRepository struct {
+	distribution.Repository
+	hooks Hooks
+}
+
+func (hr *hookedRepository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	manifests, err := hr.Repository.Manifests(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hookedManifestService{
+		ManifestService: manifests,
+		repository:      hr.Named(),
+		hooks:           hr.hooks,
+	}, nil
+}
+
+func (hr *hookedRepository) Tags(ctx context.Context) distribution.TagService {
+	return &hookedTagService{
+		TagService: hr.Repository.Tags(ctx),
+		repository: hr.Named(),
+		hooks:      hr.hooks,
+	}
+}
+
+type hookedManifestService struct {
+	distribution.ManifestService
+	repository reference.Named
+	hooks      Hooks
+}
+
+// tagOf returns the tag set in options, if any.
+func tagOf(options []distribution.ManifestServiceOption) string {
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithTagOption); ok {
+			return opt.Tag
+		}
+	}
+	return ""
+}
+
+// replaced reports whether manifest was replaced by a hook.
+func replaced(original, manifest distribution.Manifest) (bool, error) {
+	_, p1, err := original.Payload()
+	if err != nil {
+		return false, err
+	}
+	_, p2, err := manifest.Payload()
+	if err != nil {
+		return false, err
+	}
+	return string(p1) != string(p2), nil
+}
+
+func (hms *hookedManifestService) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	manifest, err := hms.ManifestService.Get(ctx, dgst, options...)
+	if err != nil || hms.hooks.PullManifest == nil {
+		return manifest, err
+	}
+
+	tag := tagOf(options)
+	rewritten, err := hms.hooks.PullManifest(ctx, hms.repository, tag, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := replaced(manifest, rewritten)
+	if err != nil || !ok {
+		return manifest, err
+	}
+	if tag == "" {
+		return nil, fmt.Errorf("manifest %s replaced on a pull by digest", dgst)
+	}
+
+	_, payload, err := rewritten.Payload()
+	if err != nil {
+		return nil, err
+	}
+	replacement := dgst.Algorithm().FromBytes(payload)
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithReplacementDigestOption); ok {
+			*opt.Digest = replacement
+		}
+	}
+	exists, err := hms.ManifestService.Exists(ctx, replacement)
+	if err == nil && !exists {
+		_, err = hms.ManifestService.Put(ctx, rewritten)
+	}
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("unable to store manifest replacing %s: %v", dgst, err)
+	}
+	return rewritten, nil
+}
+
+func (hms *hookedManifestService) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	if hms.hooks.PushManifest != nil {
+		tag := tagOf(options)
+		rewritten, err := hms.hooks.PushManifest(ctx, hms.repository, tag, manifest)
+		if err != nil {
+			return "", err
+		}
+
+		ok, err := replaced(manifest, rewritten)
+		if err != nil {
+			return "", err
+		}
+		if ok && tag == "" {
+			return "", fmt.Errorf("manifest replaced on a push by digest")
+		}
+		manifest = rewritten
+	}
+
+	return hms.ManifestService.Put(ctx, manifest, options...)
+}
+
+func (hms *hookedManifestService) Delete(ctx context.Context, dgst digest.Digest) error {
+	if hms.hooks.DeleteManifest != nil {
+		if err := hms.hooks.DeleteManifest(ctx, hms.repository, dgst); err != nil {
+			return err
+		}
+	}
+
+	return hms.ManifestService.Delete(ctx, dgst)
+}
+
+type hookedTagService struct {
+	distribution.TagService
+	repository reference.Named
+	hooks      Hooks
+}
+
+func (hts *hookedTagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	if hts.hooks.Tag != nil {
+		if err := hts.hooks.Tag(ctx, hts.repository, tag, desc); err != nil {
+			return err
+		}
+	}
+
+	return hts.TagService.Tag(ctx, tag, desc)
+}
+
+func (hts *hookedTagService) Untag(ctx context.Context, tag string) error {
+	if hts.hooks.Untag != nil {
+		if err := hts.hooks.Untag(ctx, hts.repository, tag); err != nil {
+			return err
+		}
+	}
+
+	return hts.TagService.Untag(ctx, tag)
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func testManifest(t *testing.T, annotations map[string]string) *ocischema.DeserializedManifest {
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: distribution.Descriptor{
+			MediaType: v1.MediaTypeImageConfig,
+			Digest:    digest.FromString("config"),
+			Size:      6,
+		},
+		Annotations: annotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func testRepository(t *testing.T, hooks Hooks) (distribution.Repository, distribution.ManifestService) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New(), storage.EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hooked := WithHooks(repo, hooks)
+	manifests, err := hooked.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hooked, manifests
+}
+
+func TestHooksPushManifest(t *testing.T) {
+	ctx := context.Background()
+	annotated := testManifest(t, map[string]string{"pushed": "true"})
+
+	_, manifests := testRepository(t, Hooks{
+		PushManifest: func(ctx context.Context, repository reference.Named, tag string, m distribution.Manifest) (distribution.Manifest, error) {
+			return annotated, nil
+		},
+	})
+
+	if _, err := manifests.Put(ctx, testManifest(t, nil)); err == nil {
+		t.Fatal("expected replacing a manifest pushed by digest to fail")
+	}
+
+	dgst, err := manifests.Put(ctx, testManifest(t, nil), distribution.WithTag("latest"))
+	if err != nil {
+		t.Fatalf("unexpected error pushing manifest: %v", err)
+	}
+
+	_, payload, _ := annotated.Payload()
+	if dgst != digest.FromBytes(payload) {
+		t.Fatalf("expected the replacement manifest to be stored, got %s", dgst)
+	}
+}
+
+func TestHooksPullManifest(t *testing.T) {
+	ctx := context.Background()
+	original := testManifest(t, nil)
+	rewritten := testManifest(t, map[string]string{"pulled": "true"})
+
+	_, manifests := testRepository(t, Hooks{
+		PullManifest: func(ctx context.Context, repository reference.Named, tag string, m distribution.Manifest) (distribution.Manifest, error) {
+			return rewritten, nil
+		},
+	})
+
+	dgst, err := manifests.Put(ctx, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := manifests.Get(ctx, dgst); err == nil {
+		t.Fatal("expected replacing a manifest pulled by digest to fail")
+	}
+
+	var replacement digest.Digest
+	m, err := manifests.Get(ctx, dgst, distribution.WithTag("latest"), distribution.WithReplacementDigest(&replacement))
+	if err != nil {
+		t.Fatalf("unexpected error pulling manifest: %v", err)
+	}
+	if _, ok := m.(*ocischema.DeserializedManifest).Annotations["pulled"]; !ok {
+		t.Fatal("expected the replacement manifest to be served")
+	}
+
+	// The replacement can be pulled by its own digest.
+	_, payload, _ := rewritten.Payload()
+	if replacement != digest.FromBytes(payload) {
+		t.Fatalf("unexpected replacement digest: %q", replacement)
+	}
+	exists, err := manifests.Exists(ctx, digest.FromBytes(payload))
+	if err != nil || !exists {
+		t.Fatalf("expected the replacement manifest to be stored: %v", err)
+	}
+}
+
+func TestHooksDeny(t *testing.T) {
+	ctx := context.Background()
+	denied := errcode.ErrorCodeDenied.WithMessage("denied by policy")
+
+	repo, manifests := testRepository(t, Hooks{
+		DeleteManifest: func(ctx context.Context, repository reference.Named, dgst digest.Digest) error {
+			return denied
+		},
+		Tag: func(ctx context.Context, repository reference.Named, tag string, desc distribution.Descriptor) error {
+			return denied
+		},
+	})
+
+	dgst, err := manifests.Put(ctx, testManifest(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: dgst}); err != denied {
+		t.Fatalf("expected tagging to be denied, got %v", err)
+	}
+	if err := manifests.Delete(ctx, dgst); err != denied {
+		t.Fatalf("expected deleting to be denied, got %v", err)
+	}
+	if exists, _ := manifests.Exists(ctx, dgst); !exists {
+		t.Fatal("expected the manifest to be kept")
+	}
+}
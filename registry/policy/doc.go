@@ -0,0 +1,54 @@
+// Package policy implements the content policy of the registry, a list of
+// rules evaluated in order as manifests are pushed, pulled and deleted, and as
+// tags are set and deleted. The first rule a request does not satisfy denies
+// it, and warning rules answer the requests they match with a Warning header.
+//
+// Rules are expressions written in the Common Expression Language (CEL) and
+// evaluated with cel-go. They are type-checked as they are compiled, must
+// evaluate to a bool, and may use the following variables:
+//
+//	action             string  push, pull, delete or tag
+//	repository         string  the name of the repository
+//	tag                string  the tag of the request, empty when by digest
+//	digest             string  the digest of the manifest
+//	mediaType          string  the media type of the manifest
+//	artifactType       string  the artifact type of the manifest, defaulting
+//	                           to the media type of its configuration
+//	configMediaType    string  the media type of the config of the manifest
+//	config             map     the fields of the JSON config of the manifest,
+//	                           loaded when used
+//	layers             list    the media types of the layers of the manifest
+//	class              string  the class of the manifest: image, plugin, helm
+//	                           or wasm, empty when unknown or without config
+//	authorizedClasses  list    the classes of the repository granted by the
+//	                           access token, empty when it grants none
+//	annotations        map     the annotations of the manifest, as strings
+//	platforms          list    the platforms of the manifests of an index, as
+//	                           maps with os, architecture and variant keys
+//	size               int     the size of the manifest and of the content it
+//	                           references
+//	subject            string  the digest of the subject of the manifest,
+//	                           empty when it has none
+//	referrers          list    the artifact types of the referrers of the
+//	                           manifest, looked up when used
+//	urls               list    the URLs of the content the manifest
+//	                           references, as strings
+//	user               string  the name of the authenticated user, if any
+//
+// The variables describing the manifest are empty when a tag being deleted
+// points to an unknown manifest. Besides the standard functions of CEL, the
+// glob method matches strings with the patterns of path.Match:
+//
+//	repository.glob("prod/*")
+//
+// Selecting or indexing a key missing from a map is an error, which absorbing
+// && and || operands avoid:
+//
+//	"org.example.owner" in annotations && annotations["org.example.owner"] != ""
+//
+// Requests whose evaluation fails are denied.
+//
+// The allow and deny options of the configuration which predate the policy,
+// such as the URLs allowed in manifests, the repository classes, the
+// attestations and the tag pull rules, are compiled to rules by OptionRules.
+package policy
@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// declarations declares the variables available to the expressions of
+// rules, described in the package documentation.
+var declarations = []cel.EnvOption{
+	cel.Variable("action", cel.StringType),
+	cel.Variable("repository", cel.StringType),
+	cel.Variable("tag", cel.StringType),
+	cel.Variable("digest", cel.StringType),
+	cel.Variable("mediaType", cel.StringType),
+	cel.Variable("artifactType", cel.StringType),
+	cel.Variable("configMediaType", cel.StringType),
+	cel.Variable("config", cel.MapType(cel.StringType, cel.DynType)),
+	cel.Variable("layers", cel.ListType(cel.StringType)),
+	cel.Variable("class", cel.StringType),
+	cel.Variable("authorizedClasses", cel.ListType(cel.StringType)),
+	cel.Variable("annotations", cel.MapType(cel.StringType, cel.StringType)),
+	cel.Variable("platforms", cel.ListType(cel.MapType(cel.StringType, cel.StringType))),
+	cel.Variable("size", cel.IntType),
+	cel.Variable("subject", cel.StringType),
+	cel.Variable("referrers", cel.ListType(cel.StringType)),
+	cel.Variable("urls", cel.ListType(cel.StringType)),
+	cel.Variable("user", cel.StringType),
+	cel.Function("glob",
+		cel.MemberOverload("string_glob_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+			cel.BinaryBinding(glob))),
+}
+
+// glob reports whether name matches pattern, with the syntax of path.Match.
+func glob(name, pattern ref.Val) ref.Val {
+	n, ok := name.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(name)
+	}
+	p, ok := pattern.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(pattern)
+	}
+	matched, err := path.Match(string(p), string(n))
+	if err != nil {
+		return types.NewErr("invalid pattern %q", string(p))
+	}
+	return types.Bool(matched)
+}
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+)
+
+// program is a compiled rule expression, written in the Common Expression
+// Language (https://github.com/google/cel-spec).
+type program struct {
+	source  string
+	program cel.Program
+}
+
+// compile parses and type-checks an expression, which must evaluate to a
+// bool. Regular expressions given as constants are compiled along with it.
+func compile(source string) (*program, error) {
+	envOnce.Do(func() {
+		env, envErr = cel.NewEnv(declarations...)
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if t := ast.OutputType(); !cel.BoolType.IsAssignableType(t) {
+		return nil, fmt.Errorf("expression evaluates to %s, not bool", t)
+	}
+
+	prg, err := env.Program(ast, cel.EvalOptions(cel.OptOptimize))
+	if err != nil {
+		return nil, err
+	}
+	return &program{source: source, program: prg}, nil
+}
+
+// eval evaluates the program with the given variables.
+func (p *program) eval(vars map[string]interface{}) (bool, error) {
+	out, _, err := p.program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	result, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression evaluates to %s, not bool", out.Type().TypeName())
+	}
+	return result, nil
+}
@@ -0,0 +1,294 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+)
+
+// The modes of the rules on pulls by tag.
+const (
+	TagPullDeprecate = "deprecate"
+	TagPullDisable   = "disable"
+)
+
+// OptionRules returns the rules implementing the allow and deny options of
+// config which predate the rules of the content policy: the URLs and the
+// artifacts checked in the manifests pushed, the repository classes, the
+// attestations required to tag manifests, and the rules on pulls by tag. They
+// are meant to be evaluated before the rules of the policy.
+func OptionRules(config *configuration.Configuration) ([]configuration.PolicyRule, error) {
+	var rules []configuration.PolicyRule
+	if config.Validation.Enabled || !config.Validation.Disabled {
+		urls, err := urlRules(config.Validation.Manifests.URLs.Allow, config.Validation.Manifests.URLs.Deny)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, urls...)
+		rules = append(rules, artifactRules(config.Validation.Manifests.Artifacts.Helm, config.Validation.Manifests.Artifacts.Wasm)...)
+	}
+	rules = append(rules, classRules(config.Policy.Repository.Classes)...)
+
+	attestations, err := attestationRules(config.Policy.Attestations)
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, attestations...)
+
+	tagPulls, err := tagPullRules(config.Policy.TagPulls)
+	if err != nil {
+		return nil, err
+	}
+	return append(rules, tagPulls...), nil
+}
+
+// quote returns s as a CEL string literal.
+func quote(s string) string {
+	return strconv.Quote(s)
+}
+
+// quoteList returns values as a CEL list literal.
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = quote(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// globAny returns an expression true when variable matches one of patterns,
+// with the syntax of path.Match.
+func globAny(variable string, patterns []string) (string, error) {
+	matches := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", fmt.Errorf("invalid pattern %q", pattern)
+		}
+		matches[i] = fmt.Sprintf("%s.glob(%s)", variable, quote(pattern))
+	}
+	return "(" + strings.Join(matches, " || ") + ")", nil
+}
+
+// urlRules returns the rule denying the manifests pushed with URLs allow does
+// not match or deny matches, or with any URL when neither is set.
+func urlRules(allow, deny []string) ([]configuration.PolicyRule, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		// If allow and deny are empty, allow nothing.
+		return []configuration.PolicyRule{{
+			Name:    "validation.manifests.urls",
+			Actions: []string{ActionPush},
+			Deny:    "size(urls) > 0",
+			Message: "manifests may not reference URLs",
+		}}, nil
+	}
+
+	var conditions []string
+	for _, option := range []struct {
+		name      string
+		patterns  []string
+		condition string
+	}{
+		{"allow", allow, "!u.matches(%s)"},
+		{"deny", deny, "u.matches(%s)"},
+	} {
+		if len(option.patterns) == 0 {
+			continue
+		}
+		groups := make([]string, len(option.patterns))
+		for i, s := range option.patterns {
+			// Validate via compilation.
+			if _, err := regexp.Compile(s); err != nil {
+				return nil, fmt.Errorf("validation.manifests.urls.%s: %s", option.name, err)
+			}
+			// Wrap with non-capturing group.
+			groups[i] = fmt.Sprintf("(?:%s)", s)
+		}
+		conditions = append(conditions, fmt.Sprintf(option.condition, quote(strings.Join(groups, "|"))))
+	}
+
+	return []configuration.PolicyRule{{
+		Name:    "validation.manifests.urls",
+		Actions: []string{ActionPush},
+		Deny:    fmt.Sprintf("urls.exists(u, %s)", strings.Join(conditions, " || ")),
+		Message: "manifest references URLs which are not allowed",
+	}}, nil
+}
+
+// artifactRules returns the rules checking the structure of the OCI manifests
+// of Helm charts and WASM modules, identified by the media type of their
+// config, when enabled.
+func artifactRules(helm, wasm bool) []configuration.PolicyRule {
+	var rules []configuration.PolicyRule
+	if helm {
+		isHelm := fmt.Sprintf("configMediaType == %s", quote(ocischema.MediaTypeHelmConfig))
+		chart, provenance := quote(ocischema.MediaTypeHelmChartContent), quote(ocischema.MediaTypeHelmChartProvenance)
+		rules = append(rules,
+			configuration.PolicyRule{
+				Name:    "validation.manifests.artifacts.helm",
+				Actions: []string{ActionPush},
+				Deny: fmt.Sprintf("%s && (layers.exists(l, l != %s && l != %s) || size(layers.filter(l, l == %s)) != 1 || size(layers.filter(l, l == %s)) > 1)",
+					isHelm, chart, provenance, chart, provenance),
+				Message: "Helm chart manifests must reference a chart layer, at most one provenance layer and no other layers",
+			},
+			configuration.PolicyRule{
+				Name:    "validation.manifests.artifacts.helm",
+				Actions: []string{ActionPush},
+				Deny: isHelm + ` && !(has(config.name) && type(config.name) == string && config.name != "" &&` +
+					` has(config.version) && type(config.version) == string && config.version != "")`,
+				Message: "the config of Helm chart manifests must hold the name and version of the chart",
+			})
+	}
+	if wasm {
+		rules = append(rules, configuration.PolicyRule{
+			Name:    "validation.manifests.artifacts.wasm",
+			Actions: []string{ActionPush},
+			Deny: fmt.Sprintf("configMediaType == %s && (size(layers) == 0 || layers.exists(l, l != %s))",
+				quote(ocischema.MediaTypeWasmConfig), quote(ocischema.MediaTypeWasmContentLayer)),
+			Message: "WASM module manifests must reference WASM content layers only",
+		})
+	}
+	return rules
+}
+
+// classRules returns the rules denying the manifests pushed of classes
+// missing from classes, or from those of the access granted to the
+// repository, when classes is set.
+func classRules(classes []string) []configuration.PolicyRule {
+	if len(classes) == 0 {
+		return nil
+	}
+	return []configuration.PolicyRule{
+		{
+			Name:    "policy.repository.classes",
+			Actions: []string{ActionPush},
+			Deny:    `configMediaType != "" && class == ""`,
+			Message: "unknown manifest class",
+		},
+		{
+			Name:    "policy.repository.classes",
+			Actions: []string{ActionPush},
+			Deny:    fmt.Sprintf(`class != "" && !(class in %s)`, quoteList(classes)),
+			Message: fmt.Sprintf("registry only allows %s manifests", strings.Join(classes, ", ")),
+		},
+		{
+			Name:    "policy.repository.classes",
+			Actions: []string{ActionPush},
+			Deny:    `class != "" && size(authorizedClasses) > 0 && !(class in authorizedClasses)`,
+			Message: "repository not authorized for the class of the manifest",
+		},
+	}
+}
+
+// attestationRules returns the rules denying to tag manifests missing the
+// referrers required by requirements.
+func attestationRules(requirements []configuration.AttestationRequirement) ([]configuration.PolicyRule, error) {
+	var rules []configuration.PolicyRule
+	for i, r := range requirements {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		if len(r.Tags) == 0 {
+			return nil, fmt.Errorf("attestation requirement %s: no tags", name)
+		}
+		if len(r.ArtifactTypes) == 0 {
+			return nil, fmt.Errorf("attestation requirement %s: no artifact types", name)
+		}
+		conditions := []string{`tag != ""`}
+		for _, option := range []struct {
+			variable string
+			patterns []string
+		}{
+			{"repository", r.Repositories},
+			{"tag", r.Tags},
+		} {
+			if len(option.patterns) == 0 {
+				continue
+			}
+			condition, err := globAny(option.variable, option.patterns)
+			if err != nil {
+				return nil, fmt.Errorf("attestation requirement %s: %v", name, err)
+			}
+			conditions = append(conditions, condition)
+		}
+		conditions = append(conditions, fmt.Sprintf("!%s.all(t, t in referrers)", quoteList(r.ArtifactTypes)))
+
+		rules = append(rules, configuration.PolicyRule{
+			Name:    "policy.attestations." + name,
+			Actions: []string{ActionTag},
+			Deny:    strings.Join(conditions, " && "),
+			Message: fmt.Sprintf("tags matching %s require referrers of artifact types %s", strings.Join(r.Tags, ", "), strings.Join(r.ArtifactTypes, ", ")),
+		})
+	}
+	return rules, nil
+}
+
+// tagPullRules returns the rules deprecating or disabling the pulls by tag
+// matched by rules. Only the first rule matching a pull applies, so each
+// excludes the pulls the earlier ones match.
+func tagPullRules(rules []configuration.TagPullRule) ([]configuration.PolicyRule, error) {
+	var policyRules []configuration.PolicyRule
+	var earlier []string
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		if len(r.MediaTypes) == 0 {
+			return nil, fmt.Errorf("tag pull rule %s: no media types", name)
+		}
+		mode := r.Mode
+		switch mode {
+		case "":
+			mode = TagPullDisable
+		case TagPullDeprecate, TagPullDisable:
+		default:
+			return nil, fmt.Errorf("tag pull rule %s: unknown mode %q", name, r.Mode)
+		}
+
+		conditions := []string{`tag != ""`}
+		if len(r.Repositories) > 0 {
+			condition, err := globAny("repository", r.Repositories)
+			if err != nil {
+				return nil, fmt.Errorf("tag pull rule %s: %v", name, err)
+			}
+			conditions = append(conditions, condition)
+		}
+		mediaTypes := quoteList(r.MediaTypes)
+		conditions = append(conditions, fmt.Sprintf("(mediaType in %s || artifactType in %s)", mediaTypes, mediaTypes))
+		if len(r.Referrers) > 0 {
+			conditions = append(conditions, fmt.Sprintf("!referrers.exists(t, t in %s)", quoteList(r.Referrers)))
+		}
+		matches := "(" + strings.Join(conditions, " && ") + ")"
+
+		expression := matches
+		for _, e := range earlier {
+			expression += " && !" + e
+		}
+		earlier = append(earlier, matches)
+
+		message := r.Message
+		if message == "" {
+			message = fmt.Sprintf("pulling %s manifests by tag is %sd by tag pull rule %s, pull them by digest", strings.Join(r.MediaTypes, ", "), mode, name)
+		}
+		rule := configuration.PolicyRule{
+			Name:    "policy.tagpulls." + name,
+			Actions: []string{ActionPull},
+			Message: message,
+		}
+		if mode == TagPullDeprecate {
+			rule.Warn = expression
+		} else {
+			rule.Deny = expression
+		}
+		policyRules = append(policyRules, rule)
+	}
+	return policyRules, nil
+}
@@ -0,0 +1,369 @@
+package policy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	artifactType  = "application/vnd.example.artifact.v1+json"
+	signatureType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	sbomType      = "application/spdx+json"
+)
+
+// optionPolicy returns the policy of the options of config.
+func optionPolicy(t *testing.T, config *configuration.Configuration, referrers ReferrersFunc) *Policy {
+	rules, err := OptionRules(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(rules, referrers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// testRepository returns the repository name of the registry of d, with the
+// policy p evaluated, and its manifests.
+func testRepository(t *testing.T, d *inmemory.Driver, p *Policy, name string) (distribution.Repository, distribution.ManifestService) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, _ := reference.WithName(name)
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo = p.Repository(repo)
+	manifests, err := repo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, manifests
+}
+
+func putManifest(t *testing.T, manifests distribution.ManifestService, m ocischema.Manifest) (digest.Digest, error) {
+	m.Versioned = manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest}
+	dm, err := ocischema.FromStruct(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifests.Put(context.Background(), dm)
+}
+
+func denied(err error, message string) bool {
+	e, ok := err.(errcode.Error)
+	return ok && e.Code == errcode.ErrorCodeDenied && strings.Contains(e.Message, message)
+}
+
+func TestOptionRulesErrors(t *testing.T) {
+	for name, config := range map[string]func(*configuration.Configuration){
+		"urls allow": func(c *configuration.Configuration) { c.Validation.Manifests.URLs.Allow = []string{"("} },
+		"urls deny":  func(c *configuration.Configuration) { c.Validation.Manifests.URLs.Deny = []string{"("} },
+		"attestation tags": func(c *configuration.Configuration) {
+			c.Policy.Attestations = []configuration.AttestationRequirement{{ArtifactTypes: []string{signatureType}}}
+		},
+		"attestation types": func(c *configuration.Configuration) {
+			c.Policy.Attestations = []configuration.AttestationRequirement{{Tags: []string{"prod-*"}}}
+		},
+		"attestation pattern": func(c *configuration.Configuration) {
+			c.Policy.Attestations = []configuration.AttestationRequirement{{Tags: []string{"prod-["}, ArtifactTypes: []string{signatureType}}}
+		},
+		"attestation repository": func(c *configuration.Configuration) {
+			c.Policy.Attestations = []configuration.AttestationRequirement{{Repositories: []string{"["}, Tags: []string{"prod-*"}, ArtifactTypes: []string{signatureType}}}
+		},
+		"tag pull types": func(c *configuration.Configuration) {
+			c.Policy.TagPulls = []configuration.TagPullRule{{Name: "types"}}
+		},
+		"tag pull mode": func(c *configuration.Configuration) {
+			c.Policy.TagPulls = []configuration.TagPullRule{{MediaTypes: []string{artifactType}, Mode: "warn"}}
+		},
+		"tag pull repository": func(c *configuration.Configuration) {
+			c.Policy.TagPulls = []configuration.TagPullRule{{Repositories: []string{"["}, MediaTypes: []string{artifactType}}}
+		},
+	} {
+		var c configuration.Configuration
+		config(&c)
+		if _, err := OptionRules(&c); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// The URLs are only checked with the validation enabled.
+	var c configuration.Configuration
+	c.Validation.Disabled = true
+	if rules, err := OptionRules(&c); err != nil || len(rules) != 0 {
+		t.Errorf("expected no rules with the validation disabled, got %v, %v", rules, err)
+	}
+}
+
+func TestURLRules(t *testing.T) {
+	ctx := context.Background()
+	prod, _ := reference.WithName("prod/app")
+	for _, tc := range []struct {
+		allow, deny []string
+		urls        []string
+		denied      bool
+	}{
+		{urls: nil},
+		{urls: []string{"https://example.com/layer"}, denied: true},
+		{allow: []string{`^https?://([^/]+\.)*example\.com/`}, urls: []string{"https://cdn.example.com/layer"}},
+		{allow: []string{`^https?://([^/]+\.)*example\.com/`}, urls: []string{"https://cdn.example.com/layer", "https://example.org/layer"}, denied: true},
+		{allow: []string{`^https?://([^/]+\.)*example\.com/`}, deny: []string{`^https?://www\.example\.com/`}, urls: []string{"https://www.example.com/layer"}, denied: true},
+		{deny: []string{`"quoted"`, `\.org/`}, urls: []string{"https://example.org/layer"}, denied: true},
+		{deny: []string{`"quoted"`, `\.org/`}, urls: []string{"https://example.com/layer"}},
+	} {
+		var config configuration.Configuration
+		config.Validation.Manifests.URLs.Allow = tc.allow
+		config.Validation.Manifests.URLs.Deny = tc.deny
+		p := optionPolicy(t, &config, nil)
+
+		_, err := p.Evaluate(ctx, Request{Action: ActionPush, Repository: prod, Manifest: testManifest(t, nil, tc.urls...)})
+		if tc.denied != (err != nil) {
+			t.Errorf("allow %v, deny %v: unexpected result for %v: %v", tc.allow, tc.deny, tc.urls, err)
+		}
+	}
+}
+
+func TestArtifactRules(t *testing.T) {
+	ctx := context.Background()
+	var config configuration.Configuration
+	config.Validation.Manifests.Artifacts.Helm = true
+	config.Validation.Manifests.Artifacts.Wasm = true
+	repo, manifests := testRepository(t, inmemory.New(), optionPolicy(t, &config, nil), "charts/app")
+
+	put := func(mediaType, content string) distribution.Descriptor {
+		desc, err := repo.Blobs(ctx).Put(ctx, mediaType, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc.MediaType = mediaType
+		return desc
+	}
+	chartConfig := put(ocischema.MediaTypeHelmConfig, `{"name": "app", "version": "1.0.0", "apiVersion": "v2"}`)
+	invalidChartConfig := put(ocischema.MediaTypeHelmConfig, `{"name": "app", "version": 1}`)
+	chart := put(ocischema.MediaTypeHelmChartContent, "chart")
+	provenance := put(ocischema.MediaTypeHelmChartProvenance, "provenance")
+	wasmConfig := put(ocischema.MediaTypeWasmConfig, `{}`)
+	module := put(ocischema.MediaTypeWasmContentLayer, "module")
+	image := put(v1.MediaTypeImageLayerGzip, "layer")
+
+	for _, tc := range []struct {
+		config  distribution.Descriptor
+		layers  []distribution.Descriptor
+		invalid string
+	}{
+		{config: chartConfig, layers: []distribution.Descriptor{chart}},
+		{config: chartConfig, layers: []distribution.Descriptor{chart, provenance}},
+		{config: chartConfig, layers: []distribution.Descriptor{provenance}, invalid: "chart layer"},
+		{config: chartConfig, layers: []distribution.Descriptor{chart, chart}, invalid: "chart layer"},
+		{config: chartConfig, layers: []distribution.Descriptor{chart, provenance, provenance}, invalid: "chart layer"},
+		{config: chartConfig, layers: []distribution.Descriptor{chart, image}, invalid: "chart layer"},
+		{config: invalidChartConfig, layers: []distribution.Descriptor{chart}, invalid: "name and version"},
+		{config: wasmConfig, layers: []distribution.Descriptor{module}},
+		{config: wasmConfig, layers: []distribution.Descriptor{}, invalid: "WASM"},
+		{config: wasmConfig, layers: []distribution.Descriptor{module, image}, invalid: "WASM"},
+		// Other artifacts are not checked.
+		{config: put(artifactType, "{}"), layers: []distribution.Descriptor{chart, image}},
+	} {
+		_, err := putManifest(t, manifests, ocischema.Manifest{Config: tc.config, Layers: tc.layers})
+		if tc.invalid == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error: %v", tc.layers, err)
+			}
+		} else if !denied(err, tc.invalid) {
+			t.Errorf("%v: expected a denial mentioning %q, got %v", tc.layers, tc.invalid, err)
+		}
+	}
+}
+
+func TestClassRules(t *testing.T) {
+	var config configuration.Configuration
+	config.Policy.Repository.Classes = []string{"image", "helm"}
+	_, manifests := testRepository(t, inmemory.New(), optionPolicy(t, &config, nil), "foo/bar")
+
+	withConfig := func(mediaType string) ocischema.Manifest {
+		return ocischema.Manifest{
+			Config: distribution.Descriptor{MediaType: mediaType, Digest: digest.FromString(mediaType), Size: int64(len(mediaType))},
+		}
+	}
+
+	if _, err := putManifest(t, manifests, withConfig(v1.MediaTypeImageConfig)); err != nil {
+		t.Fatalf("unexpected error pushing an image: %v", err)
+	}
+	if _, err := putManifest(t, manifests, withConfig(ocischema.MediaTypeWasmConfig)); !denied(err, "registry only allows image, helm manifests") {
+		t.Fatalf("expected pushing a WASM module to be denied, got %v", err)
+	}
+	if _, err := putManifest(t, manifests, withConfig(artifactType)); !denied(err, "unknown manifest class") {
+		t.Fatalf("expected pushing a manifest of an unknown class to be denied, got %v", err)
+	}
+
+	// The classes granted by the access token restrict those of the
+	// registry.
+	ctx := auth.WithResources(context.Background(), []auth.Resource{{Type: "repository", Name: "foo/bar", Class: "helm"}})
+	m := withConfig(v1.MediaTypeImageConfig)
+	m.Versioned = manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest}
+	dm, err := ocischema.FromStruct(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manifests.Put(ctx, dm); !denied(err, "repository not authorized") {
+		t.Fatalf("expected pushing an image to a repository granted for charts to be denied, got %v", err)
+	}
+	ctx = auth.WithResources(context.Background(), []auth.Resource{{Type: "repository", Name: "foo/bar"}})
+	if _, err := manifests.Put(ctx, dm); err != nil {
+		t.Fatalf("unexpected error pushing an image to a repository granted for images: %v", err)
+	}
+}
+
+func TestAttestations(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	var config configuration.Configuration
+	config.Policy.Attestations = []configuration.AttestationRequirement{
+		{
+			Name:          "production",
+			Repositories:  []string{"prod/*"},
+			Tags:          []string{"prod-*", "release"},
+			ArtifactTypes: []string{signatureType, sbomType},
+		},
+	}
+	p := optionPolicy(t, &config, func(ctx context.Context, repository reference.Named, subject digest.Digest) ([]digest.Digest, error) {
+		return storage.Referrers(ctx, d, repository.Name(), subject)
+	})
+
+	put := func(manifests distribution.ManifestService, m ocischema.Manifest) digest.Digest {
+		dgst, err := putManifest(t, manifests, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dgst
+	}
+	attach := func(manifests distribution.ManifestService, subject digest.Digest, artifactType string) {
+		put(manifests, ocischema.Manifest{
+			Config:  distribution.Descriptor{MediaType: artifactType, Digest: digest.FromString(artifactType), Size: int64(len(artifactType))},
+			Subject: &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject},
+		})
+	}
+	image := ocischema.Manifest{
+		Config: distribution.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromString("config"), Size: 6},
+	}
+	const message = "require referrers of artifact types " + signatureType + ", " + sbomType
+
+	repo, manifests := testRepository(t, d, p, "prod/app")
+	dgst := put(manifests, image)
+	tags := repo.Tags(ctx)
+
+	if err := tags.Tag(ctx, "dev", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatalf("unexpected error tagging an unprotected tag: %v", err)
+	}
+	if err := tags.Tag(ctx, "prod-1", distribution.Descriptor{Digest: dgst}); !denied(err, message) {
+		t.Fatalf("expected tagging an unattested manifest to be denied, got %v", err)
+	}
+	attach(manifests, dgst, signatureType)
+	if err := tags.Tag(ctx, "release", distribution.Descriptor{Digest: dgst}); !denied(err, message) {
+		t.Fatalf("expected tagging a manifest without an SBOM to be denied, got %v", err)
+	}
+	attach(manifests, dgst, sbomType)
+	if err := tags.Tag(ctx, "prod-1", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatalf("unexpected error tagging an attested manifest: %v", err)
+	}
+
+	// Other repositories are not protected.
+	repo, manifests = testRepository(t, d, p, "dev/app")
+	dgst = put(manifests, image)
+	if err := repo.Tags(ctx).Tag(ctx, "prod-1", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatalf("unexpected error tagging in an unprotected repository: %v", err)
+	}
+}
+
+func TestTagPulls(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	var config configuration.Configuration
+	config.Policy.TagPulls = []configuration.TagPullRule{
+		{
+			Name:         "unsigned",
+			Repositories: []string{"prod/*"},
+			MediaTypes:   []string{artifactType},
+			Referrers:    []string{signatureType},
+			Message:      "unsigned artifacts must be pulled by digest",
+		},
+		{
+			Name:       "artifacts",
+			MediaTypes: []string{artifactType},
+			Mode:       TagPullDeprecate,
+		},
+		{
+			Name:       "images",
+			MediaTypes: []string{v1.MediaTypeImageConfig},
+			Mode:       TagPullDeprecate,
+		},
+	}
+	p := optionPolicy(t, &config, func(ctx context.Context, repository reference.Named, subject digest.Digest) ([]digest.Digest, error) {
+		return storage.Referrers(ctx, d, repository.Name(), subject)
+	})
+	_, manifests := testRepository(t, d, p, "prod/app")
+
+	put := func(m ocischema.Manifest) digest.Digest {
+		dgst, err := putManifest(t, manifests, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dgst
+	}
+	recorder := httptest.NewRecorder()
+	ctx, _ = dcontext.WithResponseWriter(ctx, recorder)
+	pull := func(dgst digest.Digest, tag string) (string, error) {
+		recorder.HeaderMap = http.Header{}
+		_, err := manifests.Get(ctx, dgst, distribution.WithTag(tag))
+		return recorder.Header().Get("Warning"), err
+	}
+
+	artifact := put(ocischema.Manifest{
+		Config: distribution.Descriptor{MediaType: artifactType, Digest: digest.FromString("artifact"), Size: 8},
+	})
+	if _, err := pull(artifact, "latest"); !denied(err, "unsigned artifacts must be pulled by digest") {
+		t.Fatalf("expected the pull of an unsigned artifact by tag to be denied, got %v", err)
+	}
+	if warning, err := pull(artifact, ""); err != nil || warning != "" {
+		t.Fatalf("unexpected result pulling an unsigned artifact by digest: %q, %v", warning, err)
+	}
+
+	// A signed artifact is exempt from the first rule, and is deprecated by
+	// the second only, as the first rule matching a pull applies.
+	put(ocischema.Manifest{
+		Config:  distribution.Descriptor{MediaType: signatureType, Digest: digest.FromString(signatureType), Size: int64(len(signatureType))},
+		Subject: &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: artifact},
+	})
+	warning, err := pull(artifact, "latest")
+	if err != nil || !strings.Contains(warning, "tag pull rule artifacts") {
+		t.Fatalf("expected the pull of a signed artifact by tag to be deprecated, got %q, %v", warning, err)
+	}
+
+	image := put(ocischema.Manifest{
+		Config: distribution.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromString("config"), Size: 6},
+	})
+	if warning, err := pull(image, "latest"); err != nil || !strings.HasPrefix(warning, "299 - ") || !strings.Contains(warning, "tag pull rule images") {
+		t.Fatalf("expected the pull of an image by tag to be deprecated, got %q, %v", warning, err)
+	}
+}
@@ -0,0 +1,493 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema1"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The actions rules apply to.
+const (
+	ActionPush   = "push"
+	ActionPull   = "pull"
+	ActionDelete = "delete"
+	ActionTag    = "tag"
+)
+
+// ReferrersFunc returns the digests of the referrers of the manifest subject
+// of a repository.
+type ReferrersFunc func(ctx context.Context, repository reference.Named, subject digest.Digest) ([]digest.Digest, error)
+
+type rule struct {
+	name    string
+	actions []string
+	allow   bool
+	warn    bool
+	program *program
+	message string
+}
+
+func (r *rule) appliesTo(action string) bool {
+	if len(r.actions) == 0 {
+		return true
+	}
+	for _, a := range r.actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is a compiled content policy.
+type Policy struct {
+	rules     []rule
+	referrers ReferrersFunc
+}
+
+// New compiles the rules of a content policy, whose referrers variable is
+// looked up with referrers.
+func New(rules []configuration.PolicyRule, referrers ReferrersFunc) (*Policy, error) {
+	p := &Policy{referrers: referrers}
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		var sources []string
+		for _, source := range []string{r.Deny, r.Allow, r.Warn} {
+			if source != "" {
+				sources = append(sources, source)
+			}
+		}
+		if len(sources) != 1 {
+			return nil, fmt.Errorf("policy rule %s: exactly one of deny, allow and warn must be set", name)
+		}
+		for _, action := range r.Actions {
+			switch action {
+			case ActionPush, ActionPull, ActionDelete, ActionTag:
+			default:
+				return nil, fmt.Errorf("policy rule %s: unknown action %q", name, action)
+			}
+		}
+
+		prog, err := compile(sources[0])
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %s: %v", name, err)
+		}
+
+		p.rules = append(p.rules, rule{
+			name:    name,
+			actions: r.Actions,
+			allow:   r.Allow != "",
+			warn:    r.Warn != "",
+			program: prog,
+			message: r.Message,
+		})
+	}
+	return p, nil
+}
+
+// appliesTo reports whether a rule of the policy applies to action.
+func (p *Policy) appliesTo(action string) bool {
+	for i := range p.rules {
+		if p.rules[i].appliesTo(action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Request is an operation on a manifest evaluated against the policy.
+type Request struct {
+	Action     string
+	Repository reference.Named
+
+	// Tag is the tag of the request, empty when it is made by digest.
+	Tag string
+
+	// Digest is the digest of the manifest, computed from its payload when
+	// empty.
+	Digest digest.Digest
+
+	// Manifest is the manifest pushed, pulled or deleted. It may be nil when
+	// the deleted manifest is unknown.
+	Manifest distribution.Manifest
+
+	// User is the name of the authenticated user, if any.
+	User string
+
+	// Blobs provides the config of the manifest to the rules using the
+	// config variable. The config is empty when it is nil.
+	Blobs distribution.BlobProvider
+
+	// Referrers returns the artifact types of the referrers of the manifest
+	// dgst to the rules using the referrers variable. There are none when
+	// it is nil.
+	Referrers func(ctx context.Context, dgst digest.Digest) ([]string, error)
+}
+
+// Evaluate evaluates the rules applying to the action of a request in order,
+// and returns an errcode.ErrorCodeDenied error for the first rule the request
+// does not satisfy. It returns the messages of the warning rules matching the
+// request evaluated before.
+func (p *Policy) Evaluate(ctx context.Context, req Request) ([]string, error) {
+	var vars map[string]interface{}
+	var warnings []string
+	for i := range p.rules {
+		r := &p.rules[i]
+		if !r.appliesTo(req.Action) {
+			continue
+		}
+
+		if vars == nil {
+			var err error
+			if vars, err = requestVariables(ctx, req); err != nil {
+				return warnings, err
+			}
+		}
+
+		result, err := r.program.eval(vars)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("error evaluating policy rule %s: %v", r.name, err)
+			return warnings, errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf("policy rule %s could not be evaluated", r.name))
+		}
+
+		switch {
+		case r.warn:
+			if !result {
+				continue
+			}
+			dcontext.GetLogger(ctx).Infof("%s of %s warned by policy rule %s", req.Action, reference.FamiliarString(req.Repository), r.name)
+
+			message := r.message
+			if message == "" {
+				message = fmt.Sprintf("warned by policy rule %s", r.name)
+			}
+			warnings = append(warnings, message)
+
+		case result != r.allow:
+			dcontext.GetLogger(ctx).Infof("%s of %s denied by policy rule %s", req.Action, reference.FamiliarString(req.Repository), r.name)
+
+			message := r.message
+			if message == "" {
+				message = fmt.Sprintf("denied by policy rule %s", r.name)
+			}
+			return warnings, errcode.ErrorCodeDenied.WithMessage(message)
+		}
+	}
+	return warnings, nil
+}
+
+// manifestFields are the fields of manifest payloads exposed to rules, shared
+// by the schema2 and OCI formats. Other formats leave them empty.
+type manifestFields struct {
+	ArtifactType string                    `json:"artifactType"`
+	Config       *distribution.Descriptor  `json:"config"`
+	Layers       []distribution.Descriptor `json:"layers"`
+	Annotations  map[string]string         `json:"annotations"`
+	Subject      *distribution.Descriptor  `json:"subject"`
+	Manifests    []struct {
+		Platform *v1.Platform `json:"platform"`
+	} `json:"manifests"`
+}
+
+// The classes of the manifests of the configs of these media types.
+var configClasses = map[string]string{
+	schema2.MediaTypeImageConfig:  "image",
+	v1.MediaTypeImageConfig:       "image",
+	schema2.MediaTypePluginConfig: "plugin",
+	ocischema.MediaTypeHelmConfig: "helm",
+	ocischema.MediaTypeWasmConfig: "wasm",
+}
+
+func requestVariables(ctx context.Context, req Request) (map[string]interface{}, error) {
+	vars := map[string]interface{}{
+		"action":            req.Action,
+		"repository":        req.Repository.Name(),
+		"tag":               req.Tag,
+		"digest":            req.Digest.String(),
+		"mediaType":         "",
+		"artifactType":      "",
+		"configMediaType":   "",
+		"config":            map[string]interface{}{},
+		"layers":            []string{},
+		"class":             "",
+		"authorizedClasses": authorizedClasses(ctx, req.Repository),
+		"annotations":       map[string]string{},
+		"platforms":         []map[string]string{},
+		"size":              int64(0),
+		"subject":           "",
+		"referrers":         []string{},
+		"urls":              []string{},
+		"user":              req.User,
+	}
+	if req.Manifest == nil {
+		return vars, nil
+	}
+
+	mediaType, payload, err := req.Manifest.Payload()
+	if err != nil {
+		return nil, err
+	}
+	var fields manifestFields
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+
+	dgst := req.Digest
+	if dgst == "" {
+		dgst = digest.FromBytes(payload)
+		vars["digest"] = dgst.String()
+	}
+	vars["mediaType"] = mediaType
+
+	artifactType := fields.ArtifactType
+	if artifactType == "" && fields.Config != nil {
+		artifactType = fields.Config.MediaType
+	}
+	vars["artifactType"] = artifactType
+
+	switch {
+	case mediaType == schema1.MediaTypeSignedManifest || mediaType == schema1.MediaTypeManifest:
+		vars["class"] = "image"
+	case fields.Config != nil:
+		vars["configMediaType"] = fields.Config.MediaType
+		vars["class"] = configClasses[fields.Config.MediaType]
+		if req.Blobs != nil {
+			config := *fields.Config
+			vars["config"] = func() ref.Val {
+				return types.DefaultTypeAdapter.NativeToValue(loadConfig(ctx, req.Blobs, config))
+			}
+		}
+	}
+
+	layers := make([]string, 0, len(fields.Layers))
+	for _, layer := range fields.Layers {
+		layers = append(layers, layer.MediaType)
+	}
+	vars["layers"] = layers
+
+	if req.Referrers != nil {
+		vars["referrers"] = func() ref.Val {
+			referrers, err := req.Referrers(ctx, dgst)
+			if err != nil {
+				return types.NewErr("looking up the referrers of %s: %v", dgst, err)
+			}
+			return types.DefaultTypeAdapter.NativeToValue(referrers)
+		}
+	}
+
+	if fields.Annotations != nil {
+		vars["annotations"] = fields.Annotations
+	}
+
+	platforms := []map[string]string{}
+	for _, m := range fields.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		platforms = append(platforms, map[string]string{
+			"os":           m.Platform.OS,
+			"architecture": m.Platform.Architecture,
+			"variant":      m.Platform.Variant,
+		})
+	}
+	vars["platforms"] = platforms
+
+	if fields.Subject != nil {
+		vars["subject"] = fields.Subject.Digest.String()
+	}
+
+	size := int64(len(payload))
+	urls := []string{}
+	for _, desc := range req.Manifest.References() {
+		size += desc.Size
+		urls = append(urls, desc.URLs...)
+	}
+	vars["size"] = size
+	vars["urls"] = urls
+
+	return vars, nil
+}
+
+// authorizedClasses returns the classes of the resources of repository the
+// request of ctx is authorized for, defaulting to image.
+func authorizedClasses(ctx context.Context, repository reference.Named) []string {
+	classes := []string{}
+	for _, r := range auth.AuthorizedResources(ctx) {
+		if r.Name != repository.Name() {
+			continue
+		}
+		if r.Class == "" {
+			classes = append(classes, "image")
+		} else {
+			classes = append(classes, r.Class)
+		}
+	}
+	return classes
+}
+
+// loadConfig returns the fields of the JSON config of a manifest, none when
+// it is unknown or not a JSON object.
+func loadConfig(ctx context.Context, blobs distribution.BlobProvider, desc distribution.Descriptor) map[string]interface{} {
+	config := map[string]interface{}{}
+	content, err := blobs.Get(ctx, desc.Digest)
+	if err != nil {
+		dcontext.GetLogger(ctx).Debugf("unable to load the config %s: %v", desc.Digest, err)
+		return config
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return map[string]interface{}{}
+	}
+	return config
+}
+
+// referrerArtifactTypes returns the artifact types of the referrers of the
+// manifest dgst, in order.
+func referrerArtifactTypes(ctx context.Context, referrersFunc ReferrersFunc, repository distribution.Repository, dgst digest.Digest) ([]string, error) {
+	artifactTypes := []string{}
+	if referrersFunc == nil {
+		return artifactTypes, nil
+	}
+	referrers, err := referrersFunc(ctx, repository.Named(), dgst)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, referrer := range referrers {
+		manifest, err := manifests.Get(ctx, referrer)
+		if err != nil {
+			// Referrers deleted since they were indexed attest nothing.
+			dcontext.GetLogger(ctx).Debugf("skipping referrer %s of %s: %v", referrer, dgst, err)
+			continue
+		}
+		_, payload, err := manifest.Payload()
+		if err != nil {
+			return nil, err
+		}
+		var fields manifestFields
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			continue
+		}
+
+		artifactType := fields.ArtifactType
+		if artifactType == "" && fields.Config != nil {
+			artifactType = fields.Config.MediaType
+		}
+		if !seen[artifactType] {
+			seen[artifactType] = true
+			artifactTypes = append(artifactTypes, artifactType)
+		}
+	}
+	sort.Strings(artifactTypes)
+	return artifactTypes, nil
+}
+
+// Repository returns the repository with the policy evaluated as its
+// manifests are pushed, pulled and deleted, and as its tags are set and
+// deleted. The warnings of the policy are added to the response of the
+// request, as Warning headers.
+func (p *Policy) Repository(repository distribution.Repository) distribution.Repository {
+	evaluate := func(ctx context.Context, action, tag string, dgst digest.Digest, manifest distribution.Manifest) error {
+		warnings, err := p.Evaluate(ctx, Request{
+			Action:     action,
+			Repository: repository.Named(),
+			Tag:        tag,
+			Digest:     dgst,
+			Manifest:   manifest,
+			User:       dcontext.GetUserName(ctx),
+			Blobs:      repository.Blobs(ctx),
+			Referrers: func(ctx context.Context, dgst digest.Digest) ([]string, error) {
+				return referrerArtifactTypes(ctx, p.referrers, repository, dgst)
+			},
+		})
+		if w, werr := dcontext.GetResponseWriter(ctx); werr == nil {
+			for _, warning := range warnings {
+				w.Header().Add("Warning", fmt.Sprintf("299 - %q", warning))
+			}
+		}
+		return err
+	}
+
+	// lookup returns the manifest being deleted, or nil when it is unknown.
+	lookup := func(ctx context.Context, dgst digest.Digest) (distribution.Manifest, error) {
+		manifests, err := repository.Manifests(ctx)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := manifests.Get(ctx, dgst)
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			return nil, nil
+		}
+		return manifest, err
+	}
+
+	// Only the actions rules apply to are hooked, sparing the others the
+	// lookups of the manifests.
+	var hooks repositorymiddleware.Hooks
+	if p.appliesTo(ActionPull) {
+		hooks.PullManifest = func(ctx context.Context, _ reference.Named, tag string, manifest distribution.Manifest) (distribution.Manifest, error) {
+			return manifest, evaluate(ctx, ActionPull, tag, "", manifest)
+		}
+	}
+	if p.appliesTo(ActionPush) {
+		hooks.PushManifest = func(ctx context.Context, _ reference.Named, tag string, manifest distribution.Manifest) (distribution.Manifest, error) {
+			return manifest, evaluate(ctx, ActionPush, tag, "", manifest)
+		}
+	}
+	if p.appliesTo(ActionTag) {
+		hooks.Tag = func(ctx context.Context, _ reference.Named, tag string, desc distribution.Descriptor) error {
+			manifest, err := lookup(ctx, desc.Digest)
+			if err != nil {
+				return err
+			}
+			return evaluate(ctx, ActionTag, tag, desc.Digest, manifest)
+		}
+	}
+	if p.appliesTo(ActionDelete) {
+		hooks.DeleteManifest = func(ctx context.Context, _ reference.Named, dgst digest.Digest) error {
+			manifest, err := lookup(ctx, dgst)
+			if err != nil || manifest == nil {
+				// Deleting an unknown manifest fails regardless.
+				return err
+			}
+			return evaluate(ctx, ActionDelete, "", dgst, manifest)
+		}
+		hooks.Untag = func(ctx context.Context, _ reference.Named, tag string) error {
+			desc, err := repository.Tags(ctx).Get(ctx, tag)
+			if err != nil {
+				// The tag is unknown, which the deletion reports.
+				return nil
+			}
+			manifest, err := lookup(ctx, desc.Digest)
+			if err != nil {
+				return err
+			}
+			return evaluate(ctx, ActionDelete, tag, desc.Digest, manifest)
+		}
+	}
+	return repositorymiddleware.WithHooks(repository, hooks)
+}
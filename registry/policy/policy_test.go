@@ -0,0 +1,316 @@
+package policy
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestExpressions(t *testing.T) {
+	vars := map[string]interface{}{
+		"repository":  "prod/app",
+		"tag":         "v1.2",
+		"size":        int64(2048),
+		"annotations": map[string]string{"owner": "team-a"},
+		"platforms": []map[string]string{
+			{"os": "linux", "architecture": "amd64"},
+			{"os": "linux", "architecture": "arm64"},
+		},
+		"urls":   []string{},
+		"layers": []string{"application/vnd.oci.image.layer.v1.tar+gzip"},
+		"config": map[string]interface{}{"name": "app", "version": 1.0},
+	}
+
+	for _, tc := range []struct {
+		expr     string
+		expected bool
+	}{
+		{`repository == "prod/app"`, true},
+		{`repository.startsWith("prod/") && tag != "latest"`, true},
+		{`tag.matches(r"^v[0-9]+\.[0-9]+$")`, true},
+		{`tag.matches("^v[0-9]+\\.[0-9]+$")`, true},
+		{`size > 1024 * 1024`, false},
+		{`size / 1024 + 1 == 3`, true},
+		{`-size < 0`, true},
+		{`"owner" in annotations`, true},
+		{`annotations.owner == "team-a"`, true},
+		{`has(annotations.reviewer)`, false},
+		{`tag in ["v1.2", "v1.3"]`, true},
+		{`platforms.all(p, p.os == "linux")`, true},
+		{`platforms.exists(p, p.architecture == "s390x")`, false},
+		{`size(platforms) == 2 && platforms[1].architecture == "arm64"`, true},
+		{`urls.all(u, u.startsWith("https://"))`, true},
+		{`tag.size() == 4`, true},
+		{`(tag == "latest" ? "moving" : "fixed") == "fixed"`, true},
+		{`!(repository.endsWith("/app"))`, false},
+		// Errors on one side are absorbed when the other side decides.
+		{`annotations["reviewer"] == "x" || true`, true},
+		{`false && annotations["reviewer"] == "x"`, false},
+		{`[1, "a"] == [1, "a"]`, true},
+		{`null == null`, true},
+		{`repository.glob("prod/*")`, true},
+		{`repository.glob("*")`, false},
+		{`layers.exists(l, l.endsWith("+gzip"))`, true},
+		{`has(config.name) && config.name == "app"`, true},
+		{`type(config.version) == string`, false},
+	} {
+		prog, err := compile(tc.expr)
+		if err != nil {
+			t.Errorf("unexpected error compiling %s: %v", tc.expr, err)
+			continue
+		}
+		v, err := prog.eval(vars)
+		if err != nil {
+			t.Errorf("unexpected error evaluating %s: %v", tc.expr, err)
+			continue
+		}
+		if v != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.expr, tc.expected, v)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	for _, expr := range []string{
+		`repo == "x"`,
+		`tag ==`,
+		`tag.matches("[")`,
+		`tag.unknown()`,
+		`"unterminated`,
+		`tag == "a" && `,
+		`(tag`,
+		`tag # 1`,
+		`has(tag)`,
+		`tag < 1`,
+		`!tag`,
+		`size.startsWith("1")`,
+		`size + 1`,
+		`annotations.owner`,
+		`tag.glob(1)`,
+	} {
+		if _, err := compile(expr); err == nil {
+			t.Errorf("expected an error compiling %s", expr)
+		}
+	}
+
+	vars := map[string]interface{}{"tag": "v1", "size": int64(1), "annotations": map[string]string{}}
+	for _, expr := range []string{
+		`annotations["missing"] == "x"`,
+		`annotations.missing == "x"`,
+		`size / 0 == 1`,
+		`tag.glob("[")`,
+	} {
+		prog, err := compile(expr)
+		if err != nil {
+			t.Errorf("unexpected error compiling %s: %v", expr, err)
+			continue
+		}
+		if _, err := prog.eval(vars); err == nil {
+			t.Errorf("expected an error evaluating %s", expr)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, rules := range [][]configuration.PolicyRule{
+		{{Name: "both", Deny: "true", Allow: "true"}},
+		{{Name: "warn", Deny: "true", Warn: "true"}},
+		{{Name: "none"}},
+		{{Name: "action", Deny: "true", Actions: []string{"mount"}}},
+		{{Name: "syntax", Deny: "tag =="}},
+	} {
+		if _, err := New(rules, nil); err == nil {
+			t.Errorf("expected an error compiling rule %s", rules[0].Name)
+		}
+	}
+}
+
+func testManifest(t *testing.T, annotations map[string]string, urls ...string) distribution.Manifest {
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: distribution.Descriptor{
+			MediaType: v1.MediaTypeImageConfig,
+			Digest:    digest.FromString("config"),
+			Size:      6,
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType: v1.MediaTypeImageLayerNonDistributable,
+			Digest:    digest.FromString("layer"),
+			Size:      1000,
+			URLs:      urls,
+		}},
+		Annotations: annotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	p, err := New([]configuration.PolicyRule{
+		{
+			Name:    "deprecated",
+			Actions: []string{ActionPull},
+			Warn:    `tag == "v1"`,
+			Message: "v1 is deprecated",
+		},
+		{
+			Name:    "latest",
+			Actions: []string{ActionPush},
+			Deny:    `repository.startsWith("prod/") && tag == "latest"`,
+			Message: "latest is not allowed in production",
+		},
+		{
+			Name:    "owner",
+			Actions: []string{ActionPush},
+			Allow:   `"owner" in annotations`,
+		},
+		{
+			Name:  "urls",
+			Allow: `urls.all(u, u.matches(r"^https://([^/]+\.)*example\.com/"))`,
+		},
+		{
+			Name:    "type",
+			Actions: []string{ActionPush, ActionPull},
+			Allow:   `mediaType == "` + v1.MediaTypeImageManifest + `" && artifactType == "` + v1.MediaTypeImageConfig + `" && size > 1006`,
+		},
+		{
+			Name:    "delete",
+			Actions: []string{ActionDelete},
+			Deny:    `annotations["owner"] == "team-a" && user != "admin"`,
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prod, _ := reference.WithName("prod/app")
+	owned := testManifest(t, map[string]string{"owner": "team-a"})
+
+	for _, tc := range []struct {
+		req     Request
+		message string
+		warning string
+	}{
+		{Request{Action: ActionPush, Repository: prod, Tag: "v1", Manifest: owned}, "", ""},
+		{Request{Action: ActionPush, Repository: prod, Tag: "latest", Manifest: owned}, "latest is not allowed in production", ""},
+		{Request{Action: ActionPush, Repository: prod, Tag: "v1", Manifest: testManifest(t, nil)}, "denied by policy rule owner", ""},
+		{Request{Action: ActionPull, Repository: prod, Manifest: testManifest(t, nil)}, "", ""},
+		{Request{Action: ActionPull, Repository: prod, Tag: "v1", Manifest: testManifest(t, nil)}, "", "v1 is deprecated"},
+		{Request{Action: ActionPull, Repository: prod, Manifest: testManifest(t, nil, "https://cdn.example.com/layer")}, "", ""},
+		{Request{Action: ActionPull, Repository: prod, Manifest: testManifest(t, nil, "https://example.org/layer")}, "denied by policy rule urls", ""},
+		{Request{Action: ActionDelete, Repository: prod, Manifest: owned}, "denied by policy rule delete", ""},
+		{Request{Action: ActionDelete, Repository: prod, Manifest: owned, User: "admin"}, "", ""},
+		// An unknown manifest has no owner annotation so the rule errors.
+		{Request{Action: ActionDelete, Repository: prod}, "policy rule delete could not be evaluated", ""},
+	} {
+		warnings, err := p.Evaluate(ctx, tc.req)
+		if warning := strings.Join(warnings, ", "); warning != tc.warning {
+			t.Errorf("expected %s of %s to be warned with %q, got %q", tc.req.Action, tc.req.Tag, tc.warning, warning)
+		}
+		if tc.message == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %s of %s: %v", tc.req.Action, tc.req.Tag, err)
+			}
+			continue
+		}
+
+		e, ok := err.(errcode.Error)
+		if !ok || e.Code != errcode.ErrorCodeDenied || e.Message != tc.message {
+			t.Errorf("expected %s of %s to be denied with %q, got %v", tc.req.Action, tc.req.Tag, tc.message, err)
+		}
+	}
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	p, err := New([]configuration.PolicyRule{
+		{
+			Name:    "immutable",
+			Actions: []string{ActionDelete},
+			Deny:    `tag.startsWith("release-") || annotations["org.example.immutable"] == "true"`,
+		},
+		{
+			Name:    "pull",
+			Actions: []string{ActionPull},
+			Deny:    `tag == "private"`,
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New(), storage.EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo = p.Repository(repo)
+
+	manifests, err := repo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	immutable, err := manifests.Put(ctx, testManifest(t, map[string]string{"org.example.immutable": "true"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutable, err := manifests.Put(ctx, testManifest(t, map[string]string{"org.example.immutable": "false"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := repo.Tags(ctx)
+	for tag, dgst := range map[string]digest.Digest{"release-1": mutable, "dev": mutable, "private": mutable} {
+		if err := tags.Tag(ctx, tag, distribution.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	denied := func(err error) bool {
+		e, ok := err.(errcode.Error)
+		return ok && e.Code == errcode.ErrorCodeDenied
+	}
+
+	if err := manifests.Delete(ctx, immutable); !denied(err) {
+		t.Fatalf("expected deleting an immutable manifest to be denied, got %v", err)
+	}
+	if err := tags.Untag(ctx, "release-1"); !denied(err) {
+		t.Fatalf("expected deleting a release tag to be denied, got %v", err)
+	}
+	if err := tags.Untag(ctx, "dev"); err != nil {
+		t.Fatalf("unexpected error deleting tag: %v", err)
+	}
+	if _, err := manifests.Get(ctx, mutable, distribution.WithTag("private")); !denied(err) {
+		t.Fatalf("expected pulling a private tag to be denied, got %v", err)
+	}
+	if _, err := manifests.Get(ctx, mutable); err != nil {
+		t.Fatalf("unexpected error pulling manifest: %v", err)
+	}
+	if err := manifests.Delete(ctx, mutable); err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+
+	if err := manifests.Delete(ctx, digest.FromString("unknown")); denied(err) || !strings.Contains(err.Error(), "unknown") {
+		t.Fatalf("expected deleting an unknown manifest to fail as unknown, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// RouteFunc moves the blob dgst to the storage class named class.
+type RouteFunc func(ctx context.Context, dgst digest.Digest, class string) error
+
+type storageClassRule struct {
+	name    string
+	class   string
+	program *program
+}
+
+// StorageClasses routes the blobs referenced by manifests to storage classes
+// once the manifests are pushed, such as cache artifacts to cheaper storage
+// and release images to replicated storage. The first rule matching a
+// manifest decides the class of its blobs, which are left where they are
+// when no rule matches.
+type StorageClasses struct {
+	rules []storageClassRule
+	route RouteFunc
+}
+
+// NewStorageClasses compiles the storage class rules, routing blobs to the
+// classes named classes with route.
+func NewStorageClasses(rules []configuration.StorageClassRule, classes []string, route RouteFunc) (*StorageClasses, error) {
+	known := make(map[string]bool, len(classes))
+	for _, class := range classes {
+		known[class] = true
+	}
+
+	s := &StorageClasses{route: route}
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		if !known[r.Class] {
+			return nil, fmt.Errorf("storage class rule %s: unknown storage class %q", name, r.Class)
+		}
+		source := r.Match
+		if source == "" {
+			source = "true"
+		}
+		prog, err := compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("storage class rule %s: %v", name, err)
+		}
+
+		s.rules = append(s.rules, storageClassRule{
+			name:    name,
+			class:   r.Class,
+			program: prog,
+		})
+	}
+	return s, nil
+}
+
+// Class returns the storage class of the blobs referenced by the manifest of
+// req, empty when no rule matches it. Rules whose evaluation fails do not
+// match.
+func (s *StorageClasses) Class(ctx context.Context, req Request) (string, error) {
+	vars, err := requestVariables(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range s.rules {
+		r := &s.rules[i]
+		matched, err := r.program.eval(vars)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("error evaluating storage class rule %s: %v", r.name, err)
+			continue
+		}
+		if matched {
+			return r.class, nil
+		}
+	}
+	return "", nil
+}
+
+// Route routes the blobs referenced by the manifest of req to the storage
+// class of the first rule matching it.
+func (s *StorageClasses) Route(ctx context.Context, req Request) error {
+	class, err := s.Class(ctx, req)
+	if err != nil || class == "" {
+		return err
+	}
+
+	for _, desc := range req.Manifest.References() {
+		if err := s.route(ctx, desc.Digest, class); err != nil {
+			return fmt.Errorf("routing blob %s to storage class %s: %v", desc.Digest, class, err)
+		}
+	}
+	dcontext.GetLogger(ctx).Debugf("routed the blobs of manifest %s of %s to storage class %s", req.Digest, reference.FamiliarString(req.Repository), class)
+	return nil
+}
+
+// Repository returns the repository routing the blobs of its manifests to
+// storage classes as they are pushed. Pushes succeed regardless of the
+// routing, whose errors are logged, as blobs stay readable where they are.
+func (s *StorageClasses) Repository(repository distribution.Repository) distribution.Repository {
+	return &storageClassRepository{
+		Repository:     repository,
+		storageClasses: s,
+	}
+}
+
+type storageClassRepository struct {
+	distribution.Repository
+	storageClasses *StorageClasses
+}
+
+func (r *storageClassRepository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	manifests, err := r.Repository.Manifests(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &storageClassManifestService{
+		ManifestService: manifests,
+		repository:      r.Named(),
+		storageClasses:  r.storageClasses,
+	}, nil
+}
+
+type storageClassManifestService struct {
+	distribution.ManifestService
+	repository     reference.Named
+	storageClasses *StorageClasses
+}
+
+func (ms *storageClassManifestService) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	dgst, err := ms.ManifestService.Put(ctx, manifest, options...)
+	if err != nil {
+		return dgst, err
+	}
+
+	var tag string
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithTagOption); ok {
+			tag = opt.Tag
+		}
+	}
+	err = ms.storageClasses.Route(ctx, Request{
+		Action:     ActionPush,
+		Repository: ms.repository,
+		Tag:        tag,
+		Digest:     dgst,
+		Manifest:   manifest,
+		User:       dcontext.GetUserName(ctx),
+	})
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error routing the blobs of manifest %s: %v", dgst, err)
+	}
+	return dgst, nil
+}
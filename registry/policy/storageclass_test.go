@@ -0,0 +1,97 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestNewStorageClasses(t *testing.T) {
+	classes := []string{"standard", "cheap"}
+	for _, rules := range [][]configuration.StorageClassRule{
+		{{Name: "unknown", Class: "replicated"}},
+		{{Name: "none"}},
+		{{Name: "syntax", Class: "cheap", Match: "tag =="}},
+	} {
+		if _, err := NewStorageClasses(rules, classes, nil); err == nil {
+			t.Errorf("expected an error compiling storage class rule %s", rules[0].Name)
+		}
+	}
+}
+
+func TestStorageClasses(t *testing.T) {
+	ctx := context.Background()
+	routed := make(map[digest.Digest]string)
+	s, err := NewStorageClasses([]configuration.StorageClassRule{
+		{
+			Name:  "cache",
+			Class: "cheap",
+			Match: `annotations["org.example.kind"] == "cache"`,
+		},
+		{
+			Name:  "release",
+			Class: "replicated",
+			Match: `tag.matches(r"^v[0-9]+")`,
+		},
+	}, []string{"standard", "cheap", "replicated"}, func(ctx context.Context, dgst digest.Digest, class string) error {
+		routed[dgst] = class
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := reference.WithName("foo/bar")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := s.Repository(repo).Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, layer := digest.FromString("config"), digest.FromString("layer")
+	for _, tc := range []struct {
+		annotations map[string]string
+		tag         string
+		class       string
+	}{
+		// The annotation of the cache rule is missing, so it does not
+		// match.
+		{nil, "latest", ""},
+		{map[string]string{"org.example.kind": "cache"}, "v1", "cheap"},
+		{map[string]string{"org.example.kind": "image"}, "v1", "replicated"},
+	} {
+		for k := range routed {
+			delete(routed, k)
+		}
+		var options []distribution.ManifestServiceOption
+		if tc.tag != "" {
+			options = append(options, distribution.WithTag(tc.tag))
+		}
+		if _, err := manifests.Put(ctx, testManifest(t, tc.annotations), options...); err != nil {
+			t.Fatalf("unexpected error pushing manifest: %v", err)
+		}
+
+		if tc.class == "" {
+			if len(routed) != 0 {
+				t.Errorf("expected no blobs to be routed for tag %s, got %v", tc.tag, routed)
+			}
+			continue
+		}
+		if len(routed) != 2 || routed[config] != tc.class || routed[layer] != tc.class {
+			t.Errorf("expected the config and layer to be routed to %s for tag %s, got %v", tc.class, tc.tag, routed)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/distribution/distribution/v3/configuration"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/uuid"
+)
+
+const (
+	// preflightRoot is the directory the storage write check writes under.
+	preflightRoot = "/docker/registry/v2/_preflight"
+
+	// maxClockSkew is the difference between the clock of the registry and
+	// that of the storage backend beyond which the clock check fails.
+	// Tokens and signed redirect URLs are rejected by the other side when
+	// clocks disagree by more than a few minutes.
+	maxClockSkew = time.Minute
+
+	// certExpiryWarning is how long before their expiry the root
+	// certificates of token authentication are reported as expiring.
+	certExpiryWarning = 30 * 24 * time.Hour
+
+	// defaultPreflightTimeout bounds the time the checks depending on
+	// remote services take, unless configured otherwise.
+	defaultPreflightTimeout = 10 * time.Second
+)
+
+// preflightCheck is a check of the environment of the registry, run before
+// it starts listening.
+type preflightCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// preflightChecks returns the checks applying to config. The storage checks
+// share the driver, constructed by the first of them.
+func preflightChecks(config *configuration.Configuration) []preflightCheck {
+	var driver storagedriver.StorageDriver
+	var written time.Time
+	checks := []preflightCheck{
+		{
+			name: "storage credentials",
+			run: func(ctx context.Context) (string, error) {
+				var err error
+				driver, err = factory.Create(config.Storage.Type(), config.Storage.Parameters())
+				if err != nil {
+					return "", fmt.Errorf("constructing the %s storage driver: %v; check the parameters of storage.%s", config.Storage.Type(), err, config.Storage.Type())
+				}
+				if _, err := driver.List(ctx, "/"); err != nil {
+					if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+						return "", fmt.Errorf("listing the root of the %s storage: %v; check the credentials and the bucket or container of the storage", driver.Name(), err)
+					}
+				}
+				return driver.Name(), nil
+			},
+		},
+	}
+
+	if !readOnly(config) {
+		p := preflightRoot + "/" + uuid.Generate().String()
+		checks = append(checks,
+			preflightCheck{
+				name: "storage write access",
+				run: func(ctx context.Context) (string, error) {
+					if driver == nil {
+						return "", errors.New("skipped, the storage driver could not be constructed")
+					}
+					content := []byte(p)
+					written = time.Now()
+					if err := driver.PutContent(ctx, p, content); err != nil {
+						return "", fmt.Errorf("writing %s: %v; the credentials of the storage must allow writes, or the registry be configured read only with storage.maintenance.readonly", p, err)
+					}
+					read, err := driver.GetContent(ctx, p)
+					if err != nil {
+						return "", fmt.Errorf("reading back %s: %v", p, err)
+					}
+					if !bytes.Equal(read, content) {
+						return "", fmt.Errorf("reading back %s returned %d bytes differing from the %d written", p, len(read), len(content))
+					}
+					return "", nil
+				},
+			},
+			preflightCheck{
+				name: "clock",
+				run: func(ctx context.Context) (string, error) {
+					if driver == nil || written.IsZero() {
+						return "", errors.New("skipped, nothing could be written to the storage")
+					}
+					defer driver.Delete(ctx, p)
+
+					fi, err := driver.Stat(ctx, p)
+					if err != nil {
+						return "", fmt.Errorf("stating %s: %v", p, err)
+					}
+					skew := fi.ModTime().Sub(written)
+					if skew < 0 {
+						skew = -skew
+					}
+					// The resolution of the modification times of some
+					// backends is a second.
+					skew = skew.Truncate(time.Second)
+					if skew > maxClockSkew {
+						return "", fmt.Errorf("the clock of the registry is %s apart from that of the storage; synchronize the clock of the host, with NTP for instance", skew)
+					}
+					return fmt.Sprintf("%s apart from the storage", skew), nil
+				},
+			})
+	}
+
+	if config.Redis.Addr != "" {
+		checks = append(checks, preflightCheck{
+			name: "redis",
+			run: func(ctx context.Context) (string, error) {
+				return "", pingRedis(ctx, config)
+			},
+		})
+	}
+
+	if config.Auth.Type() == "token" {
+		if bundle, ok := config.Auth.Parameters()["rootcertbundle"].(string); ok && bundle != "" {
+			checks = append(checks, preflightCheck{
+				name: "token root certificates",
+				run: func(ctx context.Context) (string, error) {
+					return checkCertBundle(bundle, time.Now())
+				},
+			})
+		}
+	}
+
+	return checks
+}
+
+// runPreflight runs the checks of config, reporting their outcome to w, and
+// returns an error if any of them failed.
+func runPreflight(ctx context.Context, config *configuration.Configuration, w io.Writer) error {
+	var failed []string
+	for _, check := range preflightChecks(config) {
+		checkCtx, cancel := context.WithTimeout(ctx, defaultPreflightTimeout)
+		detail, err := check.run(checkCtx)
+		cancel()
+
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "FAIL %s: %v\n", check.name, err)
+			failed = append(failed, check.name)
+		case detail != "":
+			fmt.Fprintf(w, "ok   %s: %s\n", check.name, detail)
+		default:
+			fmt.Fprintf(w, "ok   %s\n", check.name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("preflight checks failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// readOnly reports whether config puts the registry in read only mode.
+func readOnly(config *configuration.Configuration) bool {
+	readOnly, _ := config.Storage["maintenance"]["readonly"].(map[interface{}]interface{})
+	enabled, _ := readOnly["enabled"].(bool)
+	return enabled
+}
+
+// pingRedis connects to the redis instance of config as the registry does,
+// and pings it.
+func pingRedis(ctx context.Context, config *configuration.Configuration) error {
+	conn, err := redis.DialContext(ctx, "tcp",
+		config.Redis.Addr,
+		redis.DialConnectTimeout(config.Redis.DialTimeout),
+		redis.DialReadTimeout(config.Redis.ReadTimeout),
+		redis.DialWriteTimeout(config.Redis.WriteTimeout),
+		redis.DialUseTLS(config.Redis.TLS.Enabled))
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %v; check redis.addr and that the instance accepts connections from the registry", config.Redis.Addr, err)
+	}
+	defer conn.Close()
+
+	if config.Redis.Password != "" {
+		if _, err := conn.Do("AUTH", config.Redis.Password); err != nil {
+			return fmt.Errorf("authenticating to %s: %v; check redis.password", config.Redis.Addr, err)
+		}
+	}
+	if config.Redis.DB != 0 {
+		if _, err := conn.Do("SELECT", config.Redis.DB); err != nil {
+			return fmt.Errorf("selecting database %d: %v; check redis.db", config.Redis.DB, err)
+		}
+	}
+	if _, err := conn.Do("PING"); err != nil {
+		return fmt.Errorf("pinging %s: %v", config.Redis.Addr, err)
+	}
+	return nil
+}
+
+// checkCertBundle checks that the PEM bundle at path holds certificates
+// valid at now.
+func checkCertBundle(path string, now time.Time) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %v; check auth.token.rootcertbundle", err)
+	}
+
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("parsing a certificate of %s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return "", fmt.Errorf("%s holds no PEM certificate; tokens could not be verified", path)
+	}
+
+	var expiring []string
+	for _, cert := range certs {
+		switch {
+		case now.Before(cert.NotBefore):
+			return "", fmt.Errorf("certificate %q of %s is not valid before %s; check the clock of the host", cert.Subject.CommonName, path, cert.NotBefore.Format(time.RFC3339))
+		case now.After(cert.NotAfter):
+			return "", fmt.Errorf("certificate %q of %s expired on %s; tokens signed with its key are rejected, renew it", cert.Subject.CommonName, path, cert.NotAfter.Format(time.RFC3339))
+		case cert.NotAfter.Sub(now) < certExpiryWarning:
+			expiring = append(expiring, fmt.Sprintf("%q expires on %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339)))
+		}
+	}
+
+	detail := fmt.Sprintf("%d certificates", len(certs))
+	if len(expiring) > 0 {
+		detail += ", " + strings.Join(expiring, ", ")
+	}
+	return detail, nil
+}
@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// writeCertBundle writes a bundle of a self-signed certificate valid from
+// notBefore to notAfter.
+func writeCertBundle(t *testing.T, notBefore, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "token signer"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "bundle.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunPreflight(t *testing.T) {
+	now := time.Now()
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{"inmemory": configuration.Parameters{}},
+		Auth: configuration.Auth{"token": configuration.Parameters{
+			"rootcertbundle": writeCertBundle(t, now.Add(-time.Hour), now.Add(365*24*time.Hour)),
+		}},
+	}
+
+	var out bytes.Buffer
+	if err := runPreflight(context.Background(), config, &out); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, &out)
+	}
+	for _, check := range []string{"storage credentials", "storage write access", "clock", "token root certificates"} {
+		if !strings.Contains(out.String(), "ok   "+check) {
+			t.Errorf("expected check %q to pass:\n%s", check, &out)
+		}
+	}
+
+	// Failing checks are reported with what to fix.
+	config.Auth["token"]["rootcertbundle"] = writeCertBundle(t, now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+	config.Redis.Addr = "127.0.0.1:1"
+	out.Reset()
+	err := runPreflight(context.Background(), config, &out)
+	if err == nil {
+		t.Fatalf("expected the preflight to fail:\n%s", &out)
+	}
+	if !strings.Contains(out.String(), "FAIL redis") || !strings.Contains(out.String(), "expired on") {
+		t.Fatalf("unexpected report:\n%s", &out)
+	}
+}
+
+func TestCheckCertBundleExpiring(t *testing.T) {
+	now := time.Now()
+	detail, err := checkCertBundle(writeCertBundle(t, now.Add(-time.Hour), now.Add(24*time.Hour)), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(detail, "expires on") {
+		t.Fatalf("expected the certificate to be reported as expiring, got %q", detail)
+	}
+}
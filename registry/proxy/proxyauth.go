@@ -34,6 +34,18 @@ func (c credentials) RefreshToken(u *url.URL, service string) string {
 func (c credentials) SetRefreshToken(u *url.URL, service, token string) {
 }
 
+// configureDockerConfigAuth uses the credentials a docker config file holds
+// for the remote in challenge responses
+func configureDockerConfigAuth(path, remoteURL string) (auth.CredentialStore, error) {
+	config, err := auth.LoadDockerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	context.GetLogger(context.Background()).Infof("Using credentials from docker config %s for %s", path, remoteURL)
+	return auth.NewDockerCredentialStore(config, remoteURL), nil
+}
+
 // configureAuth stores credentials for challenge responses
 func configureAuth(username, password, remoteURL string) (auth.CredentialStore, error) {
 	creds := map[string]userpass{}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
@@ -9,8 +10,10 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage/coalesce"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -24,12 +27,40 @@ type proxyBlobStore struct {
 
 var _ distribution.BlobStore = &proxyBlobStore{}
 
+// maxStreamedBlobSize is the size of the largest blob whose fetch from the
+// remote is buffered in memory and streamed to every client waiting for it.
+// Clients of larger blobs being fetched wait for them to be stored locally.
+const maxStreamedBlobSize = 256 << 20
+
+// inflightBlob is a blob being fetched from the remote and stored locally.
+type inflightBlob struct {
+	ready  chan struct{} // closed once desc and err are set
+	desc   distribution.Descriptor
+	err    error
+	stored chan struct{} // closed once the blob is stored, or storing it failed
+
+	// waiters is the number of clients being served the blob, and served
+	// is set once one of them was served all of it. abort cancels the
+	// fetch. They are protected by mu.
+	waiters int
+	served  bool
+	abort   context.CancelFunc
+}
+
+// abortedFetches counts the fetches of blobs from the remote aborted as all
+// the clients waiting for them disconnected.
+var abortedFetches = prometheus.ProxyNamespace.NewCounter("aborted_fetches", "The number of blob fetches from the remote aborted as their clients disconnected")
+
 // inflight tracks currently downloading blobs
-var inflight = make(map[digest.Digest]struct{})
+var inflight = make(map[digest.Digest]*inflightBlob)
 
 // mu protects inflight
 var mu sync.Mutex
 
+// blobStreams shares the remote read of a blob being fetched between the
+// local store and all clients waiting for it.
+var blobStreams coalesce.Group
+
 func setResponseHeaders(w http.ResponseWriter, length int64, mediaType string, digest digest.Digest) {
 	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
 	w.Header().Set("Content-Type", mediaType)
@@ -37,31 +68,28 @@ func setResponseHeaders(w http.ResponseWriter, length int64, mediaType string, d
 	w.Header().Set("Etag", digest.String())
 }
 
-func (pbs *proxyBlobStore) copyContent(ctx context.Context, dgst digest.Digest, writer io.Writer) (distribution.Descriptor, error) {
-	desc, err := pbs.remoteStore.Stat(ctx, dgst)
-	if err != nil {
-		return distribution.Descriptor{}, err
-	}
+// serveContent writes the content of the blob described by desc, read from
+// rd, to the client.
+func serveContent(w http.ResponseWriter, desc distribution.Descriptor, rd io.Reader) error {
+	setResponseHeaders(w, desc.Size, desc.MediaType, desc.Digest)
 
-	if w, ok := writer.(http.ResponseWriter); ok {
-		setResponseHeaders(w, desc.Size, desc.MediaType, dgst)
-	}
-
-	remoteReader, err := pbs.remoteStore.Open(ctx, dgst)
-	if err != nil {
-		return distribution.Descriptor{}, err
+	if _, err := io.CopyN(w, rd, desc.Size); err != nil {
+		return err
 	}
 
-	defer remoteReader.Close()
+	proxyMetrics.BlobPush(uint64(desc.Size))
+	return nil
+}
 
-	_, err = io.CopyN(writer, remoteReader, desc.Size)
+// serveRemote serves the blob described by desc straight from the remote.
+func (pbs *proxyBlobStore) serveRemote(ctx context.Context, w http.ResponseWriter, desc distribution.Descriptor) error {
+	remoteReader, err := pbs.remoteStore.Open(ctx, desc.Digest)
 	if err != nil {
-		return distribution.Descriptor{}, err
+		return err
 	}
+	defer remoteReader.Close()
 
-	proxyMetrics.BlobPush(uint64(desc.Size))
-
-	return desc, nil
+	return serveContent(w, desc, remoteReader)
 }
 
 func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) (bool, error) {
@@ -76,27 +104,20 @@ func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter
 	return true, pbs.localStore.ServeBlob(ctx, w, r, dgst)
 }
 
-func (pbs *proxyBlobStore) storeLocal(ctx context.Context, dgst digest.Digest) error {
-	defer func() {
-		mu.Lock()
-		delete(inflight, dgst)
-		mu.Unlock()
-	}()
-
-	var desc distribution.Descriptor
-	var err error
-	var bw distribution.BlobWriter
-
-	bw, err = pbs.localStore.Create(ctx)
+// storeLocal stores the blob described by desc, read from src, locally.
+func (pbs *proxyBlobStore) storeLocal(ctx context.Context, desc distribution.Descriptor, src io.Reader) error {
+	bw, err := pbs.localStore.Create(ctx)
 	if err != nil {
 		return err
 	}
 
-	desc, err = pbs.copyContent(ctx, dgst, bw)
-	if err != nil {
+	if _, err := io.CopyN(bw, src, desc.Size); err != nil {
+		bw.Cancel(ctx)
 		return err
 	}
 
+	proxyMetrics.BlobPull(uint64(desc.Size))
+
 	_, err = bw.Commit(ctx, desc)
 	if err != nil {
 		return err
@@ -121,25 +142,120 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 	}
 
 	mu.Lock()
-	_, ok := inflight[dgst]
+	fetch, ok := inflight[dgst]
+	if !ok {
+		fetch = &inflightBlob{
+			ready:  make(chan struct{}),
+			stored: make(chan struct{}),
+		}
+		inflight[dgst] = fetch
+	}
+	fetch.waiters++
+	mu.Unlock()
+
 	if ok {
-		mu.Unlock()
-		_, err := pbs.copyContent(ctx, dgst, w)
+		err = pbs.serveInflight(ctx, w, r, dgst, fetch)
+	} else {
+		err = pbs.fetch(ctx, w, dgst, fetch)
+	}
+	leaveFetch(ctx, dgst, fetch, err)
+	return err
+}
+
+// leaveFetch records that a client being served the blob of fetch is done,
+// with err. Once the last client disconnects, and none was served all of the
+// blob, the fetch is aborted rather than completed for nobody.
+func leaveFetch(ctx context.Context, dgst digest.Digest, fetch *inflightBlob, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	fetch.waiters--
+	if err == nil {
+		fetch.served = true
+	}
+	if fetch.waiters > 0 || fetch.served || fetch.abort == nil || ctx.Err() == nil {
+		return
+	}
+	select {
+	case <-fetch.stored:
+		return
+	default:
+	}
+
+	// Later clients start a fetch of their own.
+	if inflight[dgst] == fetch {
+		delete(inflight, dgst)
+	}
+	fetch.abort()
+	abortedFetches.Inc(1)
+}
+
+// fetch fetches a blob from the remote, serving it to the client while it is
+// stored locally.
+func (pbs *proxyBlobStore) fetch(ctx context.Context, w http.ResponseWriter, dgst digest.Digest, fetch *inflightBlob) error {
+	desc, err := pbs.remoteStore.Stat(ctx, dgst)
+	if err != nil {
+		fetch.err = err
+		close(fetch.ready)
+		finishFetch(dgst, fetch)
 		return err
 	}
-	inflight[dgst] = struct{}{}
-	mu.Unlock()
 
 	// storeLocalCtx will be independent with ctx, because ctx it used to fetch remote image.
 	// There would be a situation, that is pulling remote bytes ends before pbs.storeLocal( 'Copy', 'Commit' ...)
 	// Then the registry fails to cache the layer, even though the layer had been served to client.
+	// It is only canceled when all the clients of the blob disconnect.
 	storeLocalCtx, cancel := context.WithCancel(context.Background())
+	mu.Lock()
+	fetch.abort = cancel
+	mu.Unlock()
+
+	// Blobs small enough to be buffered are read from the remote once, for
+	// the local store, this client and any other client arriving meanwhile.
+	// Larger blobs are read separately for the local store and this client.
+	var src, content io.ReadCloser
+	if desc.Size <= maxStreamedBlobSize {
+		open := func(ctx context.Context) (io.ReadCloser, error) {
+			return pbs.remoteStore.Open(ctx, dgst)
+		}
+		src, _ = blobStreams.Open(storeLocalCtx, dgst.String(), desc.Size, open)
+		content, _ = blobStreams.Open(ctx, dgst.String(), desc.Size, open)
+	} else {
+		src, err = pbs.remoteStore.Open(storeLocalCtx, dgst)
+		if err == nil {
+			content, err = pbs.remoteStore.Open(ctx, dgst)
+			if err != nil {
+				src.Close()
+			}
+		}
+		if err != nil {
+			cancel()
+			fetch.err = err
+			close(fetch.ready)
+			finishFetch(dgst, fetch)
+			return err
+		}
+	}
+	defer content.Close()
+
+	fetch.desc = desc
+	close(fetch.ready)
+
 	go func(dgst digest.Digest) {
 		defer cancel()
-		if err := pbs.storeLocal(storeLocalCtx, dgst); err != nil {
+
+		err := pbs.storeLocal(storeLocalCtx, desc, src)
+		src.Close()
+		finishFetch(dgst, fetch)
+		if err != nil {
 			dcontext.GetLogger(storeLocalCtx).Errorf("Error committing to storage: %s", err.Error())
 		}
 
+		// Without a scheduler, cached content is kept rather than expired.
+		if pbs.scheduler == nil {
+			return
+		}
+
 		blobRef, err := reference.WithDigest(pbs.repositoryName, dgst)
 		if err != nil {
 			dcontext.GetLogger(storeLocalCtx).Errorf("Error creating reference: %s", err)
@@ -149,12 +265,52 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 		pbs.scheduler.AddBlob(blobRef, repositoryTTL)
 	}(dgst)
 
-	_, err = pbs.copyContent(ctx, dgst, w)
-	if err != nil {
-		cancel()
+	return serveContent(w, desc, content)
+}
+
+// serveInflight serves a blob which another request is fetching from the
+// remote, following the fetch rather than starting another one.
+func (pbs *proxyBlobStore) serveInflight(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest, fetch *inflightBlob) error {
+	select {
+	case <-fetch.ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if fetch.err != nil {
+		return fetch.err
+	}
+
+	if content, ok := blobStreams.Join(ctx, dgst.String()); ok {
+		defer content.Close()
+		return serveContent(w, fetch.desc, content)
+	}
+
+	select {
+	case <-fetch.stored:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	served, err := pbs.serveLocal(ctx, w, r, dgst)
+	if err != nil || served {
 		return err
 	}
-	return nil
+
+	// Storing the blob failed, fetch it for this client alone.
+	return pbs.serveRemote(ctx, w, fetch.desc)
+}
+
+// finishFetch marks the fetch of a blob as done, once it has been stored
+// locally or failed.
+func finishFetch(dgst digest.Digest, fetch *inflightBlob) {
+	mu.Lock()
+	if inflight[dgst] == fetch {
+		delete(inflight, dgst)
+	}
+	mu.Unlock()
+
+	close(fetch.stored)
 }
 
 func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
@@ -163,7 +319,7 @@ func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distri
 		return desc, err
 	}
 
-	if err != distribution.ErrBlobUnknown {
+	if !errors.Is(err, distribution.ErrBlobUnknown) {
 		return distribution.Descriptor{}, err
 	}
 
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -408,3 +409,117 @@ func testProxyStoreServe(t *testing.T, te *testEnv, numClients int) {
 		t.Fatalf("unexpected remote stats: %#v", remoteStats)
 	}
 }
+
+// gatedBlobStore counts remote fetches and holds back the content of blobs
+// until released.
+type gatedBlobStore struct {
+	distribution.BlobStore
+	release chan struct{}
+	stats   int32
+	opens   int32
+}
+
+func (gbs *gatedBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	atomic.AddInt32(&gbs.stats, 1)
+	return gbs.BlobStore.Stat(ctx, dgst)
+}
+
+func (gbs *gatedBlobStore) Open(ctx context.Context, dgst digest.Digest) (distribution.ReadSeekCloser, error) {
+	atomic.AddInt32(&gbs.opens, 1)
+	<-gbs.release
+	return gbs.BlobStore.Open(ctx, dgst)
+}
+
+func TestProxyStoreServeCoalesced(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+	populate(t, te, 1, 64<<10, 1)
+
+	remote := &gatedBlobStore{
+		BlobStore: te.store.remoteStore.(statsBlobStore),
+		release:   make(chan struct{}),
+	}
+	te.store.remoteStore = remote
+	dgst := te.inRemote[0].Digest
+
+	numClients := 8
+	var wg sync.WaitGroup
+	for i := 0; i < numClients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r, err := http.NewRequest("GET", "", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if err := te.store.ServeBlob(te.ctx, w, r, dgst); err != nil {
+				t.Error(err)
+				return
+			}
+			if digest.FromBytes(w.Body.Bytes()) != dgst {
+				t.Error("Mismatching blob fetch from proxy")
+			}
+		}()
+	}
+
+	// Let the clients pile up behind the first fetch.
+	time.Sleep(50 * time.Millisecond)
+	close(remote.release)
+	wg.Wait()
+
+	if stats, opens := atomic.LoadInt32(&remote.stats), atomic.LoadInt32(&remote.opens); stats != 1 || opens != 1 {
+		t.Fatalf("expected a single remote fetch, got %d stats and %d opens", stats, opens)
+	}
+}
+
+func TestProxyStoreServeDisconnected(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+	populate(t, te, 1, 64<<10, 1)
+
+	remote := &gatedBlobStore{
+		BlobStore: te.store.remoteStore.(statsBlobStore),
+		release:   make(chan struct{}),
+	}
+	te.store.remoteStore = remote
+	dgst := te.inRemote[0].Digest
+
+	ctx, cancel := context.WithCancel(te.ctx)
+	var fetch *inflightBlob
+	time.AfterFunc(20*time.Millisecond, func() {
+		mu.Lock()
+		fetch = inflight[dgst]
+		mu.Unlock()
+		cancel()
+	})
+	r, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.store.ServeBlob(ctx, httptest.NewRecorder(), r, dgst); err != context.Canceled {
+		t.Fatalf("expected the blob not to be served, got %v", err)
+	}
+
+	mu.Lock()
+	_, ok := inflight[dgst]
+	mu.Unlock()
+	if ok || fetch == nil {
+		t.Fatal("expected the fetch of the blob to be aborted")
+	}
+	// Storing the blob stops without waiting for the remote.
+	<-fetch.stored
+	close(remote.release)
+
+	// The next client fetches the blob again.
+	w := httptest.NewRecorder()
+	if err := te.store.ServeBlob(te.ctx, w, r, dgst); err != nil {
+		t.Fatal(err)
+	}
+	if digest.FromBytes(w.Body.Bytes()) != dgst {
+		t.Fatal("Mismatching blob fetch from proxy")
+	}
+	if opens := atomic.LoadInt32(&remote.opens); opens != 2 {
+		t.Fatalf("expected the blob to be fetched again, got %d opens", opens)
+	}
+}
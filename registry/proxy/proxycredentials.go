@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/client/auth"
+)
+
+// credentialRefreshWindow is how long before their expiry minted
+// credentials are replaced, so that requests in flight never carry
+// credentials which expire midway.
+const credentialRefreshWindow = 5 * time.Minute
+
+// upstreamCredentials are short lived credentials for the remote registry.
+type upstreamCredentials struct {
+	username string
+	password string
+	expiry   time.Time
+}
+
+// credentialProvider mints credentials for the remote registry from the
+// ambient credentials of the environment the registry runs in, such as an
+// instance role, IRSA or workload identity.
+type credentialProvider interface {
+	credentials(ctx context.Context) (upstreamCredentials, error)
+}
+
+// newCredentialProvider returns the credential provider configured for the
+// remote.
+func newCredentialProvider(config configuration.ProxyCredentialProvider, remoteURL *url.URL) (credentialProvider, error) {
+	switch config.Name {
+	case "ecr":
+		return newECRCredentialProvider(config.Options, remoteURL)
+	case "gcr":
+		return newGCRCredentialProvider(config.Options)
+	case "acr":
+		return newACRCredentialProvider(config.Options, remoteURL)
+	default:
+		return nil, fmt.Errorf("unknown proxy credential provider %q", config.Name)
+	}
+}
+
+// providerCredentials is a CredentialStore answering with the credentials
+// minted by a credential provider, which it mints again shortly before
+// they expire.
+type providerCredentials struct {
+	provider credentialProvider
+
+	mu      sync.Mutex
+	current upstreamCredentials
+}
+
+var _ auth.CredentialStore = &providerCredentials{}
+
+// configureProviderAuth uses credentials minted by the configured provider
+// in challenge responses
+func configureProviderAuth(config configuration.ProxyCredentialProvider, remoteURL *url.URL) (auth.CredentialStore, error) {
+	provider, err := newCredentialProvider(config, remoteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &providerCredentials{provider: provider}
+
+	// Mint the first credentials eagerly to surface configuration mistakes
+	// at startup rather than at the first pull.
+	if _, _, err := pc.basic(context.Background()); err != nil {
+		return nil, fmt.Errorf("unable to obtain upstream credentials from %s provider: %v", config.Name, err)
+	}
+
+	dcontext.GetLogger(context.Background()).Infof("Using %s credential provider for %s", config.Name, remoteURL)
+	return pc, nil
+}
+
+func (pc *providerCredentials) basic(ctx context.Context) (string, string, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if time.Until(pc.current.expiry) > credentialRefreshWindow {
+		return pc.current.username, pc.current.password, nil
+	}
+
+	creds, err := pc.provider.credentials(ctx)
+	if err != nil {
+		// Keep using credentials which have not expired yet.
+		if time.Now().Before(pc.current.expiry) {
+			dcontext.GetLogger(ctx).Warnf("unable to refresh upstream credentials, using current ones: %v", err)
+			return pc.current.username, pc.current.password, nil
+		}
+		return "", "", err
+	}
+
+	pc.current = creds
+	return creds.username, creds.password, nil
+}
+
+func (pc *providerCredentials) Basic(*url.URL) (string, string) {
+	ctx := context.Background()
+	username, password, err := pc.basic(ctx)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("unable to obtain upstream credentials: %v", err)
+	}
+	return username, password
+}
+
+func (pc *providerCredentials) RefreshToken(*url.URL, string) string {
+	return ""
+}
+
+func (pc *providerCredentials) SetRefreshToken(*url.URL, string, string) {
+}
+
+// stringOption returns the string option with the given key, or the
+// default when it is absent.
+func stringOption(options configuration.Parameters, key, def string) (string, error) {
+	val, ok := options[key]
+	if !ok || val == nil {
+		return def, nil
+	}
+	s, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("option %q must be a string, got %T", key, val)
+	}
+	return s, nil
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+const (
+	// acrUsername is the username Azure Container Registry expects
+	// alongside a refresh token obtained through token exchange.
+	acrUsername = "00000000-0000-0000-0000-000000000000"
+
+	azureManagementResource = "https://management.azure.com/"
+	defaultAzureAuthority   = "https://login.microsoftonline.com/"
+)
+
+// acrCredentialProvider mints credentials for an Azure Container Registry
+// by exchanging an Azure AD access token for an ACR refresh token. The AD
+// token is obtained through workload identity federation when the
+// AZURE_FEDERATED_TOKEN_FILE environment is present, and from the managed
+// identity of the host otherwise.
+type acrCredentialProvider struct {
+	registry string
+	tenantID string
+	clientID string
+	client   *http.Client
+
+	managedIdentity *adal.ServicePrincipalToken
+}
+
+func newACRCredentialProvider(options configuration.Parameters, remoteURL *url.URL) (credentialProvider, error) {
+	tenantID, err := stringOption(options, "tenantid", os.Getenv("AZURE_TENANT_ID"))
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := stringOption(options, "clientid", os.Getenv("AZURE_CLIENT_ID"))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &acrCredentialProvider{
+		registry: remoteURL.Host,
+		tenantID: tenantID,
+		clientID: clientID,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	if os.Getenv("AZURE_FEDERATED_TOKEN_FILE") == "" {
+		p.managedIdentity, err = adal.NewServicePrincipalTokenFromManagedIdentity(azureManagementResource, &adal.ManagedIdentityOptions{
+			ClientID: clientID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to use azure managed identity: %v", err)
+		}
+	} else if tenantID == "" || clientID == "" {
+		return nil, fmt.Errorf("acr credential provider requires a tenantid and clientid for workload identity")
+	}
+
+	return p, nil
+}
+
+func (p *acrCredentialProvider) credentials(ctx context.Context) (upstreamCredentials, error) {
+	aadToken, aadExpiry, err := p.aadToken(ctx)
+	if err != nil {
+		return upstreamCredentials{}, fmt.Errorf("unable to obtain azure ad token: %v", err)
+	}
+
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {p.registry},
+		"access_token": {aadToken},
+	}
+	if p.tenantID != "" {
+		form.Set("tenant", p.tenantID)
+	}
+
+	var out struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := p.postForm(ctx, fmt.Sprintf("https://%s/oauth2/exchange", p.registry), form, &out); err != nil {
+		return upstreamCredentials{}, fmt.Errorf("acr token exchange failed: %v", err)
+	}
+
+	expiry := jwtExpiry(out.RefreshToken)
+	if expiry.IsZero() {
+		expiry = aadExpiry
+	}
+
+	return upstreamCredentials{
+		username: acrUsername,
+		password: out.RefreshToken,
+		expiry:   expiry,
+	}, nil
+}
+
+// aadToken returns an Azure AD access token for the management resource.
+func (p *acrCredentialProvider) aadToken(ctx context.Context) (string, time.Time, error) {
+	if p.managedIdentity != nil {
+		if err := p.managedIdentity.EnsureFreshWithContext(ctx); err != nil {
+			return "", time.Time{}, err
+		}
+		token := p.managedIdentity.Token()
+		return token.OAuthToken(), token.Expires(), nil
+	}
+
+	assertion, err := ioutil.ReadFile(os.Getenv("AZURE_FEDERATED_TOKEN_FILE"))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = defaultAzureAuthority
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {p.clientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+		"scope":                 {azureManagementResource + ".default"},
+	}
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	tokenURL := strings.TrimSuffix(authority, "/") + "/" + p.tenantID + "/oauth2/v2.0/token"
+	if err := p.postForm(ctx, tokenURL, form, &out); err != nil {
+		return "", time.Time{}, err
+	}
+
+	return out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn) * time.Second), nil
+}
+
+func (p *acrCredentialProvider) postForm(ctx context.Context, endpoint string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	payload, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, payload)
+	}
+
+	return json.Unmarshal(payload, out)
+}
+
+// jwtExpiry returns the expiry of a JWT without verifying it, or the zero
+// time when it cannot be determined.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
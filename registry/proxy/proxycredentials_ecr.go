@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+const ecrGetAuthorizationTokenTarget = "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken"
+
+// ecrCredentialProvider mints credentials for an Amazon ECR registry by
+// calling GetAuthorizationToken with the default AWS credential chain,
+// which covers environment variables, shared config, IRSA web identity
+// tokens and instance roles.
+type ecrCredentialProvider struct {
+	session  *session.Session
+	region   string
+	endpoint string
+	client   *http.Client
+}
+
+func newECRCredentialProvider(options configuration.Parameters, remoteURL *url.URL) (credentialProvider, error) {
+	region, err := stringOption(options, "region", ecrRegion(remoteURL.Hostname()))
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		return nil, fmt.Errorf("ecr credential provider requires a region for %s", remoteURL.Host)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{Region: aws.String(region)},
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create aws session: %v", err)
+	}
+
+	endpoint, err := stringOption(options, "endpoint", "")
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == "" {
+		resolved, err := endpoints.DefaultResolver().EndpointFor("api.ecr", region)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve ecr endpoint for region %s: %v", region, err)
+		}
+		endpoint = resolved.URL
+	}
+
+	return &ecrCredentialProvider{
+		session:  sess,
+		region:   region,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// ecrRegion extracts the region from an ECR registry host name of the form
+// <account>.dkr.ecr.<region>.amazonaws.com.
+func ecrRegion(host string) string {
+	parts := strings.Split(host, ".")
+	if len(parts) >= 6 && parts[1] == "dkr" && parts[2] == "ecr" {
+		return parts[3]
+	}
+	return ""
+}
+
+func (p *ecrCredentialProvider) credentials(ctx context.Context) (upstreamCredentials, error) {
+	body := []byte("{}")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return upstreamCredentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", ecrGetAuthorizationTokenTarget)
+
+	signer := v4.NewSigner(p.session.Config.Credentials)
+	if _, err := signer.Sign(req, bytes.NewReader(body), "ecr", p.region, time.Now()); err != nil {
+		return upstreamCredentials{}, fmt.Errorf("unable to sign ecr request: %v", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return upstreamCredentials{}, err
+	}
+	defer resp.Body.Close()
+
+	payload, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return upstreamCredentials{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return upstreamCredentials{}, fmt.Errorf("ecr GetAuthorizationToken failed: %s: %s", resp.Status, payload)
+	}
+
+	var out struct {
+		AuthorizationData []struct {
+			AuthorizationToken string  `json:"authorizationToken"`
+			ExpiresAt          float64 `json:"expiresAt"`
+		} `json:"authorizationData"`
+	}
+	if err := json.Unmarshal(payload, &out); err != nil {
+		return upstreamCredentials{}, fmt.Errorf("unable to decode ecr authorization data: %v", err)
+	}
+	if len(out.AuthorizationData) == 0 {
+		return upstreamCredentials{}, fmt.Errorf("ecr returned no authorization data")
+	}
+
+	data := out.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return upstreamCredentials{}, fmt.Errorf("unable to decode ecr authorization token: %v", err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return upstreamCredentials{}, fmt.Errorf("malformed ecr authorization token")
+	}
+
+	return upstreamCredentials{
+		username: username,
+		password: password,
+		expiry:   time.Unix(int64(data.ExpiresAt), 0),
+	}, nil
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+const (
+	// gcrUsername is the username Google Container Registry and Artifact
+	// Registry expect alongside an OAuth2 access token.
+	gcrUsername = "oauth2accesstoken"
+
+	gcrScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// gcrCredentialProvider mints credentials for Google Container Registry or
+// Artifact Registry from Google application default credentials, which
+// cover service account key files, workload identity and the metadata
+// server of GCE and GKE.
+type gcrCredentialProvider struct {
+	tokenSource oauth2.TokenSource
+}
+
+func newGCRCredentialProvider(options configuration.Parameters) (credentialProvider, error) {
+	ctx := context.Background()
+
+	keyfile, err := stringOption(options, "keyfile", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var ts oauth2.TokenSource
+	if keyfile != "" {
+		data, err := ioutil.ReadFile(keyfile)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := google.CredentialsFromJSON(ctx, data, gcrScope)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse google credentials %s: %v", keyfile, err)
+		}
+		ts = creds.TokenSource
+	} else {
+		ts, err = google.DefaultTokenSource(ctx, gcrScope)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find google default credentials: %v", err)
+		}
+	}
+
+	return &gcrCredentialProvider{tokenSource: oauth2.ReuseTokenSource(nil, ts)}, nil
+}
+
+func (p *gcrCredentialProvider) credentials(ctx context.Context) (upstreamCredentials, error) {
+	token, err := p.tokenSource.Token()
+	if err != nil {
+		return upstreamCredentials{}, err
+	}
+
+	return upstreamCredentials{
+		username: gcrUsername,
+		password: token.AccessToken,
+		expiry:   token.Expiry,
+	}, nil
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+type testCredentialProvider struct {
+	minted int
+	ttl    time.Duration
+	err    error
+}
+
+func (p *testCredentialProvider) credentials(ctx context.Context) (upstreamCredentials, error) {
+	if p.err != nil {
+		return upstreamCredentials{}, p.err
+	}
+	p.minted++
+	return upstreamCredentials{
+		username: "user",
+		password: fmt.Sprintf("secret-%d", p.minted),
+		expiry:   time.Now().Add(p.ttl),
+	}, nil
+}
+
+func TestProviderCredentialsRefresh(t *testing.T) {
+	provider := &testCredentialProvider{ttl: time.Hour}
+	pc := &providerCredentials{provider: provider}
+
+	for i := 0; i < 3; i++ {
+		if _, password := pc.Basic(nil); password != "secret-1" {
+			t.Fatalf("expected cached credentials, got %q", password)
+		}
+	}
+
+	// Credentials about to expire are replaced.
+	pc.current.expiry = time.Now().Add(time.Minute)
+	if _, password := pc.Basic(nil); password != "secret-2" {
+		t.Fatalf("expected refreshed credentials, got %q", password)
+	}
+
+	// A failing provider keeps the current credentials while they are
+	// still valid.
+	pc.current.expiry = time.Now().Add(time.Minute)
+	provider.err = errors.New("metadata server unavailable")
+	if _, password := pc.Basic(nil); password != "secret-2" {
+		t.Fatalf("expected current credentials on refresh failure, got %q", password)
+	}
+
+	pc.current.expiry = time.Now().Add(-time.Minute)
+	if username, password := pc.Basic(nil); username != "" || password != "" {
+		t.Fatalf("expected no credentials once expired, got %q:%q", username, password)
+	}
+}
+
+func TestECRRegion(t *testing.T) {
+	for host, region := range map[string]string{
+		"123456789012.dkr.ecr.us-east-1.amazonaws.com":     "us-east-1",
+		"123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn": "cn-north-1",
+		"registry-1.docker.io":                             "",
+	} {
+		if got := ecrRegion(host); got != region {
+			t.Errorf("%s: expected region %q, got %q", host, region, got)
+		}
+	}
+}
+
+func TestECRCredentialProvider(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+
+	expiresAt := time.Now().Add(12 * time.Hour).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != ecrGetAuthorizationTokenTarget {
+			t.Errorf("unexpected target %q", r.Header.Get("X-Amz-Target"))
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIDEXAMPLE/") {
+			t.Errorf("request not signed with ambient credentials: %q", r.Header.Get("Authorization"))
+		}
+		token := base64.StdEncoding.EncodeToString([]byte("AWS:ecr-password"))
+		fmt.Fprintf(w, `{"authorizationData":[{"authorizationToken":%q,"expiresAt":%d}]}`, token, expiresAt)
+	}))
+	defer server.Close()
+
+	remoteURL, _ := url.Parse("https://123456789012.dkr.ecr.eu-west-1.amazonaws.com")
+	provider, err := newECRCredentialProvider(configuration.Parameters{"endpoint": server.URL}, remoteURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region := provider.(*ecrCredentialProvider).region; region != "eu-west-1" {
+		t.Fatalf("expected region from registry host, got %q", region)
+	}
+
+	creds, err := provider.credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.username != "AWS" || creds.password != "ecr-password" {
+		t.Fatalf("unexpected credentials %q:%q", creds.username, creds.password)
+	}
+	if creds.expiry.Unix() != expiresAt {
+		t.Fatalf("unexpected expiry %v", creds.expiry)
+	}
+}
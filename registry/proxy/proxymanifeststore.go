@@ -2,11 +2,14 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/opencontainers/go-digest"
 )
@@ -21,10 +24,24 @@ type proxyManifestStore struct {
 	repositoryName  reference.Named
 	scheduler       *scheduler.TTLExpirationScheduler
 	authChallenger  authChallenger
+	budget          *pullBudget
 }
 
 var _ distribution.ManifestService = &proxyManifestStore{}
 
+// inflightManifest is a manifest being fetched from the remote.
+type inflightManifest struct {
+	done     chan struct{} // closed once manifest and err are set
+	manifest distribution.Manifest
+	err      error
+}
+
+// inflightManifests tracks currently downloading manifests
+var inflightManifests = make(map[digest.Digest]*inflightManifest)
+
+// inflightManifestsMu protects inflightManifests
+var inflightManifestsMu sync.Mutex
+
 func (pms proxyManifestStore) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
 	exists, err := pms.localManifests.Exists(ctx, dgst)
 	if err != nil {
@@ -42,18 +59,16 @@ func (pms proxyManifestStore) Exists(ctx context.Context, dgst digest.Digest) (b
 func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
 	// At this point `dgst` was either specified explicitly, or returned by the
 	// tagstore with the most recent association.
-	var fromRemote bool
 	manifest, err := pms.localManifests.Get(ctx, dgst, options...)
 	if err != nil {
 		if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
 			return nil, err
 		}
 
-		manifest, err = pms.remoteManifests.Get(ctx, dgst, options...)
+		manifest, err = pms.fetch(ctx, dgst, options...)
 		if err != nil {
 			return nil, err
 		}
-		fromRemote = true
 	}
 
 	_, payload, err := manifest.Payload()
@@ -62,28 +77,90 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 	}
 
 	proxyMetrics.ManifestPush(uint64(len(payload)))
-	if fromRemote {
-		proxyMetrics.ManifestPull(uint64(len(payload)))
 
-		_, err = pms.localManifests.Put(ctx, manifest)
-		if err != nil {
-			return nil, err
+	return manifest, err
+}
+
+// fetch fetches a manifest from the remote and stores it locally. Concurrent
+// fetches of the same manifest wait for the first one, rather than each
+// fetching the manifest from the remote.
+func (pms proxyManifestStore) fetch(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	for {
+		inflightManifestsMu.Lock()
+		fetch, ok := inflightManifests[dgst]
+		if !ok {
+			fetch = &inflightManifest{done: make(chan struct{})}
+			inflightManifests[dgst] = fetch
 		}
+		inflightManifestsMu.Unlock()
 
-		// Schedule the manifest blob for removal
-		repoBlob, err := reference.WithDigest(pms.repositoryName, dgst)
-		if err != nil {
-			dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
-			return nil, err
+		if !ok {
+			fetch.manifest, fetch.err = pms.storeRemote(ctx, dgst, options...)
+
+			inflightManifestsMu.Lock()
+			delete(inflightManifests, dgst)
+			inflightManifestsMu.Unlock()
+			close(fetch.done)
+
+			return fetch.manifest, fetch.err
+		}
+
+		select {
+		case <-fetch.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// A fetch abandoned by its own client says nothing about the
+		// manifest, so try again.
+		if errors.Is(fetch.err, context.Canceled) || errors.Is(fetch.err, context.DeadlineExceeded) {
+			continue
 		}
 
-		pms.scheduler.AddManifest(repoBlob, repositoryTTL)
-		// Ensure the manifest blob is cleaned up
-		//pms.scheduler.AddBlob(blobRef, repositoryTTL)
+		return fetch.manifest, fetch.err
+	}
+}
 
+// storeRemote gets a manifest from the remote and stores it locally.
+func (pms proxyManifestStore) storeRemote(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	if !pms.budget.take(time.Now()) {
+		return nil, errcode.ErrorCodeTooManyRequests.WithMessage("pull budget of the remote registry exhausted")
 	}
 
-	return manifest, err
+	manifest, err := pms.remoteManifests.Get(ctx, dgst, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	proxyMetrics.ManifestPull(uint64(len(payload)))
+
+	_, err = pms.localManifests.Put(ctx, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a scheduler, cached content is kept rather than expired.
+	if pms.scheduler == nil {
+		return manifest, nil
+	}
+
+	// Schedule the manifest blob for removal
+	repoBlob, err := reference.WithDigest(pms.repositoryName, dgst)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
+		return nil, err
+	}
+
+	pms.scheduler.AddManifest(repoBlob, repositoryTTL)
+	// Ensure the manifest blob is cleaned up
+	//pms.scheduler.AddBlob(blobRef, repositoryTTL)
+
+	return manifest, nil
 }
 
 func (pms proxyManifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
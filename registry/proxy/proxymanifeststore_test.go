@@ -4,7 +4,9 @@ import (
 	"context"
 	"io"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/manifest"
@@ -273,3 +275,47 @@ func TestProxyManifests(t *testing.T) {
 	}
 
 }
+
+// gatedManifests counts remote fetches and holds them back until released.
+type gatedManifests struct {
+	distribution.ManifestService
+	release chan struct{}
+	gets    int32
+}
+
+func (gm *gatedManifests) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	atomic.AddInt32(&gm.gets, 1)
+	<-gm.release
+	return gm.ManifestService.Get(ctx, dgst, options...)
+}
+
+func TestProxyManifestsCoalesced(t *testing.T) {
+	env := newManifestStoreTestEnv(t, "foo/bar", "latest")
+	remote := &gatedManifests{
+		ManifestService: env.manifests.remoteManifests.(statsManifest).manifests,
+		release:         make(chan struct{}),
+	}
+	env.manifests.remoteManifests = remote
+	env.manifests.localManifests = env.manifests.localManifests.(statsManifest).manifests
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := env.manifests.Get(ctx, env.manifestDigest); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// Let the clients pile up behind the first fetch.
+	time.Sleep(50 * time.Millisecond)
+	close(remote.release)
+	wg.Wait()
+
+	if gets := atomic.LoadInt32(&remote.gets); gets != 1 {
+		t.Fatalf("expected a single remote fetch, got %d", gets)
+	}
+}
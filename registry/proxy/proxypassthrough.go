@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/opencontainers/go-digest"
+)
+
+// newPassthroughRepository returns a repository serving content straight
+// from the remote, without caching it.
+func newPassthroughRepository(ctx context.Context, name reference.Named, remoteRepo distribution.Repository, remoteManifests distribution.ManifestService, c authChallenger, budget *pullBudget) distribution.Repository {
+	return &proxiedRepository{
+		blobStore: &passthroughBlobStore{
+			proxyBlobStore: proxyBlobStore{
+				remoteStore:    remoteRepo.Blobs(ctx),
+				repositoryName: name,
+				authChallenger: c,
+			},
+		},
+		manifests: &passthroughManifestStore{
+			proxyManifestStore: proxyManifestStore{
+				remoteManifests: remoteManifests,
+				repositoryName:  name,
+				authChallenger:  c,
+				budget:          budget,
+			},
+		},
+		name: name,
+		tags: &passthroughTagService{
+			proxyTagService: proxyTagService{
+				remoteTags:     remoteRepo.Tags(ctx),
+				authChallenger: c,
+				budget:         budget,
+			},
+		},
+	}
+}
+
+// passthroughBlobStore serves blobs from the remote without storing them.
+type passthroughBlobStore struct {
+	proxyBlobStore
+}
+
+var _ distribution.BlobStore = &passthroughBlobStore{}
+
+func (pbs *passthroughBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	if err := pbs.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	return pbs.remoteStore.Stat(ctx, dgst)
+}
+
+func (pbs *passthroughBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	if err := pbs.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return []byte{}, err
+	}
+
+	return pbs.remoteStore.Get(ctx, dgst)
+}
+
+func (pbs *passthroughBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	desc, err := pbs.Stat(ctx, dgst)
+	if err != nil {
+		return err
+	}
+
+	return pbs.serveRemote(ctx, w, desc)
+}
+
+// passthroughManifestStore serves manifests from the remote without storing
+// them.
+type passthroughManifestStore struct {
+	proxyManifestStore
+}
+
+var _ distribution.ManifestService = &passthroughManifestStore{}
+
+func (pms *passthroughManifestStore) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return false, err
+	}
+
+	return pms.remoteManifests.Exists(ctx, dgst)
+}
+
+func (pms *passthroughManifestStore) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	if err := pms.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return nil, err
+	}
+
+	if !pms.budget.take(time.Now()) {
+		return nil, errcode.ErrorCodeTooManyRequests.WithMessage("pull budget of the remote registry exhausted")
+	}
+
+	manifest, err := pms.remoteManifests.Get(ctx, dgst, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	proxyMetrics.ManifestPush(uint64(len(payload)))
+	return manifest, nil
+}
+
+// passthroughTagService resolves tags on the remote without storing them.
+type passthroughTagService struct {
+	proxyTagService
+}
+
+var _ distribution.TagService = &passthroughTagService{}
+
+func (pt *passthroughTagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	if err := pt.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	return pt.remoteTags.Get(ctx, tag)
+}
+
+func (pt *passthroughTagService) Untag(ctx context.Context, tag string) error {
+	return distribution.ErrUnsupported
+}
+
+func (pt *passthroughTagService) All(ctx context.Context) ([]string, error) {
+	if err := pt.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return nil, err
+	}
+
+	return pt.remoteTags.All(ctx)
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestMatchesNamespace(t *testing.T) {
+	namespaces := []string{"internal", "library/alpine/"}
+
+	for name, expected := range map[string]bool{
+		"internal":             true,
+		"internal/app":         true,
+		"internal/team/app":    true,
+		"internalapp":          false,
+		"library/alpine":       true,
+		"library/alpinelinux":  false,
+		"library/ubuntu":       false,
+		"someone/internal/app": false,
+	} {
+		if matchesNamespace(name, namespaces) != expected {
+			t.Errorf("%s: expected match %v", name, expected)
+		}
+	}
+}
+
+func TestPassthroughServeBlob(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+	populate(t, te, 1, 200, 1)
+
+	pbs := &passthroughBlobStore{
+		proxyBlobStore: proxyBlobStore{
+			remoteStore:    te.store.remoteStore,
+			repositoryName: te.store.repositoryName,
+			authChallenger: &mockChallenger{},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := pbs.ServeBlob(te.ctx, w, r, te.inRemote[0].Digest); err != nil {
+			t.Fatal(err)
+		}
+		if digest.FromBytes(w.Body.Bytes()) != te.inRemote[0].Digest {
+			t.Fatal("Mismatching blob fetch from passthrough")
+		}
+	}
+
+	remoteStats, localStats := te.RemoteStats(), te.LocalStats()
+	sbsMu.Lock()
+	defer sbsMu.Unlock()
+	if opens := (*remoteStats)["open"]; opens != 2 {
+		t.Fatalf("expected every request to go to the remote, got %d opens", opens)
+	}
+	if len(*localStats) != 0 {
+		t.Fatalf("expected local storage to be left alone, got %v", *localStats)
+	}
+}
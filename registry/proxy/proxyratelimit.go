@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+// defaultRateLimitWindow is the period a configured pull budget applies to,
+// when neither the configuration nor the remote specify one. It matches the
+// window of the Docker Hub rate limits.
+const defaultRateLimitWindow = 6 * time.Hour
+
+var (
+	// rateLimitLimit is the pull quota advertised by the remote.
+	rateLimitLimit = prometheus.ProxyNamespace.NewGauge("ratelimit_limit", "The number of manifest pulls the remote allows per rate limit window", metrics.Unit("pulls"))
+
+	// rateLimitRemaining is the quota left according to the remote.
+	rateLimitRemaining = prometheus.ProxyNamespace.NewGauge("ratelimit_remaining", "The number of manifest pulls the remote has left in the current rate limit window", metrics.Unit("pulls"))
+
+	// rateLimitBudget is the number of pulls the proxy still allows
+	// itself, accounting for the configured budget and reserve.
+	rateLimitBudget = prometheus.ProxyNamespace.NewGauge("ratelimit_budget", "The number of manifest pulls from the remote the proxy allows itself in the current window", metrics.Unit("pulls"))
+
+	// rateLimitDeferred counts the pulls not made to stay within budget,
+	// labeled by whether cached content was served instead.
+	rateLimitDeferred = prometheus.ProxyNamespace.NewLabeledCounter("ratelimit_deferred", "The number of manifest pulls from the remote deferred to stay within the pull budget", "outcome")
+)
+
+func init() {
+	metrics.Register(prometheus.ProxyNamespace)
+}
+
+// pullBudget keeps the manifest pulls made from the remote within a budget,
+// so that the proxy does not exhaust the pull quota of the account it uses.
+// The budget is the configured number of pulls per window, the quota left
+// according to the RateLimit headers of the remote, or the lower of both.
+type pullBudget struct {
+	budget  int
+	window  time.Duration
+	reserve int
+
+	mu          sync.Mutex
+	used        int           // pulls made in the current window of the budget
+	windowStart time.Time     // start of the current window of the budget
+	remaining   int           // quota left according to the remote, -1 if unknown
+	quotaWindow time.Duration // window of the quota of the remote, if known
+	lastPull    time.Time
+}
+
+func newPullBudget(config configuration.ProxyRateLimit) *pullBudget {
+	window := config.Window
+	if window <= 0 {
+		window = defaultRateLimitWindow
+	}
+
+	return &pullBudget{
+		budget:    config.Budget,
+		window:    window,
+		reserve:   config.Reserve,
+		remaining: -1,
+	}
+}
+
+// available returns the number of pulls left, or -1 if there is no limit.
+// It must be called with mu held.
+func (b *pullBudget) available(now time.Time) int {
+	available := -1
+
+	if b.budget > 0 {
+		if now.Sub(b.windowStart) >= b.window {
+			b.windowStart = now
+			b.used = 0
+		}
+		available = b.budget - b.used
+	}
+
+	if b.remaining >= 0 {
+		left := b.remaining - b.reserve
+		if left < 0 {
+			left = 0
+		}
+		if available < 0 || left < available {
+			available = left
+		}
+	}
+
+	return available
+}
+
+// take records a pull of a manifest the proxy does not have, and reports
+// whether the budget allows it.
+func (b *pullBudget) take(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.available(now) == 0 {
+		rateLimitDeferred.WithValues("rejected").Inc(1)
+		return false
+	}
+
+	b.record(now)
+	return true
+}
+
+// refresh reports whether the budget allows checking the remote for a newer
+// version of content the proxy has cached. Refreshes are spread evenly over
+// the window, so that the pulls they may lead to never consume the quota
+// needed for content which is not cached.
+func (b *pullBudget) refresh(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	available := b.available(now)
+	if available < 0 {
+		return true
+	}
+
+	window := b.window
+	if b.budget <= 0 && b.quotaWindow > 0 {
+		window = b.quotaWindow
+	}
+
+	if available == 0 || now.Sub(b.lastPull) < window/time.Duration(available) {
+		rateLimitDeferred.WithValues("cached").Inc(1)
+		return false
+	}
+
+	b.lastPull = now
+	return true
+}
+
+// record accounts for a pull until the remote reports its quota again. It
+// must be called with mu held.
+func (b *pullBudget) record(now time.Time) {
+	b.used++
+	if b.remaining > 0 {
+		b.remaining--
+	}
+	b.lastPull = now
+	rateLimitBudget.Set(float64(b.available(now)))
+}
+
+// observe updates the budget from the RateLimit-Limit and
+// RateLimit-Remaining headers of a response of the remote, in the format
+// used by Docker Hub, such as "100;w=21600".
+func (b *pullBudget) observe(resp *http.Response) {
+	limit, _, ok := parseRateLimit(resp.Header.Get("RateLimit-Limit"))
+	if ok {
+		rateLimitLimit.Set(float64(limit))
+	}
+
+	remaining, window, ok := parseRateLimit(resp.Header.Get("RateLimit-Remaining"))
+	if !ok {
+		return
+	}
+	rateLimitRemaining.Set(float64(remaining))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remaining = remaining
+	if window > 0 {
+		b.quotaWindow = window
+	}
+	rateLimitBudget.Set(float64(b.available(time.Now())))
+}
+
+// parseRateLimit parses a rate limit header value, made of a quota and an
+// optional window in seconds.
+func parseRateLimit(value string) (int, time.Duration, bool) {
+	if value == "" {
+		return 0, 0, false
+	}
+
+	parts := strings.Split(value, ";")
+	quota, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || quota < 0 {
+		return 0, 0, false
+	}
+
+	var window time.Duration
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "w=") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(param[len("w="):]); err == nil {
+			window = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return quota, window, true
+}
+
+// rateLimitTransport observes the rate limit headers of the responses of
+// the remote.
+type rateLimitTransport struct {
+	base   http.RoundTripper
+	budget *pullBudget
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.budget.observe(resp)
+	}
+	return resp, err
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		value  string
+		quota  int
+		window time.Duration
+		ok     bool
+	}{
+		{value: "100;w=21600", quota: 100, window: 6 * time.Hour, ok: true},
+		{value: "76", quota: 76, ok: true},
+		{value: " 5 ; w=60", quota: 5, window: time.Minute, ok: true},
+		{value: ""},
+		{value: "unlimited"},
+	} {
+		quota, window, ok := parseRateLimit(tc.value)
+		if quota != tc.quota || window != tc.window || ok != tc.ok {
+			t.Errorf("%q: got %d, %v, %v", tc.value, quota, window, ok)
+		}
+	}
+}
+
+func TestPullBudget(t *testing.T) {
+	now := time.Now()
+	b := newPullBudget(configuration.ProxyRateLimit{Budget: 2, Window: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		if !b.take(now) {
+			t.Fatalf("pull %d should be within budget", i)
+		}
+	}
+	if b.take(now) {
+		t.Fatal("expected budget to be exhausted")
+	}
+	if b.refresh(now) {
+		t.Fatal("expected no refresh with an exhausted budget")
+	}
+
+	// The budget is renewed with the window.
+	if !b.take(now.Add(time.Hour)) {
+		t.Fatal("expected budget to be renewed")
+	}
+}
+
+func TestPullBudgetObserve(t *testing.T) {
+	b := newPullBudget(configuration.ProxyRateLimit{Reserve: 10})
+	now := time.Now()
+
+	if !b.refresh(now) || !b.take(now) {
+		t.Fatal("expected no limit before the remote reports its quota")
+	}
+
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("RateLimit-Limit", "100;w=21600")
+	resp.Header.Set("RateLimit-Remaining", "14;w=21600")
+	b.observe(resp)
+
+	// 4 pulls are left above the reserve, so refreshes are spread 90
+	// minutes apart.
+	if b.refresh(now.Add(time.Hour)) {
+		t.Fatal("expected refresh to be deferred")
+	}
+	if !b.refresh(now.Add(90 * time.Minute)) {
+		t.Fatal("expected refresh once spread interval has passed")
+	}
+	if b.refresh(now.Add(2 * time.Hour)) {
+		t.Fatal("expected refresh to be deferred")
+	}
+
+	for i := 0; i < 4; i++ {
+		if !b.take(now) {
+			t.Fatalf("pull %d should be within the quota", i)
+		}
+	}
+	if b.take(now) {
+		t.Fatal("expected the reserve to be left untouched")
+	}
+}
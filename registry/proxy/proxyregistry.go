@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 
 	"github.com/distribution/distribution/v3"
@@ -18,6 +19,7 @@ import (
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/gomodule/redigo/redis"
 )
 
 // proxyingRegistry fetches content from a remote registry and caches it locally
@@ -26,17 +28,42 @@ type proxyingRegistry struct {
 	scheduler      *scheduler.TTLExpirationScheduler
 	remoteURL      url.URL
 	authChallenger authChallenger
+	budget         *pullBudget
+	revalidate     bool
+	local          []string
+	passthrough    []string
 }
 
-// NewRegistryPullThroughCache creates a registry acting as a pull through cache
-func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
+const (
+	// schedulerStatePath is where the scheduler state is kept with the
+	// storage driver, one file per shard.
+	schedulerStatePath = "/scheduler"
+
+	// legacySchedulerStatePath is the single file the scheduler state was
+	// kept in before it was sharded. It is migrated on start.
+	legacySchedulerStatePath = "/scheduler-state.json"
+
+	// schedulerRedisPrefix names the redis hashes of the scheduler state.
+	schedulerRedisPrefix = "registry:proxy:scheduler"
+
+	defaultSchedulerShards = 16
+)
+
+// NewRegistryPullThroughCache creates a registry acting as a pull through cache.
+// The redis pool is only required when the scheduler state is kept in redis.
+func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, pool *redis.Pool, config configuration.Proxy) (distribution.Namespace, error) {
 	remoteURL, err := url.Parse(config.RemoteURL)
 	if err != nil {
 		return nil, err
 	}
 
+	store, err := newSchedulerStore(driver, pool, config.Scheduler)
+	if err != nil {
+		return nil, err
+	}
+
 	v := storage.NewVacuum(ctx, driver)
-	s := scheduler.New(ctx, driver, "/scheduler-state.json")
+	s := scheduler.NewWithStore(ctx, store)
 	s.OnBlobExpire(func(ref reference.Reference) error {
 		var r reference.Canonical
 		var ok bool
@@ -92,16 +119,29 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 	if err != nil {
 		return nil, err
 	}
-
-	cs, err := configureAuth(config.Username, config.Password, config.RemoteURL)
+	setActiveScheduler(s)
+
+	var cs auth.CredentialStore
+	switch {
+	case config.CredentialProvider.Name != "":
+		cs, err = configureProviderAuth(config.CredentialProvider, remoteURL)
+	case config.Username == "" && config.DockerConfig != "":
+		cs, err = configureDockerConfigAuth(config.DockerConfig, config.RemoteURL)
+	default:
+		cs, err = configureAuth(config.Username, config.Password, config.RemoteURL)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	return &proxyingRegistry{
-		embedded:  registry,
-		scheduler: s,
-		remoteURL: *remoteURL,
+		embedded:    registry,
+		scheduler:   s,
+		remoteURL:   *remoteURL,
+		budget:      newPullBudget(config.RateLimit),
+		revalidate:  config.Revalidate,
+		local:       config.Local,
+		passthrough: config.Passthrough,
 		authChallenger: &remoteAuthChallenger{
 			remoteURL: *remoteURL,
 			cm:        challenge.NewSimpleManager(),
@@ -110,6 +150,30 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 	}, nil
 }
 
+func newSchedulerStore(driver driver.StorageDriver, pool *redis.Pool, config configuration.ProxyScheduler) (scheduler.Store, error) {
+	shards := config.Shards
+	if shards <= 0 {
+		shards = defaultSchedulerShards
+	}
+
+	switch config.Store {
+	case "", "storage":
+		return scheduler.NewDriverStore(driver, schedulerStatePath, shards, legacySchedulerStatePath), nil
+	case "redis":
+		if pool == nil {
+			return nil, fmt.Errorf("redis configuration required to keep the proxy scheduler state in redis")
+		}
+		return scheduler.NewRedisStore(pool, schedulerRedisPrefix, shards), nil
+	case "boltdb":
+		if config.Path == "" {
+			return nil, fmt.Errorf("path required to keep the proxy scheduler state in boltdb")
+		}
+		return scheduler.NewBoltStore(config.Path, shards)
+	default:
+		return nil, fmt.Errorf("unknown proxy scheduler store: %q", config.Store)
+	}
+}
+
 func (pr *proxyingRegistry) Scope() distribution.Scope {
 	return distribution.GlobalScope
 }
@@ -119,6 +183,10 @@ func (pr *proxyingRegistry) Repositories(ctx context.Context, repos []string, la
 }
 
 func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	if matchesNamespace(name.Name(), pr.local) {
+		return pr.embedded.Repository(ctx, name)
+	}
+
 	c := pr.authChallenger
 
 	tkopts := auth.TokenHandlerOptions{
@@ -133,7 +201,7 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		Logger: dcontext.GetLogger(ctx),
 	}
 
-	tr := transport.NewTransport(http.DefaultTransport,
+	tr := transport.NewTransport(&rateLimitTransport{base: http.DefaultTransport, budget: pr.budget},
 		auth.NewAuthorizer(c.challengeManager(),
 			auth.NewTokenHandlerWithOptions(tkopts)))
 
@@ -156,31 +224,71 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
+	if matchesNamespace(name.Name(), pr.passthrough) {
+		return newPassthroughRepository(ctx, name, remoteRepo, remoteManifests, pr.authChallenger, pr.budget), nil
+	}
+
+	s := pr.scheduler
+	if pr.revalidate {
+		// Revalidated content is kept rather than expired.
+		s = nil
+	}
+
+	manifests := &proxyManifestStore{
+		repositoryName:  name,
+		localManifests:  localManifests, // Options?
+		remoteManifests: remoteManifests,
+		ctx:             ctx,
+		scheduler:       s,
+		authChallenger:  pr.authChallenger,
+		budget:          pr.budget,
+	}
+
+	tags := &proxyTagService{
+		localTags:      localRepo.Tags(ctx),
+		remoteTags:     remoteRepo.Tags(ctx),
+		authChallenger: pr.authChallenger,
+		budget:         pr.budget,
+	}
+	if pr.revalidate {
+		tags.revalidator = &tagRevalidator{
+			repositoryName: name,
+			manifests:      manifests,
+		}
+	}
+
 	return &proxiedRepository{
 		blobStore: &proxyBlobStore{
 			localStore:     localRepo.Blobs(ctx),
 			remoteStore:    remoteRepo.Blobs(ctx),
-			scheduler:      pr.scheduler,
+			scheduler:      s,
 			repositoryName: name,
 			authChallenger: pr.authChallenger,
 		},
-		manifests: &proxyManifestStore{
-			repositoryName:  name,
-			localManifests:  localManifests, // Options?
-			remoteManifests: remoteManifests,
-			ctx:             ctx,
-			scheduler:       pr.scheduler,
-			authChallenger:  pr.authChallenger,
-		},
-		name: name,
-		tags: &proxyTagService{
-			localTags:      localRepo.Tags(ctx),
-			remoteTags:     remoteRepo.Tags(ctx),
-			authChallenger: pr.authChallenger,
-		},
+		manifests: manifests,
+		name:      name,
+		tags:      tags,
 	}, nil
 }
 
+// LocalOnly reports whether a pull through cache with the given
+// configuration serves the named repository from local storage only.
+func LocalOnly(config configuration.Proxy, name string) bool {
+	return matchesNamespace(name, config.Local)
+}
+
+// matchesNamespace reports whether name is one of namespaces, or the name of
+// a repository within one of them.
+func matchesNamespace(name string, namespaces []string) bool {
+	for _, ns := range namespaces {
+		ns = strings.Trim(ns, "/")
+		if name == ns || strings.HasPrefix(name, ns+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func (pr *proxyingRegistry) Blobs() distribution.BlobEnumerator {
 	return pr.embedded.Blobs()
 }
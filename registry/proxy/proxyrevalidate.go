@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+)
+
+// revalidationTimeout bounds the time spent checking the remote for a newer
+// version of a tag and fetching it.
+const revalidationTimeout = 5 * time.Minute
+
+// revalidations tracks the tags currently being revalidated, by repository
+// and tag
+var revalidations = make(map[string]struct{})
+
+// revalidationsMu protects revalidations
+var revalidationsMu sync.Mutex
+
+// tagRevalidator refreshes cached tags of a repository from the remote in
+// the background. A tag is only moved once the manifest it points to on the
+// remote has been cached, so that the cache keeps serving complete content
+// when the remote becomes unavailable.
+type tagRevalidator struct {
+	repositoryName reference.Named
+	manifests      distribution.ManifestService
+}
+
+// revalidate checks the remote for a newer version of tag, which is cached
+// with desc, unless a check of the same tag is already in progress.
+func (tr *tagRevalidator) revalidate(ctx context.Context, pt proxyTagService, tag string, desc distribution.Descriptor) {
+	key := tr.repositoryName.Name() + ":" + tag
+
+	revalidationsMu.Lock()
+	if _, ok := revalidations[key]; ok {
+		revalidationsMu.Unlock()
+		return
+	}
+	revalidations[key] = struct{}{}
+	revalidationsMu.Unlock()
+
+	logger := dcontext.GetLogger(ctx)
+	go func() {
+		defer func() {
+			revalidationsMu.Lock()
+			delete(revalidations, key)
+			revalidationsMu.Unlock()
+		}()
+
+		// The revalidation outlives the request which triggered it.
+		ctx, cancel := context.WithTimeout(dcontext.WithLogger(context.Background(), logger), revalidationTimeout)
+		defer cancel()
+
+		if err := tr.refresh(ctx, pt, tag, desc); err != nil {
+			logger.Warnf("Error revalidating tag %s: %v", key, err)
+		}
+	}()
+}
+
+func (tr *tagRevalidator) refresh(ctx context.Context, pt proxyTagService, tag string, desc distribution.Descriptor) error {
+	if !pt.budget.refresh(time.Now()) {
+		return nil
+	}
+
+	if err := pt.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return err
+	}
+
+	remoteDesc, err := pt.remoteTags.Get(ctx, tag)
+	if err != nil {
+		return err
+	}
+
+	if remoteDesc.Digest == desc.Digest {
+		return nil
+	}
+
+	if _, err := tr.manifests.Get(ctx, remoteDesc.Digest); err != nil {
+		return err
+	}
+
+	return pt.localTags.Tag(ctx, tag, remoteDesc)
+}
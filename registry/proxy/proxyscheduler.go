@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+)
+
+var (
+	// activeScheduler is the scheduler of the pull through cache last
+	// created, reported at /debug/vars and expired on demand at
+	// /debug/proxy/scheduler.
+	activeSchedulerMu sync.Mutex
+	activeScheduler   *scheduler.TTLExpirationScheduler
+)
+
+func setActiveScheduler(s *scheduler.TTLExpirationScheduler) {
+	activeSchedulerMu.Lock()
+	defer activeSchedulerMu.Unlock()
+	activeScheduler = s
+}
+
+func getActiveScheduler() *scheduler.TTLExpirationScheduler {
+	activeSchedulerMu.Lock()
+	defer activeSchedulerMu.Unlock()
+	return activeScheduler
+}
+
+// SchedulerHandler expires, on POST, the cached objects expiring within the
+// duration of the within query parameter, or only the overdue ones without
+// it, and responds with the number of objects expired and the state of the
+// scheduler.
+func SchedulerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s := getActiveScheduler()
+	if s == nil {
+		http.Error(w, "the registry is not a pull through cache", http.StatusNotFound)
+		return
+	}
+
+	var within time.Duration
+	if v := r.URL.Query().Get("within"); v != "" {
+		var err error
+		within, err = time.ParseDuration(v)
+		if err != nil || within < 0 {
+			http.Error(w, "within must be a non-negative duration", http.StatusBadRequest)
+			return
+		}
+	}
+	n, err := s.ExpireBefore(time.Now().Add(within))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Expired   int
+		Scheduler scheduler.Stats
+	}{n, s.Stats()})
+}
+
+func init() {
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+
+	pm := registry.(*expvar.Map).Get("proxy")
+	if pm == nil {
+		pm = &expvar.Map{}
+		pm.(*expvar.Map).Init()
+		registry.(*expvar.Map).Set("proxy", pm)
+	}
+
+	pm.(*expvar.Map).Set("scheduler", expvar.Func(func() interface{} {
+		if s := getActiveScheduler(); s != nil {
+			return s.Stats()
+		}
+		return nil
+	}))
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 )
@@ -11,14 +12,35 @@ type proxyTagService struct {
 	localTags      distribution.TagService
 	remoteTags     distribution.TagService
 	authChallenger authChallenger
+	budget         *pullBudget
+
+	// revalidator, when set, refreshes cached tags in the background
+	// rather than before serving them.
+	revalidator *tagRevalidator
 }
 
 var _ distribution.TagService = proxyTagService{}
 
 // Get attempts to get the most recent digest for the tag by checking the remote
 // tag service first and then caching it locally.  If the remote is unavailable
-// the local association is returned
+// the local association is returned. The remote is not checked for tags cached
+// locally when that would exceed the pull budget of the remote. When
+// revalidating, cached tags are returned right away and checked in the
+// background.
 func (pt proxyTagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	if pt.revalidator != nil {
+		if desc, err := pt.localTags.Get(ctx, tag); err == nil {
+			pt.revalidator.revalidate(ctx, pt, tag, desc)
+			return desc, nil
+		}
+	}
+
+	if !pt.budget.refresh(time.Now()) {
+		if desc, err := pt.localTags.Get(ctx, tag); err == nil {
+			return desc, nil
+		}
+	}
+
 	err := pt.authChallenger.tryEstablishChallenges(ctx)
 	if err == nil {
 		desc, err := pt.remoteTags.Get(ctx, tag)
@@ -6,8 +6,11 @@ import (
 	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
 )
 
 type mockTagStore struct {
@@ -180,3 +183,67 @@ func TestGet(t *testing.T) {
 		t.Fatalf("Expected 4 auth challenge calls, got %#v", proxyTags.authChallenger)
 	}
 }
+
+// mockManifests records the manifests fetched through it.
+type mockManifests struct {
+	distribution.ManifestService
+	sync.Mutex
+	fetched []digest.Digest
+}
+
+func (m *mockManifests) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.fetched = append(m.fetched, dgst)
+	return nil, nil
+}
+
+func TestGetRevalidate(t *testing.T) {
+	cachedDesc := distribution.Descriptor{Digest: digest.FromString("cached")}
+	remoteDesc := distribution.Descriptor{Digest: digest.FromString("remote")}
+	proxyTags := testProxyTagService(
+		map[string]distribution.Descriptor{"latest": cachedDesc},
+		map[string]distribution.Descriptor{"latest": remoteDesc},
+	)
+
+	name, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests := &mockManifests{}
+	proxyTags.revalidator = &tagRevalidator{repositoryName: name, manifests: manifests}
+
+	ctx := context.Background()
+
+	// The cached tag is served without waiting for the remote.
+	d, err := proxyTags.Get(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Digest != cachedDesc.Digest {
+		t.Fatalf("expected cached descriptor, got %v", d.Digest)
+	}
+
+	// The tag is moved once the new manifest has been fetched.
+	deadline := time.Now().Add(time.Second)
+	for {
+		local, err := proxyTags.localTags.Get(ctx, "latest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if local.Digest == remoteDesc.Digest {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("tag was not revalidated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	manifests.Lock()
+	defer manifests.Unlock()
+	if len(manifests.fetched) != 1 || manifests.fetched[0] != remoteDesc.Digest {
+		t.Fatalf("expected new manifest to be fetched, got %v", manifests.fetched)
+	}
+}
@@ -2,14 +2,29 @@ package scheduler
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
 
 	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/docker/go-metrics"
+)
+
+var (
+	// scheduledEntries counts the entries of the scheduler by type, as of
+	// the last save of its state.
+	scheduledEntries = prometheus.ProxyNamespace.NewLabeledGauge("scheduler_entries", "The number of cached objects scheduled to expire", "", "type")
+
+	// nextExpiry is the time the earliest entry of the scheduler expires,
+	// as of the last save of its state.
+	nextExpiry = prometheus.ProxyNamespace.NewGauge("scheduler_next_expiry", "The time the next cached object expires, in seconds since the epoch", metrics.Seconds)
+
+	// expiries counts the entries expired, labeled by their type and whether
+	// acting upon them failed.
+	expiries = prometheus.ProxyNamespace.NewLabeledCounter("scheduler_expiries", "The number of cached objects expired", "type", "result")
 )
 
 // onTTLExpiryFunc is called when a repository's TTL expires
@@ -21,6 +36,12 @@ const (
 	indexSaveFrequency = 5 * time.Second
 )
 
+// entryTypeNames are the names of the entry types in metrics.
+var entryTypeNames = map[int]string{
+	entryTypeBlob:     "blob",
+	entryTypeManifest: "manifest",
+}
+
 // schedulerEntry represents an entry in the scheduler
 // fields are exported for serialization
 type schedulerEntry struct {
@@ -31,16 +52,23 @@ type schedulerEntry struct {
 	timer *time.Timer
 }
 
-// New returns a new instance of the scheduler
+// New returns a new instance of the scheduler, keeping its state in a single
+// file written with the storage driver
 func New(ctx context.Context, driver driver.StorageDriver, path string) *TTLExpirationScheduler {
+	return NewWithStore(ctx, NewDriverStore(driver, path, 1, ""))
+}
+
+// NewWithStore returns a new instance of the scheduler, keeping its state in
+// the given store
+func NewWithStore(ctx context.Context, store Store) *TTLExpirationScheduler {
 	return &TTLExpirationScheduler{
-		entries:         make(map[string]*schedulerEntry),
-		driver:          driver,
-		pathToStateFile: path,
-		ctx:             ctx,
-		stopped:         true,
-		doneChan:        make(chan struct{}),
-		saveTimer:       time.NewTicker(indexSaveFrequency),
+		entries:   make(map[string]*schedulerEntry),
+		pending:   make(map[string]*schedulerEntry),
+		store:     store,
+		ctx:       ctx,
+		stopped:   true,
+		doneChan:  make(chan struct{}),
+		saveTimer: time.NewTicker(indexSaveFrequency),
 	}
 }
 
@@ -51,18 +79,44 @@ type TTLExpirationScheduler struct {
 
 	entries map[string]*schedulerEntry
 
-	driver          driver.StorageDriver
-	ctx             context.Context
-	pathToStateFile string
+	store Store
+	ctx   context.Context
 
 	stopped bool
 
 	onBlobExpire     expiryFunc
 	onManifestExpire expiryFunc
 
-	indexDirty bool
-	saveTimer  *time.Ticker
-	doneChan   chan struct{}
+	// pending holds the entries changed since the state was last saved,
+	// with a nil entry for the removed ones
+	pending   map[string]*schedulerEntry
+	saveTimer *time.Ticker
+	doneChan  chan struct{}
+
+	// expired and failed count the entries expired since the scheduler
+	// was created, lastExpiry is the time the last one was.
+	expired    int
+	failed     int
+	lastExpiry time.Time
+}
+
+// Stats describes the entries of a scheduler and the expiries it ran.
+type Stats struct {
+	// Blobs and Manifests are the numbers of entries scheduled to expire.
+	Blobs     int
+	Manifests int
+
+	// NextExpiry is the time the earliest entry expires, zero without
+	// entries.
+	NextExpiry time.Time
+
+	// LastExpiry is the time the last entry expired, zero if none has.
+	LastExpiry time.Time
+
+	// Expired is the number of entries expired, and Failed the number of
+	// those whose expiry function returned an error.
+	Expired int
+	Failed  int
 }
 
 // OnBlobExpire is called when a scheduled blob's TTL expires
@@ -112,15 +166,15 @@ func (ttles *TTLExpirationScheduler) Start() error {
 	ttles.Lock()
 	defer ttles.Unlock()
 
+	if !ttles.stopped {
+		return fmt.Errorf("scheduler already started")
+	}
+
 	err := ttles.readState()
 	if err != nil {
 		return err
 	}
 
-	if !ttles.stopped {
-		return fmt.Errorf("scheduler already started")
-	}
-
 	dcontext.GetLogger(ttles.ctx).Infof("Starting cached object TTL expiration scheduler...")
 	ttles.stopped = false
 
@@ -128,6 +182,7 @@ func (ttles *TTLExpirationScheduler) Start() error {
 	for _, entry := range ttles.entries {
 		entry.timer = ttles.startTimer(entry, time.Until(entry.Expiry))
 	}
+	ttles.recordStats()
 
 	// Start a ticker to periodically save the entries index
 
@@ -136,17 +191,10 @@ func (ttles *TTLExpirationScheduler) Start() error {
 			select {
 			case <-ttles.saveTimer.C:
 				ttles.Lock()
-				if !ttles.indexDirty {
-					ttles.Unlock()
-					continue
-				}
-
-				err := ttles.writeState()
-				if err != nil {
+				if err := ttles.writeState(); err != nil {
 					dcontext.GetLogger(ttles.ctx).Errorf("Error writing scheduler state: %s", err)
-				} else {
-					ttles.indexDirty = false
 				}
+				ttles.recordStats()
 				ttles.Unlock()
 
 			case <-ttles.doneChan:
@@ -170,7 +218,7 @@ func (ttles *TTLExpirationScheduler) add(r reference.Reference, ttl time.Duratio
 	}
 	ttles.entries[entry.Key] = entry
 	entry.timer = ttles.startTimer(entry, ttl)
-	ttles.indexDirty = true
+	ttles.pending[entry.Key] = entry
 }
 
 func (ttles *TTLExpirationScheduler) startTimer(entry *schedulerEntry, ttl time.Duration) *time.Timer {
@@ -178,31 +226,119 @@ func (ttles *TTLExpirationScheduler) startTimer(entry *schedulerEntry, ttl time.
 		ttles.Lock()
 		defer ttles.Unlock()
 
-		var f expiryFunc
+		ttles.expire(entry)
+	})
+}
+
+// expire calls the expiry function of entry and removes it. It must be
+// called with the lock held.
+func (ttles *TTLExpirationScheduler) expire(entry *schedulerEntry) {
+	var f expiryFunc
+
+	switch entry.EntryType {
+	case entryTypeBlob:
+		f = ttles.onBlobExpire
+	case entryTypeManifest:
+		f = ttles.onManifestExpire
+	default:
+		f = func(reference.Reference) error {
+			return fmt.Errorf("scheduler entry type")
+		}
+	}
+
+	result := "expired"
+	ref, err := reference.Parse(entry.Key)
+	if err == nil {
+		if err := f(ref); err != nil {
+			dcontext.GetLogger(ttles.ctx).Errorf("Scheduler error returned from OnExpire(%s): %s", entry.Key, err)
+			result = "failed"
+		}
+	} else {
+		dcontext.GetLogger(ttles.ctx).Errorf("Error unpacking reference: %s", err)
+		result = "failed"
+	}
+	expiries.WithValues(entryTypeNames[entry.EntryType], result).Inc(1)
+	ttles.expired++
+	if result == "failed" {
+		ttles.failed++
+	}
+	ttles.lastExpiry = time.Now()
+
+	// A newer entry for the same key replaces this one
+	if ttles.entries[entry.Key] == entry {
+		delete(ttles.entries, entry.Key)
+		ttles.pending[entry.Key] = nil
+	}
+}
+
+// ExpireBefore expires the entries which expire before t right away, rather
+// than when their TTL does, and returns the number of entries expired.
+func (ttles *TTLExpirationScheduler) ExpireBefore(t time.Time) (int, error) {
+	ttles.Lock()
+	defer ttles.Unlock()
 
+	if ttles.stopped {
+		return 0, fmt.Errorf("scheduler not started")
+	}
+
+	var due []*schedulerEntry
+	for _, entry := range ttles.entries {
+		if entry.Expiry.Before(t) {
+			due = append(due, entry)
+		}
+	}
+	n := 0
+	for _, entry := range due {
+		// An entry whose timer already fired is expired by it.
+		if entry.timer != nil && !entry.timer.Stop() {
+			continue
+		}
+		ttles.expire(entry)
+		n++
+	}
+	return n, nil
+}
+
+// Stats returns the state of the entries of the scheduler and of the
+// expiries it ran.
+func (ttles *TTLExpirationScheduler) Stats() Stats {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	return ttles.stats()
+}
+
+func (ttles *TTLExpirationScheduler) stats() Stats {
+	stats := Stats{
+		LastExpiry: ttles.lastExpiry,
+		Expired:    ttles.expired,
+		Failed:     ttles.failed,
+	}
+	for _, entry := range ttles.entries {
 		switch entry.EntryType {
 		case entryTypeBlob:
-			f = ttles.onBlobExpire
+			stats.Blobs++
 		case entryTypeManifest:
-			f = ttles.onManifestExpire
-		default:
-			f = func(reference.Reference) error {
-				return fmt.Errorf("scheduler entry type")
-			}
+			stats.Manifests++
 		}
-
-		ref, err := reference.Parse(entry.Key)
-		if err == nil {
-			if err := f(ref); err != nil {
-				dcontext.GetLogger(ttles.ctx).Errorf("Scheduler error returned from OnExpire(%s): %s", entry.Key, err)
-			}
-		} else {
-			dcontext.GetLogger(ttles.ctx).Errorf("Error unpacking reference: %s", err)
+		if stats.NextExpiry.IsZero() || entry.Expiry.Before(stats.NextExpiry) {
+			stats.NextExpiry = entry.Expiry
 		}
+	}
+	return stats
+}
 
-		delete(ttles.entries, entry.Key)
-		ttles.indexDirty = true
-	})
+// recordStats records the entries of the scheduler in metrics. It must be
+// called with the lock held.
+func (ttles *TTLExpirationScheduler) recordStats() {
+	stats := ttles.stats()
+	scheduledEntries.WithValues(entryTypeNames[entryTypeBlob]).Set(float64(stats.Blobs))
+	scheduledEntries.WithValues(entryTypeNames[entryTypeManifest]).Set(float64(stats.Manifests))
+	if stats.NextExpiry.IsZero() {
+		nextExpiry.Set(0)
+	} else {
+		nextExpiry.Set(float64(stats.NextExpiry.Unix()))
+	}
 }
 
 // Stop stops the scheduler.
@@ -223,38 +359,74 @@ func (ttles *TTLExpirationScheduler) Stop() {
 	ttles.stopped = true
 }
 
+// writeState saves the entries changed since the state was last saved. The
+// changes are kept to be saved again when this fails.
 func (ttles *TTLExpirationScheduler) writeState() error {
-	jsonBytes, err := json.Marshal(ttles.entries)
-	if err != nil {
-		return err
+	if len(ttles.pending) == 0 {
+		return nil
 	}
 
-	err = ttles.driver.PutContent(ttles.ctx, ttles.pathToStateFile, jsonBytes)
-	if err != nil {
+	var (
+		entries []*schedulerEntry
+		deleted []string
+	)
+	for key, entry := range ttles.pending {
+		if entry == nil {
+			deleted = append(deleted, key)
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+
+	if err := ttles.store.save(ttles.ctx, entries, deleted); err != nil {
 		return err
 	}
 
+	ttles.pending = make(map[string]*schedulerEntry)
 	return nil
 }
 
+// readState loads the entries of the store. Entries which could not be
+// acted upon on expiry, such as those left by an interrupted write, are
+// dropped from the store rather than failing the start of the scheduler.
 func (ttles *TTLExpirationScheduler) readState() error {
-	if _, err := ttles.driver.Stat(ttles.ctx, ttles.pathToStateFile); err != nil {
-		switch err := err.(type) {
-		case driver.PathNotFoundError:
-			return nil
-		default:
-			return err
+	entries, err := ttles.store.load(ttles.ctx)
+	if err != nil {
+		return err
+	}
+
+	for key, entry := range entries {
+		if err := validateEntry(key, entry); err != nil {
+			dcontext.GetLogger(ttles.ctx).Warnf("Dropping invalid scheduler entry %q: %s", key, err)
+			ttles.pending[key] = nil
+			continue
 		}
+		ttles.entries[key] = entry
 	}
 
-	bytes, err := ttles.driver.GetContent(ttles.ctx, ttles.pathToStateFile)
-	if err != nil {
-		return err
+	return nil
+}
+
+func validateEntry(key string, entry *schedulerEntry) error {
+	if entry == nil || entry.Key != key {
+		return fmt.Errorf("entry key does not match")
 	}
 
-	err = json.Unmarshal(bytes, &ttles.entries)
+	ref, err := reference.Parse(key)
 	if err != nil {
 		return err
 	}
+	if _, ok := ref.(reference.Canonical); !ok {
+		return fmt.Errorf("reference has no digest")
+	}
+
+	if entry.EntryType != entryTypeBlob && entry.EntryType != entryTypeManifest {
+		return fmt.Errorf("unknown entry type %d", entry.EntryType)
+	}
+
+	if entry.Expiry.IsZero() {
+		return fmt.Errorf("entry has no expiry")
+	}
+
 	return nil
 }
@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"encoding/json"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -209,3 +210,57 @@ func TestDoubleStart(t *testing.T) {
 		t.Fatalf("Scheduler started twice without error")
 	}
 }
+
+func TestExpireBefore(t *testing.T) {
+	ref1, ref2, _ := testRefs(t)
+
+	var mu sync.Mutex
+	var expired []string
+	s := New(context.Background(), inmemory.New(), "/ttl")
+	s.OnBlobExpire(func(ref reference.Reference) error {
+		mu.Lock()
+		defer mu.Unlock()
+		expired = append(expired, ref.String())
+		return nil
+	})
+	s.OnManifestExpire(func(ref reference.Reference) error {
+		return fmt.Errorf("unable to delete %s", ref)
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error starting ttlExpirationScheduler: %s", err)
+	}
+	defer s.Stop()
+
+	if err := s.AddBlob(ref1.(reference.Canonical), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddManifest(ref2.(reference.Canonical), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	stats := s.Stats()
+	if stats.Blobs != 1 || stats.Manifests != 1 || stats.Expired != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if until := time.Until(stats.NextExpiry); until <= 0 || until > time.Minute {
+		t.Fatalf("unexpected next expiry in %s", until)
+	}
+
+	n, err := s.ExpireBefore(time.Now().Add(2 * time.Minute))
+	if err != nil || n != 1 {
+		t.Fatalf("expected an entry to be expired, expired %d: %v", n, err)
+	}
+	mu.Lock()
+	if len(expired) != 1 || expired[0] != ref1.String() {
+		t.Fatalf("unexpected entries expired: %v", expired)
+	}
+	mu.Unlock()
+
+	n, err = s.ExpireBefore(time.Now().Add(2 * time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("expected an entry to be expired, expired %d: %v", n, err)
+	}
+	stats = s.Stats()
+	if stats.Blobs != 0 || stats.Manifests != 0 || stats.Expired != 2 || stats.Failed != 1 || stats.LastExpiry.IsZero() || !stats.NextExpiry.IsZero() {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
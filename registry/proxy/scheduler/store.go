@@ -0,0 +1,340 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/gomodule/redigo/redis"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store persists the entries of a scheduler, so that they survive restarts.
+// Stores are created with NewDriverStore, NewRedisStore or NewBoltStore.
+type Store interface {
+	// load returns all persisted entries, by key.
+	load(ctx context.Context) (map[string]*schedulerEntry, error)
+
+	// save persists the given entries, replacing those with the same keys,
+	// and removes the entries with the deleted keys.
+	save(ctx context.Context, entries []*schedulerEntry, deleted []string) error
+}
+
+// shardOf returns the shard of a key, out of n shards.
+func shardOf(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// driverStore keeps entries in JSON files written with a storage driver. With
+// a single shard, all entries are kept in the file at path. Otherwise they are
+// spread over one file per shard, in the directory at path, so that saving a
+// change only rewrites the shard holding it.
+type driverStore struct {
+	driver     driver.StorageDriver
+	path       string
+	legacyPath string
+
+	// shards holds the entries of each shard as last loaded or saved.
+	shards []map[string]*schedulerEntry
+}
+
+// NewDriverStore returns a store keeping entries with the storage driver, in
+// the given number of shards below path. When legacyPath is set, the entries
+// of a single file state found there, as written by previous versions, are
+// moved into the shards on load.
+func NewDriverStore(d driver.StorageDriver, path string, shards int, legacyPath string) Store {
+	if shards < 1 {
+		shards = 1
+	}
+
+	return &driverStore{
+		driver:     d,
+		path:       path,
+		legacyPath: legacyPath,
+		shards:     make([]map[string]*schedulerEntry, shards),
+	}
+}
+
+func (ds *driverStore) shardPath(shard int) string {
+	if len(ds.shards) == 1 {
+		return ds.path
+	}
+	return path.Join(ds.path, fmt.Sprintf("%03d.json", shard))
+}
+
+func (ds *driverStore) load(ctx context.Context) (map[string]*schedulerEntry, error) {
+	entries := make(map[string]*schedulerEntry)
+
+	for i := range ds.shards {
+		shard, err := ds.readFile(ctx, ds.shardPath(i))
+		if err != nil {
+			return nil, err
+		}
+		ds.shards[i] = shard
+
+		for key, entry := range shard {
+			entries[key] = entry
+		}
+	}
+
+	if ds.legacyPath == "" || ds.legacyPath == ds.path {
+		return entries, nil
+	}
+
+	legacy, err := ds.readFile(ctx, ds.legacyPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(legacy) == 0 {
+		return entries, nil
+	}
+
+	var migrated []*schedulerEntry
+	for key, entry := range legacy {
+		if _, ok := entries[key]; !ok {
+			entries[key] = entry
+			migrated = append(migrated, entry)
+		}
+	}
+
+	if err := ds.save(ctx, migrated, nil); err != nil {
+		return nil, err
+	}
+	if err := ds.driver.Delete(ctx, ds.legacyPath); err != nil {
+		return nil, err
+	}
+
+	dcontext.GetLogger(ctx).Infof("Migrated %d scheduler entries from %s", len(migrated), ds.legacyPath)
+	return entries, nil
+}
+
+func (ds *driverStore) readFile(ctx context.Context, p string) (map[string]*schedulerEntry, error) {
+	entries := make(map[string]*schedulerEntry)
+
+	content, err := ds.driver.GetContent(ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return entries, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("unable to read scheduler state %s: %v", p, err)
+	}
+	return entries, nil
+}
+
+func (ds *driverStore) save(ctx context.Context, entries []*schedulerEntry, deleted []string) error {
+	dirty := make(map[int]bool)
+
+	for _, entry := range entries {
+		shard := shardOf(entry.Key, len(ds.shards))
+		if ds.shards[shard] == nil {
+			ds.shards[shard] = make(map[string]*schedulerEntry)
+		}
+		ds.shards[shard][entry.Key] = entry
+		dirty[shard] = true
+	}
+
+	for _, key := range deleted {
+		shard := shardOf(key, len(ds.shards))
+		if _, ok := ds.shards[shard][key]; ok {
+			delete(ds.shards[shard], key)
+			dirty[shard] = true
+		}
+	}
+
+	for shard := range dirty {
+		content, err := json.Marshal(ds.shards[shard])
+		if err != nil {
+			return err
+		}
+
+		if err := ds.driver.PutContent(ctx, ds.shardPath(shard), content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redisStore keeps entries in redis, spread over one hash per shard, so that
+// no single key grows with the size of the cache.
+type redisStore struct {
+	pool   *redis.Pool
+	prefix string
+	shards int
+}
+
+// NewRedisStore returns a store keeping entries in redis, in the given number
+// of hashes named after prefix.
+func NewRedisStore(pool *redis.Pool, prefix string, shards int) Store {
+	if shards < 1 {
+		shards = 1
+	}
+
+	return &redisStore{
+		pool:   pool,
+		prefix: prefix,
+		shards: shards,
+	}
+}
+
+func (rs *redisStore) shardKey(shard int) string {
+	return fmt.Sprintf("%s:%03d", rs.prefix, shard)
+}
+
+func (rs *redisStore) load(ctx context.Context) (map[string]*schedulerEntry, error) {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	entries := make(map[string]*schedulerEntry)
+	for i := 0; i < rs.shards; i++ {
+		values, err := redis.StringMap(conn.Do("HGETALL", rs.shardKey(i)))
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range values {
+			var entry schedulerEntry
+			if err := json.Unmarshal([]byte(value), &entry); err != nil {
+				// Keep the entry, recovery drops it as invalid.
+				entry = schedulerEntry{Key: key, EntryType: -1}
+			}
+			entries[key] = &entry
+		}
+	}
+
+	return entries, nil
+}
+
+func (rs *redisStore) save(ctx context.Context, entries []*schedulerEntry, deleted []string) error {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := conn.Send("HSET", rs.shardKey(shardOf(entry.Key, rs.shards)), entry.Key, value); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range deleted {
+		if err := conn.Send("HDEL", rs.shardKey(shardOf(key, rs.shards)), key); err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// boltStore keeps entries in a boltdb file on the local filesystem, in one
+// bucket per shard, so that restarts do not parse the whole state as a single
+// document.
+type boltStore struct {
+	db     *bolt.DB
+	shards int
+}
+
+// NewBoltStore returns a store keeping entries in the given number of
+// buckets of the boltdb file at path, which is created if missing. The file
+// stays open, and locked against other processes, for the lifetime of the
+// process.
+func NewBoltStore(path string, shards int) (Store, error) {
+	if shards < 1 {
+		shards = 1
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open scheduler state %s: %v", path, err)
+	}
+
+	return &boltStore{
+		db:     db,
+		shards: shards,
+	}, nil
+}
+
+func (bs *boltStore) shardBucket(shard int) []byte {
+	return []byte(fmt.Sprintf("%03d", shard))
+}
+
+func (bs *boltStore) load(ctx context.Context) (map[string]*schedulerEntry, error) {
+	entries := make(map[string]*schedulerEntry)
+
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		for i := 0; i < bs.shards; i++ {
+			b := tx.Bucket(bs.shardBucket(i))
+			if b == nil {
+				continue
+			}
+
+			err := b.ForEach(func(k, v []byte) error {
+				key := string(k)
+				var entry schedulerEntry
+				if err := json.Unmarshal(v, &entry); err != nil {
+					// Keep the entry, recovery drops it as invalid.
+					entry = schedulerEntry{Key: key, EntryType: -1}
+				}
+				entries[key] = &entry
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (bs *boltStore) save(ctx context.Context, entries []*schedulerEntry, deleted []string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		for _, entry := range entries {
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			b, err := tx.CreateBucketIfNotExists(bs.shardBucket(shardOf(entry.Key, bs.shards)))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(entry.Key), value); err != nil {
+				return err
+			}
+		}
+
+		for _, key := range deleted {
+			b := tx.Bucket(bs.shardBucket(shardOf(key, bs.shards)))
+			if b == nil {
+				continue
+			}
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,199 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/gomodule/redigo/redis"
+)
+
+var redisAddr string
+
+func init() {
+	flag.StringVar(&redisAddr, "test.registry.proxy.scheduler.redis.addr", "", "configure the address of a test instance of redis")
+}
+
+// checkStore saves entries to the store and expects a new store created by
+// reopen to load them back.
+func checkStore(t *testing.T, store Store, reopen func() Store) {
+	ctx := context.Background()
+	ref1, ref2, ref3 := testRefs(t)
+	expiry := time.Now().Add(time.Hour).Round(time.Second)
+
+	entries := []*schedulerEntry{
+		{Key: ref1.String(), Expiry: expiry, EntryType: entryTypeBlob},
+		{Key: ref2.String(), Expiry: expiry, EntryType: entryTypeManifest},
+		{Key: ref3.String(), Expiry: expiry, EntryType: entryTypeBlob},
+	}
+	if err := store.save(ctx, entries, nil); err != nil {
+		t.Fatalf("unexpected error saving entries: %v", err)
+	}
+	if err := store.save(ctx, nil, []string{ref3.String()}); err != nil {
+		t.Fatalf("unexpected error deleting entry: %v", err)
+	}
+
+	loaded, err := reopen().load(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error loading entries: %v", err)
+	}
+
+	if len(loaded) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(loaded))
+	}
+	for _, entry := range entries[:2] {
+		got, ok := loaded[entry.Key]
+		if !ok {
+			t.Fatalf("missing entry %s", entry.Key)
+		}
+		if got.EntryType != entry.EntryType || !got.Expiry.Equal(entry.Expiry) {
+			t.Fatalf("unexpected entry for %s: %#v", entry.Key, got)
+		}
+	}
+}
+
+func TestDriverStoreSharded(t *testing.T) {
+	fs := inmemory.New()
+	checkStore(t, NewDriverStore(fs, "/scheduler", 4, ""), func() Store {
+		return NewDriverStore(fs, "/scheduler", 4, "")
+	})
+}
+
+func TestDriverStoreMigrate(t *testing.T) {
+	ctx := context.Background()
+	ref1, ref2, _ := testRefs(t)
+	fs := inmemory.New()
+
+	serialized, err := json.Marshal(map[string]schedulerEntry{
+		ref1.String(): {Key: ref1.String(), Expiry: time.Now().Add(time.Hour), EntryType: entryTypeBlob},
+		ref2.String(): {Key: ref2.String(), Expiry: time.Now().Add(time.Hour), EntryType: entryTypeManifest},
+	})
+	if err != nil {
+		t.Fatalf("Error serializing test data: %s", err.Error())
+	}
+	if err := fs.PutContent(ctx, "/scheduler-state.json", serialized); err != nil {
+		t.Fatal("Unable to write serialized data to fs")
+	}
+
+	entries, err := NewDriverStore(fs, "/scheduler", 4, "/scheduler-state.json").load(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error loading entries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 migrated entries, got %d", len(entries))
+	}
+
+	if _, err := fs.Stat(ctx, "/scheduler-state.json"); err == nil {
+		t.Fatal("expected the legacy state to be removed")
+	}
+
+	entries, err = NewDriverStore(fs, "/scheduler", 4, "/scheduler-state.json").load(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error loading entries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries after migration, got %d", len(entries))
+	}
+}
+
+func TestStartDropsInvalidEntries(t *testing.T) {
+	ctx := context.Background()
+	ref1, _, _ := testRefs(t)
+	fs := inmemory.New()
+
+	serialized, err := json.Marshal(map[string]schedulerEntry{
+		ref1.String(): {Key: ref1.String(), Expiry: time.Now().Add(time.Hour), EntryType: entryTypeBlob},
+		"testrepo":    {Key: "testrepo", Expiry: time.Now().Add(time.Hour), EntryType: entryTypeBlob},
+		"testrepo@sha256:dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd": {
+			Key:       "testrepo@sha256:dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd",
+			Expiry:    time.Now().Add(time.Hour),
+			EntryType: 7,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error serializing test data: %s", err.Error())
+	}
+	if err := fs.PutContent(ctx, "/ttl", serialized); err != nil {
+		t.Fatal("Unable to write serialized data to fs")
+	}
+
+	s := New(ctx, fs, "/ttl")
+	if err := s.Start(); err != nil {
+		t.Fatalf("Error starting ttlExpirationScheduler: %s", err)
+	}
+	s.Stop()
+
+	if len(s.entries) != 1 {
+		t.Fatalf("expected 1 valid entry, got %d", len(s.entries))
+	}
+
+	entries, err := NewDriverStore(fs, "/ttl", 1, "").load(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error loading entries: %v", err)
+	}
+	if _, ok := entries[ref1.String()]; !ok || len(entries) != 1 {
+		t.Fatalf("expected invalid entries to be removed from the store: %#v", entries)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scheduler.db")
+
+	store, err := NewBoltStore(path, 4)
+	if err != nil {
+		t.Fatalf("unexpected error opening store: %v", err)
+	}
+	checkStore(t, store, func() Store {
+		// The file is locked while open.
+		if err := store.(*boltStore).db.Close(); err != nil {
+			t.Fatalf("unexpected error closing store: %v", err)
+		}
+		reopened, err := NewBoltStore(path, 4)
+		if err != nil {
+			t.Fatalf("unexpected error reopening store: %v", err)
+		}
+		return reopened
+	})
+}
+
+// TestRedisStore exercises a live redis instance using the scheduler store.
+func TestRedisStore(t *testing.T) {
+	if redisAddr == "" {
+		// fallback to an environement variable
+		redisAddr = os.Getenv("TEST_REGISTRY_PROXY_SCHEDULER_REDIS_ADDR")
+	}
+
+	if redisAddr == "" {
+		// skip if still not set
+		t.Skip("please set -test.registry.proxy.scheduler.redis.addr to test the scheduler store against redis")
+	}
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redisAddr)
+		},
+		MaxIdle:   1,
+		MaxActive: 2,
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+		Wait: false,
+	}
+
+	// Clear the database
+	conn := pool.Get()
+	if _, err := conn.Do("FLUSHDB"); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+	conn.Close()
+
+	checkStore(t, NewRedisStore(pool, "scheduler", 4), func() Store {
+		return NewRedisStore(pool, "scheduler", 4)
+	})
+}
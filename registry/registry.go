@@ -30,6 +30,7 @@ import (
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/listener"
+	"github.com/distribution/distribution/v3/registry/proxy"
 	"github.com/distribution/distribution/v3/uuid"
 	"github.com/distribution/distribution/v3/version"
 )
@@ -83,6 +84,14 @@ var tlsVersions = map[string]uint16{
 // this channel gets notified when process receives signal. It is global to ease unit testing
 var quit = make(chan os.Signal, 1)
 
+var preflight bool
+var preflightAtStartup bool
+
+func init() {
+	ServeCmd.Flags().BoolVar(&preflight, "preflight", false, "check the storage, redis, token certificates and clock of the configuration, and exit")
+	ServeCmd.Flags().BoolVar(&preflightAtStartup, "preflight-at-startup", false, "run the preflight checks before listening, exiting if they fail")
+}
+
 // ServeCmd is a cobra command for running the registry.
 var ServeCmd = &cobra.Command{
 	Use:   "serve <config>",
@@ -100,6 +109,16 @@ var ServeCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		if preflight || preflightAtStartup {
+			if err := runPreflight(ctx, config, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if preflight {
+				return
+			}
+		}
+
 		if config.HTTP.Debug.Addr != "" {
 			go func(addr string) {
 				logrus.Infof("debug server listening %v", addr)
@@ -123,6 +142,15 @@ var ServeCmd = &cobra.Command{
 			http.Handle(path, metrics.Handler())
 		}
 
+		// The endpoints starting maintenance tasks are only served when
+		// enabled, as the debug server has no authentication.
+		if config.HTTP.Debug.Triggers {
+			logrus.Info("serving maintenance triggers on the debug server")
+			http.HandleFunc("/debug/uploads/purge", handlers.UploadPurgeHandler)
+			http.HandleFunc("/debug/proxy/scheduler", proxy.SchedulerHandler)
+			http.HandleFunc("/debug/gc", handlers.GCHandler)
+		}
+
 		if err = registry.ListenAndServe(); err != nil {
 			logrus.Fatalln(err)
 		}
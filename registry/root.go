@@ -1,14 +1,34 @@
 package registry
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
 	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/bench"
+	"github.com/distribution/distribution/v3/registry/client/auth"
+	"github.com/distribution/distribution/v3/registry/export"
+	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	metadatadb "github.com/distribution/distribution/v3/registry/storage/driver/middleware/metadatadb"
+	shadow "github.com/distribution/distribution/v3/registry/storage/driver/middleware/shadow"
+	"github.com/distribution/distribution/v3/registry/storage/driver/shard"
 	"github.com/distribution/distribution/v3/version"
 	"github.com/docker/libtrust"
+	"github.com/opencontainers/go-digest"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 )
 
@@ -19,6 +39,41 @@ func init() {
 	RootCmd.AddCommand(GCCmd)
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
+	GCCmd.Flags().StringVarP(&gcRepository, "repository", "r", "", "collect only the content of the named repository, keeping the blobs other repositories link")
+	RootCmd.AddCommand(RebuildIndexesCmd)
+	RebuildIndexesCmd.Flags().BoolVarP(&rebuildAll, "all", "a", false, "rebuild the indexes of all repositories")
+	RebuildIndexesCmd.Flags().BoolVarP(&rebuildDryRun, "dry-run", "d", false, "report the changes without writing them")
+	RootCmd.AddCommand(ReferrersIndexCmd)
+	ReferrersIndexCmd.AddCommand(RebuildReferrersIndexCmd)
+	RebuildReferrersIndexCmd.Flags().BoolVarP(&rebuildAll, "all", "a", false, "rebuild the referrers index of all repositories")
+	RebuildReferrersIndexCmd.Flags().BoolVarP(&rebuildDryRun, "dry-run", "d", false, "report the changes without writing them")
+	RootCmd.AddCommand(BackupCmd)
+	BackupCmd.Flags().BoolVarP(&incremental, "incremental", "i", false, "copy only the blobs not listed in the latest snapshot of the backup")
+	RootCmd.AddCommand(RestoreCmd)
+	RootCmd.AddCommand(RebalanceShardsCmd)
+	RebalanceShardsCmd.Flags().BoolVarP(&rebalanceDryRun, "dry-run", "d", false, "report the blobs to move without moving them")
+	RootCmd.AddCommand(VerifyShadowCmd)
+	VerifyShadowCmd.Flags().BoolVarP(&repairShadow, "repair", "r", false, "copy the files missing or differing in the shadow storage and delete those only found in it")
+	RootCmd.AddCommand(ExportCmd)
+	RootCmd.AddCommand(MigrateMetadataCmd)
+	RootCmd.AddCommand(DigestAliasesCmd)
+	DigestAliasesCmd.AddCommand(SetDigestAliasCmd)
+	DigestAliasesCmd.AddCommand(RemoveDigestAliasCmd)
+	DigestAliasesCmd.AddCommand(ListDigestAliasesCmd)
+	DigestAliasesCmd.AddCommand(ImportDigestAliasesCmd)
+	MigrateMetadataCmd.Flags().BoolVarP(&migrateDryRun, "dry-run", "d", false, "count the files to copy without copying them")
+	RootCmd.AddCommand(BenchCmd)
+	BenchCmd.Flags().StringVarP(&benchEndpoint, "endpoint", "e", "", "the base URL of the registry to benchmark, instead of one run in process from the configuration")
+	BenchCmd.Flags().StringVar(&benchMetricsURL, "metrics-url", "", "the Prometheus endpoint of the registry at --endpoint, to report its storage operations")
+	BenchCmd.Flags().StringVar(&benchDockerConfig, "docker-config", "", "the docker config.json holding the credentials of the registry at --endpoint")
+	BenchCmd.Flags().DurationVarP(&benchOptions.Duration, "duration", "t", 30*time.Second, "how long operations are started for")
+	BenchCmd.Flags().IntVarP(&benchOptions.Operations, "operations", "n", 0, "the number of operations after which to stop, if not zero")
+	BenchCmd.Flags().IntVarP(&benchOptions.Concurrency, "concurrency", "c", 8, "the number of operations in progress at once")
+	BenchCmd.Flags().IntVarP(&benchOptions.Repositories, "repositories", "r", 4, "the number of repositories the operations are spread over")
+	BenchCmd.Flags().StringVar(&benchOptions.Prefix, "prefix", "bench", "the prefix of the names of the repositories created")
+	BenchCmd.Flags().IntVar(&benchOptions.Layers, "layers", 2, "the number of layers of the images pushed")
+	BenchCmd.Flags().Int64Var(&benchOptions.LayerSize, "layer-size", 1<<20, "the size in bytes of the layers pushed")
+	BenchCmd.Flags().StringVarP(&benchMix, "mix", "m", "push=1,pull=8,referrers=1", "the weights of the push, pull and referrers operations")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -38,6 +93,7 @@ var RootCmd = &cobra.Command{
 
 var dryRun bool
 var removeUntagged bool
+var gcRepository string
 
 // GCCmd is the cobra command that corresponds to the garbage-collect subcommand
 var GCCmd = &cobra.Command{
@@ -57,6 +113,7 @@ var GCCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
 			os.Exit(1)
 		}
+		driver = withMetadataDB(config, driver)
 
 		ctx := dcontext.Background()
 		ctx, err = configureLogging(ctx, config)
@@ -80,6 +137,7 @@ var GCCmd = &cobra.Command{
 		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
 			DryRun:         dryRun,
 			RemoveUntagged: removeUntagged,
+			Repository:     gcRepository,
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
@@ -87,3 +145,561 @@ var GCCmd = &cobra.Command{
 		}
 	},
 }
+
+var rebuildAll bool
+var rebuildDryRun bool
+
+// RebuildIndexesCmd is the cobra command that corresponds to the rebuild-indexes subcommand
+var RebuildIndexesCmd = &cobra.Command{
+	Use:   "rebuild-indexes <config> [repository]",
+	Short: "`rebuild-indexes` reconstructs the indexes of repositories from their manifests",
+	Long:  "`rebuild-indexes` reconstructs tag links, the referrers index and the layer references of a repository, or of all repositories with --all, from its stored manifest revisions",
+	Run: func(cmd *cobra.Command, args []string) {
+		var repository string
+		if len(args) > 1 {
+			repository = args[1]
+		}
+		if (repository == "" && !rebuildAll) || (repository != "" && rebuildAll) {
+			fmt.Fprintln(os.Stderr, "either a repository or --all must be given")
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx, driver, registry := openRegistry(cmd, args)
+
+		err := storage.RebuildIndexes(ctx, driver, registry, storage.RebuildOpts{
+			Repository: repository,
+			DryRun:     rebuildDryRun,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rebuild indexes: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// ReferrersIndexCmd is the cobra command that corresponds to the referrers-index subcommand
+var ReferrersIndexCmd = &cobra.Command{
+	Use:   "referrers-index",
+	Short: "`referrers-index` maintains the referrers index of repositories",
+	Long:  "`referrers-index` maintains the index of the manifests referring to a subject, which the referrers API is served from",
+}
+
+// RebuildReferrersIndexCmd is the cobra command that corresponds to the referrers-index rebuild subcommand
+var RebuildReferrersIndexCmd = &cobra.Command{
+	Use:   "rebuild <config> [repository]",
+	Short: "`rebuild` reconstructs the referrers index of repositories from their manifests",
+	Long:  "`rebuild` reconstructs the referrer links and descriptors of a repository, or of all repositories with --all, from the subjects of its stored manifest revisions, removing the links of referrers which are not stored",
+	Run: func(cmd *cobra.Command, args []string) {
+		var repository string
+		if len(args) > 1 {
+			repository = args[1]
+		}
+		if (repository == "" && !rebuildAll) || (repository != "" && rebuildAll) {
+			fmt.Fprintln(os.Stderr, "either a repository or --all must be given")
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx, driver, registry := openRegistry(cmd, args)
+
+		err := storage.RebuildIndexes(ctx, driver, registry, storage.RebuildOpts{
+			Repository:    repository,
+			DryRun:        rebuildDryRun,
+			ReferrersOnly: true,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rebuild referrers index: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var incremental bool
+
+// BackupCmd is the cobra command that corresponds to the backup subcommand
+var BackupCmd = &cobra.Command{
+	Use:   "backup <config> <directory>",
+	Short: "`backup` takes a snapshot of the registry into a backup directory",
+	Long:  "`backup` takes a snapshot of the manifests, tags and blobs of the registry into a backup directory, copying only new blobs with --incremental",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, driver, registry := openRegistry(cmd, args)
+
+		backupDriver, err := factory.Create("filesystem", map[string]interface{}{"rootdirectory": args[1]})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct backup driver: %v", err)
+			os.Exit(1)
+		}
+
+		_, err = storage.Backup(ctx, driver, registry, backupDriver, storage.BackupOpts{
+			Incremental: incremental,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to back up: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// RestoreCmd is the cobra command that corresponds to the restore subcommand
+var RestoreCmd = &cobra.Command{
+	Use:   "restore <config> <directory> [snapshot]",
+	Short: "`restore` restores a snapshot of a backup directory into the registry",
+	Long:  "`restore` verifies and restores a snapshot of a backup directory, the latest by default, into the registry",
+	Args:  cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Schema1 manifests were accepted when they were backed up.
+		ctx, _, registry := openRegistry(cmd, args, storage.EnableSchema1)
+
+		backupDriver, err := factory.Create("filesystem", map[string]interface{}{"rootdirectory": args[1]})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct backup driver: %v", err)
+			os.Exit(1)
+		}
+
+		var snapshot *storage.Snapshot
+		if len(args) > 2 {
+			snapshot, err = storage.LoadSnapshot(ctx, backupDriver, args[2])
+		} else {
+			snapshot, err = storage.LatestSnapshot(ctx, backupDriver)
+			if err == nil && snapshot == nil {
+				err = fmt.Errorf("backup holds no snapshot")
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load snapshot: %v", err)
+			os.Exit(1)
+		}
+
+		if err := storage.Restore(ctx, backupDriver, snapshot, registry); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to restore snapshot %s: %v", snapshot.Name, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var rebalanceDryRun bool
+
+// RebalanceShardsCmd is the cobra command that corresponds to the rebalance-shards subcommand
+var RebalanceShardsCmd = &cobra.Command{
+	Use:   "rebalance-shards <config>",
+	Short: "`rebalance-shards` moves blobs to the backends of the shard driver they hash to",
+	Long:  "`rebalance-shards` moves the blobs stored by the shard driver to the backends they hash to, such as after adding a backend",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, driver, _ := openRegistry(cmd, args)
+
+		d, ok := driver.(*shard.Driver)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "storage driver %s is not the shard driver\n", driver.Name())
+			os.Exit(1)
+		}
+
+		moved, err := shard.Rebalance(ctx, d, shard.RebalanceOpts{
+			DryRun: rebalanceDryRun,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rebalance shards: %v", err)
+			os.Exit(1)
+		}
+		if rebalanceDryRun {
+			fmt.Printf("%d blobs would be moved\n", moved)
+		} else {
+			fmt.Printf("%d blobs moved\n", moved)
+		}
+	},
+}
+
+var repairShadow bool
+
+// VerifyShadowCmd is the cobra command that corresponds to the verify-shadow subcommand
+var VerifyShadowCmd = &cobra.Command{
+	Use:   "verify-shadow <config>",
+	Short: "`verify-shadow` reports the differences between the storage and the shadow storage",
+	Long:  "`verify-shadow` compares the files of the storage with those of the shadow storage of the shadow storage middleware before cutting over to it, repairing the differences with --repair",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		var shadowDriver storagedriver.StorageDriver
+		for _, mw := range config.Middleware["storage"] {
+			if mw.Name == "shadow" {
+				shadowDriver, err = shadow.NewShadowDriver(mw.Options)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to construct shadow driver: %v", err)
+					os.Exit(1)
+				}
+				break
+			}
+		}
+		if shadowDriver == nil {
+			fmt.Fprintln(os.Stderr, "the shadow storage middleware is not configured")
+			os.Exit(1)
+		}
+
+		ctx, driver, _ := openRegistry(cmd, args)
+
+		report, err := shadow.Verify(ctx, driver, shadowDriver, shadow.VerifyOpts{
+			Repair: repairShadow,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to verify shadow storage: %v", err)
+			os.Exit(1)
+		}
+
+		for _, path := range report.Missing {
+			fmt.Printf("missing: %s\n", path)
+		}
+		for _, path := range report.Mismatched {
+			fmt.Printf("mismatched: %s\n", path)
+		}
+		for _, path := range report.Extra {
+			fmt.Printf("extra: %s\n", path)
+		}
+		fmt.Printf("%d files verified: %d missing, %d mismatched, %d extra, %d repaired\n",
+			report.Files, len(report.Missing), len(report.Mismatched), len(report.Extra), report.Repaired)
+		if !report.Consistent() && !repairShadow {
+			os.Exit(1)
+		}
+	},
+}
+
+// ExportCmd is the cobra command that corresponds to the export subcommand
+var ExportCmd = &cobra.Command{
+	Use:   "export <config>",
+	Short: "`export` exports the metadata of the registry to the configured sinks",
+	Long:  "`export` exports the repositories, tags, manifests and referrers of the registry to the sinks of the export section of the configuration, once, without the usage counted by running registries",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if len(config.Export.Sinks) == 0 {
+			fmt.Fprintln(os.Stderr, "no export sinks are configured")
+			os.Exit(1)
+		}
+
+		sinks := make(map[string]export.Sink, len(config.Export.Sinks))
+		for name, parameters := range config.Export.Sinks {
+			sink, err := export.Create(name, parameters)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to construct export sink %s: %v", name, err)
+				os.Exit(1)
+			}
+			sinks[name] = sink
+		}
+
+		ctx, _, registry := openRegistry(cmd, args)
+
+		if err := export.NewExporter(registry, sinks, nil).Export(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to export metadata: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var migrateDryRun bool
+
+// MigrateMetadataCmd is the cobra command that corresponds to the migrate-metadata subcommand
+var MigrateMetadataCmd = &cobra.Command{
+	Use:   "migrate-metadata <config>",
+	Short: "`migrate-metadata` copies the metadata of the storage into the metadata database",
+	Long:  "`migrate-metadata` copies the links, tags and referrers of the repositories of the storage into the database of the metadatadb storage middleware, before the registry runs with the middleware",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, driver, _ := openRegistry(cmd, args)
+
+		copied, err := metadatadb.Migrate(ctx, driver, metadatadb.MigrateOpts{
+			DryRun: migrateDryRun,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate metadata: %v", err)
+			os.Exit(1)
+		}
+		if migrateDryRun {
+			fmt.Printf("%d files would be copied\n", copied)
+		} else {
+			fmt.Printf("%d files copied\n", copied)
+		}
+	},
+}
+
+// DigestAliasesCmd is the cobra command that corresponds to the digest-aliases subcommand
+var DigestAliasesCmd = &cobra.Command{
+	Use:   "digest-aliases",
+	Short: "`digest-aliases` maintains the aliases of re-addressed content",
+	Long:  "`digest-aliases` maintains the map of the digests content was addressed by to those it was re-addressed to, which requests of the former are redirected to",
+}
+
+// SetDigestAliasCmd is the cobra command that corresponds to the digest-aliases set subcommand
+var SetDigestAliasCmd = &cobra.Command{
+	Use:   "set <config> <from> <to>",
+	Short: "`set` redirects the requests of a digest to another",
+	Long:  "`set` redirects the requests of the content addressed by a digest, once unknown to the repository, to the digest it was re-addressed to",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		from, to := parseDigestArg(args[1]), parseDigestArg(args[2])
+		ctx, driver, _ := openRegistry(cmd, args)
+
+		if err := storage.NewDigestAliases(driver).Set(ctx, from, to); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set digest alias: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// RemoveDigestAliasCmd is the cobra command that corresponds to the digest-aliases remove subcommand
+var RemoveDigestAliasCmd = &cobra.Command{
+	Use:   "remove <config> <from>",
+	Short: "`remove` stops redirecting the requests of a digest",
+	Long:  "`remove` removes the alias of a digest, once the content is no longer requested by it",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		from := parseDigestArg(args[1])
+		ctx, driver, _ := openRegistry(cmd, args)
+
+		if err := storage.NewDigestAliases(driver).Remove(ctx, from); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove digest alias: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// ListDigestAliasesCmd is the cobra command that corresponds to the digest-aliases list subcommand
+var ListDigestAliasesCmd = &cobra.Command{
+	Use:   "list <config>",
+	Short: "`list` prints the digest aliases",
+	Long:  "`list` prints the digest aliases, a line per alias with the digest content was addressed by and the digest it was re-addressed to",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, driver, _ := openRegistry(cmd, args)
+
+		aliases, err := storage.NewDigestAliases(driver).List(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list digest aliases: %v\n", err)
+			os.Exit(1)
+		}
+		for _, alias := range aliases {
+			fmt.Printf("%s %s\n", alias.From, alias.To)
+		}
+	},
+}
+
+// ImportDigestAliasesCmd is the cobra command that corresponds to the digest-aliases import subcommand
+var ImportDigestAliasesCmd = &cobra.Command{
+	Use:   "import <config> <file>",
+	Short: "`import` sets the digest aliases listed in a file",
+	Long:  "`import` sets the digest aliases listed in a file, such as one written by a migration tool, a line per alias with the digest content was addressed by and the digest it was re-addressed to, as printed by list. Empty lines and lines starting with # are ignored",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open aliases: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		ctx, driver, _ := openRegistry(cmd, args)
+		aliases := storage.NewDigestAliases(driver)
+
+		imported := 0
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			fields := strings.Fields(text)
+			if len(fields) != 2 {
+				fmt.Fprintf(os.Stderr, "line %d: expected two digests\n", line)
+				os.Exit(1)
+			}
+			from, err := digest.Parse(fields[0])
+			if err == nil {
+				var to digest.Digest
+				if to, err = digest.Parse(fields[1]); err == nil {
+					err = aliases.Set(ctx, from, to)
+				}
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "line %d: %v\n", line, err)
+				os.Exit(1)
+			}
+			imported++
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read aliases: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d digest aliases set\n", imported)
+	},
+}
+
+// parseDigestArg parses a digest given as an argument, exiting on errors.
+func parseDigestArg(arg string) digest.Digest {
+	dgst, err := digest.Parse(arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid digest %s: %v\n", arg, err)
+		os.Exit(1)
+	}
+	return dgst
+}
+
+var benchEndpoint string
+var benchMetricsURL string
+var benchDockerConfig string
+var benchMix string
+var benchOptions bench.Options
+
+// BenchCmd is the cobra command that corresponds to the bench subcommand
+var BenchCmd = &cobra.Command{
+	Use:   "bench [config]",
+	Short: "`bench` measures the latency of push, pull and referrers workloads",
+	Long:  "`bench` drives concurrent push, pull and referrers operations against a registry run in process from the configuration, or the registry at --endpoint, and reports the latency percentiles of each operation and the storage operations the registry made. The storage operations of a registry at --endpoint are reported from its Prometheus endpoint, given with --metrics-url. Listing referrers requires the referrers component of the oci extension",
+	Run: func(cmd *cobra.Command, args []string) {
+		mix, err := parseBenchMix(benchMix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid mix: %v\n", err)
+			os.Exit(1)
+		}
+		opts := benchOptions
+		opts.Mix = mix
+
+		ctx := dcontext.Background()
+		if benchEndpoint != "" {
+			opts.Endpoint = strings.TrimSuffix(benchEndpoint, "/")
+			config, err := auth.LoadDockerConfig(benchDockerConfig)
+			if err != nil {
+				if benchDockerConfig != "" || !os.IsNotExist(err) {
+					fmt.Fprintf(os.Stderr, "failed to load docker config: %v\n", err)
+					os.Exit(1)
+				}
+				config = &auth.DockerConfig{}
+			}
+			opts.Transport, err = bench.AuthTransport(opts.Endpoint, auth.NewDockerCredentialStore(config, opts.Endpoint))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to reach %s: %v\n", opts.Endpoint, err)
+				os.Exit(1)
+			}
+			if benchMetricsURL != "" {
+				opts.StorageActions = bench.ScrapedStorageActions(benchMetricsURL)
+			}
+		} else {
+			config, err := resolveConfiguration(args)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+				cmd.Usage()
+				os.Exit(1)
+			}
+			// the requests of the workload are not logged
+			config.Log.Level = "error"
+			config.Log.AccessLog.Disabled = true
+			ctx, err = configureLogging(ctx, config)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+				os.Exit(1)
+			}
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to listen: %v\n", err)
+				os.Exit(1)
+			}
+			server := &http.Server{Handler: handlers.NewApp(ctx, config)}
+			go server.Serve(listener)
+			defer server.Close()
+
+			opts.Endpoint = "http://" + listener.Addr().String() + strings.TrimSuffix(config.HTTP.Prefix, "/")
+			opts.StorageActions = bench.GatheredStorageActions(prometheus.DefaultGatherer)
+		}
+
+		result, err := bench.Run(ctx, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to run benchmark: %v\n", err)
+			os.Exit(1)
+		}
+		if err := result.Write(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write results: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// parseBenchMix parses the weights of the operations of a benchmark, such as
+// push=1,pull=8,referrers=1.
+func parseBenchMix(s string) (map[string]int, error) {
+	mix := make(map[string]int)
+	for _, weight := range strings.Split(s, ",") {
+		operation, value, ok := strings.Cut(strings.TrimSpace(weight), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected operation=weight, got %q", weight)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight of %s: %v", operation, err)
+		}
+		mix[operation] = n
+	}
+	return mix, nil
+}
+
+// openRegistry constructs the storage driver and the registry of the
+// configuration given as the first argument, exiting on errors.
+func openRegistry(cmd *cobra.Command, args []string, options ...storage.RegistryOption) (context.Context, storagedriver.StorageDriver, distribution.Namespace) {
+	config, err := resolveConfiguration(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+		os.Exit(1)
+	}
+	driver = withMetadataDB(config, driver)
+
+	ctx := dcontext.Background()
+	ctx, err = configureLogging(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+		os.Exit(1)
+	}
+
+	k, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	registry, err := storage.NewRegistry(ctx, driver, append([]storage.RegistryOption{storage.Schema1SigningKey(k)}, options...)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+		os.Exit(1)
+	}
+	return ctx, driver, registry
+}
+
+// withMetadataDB wraps driver with the metadatadb storage middleware when it
+// is configured, so that the metadata of repositories is read from its
+// database rather than from the storage, where the blobs would appear
+// unreferenced. It exits on errors.
+func withMetadataDB(config *configuration.Configuration, driver storagedriver.StorageDriver) storagedriver.StorageDriver {
+	for _, mw := range config.Middleware["storage"] {
+		if mw.Name == "metadatadb" {
+			wrapped, err := storagemiddleware.Get(mw.Name, mw.Options, driver)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to construct metadatadb storage middleware: %v", err)
+				os.Exit(1)
+			}
+			return wrapped
+		}
+	}
+	return driver
+}
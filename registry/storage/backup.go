@@ -0,0 +1,521 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// A backup is kept in its own storage driver, holding the snapshots taken
+// and the content of the blobs they list:
+//
+//	/snapshots/<name>.json
+//	/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>
+//
+// Blobs are shared between the snapshots of a backup, so that an incremental
+// snapshot only copies the blobs not listed in the snapshot it is based on.
+
+// snapshotNameFormat names snapshots by the time they are taken, so that
+// their names sort in that order.
+const snapshotNameFormat = "20060102T150405.000000000Z"
+
+// Snapshot describes the content of a registry at the time it was backed up.
+type Snapshot struct {
+	// Name identifies the snapshot in its backup.
+	Name string `json:"name"`
+
+	// Created is the time the snapshot was taken.
+	Created time.Time `json:"created"`
+
+	// Base is the name of the snapshot an incremental snapshot copies only
+	// the new blobs of.
+	Base string `json:"base,omitempty"`
+
+	// Repositories describes the repositories of the registry.
+	Repositories []SnapshotRepository `json:"repositories"`
+
+	// Blobs lists the digests and sizes of all blobs of the registry,
+	// including manifests.
+	Blobs []distribution.Descriptor `json:"blobs"`
+}
+
+// SnapshotRepository describes the content of a repository.
+type SnapshotRepository struct {
+	// Name is the name of the repository.
+	Name string `json:"name"`
+
+	// Manifests lists the media types and digests of the manifests stored
+	// in the repository.
+	Manifests []distribution.Descriptor `json:"manifests"`
+
+	// Tags maps the tags of the repository to the digests they point to.
+	Tags map[string]digest.Digest `json:"tags,omitempty"`
+
+	// Layers lists the digests of the blobs linked into the repository.
+	Layers []digest.Digest `json:"layers,omitempty"`
+}
+
+// BackupOpts contains options for backing up a registry
+type BackupOpts struct {
+	// Incremental bases the snapshot on the latest snapshot of the backup,
+	// copying only the blobs it does not list.
+	Incremental bool
+}
+
+// Backup takes a snapshot of the manifests, tags and blobs of all
+// repositories of registry, stored by storageDriver, into the backup kept by
+// backupDriver. Blobs are verified against their digests as they are copied.
+// As with garbage collection, the registry should not accept pushes or
+// deletes while it is backed up, for the snapshot to be consistent.
+func Backup(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, backupDriver driver.StorageDriver, opts BackupOpts) (*Snapshot, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	now := time.Now().UTC()
+	snapshot := &Snapshot{
+		Name:    now.Format(snapshotNameFormat),
+		Created: now,
+	}
+
+	inBase := make(map[digest.Digest]struct{})
+	if opts.Incremental {
+		base, err := LatestSnapshot(ctx, backupDriver)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load base snapshot: %v", err)
+		}
+		if base != nil {
+			snapshot.Base = base.Name
+			for _, desc := range base.Blobs {
+				inBase[desc.Digest] = struct{}{}
+			}
+		}
+	}
+
+	blobs := make(map[digest.Digest]struct{})
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		emit(repoName)
+
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+
+		snapshotRepository, err := snapshotRepository(ctx, repository)
+		if err != nil {
+			return err
+		}
+		for _, desc := range snapshotRepository.Manifests {
+			blobs[desc.Digest] = struct{}{}
+		}
+		for _, dgst := range snapshotRepository.Layers {
+			blobs[dgst] = struct{}{}
+		}
+		snapshot.Repositories = append(snapshot.Repositories, *snapshotRepository)
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// the registry stores no repositories
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot repositories: %v", err)
+	}
+
+	var copied int
+	bs := &blobStore{driver: storageDriver, statter: &blobStatter{driver: storageDriver}}
+	for _, dgst := range sortedDigests(blobs) {
+		desc, err := bs.statter.Stat(ctx, dgst)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat blob %s: %v", dgst, err)
+		}
+		snapshot.Blobs = append(snapshot.Blobs, distribution.Descriptor{Digest: dgst, Size: desc.Size})
+
+		if _, ok := inBase[dgst]; ok {
+			continue
+		}
+		emit("copying blob %s", dgst)
+		if err := backupBlob(ctx, bs, backupDriver, desc); err != nil {
+			return nil, fmt.Errorf("failed to copy blob %s: %v", dgst, err)
+		}
+		copied++
+	}
+
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := backupDriver.PutContent(ctx, snapshotPath(snapshot.Name), content); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	emit("\n%d blobs listed, %d blobs copied, snapshot %s", len(snapshot.Blobs), copied, snapshot.Name)
+	return snapshot, nil
+}
+
+// snapshotRepository describes the manifests, tags and layers of repository.
+func snapshotRepository(ctx context.Context, repository distribution.Repository) (*SnapshotRepository, error) {
+	name := repository.Named().Name()
+	snapshotRepository := &SnapshotRepository{
+		Name: name,
+		Tags: make(map[string]digest.Digest),
+	}
+
+	// Tags are read before manifests, so that a manifest tagged meanwhile is
+	// listed.
+	tagService := repository.Tags(ctx)
+	tags, err := tagService.All(ctx)
+	if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of %s: %v", name, err)
+	}
+	for _, tag := range tags {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			emit("%s: skipping unreadable tag %s: %v", name, tag, err)
+			continue
+		}
+		snapshotRepository.Tags[tag] = desc.Digest
+	}
+
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+	manifests := make(map[digest.Digest]struct{})
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		manifest, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			emit("%s: skipping unreadable manifest %s: %v", name, dgst, err)
+			return nil
+		}
+		mediaType, payload, err := manifest.Payload()
+		if err != nil {
+			return err
+		}
+		manifests[dgst] = struct{}{}
+		snapshotRepository.Manifests = append(snapshotRepository.Manifests, distribution.Descriptor{
+			MediaType: mediaType,
+			Digest:    dgst,
+			Size:      int64(len(payload)),
+		})
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate manifests of %s: %v", name, err)
+	}
+	sort.Slice(snapshotRepository.Manifests, func(i, j int) bool {
+		return snapshotRepository.Manifests[i].Digest < snapshotRepository.Manifests[j].Digest
+	})
+
+	for tag, dgst := range snapshotRepository.Tags {
+		if _, ok := manifests[dgst]; !ok {
+			emit("%s: skipping tag %s of missing manifest %s", name, tag, dgst)
+			delete(snapshotRepository.Tags, tag)
+		}
+	}
+
+	blobEnumerator, ok := repository.Blobs(ctx).(distribution.BlobEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert BlobStore into BlobEnumerator")
+	}
+	err = blobEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		snapshotRepository.Layers = append(snapshotRepository.Layers, dgst)
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate layers of %s: %v", name, err)
+	}
+	sort.Slice(snapshotRepository.Layers, func(i, j int) bool {
+		return snapshotRepository.Layers[i] < snapshotRepository.Layers[j]
+	})
+
+	return snapshotRepository, nil
+}
+
+// backupBlob copies the blob desc from bs into the backup kept by
+// backupDriver, verifying its content.
+func backupBlob(ctx context.Context, bs *blobStore, backupDriver driver.StorageDriver, desc distribution.Descriptor) error {
+	rc, err := bs.Open(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := backupDriver.Writer(ctx, backupBlobPath(desc.Digest), false)
+	if err != nil {
+		return err
+	}
+	verifier := desc.Digest.Verifier()
+	if _, err := io.Copy(io.MultiWriter(fw, verifier), rc); err != nil {
+		fw.Cancel()
+		return err
+	}
+	if !verifier.Verified() {
+		fw.Cancel()
+		return distribution.ErrBlobInvalidDigest{Digest: desc.Digest, Reason: fmt.Errorf("content does not match digest")}
+	}
+	if err := fw.Commit(); err != nil {
+		return err
+	}
+	return fw.Close()
+}
+
+// LatestSnapshot returns the latest snapshot of the backup kept by
+// backupDriver, or nil if it holds none.
+func LatestSnapshot(ctx context.Context, backupDriver driver.StorageDriver) (*Snapshot, error) {
+	paths, err := backupDriver.List(ctx, "/snapshots")
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var latest string
+	for _, p := range paths {
+		name := strings.TrimSuffix(path.Base(p), ".json")
+		if name > latest {
+			latest = name
+		}
+	}
+	if latest == "" {
+		return nil, nil
+	}
+	return LoadSnapshot(ctx, backupDriver, latest)
+}
+
+// LoadSnapshot returns the snapshot named name of the backup kept by
+// backupDriver.
+func LoadSnapshot(ctx context.Context, backupDriver driver.StorageDriver, name string) (*Snapshot, error) {
+	if _, err := time.Parse(snapshotNameFormat, name); err != nil {
+		return nil, fmt.Errorf("invalid snapshot name %q", name)
+	}
+	content, err := backupDriver.GetContent(ctx, snapshotPath(name))
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, fmt.Errorf("unknown snapshot %q", name)
+		}
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %v", name, err)
+	}
+	for _, desc := range snapshot.Blobs {
+		if err := desc.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid blob in snapshot %s: %v", name, err)
+		}
+	}
+	return &snapshot, nil
+}
+
+// Restore restores snapshot from the backup kept by backupDriver into
+// registry. The blobs of the snapshot are checked to be in the backup before
+// any content is restored, and verified against their digests as they are
+// copied. Blobs are uploaded once and mounted into the other repositories
+// linking them, and manifests are put, which indexes their references and
+// referrers. Tags are restored to the manifests they point to in the
+// snapshot.
+func Restore(ctx context.Context, backupDriver driver.StorageDriver, snapshot *Snapshot, registry distribution.Namespace) error {
+	sizes := make(map[digest.Digest]int64, len(snapshot.Blobs))
+	for _, desc := range snapshot.Blobs {
+		fi, err := backupDriver.Stat(ctx, backupBlobPath(desc.Digest))
+		if err != nil {
+			return fmt.Errorf("blob %s is missing from backup: %v", desc.Digest, err)
+		}
+		if fi.Size() != desc.Size {
+			return fmt.Errorf("blob %s of backup has size %d, expected %d", desc.Digest, fi.Size(), desc.Size)
+		}
+		sizes[desc.Digest] = desc.Size
+	}
+
+	// the repositories each blob has been restored into
+	restored := make(map[digest.Digest]reference.Named)
+	for _, snapshotRepository := range snapshot.Repositories {
+		emit(snapshotRepository.Name)
+
+		named, err := reference.WithName(snapshotRepository.Name)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", snapshotRepository.Name, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+
+		blobs := repository.Blobs(ctx)
+		for _, dgst := range snapshotRepository.Layers {
+			size, ok := sizes[dgst]
+			if !ok {
+				return fmt.Errorf("%s: layer %s is not listed in snapshot", snapshotRepository.Name, dgst)
+			}
+			if err := restoreBlob(ctx, backupDriver, blobs, restored[dgst], distribution.Descriptor{Digest: dgst, Size: size}); err != nil {
+				return fmt.Errorf("%s: failed to restore blob %s: %v", snapshotRepository.Name, dgst, err)
+			}
+			if _, ok := restored[dgst]; !ok {
+				restored[dgst] = named
+			}
+		}
+
+		if err := restoreManifests(ctx, backupDriver, repository, snapshotRepository); err != nil {
+			return err
+		}
+
+		tagService := repository.Tags(ctx)
+		tags := make([]string, 0, len(snapshotRepository.Tags))
+		for tag := range snapshotRepository.Tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			if err := tagService.Tag(ctx, tag, distribution.Descriptor{Digest: snapshotRepository.Tags[tag]}); err != nil {
+				return fmt.Errorf("%s: failed to restore tag %s: %v", snapshotRepository.Name, tag, err)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreBlob restores the blob desc from the backup kept by backupDriver
+// into blobs, mounting it from the repository named from when set.
+func restoreBlob(ctx context.Context, backupDriver driver.StorageDriver, blobs distribution.BlobStore, from reference.Named, desc distribution.Descriptor) error {
+	if _, err := blobs.Stat(ctx, desc.Digest); err == nil {
+		return nil
+	} else if err != distribution.ErrBlobUnknown {
+		return err
+	}
+
+	var options []distribution.BlobCreateOption
+	if from != nil {
+		canonical, err := reference.WithDigest(from, desc.Digest)
+		if err != nil {
+			return err
+		}
+		options = append(options, WithMountFrom(canonical))
+	}
+	bw, err := blobs.Create(ctx, options...)
+	if err != nil {
+		if _, ok := err.(distribution.ErrBlobMounted); ok {
+			return nil
+		}
+		return err
+	}
+
+	rc, err := backupDriver.Reader(ctx, backupBlobPath(desc.Digest), 0)
+	if err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	defer rc.Close()
+
+	if _, err := bw.ReadFrom(rc); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	// Commit verifies the content against the digest.
+	if _, err := bw.Commit(ctx, desc); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	return nil
+}
+
+// restoreManifests puts the manifests of snapshotRepository into
+// repository. Manifests referencing other manifests, such as manifest lists,
+// are put once those are.
+func restoreManifests(ctx context.Context, backupDriver driver.StorageDriver, repository distribution.Repository, snapshotRepository SnapshotRepository) error {
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	pending := snapshotRepository.Manifests
+	for len(pending) > 0 {
+		var retry []distribution.Descriptor
+		var lastErr error
+		for _, desc := range pending {
+			if exists, err := manifestService.Exists(ctx, desc.Digest); err == nil && exists {
+				continue
+			}
+
+			payload, err := backupDriver.GetContent(ctx, backupBlobPath(desc.Digest))
+			if err != nil {
+				return fmt.Errorf("%s: failed to read manifest %s: %v", snapshotRepository.Name, desc.Digest, err)
+			}
+			if desc.Digest.Algorithm().FromBytes(payload) != desc.Digest {
+				return fmt.Errorf("%s: manifest %s of backup does not match its digest", snapshotRepository.Name, desc.Digest)
+			}
+			manifest, _, err := distribution.UnmarshalManifest(desc.MediaType, payload)
+			if err != nil {
+				return fmt.Errorf("%s: failed to parse manifest %s: %v", snapshotRepository.Name, desc.Digest, err)
+			}
+
+			dgst, err := manifestService.Put(ctx, manifest)
+			if err != nil {
+				if _, ok := err.(distribution.ErrManifestVerification); ok {
+					retry = append(retry, desc)
+					lastErr = err
+					continue
+				}
+				return fmt.Errorf("%s: failed to restore manifest %s: %v", snapshotRepository.Name, desc.Digest, err)
+			}
+			if dgst != desc.Digest {
+				return fmt.Errorf("%s: manifest %s was restored as %s", snapshotRepository.Name, desc.Digest, dgst)
+			}
+		}
+
+		if len(retry) == len(pending) {
+			return fmt.Errorf("%s: failed to restore manifests: %v", snapshotRepository.Name, lastErr)
+		}
+		pending = retry
+	}
+	return nil
+}
+
+func sortedDigests(set map[digest.Digest]struct{}) []digest.Digest {
+	digests := make([]digest.Digest, 0, len(set))
+	for dgst := range set {
+		digests = append(digests, dgst)
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i] < digests[j]
+	})
+	return digests
+}
+
+func snapshotPath(name string) string {
+	return path.Join("/snapshots", name+".json")
+}
+
+func backupBlobPath(dgst digest.Digest) string {
+	hex := dgst.Encoded()
+	return path.Join("/blobs", dgst.Algorithm().String(), hex[:2], hex)
+}
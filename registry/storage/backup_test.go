@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	backupDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "foo/bar")
+	barImage := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: barImage.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot lists the layers, the config and the manifest.
+	full, err := Backup(ctx, inmemoryDriver, registry, backupDriver, BackupOpts{})
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	if full.Base != "" || len(full.Repositories) != 1 || len(full.Blobs) != len(barImage.layers)+2 {
+		t.Fatalf("unexpected snapshot: %#v", full)
+	}
+
+	other := makeRepository(t, registry, "foo/other")
+	otherImage := uploadRandomSchema2Image(t, other)
+	if err := other.Tags(ctx).Tag(ctx, "v1", distribution.Descriptor{Digest: otherImage.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	incremental, err := Backup(ctx, inmemoryDriver, registry, backupDriver, BackupOpts{Incremental: true})
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	if incremental.Base != full.Name || len(incremental.Repositories) != 2 {
+		t.Fatalf("unexpected incremental snapshot: %#v", incremental)
+	}
+
+	latest, err := LatestSnapshot(ctx, backupDriver)
+	if err != nil || latest.Name != incremental.Name {
+		t.Fatalf("expected latest snapshot %s, got %v, %v", incremental.Name, latest, err)
+	}
+
+	targetDriver := inmemory.New()
+	target := createRegistry(t, targetDriver)
+	if err := Restore(ctx, backupDriver, latest, target); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	for name, img := range map[string]image{"foo/bar": barImage, "foo/other": otherImage} {
+		restored := makeRepository(t, target, name)
+		if _, err := makeManifestService(t, restored).Get(ctx, img.manifestDigest); err != nil {
+			t.Fatalf("expected manifest %s to be restored: %v", img.manifestDigest, err)
+		}
+		for layer := range img.layers {
+			if _, err := restored.Blobs(ctx).Stat(ctx, layer); err != nil {
+				t.Fatalf("expected layer %s to be restored: %v", layer, err)
+			}
+		}
+		tags, err := restored.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: img.manifestDigest})
+		if err != nil || len(tags) != 1 {
+			t.Fatalf("expected tag of %s to be restored, got %v, %v", img.manifestDigest, tags, err)
+		}
+	}
+}
+
+func TestRestoreVerifiesBackup(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	backupDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "foo/bar")
+	image := uploadRandomSchema2Image(t, repo)
+
+	snapshot, err := Backup(ctx, inmemoryDriver, registry, backupDriver, BackupOpts{})
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	// Corrupt a layer keeping its size.
+	layer := getAnyKey(image.layers)
+	content, err := backupDriver.GetContent(ctx, backupBlobPath(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[0] ^= 0xff
+	if err := backupDriver.PutContent(ctx, backupBlobPath(layer), content); err != nil {
+		t.Fatal(err)
+	}
+
+	target := createRegistry(t, inmemory.New())
+	if err := Restore(ctx, backupDriver, snapshot, target); err == nil {
+		t.Fatalf("expected restoring a corrupted backup to fail")
+	}
+
+	if err := backupDriver.Delete(ctx, backupBlobPath(layer)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(ctx, backupDriver, snapshot, target); err == nil {
+		t.Fatalf("expected restoring an incomplete backup to fail")
+	}
+
+	if _, err := LoadSnapshot(ctx, backupDriver, "../snapshots"); err == nil {
+		t.Fatalf("expected loading an invalid snapshot name to fail")
+	}
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/uuid"
+)
+
+// Batch collects writes of metadata files, such as the links of a tag and the
+// entry of its history, which are applied by Commit.
+//
+// Drivers implementing driver.Batcher apply batches natively, all or nothing.
+// The other drivers apply the writes of a batch in order. If the batch is
+// journaled, they are first recorded in a journal, which is deleted once they
+// are all applied: the batches whose journal remains, as the registry stopped
+// or a write failed while they were applied, are completed by RecoverBatches.
+type Batch struct {
+	driver driver.StorageDriver
+	ops    []driver.BatchOperation
+
+	// journaled records the writes in a journal when the driver cannot
+	// apply them atomically.
+	journaled bool
+}
+
+// NewBatch returns an empty batch of writes to storageDriver.
+func NewBatch(storageDriver driver.StorageDriver) *Batch {
+	return &Batch{driver: storageDriver}
+}
+
+// Put adds the write of content to the file at path to the batch.
+func (b *Batch) Put(path string, content []byte) {
+	b.ops = append(b.ops, driver.BatchOperation{Path: path, Content: content})
+}
+
+// Delete adds the deletion of path, a file or a directory, to the batch.
+func (b *Batch) Delete(path string) {
+	b.ops = append(b.ops, driver.BatchOperation{Path: path, Delete: true})
+}
+
+// journalEntry is a write recorded in the journal of a batch.
+type journalEntry struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content,omitempty"`
+	Delete  bool   `json:"delete,omitempty"`
+}
+
+// Commit applies the writes of the batch, in the order they were added.
+// Once it returns an error other than that of writing the journal, the
+// writes may be partially applied, until the batch is recovered if it is
+// journaled.
+func (b *Batch) Commit(ctx context.Context) error {
+	switch len(b.ops) {
+	case 0:
+		return nil
+	case 1:
+		// A single write needs no journal.
+		return applyBatchOperations(ctx, b.driver, b.ops, time.Time{})
+	}
+	err := driver.ApplyBatch(ctx, b.driver, b.ops)
+	if !errors.As(err, &driver.ErrUnsupportedMethod{}) {
+		return err
+	}
+	if !b.journaled {
+		return applyBatchOperations(ctx, b.driver, b.ops, time.Time{})
+	}
+
+	journalPath, err := pathFor(journalPathSpec{id: uuid.Generate().String()})
+	if err != nil {
+		return err
+	}
+	entries := make([]journalEntry, 0, len(b.ops))
+	for _, op := range b.ops {
+		entries = append(entries, journalEntry{Path: op.Path, Content: op.Content, Delete: op.Delete})
+	}
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := b.driver.PutContent(ctx, journalPath, content); err != nil {
+		return err
+	}
+
+	if err := applyBatchOperations(ctx, b.driver, b.ops, time.Time{}); err != nil {
+		return err
+	}
+	if err := b.driver.Delete(ctx, journalPath); err != nil {
+		// The writes are applied, and skipped when the journal is
+		// recovered as they are more recent than it.
+		dcontext.GetLogger(ctx).Warnf("unable to delete the journal %s of a batch applied: %v", journalPath, err)
+	}
+	return nil
+}
+
+// applyBatchOperations applies ops in order. When since is set, the writes
+// of the paths modified after it are skipped, as those were written by later
+// batches, as are the deletions of missing paths.
+func applyBatchOperations(ctx context.Context, storageDriver driver.StorageDriver, ops []driver.BatchOperation, since time.Time) error {
+	for _, op := range ops {
+		if !since.IsZero() {
+			fi, err := storageDriver.Stat(ctx, op.Path)
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				if op.Delete {
+					continue
+				}
+			} else if err != nil {
+				return err
+			} else if fi.ModTime().After(since) {
+				continue
+			}
+		}
+
+		var err error
+		if op.Delete {
+			err = storageDriver.Delete(ctx, op.Path)
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				err = nil
+			}
+		} else {
+			err = storageDriver.PutContent(ctx, op.Path, op.Content)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecoverBatches completes the batches whose journal was written longer than
+// age ago, and deletes their journal. Their writes to paths modified since
+// their journal was written are skipped, as those are either already applied
+// or overwritten by later writes, whereas their writes to missing paths, such
+// as the links of a new tag, are applied. It returns the number of batches
+// recovered.
+func RecoverBatches(ctx context.Context, storageDriver driver.StorageDriver, age time.Duration) (int, error) {
+	root, err := pathFor(journalsPathSpec{})
+	if err != nil {
+		return 0, err
+	}
+	journals, err := storageDriver.List(ctx, root)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, journalPath := range journals {
+		fi, err := storageDriver.Stat(ctx, journalPath)
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			// completed since it was listed
+			continue
+		} else if err != nil {
+			return recovered, err
+		}
+		if time.Since(fi.ModTime()) < age {
+			continue
+		}
+
+		content, err := storageDriver.GetContent(ctx, journalPath)
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			continue
+		} else if err != nil {
+			return recovered, err
+		}
+		var entries []journalEntry
+		if err := json.Unmarshal(content, &entries); err != nil {
+			// A journal written partially has had none of its writes
+			// applied.
+			dcontext.GetLogger(ctx).Warnf("deleting the malformed journal %s: %v", path.Base(journalPath), err)
+		} else {
+			ops := make([]driver.BatchOperation, 0, len(entries))
+			for _, e := range entries {
+				ops = append(ops, driver.BatchOperation{Path: e.Path, Content: e.Content, Delete: e.Delete})
+			}
+			if err := applyBatchOperations(ctx, storageDriver, ops, fi.ModTime()); err != nil {
+				return recovered, err
+			}
+			recovered++
+		}
+		if err := storageDriver.Delete(ctx, journalPath); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return recovered, err
+			}
+		}
+	}
+	return recovered, nil
+}
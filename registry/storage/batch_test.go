@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// failingDriver fails the writes of the paths with a suffix, hiding whether
+// the driver it wraps applies batches natively.
+type failingDriver struct {
+	storagedriver.StorageDriver
+	suffix string
+}
+
+func (d *failingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if d.suffix != "" && strings.HasSuffix(path, d.suffix) {
+		return errors.New("write failed")
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func journals(t *testing.T, d storagedriver.StorageDriver) int {
+	root, _ := pathFor(journalsPathSpec{})
+	paths, err := d.List(context.Background(), root)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return 0
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return len(paths)
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	d := &failingDriver{StorageDriver: inmemory.New()}
+	if err := d.PutContent(ctx, "/c", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewBatch(d)
+	batch.Put("/a", []byte("a"))
+	batch.Put("/b", []byte("b"))
+	batch.Delete("/c")
+	batch.Delete("/missing")
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for p, expected := range map[string]string{"/a": "a", "/b": "b"} {
+		if content, err := d.GetContent(ctx, p); err != nil || string(content) != expected {
+			t.Errorf("unexpected content of %s: %q, %v", p, content, err)
+		}
+	}
+	if _, err := d.Stat(ctx, "/c"); err == nil {
+		t.Error("expected /c to be deleted")
+	}
+	if n := journals(t, d); n != 0 {
+		t.Errorf("expected batches to be applied without journal unless journaled, found %d", n)
+	}
+
+	batch = NewBatch(d)
+	batch.journaled = true
+	batch.Put("/a", []byte("a"))
+	batch.Put("/b", []byte("b"))
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := journals(t, d); n != 0 {
+		t.Errorf("expected the journal to be deleted, found %d", n)
+	}
+}
+
+func TestRecoverBatches(t *testing.T) {
+	ctx := context.Background()
+	d := &failingDriver{StorageDriver: inmemory.New(), suffix: "/b"}
+	for _, p := range []string{"/b", "/c", "/d"} {
+		if err := d.StorageDriver.PutContent(ctx, p, []byte("earlier")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	batch := NewBatch(d)
+	batch.journaled = true
+	batch.Put("/a", []byte("a"))
+	batch.Put("/b", []byte("b"))
+	batch.Put("/c", []byte("c"))
+	batch.Put("/d", []byte("d"))
+	batch.Put("/e", []byte("e"))
+	if err := batch.Commit(ctx); err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	if n := journals(t, d); n != 1 {
+		t.Fatalf("expected the journal of the batch to remain, found %d", n)
+	}
+	if n, err := RecoverBatches(ctx, d, time.Hour); err != nil || n != 0 {
+		t.Fatalf("expected recent journals to be left, recovered %d: %v", n, err)
+	}
+
+	// A path written after the batch is not overwritten, whereas missing
+	// paths, either deleted since or never written, are.
+	time.Sleep(10 * time.Millisecond)
+	if err := d.StorageDriver.PutContent(ctx, "/c", []byte("later")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StorageDriver.Delete(ctx, "/d"); err != nil {
+		t.Fatal(err)
+	}
+
+	d.suffix = ""
+	if n, err := RecoverBatches(ctx, d, 0); err != nil || n != 1 {
+		t.Fatalf("expected a batch to be recovered, recovered %d: %v", n, err)
+	}
+	for p, expected := range map[string]string{"/a": "a", "/b": "b", "/c": "later", "/d": "d", "/e": "e"} {
+		if content, err := d.GetContent(ctx, p); err != nil || string(content) != expected {
+			t.Errorf("unexpected content of %s: %q, %v", p, content, err)
+		}
+	}
+	if n := journals(t, d); n != 0 {
+		t.Errorf("expected the journal to be deleted, found %d", n)
+	}
+}
+
+func TestInMemoryApplyBatch(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	if err := d.PutContent(ctx, "/file", []byte("file")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write which cannot be applied fails the whole batch.
+	batch := NewBatch(d)
+	batch.Put("/a", []byte("a"))
+	batch.Put("/file/b", []byte("b"))
+	if err := batch.Commit(ctx); err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	if _, err := d.Stat(ctx, "/a"); err == nil {
+		t.Error("expected no write of a failed batch to be applied")
+	}
+
+	batch = NewBatch(d)
+	batch.Put("/a", []byte("a"))
+	batch.Delete("/file")
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := d.GetContent(ctx, "/a"); err != nil || string(content) != "a" {
+		t.Errorf("unexpected content: %q, %v", content, err)
+	}
+	if n := journals(t, d); n != 0 {
+		t.Errorf("expected batches to be applied without journal, found %d", n)
+	}
+	// Writes are checked against the earlier operations of their batch.
+	for _, ops := range [][]storagedriver.BatchOperation{
+		{{Path: "/b", Content: []byte("b")}, {Path: "/b/c", Content: []byte("c")}},
+		{{Path: "/b/c", Content: []byte("c")}, {Path: "/b", Content: []byte("b")}},
+	} {
+		err := d.ApplyBatch(ctx, ops)
+		if err == nil || !strings.Contains(err.Error(), ops[1].Path) {
+			t.Fatalf("expected the batch %v to fail naming %s, got %v", ops, ops[1].Path, err)
+		}
+		if _, err := d.Stat(ctx, "/b"); err == nil {
+			t.Errorf("expected no write of the failed batch %v to be applied", ops)
+		}
+	}
+	if err := d.ApplyBatch(ctx, []storagedriver.BatchOperation{
+		{Path: "/a", Delete: true},
+		{Path: "/a/b", Content: []byte("b")},
+	}); err != nil {
+		t.Fatalf("unexpected error writing below a file deleted by the batch: %v", err)
+	}
+	if content, err := d.GetContent(ctx, "/a/b"); err != nil || string(content) != "b" {
+		t.Errorf("unexpected content: %q, %v", content, err)
+	}
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// minBatchStat is the number of digests sharing a directory from which the
+// directory is walked once rather than stating each of them. On the object
+// storage drivers, walking a directory lists the sizes of the blobs it holds
+// page by page, instead of making a round trip per blob.
+const minBatchStat = 8
+
+// batchStatConcurrency is the number of blobs stated at once when stating
+// blobs in a batch, out of the walked directories.
+const batchStatConcurrency = 16
+
+// blobBatchStatter is implemented by the blob statters able to stat several
+// blobs with fewer calls to the storage driver than stating each of them.
+type blobBatchStatter interface {
+	// StatAll returns the descriptors of the blobs, and the errors stating
+	// them, in the order of the digests.
+	StatAll(ctx context.Context, dgsts []digest.Digest) ([]distribution.Descriptor, []error)
+}
+
+var (
+	_ blobBatchStatter = &blobStatter{}
+	_ blobBatchStatter = &linkedBlobStatter{}
+	_ blobBatchStatter = &linkedBlobStore{}
+)
+
+// StatAll implements blobBatchStatter. The digests are grouped by the
+// directory their blobs are stored under; the directories holding enough of
+// them are walked, and the others stated concurrently.
+func (bs *blobStatter) StatAll(ctx context.Context, dgsts []digest.Digest) ([]distribution.Descriptor, []error) {
+	descs := make([]distribution.Descriptor, len(dgsts))
+	errs := make([]error, len(dgsts))
+
+	// The blob of a digest is the data file of a directory named by its
+	// hex, under a directory shared by the digests of the same prefix.
+	groups := make(map[string]map[string][]int)
+	for i, dgst := range dgsts {
+		dataPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		blobPath := path.Dir(dataPath)
+		dir := path.Dir(blobPath)
+		if groups[dir] == nil {
+			groups[dir] = make(map[string][]int)
+		}
+		groups[dir][blobPath] = append(groups[dir][blobPath], i)
+	}
+
+	var single []int
+	for dir, blobs := range groups {
+		if len(blobs) < minBatchStat {
+			for _, indexes := range blobs {
+				single = append(single, indexes...)
+			}
+			continue
+		}
+
+		found := make(map[string]bool)
+		err := bs.driver.Walk(ctx, dir, func(fileInfo driver.FileInfo) error {
+			if fileInfo.IsDir() {
+				if _, ok := blobs[fileInfo.Path()]; ok || fileInfo.Path() == dir {
+					return nil
+				}
+				return driver.ErrSkipDir
+			}
+			if path.Base(fileInfo.Path()) != "data" {
+				return nil
+			}
+			indexes, ok := blobs[path.Dir(fileInfo.Path())]
+			if !ok {
+				return nil
+			}
+			found[path.Dir(fileInfo.Path())] = true
+			for _, i := range indexes {
+				descs[i] = distribution.Descriptor{
+					Size:      fileInfo.Size(),
+					MediaType: "application/octet-stream",
+					Digest:    dgsts[i],
+				}
+			}
+			return nil
+		})
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			err = nil
+		}
+		for blobPath, indexes := range blobs {
+			for _, i := range indexes {
+				switch {
+				case err != nil:
+					errs[i] = err
+				case !found[blobPath]:
+					errs[i] = distribution.ErrBlobUnknown
+				}
+			}
+		}
+	}
+
+	parallel(len(single), batchStatConcurrency, func(j int) {
+		i := single[j]
+		descs[i], errs[i] = bs.Stat(ctx, dgsts[i])
+	})
+
+	return descs, errs
+}
+
+// StatAll implements blobBatchStatter. The links of the canonical link path
+// function are listed once per directory; the blobs of the linked canonical
+// digests, which are linked to themselves, are then stated in a batch. The
+// other digests are stated one by one.
+func (lbs *linkedBlobStatter) StatAll(ctx context.Context, dgsts []digest.Digest) ([]distribution.Descriptor, []error) {
+	descs := make([]distribution.Descriptor, len(dgsts))
+	errs := make([]error, len(dgsts))
+
+	statter, ok := lbs.blobStore.statter.(blobBatchStatter)
+	if !ok || len(lbs.linkPathFns) == 0 {
+		parallel(len(dgsts), batchStatConcurrency, func(i int) {
+			descs[i], errs[i] = lbs.Stat(ctx, dgsts[i])
+		})
+		return descs, errs
+	}
+
+	listings := make(map[string]map[string]bool)
+	var listed []int
+	linkPaths := make([]string, len(dgsts))
+	for i, dgst := range dgsts {
+		if dgst.Validate() != nil || dgst.Algorithm() != digest.Canonical {
+			descs[i], errs[i] = lbs.Stat(ctx, dgst)
+			continue
+		}
+		linkPath, err := lbs.linkPathFns[0](lbs.repository.Named().Name(), dgst)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		linkDir := path.Dir(linkPath)
+		dir := path.Dir(linkDir)
+		listing, ok := listings[dir]
+		if !ok {
+			children, err := lbs.driver.List(ctx, dir)
+			if err != nil {
+				if _, ok := err.(driver.PathNotFoundError); !ok {
+					errs[i] = err
+					continue
+				}
+			}
+			listing = make(map[string]bool, len(children))
+			for _, child := range children {
+				listing[child] = true
+			}
+			listings[dir] = listing
+		}
+
+		switch {
+		case listing[linkDir]:
+			linkPaths[i] = linkPath
+			listed = append(listed, i)
+		case len(lbs.linkPathFns) > 1:
+			// The digest may be linked by another link path function.
+			descs[i], errs[i] = lbs.Stat(ctx, dgst)
+		default:
+			errs[i] = distribution.ErrBlobUnknown
+		}
+	}
+
+	// The directory of a link outlives it on drivers with directories, once
+	// the blob is deleted from the repository, so the links are stated too.
+	found := make([]bool, len(dgsts))
+	parallel(len(listed), batchStatConcurrency, func(j int) {
+		i := listed[j]
+		_, err := lbs.driver.Stat(ctx, linkPaths[i])
+		switch {
+		case err == nil:
+			found[i] = true
+		case !errors.As(err, &driver.PathNotFoundError{}):
+			errs[i] = err
+		case len(lbs.linkPathFns) > 1:
+			descs[i], errs[i] = lbs.Stat(ctx, dgsts[i])
+		default:
+			errs[i] = distribution.ErrBlobUnknown
+		}
+	})
+	var linked []digest.Digest
+	var linkedIndexes []int
+	for _, i := range listed {
+		if found[i] {
+			linked = append(linked, dgsts[i])
+			linkedIndexes = append(linkedIndexes, i)
+		}
+	}
+
+	linkedDescs, linkedErrs := statter.StatAll(ctx, linked)
+	for j, i := range linkedIndexes {
+		descs[i], errs[i] = linkedDescs[j], linkedErrs[j]
+	}
+
+	return descs, errs
+}
+
+// StatAll implements blobBatchStatter, stating the blobs in a batch unless
+// the access controller of the store, such as a descriptor cache, only
+// supports stating them one by one.
+func (lbs *linkedBlobStore) StatAll(ctx context.Context, dgsts []digest.Digest) ([]distribution.Descriptor, []error) {
+	if statter, ok := lbs.blobAccessController.(blobBatchStatter); ok {
+		return statter.StatAll(ctx, dgsts)
+	}
+
+	descs := make([]distribution.Descriptor, len(dgsts))
+	errs := make([]error, len(dgsts))
+	parallel(len(dgsts), batchStatConcurrency, func(i int) {
+		descs[i], errs[i] = lbs.Stat(ctx, dgsts[i])
+	})
+	return descs, errs
+}
+
+// batchStat stats the blobs of the digests in a batch, if supported by
+// blobs, and returns a function stating blobs from the results of the batch.
+// The blobs of other digests are stated with blobs.
+func batchStat(ctx context.Context, blobs distribution.BlobStatter, dgsts []digest.Digest) func(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	statter, ok := blobs.(blobBatchStatter)
+	if !ok || len(dgsts) == 0 {
+		return blobs.Stat
+	}
+
+	type result struct {
+		desc distribution.Descriptor
+		err  error
+	}
+	descs, errs := statter.StatAll(ctx, dgsts)
+	results := make(map[digest.Digest]result, len(dgsts))
+	for i, dgst := range dgsts {
+		results[dgst] = result{desc: descs[i], err: errs[i]}
+	}
+
+	return func(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+		if r, ok := results[dgst]; ok {
+			return r.desc, r.err
+		}
+		return blobs.Stat(ctx, dgst)
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestBlobStatterStatAll(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	statter := &blobStatter{driver: driver}
+
+	// Enough digests sharing a prefix for their directory to be walked,
+	// and a few elsewhere stated one by one.
+	var dgsts []digest.Digest
+	for i := 0; i < minBatchStat+2; i++ {
+		dgsts = append(dgsts, digest.NewDigestFromEncoded(digest.SHA256, fmt.Sprintf("ab%062x", i)))
+	}
+	dgsts = append(dgsts,
+		digest.NewDigestFromEncoded(digest.SHA256, strings.Repeat("c", 64)),
+		digest.NewDigestFromEncoded(digest.SHA256, strings.Repeat("d", 64)))
+
+	present := func(i int) bool { return i%3 != 0 }
+	for i, dgst := range dgsts {
+		if !present(i) {
+			continue
+		}
+		dataPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := driver.PutContent(ctx, dataPath, make([]byte, i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	descs, errs := statter.StatAll(ctx, dgsts)
+	for i, dgst := range dgsts {
+		if !present(i) {
+			if errs[i] != distribution.ErrBlobUnknown {
+				t.Errorf("expected %s to be unknown, got %v", dgst, errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("unexpected error stating %s: %v", dgst, errs[i])
+			continue
+		}
+		if descs[i].Digest != dgst || descs[i].Size != int64(i+1) {
+			t.Errorf("unexpected descriptor of %s: %v", dgst, descs[i])
+		}
+	}
+}
+
+func TestLinkedBlobStatterStatAll(t *testing.T) {
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), EnableDelete)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	name, _ := reference.WithName("foo/bar")
+	repository, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	blobs := repository.Blobs(ctx)
+
+	var dgsts []digest.Digest
+	for i := 0; i < 3; i++ {
+		desc, err := blobs.Put(ctx, "application/octet-stream", []byte(fmt.Sprintf("blob %d", i)))
+		if err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+		dgsts = append(dgsts, desc.Digest)
+	}
+
+	// A blob of another repository is not linked to this one.
+	other, _ := reference.WithName("foo/other")
+	otherRepository, err := registry.Repository(ctx, other)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	desc, err := otherRepository.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("other"))
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+	dgsts = append(dgsts, desc.Digest)
+
+	// Deleting a blob from the repository leaves the directory of its link.
+	desc, err = blobs.Put(ctx, "application/octet-stream", []byte("deleted"))
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+	if err := blobs.Delete(ctx, desc.Digest); err != nil {
+		t.Fatalf("unexpected error deleting blob: %v", err)
+	}
+	dgsts = append(dgsts, desc.Digest)
+
+	descs, errs := blobs.(blobBatchStatter).StatAll(ctx, dgsts)
+	for i, dgst := range dgsts[:3] {
+		if errs[i] != nil {
+			t.Errorf("unexpected error stating %s: %v", dgst, errs[i])
+		} else if descs[i].Digest != dgst {
+			t.Errorf("unexpected descriptor of %s: %v", dgst, descs[i])
+		}
+	}
+	if errs[3] != distribution.ErrBlobUnknown {
+		t.Errorf("expected the blob of another repository to be unknown, got %v", errs[3])
+	}
+	if errs[4] != distribution.ErrBlobUnknown {
+		t.Errorf("expected the deleted blob to be unknown, got %v", errs[4])
+	}
+}
@@ -532,6 +532,98 @@ func TestLayerUploadZeroLength(t *testing.T) {
 	simpleUpload(t, bs, []byte{}, digestSha256Empty)
 }
 
+// TestBlobUploadResumeDigest checks that resumed uploads restore the hash
+// state checkpointed at the size of the data stored, and fall back to hashing
+// the stored data on commit when none was.
+func TestBlobUploadResumeDigest(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := testdriver.New()
+	registry, err := NewRegistry(ctx, driver, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider()), EnableDelete, EnableRedirect)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+
+	first, second := []byte("first chunk"), []byte("second chunk")
+	dgst := digest.FromBytes(append(append([]byte{}, first...), second...))
+
+	hashStatesPath := func(id string) string {
+		p, err := pathFor(uploadHashStatePathSpec{name: imageName.Name(), id: id, alg: digest.Canonical, list: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	upload := func(dropStates bool) distribution.BlobWriter {
+		bw, err := bs.Create(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error starting upload: %v", err)
+		}
+		if _, err := bw.Write(first); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+		bw.Close()
+		if dropStates {
+			if err := driver.Delete(ctx, hashStatesPath(bw.ID())); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		bw, err = bs.Resume(ctx, bw.ID())
+		if err != nil {
+			t.Fatalf("unexpected error resuming upload: %v", err)
+		}
+		if _, err := bw.Write(second); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+		bw.Close()
+		return bw
+	}
+
+	bw := upload(false)
+	states, err := driver.List(ctx, hashStatesPath(bw.ID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("expected the hash states of both chunks to be checkpointed, found %v", states)
+	}
+	bw, err = bs.Resume(ctx, bw.ID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	if desc, err := bw.Commit(ctx, distribution.Descriptor{Digest: dgst}); err != nil || desc.Digest != dgst {
+		t.Fatalf("unexpected commit of the upload: %v, %v", desc.Digest, err)
+	}
+
+	// Without the state of the first chunk, only the stored data may tell
+	// the digest, and no state of the second chunk is checkpointed.
+	bw = upload(true)
+	if states, err := driver.List(ctx, hashStatesPath(bw.ID())); err == nil {
+		t.Fatalf("expected no hash state of a partial digest, found %v", states)
+	}
+	bw, err = bs.Resume(ctx, bw.ID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	if _, err := bw.Commit(ctx, distribution.Descriptor{Digest: digest.FromBytes(second)}); err == nil {
+		t.Fatal("expected the digest of the last chunk not to verify the upload")
+	}
+
+	bw, err = bs.Resume(ctx, upload(true).ID())
+	if err != nil {
+		t.Fatalf("unexpected error resuming upload: %v", err)
+	}
+	if desc, err := bw.Commit(ctx, distribution.Descriptor{Digest: dgst}); err != nil || desc.Digest != dgst {
+		t.Fatalf("unexpected commit of the upload: %v, %v", desc.Digest, err)
+	}
+}
+
 func simpleUpload(t *testing.T, bs distribution.BlobIngester, blob []byte, expectedDigest digest.Digest) {
 	ctx := context.Background()
 	wr, err := bs.Create(ctx)
@@ -3,16 +3,21 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/distribution/distribution/v3"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/storage/coalesce"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
 )
 
-// TODO(stevvooe): This should configurable in the future.
-const blobCacheControlMaxAge = 365 * 24 * time.Hour
+// coalescedReads counts the blob reads served through a coalescing group,
+// labeled by whether they started a shared backend read, followed one, or
+// read the blob directly.
+var coalescedReads = prometheus.StorageNamespace.NewLabeledCounter("coalesced_reads", "The number of blob reads served through read coalescing", "type")
 
 // blobServer simply serves blobs from a driver instance using a path function
 // to identify paths and a descriptor service to fill in metadata.
@@ -21,6 +26,11 @@ type blobServer struct {
 	statter  distribution.BlobStatter
 	pathFn   func(dgst digest.Digest) (string, error)
 	redirect bool // allows disabling URLFor redirects
+
+	// coalescer, when set, shares a single backend read between concurrent
+	// downloads of blobs up to coalesceMaxSize bytes, within its budget.
+	coalescer       *coalesce.Group
+	coalesceMaxSize int64
 }
 
 func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -50,14 +60,14 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 		}
 	}
 
-	br, err := newFileReader(ctx, bs.driver, path, desc.Size)
+	br, err := bs.open(ctx, r, path, desc.Size)
 	if err != nil {
 		return err
 	}
 	defer br.Close()
 
 	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, desc.Digest)) // If-None-Match handled by ServeContent
-	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%.f", blobCacheControlMaxAge.Seconds()))
+	w.Header().Set("Accept-Ranges", "bytes")
 
 	if w.Header().Get("Docker-Content-Digest") == "" {
 		w.Header().Set("Docker-Content-Digest", desc.Digest.String())
@@ -76,3 +86,43 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 	http.ServeContent(w, r, desc.Digest.String(), time.Time{}, br)
 	return nil
 }
+
+// open returns a reader of the blob at path. Concurrent downloads of the same
+// blob share a single backend read when coalescing is enabled.
+func (bs *blobServer) open(ctx context.Context, r *http.Request, path string, size int64) (distribution.ReadSeekCloser, error) {
+	if bs.coalescer == nil || r.Method != http.MethodGet || size > bs.coalesceMaxSize {
+		return newFileReader(ctx, bs.driver, path, size)
+	}
+
+	br, shared, done := bs.coalescer.OpenShared(ctx, path, size, func(ctx context.Context) (io.ReadCloser, error) {
+		return newFileReader(ctx, bs.driver, path, size)
+	})
+	switch {
+	case br == nil:
+		coalescedReads.WithValues("direct").Inc(1)
+		fr, err := newFileReader(ctx, bs.driver, path, size)
+		if err != nil {
+			done()
+			return nil, err
+		}
+		return directReader{ReadSeekCloser: fr, done: done}, nil
+	case shared:
+		coalescedReads.WithValues("follower").Inc(1)
+	default:
+		coalescedReads.WithValues("leader").Inc(1)
+	}
+
+	return br, nil
+}
+
+// directReader reads a blob directly while coalescing is enabled, telling the
+// coalescer once it is closed.
+type directReader struct {
+	distribution.ReadSeekCloser
+	done func()
+}
+
+func (r directReader) Close() error {
+	r.done()
+	return r.ReadSeekCloser.Close()
+}
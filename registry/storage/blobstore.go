@@ -1,13 +1,18 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"path"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // blobStore implements the read side of the blob store interface over a
@@ -17,6 +22,10 @@ import (
 type blobStore struct {
 	driver  driver.StorageDriver
 	statter distribution.BlobStatter
+
+	// linkMetadata writes the descriptors of linked blobs into their link
+	// files, rather than their digest only.
+	linkMetadata bool
 }
 
 var _ distribution.BlobProvider = &blobStore{}
@@ -30,8 +39,7 @@ func (bs *blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error
 
 	p, err := getContent(ctx, bs.driver, bp)
 	if err != nil {
-		switch err.(type) {
-		case driver.PathNotFoundError:
+		if errors.As(err, &driver.PathNotFoundError{}) {
 			return nil, distribution.ErrBlobUnknown
 		}
 
@@ -41,6 +49,25 @@ func (bs *blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error
 	return p, nil
 }
 
+// getInto reads the content of the blob into buf, rather than into a newly
+// allocated slice like Get.
+func (bs *blobStore) getInto(ctx context.Context, dgst digest.Digest, buf *bytes.Buffer) error {
+	bp, err := bs.path(dgst)
+	if err != nil {
+		return err
+	}
+
+	if err := getContentBuffer(ctx, bs.driver, bp, buf); err != nil {
+		if errors.As(err, &driver.PathNotFoundError{}) {
+			return distribution.ErrBlobUnknown
+		}
+
+		return err
+	}
+
+	return nil
+}
+
 func (bs *blobStore) Open(ctx context.Context, dgst digest.Digest) (distribution.ReadSeekCloser, error) {
 	desc, err := bs.statter.Stat(ctx, dgst)
 	if err != nil {
@@ -64,7 +91,7 @@ func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (distr
 	if err == nil {
 		// content already present
 		return desc, nil
-	} else if err != distribution.ErrBlobUnknown {
+	} else if !errors.Is(err, distribution.ErrBlobUnknown) {
 		dcontext.GetLogger(ctx).Errorf("blobStore: error stating content (%v): %v", dgst, err)
 		// real error, return it
 		return distribution.Descriptor{}, err
@@ -137,19 +164,77 @@ func (bs *blobStore) link(ctx context.Context, path string, dgst digest.Digest)
 	return bs.driver.PutContent(ctx, path, []byte(dgst))
 }
 
+// linkDescriptor links the path to the blob of the descriptor. When link
+// metadata is enabled, the size and media type of the blob are written along
+// with its digest, sparing the stat of the blob when reading the link.
+// Descriptors without a size, as the ones of tags, only link the digest.
+func (bs *blobStore) linkDescriptor(ctx context.Context, path string, desc distribution.Descriptor) error {
+	content, err := bs.linkContent(desc)
+	if err != nil {
+		return err
+	}
+	return bs.driver.PutContent(ctx, path, content)
+}
+
+// linkContent returns the content of the links to the blob of desc.
+func (bs *blobStore) linkContent(desc distribution.Descriptor) ([]byte, error) {
+	if !bs.linkMetadata || desc.Size <= 0 {
+		return []byte(desc.Digest), nil
+	}
+
+	return json.Marshal(linkMetadata{
+		Digest:    desc.Digest,
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
 // readlink returns the linked digest at path.
 func (bs *blobStore) readlink(ctx context.Context, path string) (digest.Digest, error) {
+	linked, _, err := bs.readlinkMetadata(ctx, path)
+	return linked, err
+}
+
+// readlinkMetadata returns the linked digest at path, and the metadata of the
+// link if it was written with them.
+func (bs *blobStore) readlinkMetadata(ctx context.Context, path string) (digest.Digest, *linkMetadata, error) {
 	content, err := bs.driver.GetContent(ctx, path)
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+
+	// Links with metadata are JSON objects, whereas those without hold the
+	// digest, which never starts with a brace.
+	if bytes.HasPrefix(content, []byte("{")) {
+		var metadata linkMetadata
+		if err := json.Unmarshal(content, &metadata); err != nil {
+			return "", nil, err
+		}
+		if err := metadata.Digest.Validate(); err != nil {
+			return "", nil, err
+		}
+		return metadata.Digest, &metadata, nil
 	}
 
 	linked, err := digest.Parse(string(content))
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return linked, nil
+	return linked, nil, nil
+}
+
+// linkMetadata is the content of the link files written with metadata.
+type linkMetadata struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Size      int64         `json:"size"`
+	CreatedAt time.Time     `json:"createdAt"`
+
+	// Platforms are the platforms of the images of a manifest, recorded
+	// into the link of its revision when it is pushed.
+	Platforms []v1.Platform `json:"platforms,omitempty"`
 }
 
 type blobStatter struct {
@@ -172,12 +257,10 @@ func (bs *blobStatter) Stat(ctx context.Context, dgst digest.Digest) (distributi
 
 	fi, err := bs.driver.Stat(ctx, path)
 	if err != nil {
-		switch err := err.(type) {
-		case driver.PathNotFoundError:
+		if errors.As(err, &driver.PathNotFoundError{}) {
 			return distribution.Descriptor{}, distribution.ErrBlobUnknown
-		default:
-			return distribution.Descriptor{}, err
 		}
+		return distribution.Descriptor{}, err
 	}
 
 	if fi.IsDir() {
@@ -35,12 +35,21 @@ type blobWriter struct {
 	digester  digest.Digester
 	written   int64 // track the write to digester
 
+	// partialDigest is set when the hash state of the data stored before
+	// the upload was resumed could not be restored, the digester hashing
+	// only the data written since.
+	partialDigest bool
+
 	fileWriter storagedriver.FileWriter
 	driver     storagedriver.StorageDriver
 	path       string
 
 	resumableDigestEnabled bool
 	committed              bool
+
+	// delegatedTo is the source the verification of the digest of the blob
+	// is delegated to, if any. The data written is not hashed then.
+	delegatedTo string
 }
 
 var _ distribution.BlobWriter = &blobWriter{}
@@ -112,6 +121,13 @@ func (bw *blobWriter) Size() int64 {
 }
 
 func (bw *blobWriter) Write(p []byte) (int, error) {
+	if bw.delegatedTo != "" {
+		n, err := bw.fileWriter.Write(p)
+		bw.written += int64(n)
+		bw.partialDigest = true
+		return n, err
+	}
+
 	// Ensure that the current write offset matches how many bytes have been
 	// written to the digester. If not, we need to update the digest state to
 	// match the current write position.
@@ -119,18 +135,23 @@ func (bw *blobWriter) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
-	_, err := bw.fileWriter.Write(p)
-	if err != nil {
-		return 0, err
-	}
-
-	n, err := bw.digester.Hash().Write(p)
+	// Only the data stored is hashed, so that the digester stays in step
+	// with the upload when a write fails partway.
+	n, err := bw.fileWriter.Write(p)
+	bw.digester.Hash().Write(p[:n])
 	bw.written += int64(n)
 
 	return n, err
 }
 
 func (bw *blobWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if bw.delegatedTo != "" {
+		nn, err := io.Copy(bw.fileWriter, r)
+		bw.written += nn
+		bw.partialDigest = true
+		return nn, err
+	}
+
 	// Ensure that the current write offset matches how many bytes have been
 	// written to the digester. If not, we need to update the digest state to
 	// match the current write position.
@@ -153,11 +174,15 @@ func (bw *blobWriter) Close() error {
 		return errors.New("blobwriter close after commit")
 	}
 
-	if err := bw.storeHashState(bw.blobStore.ctx); err != nil && err != errResumableDigestNotAvailable {
+	if err := bw.fileWriter.Close(); err != nil {
 		return err
 	}
 
-	return bw.fileWriter.Close()
+	// The hash state is checkpointed once the data it describes is stored.
+	if err := bw.storeHashState(bw.blobStore.ctx); err != nil && err != errResumableDigestNotAvailable {
+		return err
+	}
+	return nil
 }
 
 // validateBlob checks the data against the digest, returning an error if it
@@ -208,6 +233,16 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 		desc.Size = size
 	}
 
+	// The digest of a blob whose verification is delegated is trusted.
+	// The others are hashed from the data stored, since it is not hashed
+	// as it is written.
+	if bw.delegatedTo != "" && desc.Digest.Algorithm() == digest.Canonical && desc.Digest.Validate() == nil {
+		if desc.MediaType == "" {
+			desc.MediaType = "application/octet-stream"
+		}
+		return desc, nil
+	}
+
 	// TODO(stevvooe): This section is very meandering. Need to be broken down
 	// to be a lot more clear.
 
@@ -236,7 +271,7 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 		// the same, we don't need to read the data from the backend. This is
 		// because we've written the entire file in the lifecycle of the
 		// current instance.
-		if bw.written == size && digest.Canonical == desc.Digest.Algorithm() {
+		if !bw.partialDigest && bw.written == size && digest.Canonical == desc.Digest.Algorithm() {
 			canonical = bw.digester.Digest()
 			verified = desc.Digest == canonical
 		}
@@ -344,7 +379,13 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 
 	// TODO(stevvooe): We should also write the mediatype when executing this move.
 
-	return bw.blobStore.driver.Move(ctx, bw.path, blobPath)
+	if err := bw.blobStore.driver.Move(ctx, bw.path, blobPath); err != nil {
+		return err
+	}
+	if bw.delegatedTo != "" {
+		return bw.recordDelegation(ctx, desc.Digest)
+	}
+	return nil
 }
 
 // removeResources should clean up all resources associated with the upload
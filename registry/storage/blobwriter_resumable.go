@@ -11,12 +11,15 @@ import (
 	"path"
 	"strconv"
 
+	dcontext "github.com/distribution/distribution/v3/context"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/sirupsen/logrus"
 )
 
-// resumeDigest attempts to restore the state of the internal hash function
-// by loading the most recent saved hash state equal to the current size of the blob.
+// resumeDigest restores the state of the internal hash function to the data
+// stored so far, loading the hash state checkpointed at the current size of
+// the blob. If none was, the digester is reset to hash the data written from
+// then on only, and the digest is computed from the stored data on commit.
 func (bw *blobWriter) resumeDigest(ctx context.Context) error {
 	if !bw.resumableDigestEnabled {
 		return errResumableDigestNotAvailable
@@ -30,46 +33,49 @@ func (bw *blobWriter) resumeDigest(ctx context.Context) error {
 	offset := bw.fileWriter.Size()
 	if offset == bw.written {
 		// State of digester is already at the requested offset.
+		if bw.partialDigest {
+			return errResumableDigestNotAvailable
+		}
+		return nil
+	}
+
+	h.(hash.Hash).Reset()
+	if offset == 0 {
+		bw.written, bw.partialDigest = 0, false
 		return nil
 	}
 
 	// List hash states from storage backend.
-	var hashStateMatch hashStateEntry
 	hashStates, err := bw.getStoredHashStates(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to get stored hash states with offset %d: %s", offset, err)
 	}
 
-	// Find the highest stored hashState with offset equal to
-	// the requested offset.
+	// Only the state checkpointed at the requested offset describes the
+	// data stored: the others are of shorter data, or of data written by
+	// a request which did not complete.
 	for _, hashState := range hashStates {
-		if hashState.offset == offset {
-			hashStateMatch = hashState
-			break // Found an exact offset match.
+		if hashState.offset != offset {
+			continue
 		}
-	}
 
-	if hashStateMatch.offset == 0 {
-		// No need to load any state, just reset the hasher.
-		h.(hash.Hash).Reset()
-	} else {
-		storedState, err := bw.driver.GetContent(ctx, hashStateMatch.path)
+		storedState, err := bw.driver.GetContent(ctx, hashState.path)
 		if err != nil {
 			return err
 		}
 
-		if err = h.UnmarshalBinary(storedState); err != nil {
-			return err
+		if err := h.UnmarshalBinary(storedState); err != nil {
+			dcontext.GetLogger(ctx).Errorf("unable to restore upload hash state %q: %s", hashState.path, err)
+			h.(hash.Hash).Reset()
+			break
 		}
-		bw.written = hashStateMatch.offset
+		bw.written, bw.partialDigest = offset, false
+		return nil
 	}
 
 	// Mind the gap.
-	if gapLen := offset - bw.written; gapLen > 0 {
-		return errResumableDigestNotAvailable
-	}
-
-	return nil
+	bw.written, bw.partialDigest = offset, true
+	return errResumableDigestNotAvailable
 }
 
 type hashStateEntry struct {
@@ -107,6 +113,7 @@ func (bw *blobWriter) getStoredHashStates(ctx context.Context) ([]hashStateEntry
 		offset, err := strconv.ParseInt(pathSuffix, 0, 64)
 		if err != nil {
 			logrus.Errorf("unable to parse offset from upload state path %q: %s", p, err)
+			continue
 		}
 
 		hashStateEntries = append(hashStateEntries, hashStateEntry{offset: offset, path: p})
@@ -125,6 +132,12 @@ func (bw *blobWriter) storeHashState(ctx context.Context) error {
 		return errResumableDigestNotAvailable
 	}
 
+	// Only the states of all the data stored are checkpointed, keyed by its
+	// length.
+	if bw.partialDigest || bw.written == 0 || bw.written != bw.fileWriter.Size() {
+		return nil
+	}
+
 	state, err := h.MarshalBinary()
 	if err != nil {
 		return err
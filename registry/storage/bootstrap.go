@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// RepositoryBootstrap records the template a repository was created with,
+// along with the settings it applied, so that the repository keeps them as
+// templates change.
+type RepositoryBootstrap struct {
+	Template string `json:"template"`
+
+	// RequiredAnnotations lists the keys of the annotations the manifests
+	// pushed into the repository must have.
+	RequiredAnnotations []string `json:"requiredAnnotations,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// bootstrapRepository records the bootstrap of the repository of the blob
+// store if a blob is written into it while neither its bootstrap nor its
+// layers are stored, as it is being created.
+func (lbs *linkedBlobStore) bootstrapRepository(ctx context.Context) error {
+	if lbs.registry == nil || lbs.registry.bootstrap == nil {
+		return nil
+	}
+	name := lbs.repository.Named().Name()
+
+	bootstrapPath, err := pathFor(repositoryBootstrapPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+	layersPath, err := pathFor(layersPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{bootstrapPath, layersPath} {
+		if _, err := lbs.driver.Stat(ctx, p); err == nil {
+			return nil
+		} else if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	bootstrap, ok := lbs.registry.bootstrap(name)
+	if !ok {
+		return nil
+	}
+	bootstrap.CreatedAt = time.Now().UTC()
+	content, err := json.Marshal(bootstrap)
+	if err != nil {
+		return err
+	}
+	return lbs.driver.PutContent(ctx, bootstrapPath, content)
+}
+
+// GetRepositoryBootstrap returns the bootstrap of the repository name, or nil
+// if the repository was not bootstrapped.
+func GetRepositoryBootstrap(ctx context.Context, storageDriver driver.StorageDriver, name string) (*RepositoryBootstrap, error) {
+	p, err := pathFor(repositoryBootstrapPathSpec{name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := storageDriver.GetContent(ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var bootstrap RepositoryBootstrap
+	if err := json.Unmarshal(content, &bootstrap); err != nil {
+		return nil, fmt.Errorf("failed to parse the bootstrap of %s: %v", name, err)
+	}
+	return &bootstrap, nil
+}
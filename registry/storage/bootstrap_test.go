@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestBootstrapRepositories(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+
+	// A repository created before repositories were bootstrapped.
+	uploadRandomSchema2Image(t, makeRepository(t, createRegistry(t, driver), "team/old"))
+
+	registry := createRegistry(t, driver, BootstrapRepositories(func(repository string) (RepositoryBootstrap, bool) {
+		if repository == "other/app" {
+			return RepositoryBootstrap{}, false
+		}
+		return RepositoryBootstrap{Template: "team", RequiredAnnotations: []string{"org.example.owner"}}, true
+	}))
+	for _, name := range []string{"team/app", "team/old", "other/app"} {
+		uploadRandomSchema2Image(t, makeRepository(t, registry, name))
+	}
+
+	bootstrap, err := GetRepositoryBootstrap(ctx, driver, "team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bootstrap == nil || bootstrap.Template != "team" || !reflect.DeepEqual(bootstrap.RequiredAnnotations, []string{"org.example.owner"}) || bootstrap.CreatedAt.IsZero() {
+		t.Fatalf("unexpected bootstrap: %+v", bootstrap)
+	}
+
+	// Later uploads leave the bootstrap.
+	uploadRandomSchema2Image(t, makeRepository(t, registry, "team/app"))
+	if again, err := GetRepositoryBootstrap(ctx, driver, "team/app"); err != nil || !again.CreatedAt.Equal(bootstrap.CreatedAt) {
+		t.Errorf("unexpected bootstrap after another upload: %+v, %v", again, err)
+	}
+
+	for _, name := range []string{"team/old", "other/app", "unknown"} {
+		if bootstrap, err := GetRepositoryBootstrap(ctx, driver, name); err != nil || bootstrap != nil {
+			t.Errorf("%s: unexpected bootstrap %+v, %v", name, bootstrap, err)
+		}
+	}
+
+	// The bootstrap is not listed as a repository.
+	repositories := make([]string, 3)
+	n, err := registry.Repositories(ctx, repositories, "")
+	if n != 3 || !reflect.DeepEqual(repositories, []string{"other/app", "team/app", "team/old"}) {
+		t.Errorf("unexpected repositories: %v, %v", repositories[:n], err)
+	}
+}
@@ -77,6 +77,9 @@ func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
 	if err != nil {
 		return err
 	}
+	if err := NewHoldStore(reg.driver).checkRepository(ctx, name.Name()); err != nil {
+		return err
+	}
 	repoDir := path.Join(root, name.Name())
 	return reg.driver.Delete(ctx, repoDir)
 }
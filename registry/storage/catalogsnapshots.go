@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// ErrCatalogSnapshotUnknown is returned when a snapshot of the catalog does
+// not exist, or has expired.
+var ErrCatalogSnapshotUnknown = errors.New("unknown catalog snapshot")
+
+// CatalogSnapshots stores snapshots of the catalog of a registry in its
+// storage driver, so that clients paginating through the catalog read the
+// repositories as they were when the first page was requested, rather than
+// missing or repeating repositories created and deleted in between pages.
+// Each snapshot is identified by its generation, the time it was taken, and
+// expires after a time to live.
+type CatalogSnapshots struct {
+	driver driver.StorageDriver
+	ttl    time.Duration
+}
+
+// NewCatalogSnapshots returns the store of the catalog snapshots kept with
+// the content of driver, which expire after ttl.
+func NewCatalogSnapshots(driver driver.StorageDriver, ttl time.Duration) *CatalogSnapshots {
+	return &CatalogSnapshots{driver: driver, ttl: ttl}
+}
+
+// Save stores a snapshot of repositories, in the order of the catalog, and
+// returns its generation. Expired snapshots are deleted.
+func (cs *CatalogSnapshots) Save(ctx context.Context, repositories []string) (string, error) {
+	now := time.Now()
+	cs.purge(ctx, now)
+
+	generation := fmt.Sprintf("%020d", now.UnixNano())
+	p, err := pathFor(catalogSnapshotPathSpec{generation: generation})
+	if err != nil {
+		return "", err
+	}
+	if err := cs.driver.PutContent(ctx, p, []byte(strings.Join(repositories, "\n"))); err != nil {
+		return "", err
+	}
+	return generation, nil
+}
+
+// Load returns the repositories of the snapshot of generation.
+func (cs *CatalogSnapshots) Load(ctx context.Context, generation string) ([]string, error) {
+	taken, err := strconv.ParseInt(generation, 10, 64)
+	if err != nil || len(generation) != 20 {
+		return nil, ErrCatalogSnapshotUnknown
+	}
+	if time.Since(time.Unix(0, taken)) > cs.ttl {
+		return nil, ErrCatalogSnapshotUnknown
+	}
+
+	p, err := pathFor(catalogSnapshotPathSpec{generation: generation})
+	if err != nil {
+		return nil, err
+	}
+	content, err := cs.driver.GetContent(ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, ErrCatalogSnapshotUnknown
+		}
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(content), "\n"), nil
+}
+
+// purge deletes the snapshots expired at now. Failures are ignored, as the
+// snapshots are deleted by the next snapshot taken.
+func (cs *CatalogSnapshots) purge(ctx context.Context, now time.Time) {
+	root, err := pathFor(catalogSnapshotsPathSpec{})
+	if err != nil {
+		return
+	}
+	entries, err := cs.driver.List(ctx, root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		taken, err := strconv.ParseInt(path.Base(entry), 10, 64)
+		if err != nil || now.Sub(time.Unix(0, taken)) <= cs.ttl {
+			continue
+		}
+		cs.driver.Delete(ctx, entry)
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestCatalogSnapshots(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	snapshots := NewCatalogSnapshots(d, time.Hour)
+
+	repositories := []string{"foo/aaaa", "foo/bbbb", "foo/cccc"}
+	generation, err := snapshots.Save(ctx, repositories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := snapshots.Load(ctx, generation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, repositories) {
+		t.Fatalf("unexpected snapshot: %v", loaded)
+	}
+
+	for _, generation := range []string{"", "unknown", fmt.Sprintf("%020d", time.Now().UnixNano())} {
+		if _, err := snapshots.Load(ctx, generation); err != ErrCatalogSnapshotUnknown {
+			t.Errorf("unexpected error loading snapshot %q: %v", generation, err)
+		}
+	}
+
+	// snapshots expire, and are deleted by the next snapshot taken
+	expired := fmt.Sprintf("%020d", time.Now().Add(-2*time.Hour).UnixNano())
+	p, err := pathFor(catalogSnapshotPathSpec{generation: expired})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, p, []byte("foo/aaaa")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshots.Load(ctx, expired); err != ErrCatalogSnapshotUnknown {
+		t.Fatalf("unexpected error loading expired snapshot: %v", err)
+	}
+	if _, err := snapshots.Save(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, p); err == nil {
+		t.Fatal("expired snapshot not deleted")
+	}
+	if _, err := snapshots.Load(ctx, generation); err != nil {
+		t.Fatalf("unexpired snapshot deleted: %v", err)
+	}
+}
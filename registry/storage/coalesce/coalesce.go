@@ -0,0 +1,369 @@
+// Package coalesce shares a single read of some content between all the
+// readers interested in it at the same time.
+//
+// The first reader of a key starts a flight, which reads the content once
+// into memory. Readers arriving while the flight is in progress follow it,
+// receiving the bytes as they are read rather than starting their own read.
+//
+// With OpenShared, the first reader of a key reads it directly instead, and
+// the flight is only started by the second one, so that content read by a
+// single reader is never buffered. The flights started by OpenShared are
+// bounded by the MaxBytes budget of the group.
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when reading from a closed Reader.
+var ErrClosed = errors.New("coalesce: reader closed")
+
+// OpenFunc opens the content shared by a flight. The context passed to it is
+// canceled once every reader of the flight has been closed.
+type OpenFunc func(ctx context.Context) (io.ReadCloser, error)
+
+// Group coalesces concurrent reads of identical content. The zero value is
+// ready to use.
+type Group struct {
+	// MaxBytes bounds the bytes buffered by the flights in progress, which
+	// OpenShared does not start beyond it. Zero means no bound.
+	MaxBytes int64
+
+	mu       sync.Mutex
+	flights  map[string]*flight
+	direct   map[string]int // readers of each key reading it directly
+	reserved int64          // bytes buffered by the flights in progress
+}
+
+// Open returns a reader of the size bytes of the content identified by key.
+// If a flight of key is in progress, the reader follows it. Otherwise a new
+// flight is started, reading the content from open. Shared reports whether
+// the reader follows an existing flight.
+//
+// The reader stops waiting for content when ctx is done, without affecting
+// the flight. The flight itself is only canceled once all of its readers
+// are closed.
+func (g *Group) Open(ctx context.Context, key string, size int64, open OpenFunc) (r *Reader, shared bool) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+
+	f, shared := g.flights[key]
+	if shared && f.size != size {
+		// The content changed under the same key, leave the stale flight to
+		// its current readers.
+		shared = false
+	}
+
+	if !shared {
+		f = g.start(ctx, key, size, open)
+	}
+	f.refs++
+	g.mu.Unlock()
+
+	return newReader(ctx, f), shared
+}
+
+// OpenShared returns a reader of the size bytes of the content identified by
+// key if another reader of key is in progress: the reader follows the flight
+// of key, or starts one if the other reader reads key directly and MaxBytes
+// leaves room for size more bytes. Shared reports whether the reader follows
+// an existing flight.
+//
+// Otherwise OpenShared returns a nil reader, and the caller reads the content
+// directly, calling done once it is finished, so that the readers arriving in
+// the meantime start a flight.
+func (g *Group) OpenShared(ctx context.Context, key string, size int64, open OpenFunc) (r *Reader, shared bool, done func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	if g.direct == nil {
+		g.direct = make(map[string]int)
+	}
+
+	if f, ok := g.flights[key]; ok && f.size == size {
+		f.refs++
+		return newReader(ctx, f), true, nil
+	}
+	if g.direct[key] > 0 && (g.MaxBytes <= 0 || g.reserved+size <= g.MaxBytes) {
+		f := g.start(ctx, key, size, open)
+		f.refs++
+		return newReader(ctx, f), false, nil
+	}
+
+	g.direct[key]++
+	var once sync.Once
+	return nil, false, func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.direct[key]--; g.direct[key] == 0 {
+				delete(g.direct, key)
+			}
+		})
+	}
+}
+
+// start starts a flight reading the size bytes of key from open, reserving
+// them in the budget of the group. g.mu must be held.
+func (g *Group) start(ctx context.Context, key string, size int64, open OpenFunc) *flight {
+	f := newFlight(g, key, size)
+	g.flights[key] = f
+	g.reserved += size
+
+	flightCtx, cancel := context.WithCancel(detach(ctx))
+	f.cancel = cancel
+	go f.run(flightCtx, open)
+	return f
+}
+
+// Join returns a reader following the flight of key, if one is in progress.
+// Unlike Open, it never starts a new flight.
+func (g *Group) Join(ctx context.Context, key string) (*Reader, bool) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if ok {
+		f.refs++
+	}
+	g.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+	return newReader(ctx, f), true
+}
+
+// release drops a reference to f, canceling it when it was the last one and
+// returning its bytes to the budget.
+func (g *Group) release(f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f.refs--
+	if f.refs > 0 {
+		return
+	}
+
+	if g.flights[f.key] == f {
+		delete(g.flights, f.key)
+	}
+	g.reserved -= f.size
+	f.cancel()
+}
+
+// forget removes a failed flight, so that later readers start over.
+func (g *Group) forget(f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.flights[f.key] == f {
+		delete(g.flights, f.key)
+	}
+}
+
+// flight is a single read of some content, buffered in memory for the
+// readers following it.
+type flight struct {
+	group  *Group
+	key    string
+	size   int64
+	data   []byte
+	cancel context.CancelFunc
+	refs   int // protected by group.mu
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	n    int64 // number of bytes of data filled in
+	err  error // terminal error of the flight, io.EOF once complete
+}
+
+func newFlight(g *Group, key string, size int64) *flight {
+	f := &flight{
+		group: g,
+		key:   key,
+		size:  size,
+		data:  make([]byte, size),
+	}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *flight) run(ctx context.Context, open OpenFunc) {
+	rc, err := open(ctx)
+	if err != nil {
+		f.finish(err)
+		return
+	}
+	defer rc.Close()
+
+	var filled int64
+	for filled < f.size {
+		// Only this goroutine writes data beyond n, readers never look
+		// past it.
+		n, err := rc.Read(f.data[filled:])
+		filled += int64(n)
+
+		f.mu.Lock()
+		f.n = filled
+		f.cond.Broadcast()
+		f.mu.Unlock()
+
+		if err == io.EOF {
+			if filled < f.size {
+				f.finish(io.ErrUnexpectedEOF)
+				return
+			}
+			break
+		}
+		if err != nil {
+			f.finish(err)
+			return
+		}
+	}
+
+	f.finish(io.EOF)
+}
+
+func (f *flight) finish(err error) {
+	if err != io.EOF {
+		f.group.forget(f)
+	}
+
+	f.mu.Lock()
+	f.err = err
+	f.cond.Broadcast()
+	f.mu.Unlock()
+}
+
+// wake wakes up all readers waiting for the flight, so that they notice
+// changes to their own state.
+func (f *flight) wake() {
+	f.mu.Lock()
+	f.cond.Broadcast()
+	f.mu.Unlock()
+}
+
+// Reader reads the content of a flight. It implements io.ReadSeeker, so that
+// it can be used with http.ServeContent, and must be closed once done.
+type Reader struct {
+	f      *flight
+	ctx    context.Context
+	done   chan struct{}
+	offset int64
+	closed bool
+}
+
+func newReader(ctx context.Context, f *flight) *Reader {
+	r := &Reader{
+		f:    f,
+		ctx:  ctx,
+		done: make(chan struct{}),
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				f.wake()
+			case <-r.done:
+			}
+		}()
+	}
+
+	return r
+}
+
+// Read reads from the content at the current offset, waiting for the flight
+// to get there if necessary.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, ErrClosed
+	}
+	if r.offset >= r.f.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	f := r.f
+	f.mu.Lock()
+	for f.n <= r.offset && f.err == nil && r.ctx.Err() == nil {
+		f.cond.Wait()
+	}
+	filled, err := f.n, f.err
+	f.mu.Unlock()
+
+	if filled > r.offset {
+		n := copy(p, f.data[r.offset:filled])
+		r.offset += int64(n)
+		return n, nil
+	}
+
+	if ctxErr := r.ctx.Err(); ctxErr != nil {
+		return 0, ctxErr
+	}
+	return 0, err
+}
+
+// Seek sets the offset of the next Read.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed {
+		return 0, ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.f.size
+	default:
+		return r.offset, fmt.Errorf("coalesce: invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return r.offset, fmt.Errorf("coalesce: negative offset %d", offset)
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+// Close releases the reader. Once all readers of a flight are closed, the
+// flight is canceled if it is still in progress.
+func (r *Reader) Close() error {
+	if r.closed {
+		return ErrClosed
+	}
+	r.closed = true
+	close(r.done)
+	r.f.group.release(r.f)
+	return nil
+}
+
+// detached is a context carrying the values of its parent, but not its
+// deadline or cancellation.
+type detached struct {
+	parent context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detached{parent: ctx}
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
@@ -0,0 +1,266 @@
+package coalesce
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedReader returns its content in small pieces, waiting for each piece to
+// be released.
+type gatedReader struct {
+	ctx     context.Context
+	content []byte
+	gate    chan struct{}
+	closed  chan struct{}
+}
+
+func (r *gatedReader) Read(p []byte) (int, error) {
+	if len(r.content) == 0 {
+		return 0, io.EOF
+	}
+
+	select {
+	case <-r.gate:
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	}
+
+	n := copy(p[:min(len(p), 4)], r.content)
+	r.content = r.content[n:]
+	return n, nil
+}
+
+func (r *gatedReader) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestGroupSharesRead(t *testing.T) {
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	gate := make(chan struct{})
+	closed := make(chan struct{})
+
+	var opens int
+	open := func(ctx context.Context) (io.ReadCloser, error) {
+		opens++
+		return &gatedReader{ctx: ctx, content: content, gate: gate, closed: closed}, nil
+	}
+
+	var g Group
+	readers := make([]*Reader, 5)
+	for i := range readers {
+		r, shared := g.Open(context.Background(), "blob", int64(len(content)), open)
+		if shared != (i > 0) {
+			t.Fatalf("reader %d: unexpected shared %v", i, shared)
+		}
+		readers[i] = r
+	}
+
+	// One reader only wants the tail of the content.
+	if _, err := readers[4].Seek(-8, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]byte, len(readers))
+	for i, r := range readers {
+		wg.Add(1)
+		go func(i int, r *Reader) {
+			defer wg.Done()
+			defer r.Close()
+			p, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Errorf("reader %d: %v", i, err)
+			}
+			results[i] = p
+		}(i, r)
+	}
+
+	go func() {
+		for {
+			select {
+			case gate <- struct{}{}:
+			case <-closed:
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if opens != 1 {
+		t.Fatalf("expected a single backend read, got %d", opens)
+	}
+	for i, p := range results[:4] {
+		if !bytes.Equal(p, content) {
+			t.Fatalf("reader %d: unexpected content %q", i, p)
+		}
+	}
+	if !bytes.Equal(results[4], content[len(content)-8:]) {
+		t.Fatalf("unexpected tail %q", results[4])
+	}
+
+	// Once all readers are gone, the next one starts a new flight.
+	r, shared := g.Open(context.Background(), "blob", int64(len(content)), func(ctx context.Context) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(content)), nil
+	})
+	defer r.Close()
+	if shared {
+		t.Fatal("expected a new flight after all readers closed")
+	}
+}
+
+func TestGroupCancel(t *testing.T) {
+	closed := make(chan struct{})
+	open := func(ctx context.Context) (io.ReadCloser, error) {
+		return &gatedReader{ctx: ctx, content: []byte("content"), gate: make(chan struct{}), closed: closed}, nil
+	}
+
+	var g Group
+	first, _ := g.Open(context.Background(), "blob", 7, open)
+
+	// A reader whose context is done stops waiting, without affecting the
+	// flight.
+	ctx, cancel := context.WithCancel(context.Background())
+	second, _ := g.Open(ctx, "blob", 7, open)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := second.Read(make([]byte, 7)); err != context.Canceled {
+		t.Fatalf("expected read to be canceled, got %v", err)
+	}
+	second.Close()
+
+	select {
+	case <-closed:
+		t.Fatal("flight was canceled while a reader remained")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Closing the last reader cancels the backend read.
+	first.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("flight was not canceled after all readers closed")
+	}
+}
+
+func TestGroupFailedFlight(t *testing.T) {
+	errBackend := errors.New("backend unavailable")
+	var g Group
+
+	r, _ := g.Open(context.Background(), "blob", 7, func(ctx context.Context) (io.ReadCloser, error) {
+		return nil, errBackend
+	})
+	defer r.Close()
+	if _, err := r.Read(make([]byte, 7)); err != errBackend {
+		t.Fatalf("expected backend error, got %v", err)
+	}
+
+	// A failed flight is not joined by later readers, even while readers of
+	// it remain.
+	retry, shared := g.Open(context.Background(), "blob", 7, func(ctx context.Context) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader([]byte("content"))), nil
+	})
+	defer retry.Close()
+	if shared {
+		t.Fatal("expected a new flight after failure")
+	}
+	if p, err := ioutil.ReadAll(retry); err != nil || string(p) != "content" {
+		t.Fatalf("unexpected result %q, %v", p, err)
+	}
+}
+
+func TestGroupOpenShared(t *testing.T) {
+	content := []byte("content")
+	var opens int
+	open := func(ctx context.Context) (io.ReadCloser, error) {
+		opens++
+		return ioutil.NopCloser(bytes.NewReader(content)), nil
+	}
+
+	var g Group
+	// A single reader reads directly, without a flight.
+	r, _, done := g.OpenShared(context.Background(), "blob", 7, open)
+	if r != nil {
+		t.Fatal("expected the first reader to read directly")
+	}
+
+	// The second reader starts a flight, which the third one follows.
+	second, shared, _ := g.OpenShared(context.Background(), "blob", 7, open)
+	if second == nil || shared {
+		t.Fatalf("expected the second reader to start a flight: %v, %v", second, shared)
+	}
+	third, shared, _ := g.OpenShared(context.Background(), "blob", 7, open)
+	if third == nil || !shared {
+		t.Fatalf("expected the third reader to follow the flight: %v, %v", third, shared)
+	}
+	for _, r := range []*Reader{second, third} {
+		if p, err := ioutil.ReadAll(r); err != nil || string(p) != string(content) {
+			t.Fatalf("unexpected result %q, %v", p, err)
+		}
+		r.Close()
+	}
+	if opens != 1 {
+		t.Fatalf("expected a single read of the flight, got %d", opens)
+	}
+
+	// Once the direct read is done, the next reader reads directly again.
+	done()
+	done()
+	if r, _, _ := g.OpenShared(context.Background(), "blob", 7, open); r != nil {
+		t.Fatal("expected a reader to read directly once the others are done")
+	}
+}
+
+func TestGroupBudget(t *testing.T) {
+	open := func(ctx context.Context) (io.ReadCloser, error) {
+		return &gatedReader{ctx: ctx, content: make([]byte, 6), gate: make(chan struct{}), closed: make(chan struct{})}, nil
+	}
+
+	g := Group{MaxBytes: 10}
+	start := func(key string) *Reader {
+		if r, _, _ := g.OpenShared(context.Background(), key, 6, open); r != nil {
+			t.Fatalf("expected the first reader of %s to read directly", key)
+		}
+		r, _, _ := g.OpenShared(context.Background(), key, 6, open)
+		return r
+	}
+
+	first := start("first")
+	if first == nil {
+		t.Fatal("expected a flight within the budget")
+	}
+	// A second flight would exceed the budget, so the readers read directly.
+	if r := start("second"); r != nil {
+		t.Fatal("expected a direct read beyond the budget")
+	}
+	if r, _, _ := g.OpenShared(context.Background(), "second", 6, open); r != nil {
+		t.Fatal("expected a direct read beyond the budget")
+	}
+	// Readers of the flight in progress still follow it.
+	if r, shared, _ := g.OpenShared(context.Background(), "first", 6, open); r == nil || !shared {
+		t.Fatal("expected a reader to follow the flight in progress")
+	} else {
+		r.Close()
+	}
+
+	// Closing the flight returns its bytes to the budget.
+	first.Close()
+	if r, shared, _ := g.OpenShared(context.Background(), "second", 6, open); r == nil || shared {
+		t.Fatal("expected a flight once the budget is available")
+	} else {
+		r.Close()
+	}
+}
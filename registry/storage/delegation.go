@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// delegatedVerifications counts the blobs stored without verifying their
+// digest, by source.
+var delegatedVerifications = prometheus.StorageNamespace.NewLabeledCounter("delegated_verifications", "The number of blobs stored with the verification of their digest delegated to the source replicating them", "source")
+
+type delegatedVerificationKey struct{}
+
+// WithDelegatedVerification returns a context under which the blobs uploaded
+// are not hashed, their digest being trusted as verified by source, such as
+// another registry replicating its content. Only blobs of the canonical
+// algorithm are trusted, and the delegation is recorded with the blobs
+// stored, as returned by BlobVerificationDelegation.
+func WithDelegatedVerification(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, delegatedVerificationKey{}, source)
+}
+
+// delegatedVerification returns the source the verification of the blobs
+// uploaded under ctx is delegated to, if any.
+func delegatedVerification(ctx context.Context) string {
+	source, _ := ctx.Value(delegatedVerificationKey{}).(string)
+	return source
+}
+
+// VerificationDelegation records that a blob was stored without verifying its
+// digest, trusted as verified by the source uploading it.
+type VerificationDelegation struct {
+	Source string `json:"source"`
+
+	// Subject is the name of the user the upload was authenticated as.
+	Subject string `json:"subject,omitempty"`
+
+	RequestID   string    `json:"requestID,omitempty"`
+	DelegatedAt time.Time `json:"delegatedAt"`
+}
+
+// recordDelegation records that the verification of the blob dgst written by
+// bw was delegated.
+func (bw *blobWriter) recordDelegation(ctx context.Context, dgst digest.Digest) error {
+	p, err := pathFor(blobDelegationPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(VerificationDelegation{
+		Source:      bw.delegatedTo,
+		Subject:     dcontext.GetUserName(ctx),
+		RequestID:   dcontext.GetRequestID(ctx),
+		DelegatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.blobStore.driver.PutContent(ctx, p, content); err != nil {
+		return err
+	}
+
+	delegatedVerifications.WithValues(bw.delegatedTo).Inc(1)
+	dcontext.GetLoggerWithField(ctx, "digest", dgst).Infof("stored blob with its verification delegated to %s", bw.delegatedTo)
+	return nil
+}
+
+// BlobVerificationDelegation returns the record of the delegation of the
+// verification of the blob dgst, or nil if the blob was verified as it was
+// stored.
+func BlobVerificationDelegation(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest) (*VerificationDelegation, error) {
+	p, err := pathFor(blobDelegationPathSpec{digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := storageDriver.GetContent(ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var delegation VerificationDelegation
+	if err := json.Unmarshal(content, &delegation); err != nil {
+		return nil, fmt.Errorf("failed to parse the verification delegation of %s: %v", dgst, err)
+	}
+	return &delegation, nil
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDelegatedVerification(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	repo := makeRepository(t, createRegistry(t, driver), "mirror/app")
+
+	upload := func(ctx context.Context, content []byte, dgst digest.Digest) error {
+		wr, err := repo.Blobs(ctx).Create(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wr.ReadFrom(bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		_, err = wr.Commit(ctx, distribution.Descriptor{Digest: dgst})
+		return err
+	}
+
+	delegated := WithDelegatedVerification(ctx, "primary")
+	replicated := []byte("replicated")
+	if err := upload(delegated, replicated, digest.FromBytes(replicated)); err != nil {
+		t.Fatal(err)
+	}
+	delegation, err := BlobVerificationDelegation(ctx, driver, digest.FromBytes(replicated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delegation == nil || delegation.Source != "primary" || delegation.DelegatedAt.IsZero() {
+		t.Fatalf("unexpected delegation: %+v", delegation)
+	}
+	if desc, err := repo.Blobs(ctx).Stat(ctx, digest.FromBytes(replicated)); err != nil || desc.Size != int64(len(replicated)) {
+		t.Errorf("unexpected descriptor of the replicated blob: %+v, %v", desc, err)
+	}
+
+	pushed := []byte("pushed")
+	if err := upload(ctx, pushed, digest.FromBytes(pushed)); err != nil {
+		t.Fatal(err)
+	}
+	if delegation, err := BlobVerificationDelegation(ctx, driver, digest.FromBytes(pushed)); err != nil || delegation != nil {
+		t.Errorf("unexpected delegation of a verified blob: %+v, %v", delegation, err)
+	}
+
+	// Digests of other algorithms are still verified.
+	if err := upload(delegated, []byte("other"), digest.SHA512.FromBytes([]byte("mismatch"))); err == nil {
+		t.Error("expected a mismatching digest of another algorithm to be rejected")
+	} else if _, ok := err.(distribution.ErrBlobInvalidDigest); !ok {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
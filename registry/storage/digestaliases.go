@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// ErrDigestAliasUnknown is returned when a digest has no alias.
+var ErrDigestAliasUnknown = errors.New("unknown digest alias")
+
+// DigestAlias maps the digest content was addressed by to the digest it was
+// re-addressed to, such as when migrating content from sha256 to sha512.
+type DigestAlias struct {
+	From digest.Digest `json:"from"`
+	To   digest.Digest `json:"to"`
+}
+
+// maxDigestAliasChain is the number of aliases followed when checking that
+// a new alias does not make a cycle, beyond clients following redirects.
+const maxDigestAliasChain = 10
+
+// DigestAliases stores the digest aliases of a registry in its storage
+// driver.
+type DigestAliases struct {
+	driver driver.StorageDriver
+}
+
+// NewDigestAliases returns the store of the digest aliases kept with the
+// content of driver.
+func NewDigestAliases(driver driver.StorageDriver) *DigestAliases {
+	return &DigestAliases{driver: driver}
+}
+
+// Get returns the digest the content addressed by from was re-addressed to.
+func (da *DigestAliases) Get(ctx context.Context, from digest.Digest) (digest.Digest, error) {
+	if err := from.Validate(); err != nil {
+		return "", ErrDigestAliasUnknown
+	}
+	p, err := pathFor(digestAliasPathSpec{digest: from})
+	if err != nil {
+		return "", err
+	}
+	content, err := da.driver.GetContent(ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return "", ErrDigestAliasUnknown
+		}
+		return "", err
+	}
+	to, err := digest.Parse(strings.TrimSpace(string(content)))
+	if err != nil {
+		return "", fmt.Errorf("invalid digest alias of %s: %v", from, err)
+	}
+	return to, nil
+}
+
+// Set records that the content addressed by from was re-addressed to to,
+// replacing the previous alias of from.
+func (da *DigestAliases) Set(ctx context.Context, from, to digest.Digest) error {
+	if err := from.Validate(); err != nil {
+		return err
+	}
+	if err := to.Validate(); err != nil {
+		return err
+	}
+	if from == to {
+		return fmt.Errorf("digest %s cannot be an alias of itself", from)
+	}
+	// content re-addressed more than once is redirected along the chain of
+	// its aliases, which must not lead back to from
+	next := to
+	for i := 0; i < maxDigestAliasChain; i++ {
+		var err error
+		next, err = da.Get(ctx, next)
+		if err == ErrDigestAliasUnknown {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if next == from {
+			return fmt.Errorf("the aliases of %s lead back to %s", to, from)
+		}
+	}
+
+	p, err := pathFor(digestAliasPathSpec{digest: from})
+	if err != nil {
+		return err
+	}
+	return da.driver.PutContent(ctx, p, []byte(to.String()))
+}
+
+// Remove removes the alias of from.
+func (da *DigestAliases) Remove(ctx context.Context, from digest.Digest) error {
+	if err := from.Validate(); err != nil {
+		return ErrDigestAliasUnknown
+	}
+	p, err := pathFor(digestAliasPathSpec{digest: from})
+	if err != nil {
+		return err
+	}
+	if err := da.driver.Delete(ctx, p); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return ErrDigestAliasUnknown
+		}
+		return err
+	}
+	return nil
+}
+
+// List returns the aliases, sorted by the digest they map.
+func (da *DigestAliases) List(ctx context.Context) ([]DigestAlias, error) {
+	root, err := pathFor(digestAliasesPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases []DigestAlias
+	err = da.driver.Walk(ctx, root, func(fi driver.FileInfo) error {
+		if fi.IsDir() {
+			return nil
+		}
+		components := strings.Split(strings.TrimPrefix(fi.Path(), root+"/"), "/")
+		if len(components) != 2 {
+			return nil
+		}
+		from := digest.NewDigestFromEncoded(digest.Algorithm(components[0]), components[1])
+		to, err := da.Get(ctx, from)
+		if err == ErrDigestAliasUnknown {
+			// removed while listing
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		aliases = append(aliases, DigestAlias{From: from, To: to})
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliases[i].From < aliases[j].From
+	})
+	return aliases, nil
+}
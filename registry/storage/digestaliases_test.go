@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDigestAliases(t *testing.T) {
+	ctx := context.Background()
+	aliases := NewDigestAliases(inmemory.New())
+
+	if list, err := aliases.List(ctx); err != nil || len(list) != 0 {
+		t.Fatalf("unexpected aliases of an empty registry: %v, %v", list, err)
+	}
+
+	first := digest.FromString("first")
+	second := digest.SHA512.FromString("first")
+	third := digest.FromString("third")
+	if err := aliases.Set(ctx, first, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := aliases.Set(ctx, second, third); err != nil {
+		t.Fatal(err)
+	}
+	if to, err := aliases.Get(ctx, first); err != nil || to != second {
+		t.Fatalf("unexpected alias of %s: %s, %v", first, to, err)
+	}
+	if _, err := aliases.Get(ctx, third); err != ErrDigestAliasUnknown {
+		t.Fatalf("unexpected error getting an unknown alias: %v", err)
+	}
+
+	for _, alias := range []DigestAlias{
+		{From: first, To: first},
+		{From: third, To: first},
+		{From: "sha256:invalid", To: first},
+		{From: first, To: "invalid"},
+	} {
+		if err := aliases.Set(ctx, alias.From, alias.To); err == nil {
+			t.Errorf("expected an error setting alias %v", alias)
+		}
+	}
+
+	list, err := aliases.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DigestAlias{{From: first, To: second}, {From: second, To: third}}
+	if first > second {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if !reflect.DeepEqual(list, expected) {
+		t.Fatalf("unexpected aliases: %v", list)
+	}
+
+	if err := aliases.Remove(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := aliases.Remove(ctx, first); err != ErrDigestAliasUnknown {
+		t.Fatalf("unexpected error removing an unknown alias: %v", err)
+	}
+	if _, err := aliases.Get(ctx, first); err != ErrDigestAliasUnknown {
+		t.Fatalf("unexpected error getting a removed alias: %v", err)
+	}
+}
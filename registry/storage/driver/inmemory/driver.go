@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -320,3 +322,121 @@ func (w *writer) Commit() error {
 	w.committed = true
 	return nil
 }
+
+var _ storagedriver.Batcher = &Driver{}
+
+// ApplyBatch applies the writes of ops while holding the lock of the
+// filesystem, so that they are seen all at once, after checking that none of
+// them fails.
+func (d *Driver) ApplyBatch(ctx context.Context, ops []storagedriver.BatchOperation) error {
+	for _, op := range ops {
+		if !storagedriver.PathRegexp.MatchString(op.Path) {
+			return storagedriver.InvalidPathError{Path: op.Path, DriverName: driverName}
+		}
+	}
+	return d.baseEmbed.Base.StorageDriver.(*driver).applyBatch(ops)
+}
+
+func (d *driver) applyBatch(ops []storagedriver.BatchOperation) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	paths := batchPaths{root: d.root, written: make(map[string]struct{})}
+	for _, op := range ops {
+		normalized := normalize(op.Path)
+		if op.Delete {
+			paths.delete(normalized)
+			continue
+		}
+		if !paths.writable(normalized) {
+			return fmt.Errorf("not a file: %s", op.Path)
+		}
+		paths.written[normalized] = struct{}{}
+	}
+
+	for _, op := range ops {
+		normalized := normalize(op.Path)
+		if op.Delete {
+			if err := d.root.delete(normalized); err != nil && err != errNotExists {
+				return err
+			}
+			continue
+		}
+		f, err := d.root.mkfile(normalized)
+		if err != nil {
+			return fmt.Errorf("not a file: %s", op.Path)
+		}
+		f.truncate()
+		f.WriteAt(op.Content, 0)
+	}
+	return nil
+}
+
+// batchPaths tracks the files of the filesystem as the operations of a batch
+// are validated, without applying them: the files written by the operations
+// validated so far, and the paths they deleted from the filesystem.
+type batchPaths struct {
+	root    *dir
+	written map[string]struct{}
+	deleted []string
+}
+
+// delete records the deletion of p and the paths below it.
+func (b *batchPaths) delete(p string) {
+	for written := range b.written {
+		if below(written, p) {
+			delete(b.written, written)
+		}
+	}
+	b.deleted = append(b.deleted, p)
+}
+
+// writable reports whether a file can be written at p: p is not a directory,
+// and none of its parents is a file.
+func (b *batchPaths) writable(p string) bool {
+	if b.isdir(p) {
+		return false
+	}
+	for parent := path.Dir(p); parent != "/"; parent = path.Dir(parent) {
+		if b.isfile(parent) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *batchPaths) isfile(p string) bool {
+	if _, ok := b.written[p]; ok {
+		return true
+	}
+	n := b.stored(p)
+	return n != nil && !n.isdir()
+}
+
+func (b *batchPaths) isdir(p string) bool {
+	for written := range b.written {
+		if written != p && below(written, p) {
+			return true
+		}
+	}
+	n := b.stored(p)
+	return n != nil && n.isdir()
+}
+
+// stored returns the node of the filesystem at p, unless it was deleted.
+func (b *batchPaths) stored(p string) node {
+	for _, deleted := range b.deleted {
+		if below(p, deleted) {
+			return nil
+		}
+	}
+	if n := b.root.find(p); n.path() == p {
+		return n
+	}
+	return nil
+}
+
+// below reports whether p is dir or below it.
+func below(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
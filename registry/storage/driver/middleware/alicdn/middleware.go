@@ -110,6 +110,12 @@ func (ac *aliCDNStorageMiddleware) URLFor(ctx context.Context, path string, opti
 	return acURL, nil
 }
 
+// ApplyBatch forwards the batch to the wrapped driver, which applies it
+// natively if it can.
+func (ac *aliCDNStorageMiddleware) ApplyBatch(ctx context.Context, ops []storagedriver.BatchOperation) error {
+	return storagedriver.ApplyBatch(ctx, ac.StorageDriver, ops)
+}
+
 // init registers the alicdn layerHandler backend.
 func init() {
 	storagemiddleware.Register("alicdn", storagemiddleware.InitFunc(newAliCDNStorageMiddleware))
@@ -209,6 +209,12 @@ func (lh *cloudFrontStorageMiddleware) URLFor(ctx context.Context, path string,
 	return cfURL, nil
 }
 
+// ApplyBatch forwards the batch to the wrapped driver, which applies it
+// natively if it can.
+func (lh *cloudFrontStorageMiddleware) ApplyBatch(ctx context.Context, ops []storagedriver.BatchOperation) error {
+	return storagedriver.ApplyBatch(ctx, lh.StorageDriver, ops)
+}
+
 // init registers the cloudfront layerHandler backend.
 func init() {
 	storagemiddleware.Register("cloudfront", storagemiddleware.InitFunc(newCloudFrontStorageMiddleware))
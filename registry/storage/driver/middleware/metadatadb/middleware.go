@@ -0,0 +1,364 @@
+// Package middleware - metadatadb wrapper for storage drivers, storing the
+// metadata of repositories, their links, tags and referrers, in a database
+// while blobs and uploads remain on the driver it wraps
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+// repositoriesRoot is the directory of the repositories in the storage
+// layout of the registry.
+const repositoriesRoot = "/docker/registry/v2/repositories"
+
+// errNotFound is returned by stores for paths they hold no file at.
+var errNotFound = errors.New("not found")
+
+// store holds the files of the metadata paths, keyed by path. Directories
+// are implied by the paths of the files they hold.
+type store interface {
+	// get returns the content of the file at path and the time it was
+	// written, or errNotFound.
+	get(ctx context.Context, path string) ([]byte, time.Time, error)
+
+	// put writes the file at path, of the repository named repository.
+	put(ctx context.Context, path, repository string, content []byte) error
+
+	// children returns the names of the files and directories directly in
+	// dir.
+	children(ctx context.Context, dir string) ([]string, error)
+
+	// delete deletes the file at path, or the files below path, returning
+	// the number of files deleted.
+	delete(ctx context.Context, path string) (int64, error)
+}
+
+// metadataStorageMiddleware stores the files of the metadata directories of
+// repositories, such as _manifests, _layers and _referrers, in a store, and
+// all other files, such as those of blobs and uploads, in the storage driver
+// it wraps. Directories holding both, the repositories and their ancestors,
+// are merged.
+type metadataStorageMiddleware struct {
+	storagedriver.StorageDriver
+	store store
+}
+
+var _ storagedriver.StorageDriver = &metadataStorageMiddleware{}
+
+// newMetadataStorageMiddleware constructs a metadatadb storage middleware.
+// Required options: dsn, the data source name of the database. Optional
+// options: driver, the name of the database/sql driver, postgres by default,
+// which must be compiled into the registry; table, the table of the
+// metadata, registry_metadata by default, created unless it exists.
+func newMetadataStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	store, err := newSQLStore(options)
+	if err != nil {
+		return nil, err
+	}
+	return &metadataStorageMiddleware{StorageDriver: sd, store: store}, nil
+}
+
+// placement describes where the files at and below a path are stored.
+type placement int
+
+const (
+	// inDriver paths are stored by the wrapped driver.
+	inDriver placement = iota
+	// inStore paths are stored by the store.
+	inStore
+	// merged paths are directories holding paths of both.
+	merged
+)
+
+// place returns where the files at and below p are stored, and the name of
+// the repository of the paths of the store.
+func place(p string) (placement, string) {
+	if p == repositoriesRoot || strings.HasPrefix(repositoriesRoot, p+"/") || p == "/" {
+		return merged, ""
+	}
+	if !strings.HasPrefix(p, repositoriesRoot+"/") {
+		return inDriver, ""
+	}
+
+	components := strings.Split(p[len(repositoriesRoot)+1:], "/")
+	for i, component := range components {
+		// repository names do not have components starting with _
+		if strings.HasPrefix(component, "_") {
+			if component == "_uploads" {
+				return inDriver, ""
+			}
+			return inStore, strings.Join(components[:i], "/")
+		}
+	}
+	return merged, ""
+}
+
+func (mm *metadataStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if where, _ := place(path); where != inStore {
+		return mm.StorageDriver.GetContent(ctx, path)
+	}
+	content, _, err := mm.store.get(ctx, path)
+	if err != nil {
+		return nil, mm.storeError(path, err)
+	}
+	return content, nil
+}
+
+func (mm *metadataStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	where, repository := place(path)
+	if where != inStore {
+		return mm.StorageDriver.PutContent(ctx, path, content)
+	}
+	return mm.storeError(path, mm.store.put(ctx, path, repository, content))
+}
+
+func (mm *metadataStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if where, _ := place(path); where != inStore {
+		return mm.StorageDriver.Reader(ctx, path, offset)
+	}
+	content, _, err := mm.store.get(ctx, path)
+	if err != nil {
+		return nil, mm.storeError(path, err)
+	}
+	if offset < 0 || offset > int64(len(content)) {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: mm.Name()}
+	}
+	return io.NopCloser(bytes.NewReader(content[offset:])), nil
+}
+
+func (mm *metadataStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	where, repository := place(path)
+	if where != inStore {
+		return mm.StorageDriver.Writer(ctx, path, append)
+	}
+
+	w := &storeWriter{mm: mm, path: path, repository: repository}
+	if append {
+		content, _, err := mm.store.get(ctx, path)
+		if err != nil {
+			return nil, mm.storeError(path, err)
+		}
+		w.buf.Write(content)
+	}
+	return w, nil
+}
+
+func (mm *metadataStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	switch where, _ := place(path); where {
+	case inDriver:
+		return mm.StorageDriver.Stat(ctx, path)
+	case merged:
+		fi, err := mm.StorageDriver.Stat(ctx, path)
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return fi, err
+		}
+	}
+
+	content, modified, err := mm.store.get(ctx, path)
+	switch err {
+	case nil:
+		return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+			Path:    path,
+			Size:    int64(len(content)),
+			ModTime: modified,
+		}}, nil
+	case errNotFound:
+	default:
+		return nil, mm.storeError(path, err)
+	}
+
+	children, err := mm.store.children(ctx, path)
+	if err != nil {
+		return nil, mm.storeError(path, err)
+	}
+	if len(children) == 0 {
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: mm.Name()}
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:  path,
+		IsDir: true,
+	}}, nil
+}
+
+func (mm *metadataStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	where, _ := place(path)
+	if where == inDriver {
+		return mm.StorageDriver.List(ctx, path)
+	}
+
+	var entries []string
+	found := false
+	if where == merged {
+		var err error
+		entries, err = mm.StorageDriver.List(ctx, path)
+		switch err.(type) {
+		case nil:
+			found = true
+		case storagedriver.PathNotFoundError:
+		default:
+			return nil, err
+		}
+	}
+
+	children, err := mm.store.children(ctx, path)
+	if err != nil {
+		return nil, mm.storeError(path, err)
+	}
+	if !found && len(children) == 0 {
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: mm.Name()}
+	}
+
+	listed := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		listed[entry] = true
+	}
+	for _, child := range children {
+		if entry := joinPath(path, child); !listed[entry] {
+			listed[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// joinPath returns the path of the entry named name in dir.
+func joinPath(dir, name string) string {
+	if dir == "/" {
+		return "/" + name
+	}
+	return dir + "/" + name
+}
+
+// Move moves the files of the store by copying them, as the metadata paths
+// are small. Moves from or to the store are not made by the registry.
+func (mm *metadataStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	sourceWhere, _ := place(sourcePath)
+	destWhere, _ := place(destPath)
+	if sourceWhere != inStore && destWhere != inStore {
+		return mm.StorageDriver.Move(ctx, sourcePath, destPath)
+	}
+
+	content, err := mm.GetContent(ctx, sourcePath)
+	if err != nil {
+		return err
+	}
+	if err := mm.PutContent(ctx, destPath, content); err != nil {
+		return err
+	}
+	return mm.Delete(ctx, sourcePath)
+}
+
+func (mm *metadataStorageMiddleware) Delete(ctx context.Context, path string) error {
+	where, _ := place(path)
+	if where == inDriver {
+		return mm.StorageDriver.Delete(ctx, path)
+	}
+
+	found := false
+	if where == merged {
+		err := mm.StorageDriver.Delete(ctx, path)
+		switch err.(type) {
+		case nil:
+			found = true
+		case storagedriver.PathNotFoundError:
+		default:
+			return err
+		}
+	}
+
+	deleted, err := mm.store.delete(ctx, path)
+	if err != nil {
+		return mm.storeError(path, err)
+	}
+	if !found && deleted == 0 {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: mm.Name()}
+	}
+	return nil
+}
+
+func (mm *metadataStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if where, _ := place(path); where == inStore {
+		return "", storagedriver.ErrUnsupportedMethod{DriverName: mm.Name()}
+	}
+	return mm.StorageDriver.URLFor(ctx, path, options)
+}
+
+// Walk walks the paths stored by the wrapped driver alone with its own Walk,
+// and the other paths by listing them.
+func (mm *metadataStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	if where, _ := place(path); where == inDriver {
+		return mm.StorageDriver.Walk(ctx, path, f)
+	}
+	return storagedriver.WalkFallback(ctx, mm, path, f)
+}
+
+// storeError converts the errors of the store into those of storage drivers.
+func (mm *metadataStorageMiddleware) storeError(path string, err error) error {
+	switch err {
+	case nil:
+		return nil
+	case errNotFound:
+		return storagedriver.PathNotFoundError{Path: path, DriverName: mm.Name()}
+	default:
+		return storagedriver.Error{DriverName: mm.Name(), Enclosed: err}
+	}
+}
+
+// storeWriter buffers the content written to a path of the store, written
+// to the store on commit.
+type storeWriter struct {
+	mm         *metadataStorageMiddleware
+	path       string
+	repository string
+	buf        bytes.Buffer
+	closed     bool
+	committed  bool
+	cancelled  bool
+}
+
+func (w *storeWriter) Write(p []byte) (int, error) {
+	if w.closed || w.committed || w.cancelled {
+		return 0, errors.New("already closed, committed or cancelled")
+	}
+	return w.buf.Write(p)
+}
+
+func (w *storeWriter) Size() int64 {
+	return int64(w.buf.Len())
+}
+
+func (w *storeWriter) Close() error {
+	if w.closed {
+		return errors.New("already closed")
+	}
+	w.closed = true
+	return nil
+}
+
+func (w *storeWriter) Cancel() error {
+	if w.closed || w.committed {
+		return errors.New("already closed or committed")
+	}
+	w.cancelled = true
+	return nil
+}
+
+func (w *storeWriter) Commit() error {
+	if w.closed || w.committed || w.cancelled {
+		return errors.New("already closed, committed or cancelled")
+	}
+	w.committed = true
+	return w.mm.storeError(w.path, w.mm.store.put(context.Background(), w.path, w.repository, w.buf.Bytes()))
+}
+
+func init() {
+	storagemiddleware.Register("metadatadb", storagemiddleware.InitFunc(newMetadataStorageMiddleware))
+}
@@ -0,0 +1,326 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+// memoryStore is a store for tests, with the semantics of sqlStore.
+type memoryStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	repos map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{files: make(map[string][]byte), repos: make(map[string]string)}
+}
+
+func (s *memoryStore) get(ctx context.Context, path string) ([]byte, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.files[path]
+	if !ok {
+		return nil, time.Time{}, errNotFound
+	}
+	return append([]byte(nil), content...), time.Now(), nil
+}
+
+func (s *memoryStore) put(ctx context.Context, path, repository string, content []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = append([]byte(nil), content...)
+	s.repos[path] = repository
+	return nil
+}
+
+func (s *memoryStore) children(ctx context.Context, dir string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	low, _ := below(dir)
+	names := make(map[string]bool)
+	for path := range s.files {
+		if strings.HasPrefix(path, low) {
+			names[strings.SplitN(path[len(low):], "/", 2)[0]] = true
+		}
+	}
+	var children []string
+	for name := range names {
+		children = append(children, name)
+	}
+	return children, nil
+}
+
+func (s *memoryStore) delete(ctx context.Context, path string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	low, _ := below(path)
+	var deleted int64
+	for p := range s.files {
+		if p == path || strings.HasPrefix(p, low) {
+			delete(s.files, p)
+			delete(s.repos, p)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func TestPlace(t *testing.T) {
+	for _, tc := range []struct {
+		path       string
+		where      placement
+		repository string
+	}{
+		{"/", merged, ""},
+		{"/docker/registry/v2", merged, ""},
+		{"/docker/registry/v2/repositories", merged, ""},
+		{"/docker/registry/v2/repositories/foo/bar", merged, ""},
+		{"/docker/registry/v2/blobs/sha256/ab", inDriver, ""},
+		{"/docker/registry/v2/repositoriesfoo", inDriver, ""},
+		{"/docker/registry/v2/repositories/foo/bar/_uploads/id/data", inDriver, ""},
+		{"/docker/registry/v2/repositories/foo/bar/_manifests", inStore, "foo/bar"},
+		{"/docker/registry/v2/repositories/foo/bar/_manifests/tags/latest/current/link", inStore, "foo/bar"},
+		{"/docker/registry/v2/repositories/foo/_layers/sha256/ab/link", inStore, "foo"},
+	} {
+		where, repository := place(tc.path)
+		if where != tc.where || repository != tc.repository {
+			t.Errorf("place(%s) = %d, %q, expected %d, %q", tc.path, where, repository, tc.where, tc.repository)
+		}
+	}
+}
+
+func TestMetadataOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{},
+		{"dsn": ""},
+		{"dsn": "postgres://localhost/registry", "table": "drop table;"},
+		{"dsn": "postgres://localhost/registry", "driver": "unknown"},
+	} {
+		if _, err := newMetadataStorageMiddleware(inmemory.New(), options); err == nil {
+			t.Errorf("expected an error creating middleware with options %v", options)
+		}
+	}
+}
+
+func TestMetadataFiles(t *testing.T) {
+	ctx := context.Background()
+	base := inmemory.New()
+	store := newMemoryStore()
+	mm := &metadataStorageMiddleware{StorageDriver: base, store: store}
+
+	link := repositoriesRoot + "/foo/_manifests/tags/latest/current/link"
+	upload := repositoriesRoot + "/foo/_uploads/id/startedat"
+	if err := mm.PutContent(ctx, link, []byte("sha256:abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.PutContent(ctx, upload, []byte("now")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := base.Stat(ctx, link); err == nil {
+		t.Fatal("link written to the storage driver")
+	}
+	if _, ok := store.files[upload]; ok {
+		t.Fatal("upload written to the store")
+	}
+
+	content, err := mm.GetContent(ctx, link)
+	if err != nil || string(content) != "sha256:abc" {
+		t.Fatalf("unexpected content: %q, %v", content, err)
+	}
+	rc, err := mm.Reader(ctx, link, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ = io.ReadAll(rc)
+	rc.Close()
+	if string(content) != "abc" {
+		t.Fatalf("unexpected content read: %q", content)
+	}
+
+	entries, err := mm.List(ctx, repositoriesRoot+"/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(entries)
+	if strings.Join(entries, " ") != repositoriesRoot+"/foo/_manifests "+repositoriesRoot+"/foo/_uploads" {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	fi, err := mm.Stat(ctx, repositoriesRoot+"/foo/_manifests/tags")
+	if err != nil || !fi.IsDir() {
+		t.Fatalf("unexpected stat: %v, %v", fi, err)
+	}
+	if _, err := mm.Stat(ctx, repositoriesRoot+"/foo/_layers"); err == nil {
+		t.Fatal("expected an error stating a missing directory")
+	}
+
+	var walked []string
+	if err := mm.Walk(ctx, repositoriesRoot, func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			walked = append(walked, fi.Path())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(walked)
+	if strings.Join(walked, " ") != link+" "+upload {
+		t.Fatalf("unexpected files walked: %v", walked)
+	}
+
+	if err := mm.Delete(ctx, repositoriesRoot+"/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.files) != 0 {
+		t.Fatalf("files left in the store: %v", store.files)
+	}
+	if _, err := base.Stat(ctx, upload); err == nil {
+		t.Fatal("upload left in the storage driver")
+	}
+	if err := mm.Delete(ctx, repositoriesRoot+"/foo"); err == nil {
+		t.Fatal("expected an error deleting a missing path")
+	}
+}
+
+func pushImage(t *testing.T, registry distribution.Namespace) digest.Digest {
+	ctx := context.Background()
+	named, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(repo, layers); err != nil {
+		t.Fatal(err)
+	}
+	var digests []digest.Digest
+	for dgst := range layers {
+		digests = append(digests, dgst)
+	}
+	m, err := testutil.MakeSchema2Manifest(repo, digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := manifests.Put(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+	return dgst
+}
+
+func resolveTag(t *testing.T, registry distribution.Namespace) (digest.Digest, error) {
+	ctx := context.Background()
+	named, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := repo.Tags(ctx).Get(ctx, "latest")
+	return desc.Digest, err
+}
+
+func TestMetadataRegistry(t *testing.T) {
+	ctx := context.Background()
+	base := inmemory.New()
+	store := newMemoryStore()
+	registry, err := storage.NewRegistry(ctx, &metadataStorageMiddleware{StorageDriver: base, store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dgst := pushImage(t, registry)
+	if resolved, err := resolveTag(t, registry); err != nil || resolved != dgst {
+		t.Fatalf("unexpected tag: %s, %v", resolved, err)
+	}
+	if _, err := base.Stat(ctx, repositoriesRoot+"/foo/bar/_manifests"); err == nil {
+		t.Fatal("manifest links written to the storage driver")
+	}
+	if _, err := base.Stat(ctx, "/docker/registry/v2/blobs"); err != nil {
+		t.Fatalf("blobs not written to the storage driver: %v", err)
+	}
+	for path, repository := range store.repos {
+		if repository != "foo/bar" {
+			t.Fatalf("unexpected repository of %s: %s", path, repository)
+		}
+	}
+
+	repos := make([]string, 10)
+	n, err := registry.Repositories(ctx, repos, "")
+	if err != io.EOF || n != 1 || repos[0] != "foo/bar" {
+		t.Fatalf("unexpected catalog: %v, %v", repos[:n], err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	base := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := pushImage(t, registry)
+
+	mm := &metadataStorageMiddleware{StorageDriver: base, store: newMemoryStore()}
+	migrated, err := storage.NewRegistry(ctx, mm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveTag(t, migrated); err == nil {
+		t.Fatal("expected an error resolving a tag before migrating")
+	}
+
+	count, err := Migrate(ctx, mm, MigrateOpts{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatal("no files to migrate")
+	}
+	if _, err := resolveTag(t, migrated); err == nil {
+		t.Fatal("expected an error resolving a tag after a dry run")
+	}
+
+	copied, err := Migrate(ctx, mm, MigrateOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != count {
+		t.Fatalf("copied %d files, expected %d", copied, count)
+	}
+	if resolved, err := resolveTag(t, migrated); err != nil || resolved != dgst {
+		t.Fatalf("unexpected tag after migrating: %s, %v", resolved, err)
+	}
+
+	if _, err := Migrate(ctx, base, MigrateOpts{}); err == nil {
+		t.Fatal("expected an error migrating without the middleware")
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// MigrateOpts contains options for the migration of metadata.
+type MigrateOpts struct {
+	// DryRun counts the files to copy without copying them.
+	DryRun bool
+}
+
+// Migrate copies the metadata paths stored by the storage driver wrapped by
+// sd, a metadatadb storage middleware, such as those of an existing registry,
+// into its database, replacing the files the database holds at the same
+// paths. It returns the number of files copied. The files are left in the
+// storage driver, where they are no longer read, and can be deleted once
+// the registry runs with the middleware.
+func Migrate(ctx context.Context, sd storagedriver.StorageDriver, opts MigrateOpts) (int, error) {
+	mm, ok := sd.(*metadataStorageMiddleware)
+	if !ok {
+		return 0, fmt.Errorf("storage driver %s is not the metadatadb storage middleware", sd.Name())
+	}
+
+	copied := 0
+	err := mm.StorageDriver.Walk(ctx, repositoriesRoot, func(fi storagedriver.FileInfo) error {
+		where, repository := place(fi.Path())
+		if where == inDriver {
+			if fi.IsDir() {
+				return storagedriver.ErrSkipDir
+			}
+			return nil
+		}
+		if where != inStore || fi.IsDir() {
+			return nil
+		}
+
+		copied++
+		if opts.DryRun {
+			return nil
+		}
+		content, err := mm.StorageDriver.GetContent(ctx, fi.Path())
+		if err != nil {
+			return err
+		}
+		if err := mm.store.put(ctx, fi.Path(), repository, content); err != nil {
+			return mm.storeError(fi.Path(), err)
+		}
+		dcontext.GetLogger(ctx).Debugf("migrated %s", fi.Path())
+		return nil
+	})
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		// the storage driver holds no repositories
+		err = nil
+	}
+	return copied, err
+}
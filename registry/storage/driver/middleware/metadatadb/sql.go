@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+const (
+	defaultSQLDriver = "postgres"
+	defaultTable     = "registry_metadata"
+)
+
+var tableRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// sqlStore stores the metadata paths in a PostgreSQL table, a row per file.
+// Paths are compared bytewise, so that the files below a directory are
+// found by the range of the primary key between dir/ and dir0, as 0 follows
+// / in ASCII.
+type sqlStore struct {
+	db    *sql.DB
+	table string
+}
+
+func newSQLStore(options map[string]interface{}) (*sqlStore, error) {
+	driverName, err := storagemiddleware.StringOption(options, "driver", defaultSQLDriver)
+	if err != nil {
+		return nil, err
+	}
+	dsn, err := storagemiddleware.StringOption(options, "dsn", "")
+	if err != nil {
+		return nil, err
+	}
+	if dsn == "" {
+		return nil, fmt.Errorf("dsn must be set")
+	}
+	table, err := storagemiddleware.StringOption(options, "table", defaultTable)
+	if err != nil {
+		return nil, err
+	}
+	if !tableRegexp.MatchString(table) {
+		return nil, fmt.Errorf("invalid table %q", table)
+	}
+
+	if !registeredDriver(driverName) {
+		return nil, fmt.Errorf("database/sql driver %q is not compiled into the registry", driverName)
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata database: %v", err)
+	}
+	s := &sqlStore{db: db, table: table}
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	path text COLLATE "C" PRIMARY KEY,
+	repository text NOT NULL,
+	content bytea NOT NULL,
+	modified timestamptz NOT NULL
+)`, table)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create metadata table: %v", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_repository ON %s (repository)`, table, table)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create metadata index: %v", err)
+	}
+	return s, nil
+}
+
+// registeredDriver reports whether the database/sql driver named name is
+// registered.
+func registeredDriver(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// below returns the bounds of the paths below dir.
+func below(dir string) (string, string) {
+	if dir == "/" {
+		return "/", "0"
+	}
+	return dir + "/", dir + "0"
+}
+
+func (s *sqlStore) get(ctx context.Context, path string) ([]byte, time.Time, error) {
+	var content []byte
+	var modified time.Time
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT content, modified FROM %s WHERE path = $1`, s.table), path).Scan(&content, &modified)
+	if err == sql.ErrNoRows {
+		return nil, time.Time{}, errNotFound
+	}
+	return content, modified, err
+}
+
+// put inserts or replaces the file in a single statement, so that the
+// links of tags are moved atomically.
+func (s *sqlStore) put(ctx context.Context, path, repository string, content []byte) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (path, repository, content, modified) VALUES ($1, $2, $3, now())
+ON CONFLICT (path) DO UPDATE SET content = excluded.content, modified = excluded.modified`, s.table), path, repository, content)
+	return err
+}
+
+func (s *sqlStore) children(ctx context.Context, dir string) ([]string, error) {
+	low, high := below(dir)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT DISTINCT split_part(substr(path, $3), '/', 1) FROM %s WHERE path >= $1 AND path < $2`, s.table), low, high, len(low)+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (s *sqlStore) delete(ctx context.Context, path string) (int64, error) {
+	low, high := below(path)
+	result, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE path = $1 OR (path >= $2 AND path < $3)`, s.table), path, low, high)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	_ "github.com/lib/pq"
+)
+
+// newTestSQLStore returns a store in an empty table of the database
+// REGISTRY_METADATADB_DSN, dropped once the test completes, skipping the test
+// if the variable is not set.
+func newTestSQLStore(t *testing.T) *sqlStore {
+	dsn := os.Getenv("REGISTRY_METADATADB_DSN")
+	if dsn == "" {
+		t.Skip("Must set REGISTRY_METADATADB_DSN to run metadata database tests")
+	}
+	const table = "registry_metadata_test"
+	s, err := newSQLStore(map[string]interface{}{"dsn": dsn, "table": table})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.db.Exec("DROP TABLE " + table)
+		s.db.Close()
+	})
+	if _, err := s.db.Exec("TRUNCATE " + table); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSQLStore(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLStore(t)
+
+	link := repositoriesRoot + "/foo/_manifests/tags/latest/current/link"
+	revision := repositoriesRoot + "/foo/_manifests/revisions/sha256/abc/link"
+	// foo0 sorts right after foo/, and must not be found below foo.
+	sibling := repositoriesRoot + "/foo0/_manifests/tags/latest/current/link"
+	for _, path := range []string{link, revision, sibling} {
+		if err := s.put(ctx, path, "foo", []byte("sha256:abc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.put(ctx, link, "foo", []byte("sha256:def")); err != nil {
+		t.Fatalf("unexpected error replacing a file: %v", err)
+	}
+	content, modified, err := s.get(ctx, link)
+	if err != nil || string(content) != "sha256:def" || modified.IsZero() {
+		t.Fatalf("unexpected file: %q, %v, %v", content, modified, err)
+	}
+	if _, _, err := s.get(ctx, repositoriesRoot+"/foo/_manifests"); err != errNotFound {
+		t.Fatalf("unexpected error getting a directory: %v", err)
+	}
+
+	for dir, expected := range map[string]string{
+		"/":                                  "docker",
+		repositoriesRoot:                     "foo foo0",
+		repositoriesRoot + "/foo/_manifests": "revisions tags",
+		repositoriesRoot + "/bar":            "",
+	} {
+		children, err := s.children(ctx, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(children)
+		if strings.Join(children, " ") != expected {
+			t.Errorf("unexpected children of %s: %v", dir, children)
+		}
+	}
+
+	deleted, err := s.delete(ctx, repositoriesRoot+"/foo")
+	if err != nil || deleted != 2 {
+		t.Fatalf("unexpected deletion: %d, %v", deleted, err)
+	}
+	if _, _, err := s.get(ctx, sibling); err != nil {
+		t.Fatalf("unexpected error getting a file next to the deleted ones: %v", err)
+	}
+	if deleted, err := s.delete(ctx, sibling); err != nil || deleted != 1 {
+		t.Fatalf("unexpected deletion of a file: %d, %v", deleted, err)
+	}
+}
+
+func TestSQLStoreRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, &metadataStorageMiddleware{StorageDriver: inmemory.New(), store: newTestSQLStore(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dgst := pushImage(t, registry)
+	if resolved, err := resolveTag(t, registry); err != nil || resolved != dgst {
+		t.Fatalf("unexpected tag: %s, %v", resolved, err)
+	}
+}
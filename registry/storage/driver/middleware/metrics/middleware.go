@@ -0,0 +1,192 @@
+// Package middleware - metrics wrapper for storage drivers, reporting the
+// number, outcome and duration of storage operations
+package middleware
+
+import (
+	"context"
+	"io"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+var (
+	// operationDuration is the duration of storage operations
+	operationDuration = prometheus.StorageNamespace.NewLabeledTimer("middleware_operation", "The number of seconds storage operations take", "name", "operation")
+
+	// operationCount is the number of storage operations, by outcome
+	operationCount = prometheus.StorageNamespace.NewLabeledCounter("middleware_operations", "The number of storage operations", "name", "operation", "outcome")
+
+	// transferredBytes is the number of bytes read or written through
+	// readers and writers
+	transferredBytes = prometheus.StorageNamespace.NewLabeledCounter("middleware_bytes", "The number of bytes read from or written to storage through readers and writers", "name", "direction")
+)
+
+// metricsStorageMiddleware reports metrics for each operation of the storage
+// driver it wraps. Unlike the metrics of the drivers themselves, they are
+// labeled with the name of the middleware, so that the same metrics can be
+// observed at several points of a chain of middlewares, for instance before
+// and after a cache.
+type metricsStorageMiddleware struct {
+	storagedriver.StorageDriver
+	name string
+}
+
+var _ storagedriver.StorageDriver = &metricsStorageMiddleware{}
+
+// newMetricsStorageMiddleware constructs a metrics storage middleware.
+// Optional options: name, the value of the name label, which defaults to the
+// name of the wrapped driver.
+func newMetricsStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	name, err := storagemiddleware.StringOption(options, "name", sd.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	return &metricsStorageMiddleware{StorageDriver: sd, name: name}, nil
+}
+
+func (m *metricsStorageMiddleware) observe(operation string, start time.Time, err error) {
+	operationDuration.WithValues(m.name, operation).UpdateSince(start)
+
+	outcome := "success"
+	switch err.(type) {
+	case nil:
+	case storagedriver.PathNotFoundError:
+		outcome = "notfound"
+	default:
+		outcome = "error"
+	}
+	operationCount.WithValues(m.name, operation, outcome).Inc(1)
+}
+
+func (m *metricsStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	start := time.Now()
+	content, err := m.StorageDriver.GetContent(ctx, path)
+	m.observe("GetContent", start, err)
+	return content, err
+}
+
+func (m *metricsStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	start := time.Now()
+	err := m.StorageDriver.PutContent(ctx, path, content)
+	m.observe("PutContent", start, err)
+	return err
+}
+
+func (m *metricsStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := m.StorageDriver.Reader(ctx, path, offset)
+	m.observe("Reader", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &countingReader{ReadCloser: rc, name: m.name}, nil
+}
+
+func (m *metricsStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	start := time.Now()
+	fw, err := m.StorageDriver.Writer(ctx, path, append)
+	m.observe("Writer", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &countingWriter{FileWriter: fw, m: m}, nil
+}
+
+func (m *metricsStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	start := time.Now()
+	fi, err := m.StorageDriver.Stat(ctx, path)
+	m.observe("Stat", start, err)
+	return fi, err
+}
+
+func (m *metricsStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	start := time.Now()
+	entries, err := m.StorageDriver.List(ctx, path)
+	m.observe("List", start, err)
+	return entries, err
+}
+
+func (m *metricsStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	start := time.Now()
+	err := m.StorageDriver.Move(ctx, sourcePath, destPath)
+	m.observe("Move", start, err)
+	return err
+}
+
+func (m *metricsStorageMiddleware) Delete(ctx context.Context, path string) error {
+	start := time.Now()
+	err := m.StorageDriver.Delete(ctx, path)
+	m.observe("Delete", start, err)
+	return err
+}
+
+func (m *metricsStorageMiddleware) ApplyBatch(ctx context.Context, ops []storagedriver.BatchOperation) error {
+	start := time.Now()
+	err := storagedriver.ApplyBatch(ctx, m.StorageDriver, ops)
+	if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		m.observe("ApplyBatch", start, err)
+	}
+	return err
+}
+
+func (m *metricsStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	start := time.Now()
+	u, err := m.StorageDriver.URLFor(ctx, path, options)
+	if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		m.observe("URLFor", start, err)
+	}
+	return u, err
+}
+
+func (m *metricsStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	start := time.Now()
+	err := m.StorageDriver.Walk(ctx, path, f)
+	m.observe("Walk", start, err)
+	return err
+}
+
+// countingReader counts the bytes read from storage.
+type countingReader struct {
+	io.ReadCloser
+	name string
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		transferredBytes.WithValues(r.name, "read").Inc(float64(n))
+	}
+	return n, err
+}
+
+// countingWriter counts the bytes written to storage, and reports the
+// outcome of committing them.
+type countingWriter struct {
+	storagedriver.FileWriter
+	m *metricsStorageMiddleware
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.FileWriter.Write(p)
+	if n > 0 {
+		transferredBytes.WithValues(w.m.name, "write").Inc(float64(n))
+	}
+	return n, err
+}
+
+func (w *countingWriter) Commit() error {
+	start := time.Now()
+	err := w.FileWriter.Commit()
+	w.m.observe("Commit", start, err)
+	return err
+}
+
+func init() {
+	storagemiddleware.Register("metrics", storagemiddleware.InitFunc(newMetricsStorageMiddleware))
+}
@@ -0,0 +1,97 @@
+package storagemiddleware
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DurationOption returns the duration set for name in options, or def when it
+// is not set. Durations may be given as strings such as "1m30s", or as a
+// number of nanoseconds.
+func DurationOption(options map[string]interface{}, name string, def time.Duration) (time.Duration, error) {
+	v, ok := options[name]
+	if !ok {
+		return def, nil
+	}
+
+	switch v := v.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		return d, nil
+	case int:
+		return time.Duration(v), nil
+	case int64:
+		return time.Duration(v), nil
+	default:
+		return 0, fmt.Errorf("%s must be a duration, got %T", name, v)
+	}
+}
+
+// IntOption returns the integer set for name in options, or def when it is not
+// set.
+func IntOption(options map[string]interface{}, name string, def int) (int, error) {
+	v, ok := options[name]
+	if !ok {
+		return def, nil
+	}
+
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("%s must be an integer, got %T", name, v)
+	}
+}
+
+// StringOption returns the string set for name in options, or def when it is
+// not set.
+func StringOption(options map[string]interface{}, name string, def string) (string, error) {
+	v, ok := options[name]
+	if !ok {
+		return def, nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, got %T", name, v)
+	}
+	return s, nil
+}
+
+// StringKeys returns a copy of the map v parsed from the configuration, which
+// YAML decodes keyed by interface{}, keyed by strings.
+func StringKeys(v interface{}) (map[string]interface{}, error) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		params := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			params[k] = v
+		}
+		return params, nil
+	case map[interface{}]interface{}:
+		params := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v", k)
+			}
+			params[key] = v
+		}
+		return params, nil
+	}
+	return nil, fmt.Errorf("expected a map, got %T", v)
+}
@@ -0,0 +1,278 @@
+// Package middleware - read-through cache wrapper for storage drivers, keeping
+// small contents and file info in memory
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+const (
+	defaultTTL        = time.Minute
+	defaultMaxEntries = 10000
+	defaultMaxSize    = 1 << 20
+)
+
+// readCacheCount is the number of cache lookups, hits and misses
+var readCacheCount = prometheus.StorageNamespace.NewLabeledCounter("middleware_readcache", "The number of lookups of the read cache storage middleware", "type")
+
+// readCacheStorageMiddleware caches the results of GetContent and Stat calls
+// to the storage driver it wraps, such as the links and manifests read on
+// every pull. Entries are invalidated when the paths they were read from, or
+// paths below them, are written through the middleware. Changes made by other
+// registry instances sharing the same storage are only seen once the entries
+// expire.
+type readCacheStorageMiddleware struct {
+	storagedriver.StorageDriver
+	ttl        time.Duration
+	maxEntries int
+	maxSize    int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+
+	// generation is incremented on each invalidation, so that reads which
+	// started before an invalidation do not fill the cache with what they
+	// read.
+	generation uint64
+}
+
+var _ storagedriver.StorageDriver = &readCacheStorageMiddleware{}
+
+// cacheEntry holds what was read from a path, the content read with
+// GetContent and the file info read with Stat.
+type cacheEntry struct {
+	path    string
+	expires time.Time
+
+	content []byte
+	hasInfo bool
+	info    storagedriver.FileInfo
+}
+
+// newReadCacheStorageMiddleware constructs a read cache storage middleware.
+// Optional options: ttl, how long entries are kept, 1m by default;
+// maxentries, the number of paths cached, 10000 by default; maxsize, the size
+// of the largest content cached, 1MiB by default.
+func newReadCacheStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	ttl, err := storagemiddleware.DurationOption(options, "ttl", defaultTTL)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be positive")
+	}
+
+	maxEntries, err := storagemiddleware.IntOption(options, "maxentries", defaultMaxEntries)
+	if err != nil {
+		return nil, err
+	}
+	if maxEntries < 1 {
+		return nil, fmt.Errorf("maxentries must be at least 1")
+	}
+
+	maxSize, err := storagemiddleware.IntOption(options, "maxsize", defaultMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readCacheStorageMiddleware{
+		StorageDriver: sd,
+		ttl:           ttl,
+		maxEntries:    maxEntries,
+		maxSize:       maxSize,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+	}, nil
+}
+
+// lookup returns the entry cached for path, if any. It must be called with mu
+// held.
+func (rc *readCacheStorageMiddleware) lookup(path string) *cacheEntry {
+	elem, ok := rc.entries[path]
+	if !ok {
+		return nil
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		rc.remove(elem)
+		return nil
+	}
+
+	rc.lru.MoveToFront(elem)
+	return entry
+}
+
+// fill records what was read from path, unless the cache was invalidated
+// since generation.
+func (rc *readCacheStorageMiddleware) fill(path string, generation uint64, update func(*cacheEntry)) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.generation != generation {
+		return
+	}
+
+	entry := rc.lookup(path)
+	if entry == nil {
+		entry = &cacheEntry{path: path, expires: time.Now().Add(rc.ttl)}
+		rc.entries[path] = rc.lru.PushFront(entry)
+
+		if rc.lru.Len() > rc.maxEntries {
+			rc.remove(rc.lru.Back())
+		}
+	}
+	update(entry)
+}
+
+// remove must be called with mu held.
+func (rc *readCacheStorageMiddleware) remove(elem *list.Element) {
+	rc.lru.Remove(elem)
+	delete(rc.entries, elem.Value.(*cacheEntry).path)
+}
+
+// invalidate removes the entries of path, of the directories it is in, whose
+// file info may change with it, and, when recursive, of the paths below it.
+func (rc *readCacheStorageMiddleware) invalidate(path string, recursive bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.generation++
+
+	for p := path; ; {
+		if elem, ok := rc.entries[p]; ok {
+			rc.remove(elem)
+		}
+
+		i := strings.LastIndex(p, "/")
+		if i <= 0 {
+			break
+		}
+		p = p[:i]
+	}
+	if elem, ok := rc.entries["/"]; ok {
+		rc.remove(elem)
+	}
+
+	if recursive {
+		prefix := strings.TrimSuffix(path, "/") + "/"
+		for p, elem := range rc.entries {
+			if strings.HasPrefix(p, prefix) {
+				rc.remove(elem)
+			}
+		}
+	}
+}
+
+func (rc *readCacheStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	readCacheCount.WithValues("Request").Inc(1)
+
+	rc.mu.Lock()
+	if entry := rc.lookup(path); entry != nil && entry.content != nil {
+		content := append([]byte(nil), entry.content...)
+		rc.mu.Unlock()
+		readCacheCount.WithValues("Hit").Inc(1)
+		return content, nil
+	}
+	generation := rc.generation
+	rc.mu.Unlock()
+
+	readCacheCount.WithValues("Miss").Inc(1)
+	content, err := rc.StorageDriver.GetContent(ctx, path)
+	if err != nil || len(content) > rc.maxSize {
+		return content, err
+	}
+
+	cached := append([]byte{}, content...)
+	rc.fill(path, generation, func(entry *cacheEntry) {
+		entry.content = cached
+	})
+	return content, nil
+}
+
+func (rc *readCacheStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	readCacheCount.WithValues("Request").Inc(1)
+
+	rc.mu.Lock()
+	if entry := rc.lookup(path); entry != nil && entry.hasInfo {
+		info := entry.info
+		rc.mu.Unlock()
+		readCacheCount.WithValues("Hit").Inc(1)
+		return info, nil
+	}
+	generation := rc.generation
+	rc.mu.Unlock()
+
+	readCacheCount.WithValues("Miss").Inc(1)
+	info, err := rc.StorageDriver.Stat(ctx, path)
+	if err != nil {
+		return info, err
+	}
+
+	rc.fill(path, generation, func(entry *cacheEntry) {
+		entry.hasInfo = true
+		entry.info = info
+	})
+	return info, nil
+}
+
+func (rc *readCacheStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	defer rc.invalidate(path, false)
+	return rc.StorageDriver.PutContent(ctx, path, content)
+}
+
+func (rc *readCacheStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := rc.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invalidatingWriter{FileWriter: fw, rc: rc, path: path}, nil
+}
+
+func (rc *readCacheStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	defer rc.invalidate(sourcePath, true)
+	defer rc.invalidate(destPath, true)
+	return rc.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+func (rc *readCacheStorageMiddleware) Delete(ctx context.Context, path string) error {
+	defer rc.invalidate(path, true)
+	return rc.StorageDriver.Delete(ctx, path)
+}
+
+func (rc *readCacheStorageMiddleware) ApplyBatch(ctx context.Context, ops []storagedriver.BatchOperation) error {
+	defer func() {
+		for _, op := range ops {
+			rc.invalidate(op.Path, op.Delete)
+		}
+	}()
+	return storagedriver.ApplyBatch(ctx, rc.StorageDriver, ops)
+}
+
+// invalidatingWriter invalidates the cache of the path it writes to once its
+// content becomes visible.
+type invalidatingWriter struct {
+	storagedriver.FileWriter
+	rc   *readCacheStorageMiddleware
+	path string
+}
+
+func (w *invalidatingWriter) Commit() error {
+	defer w.rc.invalidate(w.path, false)
+	return w.FileWriter.Commit()
+}
+
+func init() {
+	storagemiddleware.Register("readcache", storagemiddleware.InitFunc(newReadCacheStorageMiddleware))
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// countingDriver counts the reads made to it.
+type countingDriver struct {
+	storagedriver.StorageDriver
+	reads int
+}
+
+func (d *countingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	d.reads++
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *countingDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	d.reads++
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func newTestMiddleware(t *testing.T, options map[string]interface{}) (*countingDriver, storagedriver.StorageDriver) {
+	d := &countingDriver{StorageDriver: inmemory.New()}
+	sd, err := newReadCacheStorageMiddleware(d, options)
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	return d, sd
+}
+
+func get(t *testing.T, sd storagedriver.StorageDriver, path string) string {
+	content, err := sd.GetContent(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error reading %s: %v", path, err)
+	}
+	return string(content)
+}
+
+func TestReadCacheHit(t *testing.T) {
+	ctx := context.Background()
+	d, sd := newTestMiddleware(t, nil)
+
+	if err := sd.PutContent(ctx, "/a/b", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if content := get(t, sd, "/a/b"); content != "content" {
+			t.Fatalf("unexpected content %q", content)
+		}
+	}
+	if d.reads != 1 {
+		t.Fatalf("expected a single read, got %d", d.reads)
+	}
+}
+
+func TestReadCacheInvalidate(t *testing.T) {
+	ctx := context.Background()
+	d, sd := newTestMiddleware(t, nil)
+
+	if err := sd.PutContent(ctx, "/a/b", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	get(t, sd, "/a/b")
+	if _, err := sd.Stat(ctx, "/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sd.PutContent(ctx, "/a/b", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if content := get(t, sd, "/a/b"); content != "second" {
+		t.Fatalf("expected the write to invalidate the cache, got %q", content)
+	}
+
+	fw, err := sd.Writer(ctx, "/a/b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("third")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if content := get(t, sd, "/a/b"); content != "third" {
+		t.Fatalf("expected the commit to invalidate the cache, got %q", content)
+	}
+
+	// Deleting a directory removes the entries below it.
+	if err := sd.Delete(ctx, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sd.GetContent(ctx, "/a/b"); err == nil {
+		t.Fatal("expected deleted content not to be served from the cache")
+	}
+	if _, err := sd.Stat(ctx, "/a"); err == nil {
+		t.Fatal("expected deleted directory not to be served from the cache")
+	}
+
+	if d.reads != 6 {
+		t.Fatalf("expected 6 reads, got %d", d.reads)
+	}
+}
+
+func TestReadCacheApplyBatch(t *testing.T) {
+	ctx := context.Background()
+	sd, err := newReadCacheStorageMiddleware(inmemory.New(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	if err := sd.PutContent(ctx, "/a", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	get(t, sd, "/a")
+
+	// Batches are applied by the driver wrapped, and invalidate the cache.
+	if err := storagedriver.ApplyBatch(ctx, sd, []storagedriver.BatchOperation{
+		{Path: "/a", Content: []byte("second")},
+		{Path: "/b", Content: []byte("b")},
+	}); err != nil {
+		t.Fatalf("unexpected error applying batch: %v", err)
+	}
+	if content := get(t, sd, "/a"); content != "second" {
+		t.Fatalf("expected the batch to invalidate the cache, got %q", content)
+	}
+
+	// Drivers unable to apply batches report them unsupported.
+	_, sd = newTestMiddleware(t, nil)
+	err = storagedriver.ApplyBatch(ctx, sd, []storagedriver.BatchOperation{{Path: "/a", Content: []byte("a")}})
+	if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		t.Fatalf("expected batches to be unsupported, got %v", err)
+	}
+	if _, err := sd.Stat(ctx, "/a"); err == nil {
+		t.Fatal("expected no write of an unsupported batch to be applied")
+	}
+}
+
+func TestReadCacheLimits(t *testing.T) {
+	ctx := context.Background()
+	d, sd := newTestMiddleware(t, map[string]interface{}{
+		"maxentries": 1,
+		"maxsize":    4,
+	})
+
+	if err := sd.PutContent(ctx, "/large", []byte("too large")); err != nil {
+		t.Fatal(err)
+	}
+	get(t, sd, "/large")
+	get(t, sd, "/large")
+	if d.reads != 2 {
+		t.Fatalf("expected large content not to be cached, got %d reads", d.reads)
+	}
+
+	for _, p := range []string{"/a", "/b"} {
+		if err := sd.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+		get(t, sd, p)
+	}
+	get(t, sd, "/a")
+	if d.reads != 5 {
+		t.Fatalf("expected the least recently used entry to be evicted, got %d reads", d.reads)
+	}
+}
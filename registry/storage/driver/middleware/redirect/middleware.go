@@ -45,6 +45,12 @@ func (r *redirectStorageMiddleware) URLFor(ctx context.Context, path string, opt
 	return u.String(), nil
 }
 
+// ApplyBatch forwards the batch to the wrapped driver, which applies it
+// natively if it can.
+func (r *redirectStorageMiddleware) ApplyBatch(ctx context.Context, ops []storagedriver.BatchOperation) error {
+	return storagedriver.ApplyBatch(ctx, r.StorageDriver, ops)
+}
+
 func init() {
 	storagemiddleware.Register("redirect", storagemiddleware.InitFunc(newRedirectStorageMiddleware))
 }
@@ -0,0 +1,235 @@
+// Package middleware - retry wrapper for storage drivers, retrying operations
+// failing with transient errors with an exponential backoff
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+const (
+	defaultMaxAttempts    = 3
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+// retries is the number of storage operations retried
+var retries = prometheus.StorageNamespace.NewLabeledCounter("middleware_retries", "The number of storage operations retried after a transient error", "operation")
+
+// retryableOperations are the operations which can safely be repeated, and
+// are retried unless the operations option restricts them: Move, Writer and
+// Walk are passed through as is, as are readers and writers once opened.
+var retryableOperations = []string{"GetContent", "PutContent", "Reader", "Stat", "List", "Delete", "URLFor"}
+
+// retryStorageMiddleware retries the operations of the storage driver it
+// wraps which fail with a transient error.
+type retryStorageMiddleware struct {
+	storagedriver.StorageDriver
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	operations     map[string]bool
+}
+
+var _ storagedriver.StorageDriver = &retryStorageMiddleware{}
+
+// newRetryStorageMiddleware constructs a retry storage middleware.
+// Optional options: maxattempts, the number of attempts made for an
+// operation, 3 by default; initialbackoff, the delay before the first retry,
+// 100ms by default, doubled for each subsequent retry up to maxbackoff, 5s by
+// default; operations, the list of the operations retried, all those which
+// can safely be repeated by default.
+func newRetryStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	maxAttempts, err := storagemiddleware.IntOption(options, "maxattempts", defaultMaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	if maxAttempts < 1 {
+		return nil, fmt.Errorf("maxattempts must be at least 1")
+	}
+
+	initialBackoff, err := storagemiddleware.DurationOption(options, "initialbackoff", defaultInitialBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBackoff, err := storagemiddleware.DurationOption(options, "maxbackoff", defaultMaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	if maxBackoff < initialBackoff {
+		return nil, fmt.Errorf("maxbackoff must not be less than initialbackoff")
+	}
+
+	operations, err := operationsOption(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &retryStorageMiddleware{
+		StorageDriver:  sd,
+		maxAttempts:    maxAttempts,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		operations:     operations,
+	}, nil
+}
+
+// operationsOption returns the set of the operations listed by the
+// operations option, or of all the retryable operations when it is not set.
+// The names of the operations are case insensitive.
+func operationsOption(options map[string]interface{}) (map[string]bool, error) {
+	operations := make(map[string]bool, len(retryableOperations))
+	v, ok := options["operations"]
+	if !ok {
+		for _, operation := range retryableOperations {
+			operations[operation] = true
+		}
+		return operations, nil
+	}
+
+	listed, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("operations must be a list, got %T", v)
+	}
+	for _, l := range listed {
+		name, ok := l.(string)
+		if !ok {
+			return nil, fmt.Errorf("operations must be a list of strings, got %T", l)
+		}
+		var found bool
+		for _, operation := range retryableOperations {
+			if strings.EqualFold(name, operation) {
+				operations[operation] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("operation %s cannot be retried, retryable operations: %s", name, strings.Join(retryableOperations, ", "))
+		}
+	}
+	return operations, nil
+}
+
+// do calls op until it succeeds, fails with an error which is not transient
+// or has been attempted maxAttempts times.
+func (r *retryStorageMiddleware) do(ctx context.Context, operation, path string, op func(attempt int) error) error {
+	if !r.operations[operation] {
+		return op(1)
+	}
+
+	backoff := r.initialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := op(attempt)
+		if attempt == r.maxAttempts || ctx.Err() != nil || !storagedriver.Transient(err) {
+			return err
+		}
+
+		// Wait between half and all of the backoff, so that the retries of
+		// operations which failed together are spread out.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		dcontext.GetLogger(ctx).Warnf("storage %s of %s failed, retrying in %s: %v", operation, path, wait, err)
+		retries.WithValues(operation).Inc(1)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+func (r *retryStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	var content []byte
+	err := r.do(ctx, "GetContent", path, func(int) error {
+		var err error
+		content, err = r.StorageDriver.GetContent(ctx, path)
+		return err
+	})
+	return content, err
+}
+
+func (r *retryStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	return r.do(ctx, "PutContent", path, func(int) error {
+		return r.StorageDriver.PutContent(ctx, path, content)
+	})
+}
+
+func (r *retryStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := r.do(ctx, "Reader", path, func(int) error {
+		var err error
+		rc, err = r.StorageDriver.Reader(ctx, path, offset)
+		return err
+	})
+	return rc, err
+}
+
+func (r *retryStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	var fi storagedriver.FileInfo
+	err := r.do(ctx, "Stat", path, func(int) error {
+		var err error
+		fi, err = r.StorageDriver.Stat(ctx, path)
+		return err
+	})
+	return fi, err
+}
+
+func (r *retryStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	var entries []string
+	err := r.do(ctx, "List", path, func(int) error {
+		var err error
+		entries, err = r.StorageDriver.List(ctx, path)
+		return err
+	})
+	return entries, err
+}
+
+func (r *retryStorageMiddleware) Delete(ctx context.Context, path string) error {
+	return r.do(ctx, "Delete", path, func(attempt int) error {
+		err := r.StorageDriver.Delete(ctx, path)
+
+		// A failed attempt may still have deleted the path.
+		if _, ok := err.(storagedriver.PathNotFoundError); ok && attempt > 1 {
+			return nil
+		}
+		return err
+	})
+}
+
+func (r *retryStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	var u string
+	err := r.do(ctx, "URLFor", path, func(int) error {
+		var err error
+		u, err = r.StorageDriver.URLFor(ctx, path, options)
+		return err
+	})
+	return u, err
+}
+
+// ApplyBatch forwards the batch to the wrapped driver, which applies it
+// natively if it can.
+func (r *retryStorageMiddleware) ApplyBatch(ctx context.Context, ops []storagedriver.BatchOperation) error {
+	return storagedriver.ApplyBatch(ctx, r.StorageDriver, ops)
+}
+
+func init() {
+	storagemiddleware.Register("retry", storagemiddleware.InitFunc(newRetryStorageMiddleware))
+}
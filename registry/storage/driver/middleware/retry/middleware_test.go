@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// flakyDriver fails the first calls made to it with a transient error.
+type flakyDriver struct {
+	storagedriver.StorageDriver
+	failures int
+	calls    int
+}
+
+func (d *flakyDriver) fail() error {
+	d.calls++
+	if d.calls <= d.failures {
+		return errors.New("connection reset by peer")
+	}
+	return nil
+}
+
+func (d *flakyDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if err := d.fail(); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *flakyDriver) Delete(ctx context.Context, path string) error {
+	err := d.StorageDriver.Delete(ctx, path)
+	if err := d.fail(); err != nil {
+		return err
+	}
+	return err
+}
+
+func newTestMiddleware(t *testing.T, d storagedriver.StorageDriver) storagedriver.StorageDriver {
+	sd, err := newRetryStorageMiddleware(d, map[string]interface{}{
+		"initialbackoff": "1ms",
+		"maxbackoff":     "2ms",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	return sd
+}
+
+func TestRetryTransient(t *testing.T) {
+	ctx := context.Background()
+	d := &flakyDriver{StorageDriver: inmemory.New(), failures: 2}
+	if err := d.StorageDriver.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := newTestMiddleware(t, d).GetContent(ctx, "/a")
+	if err != nil {
+		t.Fatalf("expected the read to be retried, got %v", err)
+	}
+	if string(content) != "content" || d.calls != 3 {
+		t.Fatalf("unexpected content %q after %d calls", content, d.calls)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	d := &flakyDriver{StorageDriver: inmemory.New(), failures: 5}
+
+	if _, err := newTestMiddleware(t, d).GetContent(context.Background(), "/a"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if d.calls != defaultMaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", defaultMaxAttempts, d.calls)
+	}
+}
+
+func TestRetryNotFound(t *testing.T) {
+	d := &flakyDriver{StorageDriver: inmemory.New()}
+
+	_, err := newTestMiddleware(t, d).GetContent(context.Background(), "/a")
+	if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected a path not found error, got %v", err)
+	}
+	if d.calls != 1 {
+		t.Fatalf("expected a single attempt, got %d", d.calls)
+	}
+}
+
+func TestRetryDelete(t *testing.T) {
+	ctx := context.Background()
+	d := &flakyDriver{StorageDriver: inmemory.New(), failures: 1}
+	if err := d.StorageDriver.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first attempt deletes the content but fails.
+	if err := newTestMiddleware(t, d).Delete(ctx, "/a"); err != nil {
+		t.Fatalf("expected the delete to succeed, got %v", err)
+	}
+}
+
+func TestRetryOperations(t *testing.T) {
+	ctx := context.Background()
+	d := &flakyDriver{StorageDriver: inmemory.New(), failures: 1}
+	if err := d.StorageDriver.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := newRetryStorageMiddleware(d, map[string]interface{}{
+		"initialbackoff": "1ms",
+		"operations":     []interface{}{"stat", "list"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	// Operations left out of the list are not retried.
+	if _, err := sd.GetContent(ctx, "/a"); err == nil {
+		t.Fatal("expected the read not to be retried")
+	}
+	if d.calls != 1 {
+		t.Fatalf("expected a single attempt, got %d", d.calls)
+	}
+
+	if _, err := newRetryStorageMiddleware(d, map[string]interface{}{"operations": []interface{}{"move"}}); err == nil {
+		t.Fatal("expected moves to be refused as retried operations")
+	}
+}
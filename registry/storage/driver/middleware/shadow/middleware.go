@@ -0,0 +1,240 @@
+// Package middleware - shadow wrapper for storage drivers, copying the writes
+// made to the driver it wraps to a shadow driver in the background
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+const (
+	defaultWorkers   = 4
+	defaultQueueSize = 10000
+)
+
+// shadowCount is the number of paths queued, copied, failed to copy and
+// dropped from the queue
+var shadowCount = prometheus.StorageNamespace.NewLabeledCounter("middleware_shadow", "The number of paths copied to the shadow storage driver", "result")
+
+// shadowStorageMiddleware copies the paths written, moved and deleted through
+// the storage driver it wraps, the primary, to a shadow storage driver in the
+// background, while all reads are served by the primary. A path is copied by
+// reading its current content from the primary, or deleted from the shadow
+// when it no longer exists, so copies may be repeated and applied in any
+// order. Each path is copied by the same worker, so that the copies of a path
+// are not raced.
+type shadowStorageMiddleware struct {
+	storagedriver.StorageDriver
+	shadow storagedriver.StorageDriver
+	queues []chan string
+}
+
+var _ storagedriver.StorageDriver = &shadowStorageMiddleware{}
+
+// newShadowStorageMiddleware constructs a shadow storage middleware.
+// Required options: driver, the shadow storage driver, a map with a single key
+// naming the driver whose value holds its parameters. Optional options:
+// workers, the number of paths copied concurrently, 4 by default; queuesize,
+// the number of paths waiting to be copied by each worker, beyond which paths
+// are dropped, 10000 by default.
+func newShadowStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	workers, err := storagemiddleware.IntOption(options, "workers", defaultWorkers)
+	if err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		return nil, fmt.Errorf("workers must be at least 1")
+	}
+
+	queueSize, err := storagemiddleware.IntOption(options, "queuesize", defaultQueueSize)
+	if err != nil {
+		return nil, err
+	}
+	if queueSize < 1 {
+		return nil, fmt.Errorf("queuesize must be at least 1")
+	}
+
+	shadow, err := NewShadowDriver(options)
+	if err != nil {
+		return nil, err
+	}
+
+	sm := &shadowStorageMiddleware{
+		StorageDriver: sd,
+		shadow:        shadow,
+		queues:        make([]chan string, workers),
+	}
+	for i := range sm.queues {
+		sm.queues[i] = make(chan string, queueSize)
+		go sm.work(sm.queues[i])
+	}
+	return sm, nil
+}
+
+// NewShadowDriver constructs the shadow storage driver configured by the
+// driver option of the options of a shadow storage middleware.
+func NewShadowDriver(options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	params, err := storagemiddleware.StringKeys(options["driver"])
+	if err != nil || len(params) != 1 {
+		return nil, fmt.Errorf("driver must configure exactly one storage driver")
+	}
+
+	var name string
+	var driverParams interface{}
+	for name, driverParams = range params {
+	}
+
+	var p map[string]interface{}
+	if driverParams != nil {
+		p, err = storagemiddleware.StringKeys(driverParams)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameters of the %s driver: %v", name, err)
+		}
+	}
+	d, err := factory.Create(name, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct %s shadow driver: %v", name, err)
+	}
+	return d, nil
+}
+
+// enqueue queues path to be copied to the shadow driver. The path is dropped
+// when the queue of its worker is full, rather than slowing down the primary;
+// dropped paths are reported by verifying the shadow driver.
+func (sm *shadowStorageMiddleware) enqueue(path string) {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+
+	select {
+	case sm.queues[h.Sum32()%uint32(len(sm.queues))] <- path:
+		shadowCount.WithValues("Queued").Inc(1)
+	default:
+		shadowCount.WithValues("Dropped").Inc(1)
+		dcontext.GetLogger(context.Background()).Warnf("shadow storage queue full, dropped %s", path)
+	}
+}
+
+func (sm *shadowStorageMiddleware) work(queue chan string) {
+	ctx := context.Background()
+	for path := range queue {
+		if err := syncPath(ctx, sm.StorageDriver, sm.shadow, path); err != nil {
+			shadowCount.WithValues("Failed").Inc(1)
+			dcontext.GetLogger(ctx).Errorf("failed to copy %s to shadow storage: %v", path, err)
+			continue
+		}
+		shadowCount.WithValues("Copied").Inc(1)
+	}
+}
+
+// syncPath makes path in shadow match path in primary: the files at and below
+// path are copied, or path is deleted when it does not exist in primary.
+func syncPath(ctx context.Context, primary, shadow storagedriver.StorageDriver, path string) error {
+	fi, err := primary.Stat(ctx, path)
+	switch err.(type) {
+	case nil:
+	case storagedriver.PathNotFoundError:
+		if err := shadow.Delete(ctx, path); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+		return nil
+	default:
+		return err
+	}
+
+	if !fi.IsDir() {
+		return copyFile(ctx, primary, shadow, path)
+	}
+	return primary.Walk(ctx, path, func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			return nil
+		}
+		return copyFile(ctx, primary, shadow, fi.Path())
+	})
+}
+
+// copyFile copies the file at path from source to dest.
+func copyFile(ctx context.Context, source, dest storagedriver.StorageDriver, path string) error {
+	rc, err := source.Reader(ctx, path, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := dest.Writer(ctx, path, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, rc); err != nil {
+		fw.Cancel()
+		return err
+	}
+	if err := fw.Commit(); err != nil {
+		return err
+	}
+	return fw.Close()
+}
+
+func (sm *shadowStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	if err := sm.StorageDriver.PutContent(ctx, path, content); err != nil {
+		return err
+	}
+	sm.enqueue(path)
+	return nil
+}
+
+func (sm *shadowStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := sm.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+
+	return &shadowWriter{FileWriter: fw, sm: sm, path: path}, nil
+}
+
+func (sm *shadowStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := sm.StorageDriver.Move(ctx, sourcePath, destPath); err != nil {
+		return err
+	}
+	sm.enqueue(destPath)
+	sm.enqueue(sourcePath)
+	return nil
+}
+
+func (sm *shadowStorageMiddleware) Delete(ctx context.Context, path string) error {
+	if err := sm.StorageDriver.Delete(ctx, path); err != nil {
+		return err
+	}
+	sm.enqueue(path)
+	return nil
+}
+
+// shadowWriter queues the path it writes to once its content becomes visible.
+// Writers closed without being committed, such as those of uploads in
+// progress, are not copied.
+type shadowWriter struct {
+	storagedriver.FileWriter
+	sm   *shadowStorageMiddleware
+	path string
+}
+
+func (w *shadowWriter) Commit() error {
+	if err := w.FileWriter.Commit(); err != nil {
+		return err
+	}
+	w.sm.enqueue(w.path)
+	return nil
+}
+
+func init() {
+	storagemiddleware.Register("shadow", storagemiddleware.InitFunc(newShadowStorageMiddleware))
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func newTestMiddleware(t *testing.T) (primary storagedriver.StorageDriver, sm *shadowStorageMiddleware) {
+	primary = inmemory.New()
+	sd, err := newShadowStorageMiddleware(primary, map[string]interface{}{
+		"driver": map[interface{}]interface{}{"inmemory": nil},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	return primary, sd.(*shadowStorageMiddleware)
+}
+
+// waitConsistent waits for the shadow driver to catch up with the primary.
+func waitConsistent(t *testing.T, primary, shadow storagedriver.StorageDriver) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		report, err := Verify(context.Background(), primary, shadow, VerifyOpts{})
+		if err != nil {
+			t.Fatalf("unexpected error verifying shadow: %v", err)
+		}
+		if report.Consistent() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("shadow did not catch up with primary: %+v", report)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShadowOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{},
+		{"driver": "inmemory"},
+		{"driver": map[interface{}]interface{}{}},
+		{"driver": map[interface{}]interface{}{"inmemory": nil, "filesystem": nil}},
+		{"driver": map[interface{}]interface{}{"unknown": nil}},
+		{"driver": map[interface{}]interface{}{"inmemory": nil}, "workers": 0},
+		{"driver": map[interface{}]interface{}{"inmemory": nil}, "queuesize": "none"},
+	} {
+		if _, err := newShadowStorageMiddleware(inmemory.New(), options); err == nil {
+			t.Errorf("expected an error creating middleware with options %v", options)
+		}
+	}
+}
+
+func TestShadowCopiesWrites(t *testing.T) {
+	ctx := context.Background()
+	primary, sm := newTestMiddleware(t)
+
+	if err := sm.PutContent(ctx, "/a/link", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.PutContent(ctx, "/a/link", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	fw, err := sm.Writer(ctx, "/uploads/data", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("blob")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+	waitConsistent(t, primary, sm.shadow)
+
+	if err := sm.Move(ctx, "/uploads/data", "/blobs/data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.PutContent(ctx, "/b/link", []byte("link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Delete(ctx, "/b"); err != nil {
+		t.Fatal(err)
+	}
+	waitConsistent(t, primary, sm.shadow)
+
+	if content, err := sm.shadow.GetContent(ctx, "/a/link"); err != nil || string(content) != "second" {
+		t.Fatalf("unexpected shadow content %q: %v", content, err)
+	}
+	if _, err := sm.shadow.Stat(ctx, "/uploads/data"); err == nil {
+		t.Fatal("expected moved file to be removed from shadow")
+	}
+
+	// Reads are served by the primary only.
+	if err := sm.shadow.PutContent(ctx, "/a/link", []byte("shadow")); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := sm.GetContent(ctx, "/a/link"); err != nil || string(content) != "second" {
+		t.Fatalf("expected content to be read from primary, got %q: %v", content, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	primary, shadow := inmemory.New(), inmemory.New()
+
+	for path, content := range map[string]string{
+		"/docker/registry/v2/blobs/sha256/ab/abcd/data":                            "blob",
+		"/docker/registry/v2/blobs/sha256/cd/cdef/data":                            "missing",
+		"/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link": "sha256:abcd",
+		"/docker/registry/v2/repositories/foo/_uploads/1234/data":                  "upload",
+	} {
+		if err := primary.PutContent(ctx, path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, content := range map[string]string{
+		"/docker/registry/v2/blobs/sha256/ab/abcd/data":                            "blob",
+		"/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link": "sha256:0123",
+		"/docker/registry/v2/repositories/bar/_manifests/tags/latest/current/link": "sha256:abcd",
+	} {
+		if err := shadow.PutContent(ctx, path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := Verify(ctx, primary, shadow, VerifyOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error verifying shadow: %v", err)
+	}
+	if report.Consistent() || report.Files != 3 || report.Repaired != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(report.Missing) != 1 || report.Missing[0] != "/docker/registry/v2/blobs/sha256/cd/cdef/data" {
+		t.Fatalf("unexpected missing files: %v", report.Missing)
+	}
+	if len(report.Mismatched) != 1 || report.Mismatched[0] != "/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link" {
+		t.Fatalf("unexpected mismatched files: %v", report.Mismatched)
+	}
+	if len(report.Extra) != 1 || report.Extra[0] != "/docker/registry/v2/repositories/bar/_manifests/tags/latest/current/link" {
+		t.Fatalf("unexpected extra files: %v", report.Extra)
+	}
+
+	report, err = Verify(ctx, primary, shadow, VerifyOpts{Repair: true})
+	if err != nil {
+		t.Fatalf("unexpected error repairing shadow: %v", err)
+	}
+	if report.Repaired != 3 {
+		t.Fatalf("expected 3 files to be repaired, got %+v", report)
+	}
+
+	report, err = Verify(ctx, primary, shadow, VerifyOpts{})
+	if err != nil || !report.Consistent() {
+		t.Fatalf("expected shadow to be consistent once repaired: %+v, %v", report, err)
+	}
+}
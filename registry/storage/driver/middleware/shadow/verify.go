@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// blobsPrefix is the prefix of the paths of blob data, which is content
+// addressed and therefore compared by size only.
+const blobsPrefix = "/docker/registry/v2/blobs/"
+
+// VerifyOpts contains options for verifying a shadow storage driver
+type VerifyOpts struct {
+	// Repair copies the files missing or differing in the shadow driver and
+	// deletes the files only found in it.
+	Repair bool
+}
+
+// Report is the result of verifying that a shadow storage driver holds the
+// content of the primary storage driver, before cutting over to it.
+type Report struct {
+	// Files is the number of files of the primary driver checked.
+	Files int `json:"files"`
+	// Missing lists the files of the primary driver missing in the shadow.
+	Missing []string `json:"missing,omitempty"`
+	// Mismatched lists the files whose content differs in the shadow.
+	Mismatched []string `json:"mismatched,omitempty"`
+	// Extra lists the files of the shadow missing in the primary driver.
+	Extra []string `json:"extra,omitempty"`
+	// Repaired is the number of files copied or deleted when repairing.
+	Repaired int `json:"repaired"`
+}
+
+// Consistent reports whether the shadow driver held the content of the
+// primary driver when verified.
+func (r *Report) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Mismatched) == 0 && len(r.Extra) == 0
+}
+
+// Verify compares the files of the primary and shadow storage drivers. Blob
+// data is compared by size, other files, such as links and manifests, by
+// content. Uploads in progress are skipped, as their data is only copied once
+// they are committed.
+func Verify(ctx context.Context, primary, shadow storagedriver.StorageDriver, opts VerifyOpts) (*Report, error) {
+	report := &Report{}
+
+	err := walkFiles(ctx, primary, func(fi storagedriver.FileInfo) error {
+		report.Files++
+
+		same, err := sameFile(ctx, primary, shadow, fi)
+		switch err.(type) {
+		case nil:
+			if same {
+				return nil
+			}
+			report.Mismatched = append(report.Mismatched, fi.Path())
+		case storagedriver.PathNotFoundError:
+			report.Missing = append(report.Missing, fi.Path())
+		default:
+			return err
+		}
+
+		if !opts.Repair {
+			return nil
+		}
+		if err := copyFile(ctx, primary, shadow, fi.Path()); err != nil {
+			return err
+		}
+		report.Repaired++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	err = walkFiles(ctx, shadow, func(fi storagedriver.FileInfo) error {
+		_, err := primary.Stat(ctx, fi.Path())
+		switch err.(type) {
+		case nil:
+			return nil
+		case storagedriver.PathNotFoundError:
+		default:
+			return err
+		}
+
+		report.Extra = append(report.Extra, fi.Path())
+		if !opts.Repair {
+			return nil
+		}
+		if err := shadow.Delete(ctx, fi.Path()); err != nil {
+			return err
+		}
+		report.Repaired++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	dcontext.GetLogger(ctx).Infof("verified %d files: %d missing, %d mismatched, %d extra, %d repaired",
+		report.Files, len(report.Missing), len(report.Mismatched), len(report.Extra), report.Repaired)
+	return report, nil
+}
+
+// walkFiles calls f for each file of d, other than those of uploads in
+// progress. An empty driver has no files.
+func walkFiles(ctx context.Context, d storagedriver.StorageDriver, f func(fi storagedriver.FileInfo) error) error {
+	err := d.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			if strings.HasSuffix(fi.Path(), "/_uploads") {
+				return storagedriver.ErrSkipDir
+			}
+			return nil
+		}
+		return f(fi)
+	})
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// sameFile reports whether the file of primary described by fi has the same
+// content in shadow.
+func sameFile(ctx context.Context, primary, shadow storagedriver.StorageDriver, fi storagedriver.FileInfo) (bool, error) {
+	shadowFi, err := shadow.Stat(ctx, fi.Path())
+	if err != nil {
+		return false, err
+	}
+	if shadowFi.IsDir() || shadowFi.Size() != fi.Size() {
+		return false, nil
+	}
+	if strings.HasPrefix(fi.Path(), blobsPrefix) {
+		return true, nil
+	}
+
+	content, err := primary.GetContent(ctx, fi.Path())
+	if err != nil {
+		return false, err
+	}
+	shadowContent, err := shadow.GetContent(ctx, fi.Path())
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(content, shadowContent), nil
+}
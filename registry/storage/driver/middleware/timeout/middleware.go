@@ -0,0 +1,181 @@
+// Package middleware - timeout wrapper for storage drivers, bounding the time
+// storage operations are waited on
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+// timeouts is the number of storage operations which timed out
+var timeouts = prometheus.StorageNamespace.NewLabeledCounter("middleware_timeouts", "The number of storage operations which timed out", "operation")
+
+// timeoutStorageMiddleware bounds the time the operations of the storage
+// driver it wraps are waited on. Each operation runs with a context whose
+// deadline is its timeout, and is abandoned when the timeout expires, so that
+// drivers ignoring their context do not hang requests either. Readers,
+// writers and walks, whose duration depends on the size of what they go
+// through, are passed through as is.
+type timeoutStorageMiddleware struct {
+	storagedriver.StorageDriver
+	timeouts map[string]time.Duration
+}
+
+var _ storagedriver.StorageDriver = &timeoutStorageMiddleware{}
+
+// operations maps the options setting the timeouts of operations to the
+// operations.
+var operations = map[string]string{
+	"stat":       "Stat",
+	"getcontent": "GetContent",
+	"putcontent": "PutContent",
+	"list":       "List",
+	"move":       "Move",
+	"delete":     "Delete",
+}
+
+// newTimeoutStorageMiddleware constructs a timeout storage middleware.
+// Optional options: default, the timeout of the operations without one of
+// their own; stat, getcontent, putcontent, list, move and delete, the timeouts
+// of these operations. A zero timeout disables the timeout of an operation.
+func newTimeoutStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	def, err := storagemiddleware.DurationOption(options, "default", 0)
+	if err != nil {
+		return nil, err
+	}
+	if def < 0 {
+		return nil, fmt.Errorf("default must not be negative")
+	}
+
+	timeouts := make(map[string]time.Duration, len(operations))
+	for name, operation := range operations {
+		timeout, err := storagemiddleware.DurationOption(options, name, def)
+		if err != nil {
+			return nil, err
+		}
+		if timeout < 0 {
+			return nil, fmt.Errorf("%s must not be negative", name)
+		}
+		timeouts[operation] = timeout
+	}
+
+	return &timeoutStorageMiddleware{StorageDriver: sd, timeouts: timeouts}, nil
+}
+
+// do calls op with a context expiring after the timeout of operation, and
+// returns a storagedriver.TimeoutError if op has not returned by then. The
+// results of abandoned calls are discarded.
+func (t *timeoutStorageMiddleware) do(ctx context.Context, operation, path string, op func(ctx context.Context) error) error {
+	timeout := t.timeouts[operation]
+	if timeout == 0 {
+		return op(ctx)
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- op(opCtx)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || opCtx.Err() == nil {
+			return err
+		}
+	case <-opCtx.Done():
+	}
+
+	// The context of the caller may be done rather than the timeout
+	// expired, in which case the operation did not time out on its own.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	timeouts.WithValues(operation).Inc(1)
+	return storagedriver.TimeoutError{
+		Operation:  operation,
+		Path:       path,
+		Timeout:    timeout,
+		DriverName: t.StorageDriver.Name(),
+	}
+}
+
+func (t *timeoutStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	var content []byte
+	err := t.do(ctx, "GetContent", path, func(ctx context.Context) error {
+		var err error
+		content, err = t.StorageDriver.GetContent(ctx, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func (t *timeoutStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	return t.do(ctx, "PutContent", path, func(ctx context.Context) error {
+		return t.StorageDriver.PutContent(ctx, path, content)
+	})
+}
+
+func (t *timeoutStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	var fi storagedriver.FileInfo
+	err := t.do(ctx, "Stat", path, func(ctx context.Context) error {
+		var err error
+		fi, err = t.StorageDriver.Stat(ctx, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+func (t *timeoutStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	var entries []string
+	err := t.do(ctx, "List", path, func(ctx context.Context) error {
+		var err error
+		entries, err = t.StorageDriver.List(ctx, path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (t *timeoutStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	return t.do(ctx, "Move", sourcePath, func(ctx context.Context) error {
+		return t.StorageDriver.Move(ctx, sourcePath, destPath)
+	})
+}
+
+func (t *timeoutStorageMiddleware) Delete(ctx context.Context, path string) error {
+	return t.do(ctx, "Delete", path, func(ctx context.Context) error {
+		return t.StorageDriver.Delete(ctx, path)
+	})
+}
+
+// ApplyBatch is bounded by the timeout of PutContent, as batches write small
+// contents.
+func (t *timeoutStorageMiddleware) ApplyBatch(ctx context.Context, ops []storagedriver.BatchOperation) error {
+	var path string
+	if len(ops) > 0 {
+		path = ops[0].Path
+	}
+	return t.do(ctx, "PutContent", path, func(ctx context.Context) error {
+		return storagedriver.ApplyBatch(ctx, t.StorageDriver, ops)
+	})
+}
+
+func init() {
+	storagemiddleware.Register("timeout", storagemiddleware.InitFunc(newTimeoutStorageMiddleware))
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// hungDriver hangs stating paths, ignoring the context of the calls.
+type hungDriver struct {
+	storagedriver.StorageDriver
+	release chan struct{}
+}
+
+func (d *hungDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	<-d.release
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func TestTimeout(t *testing.T) {
+	ctx := context.Background()
+	d := &hungDriver{StorageDriver: inmemory.New(), release: make(chan struct{})}
+	defer close(d.release)
+	if err := d.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := newTimeoutStorageMiddleware(d, map[string]interface{}{
+		"default": "1s",
+		"stat":    "10ms",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+
+	start := time.Now()
+	_, err = sd.Stat(ctx, "/a")
+	if _, ok := err.(storagedriver.TimeoutError); !ok {
+		t.Fatalf("expected the stat to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the stat to be abandoned after its timeout, took %v", elapsed)
+	}
+
+	// Operations completing in time are unaffected.
+	content, err := sd.GetContent(ctx, "/a")
+	if err != nil || string(content) != "content" {
+		t.Fatalf("unexpected result reading content: %q, %v", content, err)
+	}
+	if _, err := sd.GetContent(ctx, "/b"); err == nil {
+		t.Fatal("expected reading a missing path to fail")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected a path not found error, got %v", err)
+	}
+
+	// The caller giving up is not reported as a timeout.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := sd.Stat(canceled, "/a"); err != context.Canceled {
+		t.Fatalf("expected the stat to be canceled, got %v", err)
+	}
+}
+
+func TestTimeoutOptions(t *testing.T) {
+	if _, err := newTimeoutStorageMiddleware(inmemory.New(), map[string]interface{}{"move": "-1s"}); err == nil {
+		t.Fatal("expected a negative timeout to be rejected")
+	}
+	if _, err := newTimeoutStorageMiddleware(inmemory.New(), map[string]interface{}{"default": "soon"}); err == nil {
+		t.Fatal("expected an invalid timeout to be rejected")
+	}
+}
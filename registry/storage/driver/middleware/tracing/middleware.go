@@ -0,0 +1,237 @@
+// Package middleware - tracing wrapper for storage drivers, logging a span for
+// each storage operation
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+// tracingStorageMiddleware logs a span for each operation of the storage
+// driver it wraps. Spans carry a trace.id of their own and the trace.id of
+// the request they are part of as trace.parent.id, as traces created with
+// context.WithTrace do, so that storage operations can be linked to requests.
+type tracingStorageMiddleware struct {
+	storagedriver.StorageDriver
+	debug     bool
+	threshold time.Duration
+}
+
+var _ storagedriver.StorageDriver = &tracingStorageMiddleware{}
+
+// newTracingStorageMiddleware constructs a tracing storage middleware.
+// Optional options: level, either info, the default, or debug; threshold,
+// the duration below which successful operations are not logged.
+func newTracingStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	level, err := storagemiddleware.StringOption(options, "level", "info")
+	if err != nil {
+		return nil, err
+	}
+	if level != "info" && level != "debug" {
+		return nil, fmt.Errorf("level must be info or debug")
+	}
+
+	threshold, err := storagemiddleware.DurationOption(options, "threshold", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracingStorageMiddleware{
+		StorageDriver: sd,
+		debug:         level == "debug",
+		threshold:     threshold,
+	}, nil
+}
+
+// span is a traced storage operation.
+type span struct {
+	ctx       context.Context
+	t         *tracingStorageMiddleware
+	operation string
+	path      string
+}
+
+func (t *tracingStorageMiddleware) start(ctx context.Context, operation, path string) (context.Context, *span) {
+	ctx, _ = dcontext.WithTrace(ctx)
+	return ctx, &span{ctx: ctx, t: t, operation: operation, path: path}
+}
+
+// finish logs the span. Failed operations are logged as warnings, except for
+// paths which are not found, as the registry routinely looks up content it
+// may not have.
+func (s *span) finish(err error, fields map[interface{}]interface{}) {
+	if fields == nil {
+		fields = make(map[interface{}]interface{})
+	}
+	fields["storage.operation"] = s.operation
+	fields["storage.path"] = s.path
+	fields["storage.driver"] = s.t.StorageDriver.Name()
+
+	logger := dcontext.GetLoggerWithFields(s.ctx, fields, "trace.id", "trace.parent.id", "trace.duration")
+
+	if _, ok := err.(storagedriver.PathNotFoundError); err != nil && !ok {
+		logger.Warnf("storage %s failed: %v", s.operation, err)
+		return
+	}
+
+	if d, ok := s.ctx.Value("trace.duration").(time.Duration); ok && d < s.t.threshold {
+		return
+	}
+
+	if s.t.debug {
+		logger.Debugf("storage %s", s.operation)
+	} else {
+		logger.Infof("storage %s", s.operation)
+	}
+}
+
+func (t *tracingStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	ctx, s := t.start(ctx, "GetContent", path)
+	content, err := t.StorageDriver.GetContent(ctx, path)
+	s.finish(err, map[interface{}]interface{}{"storage.size": len(content)})
+	return content, err
+}
+
+func (t *tracingStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	ctx, s := t.start(ctx, "PutContent", path)
+	err := t.StorageDriver.PutContent(ctx, path, content)
+	s.finish(err, map[interface{}]interface{}{"storage.size": len(content)})
+	return err
+}
+
+// Reader spans last until the reader is closed.
+func (t *tracingStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	ctx, s := t.start(ctx, "Reader", path)
+	rc, err := t.StorageDriver.Reader(ctx, path, offset)
+	if err != nil {
+		s.finish(err, nil)
+		return nil, err
+	}
+
+	return &tracedReader{ReadCloser: rc, span: s, offset: offset}, nil
+}
+
+// Writer spans last until the writer is committed, cancelled or closed.
+func (t *tracingStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	ctx, s := t.start(ctx, "Writer", path)
+	fw, err := t.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		s.finish(err, nil)
+		return nil, err
+	}
+
+	return &tracedWriter{FileWriter: fw, span: s}, nil
+}
+
+func (t *tracingStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	ctx, s := t.start(ctx, "Stat", path)
+	fi, err := t.StorageDriver.Stat(ctx, path)
+	s.finish(err, nil)
+	return fi, err
+}
+
+func (t *tracingStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	ctx, s := t.start(ctx, "List", path)
+	entries, err := t.StorageDriver.List(ctx, path)
+	s.finish(err, map[interface{}]interface{}{"storage.entries": len(entries)})
+	return entries, err
+}
+
+func (t *tracingStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	ctx, s := t.start(ctx, "Move", sourcePath)
+	err := t.StorageDriver.Move(ctx, sourcePath, destPath)
+	s.finish(err, map[interface{}]interface{}{"storage.destination": destPath})
+	return err
+}
+
+func (t *tracingStorageMiddleware) Delete(ctx context.Context, path string) error {
+	ctx, s := t.start(ctx, "Delete", path)
+	err := t.StorageDriver.Delete(ctx, path)
+	s.finish(err, nil)
+	return err
+}
+
+// ApplyBatch spans are named after the path of the first write of the batch.
+func (t *tracingStorageMiddleware) ApplyBatch(ctx context.Context, ops []storagedriver.BatchOperation) error {
+	var path string
+	if len(ops) > 0 {
+		path = ops[0].Path
+	}
+	ctx, s := t.start(ctx, "ApplyBatch", path)
+	err := storagedriver.ApplyBatch(ctx, t.StorageDriver, ops)
+	if _, ok := err.(storagedriver.ErrUnsupportedMethod); ok {
+		return err
+	}
+	s.finish(err, map[interface{}]interface{}{"storage.operations": len(ops)})
+	return err
+}
+
+func (t *tracingStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	ctx, s := t.start(ctx, "Walk", path)
+	err := t.StorageDriver.Walk(ctx, path, f)
+	s.finish(err, nil)
+	return err
+}
+
+// tracedReader finishes the span of a Reader when closed.
+type tracedReader struct {
+	io.ReadCloser
+	span   *span
+	offset int64
+	n      int64
+}
+
+func (r *tracedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *tracedReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.span.finish(err, map[interface{}]interface{}{"storage.offset": r.offset, "storage.size": r.n})
+	return err
+}
+
+// tracedWriter finishes the span of a Writer once it is done with.
+type tracedWriter struct {
+	storagedriver.FileWriter
+	span     *span
+	finished bool
+}
+
+func (w *tracedWriter) finish(err error, outcome string) {
+	if w.finished {
+		return
+	}
+	w.finished = true
+	w.span.finish(err, map[interface{}]interface{}{"storage.size": w.FileWriter.Size(), "storage.outcome": outcome})
+}
+
+func (w *tracedWriter) Commit() error {
+	err := w.FileWriter.Commit()
+	w.finish(err, "committed")
+	return err
+}
+
+func (w *tracedWriter) Cancel() error {
+	err := w.FileWriter.Cancel()
+	w.finish(err, "cancelled")
+	return err
+}
+
+func (w *tracedWriter) Close() error {
+	err := w.FileWriter.Close()
+	w.finish(err, "closed")
+	return err
+}
+
+func init() {
+	storagemiddleware.Register("tracing", storagemiddleware.InitFunc(newTracingStorageMiddleware))
+}
@@ -0,0 +1,500 @@
+// Package ocilayout implements a read-only storage driver serving the content
+// of OCI image layout directories, so that bundles copied to a host, as in
+// airgapped environments, are served by the registry without importing them.
+//
+// Each layout is a repository. The manifests listed in its index.json are the
+// revisions of the repository, along with the manifests of the image indexes
+// among them, and the org.opencontainers.image.ref.name annotations of the
+// manifests listed are its tags. The blobs of the layout are the layers of the
+// repository. The metadata files of the registry are synthesized from the
+// layouts, which are read again as their index.json changes.
+package ocilayout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/reference"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const driverName = "ocilayout"
+
+// storageRoot is the directory of the files of the registry, as laid out by
+// the storage package.
+const storageRoot = "/docker/registry/v2"
+
+// The files of an OCI image layout.
+const (
+	indexFile    = "index.json"
+	blobsDirName = "blobs"
+)
+
+// refreshInterval bounds how often the layouts are checked for changes.
+const refreshInterval = time.Second
+
+func init() {
+	factory.Register(driverName, &ocilayoutDriverFactory{})
+}
+
+// ocilayoutDriverFactory implements the factory.StorageDriverFactory interface.
+type ocilayoutDriverFactory struct{}
+
+func (factory *ocilayoutDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+// DriverParameters represents all configuration options available for the
+// ocilayout driver.
+type DriverParameters struct {
+	// RootDirectory is a directory whose subdirectories holding an OCI
+	// image layout are served as the repositories named by their path
+	// relative to it.
+	RootDirectory string
+
+	// Layouts maps repository names to the OCI image layout directories
+	// served as the repositories.
+	Layouts map[string]string
+}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Optional Parameters:
+// - rootdirectory
+// - layouts
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	var params DriverParameters
+	if rootDir, ok := parameters["rootdirectory"]; ok {
+		params.RootDirectory = fmt.Sprint(rootDir)
+	}
+	if layouts, ok := parameters["layouts"]; ok {
+		m, ok := layouts.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("layouts must be a map of repository names to directories")
+		}
+		params.Layouts = make(map[string]string, len(m))
+		for name, dir := range m {
+			params.Layouts[fmt.Sprint(name)] = fmt.Sprint(dir)
+		}
+	}
+	if params.RootDirectory == "" && len(params.Layouts) == 0 {
+		return nil, fmt.Errorf("no rootdirectory or layouts configured")
+	}
+	for name := range params.Layouts {
+		if _, err := reference.WithName(name); err != nil {
+			return nil, fmt.Errorf("invalid repository name %q: %v", name, err)
+		}
+	}
+	return New(params), nil
+}
+
+// New constructs a new Driver serving the layouts of params.
+func New(params DriverParameters) *Driver {
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: &driver{params: params},
+			},
+		},
+	}
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a read-only storagedriver.StorageDriver implementation serving
+// OCI image layout directories.
+type Driver struct {
+	baseEmbed
+}
+
+var _ storagedriver.StorageDriver = &Driver{}
+
+// entry is a file or a directory of the files synthesized from the layouts.
+type entry struct {
+	// file is the path on disk of the content of the entry, for blobs.
+	file     string
+	content  []byte
+	size     int64
+	modTime  time.Time
+	children map[string]bool
+}
+
+func (e *entry) isDir() bool {
+	return e.children != nil
+}
+
+// tree holds the files synthesized from the layouts, by path.
+type tree struct {
+	entries map[string]*entry
+	// versions identifies the layouts the tree was built from, by the
+	// path and modification time of their index.json.
+	versions map[string]time.Time
+}
+
+// add adds the entry at p, along with its parent directories.
+func (t *tree) add(p string, e *entry) {
+	if _, ok := t.entries[p]; ok {
+		// The content of the first layout holding a blob is served.
+		return
+	}
+	t.entries[p] = e
+	for p != "/" {
+		parent := path.Dir(p)
+		dir, ok := t.entries[parent]
+		if !ok {
+			dir = &entry{children: make(map[string]bool), modTime: e.modTime}
+			t.entries[parent] = dir
+		}
+		dir.children[p] = true
+		if e.modTime.After(dir.modTime) {
+			dir.modTime = e.modTime
+		}
+		if ok {
+			return
+		}
+		p, e = parent, dir
+	}
+}
+
+type driver struct {
+	params DriverParameters
+
+	mu      sync.Mutex
+	tree    *tree
+	checked time.Time
+}
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// layouts returns the directories of the layouts served, by repository name.
+func (d *driver) layouts() (map[string]string, error) {
+	layouts := make(map[string]string, len(d.params.Layouts))
+	if d.params.RootDirectory != "" {
+		root := filepath.Clean(d.params.RootDirectory)
+		err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() || fi.Name() != v1.ImageLayoutFile {
+				return nil
+			}
+			dir := filepath.Dir(p)
+			name, err := filepath.Rel(root, dir)
+			if err != nil {
+				return err
+			}
+			name = filepath.ToSlash(name)
+			if _, err := reference.WithName(name); err == nil {
+				layouts[name] = dir
+			}
+			// Layouts do not nest.
+			return filepath.SkipDir
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for name, dir := range d.params.Layouts {
+		layouts[name] = dir
+	}
+	return layouts, nil
+}
+
+// current returns the tree of the layouts as they are, building it again if
+// they changed since it was built.
+func (d *driver) current() (*tree, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.tree != nil && time.Since(d.checked) < refreshInterval {
+		return d.tree, nil
+	}
+	layouts, err := d.layouts()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]time.Time, len(layouts))
+	for name, dir := range layouts {
+		if fi, err := os.Stat(filepath.Join(dir, indexFile)); err == nil {
+			versions[name+"="+dir] = fi.ModTime()
+		}
+	}
+	d.checked = time.Now()
+	if d.tree != nil && equalVersions(d.tree.versions, versions) {
+		return d.tree, nil
+	}
+
+	t := &tree{entries: make(map[string]*entry), versions: versions}
+	t.add("/", &entry{children: make(map[string]bool)})
+	t.add(storageRoot+"/repositories", &entry{children: make(map[string]bool)})
+	names := make([]string, 0, len(layouts))
+	for name := range layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := addLayout(t, name, layouts[name]); err != nil {
+			return nil, fmt.Errorf("layout %s of repository %s: %v", layouts[name], name, err)
+		}
+	}
+	d.tree = t
+	return t, nil
+}
+
+func equalVersions(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !w.Equal(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagOf returns the tag named by the ref.name annotation of a manifest, which
+// may be a tag or a full reference.
+func tagOf(refName string) string {
+	if i := strings.LastIndex(refName, ":"); i >= 0 && !strings.Contains(refName[i:], "/") {
+		refName = refName[i+1:]
+	}
+	if !anchoredTagRegexp.MatchString(refName) {
+		return ""
+	}
+	return refName
+}
+
+var anchoredTagRegexp = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
+// addLayout adds the files of the repository name served from the layout in
+// dir to t.
+func addLayout(t *tree, name, dir string) error {
+	fi, err := os.Stat(filepath.Join(dir, indexFile))
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		return err
+	}
+	var index v1.Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return err
+	}
+	modTime := fi.ModTime()
+	repo := path.Join(storageRoot, "repositories", name)
+	link := func(p string, dgst digest.Digest) {
+		t.add(p, &entry{content: []byte(dgst), size: int64(len(dgst)), modTime: modTime})
+	}
+
+	// The blobs of the layout are the layers of the repository.
+	blobsDir := filepath.Join(dir, blobsDirName)
+	algorithms, err := ioutil.ReadDir(blobsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	t.add(path.Join(repo, "_layers"), &entry{children: make(map[string]bool), modTime: modTime})
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		blobs, err := ioutil.ReadDir(filepath.Join(blobsDir, algorithm.Name()))
+		if err != nil {
+			return err
+		}
+		for _, blob := range blobs {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(algorithm.Name()), blob.Name())
+			if blob.IsDir() || dgst.Validate() != nil {
+				continue
+			}
+			hex := dgst.Encoded()
+			t.add(path.Join(storageRoot, "blobs", algorithm.Name(), hex[:2], hex, "data"), &entry{
+				file:    filepath.Join(blobsDir, algorithm.Name(), blob.Name()),
+				size:    blob.Size(),
+				modTime: blob.ModTime(),
+			})
+			link(path.Join(repo, "_layers", algorithm.Name(), hex, "link"), dgst)
+		}
+	}
+
+	// The manifests listed are the revisions of the repository, and those
+	// annotated with a ref.name its tags.
+	t.add(path.Join(repo, "_manifests", "tags"), &entry{children: make(map[string]bool), modTime: modTime})
+	var addManifests func(descs []v1.Descriptor, depth int) error
+	addManifests = func(descs []v1.Descriptor, depth int) error {
+		for _, desc := range descs {
+			if desc.Digest.Validate() != nil {
+				continue
+			}
+			link(path.Join(repo, "_manifests", "revisions", desc.Digest.Algorithm().String(), desc.Digest.Encoded(), "link"), desc.Digest)
+			if depth == 0 {
+				if tag := tagOf(desc.Annotations[v1.AnnotationRefName]); tag != "" {
+					link(path.Join(repo, "_manifests", "tags", tag, "current", "link"), desc.Digest)
+					link(path.Join(repo, "_manifests", "tags", tag, "index", desc.Digest.Algorithm().String(), desc.Digest.Encoded(), "link"), desc.Digest)
+				}
+			}
+
+			switch desc.MediaType {
+			case v1.MediaTypeImageIndex, manifestlist.MediaTypeManifestList:
+			default:
+				continue
+			}
+			content, err := ioutil.ReadFile(filepath.Join(blobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			var child v1.Index
+			if err := json.Unmarshal(content, &child); err != nil {
+				return fmt.Errorf("index %s: %v", desc.Digest, err)
+			}
+			if err := addManifests(child.Manifests, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return addManifests(index.Manifests, 0)
+}
+
+func (d *driver) lookup(p string) (*entry, error) {
+	t, err := d.current()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := t.entries[p]
+	if !ok {
+		return nil, storagedriver.PathNotFoundError{Path: p}
+	}
+	return e, nil
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	rc, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: driverName}
+	}
+	e, err := d.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if e.isDir() {
+		return nil, fmt.Errorf("%q is a directory", path)
+	}
+	if e.file == "" {
+		if offset > int64(len(e.content)) {
+			offset = int64(len(e.content))
+		}
+		return ioutil.NopCloser(bytes.NewReader(e.content[offset:])), nil
+	}
+
+	f, err := os.Open(e.file)
+	if os.IsNotExist(err) {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	e, err := d.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    path,
+		Size:    e.size,
+		ModTime: e.modTime,
+		IsDir:   e.isDir(),
+	}}, nil
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	e, err := d.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if !e.isDir() {
+		return nil, fmt.Errorf("not a directory")
+	}
+	children := make([]string, 0, len(e.children))
+	for child := range e.children {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	return children, nil
+}
+
+// PutContent is unsupported, as the driver is read-only.
+func (d *driver) PutContent(ctx context.Context, path string, content []byte) error {
+	return storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// Writer is unsupported, as the driver is read-only.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	return nil, storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// Move is unsupported, as the driver is read-only.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	return storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// Delete is unsupported, as the driver is read-only.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	return storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at the given path.
+// May return an UnsupportedMethodErr in certain StorageDriver implementations.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "", storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file and directory
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	return storagedriver.WalkFallback(ctx, d, path, f)
+}
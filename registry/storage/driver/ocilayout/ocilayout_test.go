@@ -0,0 +1,151 @@
+package ocilayout
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeBlob writes content to the blobs of the layout in dir.
+func writeBlob(t *testing.T, dir string, mediaType string, content []byte) v1.Descriptor {
+	dgst := digest.FromBytes(content)
+	blobs := filepath.Join(dir, "blobs", dgst.Algorithm().String())
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(blobs, dgst.Encoded()), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return v1.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}
+}
+
+func marshal(t *testing.T, v interface{}) []byte {
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// writeLayout writes an OCI image layout of an image, tagged v1, to dir.
+func writeLayout(t *testing.T, dir string) (manifest, layer v1.Descriptor) {
+	config := writeBlob(t, dir, v1.MediaTypeImageConfig, []byte(`{}`))
+	layer = writeBlob(t, dir, v1.MediaTypeImageLayer, []byte("layer"))
+	m := v1.Manifest{MediaType: v1.MediaTypeImageManifest, Config: config, Layers: []v1.Descriptor{layer}}
+	m.SchemaVersion = 2
+	manifest = writeBlob(t, dir, v1.MediaTypeImageManifest, marshal(t, m))
+
+	tagged := manifest
+	tagged.Annotations = map[string]string{v1.AnnotationRefName: "docker.io/library/image:v1"}
+	index := v1.Index{Manifests: []v1.Descriptor{tagged}}
+	index.SchemaVersion = 2
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), marshal(t, index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return manifest, layer
+}
+
+func TestLayouts(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	manifest, layer := writeLayout(t, filepath.Join(root, "team", "image"))
+
+	d, err := FromParameters(map[string]interface{}{"rootdirectory": root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var repos []string
+	if err := reg.(distribution.RepositoryEnumerator).Enumerate(ctx, func(name string) error {
+		repos = append(repos, name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 1 || repos[0] != "team/image" {
+		t.Fatalf("unexpected repositories: %v", repos)
+	}
+
+	name, _ := reference.WithName("team/image")
+	repo, err := reg.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := repo.Tags(ctx).Get(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != manifest.Digest {
+		t.Fatalf("unexpected digest of tag v1: %s", desc.Digest)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manifests.Get(ctx, manifest.Digest); err != nil {
+		t.Fatalf("unexpected error getting the manifest: %v", err)
+	}
+	content, err := repo.Blobs(ctx).Get(ctx, layer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "layer" {
+		t.Fatalf("unexpected content of the layer: %q", content)
+	}
+
+	// The driver is read-only.
+	if err := d.PutContent(ctx, "/file", []byte("content")); err == nil {
+		t.Fatal("expected writes to fail")
+	} else if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+}
+
+func TestLayoutsParameter(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	_, layer := writeLayout(t, dir)
+
+	d, err := FromParameters(map[string]interface{}{
+		"layouts": map[interface{}]interface{}{"bundle": dir},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := d.GetContent(ctx, "/docker/registry/v2/repositories/bundle/_layers/sha256/"+layer.Digest.Encoded()+"/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.Digest(link) != layer.Digest {
+		t.Fatalf("unexpected link: %q", link)
+	}
+	if _, err := d.Stat(ctx, "/docker/registry/v2/repositories/other"); err == nil {
+		t.Fatal("expected no repository other")
+	}
+
+	if _, err := FromParameters(map[string]interface{}{}); err == nil {
+		t.Fatal("expected an error without layouts")
+	}
+	if _, err := FromParameters(map[string]interface{}{
+		"layouts": map[interface{}]interface{}{"Invalid Name": dir},
+	}); err == nil {
+		t.Fatal("expected an error for an invalid repository name")
+	}
+}
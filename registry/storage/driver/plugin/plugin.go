@@ -0,0 +1,548 @@
+// Package plugin provides a storagedriver.StorageDriver implementation
+// delegating to a storage driver served by a plugin process, so that storage
+// backends can be supported without being built into the registry.
+//
+// The registry starts the plugin and talks to it over gRPC, in the manner of
+// hashicorp/go-plugin: the plugin serves its driver on a unix socket whose
+// address it writes on its standard output, and exits when the registry
+// closes its standard input. Plugins written in Go implement the
+// storagedriver.StorageDriver interface and call Serve from their main
+// function:
+//
+//	func main() {
+//		if err := plugin.Serve(mydriver.FromParameters); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+const driverName = "plugin"
+
+// defaultStartTimeout is the time plugins have to start serving their driver.
+const defaultStartTimeout = 10 * time.Second
+
+func init() {
+	factory.Register(driverName, &pluginDriverFactory{})
+}
+
+// pluginDriverFactory implements the factory.StorageDriverFactory interface
+type pluginDriverFactory struct{}
+
+func (factory *pluginDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+// DriverParameters represents all configuration options available for the
+// plugin driver
+type DriverParameters struct {
+	// Command is the path of the executable of the plugin.
+	Command string
+
+	// Args are the arguments the plugin is started with.
+	Args []string
+
+	// Parameters are the parameters of the storage driver of the plugin.
+	Parameters map[string]interface{}
+
+	// StartTimeout is the time the plugin has to start serving its driver.
+	StartTimeout time.Duration
+}
+
+type driver struct {
+	name   string
+	conn   *grpc.ClientConn
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	exited chan struct{}
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation delegating to the
+// storage driver of a plugin process.
+type Driver struct {
+	baseEmbed
+}
+
+var _ storagedriver.StorageDriver = &Driver{}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - command: the path of the executable of the plugin
+// Optional parameters:
+// - args: the arguments the plugin is started with
+// - parameters: the parameters of the storage driver of the plugin
+// - starttimeout: the time the plugin has to start serving, 10s by default
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	params := DriverParameters{StartTimeout: defaultStartTimeout}
+
+	params.Command, _ = parameters["command"].(string)
+	if params.Command == "" {
+		return nil, fmt.Errorf("the command parameter must be the path of the plugin")
+	}
+
+	if args, ok := parameters["args"]; ok {
+		list, ok := args.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the args parameter must be a list of arguments")
+		}
+		for _, arg := range list {
+			params.Args = append(params.Args, fmt.Sprint(arg))
+		}
+	}
+
+	if p, ok := parameters["parameters"]; ok && p != nil {
+		converted, err := jsonValue(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin parameters: %v", err)
+		}
+		m, ok := converted.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the parameters parameter must be a map of the parameters of the plugin")
+		}
+		params.Parameters = m
+	}
+
+	switch timeout := parameters["starttimeout"].(type) {
+	case nil:
+	case time.Duration:
+		params.StartTimeout = timeout
+	case string:
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid starttimeout: %v", err)
+		}
+		params.StartTimeout = d
+	default:
+		return nil, fmt.Errorf("invalid starttimeout: %v", timeout)
+	}
+
+	return New(params)
+}
+
+// jsonValue converts a value parsed from the configuration, whose maps may
+// be keyed by interface{}, to one that can be encoded as JSON.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v", k)
+			}
+			converted, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			converted, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = converted
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			converted, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = converted
+		}
+		return l, nil
+	}
+	return v, nil
+}
+
+// New starts the plugin and constructs a Driver delegating to its storage
+// driver, once initialized with the parameters. The plugin runs as long as
+// the registry.
+func New(params DriverParameters) (*Driver, error) {
+	cmd := exec.Command(params.Command, params.Args...)
+	cmd.Env = append(os.Environ(), MagicCookieKey+"="+MagicCookieValue)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// Unlike the pipe StdoutPipe returns, this one may be read while the
+	// registry waits for the plugin to exit.
+	stdout, stdoutWriter := io.Pipe()
+	cmd.Stdout = stdoutWriter
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %v", params.Command, err)
+	}
+
+	d := &driver{cmd: cmd, stdin: stdin, exited: make(chan struct{})}
+	go func() {
+		err := cmd.Wait()
+		stdoutWriter.Close()
+		close(d.exited)
+		logrus.WithField("plugin", params.Command).Warnf("storage driver plugin exited: %v", err)
+	}()
+
+	if err := d.connect(params, stdout); err != nil {
+		d.kill()
+		return nil, fmt.Errorf("plugin %s: %v", params.Command, err)
+	}
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: d,
+			},
+		},
+	}, nil
+}
+
+// connect reads the handshake of the plugin from its standard output, dials
+// it and initializes its storage driver.
+func (d *driver) connect(params DriverParameters, stdout io.Reader) error {
+	ctx, cancel := context.WithTimeout(context.Background(), params.StartTimeout)
+	defer cancel()
+
+	lines := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		if scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+		// Keep draining the output of the plugin, so that writing to it
+		// does not block the plugin.
+		io.Copy(io.Discard, stdout)
+	}()
+
+	var line string
+	select {
+	case l, ok := <-lines:
+		if !ok {
+			return errors.New("exited before serving")
+		}
+		line = l
+	case <-ctx.Done():
+		return fmt.Errorf("not serving after %s", params.StartTimeout)
+	}
+
+	parts := strings.Split(line, "|")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid handshake %q", line)
+	}
+	if version, err := strconv.Atoi(parts[0]); err != nil || version != ProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %s, expected %d", parts[0], ProtocolVersion)
+	}
+	network, address := parts[1], parts[2]
+
+	conn, err := grpc.DialContext(ctx, address,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address)
+		}),
+		grpc.WithDefaultCallOptions(
+			grpc.ForceCodec(jsonCodec{}),
+			grpc.MaxCallRecvMsgSize(maxMessageSize),
+			grpc.MaxCallSendMsgSize(maxMessageSize),
+		),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %v", err)
+	}
+	d.conn = conn
+
+	resp := new(initResponse)
+	if err := conn.Invoke(ctx, methodName("Init"), &initRequest{Parameters: params.Parameters}, resp); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to initialize storage driver: %v", err)
+	}
+	d.name = resp.Name
+	return nil
+}
+
+func (d *driver) kill() {
+	d.stdin.Close()
+	select {
+	case <-d.exited:
+	case <-time.After(time.Second):
+		d.cmd.Process.Kill()
+	}
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+// Name returns the name of the storage driver of the plugin.
+func (d *driver) Name() string {
+	return d.name
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	resp := new(contentMessage)
+	if err := d.conn.Invoke(ctx, methodName("GetContent"), &pathRequest{Path: path}, resp); err != nil {
+		return nil, fromStatus(err, path, 0)
+	}
+	return resp.Content, nil
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	err := d.conn.Invoke(ctx, methodName("PutContent"), &contentMessage{Path: path, Content: contents}, new(empty))
+	return fromStatus(err, path, 0)
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := d.conn.NewStream(ctx, &serviceDesc.Streams[0], methodName("Reader"))
+	if err == nil {
+		err = stream.SendMsg(&readerRequest{Path: path, Offset: offset})
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err == nil {
+		// The plugin sends an empty chunk once it opened the content.
+		err = stream.RecvMsg(new(chunk))
+	}
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err, path, offset)
+	}
+	return &reader{stream: stream, cancel: cancel, path: path, offset: offset}, nil
+}
+
+type reader struct {
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	path   string
+	offset int64
+	buf    []byte
+	err    error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		c := new(chunk)
+		if err := r.stream.RecvMsg(c); err != nil {
+			if err != io.EOF {
+				err = fromStatus(err, r.path, r.offset)
+			}
+			r.err = err
+			continue
+		}
+		r.buf = c.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := d.conn.NewStream(ctx, &serviceDesc.Streams[1], methodName("Writer"))
+	if err == nil {
+		err = stream.SendMsg(&writerRequest{Path: path, Append: append})
+	}
+	resp := new(writerResponse)
+	if err == nil {
+		err = stream.RecvMsg(resp)
+	}
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err, path, 0)
+	}
+	return &writer{stream: stream, cancel: cancel, path: path, size: resp.Size}, nil
+}
+
+type writer struct {
+	stream    grpc.ClientStream
+	cancel    context.CancelFunc
+	path      string
+	size      int64
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if err := w.stream.SendMsg(&writerRequest{Data: p[:n]}); err != nil {
+			return written, w.streamError(err)
+		}
+		p = p[n:]
+		written += n
+		w.size += int64(n)
+	}
+	return written, nil
+}
+
+// streamError returns the error the stream failed with, which sending
+// reports as io.EOF.
+func (w *writer) streamError(err error) error {
+	if err == io.EOF {
+		err = w.stream.RecvMsg(new(writerResponse))
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return fromStatus(err, w.path, 0)
+}
+
+// do sends the action to the plugin and updates the size of the writer with
+// the answer.
+func (w *writer) do(action string) error {
+	if err := w.stream.SendMsg(&writerRequest{Action: action}); err != nil {
+		return w.streamError(err)
+	}
+	resp := new(writerResponse)
+	if err := w.stream.RecvMsg(resp); err != nil {
+		return fromStatus(err, w.path, 0)
+	}
+	w.size = resp.Size
+	return nil
+}
+
+func (w *writer) Size() int64 {
+	return w.size
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+	defer w.cancel()
+	return w.do(actionClose)
+}
+
+func (w *writer) Cancel() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+	return w.do(actionCancel)
+}
+
+func (w *writer) Commit() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+	w.committed = true
+	return w.do(actionCommit)
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	resp := new(statResponse)
+	if err := d.conn.Invoke(ctx, methodName("Stat"), &pathRequest{Path: path}, resp); err != nil {
+		return nil, fromStatus(err, path, 0)
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    resp.Path,
+		Size:    resp.Size,
+		ModTime: resp.ModTime,
+		IsDir:   resp.IsDir,
+	}}, nil
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	resp := new(listResponse)
+	if err := d.conn.Invoke(ctx, methodName("List"), &pathRequest{Path: path}, resp); err != nil {
+		return nil, fromStatus(err, path, 0)
+	}
+	return resp.Paths, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	err := d.conn.Invoke(ctx, methodName("Move"), &moveRequest{Source: sourcePath, Dest: destPath}, new(empty))
+	return fromStatus(err, sourcePath, 0)
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	err := d.conn.Invoke(ctx, methodName("Delete"), &pathRequest{Path: path}, new(empty))
+	return fromStatus(err, path, 0)
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at the
+// given path.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	resp := new(urlForResponse)
+	if err := d.conn.Invoke(ctx, methodName("URLFor"), &urlForRequest{Path: path, Options: options}, resp); err != nil {
+		return "", fromStatus(err, path, 0)
+	}
+	return resp.URL, nil
+}
+
+// Walk traverses a filesystem defined within driver, starting from the given
+// path, calling f on each file, with the List and Stat methods of the plugin.
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	return storagedriver.WalkFallback(ctx, d, path, f)
+}
@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// TestMain serves the inmemory driver when the test binary is started as a
+// plugin by the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv(MagicCookieKey) == MagicCookieValue {
+		err := Serve(func(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+			if parameters["fail"] == 1 {
+				return nil, errors.New("failing as configured")
+			}
+			return inmemory.New(), nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestDriver(t *testing.T, parameters map[interface{}]interface{}) (*Driver, error) {
+	d, err := FromParameters(map[string]interface{}{
+		"command":    os.Args[0],
+		"parameters": parameters,
+	})
+	if err == nil {
+		t.Cleanup(func() { d.StorageDriver.(*driver).kill() })
+	}
+	return d, err
+}
+
+func TestPlugin(t *testing.T) {
+	if err := Serve(nil); err == nil {
+		t.Fatal("expected an error serving without being started by the registry")
+	}
+	if _, err := newTestDriver(t, map[interface{}]interface{}{"fail": 1}); err == nil {
+		t.Fatal("expected the error of the factory of the plugin")
+	}
+
+	d, err := newTestDriver(t, nil)
+	if err != nil {
+		t.Fatalf("unexpected error starting plugin: %v", err)
+	}
+	if d.Name() != "inmemory" {
+		t.Errorf("unexpected name: %s", d.Name())
+	}
+
+	ctx := context.Background()
+	if _, err := d.GetContent(ctx, "/missing"); !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		t.Fatalf("expected a PathNotFoundError, got %v", err)
+	}
+	if err := d.PutContent(ctx, "/a/b", []byte("content")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+	if content, err := d.GetContent(ctx, "/a/b"); err != nil || string(content) != "content" {
+		t.Fatalf("unexpected content: %q, %v", content, err)
+	}
+	if _, err := d.Reader(ctx, "/missing", 0); !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		t.Fatalf("expected a PathNotFoundError opening reader, got %v", err)
+	}
+
+	// Write more than a chunk, closing the writer before appending to it.
+	large := bytes.Repeat([]byte("0123456789abcdef"), chunkSize/8)
+	fw, err := d.Writer(ctx, "/a/c", false)
+	if err != nil {
+		t.Fatalf("unexpected error opening writer: %v", err)
+	}
+	if _, err := fw.Write(large[:chunkSize+1]); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	fw, err = d.Writer(ctx, "/a/c", true)
+	if err != nil {
+		t.Fatalf("unexpected error opening writer to append: %v", err)
+	}
+	if fw.Size() != chunkSize+1 {
+		t.Fatalf("unexpected size of writer appending: %d", fw.Size())
+	}
+	if _, err := fw.Write(large[chunkSize+1:]); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := fw.Commit(); err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	rc, err := d.Reader(ctx, "/a/c", 16)
+	if err != nil {
+		t.Fatalf("unexpected error opening reader: %v", err)
+	}
+	read, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(read, large[16:]) {
+		t.Fatalf("unexpected content read: %d bytes, %v", len(read), err)
+	}
+
+	fi, err := d.Stat(ctx, "/a/c")
+	if err != nil || fi.Size() != int64(len(large)) || fi.IsDir() {
+		t.Fatalf("unexpected file info: %+v, %v", fi, err)
+	}
+	if err := d.Move(ctx, "/a/b", "/d"); err != nil {
+		t.Fatalf("unexpected error moving: %v", err)
+	}
+	if paths, err := d.List(ctx, "/a"); err != nil || len(paths) != 1 || paths[0] != "/a/c" {
+		t.Fatalf("unexpected list: %v, %v", paths, err)
+	}
+
+	var walked []string
+	err = d.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		walked = append(walked, fi.Path())
+		return nil
+	})
+	if err != nil || fmt.Sprint(walked) != "[/a /a/c /d]" {
+		t.Fatalf("unexpected walk: %v, %v", walked, err)
+	}
+
+	if _, err := d.URLFor(ctx, "/d", nil); !errors.As(err, new(storagedriver.ErrUnsupportedMethod)) {
+		t.Fatalf("expected an ErrUnsupportedMethod, got %v", err)
+	}
+	if err := d.Delete(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if _, err := d.Stat(ctx, "/a/c"); !errors.As(err, new(storagedriver.PathNotFoundError)) {
+		t.Fatalf("expected a PathNotFoundError after deleting, got %v", err)
+	}
+}
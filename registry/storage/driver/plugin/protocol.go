@@ -0,0 +1,291 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The handshake between the registry and the plugins it starts. The registry
+// sets MagicCookieKey to MagicCookieValue in the environment of plugins, so
+// that they refuse to run when started otherwise, and plugins answer with a
+// line on their standard output of the form
+//
+//	<protocol version>|<network>|<address>
+//
+// giving the address their gRPC server listens on.
+const (
+	MagicCookieKey   = "DISTRIBUTION_STORAGE_PLUGIN"
+	MagicCookieValue = "b6f1a8e2c7d94f3e9a0c5d1e8f2b7a43"
+
+	// ProtocolVersion is the version of the protocol described here, which
+	// plugins and the registry must agree on.
+	ProtocolVersion = 1
+)
+
+// serviceName is the name of the gRPC service of plugins.
+const serviceName = "distribution.storagedriver.v1.StorageDriver"
+
+// codecName is the content subtype of the messages of the service, which are
+// encoded as JSON so that plugins need no generated code. The codec is forced
+// on the connections of plugins only, rather than registered, so that the
+// codec of the other gRPC clients and servers of the process is left alone.
+const codecName = "distribution-storagedriver-json"
+
+// maxMessageSize bounds the size of the messages of the service. Contents
+// are bounded by the drivers, not the transport, and streams send them in
+// chunks of chunkSize.
+const maxMessageSize = math.MaxInt32
+
+// chunkSize is the size of the chunks of the contents streamed by Reader and
+// Writer.
+const chunkSize = 1 << 20
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+type empty struct{}
+
+type initRequest struct {
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type initResponse struct {
+	Name string `json:"name"`
+}
+
+type pathRequest struct {
+	Path string `json:"path"`
+}
+
+type contentMessage struct {
+	Path    string `json:"path,omitempty"`
+	Content []byte `json:"content,omitempty"`
+}
+
+type readerRequest struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+type chunk struct {
+	Data []byte `json:"data,omitempty"`
+}
+
+// The actions of the messages of Writer streams, after the first one which
+// opens the writer.
+const (
+	actionWrite  = ""
+	actionClose  = "close"
+	actionCancel = "cancel"
+	actionCommit = "commit"
+)
+
+type writerRequest struct {
+	Path   string `json:"path,omitempty"`
+	Append bool   `json:"append,omitempty"`
+	Action string `json:"action,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+}
+
+type writerResponse struct {
+	Size int64 `json:"size"`
+}
+
+type statResponse struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	IsDir   bool      `json:"isdir,omitempty"`
+}
+
+type listResponse struct {
+	Paths []string `json:"paths"`
+}
+
+type moveRequest struct {
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+}
+
+type urlForRequest struct {
+	Path    string                 `json:"path"`
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+type urlForResponse struct {
+	URL string `json:"url"`
+}
+
+// driverServer is implemented by the server of plugins.
+type driverServer interface {
+	Init(context.Context, *initRequest) (*initResponse, error)
+	GetContent(context.Context, *pathRequest) (*contentMessage, error)
+	PutContent(context.Context, *contentMessage) (*empty, error)
+	Reader(*readerRequest, grpc.ServerStream) error
+	Writer(grpc.ServerStream) error
+	Stat(context.Context, *pathRequest) (*statResponse, error)
+	List(context.Context, *pathRequest) (*listResponse, error)
+	Move(context.Context, *moveRequest) (*empty, error)
+	Delete(context.Context, *pathRequest) (*empty, error)
+	URLFor(context.Context, *urlForRequest) (*urlForResponse, error)
+}
+
+// unaryHandler returns the description of the unary method, whose requests
+// are decoded into the values newRequest returns before being passed to call.
+func unaryHandler(method string, newRequest func() interface{}, call func(driverServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(driverServer), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: methodName(method)}, handler)
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*driverServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("Init", func() interface{} { return new(initRequest) }, func(s driverServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Init(ctx, req.(*initRequest))
+		}),
+		unaryHandler("GetContent", func() interface{} { return new(pathRequest) }, func(s driverServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.GetContent(ctx, req.(*pathRequest))
+		}),
+		unaryHandler("PutContent", func() interface{} { return new(contentMessage) }, func(s driverServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.PutContent(ctx, req.(*contentMessage))
+		}),
+		unaryHandler("Stat", func() interface{} { return new(pathRequest) }, func(s driverServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Stat(ctx, req.(*pathRequest))
+		}),
+		unaryHandler("List", func() interface{} { return new(pathRequest) }, func(s driverServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.List(ctx, req.(*pathRequest))
+		}),
+		unaryHandler("Move", func() interface{} { return new(moveRequest) }, func(s driverServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Move(ctx, req.(*moveRequest))
+		}),
+		unaryHandler("Delete", func() interface{} { return new(pathRequest) }, func(s driverServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Delete(ctx, req.(*pathRequest))
+		}),
+		unaryHandler("URLFor", func() interface{} { return new(urlForRequest) }, func(s driverServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.URLFor(ctx, req.(*urlForRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Reader",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := new(readerRequest)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(driverServer).Reader(req, stream)
+			},
+			ServerStreams: true,
+		},
+		{
+			StreamName: "Writer",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(driverServer).Writer(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+// methodName returns the full name of the method of the service.
+func methodName(method string) string {
+	return "/" + serviceName + "/" + method
+}
+
+// toStatus converts the errors of the storage driver of a plugin to the
+// status sent to the registry, keeping the errors the registry tells apart.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	var (
+		notFound    storagedriver.PathNotFoundError
+		invalidPath storagedriver.InvalidPathError
+		offset      storagedriver.InvalidOffsetError
+		unsupported storagedriver.ErrUnsupportedMethod
+	)
+	switch {
+	case errors.As(err, &notFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.As(err, &invalidPath):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &offset):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.As(err, &unsupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	// The registry names the driver in its own errors.
+	var driverErr storagedriver.Error
+	if errors.As(err, &driverErr) && driverErr.Enclosed != nil {
+		err = driverErr.Enclosed
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// fromStatus converts the status received from a plugin for an operation on
+// path back to the error of the storage driver.
+func fromStatus(err error, path string, offset int64) error {
+	s, ok := status.FromError(err)
+	if !ok || err == nil {
+		return err
+	}
+	switch s.Code() {
+	case codes.NotFound:
+		return storagedriver.PathNotFoundError{Path: path}
+	case codes.InvalidArgument:
+		return storagedriver.InvalidPathError{Path: path}
+	case codes.OutOfRange:
+		return storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+	case codes.Unimplemented:
+		return storagedriver.ErrUnsupportedMethod{}
+	case codes.Canceled:
+		return context.Canceled
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	case codes.Unknown:
+		return errors.New(s.Message())
+	}
+	return err
+}
@@ -0,0 +1,308 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Factory creates the storage driver of a plugin from the parameters
+// configured for it in the registry. The parameters are decoded from JSON,
+// except for integers, which are passed as int.
+type Factory func(parameters map[string]interface{}) (storagedriver.StorageDriver, error)
+
+// Serve serves the storage driver created by factory to the registry which
+// started the plugin, until the registry exits. It is meant to be called
+// from the main function of plugins, and fails if the plugin was not started
+// by a registry.
+func Serve(factory Factory) error {
+	if os.Getenv(MagicCookieKey) != MagicCookieValue {
+		return errors.New("storage driver plugins are meant to be started by the registry, not directly")
+	}
+
+	dir, err := os.MkdirTemp("", "registry-storage-plugin")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(jsonCodec{}),
+		grpc.MaxRecvMsgSize(maxMessageSize),
+		grpc.MaxSendMsgSize(maxMessageSize),
+	)
+	server.RegisterService(&serviceDesc, &pluginServer{factory: factory})
+
+	// The registry keeps the standard input of plugins open as long as it
+	// runs, so that plugins do not outlive it.
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		server.Stop()
+	}()
+
+	if _, err := fmt.Fprintf(os.Stdout, "%d|unix|%s\n", ProtocolVersion, socket); err != nil {
+		return err
+	}
+	return server.Serve(listener)
+}
+
+// pluginServer serves the storage driver of a plugin, once the registry
+// initialized it.
+type pluginServer struct {
+	factory Factory
+
+	mu     sync.RWMutex
+	driver storagedriver.StorageDriver
+}
+
+var _ driverServer = &pluginServer{}
+
+func (s *pluginServer) storageDriver() (storagedriver.StorageDriver, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.driver == nil {
+		return nil, status.Error(codes.FailedPrecondition, "storage driver not initialized")
+	}
+	return s.driver, nil
+}
+
+func (s *pluginServer) Init(ctx context.Context, req *initRequest) (*initResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.driver != nil {
+		return nil, status.Error(codes.FailedPrecondition, "storage driver already initialized")
+	}
+
+	d, err := s.factory(integers(req.Parameters).(map[string]interface{}))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.driver = d
+	return &initResponse{Name: d.Name()}, nil
+}
+
+// integers converts the integral numbers of v, decoded from JSON as float64,
+// back to int, as the storage drivers expect of the integers of their
+// parameters.
+func integers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			m[k] = integers(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = integers(value)
+		}
+		return l
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+			return int(v)
+		}
+	}
+	return v
+}
+
+func (s *pluginServer) GetContent(ctx context.Context, req *pathRequest) (*contentMessage, error) {
+	d, err := s.storageDriver()
+	if err != nil {
+		return nil, err
+	}
+	content, err := d.GetContent(ctx, req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &contentMessage{Content: content}, nil
+}
+
+func (s *pluginServer) PutContent(ctx context.Context, req *contentMessage) (*empty, error) {
+	d, err := s.storageDriver()
+	if err != nil {
+		return nil, err
+	}
+	return &empty{}, toStatus(d.PutContent(ctx, req.Path, req.Content))
+}
+
+// Reader streams the content from the offset in chunks, after an empty one
+// telling the registry that the content was found.
+func (s *pluginServer) Reader(req *readerRequest, stream grpc.ServerStream) error {
+	d, err := s.storageDriver()
+	if err != nil {
+		return err
+	}
+	rc, err := d.Reader(stream.Context(), req.Path, req.Offset)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer rc.Close()
+
+	if err := stream.SendMsg(&chunk{}); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := rc.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&chunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+}
+
+// Writer opens a writer with the first message of the stream, answering with
+// its size, and then writes the data of the messages that follow. Closing,
+// cancelling and committing the writer are answered with its size as well.
+// The writer is closed when the stream ends without closing it, so that the
+// content written can be appended to.
+func (s *pluginServer) Writer(stream grpc.ServerStream) error {
+	d, err := s.storageDriver()
+	if err != nil {
+		return err
+	}
+	open := new(writerRequest)
+	if err := stream.RecvMsg(open); err != nil {
+		return err
+	}
+	fw, err := d.Writer(stream.Context(), open.Path, open.Append)
+	if err != nil {
+		return toStatus(err)
+	}
+	if err := stream.SendMsg(&writerResponse{Size: fw.Size()}); err != nil {
+		fw.Close()
+		return err
+	}
+
+	for {
+		req := new(writerRequest)
+		if err := stream.RecvMsg(req); err != nil {
+			fw.Close()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		switch req.Action {
+		case actionWrite:
+			if _, err := fw.Write(req.Data); err != nil {
+				fw.Close()
+				return toStatus(err)
+			}
+			continue
+		case actionClose:
+			err = fw.Close()
+		case actionCancel:
+			err = fw.Cancel()
+		case actionCommit:
+			err = fw.Commit()
+		default:
+			fw.Close()
+			return status.Errorf(codes.InvalidArgument, "unknown writer action %q", req.Action)
+		}
+		if err != nil {
+			if req.Action != actionClose {
+				fw.Close()
+			}
+			return toStatus(err)
+		}
+		if err := stream.SendMsg(&writerResponse{Size: fw.Size()}); err != nil {
+			return err
+		}
+		if req.Action == actionClose {
+			return nil
+		}
+	}
+}
+
+func (s *pluginServer) Stat(ctx context.Context, req *pathRequest) (*statResponse, error) {
+	d, err := s.storageDriver()
+	if err != nil {
+		return nil, err
+	}
+	fi, err := d.Stat(ctx, req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &statResponse{
+		Path:    fi.Path(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}, nil
+}
+
+func (s *pluginServer) List(ctx context.Context, req *pathRequest) (*listResponse, error) {
+	d, err := s.storageDriver()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := d.List(ctx, req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &listResponse{Paths: paths}, nil
+}
+
+func (s *pluginServer) Move(ctx context.Context, req *moveRequest) (*empty, error) {
+	d, err := s.storageDriver()
+	if err != nil {
+		return nil, err
+	}
+	return &empty{}, toStatus(d.Move(ctx, req.Source, req.Dest))
+}
+
+func (s *pluginServer) Delete(ctx context.Context, req *pathRequest) (*empty, error) {
+	d, err := s.storageDriver()
+	if err != nil {
+		return nil, err
+	}
+	return &empty{}, toStatus(d.Delete(ctx, req.Path))
+}
+
+// URLFor passes the expiry option as a time.Time, as the registry does.
+func (s *pluginServer) URLFor(ctx context.Context, req *urlForRequest) (*urlForResponse, error) {
+	d, err := s.storageDriver()
+	if err != nil {
+		return nil, err
+	}
+	if expiry, ok := req.Options["expiry"].(string); ok {
+		t, err := time.Parse(time.RFC3339Nano, expiry)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid expiry: %v", err)
+		}
+		req.Options["expiry"] = t
+	}
+	url, err := d.URLFor(ctx, req.Path, req.Options)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &urlForResponse{URL: url}, nil
+}
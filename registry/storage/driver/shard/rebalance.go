@@ -0,0 +1,81 @@
+package shard
+
+import (
+	"context"
+	"path"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// RebalanceOpts contains options for rebalancing blobs
+type RebalanceOpts struct {
+	DryRun bool
+}
+
+// Rebalance moves the blobs stored by other backends than the backend they
+// hash to, such as after adding a backend, to the backend they hash to. It
+// returns the number of blobs moved, or which would be moved in a dry run.
+// Blobs remain readable while they are moved.
+func Rebalance(ctx context.Context, d *Driver, opts RebalanceOpts) (int, error) {
+	sd := d.StorageDriver.(*driver)
+
+	var moved int
+	for i, backend := range sd.backends {
+		blobs, err := listBlobs(ctx, backend.Driver)
+		if err != nil {
+			return moved, err
+		}
+
+		for _, blobPath := range blobs {
+			owner := sd.ring.owner(path.Base(blobPath))
+			if owner == i {
+				continue
+			}
+
+			dcontext.GetLogger(ctx).Infof("moving blob %s from backend %s to %s", blobPath, backend.Name, sd.backends[owner].Name)
+			moved++
+			if opts.DryRun {
+				continue
+			}
+			if err := moveBlob(ctx, backend.Driver, sd.backends[owner].Driver, blobPath); err != nil {
+				return moved, err
+			}
+		}
+	}
+	return moved, nil
+}
+
+// listBlobs returns the directories of the blobs stored by backend.
+func listBlobs(ctx context.Context, backend storagedriver.StorageDriver) ([]string, error) {
+	var blobs []string
+	err := backend.Walk(ctx, blobsRoot, func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() && blobPathRegexp.MatchString(fi.Path()) {
+			blobs = append(blobs, fi.Path())
+			return storagedriver.ErrSkipDir
+		}
+		return nil
+	})
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil, nil
+	}
+	return blobs, err
+}
+
+// moveBlob copies the files of the blob directory blobPath of source to dest,
+// unless dest already stores them, and deletes the directory from source.
+func moveBlob(ctx context.Context, source, dest storagedriver.StorageDriver, blobPath string) error {
+	err := source.Walk(ctx, blobPath, func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			return nil
+		}
+		if existing, err := dest.Stat(ctx, fi.Path()); err == nil && existing.Size() == fi.Size() {
+			return nil
+		}
+		return copyFile(ctx, source, fi.Path(), dest, fi.Path())
+	})
+	if err != nil {
+		return err
+	}
+	return source.Delete(ctx, blobPath)
+}
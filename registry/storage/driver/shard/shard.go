@@ -0,0 +1,440 @@
+// Package shard provides a storagedriver.StorageDriver implementation
+// distributing blobs across several backend storage drivers, so that a
+// registry can exceed the request rate limits of a single bucket.
+//
+// The content of each blob is stored by the backend its digest hashes to on
+// a consistent hash ring of the backends, and all other content, such as
+// the metadata of repositories and uploads, by the first backend. Adding a
+// backend only moves the blobs it takes over on the ring, which can be done
+// with Rebalance; until then, blobs are still read from the backend storing
+// them.
+package shard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+const driverName = "shard"
+
+// virtualNodes is the number of points of each backend on the hash ring,
+// which evens out the share of blobs of the backends.
+const virtualNodes = 128
+
+// blobsRoot is the path of the blob store, see the storage package.
+const blobsRoot = "/docker/registry/v2/blobs"
+
+// blobPathRegexp matches the paths of and under the directory of a blob,
+// capturing the hex digest of the blob.
+var blobPathRegexp = regexp.MustCompile(`^` + blobsRoot + `/[^/]+/[0-9a-f]{2}/([0-9a-f]+)(?:/|$)`)
+
+func init() {
+	factory.Register(driverName, &shardDriverFactory{})
+}
+
+// shardDriverFactory implements the factory.StorageDriverFactory interface
+type shardDriverFactory struct{}
+
+func (factory *shardDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+// Backend is a storage driver storing a share of the blobs.
+type Backend struct {
+	// Name identifies the backend on the hash ring, so that renaming a
+	// backend moves the blobs it stores.
+	Name string
+
+	// Driver stores the content of the backend.
+	Driver storagedriver.StorageDriver
+}
+
+type driver struct {
+	backends []Backend
+	ring     ring
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation distributing blobs
+// across several backend storage drivers.
+type Driver struct {
+	baseEmbed
+}
+
+var _ storagedriver.StorageDriver = &Driver{}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - backends: a list of backends, each with a name and the parameters of
+// exactly one storage driver, keyed by its name.
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	list, ok := parameters["backends"].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("the backends parameter must list the backends to shard across")
+	}
+
+	var backends []Backend
+	for i, item := range list {
+		params, err := storagemiddleware.StringKeys(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend %d: %v", i, err)
+		}
+
+		name, ok := params["name"].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("backend %d must have a name", i)
+		}
+		delete(params, "name")
+		if len(params) != 1 {
+			return nil, fmt.Errorf("backend %s must configure exactly one storage driver", name)
+		}
+
+		for driverName, driverParams := range params {
+			var p map[string]interface{}
+			if driverParams != nil {
+				p, err = storagemiddleware.StringKeys(driverParams)
+				if err != nil {
+					return nil, fmt.Errorf("invalid parameters of backend %s: %v", name, err)
+				}
+			}
+			d, err := factory.Create(driverName, p)
+			if err != nil {
+				return nil, fmt.Errorf("failed to construct %s driver of backend %s: %v", driverName, name, err)
+			}
+			backends = append(backends, Backend{Name: name, Driver: d})
+		}
+	}
+
+	return New(backends)
+}
+
+// New constructs a new Driver distributing blobs across backends. Content
+// other than blobs is stored by the first backend.
+func New(backends []Backend) (*Driver, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("at least one backend is required")
+	}
+	names := make([]string, len(backends))
+	seen := make(map[string]struct{}, len(backends))
+	for i, backend := range backends {
+		if _, ok := seen[backend.Name]; ok {
+			return nil, fmt.Errorf("duplicate backend name %q", backend.Name)
+		}
+		seen[backend.Name] = struct{}{}
+		names[i] = backend.Name
+	}
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: &driver{
+					backends: backends,
+					ring:     newRing(names),
+				},
+			},
+		},
+	}, nil
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	backend, err := d.readBackend(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return backend.GetContent(ctx, path)
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	return d.writeBackend(path).PutContent(ctx, path, contents)
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	backend, err := d.readBackend(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Reader(ctx, path, offset)
+}
+
+// Writer returns a FileWriter which will store the content written to it at
+// the location designated by "path" after the call to Commit.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	return d.writeBackend(path).Writer(ctx, path, append)
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if !containsBlobs(path) {
+		backend, err := d.readBackend(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return backend.Stat(ctx, path)
+	}
+
+	for _, backend := range d.backends {
+		fi, err := backend.Driver.Stat(ctx, path)
+		if err == nil {
+			return fi, nil
+		}
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	if !containsBlobs(path) {
+		backend, err := d.readBackend(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return backend.List(ctx, path)
+	}
+
+	var found bool
+	seen := make(map[string]struct{})
+	for _, backend := range d.backends {
+		children, err := backend.Driver.List(ctx, path)
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, child := range children {
+			seen[child] = struct{}{}
+		}
+	}
+	if !found {
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+
+	children := make([]string, 0, len(seen))
+	for child := range seen {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	return children, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object. Objects are copied between backends, as when an upload is committed
+// to a blob stored by another backend than the uploads.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	source, err := d.readBackend(ctx, sourcePath)
+	if err != nil {
+		return err
+	}
+	dest := d.writeBackend(destPath)
+	if source == dest {
+		return source.Move(ctx, sourcePath, destPath)
+	}
+
+	if err := copyFile(ctx, source, sourcePath, dest, destPath); err != nil {
+		return err
+	}
+	return source.Delete(ctx, sourcePath)
+}
+
+// copyFile copies the file at sourcePath of source to destPath of dest.
+func copyFile(ctx context.Context, source storagedriver.StorageDriver, sourcePath string, dest storagedriver.StorageDriver, destPath string) error {
+	rc, err := source.Reader(ctx, sourcePath, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := dest.Writer(ctx, destPath, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, rc); err != nil {
+		fw.Cancel()
+		return err
+	}
+	if err := fw.Commit(); err != nil {
+		return err
+	}
+	return fw.Close()
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths,
+// in all backends which store them.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	if !containsBlobs(path) && !isBlobPath(path) {
+		return d.backends[0].Driver.Delete(ctx, path)
+	}
+
+	var found bool
+	for _, backend := range d.backends {
+		if err := backend.Driver.Delete(ctx, path); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	return nil
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at the
+// given path, from the backend storing it.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	backend, err := d.readBackend(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	return backend.URLFor(ctx, path, options)
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	if containsBlobs(path) {
+		return storagedriver.WalkFallback(ctx, d, path, f)
+	}
+	backend, err := d.readBackend(ctx, path)
+	if err != nil {
+		return err
+	}
+	return backend.Walk(ctx, path, f)
+}
+
+// writeBackend returns the backend storing the content at path.
+func (d *driver) writeBackend(path string) storagedriver.StorageDriver {
+	if m := blobPathRegexp.FindStringSubmatch(path); m != nil {
+		return d.backends[d.ring.owner(m[1])].Driver
+	}
+	return d.backends[0].Driver
+}
+
+// readBackend returns the backend storing the content at path. Blobs not
+// stored by the backend they hash to, as they were stored before it was
+// added, are read from the backend storing them.
+func (d *driver) readBackend(ctx context.Context, path string) (storagedriver.StorageDriver, error) {
+	owner := d.writeBackend(path)
+	if !isBlobPath(path) {
+		return owner, nil
+	}
+
+	if _, err := owner.Stat(ctx, path); err == nil {
+		return owner, nil
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		return nil, err
+	}
+	for _, backend := range d.backends {
+		if backend.Driver == owner {
+			continue
+		}
+		if _, err := backend.Driver.Stat(ctx, path); err == nil {
+			return backend.Driver, nil
+		} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	return owner, nil
+}
+
+// isBlobPath reports whether path is the directory of a blob, or under it.
+func isBlobPath(path string) bool {
+	return blobPathRegexp.MatchString(path)
+}
+
+// containsBlobs reports whether path is a directory containing the
+// directories of blobs of several backends, such as the blob store.
+func containsBlobs(path string) bool {
+	if path == "/" || strings.HasPrefix(blobsRoot, path+"/") {
+		return true
+	}
+	if path != blobsRoot && !strings.HasPrefix(path, blobsRoot+"/") {
+		return false
+	}
+	// <algorithm>/<first two hex bytes of digest>
+	return strings.Count(strings.TrimPrefix(path, blobsRoot), "/") <= 2 && !isBlobPath(path)
+}
+
+// ring is a consistent hash ring of backends.
+type ring struct {
+	points []uint64
+	owners []int
+}
+
+func newRing(names []string) ring {
+	type point struct {
+		hash  uint64
+		owner int
+	}
+	points := make([]point, 0, len(names)*virtualNodes)
+	for i, name := range names {
+		for v := 0; v < virtualNodes; v++ {
+			points = append(points, point{hash: hash(name + "-" + strconv.Itoa(v)), owner: i})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+
+	r := ring{
+		points: make([]uint64, len(points)),
+		owners: make([]int, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// owner returns the index of the backend storing the blob with the given hex
+// digest.
+func (r ring) owner(hex string) int {
+	h := hash(hex)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,229 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"github.com/opencontainers/go-digest"
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { check.TestingT(t) }
+
+func init() {
+	var backends []Backend
+	for _, name := range []string{"one", "two"} {
+		root, err := ioutil.TempDir("", "driver-")
+		if err != nil {
+			panic(err)
+		}
+		defer os.Remove(root)
+
+		backend, err := filesystem.FromParameters(map[string]interface{}{
+			"rootdirectory": root,
+		})
+		if err != nil {
+			panic(err)
+		}
+		backends = append(backends, Backend{Name: name, Driver: backend})
+	}
+
+	driver, err := New(backends)
+	if err != nil {
+		panic(err)
+	}
+
+	testsuites.RegisterSuite(func() (storagedriver.StorageDriver, error) {
+		return driver, nil
+	}, testsuites.NeverSkip)
+}
+
+func blobPath(dgst digest.Digest) string {
+	return fmt.Sprintf("%s/%s/%s/%s/data", blobsRoot, dgst.Algorithm(), dgst.Hex()[:2], dgst.Hex())
+}
+
+func newBackends(names ...string) []Backend {
+	var backends []Backend
+	for _, name := range names {
+		backends = append(backends, Backend{Name: name, Driver: inmemory.New()})
+	}
+	return backends
+}
+
+// stored returns the names of the backends storing the file at path.
+func stored(ctx context.Context, backends []Backend, path string) []string {
+	var names []string
+	for _, backend := range backends {
+		if _, err := backend.Driver.Stat(ctx, path); err == nil {
+			names = append(names, backend.Name)
+		}
+	}
+	return names
+}
+
+func TestFromParameters(t *testing.T) {
+	for _, params := range []map[string]interface{}{
+		{},
+		{"backends": []interface{}{}},
+		{"backends": []interface{}{map[interface{}]interface{}{"inmemory": nil}}},
+		{"backends": []interface{}{map[interface{}]interface{}{"name": "one", "inmemory": nil, "filesystem": nil}}},
+		{"backends": []interface{}{
+			map[interface{}]interface{}{"name": "one", "inmemory": nil},
+			map[interface{}]interface{}{"name": "one", "inmemory": nil},
+		}},
+		{"backends": []interface{}{map[interface{}]interface{}{"name": "one", "unknown": nil}}},
+	} {
+		if _, err := FromParameters(params); err == nil {
+			t.Errorf("expected an error constructing a driver from %v", params)
+		}
+	}
+
+	d, err := FromParameters(map[string]interface{}{"backends": []interface{}{
+		map[interface{}]interface{}{"name": "one", "inmemory": nil},
+		map[string]interface{}{"name": "two", "inmemory": map[interface{}]interface{}{}},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error constructing driver: %v", err)
+	}
+	if backends := d.StorageDriver.(*driver).backends; len(backends) != 2 || backends[1].Name != "two" {
+		t.Fatalf("unexpected backends: %v", backends)
+	}
+}
+
+func TestBlobsAreSharded(t *testing.T) {
+	ctx := context.Background()
+	backends := newBackends("one", "two", "three")
+	d, err := New(backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	var digests []digest.Digest
+	for i := 0; i < 60; i++ {
+		content := []byte(fmt.Sprintf("blob %d", i))
+		dgst := digest.FromBytes(content)
+		digests = append(digests, dgst)
+
+		// Uploads are stored by the first backend and moved to the
+		// backend of the blob when committed.
+		uploadPath := fmt.Sprintf("/docker/registry/v2/repositories/foo/_uploads/%d/data", i)
+		if err := d.PutContent(ctx, uploadPath, content); err != nil {
+			t.Fatal(err)
+		}
+		if where := stored(ctx, backends, uploadPath); len(where) != 1 || where[0] != "one" {
+			t.Fatalf("expected upload to be stored by the first backend, found in %v", where)
+		}
+		if err := d.Move(ctx, uploadPath, blobPath(dgst)); err != nil {
+			t.Fatalf("unexpected error moving upload: %v", err)
+		}
+
+		where := stored(ctx, backends, blobPath(dgst))
+		if len(where) != 1 {
+			t.Fatalf("expected blob to be stored by one backend, found in %v", where)
+		}
+		counts[where[0]]++
+
+		if p, err := d.GetContent(ctx, blobPath(dgst)); err != nil || string(p) != string(content) {
+			t.Fatalf("unexpected content of blob %s: %q, %v", dgst, p, err)
+		}
+	}
+	for _, backend := range backends {
+		if counts[backend.Name] == 0 {
+			t.Fatalf("expected blobs to be stored by all backends, got %v", counts)
+		}
+	}
+
+	var walked int
+	err = d.Walk(ctx, blobsRoot, func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			walked++
+		}
+		return nil
+	})
+	if err != nil || walked != len(digests) {
+		t.Fatalf("expected to walk %d blobs across backends, walked %d: %v", len(digests), walked, err)
+	}
+
+	if err := d.Delete(ctx, "/docker"); err != nil {
+		t.Fatal(err)
+	}
+	for _, dgst := range digests {
+		if where := stored(ctx, backends, blobPath(dgst)); len(where) != 0 {
+			t.Fatalf("expected blob %s to be deleted, found in %v", dgst, where)
+		}
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	ctx := context.Background()
+	backends := newBackends("one", "two")
+	d, err := New(backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var digests []digest.Digest
+	for i := 0; i < 60; i++ {
+		content := []byte(fmt.Sprintf("blob %d", i))
+		dgst := digest.FromBytes(content)
+		digests = append(digests, dgst)
+		if err := d.PutContent(ctx, blobPath(dgst), content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Add a backend.
+	backends = append(backends, newBackends("three")...)
+	d, err = New(backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dgst := range digests {
+		if _, err := d.GetContent(ctx, blobPath(dgst)); err != nil {
+			t.Fatalf("expected blob %s to be readable before rebalancing: %v", dgst, err)
+		}
+	}
+
+	planned, err := Rebalance(ctx, d, RebalanceOpts{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planned == 0 || planned == len(digests) {
+		t.Fatalf("expected some blobs to move to the added backend, got %d", planned)
+	}
+	if where := stored(ctx, backends[2:], blobsRoot); len(where) != 0 {
+		t.Fatalf("expected a dry run to move no blobs")
+	}
+
+	moved, err := Rebalance(ctx, d, RebalanceOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error rebalancing: %v", err)
+	}
+	if moved != planned {
+		t.Fatalf("expected %d blobs to be moved, moved %d", planned, moved)
+	}
+
+	sd := d.StorageDriver.(*driver)
+	for _, dgst := range digests {
+		where := stored(ctx, backends, blobPath(dgst))
+		if len(where) != 1 || where[0] != backends[sd.ring.owner(dgst.Hex())].Name {
+			t.Fatalf("expected blob %s to be stored by the backend it hashes to, found in %v", dgst, where)
+		}
+		if _, err := d.GetContent(ctx, blobPath(dgst)); err != nil {
+			t.Fatalf("expected blob %s to be readable after rebalancing: %v", dgst, err)
+		}
+	}
+
+	if moved, err := Rebalance(ctx, d, RebalanceOpts{}); err != nil || moved != 0 {
+		t.Fatalf("expected no blobs to move once rebalanced, moved %d: %v", moved, err)
+	}
+}
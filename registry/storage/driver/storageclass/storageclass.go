@@ -0,0 +1,525 @@
+// Package storageclass provides a storagedriver.StorageDriver implementation
+// storing blobs in several storage classes, such as buckets with different
+// costs or replication, each backed by its own storage driver.
+//
+// Blobs are written to the first class, the default one, which also stores
+// all other content, such as the metadata of repositories and uploads. Blobs
+// are then moved to other classes with Route, as the registry does when the
+// manifests referencing them are pushed, according to the storage class rules
+// of its policy. Blobs are read from the class storing them.
+//
+// Archive classes, such as buckets of an archive tier, store blobs which
+// cannot be read until they are restored to the default class with Restore.
+package storageclass
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/opencontainers/go-digest"
+)
+
+const driverName = "storageclass"
+
+// blobsRoot is the path of the blob store, see the storage package.
+const blobsRoot = "/docker/registry/v2/blobs"
+
+// blobPathRegexp matches the paths of and under the directory of a blob.
+var blobPathRegexp = regexp.MustCompile(`^` + blobsRoot + `/[^/]+/[0-9a-f]{2}/[0-9a-f]+(?:/|$)`)
+
+func init() {
+	factory.Register(driverName, &storageClassDriverFactory{})
+}
+
+// storageClassDriverFactory implements the factory.StorageDriverFactory interface
+type storageClassDriverFactory struct{}
+
+func (factory *storageClassDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+// Class is a storage class, whose blobs are stored by a storage driver.
+type Class struct {
+	// Name identifies the class in the storage class rules of the policy.
+	Name string
+
+	// Driver stores the content of the class.
+	Driver storagedriver.StorageDriver
+
+	// Archive marks a class whose blobs are not read, but restored to the
+	// default class first.
+	Archive bool
+}
+
+// ArchivedError is returned, enclosed in a storagedriver.Error, when reading
+// the data of a blob stored by an archive class.
+type ArchivedError struct {
+	Path  string
+	Class string
+}
+
+func (err ArchivedError) Error() string {
+	return fmt.Sprintf("%s is archived in storage class %s", err.Path, err.Class)
+}
+
+type driver struct {
+	classes []Class
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation storing blobs in
+// several storage classes.
+type Driver struct {
+	baseEmbed
+}
+
+var _ storagedriver.StorageDriver = &Driver{}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - classes: a list of storage classes, each with a name and the parameters
+// of exactly one storage driver, keyed by its name. The first class is the
+// default one. Classes with archive set to true are archive classes.
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	list, ok := parameters["classes"].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("the classes parameter must list the storage classes")
+	}
+
+	var classes []Class
+	for i, item := range list {
+		params, err := storagemiddleware.StringKeys(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage class %d: %v", i, err)
+		}
+
+		name, ok := params["name"].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("storage class %d must have a name", i)
+		}
+		delete(params, "name")
+		var archive bool
+		if v, ok := params["archive"]; ok {
+			if archive, ok = v.(bool); !ok {
+				return nil, fmt.Errorf("the archive parameter of storage class %s must be a boolean", name)
+			}
+			delete(params, "archive")
+		}
+		if len(params) != 1 {
+			return nil, fmt.Errorf("storage class %s must configure exactly one storage driver", name)
+		}
+
+		for driverName, driverParams := range params {
+			var p map[string]interface{}
+			if driverParams != nil {
+				p, err = storagemiddleware.StringKeys(driverParams)
+				if err != nil {
+					return nil, fmt.Errorf("invalid parameters of storage class %s: %v", name, err)
+				}
+			}
+			d, err := factory.Create(driverName, p)
+			if err != nil {
+				return nil, fmt.Errorf("failed to construct %s driver of storage class %s: %v", driverName, name, err)
+			}
+			classes = append(classes, Class{Name: name, Driver: d, Archive: archive})
+		}
+	}
+
+	return New(classes)
+}
+
+// New constructs a new Driver storing blobs in the storage classes. The first
+// class is the default one, storing new blobs and all other content, and
+// cannot be an archive class.
+func New(classes []Class) (*Driver, error) {
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("at least one storage class is required")
+	}
+	if classes[0].Archive {
+		return nil, fmt.Errorf("the default storage class %q cannot be an archive class", classes[0].Name)
+	}
+	seen := make(map[string]struct{}, len(classes))
+	for _, class := range classes {
+		if _, ok := seen[class.Name]; ok {
+			return nil, fmt.Errorf("duplicate storage class name %q", class.Name)
+		}
+		seen[class.Name] = struct{}{}
+	}
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: &driver{
+					classes: classes,
+				},
+			},
+		},
+	}, nil
+}
+
+// Classes returns the names of the storage classes of the driver, starting
+// with the default one.
+func (d *Driver) Classes() []string {
+	classes := d.StorageDriver.(*driver).classes
+	names := make([]string, len(classes))
+	for i, class := range classes {
+		names[i] = class.Name
+	}
+	return names
+}
+
+// Archives reports whether the driver has archive classes.
+func (d *Driver) Archives() bool {
+	for _, class := range d.StorageDriver.(*driver).classes {
+		if class.Archive {
+			return true
+		}
+	}
+	return false
+}
+
+// Archived returns the name of the archive class storing the blob dgst, or an
+// empty string if the blob is readable or not stored.
+func (d *Driver) Archived(ctx context.Context, dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	_, err := d.StorageDriver.(*driver).readableClass(ctx, blobDataPath(dgst))
+	if archived, ok := err.(ArchivedError); ok {
+		return archived.Class, nil
+	}
+	return "", err
+}
+
+// Restore moves the blob dgst from the archive class storing it to the
+// default class, where it is read again. Restoring a blob which is not
+// archived is not an error.
+func (d *Driver) Restore(ctx context.Context, dgst digest.Digest) error {
+	return d.Route(ctx, dgst, d.StorageDriver.(*driver).classes[0].Name)
+}
+
+// Route moves the blob dgst to the storage class named class, from the
+// classes storing it. Blobs remain readable while they are moved. Routing a
+// blob which is not stored is not an error, as it may have been deleted.
+func (d *Driver) Route(ctx context.Context, dgst digest.Digest, class string) error {
+	sd := d.StorageDriver.(*driver)
+
+	var dest storagedriver.StorageDriver
+	for _, c := range sd.classes {
+		if c.Name == class {
+			dest = c.Driver
+		}
+	}
+	if dest == nil {
+		return fmt.Errorf("unknown storage class %q", class)
+	}
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+	blobPath := strings.TrimSuffix(blobDataPath(dgst), "/data")
+
+	var sources []storagedriver.StorageDriver
+	for _, c := range sd.classes {
+		if c.Driver == dest {
+			continue
+		}
+		if _, err := c.Driver.Stat(ctx, blobPath); err == nil {
+			sources = append(sources, c.Driver)
+		} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	if err := copyBlob(ctx, sources[0], dest, blobPath); err != nil {
+		return err
+	}
+	for _, source := range sources {
+		if err := source.Delete(ctx, blobPath); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// blobDataPath returns the path of the data of the blob dgst.
+func blobDataPath(dgst digest.Digest) string {
+	return fmt.Sprintf("%s/%s/%s/%s/data", blobsRoot, dgst.Algorithm(), dgst.Hex()[:2], dgst.Hex())
+}
+
+// copyBlob copies the files of the blob directory blobPath of source to dest,
+// unless dest already stores them.
+func copyBlob(ctx context.Context, source, dest storagedriver.StorageDriver, blobPath string) error {
+	return source.Walk(ctx, blobPath, func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			return nil
+		}
+		if existing, err := dest.Stat(ctx, fi.Path()); err == nil && existing.Size() == fi.Size() {
+			return nil
+		}
+		return copyFile(ctx, source, fi.Path(), dest, fi.Path())
+	})
+}
+
+// copyFile copies the file at sourcePath of source to destPath of dest.
+func copyFile(ctx context.Context, source storagedriver.StorageDriver, sourcePath string, dest storagedriver.StorageDriver, destPath string) error {
+	rc, err := source.Reader(ctx, sourcePath, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := dest.Writer(ctx, destPath, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, rc); err != nil {
+		fw.Cancel()
+		return err
+	}
+	if err := fw.Commit(); err != nil {
+		return err
+	}
+	return fw.Close()
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	class, err := d.readableClass(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return class.GetContent(ctx, path)
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	class, err := d.readClass(ctx, path)
+	if err != nil {
+		return err
+	}
+	return class.PutContent(ctx, path, contents)
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	class, err := d.readableClass(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return class.Reader(ctx, path, offset)
+}
+
+// Writer returns a FileWriter which will store the content written to it at
+// the location designated by "path" after the call to Commit. Files are
+// written to the default class, unless they are files of a blob stored by
+// another class.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	class, err := d.readClass(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return class.Writer(ctx, path, append)
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if !containsBlobs(path) && !isBlobPath(path) {
+		return d.classes[0].Driver.Stat(ctx, path)
+	}
+
+	for _, class := range d.classes {
+		fi, err := class.Driver.Stat(ctx, path)
+		if err == nil {
+			return fi, nil
+		}
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	if !containsBlobs(path) {
+		class, err := d.readClass(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return class.List(ctx, path)
+	}
+
+	var found bool
+	seen := make(map[string]struct{})
+	for _, class := range d.classes {
+		children, err := class.Driver.List(ctx, path)
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, child := range children {
+			seen[child] = struct{}{}
+		}
+	}
+	if !found {
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+
+	children := make([]string, 0, len(seen))
+	for child := range seen {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	return children, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object. Objects are copied between classes, as when an upload is committed
+// to a blob stored by another class than the default one.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	source, err := d.readClass(ctx, sourcePath)
+	if err != nil {
+		return err
+	}
+	dest, err := d.readClass(ctx, destPath)
+	if err != nil {
+		return err
+	}
+	if source == dest {
+		return source.Move(ctx, sourcePath, destPath)
+	}
+
+	if err := copyFile(ctx, source, sourcePath, dest, destPath); err != nil {
+		return err
+	}
+	return source.Delete(ctx, sourcePath)
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths,
+// in all classes which store them.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	if !containsBlobs(path) && !isBlobPath(path) {
+		return d.classes[0].Driver.Delete(ctx, path)
+	}
+
+	var found bool
+	for _, class := range d.classes {
+		if err := class.Driver.Delete(ctx, path); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	return nil
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at the
+// given path, from the class storing it.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	class, err := d.readableClass(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	return class.URLFor(ctx, path, options)
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	if containsBlobs(path) {
+		return storagedriver.WalkFallback(ctx, d, path, f)
+	}
+	class, err := d.readClass(ctx, path)
+	if err != nil {
+		return err
+	}
+	return class.Walk(ctx, path, f)
+}
+
+// readClass returns the class storing the content at path: the class storing
+// the blob for the files of blobs, looked up from the default class, and the
+// default class otherwise, including for blobs which are not stored.
+func (d *driver) readClass(ctx context.Context, path string) (storagedriver.StorageDriver, error) {
+	def := d.classes[0].Driver
+	if !isBlobPath(path) || len(d.classes) == 1 {
+		return def, nil
+	}
+
+	blobPath := blobPathRegexp.FindString(path)
+	blobPath = strings.TrimSuffix(blobPath, "/")
+	for _, class := range d.classes {
+		if _, err := class.Driver.Stat(ctx, blobPath); err == nil {
+			return class.Driver, nil
+		} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	return def, nil
+}
+
+// readableClass returns the class storing the content at path, like
+// readClass, failing with an ArchivedError for the data of the blobs stored
+// by archive classes.
+func (d *driver) readableClass(ctx context.Context, path string) (storagedriver.StorageDriver, error) {
+	class, err := d.readClass(ctx, path)
+	if err != nil || !isBlobPath(path) || !strings.HasSuffix(path, "/data") {
+		return class, err
+	}
+	for _, c := range d.classes {
+		if c.Driver == class && c.Archive {
+			return nil, ArchivedError{Path: path, Class: c.Name}
+		}
+	}
+	return class, nil
+}
+
+// isBlobPath reports whether path is the directory of a blob, or under it.
+func isBlobPath(path string) bool {
+	return blobPathRegexp.MatchString(path)
+}
+
+// containsBlobs reports whether path is a directory containing the
+// directories of blobs of several classes, such as the blob store.
+func containsBlobs(path string) bool {
+	if path == "/" || strings.HasPrefix(blobsRoot, path+"/") {
+		return true
+	}
+	if path != blobsRoot && !strings.HasPrefix(path, blobsRoot+"/") {
+		return false
+	}
+	// <algorithm>/<first two hex bytes of digest>
+	return strings.Count(strings.TrimPrefix(path, blobsRoot), "/") <= 2 && !isBlobPath(path)
+}
@@ -0,0 +1,240 @@
+package storageclass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"github.com/opencontainers/go-digest"
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { check.TestingT(t) }
+
+func init() {
+	var classes []Class
+	for _, name := range []string{"standard", "archive"} {
+		root, err := ioutil.TempDir("", "driver-")
+		if err != nil {
+			panic(err)
+		}
+		defer os.Remove(root)
+
+		class, err := filesystem.FromParameters(map[string]interface{}{
+			"rootdirectory": root,
+		})
+		if err != nil {
+			panic(err)
+		}
+		classes = append(classes, Class{Name: name, Driver: class})
+	}
+
+	driver, err := New(classes)
+	if err != nil {
+		panic(err)
+	}
+
+	testsuites.RegisterSuite(func() (storagedriver.StorageDriver, error) {
+		return driver, nil
+	}, testsuites.NeverSkip)
+}
+
+func blobPath(dgst digest.Digest) string {
+	return fmt.Sprintf("%s/%s/%s/%s/data", blobsRoot, dgst.Algorithm(), dgst.Hex()[:2], dgst.Hex())
+}
+
+// stored returns the names of the classes storing the file at path.
+func stored(ctx context.Context, classes []Class, path string) []string {
+	var names []string
+	for _, class := range classes {
+		if _, err := class.Driver.Stat(ctx, path); err == nil {
+			names = append(names, class.Name)
+		}
+	}
+	return names
+}
+
+func TestFromParameters(t *testing.T) {
+	for _, params := range []map[string]interface{}{
+		{},
+		{"classes": []interface{}{}},
+		{"classes": []interface{}{map[interface{}]interface{}{"inmemory": nil}}},
+		{"classes": []interface{}{map[interface{}]interface{}{"name": "standard", "inmemory": nil, "filesystem": nil}}},
+		{"classes": []interface{}{
+			map[interface{}]interface{}{"name": "standard", "inmemory": nil},
+			map[interface{}]interface{}{"name": "standard", "inmemory": nil},
+		}},
+		{"classes": []interface{}{map[interface{}]interface{}{"name": "standard", "unknown": nil}}},
+		{"classes": []interface{}{map[interface{}]interface{}{"name": "standard", "archive": true, "inmemory": nil}}},
+		{"classes": []interface{}{
+			map[interface{}]interface{}{"name": "standard", "inmemory": nil},
+			map[interface{}]interface{}{"name": "archive", "archive": "yes", "inmemory": nil},
+		}},
+	} {
+		if _, err := FromParameters(params); err == nil {
+			t.Errorf("expected an error constructing a driver from %v", params)
+		}
+	}
+
+	d, err := FromParameters(map[string]interface{}{"classes": []interface{}{
+		map[interface{}]interface{}{"name": "standard", "inmemory": nil},
+		map[string]interface{}{"name": "archive", "inmemory": map[interface{}]interface{}{}},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error constructing driver: %v", err)
+	}
+	if classes := d.Classes(); len(classes) != 2 || classes[0] != "standard" || classes[1] != "archive" {
+		t.Fatalf("unexpected classes: %v", classes)
+	}
+	if d.Archives() {
+		t.Fatal("expected no archive classes")
+	}
+
+	d, err = FromParameters(map[string]interface{}{"classes": []interface{}{
+		map[interface{}]interface{}{"name": "standard", "inmemory": nil},
+		map[interface{}]interface{}{"name": "archive", "archive": true, "inmemory": nil},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error constructing driver: %v", err)
+	}
+	if !d.Archives() {
+		t.Fatal("expected an archive class")
+	}
+}
+
+func TestRoute(t *testing.T) {
+	ctx := context.Background()
+	classes := []Class{
+		{Name: "standard", Driver: inmemory.New()},
+		{Name: "cheap", Driver: inmemory.New()},
+		{Name: "replicated", Driver: inmemory.New()},
+	}
+	d, err := New(classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("blob")
+	dgst := digest.FromBytes(content)
+
+	// Uploads are committed to the default class.
+	uploadPath := "/docker/registry/v2/repositories/foo/_uploads/1/data"
+	if err := d.PutContent(ctx, uploadPath, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Move(ctx, uploadPath, blobPath(dgst)); err != nil {
+		t.Fatalf("unexpected error moving upload: %v", err)
+	}
+	if where := stored(ctx, classes, blobPath(dgst)); len(where) != 1 || where[0] != "standard" {
+		t.Fatalf("expected blob to be stored by the default class, found in %v", where)
+	}
+
+	for _, class := range []string{"cheap", "replicated", "replicated"} {
+		if err := d.Route(ctx, dgst, class); err != nil {
+			t.Fatalf("unexpected error routing blob to %s: %v", class, err)
+		}
+		if where := stored(ctx, classes, blobPath(dgst)); len(where) != 1 || where[0] != class {
+			t.Fatalf("expected blob to be stored by class %s, found in %v", class, where)
+		}
+		if p, err := d.GetContent(ctx, blobPath(dgst)); err != nil || string(p) != string(content) {
+			t.Fatalf("unexpected content of blob: %q, %v", p, err)
+		}
+	}
+
+	// Blobs pushed again are written to the class storing them.
+	if err := d.PutContent(ctx, uploadPath, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Move(ctx, uploadPath, blobPath(dgst)); err != nil {
+		t.Fatalf("unexpected error moving upload: %v", err)
+	}
+	if where := stored(ctx, classes, blobPath(dgst)); len(where) != 1 || where[0] != "replicated" {
+		t.Fatalf("expected blob to stay in its class, found in %v", where)
+	}
+
+	var walked []string
+	err = d.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			walked = append(walked, fi.Path())
+		}
+		return nil
+	})
+	if err != nil || len(walked) != 1 || walked[0] != blobPath(dgst) {
+		t.Fatalf("unexpected files walked: %v, %v", walked, err)
+	}
+
+	if err := d.Route(ctx, dgst, "unknown"); err == nil {
+		t.Fatal("expected an error routing to an unknown class")
+	}
+	if err := d.Route(ctx, digest.FromString("missing"), "cheap"); err != nil {
+		t.Fatalf("unexpected error routing a missing blob: %v", err)
+	}
+
+	if err := d.Delete(ctx, blobPath(dgst)); err != nil {
+		t.Fatal(err)
+	}
+	if where := stored(ctx, classes, blobPath(dgst)); len(where) != 0 {
+		t.Fatalf("expected blob to be deleted, found in %v", where)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	classes := []Class{
+		{Name: "standard", Driver: inmemory.New()},
+		{Name: "glacier", Driver: inmemory.New(), Archive: true},
+	}
+	d, err := New(classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("blob")
+	dgst := digest.FromBytes(content)
+	if err := d.PutContent(ctx, blobPath(dgst), content); err != nil {
+		t.Fatal(err)
+	}
+	if class, err := d.Archived(ctx, dgst); err != nil || class != "" {
+		t.Fatalf("unexpected archive class of a readable blob: %q, %v", class, err)
+	}
+
+	if err := d.Route(ctx, dgst, "glacier"); err != nil {
+		t.Fatal(err)
+	}
+	if class, err := d.Archived(ctx, dgst); err != nil || class != "glacier" {
+		t.Fatalf("unexpected archive class: %q, %v", class, err)
+	}
+	if _, err := d.GetContent(ctx, blobPath(dgst)); err == nil {
+		t.Fatal("expected an error reading an archived blob")
+	} else if archived := (ArchivedError{}); !errors.As(err, &archived) || archived.Class != "glacier" {
+		t.Fatalf("unexpected error reading an archived blob: %v", err)
+	}
+	if _, err := d.Reader(ctx, blobPath(dgst), 0); err == nil {
+		t.Fatal("expected an error reading an archived blob")
+	}
+	// Archived blobs are still listed and stated.
+	if fi, err := d.Stat(ctx, blobPath(dgst)); err != nil || fi.Size() != int64(len(content)) {
+		t.Fatalf("unexpected stat of an archived blob: %v, %v", fi, err)
+	}
+
+	if err := d.Restore(ctx, dgst); err != nil {
+		t.Fatal(err)
+	}
+	if where := stored(ctx, classes, blobPath(dgst)); len(where) != 1 || where[0] != "standard" {
+		t.Fatalf("expected blob to be restored to the default class, found in %v", where)
+	}
+	if p, err := d.GetContent(ctx, blobPath(dgst)); err != nil || string(p) != string(content) {
+		t.Fatalf("unexpected content of restored blob: %q, %v", p, err)
+	}
+	if class, err := d.Archived(ctx, digest.FromString("missing")); err != nil || class != "" {
+		t.Fatalf("unexpected archive class of a missing blob: %q, %v", class, err)
+	}
+}
@@ -2,11 +2,13 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Version is a string representing the storage driver version, of the form
@@ -111,6 +113,35 @@ type FileWriter interface {
 	Commit() error
 }
 
+// BatchOperation is a write of a batch applied with Batcher.ApplyBatch: the
+// content of the file at Path is replaced, or the path is deleted.
+type BatchOperation struct {
+	Path    string
+	Content []byte
+	Delete  bool
+}
+
+// Batcher is implemented by the storage drivers able to apply a batch of
+// writes atomically, sparing the storage layer from journaling them.
+type Batcher interface {
+	// ApplyBatch applies all of ops, in order, or none of them. Deleting a
+	// path which does not exist is not an error. It returns
+	// ErrUnsupportedMethod, applying none of them, if the driver cannot
+	// apply batches, as storage middlewares wrapping such drivers do.
+	ApplyBatch(ctx context.Context, ops []BatchOperation) error
+}
+
+// ApplyBatch applies ops with storageDriver if it implements Batcher, and
+// returns ErrUnsupportedMethod otherwise. Storage middlewares forward the
+// batches of the drivers they wrap with it.
+func ApplyBatch(ctx context.Context, storageDriver StorageDriver, ops []BatchOperation) error {
+	batcher, ok := storageDriver.(Batcher)
+	if !ok {
+		return ErrUnsupportedMethod{DriverName: storageDriver.Name()}
+	}
+	return batcher.ApplyBatch(ctx, ops)
+}
+
 // PathRegexp is the regular expression which each file path must match. A
 // file path is absolute, beginning with a slash and containing a positive
 // number of path components separated by slashes, where each component is
@@ -169,3 +200,57 @@ type Error struct {
 func (err Error) Error() string {
 	return fmt.Sprintf("%s: %s", err.DriverName, err.Enclosed)
 }
+
+// Unwrap returns the enclosed error.
+func (err Error) Unwrap() error {
+	return err.Enclosed
+}
+
+// TimeoutError is returned when a storage operation does not complete within
+// the timeout set for it. The operation may still complete, or have
+// completed, on the storage backend.
+type TimeoutError struct {
+	Operation  string
+	Path       string
+	Timeout    time.Duration
+	DriverName string
+}
+
+func (err TimeoutError) Error() string {
+	return fmt.Sprintf("%s: %s of %s timed out after %s", err.DriverName, err.Operation, err.Path, err.Timeout)
+}
+
+// Temporary reports that the operation may succeed when retried.
+func (err TimeoutError) Temporary() bool {
+	return true
+}
+
+// Transient reports whether an operation failing with err may succeed when
+// repeated, such as after a brief outage of the storage backend, rather than
+// failing again the same way. Drivers classify their own errors by returning
+// errors with a Temporary method, or with a StatusCode method returning the
+// HTTP status of the response of the backend. Errors otherwise unknown are
+// considered transient.
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	switch err.(type) {
+	case PathNotFoundError, InvalidPathError, InvalidOffsetError, ErrUnsupportedMethod:
+		return false
+	}
+
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) {
+		return temporary.Temporary()
+	}
+
+	var status interface{ StatusCode() int }
+	if errors.As(err, &status) {
+		code := status.StatusCode()
+		return code >= 500 || code == 408 || code == 429
+	}
+
+	return true
+}
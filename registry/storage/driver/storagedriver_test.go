@@ -0,0 +1,37 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// statusError is an error of a backend responding with an HTTP status.
+type statusError int
+
+func (e statusError) Error() string   { return fmt.Sprintf("status %d", int(e)) }
+func (e statusError) StatusCode() int { return int(e) }
+
+func TestTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errors.New("connection reset by peer"), true},
+		{PathNotFoundError{Path: "/a"}, false},
+		{InvalidPathError{Path: "a"}, false},
+		{ErrUnsupportedMethod{}, false},
+		{context.Canceled, false},
+		{fmt.Errorf("reading: %w", context.DeadlineExceeded), false},
+		{TimeoutError{Operation: "Stat", Path: "/a"}, true},
+		{Error{Enclosed: statusError(503)}, true},
+		{Error{Enclosed: statusError(429)}, true},
+		{Error{Enclosed: statusError(403)}, false},
+	} {
+		if transient := Transient(tc.err); transient != tc.transient {
+			t.Errorf("expected Transient(%v) to be %v", tc.err, tc.transient)
+		}
+	}
+}
@@ -5,14 +5,14 @@
 // Swift.
 //
 // It supports both TempAuth authentication and Keystone authentication
-// (up to version 3).
+// (up to version 3), including Keystone v3 application credentials.
 //
 // As Swift has a limit on the size of a single uploaded object (by default
 // this is 5GB), the driver makes use of the Swift Large Object Support
 // (http://docs.openstack.org/developer/swift/overview_large_objects.html).
-// Only one container is used for both manifests and data objects. Manifests
-// are stored in the 'files' pseudo directory, data objects are stored under
-// 'segments'.
+// Manifests are stored in the 'files' pseudo directory, data objects are
+// stored under 'segments', in the same container unless a segment container
+// is configured.
 package swift
 
 import (
@@ -58,29 +58,38 @@ var readAfterWriteTimeout = 15 * time.Second
 // readAfterWriteWait defines the time to sleep between two retries
 var readAfterWriteWait = 200 * time.Millisecond
 
+// segmentRetryWait defines the time to sleep before retrying to upload a
+// segment, doubled on each retry
+var segmentRetryWait = time.Second
+
 // Parameters A struct that encapsulates all of the driver parameters after all values have been set
 type Parameters struct {
-	Username            string
-	Password            string
-	AuthURL             string
-	Tenant              string
-	TenantID            string
-	Domain              string
-	DomainID            string
-	TenantDomain        string
-	TenantDomainID      string
-	TrustID             string
-	Region              string
-	AuthVersion         int
-	Container           string
-	Prefix              string
-	EndpointType        string
-	InsecureSkipVerify  bool
-	ChunkSize           int
-	SecretKey           string
-	AccessKey           string
-	TempURLContainerKey bool
-	TempURLMethods      []string
+	Username                    string
+	Password                    string
+	ApplicationCredentialID     string
+	ApplicationCredentialName   string
+	ApplicationCredentialSecret string
+	AuthURL                     string
+	Tenant                      string
+	TenantID                    string
+	Domain                      string
+	DomainID                    string
+	TenantDomain                string
+	TenantDomainID              string
+	TrustID                     string
+	Region                      string
+	AuthVersion                 int
+	Container                   string
+	SegmentContainer            string
+	Prefix                      string
+	EndpointType                string
+	InsecureSkipVerify          bool
+	ChunkSize                   int
+	Retries                     int
+	SecretKey                   string
+	AccessKey                   string
+	TempURLContainerKey         bool
+	TempURLMethods              []string
 }
 
 // swiftInfo maps the JSON structure returned by Swift /info endpoint
@@ -110,10 +119,12 @@ func (factory *swiftDriverFactory) Create(parameters map[string]interface{}) (st
 type driver struct {
 	Conn                 *swift.Connection
 	Container            string
+	SegmentContainer     string
 	Prefix               string
 	BulkDeleteSupport    bool
 	BulkDeleteMaxDeletes int
 	ChunkSize            int
+	Retries              int
 	SecretKey            string
 	AccessKey            string
 	TempURLContainerKey  bool
@@ -132,8 +143,8 @@ type Driver struct {
 
 // FromParameters constructs a new Driver with a given parameters map
 // Required parameters:
-// - username
-// - password
+// - username and password, or applicationcredentialid (or
+// applicationcredentialname and username) and applicationcredentialsecret
 // - authurl
 // - container
 func FromParameters(parameters map[string]interface{}) (*Driver, error) {
@@ -159,12 +170,35 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		return nil, err
 	}
 
-	if params.Username == "" {
-		return nil, fmt.Errorf("no username parameter provided")
-	}
+	if params.ApplicationCredentialID != "" || params.ApplicationCredentialName != "" || params.ApplicationCredentialSecret != "" {
+		if params.ApplicationCredentialSecret == "" {
+			return nil, fmt.Errorf("no applicationcredentialsecret parameter provided")
+		}
+
+		if params.ApplicationCredentialID == "" && params.ApplicationCredentialName == "" {
+			return nil, fmt.Errorf("no applicationcredentialid or applicationcredentialname parameter provided")
+		}
 
-	if params.Password == "" {
-		return nil, fmt.Errorf("no password parameter provided")
+		if params.ApplicationCredentialID == "" && params.Username == "" {
+			return nil, fmt.Errorf("the applicationcredentialname parameter requires the username parameter")
+		}
+
+		// Application credentials are only supported by Keystone v3
+		switch params.AuthVersion {
+		case 0:
+			params.AuthVersion = 3
+		case 3:
+		default:
+			return nil, fmt.Errorf("application credentials require authversion 3, not %d", params.AuthVersion)
+		}
+	} else {
+		if params.Username == "" {
+			return nil, fmt.Errorf("no username parameter provided")
+		}
+
+		if params.Password == "" {
+			return nil, fmt.Errorf("no password parameter provided")
+		}
 	}
 
 	if params.AuthURL == "" {
@@ -179,6 +213,10 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		return nil, fmt.Errorf("the chunksize %#v parameter should be a number that is larger than or equal to %d", params.ChunkSize, minChunkSize)
 	}
 
+	if params.Retries < 0 {
+		return nil, fmt.Errorf("the retries %#v parameter should be a number that is larger than or equal to 0", params.Retries)
+	}
+
 	return New(params)
 }
 
@@ -191,44 +229,57 @@ func New(params Parameters) (*Driver, error) {
 	}
 
 	ct := &swift.Connection{
-		UserName:       params.Username,
-		ApiKey:         params.Password,
-		AuthUrl:        params.AuthURL,
-		Region:         params.Region,
-		AuthVersion:    params.AuthVersion,
-		UserAgent:      "distribution/" + version.Version,
-		Tenant:         params.Tenant,
-		TenantId:       params.TenantID,
-		Domain:         params.Domain,
-		DomainId:       params.DomainID,
-		TenantDomain:   params.TenantDomain,
-		TenantDomainId: params.TenantDomainID,
-		TrustId:        params.TrustID,
-		EndpointType:   swift.EndpointType(params.EndpointType),
-		Transport:      transport,
-		ConnectTimeout: 60 * time.Second,
-		Timeout:        15 * 60 * time.Second,
+		UserName:                    params.Username,
+		ApiKey:                      params.Password,
+		ApplicationCredentialId:     params.ApplicationCredentialID,
+		ApplicationCredentialName:   params.ApplicationCredentialName,
+		ApplicationCredentialSecret: params.ApplicationCredentialSecret,
+		AuthUrl:                     params.AuthURL,
+		Region:                      params.Region,
+		AuthVersion:                 params.AuthVersion,
+		UserAgent:                   "distribution/" + version.Version,
+		Tenant:                      params.Tenant,
+		TenantId:                    params.TenantID,
+		Domain:                      params.Domain,
+		DomainId:                    params.DomainID,
+		TenantDomain:                params.TenantDomain,
+		TenantDomainId:              params.TenantDomainID,
+		TrustId:                     params.TrustID,
+		EndpointType:                swift.EndpointType(params.EndpointType),
+		Retries:                     params.Retries,
+		Transport:                   transport,
+		ConnectTimeout:              60 * time.Second,
+		Timeout:                     15 * 60 * time.Second,
 	}
 	err := ct.Authenticate()
 	if err != nil {
 		return nil, fmt.Errorf("swift authentication failed: %s", err)
 	}
 
-	if _, _, err := ct.Container(params.Container); err == swift.ContainerNotFound {
-		if err := ct.ContainerCreate(params.Container, nil); err != nil {
-			return nil, fmt.Errorf("failed to create container %s (%s)", params.Container, err)
+	segmentContainer := params.SegmentContainer
+	if segmentContainer == "" {
+		segmentContainer = params.Container
+	}
+
+	for _, container := range []string{params.Container, segmentContainer} {
+		if _, _, err := ct.Container(container); err == swift.ContainerNotFound {
+			if err := ct.ContainerCreate(container, nil); err != nil {
+				return nil, fmt.Errorf("failed to create container %s (%s)", container, err)
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to retrieve info about container %s (%s)", container, err)
 		}
-	} else if err != nil {
-		return nil, fmt.Errorf("failed to retrieve info about container %s (%s)", params.Container, err)
 	}
 
 	d := &driver{
-		Conn:           ct,
-		Container:      params.Container,
-		Prefix:         params.Prefix,
-		ChunkSize:      params.ChunkSize,
-		TempURLMethods: make([]string, 0),
-		AccessKey:      params.AccessKey,
+		Conn:             ct,
+		Container:        params.Container,
+		SegmentContainer: segmentContainer,
+		Prefix:           params.Prefix,
+		ChunkSize:        params.ChunkSize,
+		Retries:          ct.Retries,
+		TempURLMethods:   make([]string, 0),
+		AccessKey:        params.AccessKey,
 	}
 
 	info := swiftInfo{}
@@ -366,9 +417,10 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 // at the location designated by "path" after the call to Commit.
 func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
 	var (
-		segments     []swift.Object
-		segmentsPath string
-		err          error
+		segments         []swift.Object
+		segmentContainer = d.SegmentContainer
+		segmentsPath     string
+		err              error
 	)
 
 	if !append {
@@ -389,19 +441,22 @@ func (d *driver) Writer(ctx context.Context, path string, append bool) (storaged
 			if err != nil {
 				return nil, err
 			}
-			if err := d.Conn.ObjectMove(d.Container, d.swiftPath(path), d.Container, getSegmentPath(segmentsPath, len(segments))); err != nil {
+			if err := d.Conn.ObjectMove(d.Container, d.swiftPath(path), segmentContainer, getSegmentPath(segmentsPath, len(segments))); err != nil {
 				return nil, err
 			}
 			segments = []swift.Object{info}
 		} else {
-			_, segmentsPath = parseManifest(manifest)
-			if segments, err = d.getAllSegments(segmentsPath); err != nil {
+			// Keep appending to the container of the segments written
+			// so far, even if another segment container was configured
+			// since.
+			segmentContainer, segmentsPath = parseManifest(manifest)
+			if segments, err = d.getAllSegments(segmentContainer, segmentsPath); err != nil {
 				return nil, err
 			}
 		}
 	}
 
-	return d.newWriter(path, segmentsPath, segments), nil
+	return d.newWriter(path, segmentContainer, segmentsPath, segments), nil
 }
 
 // Stat retrieves the FileInfo for the given path, including the current size
@@ -530,19 +585,25 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 		return err
 	}
 
+	// The names of the objects to delete, by container, as segments may be
+	// stored in another container than manifests.
+	filenames := make(map[string][]string)
 	for _, obj := range objects {
 		if obj.PseudoDirectory {
 			continue
 		}
+		filenames[d.Container] = append(filenames[d.Container], obj.Name)
 		if _, headers, err := d.Conn.Object(d.Container, obj.Name); err == nil {
 			manifest, ok := headers["X-Object-Manifest"]
 			if ok {
-				_, prefix := parseManifest(manifest)
-				segments, err := d.getAllSegments(prefix)
+				container, prefix := parseManifest(manifest)
+				segments, err := d.getAllSegments(container, prefix)
 				if err != nil {
 					return err
 				}
-				objects = append(objects, segments...)
+				for _, segment := range segments {
+					filenames[container] = append(filenames[container], segment.Name)
+				}
 			}
 		} else {
 			if err == swift.ObjectNotFound {
@@ -552,18 +613,40 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 		}
 	}
 
-	if d.BulkDeleteSupport && len(objects) > 0 && d.BulkDeleteMaxDeletes > 0 {
-		filenames := make([]string, len(objects))
-		for i, obj := range objects {
-			filenames[i] = obj.Name
+	for container, names := range filenames {
+		if err := d.deleteObjects(path, container, names); err != nil {
+			return err
+		}
+	}
+
+	_, _, err = d.Conn.Object(d.Container, d.swiftPath(path))
+	if err == nil {
+		if err := d.Conn.ObjectDelete(d.Container, d.swiftPath(path)); err != nil {
+			if err == swift.ObjectNotFound {
+				return storagedriver.PathNotFoundError{Path: path}
+			}
+			return err
+		}
+	} else if err == swift.ObjectNotFound {
+		if len(objects) == 0 {
+			return storagedriver.PathNotFoundError{Path: path}
 		}
+	} else {
+		return err
+	}
+	return nil
+}
 
-		chunks, err := chunkFilenames(filenames, d.BulkDeleteMaxDeletes)
+// deleteObjects deletes the named objects of the container, in bulk if
+// supported, while deleting path.
+func (d *driver) deleteObjects(path, container string, names []string) error {
+	if d.BulkDeleteSupport && len(names) > 0 && d.BulkDeleteMaxDeletes > 0 {
+		chunks, err := chunkFilenames(names, d.BulkDeleteMaxDeletes)
 		if err != nil {
 			return err
 		}
 		for _, chunk := range chunks {
-			_, err := d.Conn.BulkDelete(d.Container, chunk)
+			_, err := d.Conn.BulkDelete(container, chunk)
 			// Don't fail on ObjectNotFound because eventual consistency
 			// makes this situation normal.
 			if err != nil && err != swift.Forbidden && err != swift.ObjectNotFound {
@@ -573,31 +656,16 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 				return err
 			}
 		}
-	} else {
-		for _, obj := range objects {
-			if err := d.Conn.ObjectDelete(d.Container, obj.Name); err != nil {
-				if err == swift.ObjectNotFound {
-					return storagedriver.PathNotFoundError{Path: obj.Name}
-				}
-				return err
-			}
-		}
+		return nil
 	}
 
-	_, _, err = d.Conn.Object(d.Container, d.swiftPath(path))
-	if err == nil {
-		if err := d.Conn.ObjectDelete(d.Container, d.swiftPath(path)); err != nil {
+	for _, name := range names {
+		if err := d.Conn.ObjectDelete(container, name); err != nil {
 			if err == swift.ObjectNotFound {
-				return storagedriver.PathNotFoundError{Path: path}
+				return storagedriver.PathNotFoundError{Path: name}
 			}
 			return err
 		}
-	} else if err == swift.ObjectNotFound {
-		if len(objects) == 0 {
-			return storagedriver.PathNotFoundError{Path: path}
-		}
-	} else {
-		return err
 	}
 	return nil
 }
@@ -680,9 +748,9 @@ func (d *driver) swiftSegmentPath(path string) (string, error) {
 	return strings.TrimLeft(strings.TrimRight(d.Prefix+"/segments/"+path[0:3]+"/"+path[3:], "/"), "/"), nil
 }
 
-func (d *driver) getAllSegments(path string) ([]swift.Object, error) {
+func (d *driver) getAllSegments(container, path string) ([]swift.Object, error) {
 	//a simple container listing works 99.9% of the time
-	segments, err := d.Conn.ObjectsAll(d.Container, &swift.ObjectsOpts{Prefix: path})
+	segments, err := d.Conn.ObjectsAll(container, &swift.ObjectsOpts{Prefix: path})
 	if err != nil {
 		if err == swift.ContainerNotFound {
 			return nil, storagedriver.PathNotFoundError{Path: path}
@@ -713,7 +781,7 @@ func (d *driver) getAllSegments(path string) ([]swift.Object, error) {
 		//guaranteed to return the correct metadata, except for the pathological
 		//case of an outage of large parts of the Swift cluster or its network,
 		//since every segment is only written once.)
-		segment, _, err := d.Conn.Object(d.Container, segmentPath)
+		segment, _, err := d.Conn.Object(container, segmentPath)
 		switch err {
 		case nil:
 			//found new segment -> keep going, more might be missing
@@ -785,32 +853,35 @@ func getSegmentPath(segmentsPath string, partNumber int) string {
 }
 
 type writer struct {
-	driver       *driver
-	path         string
-	segmentsPath string
-	size         int64
-	bw           *bufio.Writer
-	closed       bool
-	committed    bool
-	cancelled    bool
+	driver           *driver
+	path             string
+	segmentContainer string
+	segmentsPath     string
+	size             int64
+	bw               *bufio.Writer
+	closed           bool
+	committed        bool
+	cancelled        bool
 }
 
-func (d *driver) newWriter(path, segmentsPath string, segments []swift.Object) storagedriver.FileWriter {
+func (d *driver) newWriter(path, segmentContainer, segmentsPath string, segments []swift.Object) storagedriver.FileWriter {
 	var size int64
 	for _, segment := range segments {
 		size += segment.Bytes
 	}
 	return &writer{
-		driver:       d,
-		path:         path,
-		segmentsPath: segmentsPath,
-		size:         size,
+		driver:           d,
+		path:             path,
+		segmentContainer: segmentContainer,
+		segmentsPath:     segmentsPath,
+		size:             size,
 		bw: bufio.NewWriterSize(&segmentWriter{
 			conn:          d.Conn,
-			container:     d.Container,
+			container:     segmentContainer,
 			segmentsPath:  segmentsPath,
 			segmentNumber: len(segments) + 1,
 			maxChunkSize:  d.ChunkSize,
+			retries:       d.Retries,
 		}, d.ChunkSize),
 	}
 }
@@ -843,7 +914,7 @@ func (w *writer) Close() error {
 	}
 
 	if !w.committed && !w.cancelled {
-		if err := w.driver.createManifest(w.path, w.driver.Container+"/"+w.segmentsPath); err != nil {
+		if err := w.driver.createManifest(w.path, w.segmentContainer+"/"+w.segmentsPath); err != nil {
 			return err
 		}
 		if err := w.waitForSegmentsToShowUp(); err != nil {
@@ -878,7 +949,7 @@ func (w *writer) Commit() error {
 		return err
 	}
 
-	if err := w.driver.createManifest(w.path, w.driver.Container+"/"+w.segmentsPath); err != nil {
+	if err := w.driver.createManifest(w.path, w.segmentContainer+"/"+w.segmentsPath); err != nil {
 		return err
 	}
 
@@ -915,6 +986,7 @@ type segmentWriter struct {
 	segmentsPath  string
 	segmentNumber int
 	maxChunkSize  int
+	retries       int
 }
 
 func (sw *segmentWriter) Write(p []byte) (int, error) {
@@ -924,8 +996,7 @@ func (sw *segmentWriter) Write(p []byte) (int, error) {
 		if offset+chunkSize > len(p) {
 			chunkSize = len(p) - offset
 		}
-		_, err := sw.conn.ObjectPut(sw.container, getSegmentPath(sw.segmentsPath, sw.segmentNumber), bytes.NewReader(p[offset:offset+chunkSize]), false, "", contentType, nil)
-		if err != nil {
+		if err := sw.putSegment(p[offset : offset+chunkSize]); err != nil {
 			return n, err
 		}
 
@@ -935,3 +1006,28 @@ func (sw *segmentWriter) Write(p []byte) (int, error) {
 
 	return n, nil
 }
+
+// putSegment uploads the next segment, retrying on the errors which may not
+// happen again. Segments are only referenced by the manifest once committed,
+// so uploading one again is safe.
+func (sw *segmentWriter) putSegment(segment []byte) error {
+	waitingTime := segmentRetryWait
+	for attempt := 0; ; attempt++ {
+		_, err := sw.conn.ObjectPut(sw.container, getSegmentPath(sw.segmentsPath, sw.segmentNumber), bytes.NewReader(segment), false, "", contentType, nil)
+		if err == nil || attempt >= sw.retries || !retryable(err) {
+			return err
+		}
+		time.Sleep(waitingTime)
+		waitingTime *= 2
+	}
+}
+
+// retryable reports whether a request failing with err may succeed when
+// repeated: errors of the connection, and server errors or throttling
+// reported by Swift.
+func retryable(err error) bool {
+	if swiftErr, ok := err.(*swift.Error); ok {
+		return swiftErr.StatusCode >= 500 || swiftErr.StatusCode == http.StatusRequestTimeout || swiftErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -1,6 +1,7 @@
 package swift
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"reflect"
@@ -22,6 +23,10 @@ func Test(t *testing.T) { check.TestingT(t) }
 
 var swiftDriverConstructor func(prefix string) (*Driver, error)
 
+// segmentContainer is the segment container of the drivers swiftDriverConstructor
+// constructs, set by the tests of segment containers.
+var segmentContainer string
+
 func init() {
 	var (
 		username           string
@@ -85,27 +90,28 @@ func init() {
 
 	swiftDriverConstructor = func(root string) (*Driver, error) {
 		parameters := Parameters{
-			username,
-			password,
-			authURL,
-			tenant,
-			tenantID,
-			domain,
-			domainID,
-			tenantDomain,
-			tenantDomainID,
-			trustID,
-			region,
-			AuthVersion,
-			container,
-			root,
-			endpointType,
-			insecureSkipVerify,
-			defaultChunkSize,
-			secretKey,
-			accessKey,
-			containerKey,
-			tempURLMethods,
+			Username:            username,
+			Password:            password,
+			AuthURL:             authURL,
+			Tenant:              tenant,
+			TenantID:            tenantID,
+			Domain:              domain,
+			DomainID:            domainID,
+			TenantDomain:        tenantDomain,
+			TenantDomainID:      tenantDomainID,
+			TrustID:             trustID,
+			Region:              region,
+			AuthVersion:         AuthVersion,
+			Container:           container,
+			SegmentContainer:    segmentContainer,
+			Prefix:              root,
+			EndpointType:        endpointType,
+			InsecureSkipVerify:  insecureSkipVerify,
+			ChunkSize:           defaultChunkSize,
+			SecretKey:           secretKey,
+			AccessKey:           accessKey,
+			TempURLContainerKey: containerKey,
+			TempURLMethods:      tempURLMethods,
 		}
 
 		return New(parameters)
@@ -183,6 +189,112 @@ func TestEmptyRootList(t *testing.T) {
 	}
 }
 
+func TestSegmentContainer(t *testing.T) {
+	segmentContainer = "test-segments"
+	defer func() { segmentContainer = "" }()
+
+	root, err := ioutil.TempDir("", "driver-")
+	if err != nil {
+		t.Fatalf("unexpected error creating temporary directory: %v", err)
+	}
+	defer os.Remove(root)
+
+	d, err := swiftDriverConstructor(root)
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	sd := d.StorageDriver.(*driver)
+
+	ctx := context.Background()
+	contents := bytes.Repeat([]byte("a"), minChunkSize)
+	fw, err := d.Writer(ctx, "/dir/blob", false)
+	if err != nil {
+		t.Fatalf("unexpected error opening writer: %v", err)
+	}
+	if _, err := fw.Write(contents); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	// Appending keeps writing segments to the container of the manifest.
+	sd.SegmentContainer = sd.Container
+	fw, err = d.Writer(ctx, "/dir/blob", true)
+	if err != nil {
+		t.Fatalf("unexpected error opening writer to append: %v", err)
+	}
+	if _, err := fw.Write(contents); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := fw.Commit(); err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+	fw.Close()
+
+	read, err := d.GetContent(ctx, "/dir/blob")
+	if err != nil || len(read) != 2*len(contents) {
+		t.Fatalf("unexpected content: %d bytes, %v", len(read), err)
+	}
+
+	_, headers, err := sd.Conn.Object(sd.Container, sd.swiftPath("/dir/blob"))
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest: %v", err)
+	}
+	container, prefix := parseManifest(headers["X-Object-Manifest"])
+	if container != "test-segments" {
+		t.Fatalf("unexpected container of segments: %s", container)
+	}
+	segments, err := sd.getAllSegments(container, prefix)
+	if err != nil || len(segments) != 2 {
+		t.Fatalf("unexpected segments: %v, %v", segments, err)
+	}
+
+	if err := d.Delete(ctx, "/dir"); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if segments, err := sd.getAllSegments(container, prefix); err != nil || len(segments) != 0 {
+		t.Fatalf("expected the segments to be deleted, got %v, %v", segments, err)
+	}
+}
+
+func TestApplicationCredentialParameters(t *testing.T) {
+	for _, tc := range []struct {
+		parameters map[string]interface{}
+		err        string
+	}{
+		{
+			parameters: map[string]interface{}{"applicationcredentialid": "id"},
+			err:        "no applicationcredentialsecret parameter provided",
+		},
+		{
+			parameters: map[string]interface{}{"applicationcredentialsecret": "secret"},
+			err:        "no applicationcredentialid or applicationcredentialname parameter provided",
+		},
+		{
+			parameters: map[string]interface{}{"applicationcredentialname": "name", "applicationcredentialsecret": "secret"},
+			err:        "the applicationcredentialname parameter requires the username parameter",
+		},
+		{
+			parameters: map[string]interface{}{"applicationcredentialid": "id", "applicationcredentialsecret": "secret", "authversion": 2},
+			err:        "application credentials require authversion 3, not 2",
+		},
+		{
+			parameters: map[string]interface{}{"applicationcredentialid": "id", "applicationcredentialsecret": "secret"},
+			err:        "no authurl parameter provided",
+		},
+		{
+			parameters: map[string]interface{}{"username": "user", "password": "password", "authurl": "https://keystone", "container": "c", "retries": -1},
+			err:        "the retries -1 parameter should be a number that is larger than or equal to 0",
+		},
+	} {
+		_, err := FromParameters(tc.parameters)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%v: unexpected error: %v, expected %s", tc.parameters, err, tc.err)
+		}
+	}
+}
+
 func TestFilenameChunking(t *testing.T) {
 	// Test valid input and sizes
 	input := []string{"a", "b", "c", "d", "e"}
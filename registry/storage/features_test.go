@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestEnableFeatures(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	registry := createRegistry(t, driver, EnableFeatures(func(feature, repository string) bool {
+		return repository == "canary/app"
+	}))
+
+	for _, c := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"canary/app", true},
+		{"stable/app", false},
+	} {
+		repo := makeRepository(t, registry, c.name)
+		image := uploadRandomSchema2Image(t, repo)
+		tags := repo.Tags(ctx)
+		if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tags.(TagHistory).At(ctx, "latest", time.Now()); (err == nil) != c.enabled {
+			t.Errorf("%s: unexpected history of the tag: %v", c.name, err)
+		}
+
+		desc, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte(c.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		linkPath, err := blobLinkPath(c.name, desc.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := driver.GetContent(ctx, linkPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var metadata linkMetadata
+		if err := json.Unmarshal(content, &metadata); (err == nil) != c.enabled {
+			t.Errorf("%s: unexpected link %q", c.name, content)
+		}
+	}
+}
@@ -8,9 +8,15 @@ import (
 	"io"
 	"io/ioutil"
 
+	prometheus "github.com/distribution/distribution/v3/metrics"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
+// abortedReads counts the reads of files stopped before their end as their
+// context was canceled, such as when the client downloading a blob
+// disconnected.
+var abortedReads = prometheus.StorageNamespace.NewCounter("aborted_reads", "The number of file reads aborted as their context was canceled")
+
 // TODO(stevvooe): Set an optimal buffer size here. We'll have to
 // understand the latency characteristics of the underlying network to
 // set this correctly, so we may want to leave it to the driver. For
@@ -55,6 +61,13 @@ func (fr *fileReader) Read(p []byte) (n int, err error) {
 		return 0, fr.err
 	}
 
+	// Stop reading from the backend once the reader is no longer wanted,
+	// as not all drivers abort their reads when their context is done.
+	if err := fr.ctx.Err(); err != nil {
+		abortedReads.Inc(1)
+		return 0, fr.closeWithErr(err)
+	}
+
 	rd, err := fr.reader()
 	if err != nil {
 		return 0, err
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"bytes"
+	stdcontext "context"
 	"io"
 	mrand "math/rand"
 	"testing"
@@ -191,3 +192,34 @@ func TestFileReaderErrors(t *testing.T) {
 	//     failure cases and how the storage driver propagates these errors
 	//     up the stack.
 }
+
+func TestFileReaderCanceled(t *testing.T) {
+	driver := inmemory.New()
+	path := "/canceled"
+	content := make([]byte, 3*fileReaderBufferSize)
+	ctx, cancel := stdcontext.WithCancel(context.Background())
+	defer cancel()
+
+	if err := driver.PutContent(ctx, path, content); err != nil {
+		t.Fatalf("error putting content: %v", err)
+	}
+
+	fr, err := newFileReader(ctx, driver, path, int64(len(content)))
+	if err != nil {
+		t.Fatalf("error allocating file reader: %v", err)
+	}
+	defer fr.Close()
+
+	p := make([]byte, 1024)
+	if _, err := fr.Read(p); err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+
+	cancel()
+	if _, err := fr.Read(p); err != stdcontext.Canceled {
+		t.Fatalf("expected reads to stop once canceled, got %v", err)
+	}
+	if fr.rc != nil {
+		t.Fatal("expected the backend reader to be closed")
+	}
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"path"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/reference"
@@ -18,6 +20,10 @@ func emit(format string, a ...interface{}) {
 type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
+
+	// Repository restricts the collection to the content of the
+	// repository it names.
+	Repository string
 }
 
 // ManifestDel contains manifest structure which will be deleted
@@ -27,87 +33,41 @@ type ManifestDel struct {
 	Tags   []string
 }
 
-// MarkAndSweep performs a mark and sweep of registry data
+// MarkAndSweep performs a mark and sweep of registry data. When
+// opts.Repository is set, only the content of that repository is collected.
 func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts GCOpts) error {
 	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
 	if !ok {
 		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
 	}
 
+	// content under legal hold is kept regardless
+	holds, err := NewHoldStore(storageDriver).List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list legal holds: %v", err)
+	}
+
+	if opts.Repository != "" {
+		return markAndSweepRepository(ctx, storageDriver, registry, repositoryEnumerator, holds, opts)
+	}
+
 	// mark
 	markSet := make(map[digest.Digest]struct{})
 	manifestArr := make([]ManifestDel, 0)
-	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+	var repoNames []string
+	// the manifest revisions kept of each repository
+	revisionsKept := make(map[string]map[digest.Digest]struct{})
+	err = repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
 		emit(repoName)
+		repoNames = append(repoNames, repoName)
 
-		var err error
-		named, err := reference.WithName(repoName)
-		if err != nil {
-			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
-		}
-		repository, err := registry.Repository(ctx, named)
+		deleted, kept, err := markRepository(ctx, registry, repoName, holds, opts, markSet)
 		if err != nil {
-			return fmt.Errorf("failed to construct repository: %v", err)
-		}
-
-		manifestService, err := repository.Manifests(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to construct manifest service: %v", err)
-		}
-
-		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
-		if !ok {
-			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
-		}
-
-		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
-			if opts.RemoveUntagged {
-				// fetch all tags where this manifest is the latest one
-				tags, err := repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
-				if err != nil {
-					return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
-				}
-				if len(tags) == 0 {
-					emit("manifest eligible for deletion: %s", dgst)
-					// fetch all tags from repository
-					// all of these tags could contain manifest in history
-					// which means that we need check (and delete) those references when deleting manifest
-					allTags, err := repository.Tags(ctx).All(ctx)
-					if err != nil {
-						return fmt.Errorf("failed to retrieve tags %v", err)
-					}
-					manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
-					return nil
-				}
-			}
-			// Mark the manifest's blob
-			emit("%s: marking manifest %s ", repoName, dgst)
-			markSet[dgst] = struct{}{}
-
-			manifest, err := manifestService.Get(ctx, dgst)
-			if err != nil {
-				return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
-			}
-
-			descriptors := manifest.References()
-			for _, descriptor := range descriptors {
-				markSet[descriptor.Digest] = struct{}{}
-				emit("%s: marking blob %s", repoName, descriptor.Digest)
-			}
-
-			return nil
-		})
-
-		// In certain situations such as unfinished uploads, deleting all
-		// tags in S3 or removing the _manifests folder manually, this
-		// error may be of type PathNotFound.
-		//
-		// In these cases we can continue marking other manifests safely.
-		if _, ok := err.(driver.PathNotFoundError); ok {
-			return nil
+			return err
 		}
-
-		return err
+		manifestArr = append(manifestArr, deleted...)
+		revisionsKept[repoName] = kept
+		return nil
 	})
 
 	if err != nil {
@@ -124,11 +84,24 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			}
 		}
 	}
+	if !opts.DryRun {
+		// The referrers index only links the revisions kept, so that the
+		// referrers API lists no referrer deleted, before or by this run.
+		for _, repoName := range repoNames {
+			if err := vacuum.RemoveReferrerLinks(repoName, revisionsKept[repoName]); err != nil {
+				return fmt.Errorf("failed to delete referrer links of %s: %v", repoName, err)
+			}
+		}
+	}
 	blobService := registry.Blobs()
 	deleteSet := make(map[digest.Digest]struct{})
 	err = blobService.Enumerate(ctx, func(dgst digest.Digest) error {
 		// check if digest is in markSet. If not, delete it!
 		if _, ok := markSet[dgst]; !ok {
+			if id := heldBy(holds, "", dgst); id != "" {
+				emit("blob %s is under legal hold %s", dgst, id)
+				return nil
+			}
 			deleteSet[dgst] = struct{}{}
 		}
 		return nil
@@ -148,5 +121,283 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		}
 	}
 
+	if !opts.DryRun && len(deleteSet) > 0 {
+		for _, repoName := range repoNames {
+			if err := vacuum.RemoveLayerLinks(repoName, deleteSet); err != nil {
+				return fmt.Errorf("failed to delete layer links of %s: %v", repoName, err)
+			}
+		}
+	}
+
 	return err
 }
+
+// markRepository marks the manifests kept of the repository repoName and the
+// blobs they reference in markSet. It returns the manifests eligible for
+// deletion and the revisions kept.
+func markRepository(ctx context.Context, registry distribution.Namespace, repoName string, holds []Hold, opts GCOpts, markSet map[digest.Digest]struct{}) ([]ManifestDel, map[digest.Digest]struct{}, error) {
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to construct repository: %v", err)
+	}
+
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, nil, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	// Referrers, such as signatures and SBOMs, are usually untagged,
+	// and are kept as long as their subject is.
+	var revisions []digest.Digest
+	stored := make(map[digest.Digest]bool)
+	untagged := make(map[digest.Digest]bool)
+	subjects := make(map[digest.Digest]digest.Digest)
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		revisions = append(revisions, dgst)
+		stored[dgst] = true
+		if !opts.RemoveUntagged {
+			return nil
+		}
+
+		// fetch all tags where this manifest is the latest one
+		tags, err := repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
+		if err != nil {
+			return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
+		}
+		if id := heldBy(holds, repoName, dgst); len(tags) == 0 && id != "" {
+			emit("%s: manifest %s is under legal hold %s", repoName, dgst, id)
+		} else if len(tags) == 0 {
+			untagged[dgst] = true
+			if subject := manifestSubject(ctx, manifestService, dgst); subject != "" {
+				subjects[dgst] = subject
+			}
+		}
+		return nil
+	})
+
+	// In certain situations such as unfinished uploads, deleting all
+	// tags in S3 or removing the _manifests folder manually, this
+	// error may be of type PathNotFound.
+	//
+	// In these cases we can continue marking other manifests safely.
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		err = nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Keep the untagged referrers of the manifests kept, and of the
+	// referrers kept in turn.
+	for kept := true; kept; {
+		kept = false
+		for dgst, subject := range subjects {
+			if untagged[dgst] && !untagged[subject] && stored[subject] {
+				emit("%s: keeping referrer %s of %s", repoName, dgst, subject)
+				delete(untagged, dgst)
+				kept = true
+			}
+		}
+	}
+
+	var allTags []string
+	var manifestArr []ManifestDel
+	keptRevisions := make(map[digest.Digest]struct{}, len(revisions))
+	for _, dgst := range revisions {
+		if untagged[dgst] {
+			emit("manifest eligible for deletion: %s", dgst)
+			if allTags == nil {
+				// fetch all tags from repository
+				// all of these tags could contain manifest in history
+				// which means that we need check (and delete) those references when deleting manifest
+				allTags, err = repository.Tags(ctx).All(ctx)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to retrieve tags %v", err)
+				}
+			}
+			manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
+			continue
+		}
+		keptRevisions[dgst] = struct{}{}
+
+		// Mark the manifest's blob
+		emit("%s: marking manifest %s ", repoName, dgst)
+		markSet[dgst] = struct{}{}
+
+		manifest, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+		}
+
+		descriptors := manifest.References()
+		for _, descriptor := range descriptors {
+			markSet[descriptor.Digest] = struct{}{}
+			emit("%s: marking blob %s", repoName, descriptor.Digest)
+		}
+	}
+	return manifestArr, keptRevisions, nil
+}
+
+// markAndSweepRepository collects the content of the repository
+// opts.Repository only. The blobs it links, as layers or manifest revisions,
+// but no longer references are unlinked from it, and deleted unless another
+// repository links them too, so that the blobs shared with repositories not
+// marked are kept.
+func markAndSweepRepository(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, repositoryEnumerator distribution.RepositoryEnumerator, holds []Hold, opts GCOpts) error {
+	repoName := opts.Repository
+	if _, err := reference.WithName(repoName); err != nil {
+		return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	layers, err := linkedDigests(ctx, storageDriver, layersPathSpec{name: repoName})
+	if err != nil {
+		return fmt.Errorf("failed to list the layers of %s: %v", repoName, err)
+	}
+	revisions, err := linkedDigests(ctx, storageDriver, manifestRevisionsPathSpec{name: repoName})
+	if err != nil {
+		return fmt.Errorf("failed to list the manifests of %s: %v", repoName, err)
+	}
+	if len(layers) == 0 && len(revisions) == 0 {
+		return fmt.Errorf("repository %s not found", repoName)
+	}
+
+	// mark
+	emit(repoName)
+	markSet := make(map[digest.Digest]struct{})
+	manifestArr, kept, err := markRepository(ctx, registry, repoName, holds, opts, markSet)
+	if err != nil {
+		return fmt.Errorf("failed to mark: %v", err)
+	}
+
+	// The blobs linked by the repository which it no longer references.
+	unlinkSet := make(map[digest.Digest]struct{})
+	for _, linked := range []map[digest.Digest]struct{}{layers, revisions} {
+		for dgst := range linked {
+			if _, ok := markSet[dgst]; ok {
+				continue
+			}
+			if id := heldBy(holds, repoName, dgst); id != "" {
+				emit("blob %s is under legal hold %s", dgst, id)
+				continue
+			}
+			unlinkSet[dgst] = struct{}{}
+		}
+	}
+
+	// Of those, the blobs linked by other repositories are shared and
+	// kept.
+	deleteSet := make(map[digest.Digest]struct{}, len(unlinkSet))
+	for dgst := range unlinkSet {
+		deleteSet[dgst] = struct{}{}
+	}
+	err = repositoryEnumerator.Enumerate(ctx, func(otherName string) error {
+		if otherName == repoName || len(deleteSet) == 0 {
+			return nil
+		}
+		for _, spec := range []pathSpec{layersPathSpec{name: otherName}, manifestRevisionsPathSpec{name: otherName}} {
+			linked, err := linkedDigests(ctx, storageDriver, spec)
+			if err != nil {
+				return fmt.Errorf("failed to list the links of %s: %v", otherName, err)
+			}
+			for dgst := range linked {
+				if _, ok := deleteSet[dgst]; ok {
+					emit("blob %s is shared with %s", dgst, otherName)
+					delete(deleteSet, dgst)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check shared blobs: %v", err)
+	}
+	emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion, %d blobs shared", len(markSet), len(deleteSet), len(manifestArr), len(unlinkSet)-len(deleteSet))
+
+	// sweep
+	if opts.DryRun {
+		for dgst := range deleteSet {
+			emit("blob eligible for deletion: %s", dgst)
+		}
+		return nil
+	}
+	vacuum := NewVacuum(ctx, storageDriver)
+	for _, obj := range manifestArr {
+		if err := vacuum.RemoveManifest(obj.Name, obj.Digest, obj.Tags); err != nil {
+			return fmt.Errorf("failed to delete manifest %s: %v", obj.Digest, err)
+		}
+	}
+	if err := vacuum.RemoveReferrerLinks(repoName, kept); err != nil {
+		return fmt.Errorf("failed to delete referrer links of %s: %v", repoName, err)
+	}
+	// The links go first, so that an interrupted run leaves no link to a
+	// deleted blob.
+	if err := vacuum.RemoveLayerLinks(repoName, unlinkSet); err != nil {
+		return fmt.Errorf("failed to delete layer links of %s: %v", repoName, err)
+	}
+	for dgst := range deleteSet {
+		emit("blob eligible for deletion: %s", dgst)
+		if err := vacuum.RemoveBlob(string(dgst)); err != nil {
+			return fmt.Errorf("failed to delete blob %s: %v", dgst, err)
+		}
+	}
+	return nil
+}
+
+// linkedDigests returns the digests of the links in the directory of spec,
+// laid out as <algorithm>/<hex digest>/link.
+func linkedDigests(ctx context.Context, storageDriver driver.StorageDriver, spec pathSpec) (map[digest.Digest]struct{}, error) {
+	root, err := pathFor(spec)
+	if err != nil {
+		return nil, err
+	}
+	dgsts := make(map[digest.Digest]struct{})
+	algorithms, err := storageDriver.List(ctx, root)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return dgsts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, algorithmPath := range algorithms {
+		linkPaths, err := storageDriver.List(ctx, algorithmPath)
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, linkPath := range linkPaths {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(algorithmPath)), path.Base(linkPath))
+			if dgst.Validate() == nil {
+				dgsts[dgst] = struct{}{}
+			}
+		}
+	}
+	return dgsts, nil
+}
+
+// manifestSubject returns the digest of the subject of the manifest dgst,
+// empty when it has none or cannot be read.
+func manifestSubject(ctx context.Context, manifestService distribution.ManifestService, dgst digest.Digest) digest.Digest {
+	manifest, err := manifestService.Get(ctx, dgst)
+	if err != nil {
+		return ""
+	}
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return ""
+	}
+	var fields struct {
+		Subject *distribution.Descriptor `json:"subject"`
+	}
+	if err := json.Unmarshal(payload, &fields); err != nil || fields.Subject == nil {
+		return ""
+	}
+	return fields.Subject.Digest
+}
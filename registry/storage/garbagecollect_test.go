@@ -7,12 +7,15 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/docker/libtrust"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type image struct {
@@ -500,3 +503,210 @@ func TestOrphanBlobDeleted(t *testing.T) {
 		}
 	}
 }
+
+func TestOrphanBlobLinksDeleted(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, EnableLinkMetadata)
+	repo := makeRepository(t, registry, "michael_z_doukas")
+
+	digests, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+
+	if err = testutil.UploadBlobs(repo, digests); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+
+	uploadRandomSchema2Image(t, repo)
+
+	// Run GC
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	// The links to the deleted blobs, trusted without stating the blob, are
+	// gone too.
+	for dgst := range digests {
+		if _, err := repo.Blobs(ctx).Stat(ctx, dgst); err != distribution.ErrBlobUnknown {
+			t.Fatalf("expected the orphan layer to be unknown, got %v", err)
+		}
+	}
+}
+
+func uploadReferrer(t *testing.T, repository distribution.Repository, subject digest.Digest) digest.Digest {
+	ctx := context.Background()
+	config, err := repository.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, nil)
+	if err != nil {
+		t.Fatalf("failed to upload config: %v", err)
+	}
+	referrer, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config:  config,
+		Subject: &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject},
+	})
+	if err != nil {
+		t.Fatalf("failed to make referrer: %v", err)
+	}
+	dgst, err := makeManifestService(t, repository).Put(ctx, referrer)
+	if err != nil {
+		t.Fatalf("failed to upload referrer: %v", err)
+	}
+	return dgst
+}
+
+func TestReferrersOfKeptSubjectsKept(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "referrers")
+	manifestService := makeManifestService(t, repo)
+
+	tagged := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: tagged.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	untagged := uploadRandomSchema2Image(t, repo)
+
+	kept := uploadReferrer(t, repo, tagged.manifestDigest)
+	keptOfKept := uploadReferrer(t, repo, kept)
+	deleted := uploadReferrer(t, repo, untagged.manifestDigest)
+
+	referrerPath := func(subject, dgst digest.Digest) string {
+		return path.Join(referrersLinkPath("referrers"), subject.Algorithm().String(), subject.Hex(), dgst.Algorithm().String(), dgst.Hex(), "link")
+	}
+	// A link left by a referrer deleted without garbage collection.
+	stalePath := referrerPath(tagged.manifestDigest, digest.FromString("removed"))
+	if err := inmemoryDriver.PutContent(ctx, stalePath, []byte(digest.FromString("removed"))); err != nil {
+		t.Fatal(err)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	manifests := allManifests(t, manifestService)
+	for _, dgst := range []digest.Digest{tagged.manifestDigest, kept, keptOfKept} {
+		if _, ok := manifests[dgst]; !ok {
+			t.Errorf("expected manifest %s to be kept", dgst)
+		}
+	}
+	for _, dgst := range []digest.Digest{untagged.manifestDigest, deleted} {
+		if _, ok := manifests[dgst]; ok {
+			t.Errorf("expected manifest %s to be deleted", dgst)
+		}
+	}
+
+	for _, p := range []string{referrerPath(tagged.manifestDigest, kept), referrerPath(kept, keptOfKept)} {
+		if _, err := inmemoryDriver.GetContent(ctx, p); err != nil {
+			t.Errorf("expected referrer link %s to be kept: %v", p, err)
+		}
+	}
+	for _, p := range []string{stalePath, referrerPath(untagged.manifestDigest, deleted)} {
+		if _, err := inmemoryDriver.GetContent(ctx, p); err == nil {
+			t.Errorf("expected referrer link %s to be deleted", p)
+		} else if _, ok := err.(driver.PathNotFoundError); !ok {
+			t.Fatal(err)
+		}
+	}
+	subjectPath := path.Join(referrersLinkPath("referrers"), untagged.manifestDigest.Algorithm().String(), untagged.manifestDigest.Hex())
+	if _, err := inmemoryDriver.Stat(ctx, subjectPath); err == nil {
+		t.Errorf("expected the referrers index of the deleted subject to be deleted")
+	}
+}
+
+func TestRepositoryGC(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	cleaned := makeRepository(t, registry, "team/cleaned")
+	other := makeRepository(t, registry, "team/other")
+
+	kept := uploadRandomSchema2Image(t, cleaned)
+	uploadRandomSchema2Image(t, other)
+
+	// Blobs left unreferenced in each repository, one of them linked by
+	// both.
+	orphans, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	shared, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	otherOrphans, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	for _, upload := range []struct {
+		repo  distribution.Repository
+		blobs map[digest.Digest]io.ReadSeeker
+	}{{cleaned, orphans}, {cleaned, shared}, {other, shared}, {other, otherOrphans}} {
+		for _, rs := range upload.blobs {
+			rs.Seek(0, io.SeekStart)
+		}
+		if err := testutil.UploadBlobs(upload.repo, upload.blobs); err != nil {
+			t.Fatalf("Failed to upload blob: %v", err)
+		}
+	}
+
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{Repository: "team/cleaned", DryRun: true}); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	blobs := allBlobs(t, registry)
+	for dgst := range orphans {
+		if _, ok := blobs[dgst]; !ok {
+			t.Fatalf("Orphan layer deleted by a dry run: %v", dgst)
+		}
+	}
+
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{Repository: "team/cleaned"}); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	blobs = allBlobs(t, registry)
+	for dgst := range orphans {
+		if _, ok := blobs[dgst]; ok {
+			t.Fatalf("Orphan layer is present: %v", dgst)
+		}
+	}
+	for _, digests := range []map[digest.Digest]io.ReadSeeker{kept.layers, shared, otherOrphans} {
+		for dgst := range digests {
+			if _, ok := blobs[dgst]; !ok {
+				t.Fatalf("Layer referenced or linked elsewhere deleted: %v", dgst)
+			}
+		}
+	}
+	if _, ok := blobs[kept.manifestDigest]; !ok {
+		t.Fatalf("Manifest deleted: %v", kept.manifestDigest)
+	}
+
+	// The shared blob is only unlinked from the repository collected.
+	for dgst := range shared {
+		if _, err := cleaned.Blobs(ctx).Stat(ctx, dgst); err != distribution.ErrBlobUnknown {
+			t.Fatalf("expected the shared layer to be unlinked, got %v", err)
+		}
+		if _, err := other.Blobs(ctx).Stat(ctx, dgst); err != nil {
+			t.Fatalf("expected the shared layer to stay linked elsewhere, got %v", err)
+		}
+	}
+
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{Repository: "team/missing"}); err == nil {
+		t.Fatal("expected an error collecting an unknown repository")
+	}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// The kinds of the nodes of a graph.
+const (
+	GraphNodeManifest = "manifest"
+	GraphNodeBlob     = "blob"
+)
+
+// The types of the edges of a graph.
+const (
+	// GraphEdgeManifest links an index to a manifest it lists.
+	GraphEdgeManifest = "manifest"
+
+	// GraphEdgeBlob links a manifest to a blob it references, such as its
+	// config or a layer.
+	GraphEdgeBlob = "blob"
+
+	// GraphEdgeSubject links a manifest to its subject, the manifest it
+	// refers to.
+	GraphEdgeSubject = "subject"
+)
+
+// GraphNode is a manifest or a blob of a graph.
+type GraphNode struct {
+	Digest    digest.Digest `json:"digest"`
+	Kind      string        `json:"kind"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Size      int64         `json:"size"`
+
+	// Missing is set for the manifests listed by an index, or indexed as
+	// referrers, but not stored in the repository.
+	Missing bool `json:"missing,omitempty"`
+
+	// External is set for the subjects of the manifests of the graph which
+	// are not part of it, and would not be affected by deleting its root.
+	External bool `json:"external,omitempty"`
+}
+
+// GraphEdge links two nodes of a graph.
+type GraphEdge struct {
+	From digest.Digest `json:"from"`
+	To   digest.Digest `json:"to"`
+	Type string        `json:"type"`
+}
+
+// Graph is the closure of a manifest: the manifests it lists, if an index,
+// the blobs it references, and its referrers, along with theirs in turn.
+type Graph struct {
+	Root  digest.Digest `json:"root"`
+	Nodes []GraphNode   `json:"nodes"`
+	Edges []GraphEdge   `json:"edges"`
+
+	// Size is the size of the nodes of the graph, excluding the external
+	// ones. Blobs referenced by several manifests are counted once.
+	Size int64 `json:"size"`
+}
+
+// ManifestGraph returns the graph rooted at the manifest root of the
+// repository, with the referrers indexed in the storage driver. Referrers
+// link to the manifest they refer to by subject edges. The blobs of the graph
+// may still be referenced by manifests outside of it.
+func ManifestGraph(ctx context.Context, repository distribution.Repository, storageDriver driver.StorageDriver, root digest.Digest) (Graph, error) {
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return Graph{}, err
+	}
+
+	graph := Graph{Root: root, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	nodes := make(map[digest.Digest]bool)
+	edges := make(map[GraphEdge]bool)
+	addNode := func(node GraphNode) {
+		if !nodes[node.Digest] {
+			nodes[node.Digest] = true
+			graph.Nodes = append(graph.Nodes, node)
+		}
+	}
+	addEdge := func(edge GraphEdge) {
+		if !edges[edge] {
+			edges[edge] = true
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+
+	subjects := make(map[digest.Digest]distribution.Descriptor)
+	var subjectOrder []digest.Digest
+	queue := []distribution.Descriptor{{Digest: root}}
+	for len(queue) > 0 {
+		desc := queue[0]
+		queue = queue[1:]
+		if nodes[desc.Digest] {
+			continue
+		}
+
+		manifest, err := manifestService.Get(ctx, desc.Digest)
+		if err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok && desc.Digest != root {
+				addNode(GraphNode{Digest: desc.Digest, Kind: GraphNodeManifest, MediaType: desc.MediaType, Size: desc.Size, Missing: true})
+				continue
+			}
+			return Graph{}, err
+		}
+		mediaType, payload, err := manifest.Payload()
+		if err != nil {
+			return Graph{}, err
+		}
+		addNode(GraphNode{Digest: desc.Digest, Kind: GraphNodeManifest, MediaType: mediaType, Size: int64(len(payload))})
+
+		_, isIndex := manifest.(*manifestlist.DeserializedManifestList)
+		for _, ref := range manifest.References() {
+			if isIndex {
+				addEdge(GraphEdge{From: desc.Digest, To: ref.Digest, Type: GraphEdgeManifest})
+				queue = append(queue, ref)
+				continue
+			}
+			addEdge(GraphEdge{From: desc.Digest, To: ref.Digest, Type: GraphEdgeBlob})
+			addNode(GraphNode{Digest: ref.Digest, Kind: GraphNodeBlob, MediaType: ref.MediaType, Size: ref.Size})
+		}
+
+		var fields struct {
+			Subject *distribution.Descriptor `json:"subject"`
+		}
+		if err := json.Unmarshal(payload, &fields); err == nil && fields.Subject != nil {
+			addEdge(GraphEdge{From: desc.Digest, To: fields.Subject.Digest, Type: GraphEdgeSubject})
+			if _, ok := subjects[fields.Subject.Digest]; !ok {
+				subjects[fields.Subject.Digest] = *fields.Subject
+				subjectOrder = append(subjectOrder, fields.Subject.Digest)
+			}
+		}
+
+		referrers, err := Referrers(ctx, storageDriver, repository.Named().Name(), desc.Digest)
+		if err != nil {
+			return Graph{}, err
+		}
+		sort.Slice(referrers, func(i, j int) bool { return referrers[i] < referrers[j] })
+		for _, referrer := range referrers {
+			addEdge(GraphEdge{From: referrer, To: desc.Digest, Type: GraphEdgeSubject})
+			queue = append(queue, distribution.Descriptor{Digest: referrer})
+		}
+	}
+
+	for _, node := range graph.Nodes {
+		graph.Size += node.Size
+	}
+	for _, dgst := range subjectOrder {
+		subject := subjects[dgst]
+		addNode(GraphNode{Digest: dgst, Kind: GraphNodeManifest, MediaType: subject.MediaType, Size: subject.Size, External: true})
+	}
+	return graph, nil
+}
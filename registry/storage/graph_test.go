@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestManifestGraph(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	registry := createRegistry(t, driver)
+	repo := makeRepository(t, registry, "graph")
+
+	image1 := uploadRandomSchema2Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+	manifestList, err := testutil.MakeManifestList(registry.BlobStatter(), []digest.Digest{image1.manifestDigest, image2.manifestDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := makeManifestService(t, repo).Put(ctx, manifestList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := uploadReferrer(t, repo, index)
+	signatureOfSignature := uploadReferrer(t, repo, signature)
+	// Not part of the graph of the index.
+	uploadRandomSchema2Image(t, repo)
+
+	graph, err := ManifestGraph(ctx, repo, driver, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if graph.Root != index {
+		t.Errorf("unexpected root: %s", graph.Root)
+	}
+
+	nodes := make(map[digest.Digest]GraphNode)
+	var size int64
+	for _, node := range graph.Nodes {
+		if node.Missing || node.External {
+			t.Errorf("unexpected node: %+v", node)
+		}
+		nodes[node.Digest] = node
+		size += node.Size
+	}
+	blobs := make(map[digest.Digest]bool)
+	for _, image := range []image{image1, image2} {
+		for _, ref := range image.manifest.References() {
+			blobs[ref.Digest] = true
+			if nodes[ref.Digest].Kind != GraphNodeBlob || nodes[ref.Digest].Size != ref.Size {
+				t.Errorf("unexpected node of blob %s: %+v", ref.Digest, nodes[ref.Digest])
+			}
+		}
+	}
+	for _, dgst := range []digest.Digest{index, image1.manifestDigest, image2.manifestDigest, signature, signatureOfSignature} {
+		if nodes[dgst].Kind != GraphNodeManifest || nodes[dgst].Size == 0 {
+			t.Errorf("unexpected node of manifest %s: %+v", dgst, nodes[dgst])
+		}
+	}
+	// The signatures have an empty config, shared with the images.
+	blobs[digest.FromBytes(nil)] = true
+	if len(nodes) != 5+len(blobs) {
+		t.Errorf("unexpected nodes: %+v", graph.Nodes)
+	}
+	if graph.Size != size {
+		t.Errorf("unexpected size %d, expected %d", graph.Size, size)
+	}
+
+	edges := make(map[GraphEdge]bool)
+	for _, edge := range graph.Edges {
+		edges[edge] = true
+	}
+	for _, edge := range []GraphEdge{
+		{From: index, To: image1.manifestDigest, Type: GraphEdgeManifest},
+		{From: index, To: image2.manifestDigest, Type: GraphEdgeManifest},
+		{From: signature, To: index, Type: GraphEdgeSubject},
+		{From: signatureOfSignature, To: signature, Type: GraphEdgeSubject},
+	} {
+		if !edges[edge] {
+			t.Errorf("missing edge %+v", edge)
+		}
+	}
+	for _, ref := range image1.manifest.References() {
+		if !edges[GraphEdge{From: image1.manifestDigest, To: ref.Digest, Type: GraphEdgeBlob}] {
+			t.Errorf("missing edge to blob %s", ref.Digest)
+		}
+	}
+
+	// The subject of the root is outside of its graph.
+	graph, err = ManifestGraph(ctx, repo, driver, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var external []GraphNode
+	size = 0
+	for _, node := range graph.Nodes {
+		if node.External {
+			external = append(external, node)
+		} else {
+			size += node.Size
+		}
+	}
+	if len(external) != 1 || external[0].Digest != index || graph.Size != size {
+		t.Errorf("unexpected graph of a referrer: %+v", graph)
+	}
+
+	if _, err := ManifestGraph(ctx, repo, driver, digest.FromString("unknown")); err == nil {
+		t.Error("expected an error for an unknown manifest")
+	} else if _, ok := err.(distribution.ErrManifestUnknownRevision); !ok {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
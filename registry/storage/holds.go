@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/uuid"
+	"github.com/opencontainers/go-digest"
+)
+
+// ErrHoldUnknown is returned when a legal hold does not exist.
+var ErrHoldUnknown = errors.New("unknown legal hold")
+
+// Hold is a legal hold placed on content. Held content cannot be deleted,
+// through the API, by garbage collection or by the expiry of cached content,
+// until the hold is released.
+//
+// A hold either applies to a digest, or to tags of a repository matching a
+// pattern. Holds on a digest apply to the manifest or blob with that digest;
+// garbage collection also keeps the content referenced by held manifests.
+// Holds on tags prevent the matching tags from being deleted or moved to
+// another manifest, and the manifests they point to from being deleted.
+type Hold struct {
+	// ID identifies the hold. It is assigned when the hold is placed.
+	ID string `json:"id"`
+
+	// Repository restricts the hold to a repository. A hold on a digest
+	// without a repository applies to the content in all repositories.
+	Repository string `json:"repository,omitempty"`
+
+	// Digest is the digest of the held content.
+	Digest digest.Digest `json:"digest,omitempty"`
+
+	// Tags is a pattern, in the syntax of path.Match, of the tags held in
+	// the repository.
+	Tags string `json:"tags,omitempty"`
+
+	// Reason records why the hold was placed, such as a case reference.
+	Reason string `json:"reason,omitempty"`
+
+	// CreatedBy is the name of the user who placed the hold.
+	CreatedBy string `json:"createdBy,omitempty"`
+
+	// CreatedAt is the time the hold was placed.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Validate checks that the hold applies to either a digest or tags.
+func (h Hold) Validate() error {
+	if (h.Digest == "") == (h.Tags == "") {
+		return errors.New("exactly one of digest and tags must be set")
+	}
+	if h.Repository != "" {
+		if _, err := reference.WithName(h.Repository); err != nil {
+			return fmt.Errorf("invalid repository: %v", err)
+		}
+	}
+	if h.Digest != "" {
+		if err := h.Digest.Validate(); err != nil {
+			return fmt.Errorf("invalid digest: %v", err)
+		}
+	}
+	if h.Tags != "" {
+		if h.Repository == "" {
+			return errors.New("holds on tags require a repository")
+		}
+		if _, err := path.Match(h.Tags, ""); err != nil {
+			return fmt.Errorf("invalid tags pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+// holdsDigest reports whether the hold applies to dgst in the repository
+// named repo, or in any repository when repo is empty.
+func (h Hold) holdsDigest(repo string, dgst digest.Digest) bool {
+	return h.Digest == dgst && (repo == "" || h.Repository == "" || h.Repository == repo)
+}
+
+// holdsTag reports whether the hold applies to tag in the repository named
+// repo.
+func (h Hold) holdsTag(repo, tag string) bool {
+	if h.Tags == "" || h.Repository != repo {
+		return false
+	}
+	ok, _ := path.Match(h.Tags, tag)
+	return ok
+}
+
+// heldBy returns the ID of a hold applying to dgst in the repository named
+// repo, or in any repository when repo is empty.
+func heldBy(holds []Hold, repo string, dgst digest.Digest) string {
+	for _, h := range holds {
+		if h.holdsDigest(repo, dgst) {
+			return h.ID
+		}
+	}
+	return ""
+}
+
+// HoldStore stores the legal holds of a registry in its storage driver.
+type HoldStore struct {
+	driver driver.StorageDriver
+}
+
+// NewHoldStore returns the store of the legal holds kept with the content
+// of driver.
+func NewHoldStore(driver driver.StorageDriver) *HoldStore {
+	return &HoldStore{driver: driver}
+}
+
+// List returns the holds in the order they were placed.
+func (hs *HoldStore) List(ctx context.Context) ([]Hold, error) {
+	root, err := pathFor(holdsPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := hs.driver.List(ctx, root)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	holds := make([]Hold, 0, len(paths))
+	for _, p := range paths {
+		hold, err := hs.Get(ctx, path.Base(p))
+		if err == ErrHoldUnknown {
+			// released while listing
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+
+	sort.Slice(holds, func(i, j int) bool {
+		return holds[i].CreatedAt.Before(holds[j].CreatedAt)
+	})
+	return holds, nil
+}
+
+// Get returns the hold identified by id.
+func (hs *HoldStore) Get(ctx context.Context, id string) (Hold, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return Hold{}, ErrHoldUnknown
+	}
+
+	p, err := pathFor(holdPathSpec{id: id})
+	if err != nil {
+		return Hold{}, err
+	}
+
+	content, err := hs.driver.GetContent(ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return Hold{}, ErrHoldUnknown
+		}
+		return Hold{}, err
+	}
+
+	var hold Hold
+	if err := json.Unmarshal(content, &hold); err != nil {
+		return Hold{}, fmt.Errorf("invalid legal hold %s: %v", id, err)
+	}
+	return hold, nil
+}
+
+// Place validates and stores a hold, assigning its ID and creation time.
+func (hs *HoldStore) Place(ctx context.Context, hold Hold) (Hold, error) {
+	if err := hold.Validate(); err != nil {
+		return Hold{}, err
+	}
+	hold.ID = uuid.Generate().String()
+	hold.CreatedAt = time.Now().UTC()
+
+	content, err := json.Marshal(hold)
+	if err != nil {
+		return Hold{}, err
+	}
+	p, err := pathFor(holdPathSpec{id: hold.ID})
+	if err != nil {
+		return Hold{}, err
+	}
+	if err := hs.driver.PutContent(ctx, p, content); err != nil {
+		return Hold{}, err
+	}
+	return hold, nil
+}
+
+// Release removes the hold identified by id and returns it.
+func (hs *HoldStore) Release(ctx context.Context, id string) (Hold, error) {
+	hold, err := hs.Get(ctx, id)
+	if err != nil {
+		return Hold{}, err
+	}
+
+	p, err := pathFor(holdPathSpec{id: id})
+	if err != nil {
+		return Hold{}, err
+	}
+	if err := hs.driver.Delete(ctx, p); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return Hold{}, ErrHoldUnknown
+		}
+		return Hold{}, err
+	}
+	return hold, nil
+}
+
+// checkDigest returns an ErrContentHeld error when dgst is held in the
+// repository named repo, or in any repository when repo is empty.
+func (hs *HoldStore) checkDigest(ctx context.Context, repo string, dgst digest.Digest) error {
+	holds, err := hs.List(ctx)
+	if err != nil {
+		return err
+	}
+	if id := heldBy(holds, repo, dgst); id != "" {
+		return distribution.ErrContentHeld{Hold: id}
+	}
+	return nil
+}
+
+// checkTag returns an ErrContentHeld error when tag is held in the
+// repository named repo.
+func (hs *HoldStore) checkTag(ctx context.Context, repo, tag string) error {
+	holds, err := hs.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, h := range holds {
+		if h.holdsTag(repo, tag) {
+			return distribution.ErrContentHeld{Hold: h.ID}
+		}
+	}
+	return nil
+}
+
+// checkManifestTags returns an ErrContentHeld error when the manifest dgst
+// is held in repository through one of its tags.
+func (hs *HoldStore) checkManifestTags(ctx context.Context, repository distribution.Repository, dgst digest.Digest) error {
+	holds, err := hs.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	repo := repository.Named().Name()
+	var tagHolds []Hold
+	for _, h := range holds {
+		if h.Tags != "" && h.Repository == repo {
+			tagHolds = append(tagHolds, h)
+		}
+	}
+	if len(tagHolds) == 0 {
+		return nil
+	}
+
+	tags, err := repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		for _, h := range tagHolds {
+			if h.holdsTag(repo, tag) {
+				return distribution.ErrContentHeld{Hold: h.ID}
+			}
+		}
+	}
+	return nil
+}
+
+// checkRepository returns an ErrContentHeld error when content of the
+// repository named repo is held by a hold restricted to it.
+func (hs *HoldStore) checkRepository(ctx context.Context, repo string) error {
+	holds, err := hs.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, h := range holds {
+		if h.Repository == repo {
+			return distribution.ErrContentHeld{Hold: h.ID}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestHoldStore(t *testing.T) {
+	ctx := context.Background()
+	hs := NewHoldStore(inmemory.New())
+
+	holds, err := hs.List(ctx)
+	if err != nil || len(holds) != 0 {
+		t.Fatalf("expected no holds, got %v, %v", holds, err)
+	}
+
+	for _, hold := range []Hold{
+		{},
+		{Digest: digest.FromString("a"), Tags: "*"},
+		{Tags: "*"},
+		{Repository: "foo/bar", Tags: "["},
+		{Repository: "Foo", Digest: digest.FromString("a")},
+		{Digest: "sha256:invalid"},
+	} {
+		if _, err := hs.Place(ctx, hold); err == nil {
+			t.Errorf("expected an error placing hold %#v", hold)
+		}
+	}
+
+	first, err := hs.Place(ctx, Hold{Digest: digest.FromString("a"), Reason: "case 1"})
+	if err != nil {
+		t.Fatalf("unexpected error placing hold: %v", err)
+	}
+	second, err := hs.Place(ctx, Hold{Repository: "foo/bar", Tags: "release-*"})
+	if err != nil {
+		t.Fatalf("unexpected error placing hold: %v", err)
+	}
+
+	holds, err = hs.List(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error listing holds: %v", err)
+	}
+	if len(holds) != 2 || holds[0].ID != first.ID || holds[1].ID != second.ID {
+		t.Fatalf("unexpected holds: %v", holds)
+	}
+
+	hold, err := hs.Get(ctx, first.ID)
+	if err != nil || hold.Reason != "case 1" {
+		t.Fatalf("unexpected hold %v, %v", hold, err)
+	}
+
+	if _, err := hs.Release(ctx, first.ID); err != nil {
+		t.Fatalf("unexpected error releasing hold: %v", err)
+	}
+	for _, id := range []string{first.ID, "unknown", "../../repositories"} {
+		if _, err := hs.Get(ctx, id); err != ErrHoldUnknown {
+			t.Errorf("expected hold %s to be unknown, got %v", id, err)
+		}
+		if _, err := hs.Release(ctx, id); err != ErrHoldUnknown {
+			t.Errorf("expected releasing hold %s to fail as unknown, got %v", id, err)
+		}
+	}
+}
+
+func held(err error) bool {
+	_, ok := err.(distribution.ErrContentHeld)
+	return ok
+}
+
+func TestHeldContentCannotBeDeleted(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	hs := NewHoldStore(inmemoryDriver)
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "foo/bar")
+	manifests := makeManifestService(t, repo)
+	tags := repo.Tags(ctx)
+
+	image1 := uploadRandomSchema2Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+	if err := tags.Tag(ctx, "release-1", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	digestHold, err := hs.Place(ctx, Hold{Digest: image2.manifestDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagHold, err := hs.Place(ctx, Hold{Repository: "foo/bar", Tags: "release-*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manifests.Delete(ctx, image2.manifestDigest); !held(err) {
+		t.Fatalf("expected deleting a held manifest to fail, got %v", err)
+	}
+	if err := manifests.Delete(ctx, image1.manifestDigest); !held(err) {
+		t.Fatalf("expected deleting a manifest with a held tag to fail, got %v", err)
+	}
+	layer := getAnyKey(image2.layers)
+	layerHold, err := hs.Place(ctx, Hold{Repository: "foo/bar", Digest: layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Blobs(ctx).Delete(ctx, layer); !held(err) {
+		t.Fatalf("expected deleting a held blob to fail, got %v", err)
+	}
+	if err := tags.Untag(ctx, "release-1"); !held(err) {
+		t.Fatalf("expected deleting a held tag to fail, got %v", err)
+	}
+	if err := tags.Tag(ctx, "release-1", distribution.Descriptor{Digest: image2.manifestDigest}); !held(err) {
+		t.Fatalf("expected moving a held tag to fail, got %v", err)
+	}
+	if err := tags.Tag(ctx, "release-1", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatalf("unexpected error retagging a held tag: %v", err)
+	}
+	if err := registry.(distribution.RepositoryRemover).Remove(ctx, repo.Named()); !held(err) {
+		t.Fatalf("expected removing a repository with held content to fail, got %v", err)
+	}
+
+	// other tags are not held
+	if err := tags.Tag(ctx, "dev", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tags.Untag(ctx, "dev"); err != nil {
+		t.Fatalf("unexpected error deleting a tag: %v", err)
+	}
+
+	for _, id := range []string{digestHold.ID, tagHold.ID, layerHold.ID} {
+		if _, err := hs.Release(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tags.Untag(ctx, "release-1"); err != nil {
+		t.Fatalf("unexpected error deleting a released tag: %v", err)
+	}
+	if err := manifests.Delete(ctx, image2.manifestDigest); err != nil {
+		t.Fatalf("unexpected error deleting a released manifest: %v", err)
+	}
+}
+
+func TestGCKeepsHeldContent(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "foo/bar")
+
+	heldImage := uploadRandomSchema2Image(t, repo)
+	image := uploadRandomSchema2Image(t, repo)
+	taggedImage := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: taggedImage.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHoldStore(inmemoryDriver).Place(ctx, Hold{Digest: heldImage.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+	if _, ok := blobs[heldImage.manifestDigest]; !ok {
+		t.Fatalf("held manifest is missing")
+	}
+	for layer := range heldImage.layers {
+		if _, ok := blobs[layer]; !ok {
+			t.Fatalf("held manifest layer is missing: %v", layer)
+		}
+	}
+	if _, ok := blobs[image.manifestDigest]; ok {
+		t.Fatalf("untagged manifest is present")
+	}
+}
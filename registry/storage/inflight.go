@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// byteLimiter bounds the number of bytes held at once by the operations
+// acquiring them, such as the contents of the manifests being read. The
+// operations are admitted in the order they acquired their bytes.
+type byteLimiter struct {
+	mu      sync.Mutex
+	size    int64
+	held    int64
+	waiters list.List
+}
+
+type byteLimiterWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// newByteLimiter returns a limiter letting up to size bytes be held at once.
+func newByteLimiter(size int64) *byteLimiter {
+	return &byteLimiter{size: size}
+}
+
+// acquire waits for n bytes to be available, or for ctx to be done, and
+// returns a function releasing them. An operation needing more bytes than
+// the limiter lets be held is admitted once it is the only one holding any.
+func (l *byteLimiter) acquire(ctx context.Context, n int64) (func(), error) {
+	if n > l.size {
+		n = l.size
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() { l.release(n) })
+	}
+
+	l.mu.Lock()
+	if l.size-l.held >= n && l.waiters.Len() == 0 {
+		l.held += n
+		l.mu.Unlock()
+		return release, nil
+	}
+
+	ready := make(chan struct{})
+	elem := l.waiters.PushBack(byteLimiterWaiter{n: n, ready: ready})
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return release, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		select {
+		case <-ready:
+			// The bytes were acquired while ctx was done.
+			l.mu.Unlock()
+			l.release(n)
+		default:
+			front := l.waiters.Front() == elem
+			l.waiters.Remove(elem)
+			// Waiters behind this one may now fit.
+			if front {
+				l.notify()
+			}
+			l.mu.Unlock()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (l *byteLimiter) release(n int64) {
+	l.mu.Lock()
+	l.held -= n
+	l.notify()
+	l.mu.Unlock()
+}
+
+// notify admits the waiters at the front of the queue whose bytes are
+// available. It must be called with l.mu held.
+func (l *byteLimiter) notify() {
+	for {
+		elem := l.waiters.Front()
+		if elem == nil {
+			return
+		}
+		w := elem.Value.(byteLimiterWaiter)
+		if l.size-l.held < w.n {
+			return
+		}
+		l.held += w.n
+		l.waiters.Remove(elem)
+		close(w.ready)
+	}
+}
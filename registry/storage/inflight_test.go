@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestByteLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := newByteLimiter(10)
+
+	release, err := l.acquire(ctx, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bytes beyond the limit wait for the bytes held to be released.
+	acquired := make(chan func())
+	go func() {
+		release, err := l.acquire(ctx, 6)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the bytes beyond the limit to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Waiting is abandoned when ctx is done.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.acquire(canceled, 1); err != context.Canceled {
+		t.Fatalf("expected the acquisition to be canceled, got %v", err)
+	}
+
+	release()
+	release()
+	(<-acquired)()
+
+	// More bytes than the limit are admitted alone.
+	release, err = l.acquire(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if l.held != 0 {
+		t.Fatalf("expected no bytes held, got %d", l.held)
+	}
+}
@@ -1,18 +1,48 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
 	"io/ioutil"
+	"sync"
 
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
 const (
 	maxBlobGetSize = 4 << 20
+
+	// maxPooledBufferSize is the capacity above which buffers are not
+	// returned to bufferPool, so that a few large manifests do not keep
+	// their memory held by the pool.
+	maxPooledBufferSize = 1 << 20
 )
 
+// bufferPool holds the buffers the contents of manifests are read into,
+// reused across reads rather than allocated and grown for each of them.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer returns an empty buffer from bufferPool.
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns buf to bufferPool. The contents of buf must no longer be
+// referenced.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
 func getContent(ctx context.Context, driver driver.StorageDriver, p string) ([]byte, error) {
 	r, err := driver.Reader(ctx, p, 0)
 	if err != nil {
@@ -23,6 +53,18 @@ func getContent(ctx context.Context, driver driver.StorageDriver, p string) ([]b
 	return readAllLimited(r, maxBlobGetSize)
 }
 
+// getContentBuffer reads the content at p into buf, like getContent.
+func getContentBuffer(ctx context.Context, driver driver.StorageDriver, p string, buf *bytes.Buffer) error {
+	r, err := driver.Reader(ctx, p, 0)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = buf.ReadFrom(limitReader(r, maxBlobGetSize))
+	return err
+}
+
 func readAllLimited(r io.Reader, limit int64) ([]byte, error) {
 	r = limitReader(r, limit)
 	return ioutil.ReadAll(r)
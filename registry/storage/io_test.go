@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func BenchmarkGetContent(b *testing.B) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	content := make([]byte, 64<<10)
+	if err := driver.PutContent(ctx, "/manifest", content); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("ReadAll", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := getContent(ctx, driver, "/manifest"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+
+	b.Run("Pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				buf := getBuffer()
+				buf.Grow(len(content) + bytes.MinRead)
+				if err := getContentBuffer(ctx, driver, "/manifest", buf); err != nil {
+					b.Fatal(err)
+				}
+				putBuffer(buf)
+			}
+		})
+	})
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"path"
@@ -42,6 +43,10 @@ type linkedBlobStore struct {
 
 	// linkDirectoryPathSpec locates the root directories in which one might find links
 	linkDirectoryPathSpec pathSpec
+
+	// checkReferences rejects deleting blobs referenced by manifests of
+	// the repository.
+	checkReferences bool
 }
 
 var _ distribution.BlobStore = &linkedBlobStore{}
@@ -83,6 +88,10 @@ func (lbs *linkedBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter
 }
 
 func (lbs *linkedBlobStore) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
+	if err := lbs.bootstrapRepository(ctx); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
 	dgst := digest.FromBytes(p)
 	// Place the data in the blob store first.
 	desc, err := lbs.blobStore.Put(ctx, mediaType, p)
@@ -137,6 +146,10 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 		}
 	}
 
+	if err := lbs.bootstrapRepository(ctx); err != nil {
+		return nil, err
+	}
+
 	if opts.Mount.ShouldMount {
 		desc, err := lbs.mount(ctx, opts.Mount.From, opts.Mount.From.Digest(), opts.Mount.Stat)
 		if err == nil {
@@ -188,12 +201,10 @@ func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution
 
 	startedAtBytes, err := lbs.blobStore.driver.GetContent(ctx, startedAtPath)
 	if err != nil {
-		switch err := err.(type) {
-		case driver.PathNotFoundError:
+		if errors.As(err, &driver.PathNotFoundError{}) {
 			return nil, distribution.ErrBlobUploadUnknown
-		default:
-			return nil, err
 		}
+		return nil, err
 	}
 
 	startedAt, err := time.Parse(time.RFC3339, string(startedAtBytes))
@@ -224,6 +235,24 @@ func (lbs *linkedBlobStore) Delete(ctx context.Context, dgst digest.Digest) erro
 		return err
 	}
 
+	if err := NewHoldStore(lbs.driver).checkDigest(ctx, lbs.repository.Named().Name(), dgst); err != nil {
+		return err
+	}
+
+	if lbs.checkReferences {
+		manifest, err := referencingManifest(ctx, lbs.blobStore, lbs.repository.Named().Name(), dgst)
+		if err != nil {
+			return err
+		}
+		if manifest != "" {
+			return distribution.ErrBlobReferenced{Digest: dgst, Manifest: manifest}
+		}
+	}
+
+	if distribution.IsDryRun(ctx) {
+		return nil
+	}
+
 	err = lbs.blobAccessController.Clear(ctx, dgst)
 	if err != nil {
 		return err
@@ -260,7 +289,7 @@ func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.
 		_, err = lbs.Stat(ctx, digest)
 		if err != nil {
 			// we expect this error to occur so we move on
-			if err == distribution.ErrBlobUnknown {
+			if errors.Is(err, distribution.ErrBlobUnknown) {
 				return nil
 			}
 			return err
@@ -321,6 +350,7 @@ func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string
 		driver:                 lbs.driver,
 		path:                   path,
 		resumableDigestEnabled: lbs.resumableDigestEnabled,
+		delegatedTo:            delegatedVerification(ctx),
 	}
 
 	return bw, nil
@@ -352,7 +382,7 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 			return err
 		}
 
-		if err := lbs.blobStore.link(ctx, blobLinkPath, canonical.Digest); err != nil {
+		if err := lbs.blobStore.linkDescriptor(ctx, blobLinkPath, canonical); err != nil {
 			return err
 		}
 	}
@@ -360,6 +390,37 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 	return nil
 }
 
+// setMediaType records the media type a manifest references the blob of
+// desc with, if the blob was linked without one, as uploads are. It is
+// written into the link of the blob when link metadata is enabled and into
+// the descriptor cache, so that the blob is then served with it.
+func (lbs *linkedBlobStore) setMediaType(ctx context.Context, desc distribution.Descriptor) error {
+	if desc.MediaType == "" || desc.MediaType == "application/octet-stream" {
+		return nil
+	}
+
+	current, err := lbs.blobAccessController.Stat(ctx, desc.Digest)
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			// The references of manifest lists are manifests, and those of
+			// foreign layers are not stored.
+			return nil
+		}
+		return err
+	}
+	if current.MediaType != "" && current.MediaType != "application/octet-stream" {
+		return nil
+	}
+	current.MediaType = desc.MediaType
+
+	if lbs.blobStore.linkMetadata {
+		if err := lbs.linkBlob(ctx, current); err != nil {
+			return err
+		}
+	}
+	return lbs.blobAccessController.SetDescriptor(ctx, current.Digest, current)
+}
+
 type linkedBlobStatter struct {
 	*blobStore
 	repository distribution.Repository
@@ -377,27 +438,26 @@ var _ distribution.BlobDescriptorService = &linkedBlobStatter{}
 
 func (lbs *linkedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
 	var (
-		found  bool
-		target digest.Digest
+		found    bool
+		target   digest.Digest
+		metadata *linkMetadata
 	)
 
 	// try the many link path functions until we get success or an error that
 	// is not PathNotFoundError.
 	for _, linkPathFn := range lbs.linkPathFns {
 		var err error
-		target, err = lbs.resolveWithLinkFunc(ctx, dgst, linkPathFn)
+		target, metadata, err = lbs.resolveWithLinkFunc(ctx, dgst, linkPathFn)
 
 		if err == nil {
 			found = true
 			break // success!
 		}
 
-		switch err := err.(type) {
-		case driver.PathNotFoundError:
-			// do nothing, just move to the next linkPathFn
-		default:
+		if !errors.As(err, &driver.PathNotFoundError{}) {
 			return distribution.Descriptor{}, err
 		}
+		// move on to the next linkPathFn
 	}
 
 	if !found {
@@ -409,6 +469,15 @@ func (lbs *linkedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (dis
 		dcontext.GetLogger(ctx).Warnf("looking up blob with canonical target: %v -> %v", dgst, target)
 	}
 
+	// Links written with metadata describe the blob as stated when linked.
+	if metadata != nil {
+		return distribution.Descriptor{
+			Size:      metadata.Size,
+			MediaType: metadata.MediaType,
+			Digest:    target,
+		}, nil
+	}
+
 	// TODO(stevvooe): Look up repository local mediatype and replace that on
 	// the returned descriptor.
 
@@ -425,12 +494,10 @@ func (lbs *linkedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) (er
 
 		err = lbs.blobStore.driver.Delete(ctx, blobLinkPath)
 		if err != nil {
-			switch err := err.(type) {
-			case driver.PathNotFoundError:
+			if errors.As(err, &driver.PathNotFoundError{}) {
 				continue // just ignore this error and continue
-			default:
-				return err
 			}
+			return err
 		}
 	}
 
@@ -440,13 +507,13 @@ func (lbs *linkedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) (er
 // resolveTargetWithFunc allows us to read a link to a resource with different
 // linkPathFuncs to let us try a few different paths before returning not
 // found.
-func (lbs *linkedBlobStatter) resolveWithLinkFunc(ctx context.Context, dgst digest.Digest, linkPathFn linkPathFunc) (digest.Digest, error) {
+func (lbs *linkedBlobStatter) resolveWithLinkFunc(ctx context.Context, dgst digest.Digest, linkPathFn linkPathFunc) (digest.Digest, *linkMetadata, error) {
 	blobLinkPath, err := linkPathFn(lbs.repository.Named().Name(), dgst)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return lbs.blobStore.readlink(ctx, blobLinkPath)
+	return lbs.blobStore.readlinkMetadata(ctx, blobLinkPath)
 }
 
 func (lbs *linkedBlobStatter) SetDescriptor(ctx context.Context, dgst digest.Digest, desc distribution.Descriptor) error {
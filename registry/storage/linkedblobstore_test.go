@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"reflect"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/opencontainers/go-digest"
 )
@@ -262,3 +264,63 @@ func (f statCrossMountCreateOption) Apply(v interface{}) error {
 
 	return nil
 }
+
+func TestLinkedBlobStoreLinkMetadata(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	name, _ := reference.WithName("nm/foo")
+
+	// Blobs linked before enabling link metadata keep being read.
+	registry, err := NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	plain, err := repository.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("plain"))
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	registry, err = NewRegistry(ctx, driver, EnableLinkMetadata)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err = registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	blobs := repository.Blobs(ctx)
+	desc, err := blobs.Put(ctx, "application/octet-stream", []byte("with metadata"))
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	linkPath, err := blobLinkPath(name.Name(), desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := driver.GetContent(ctx, linkPath)
+	if err != nil {
+		t.Fatalf("unexpected error reading link: %v", err)
+	}
+	var metadata linkMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		t.Fatalf("expected the link to hold metadata, got %q: %v", content, err)
+	}
+	if metadata.Digest != desc.Digest || metadata.Size != desc.Size || metadata.CreatedAt.IsZero() {
+		t.Errorf("unexpected link metadata: %+v", metadata)
+	}
+
+	for _, expected := range []distribution.Descriptor{plain, desc} {
+		stat, err := blobs.Stat(ctx, expected.Digest)
+		if err != nil {
+			t.Fatalf("unexpected error stating %s: %v", expected.Digest, err)
+		}
+		if !reflect.DeepEqual(stat, expected) {
+			t.Errorf("unexpected descriptor: %v != %v", stat, expected)
+		}
+	}
+}
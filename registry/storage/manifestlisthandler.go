@@ -12,9 +12,10 @@ import (
 
 // manifestListHandler is a ManifestHandler that covers schema2 manifest lists.
 type manifestListHandler struct {
-	repository distribution.Repository
-	blobStore  distribution.BlobStore
-	ctx        context.Context
+	repository        distribution.Repository
+	blobStore         distribution.BlobStore
+	ctx               context.Context
+	verifyConcurrency int
 }
 
 var _ ManifestHandler = &manifestListHandler{}
@@ -56,6 +57,16 @@ func (ms *manifestListHandler) Put(ctx context.Context, manifestList distributio
 	return revision.Digest, nil
 }
 
+// verify verifies the manifest as Put does, without storing it.
+func (ms *manifestListHandler) verify(ctx context.Context, manifest distribution.Manifest, skipDependencyVerification bool) error {
+	m, ok := manifest.(*manifestlist.DeserializedManifestList)
+	if !ok {
+		return fmt.Errorf("wrong type verified by manifestListHandler: %T", manifest)
+	}
+
+	return ms.verifyManifest(ms.ctx, *m, skipDependencyVerification)
+}
+
 // verifyManifest ensures that the manifest content is valid from the
 // perspective of the registry. As a policy, the registry only tries to
 // store valid content, leaving trust policies of that content up to
@@ -77,7 +88,8 @@ func (ms *manifestListHandler) verifyManifest(ctx context.Context, mnfst manifes
 			return err
 		}
 
-		for _, manifestDescriptor := range mnfst.References() {
+		errs = verifyDescriptors(mnfst.References(), ms.verifyConcurrency, func(manifestDescriptor distribution.Descriptor) []error {
+			var errs []error
 			exists, err := manifestService.Exists(ctx, manifestDescriptor.Digest)
 			if err != nil && err != distribution.ErrBlobUnknown {
 				errs = append(errs, err)
@@ -86,7 +98,8 @@ func (ms *manifestListHandler) verifyManifest(ctx context.Context, mnfst manifes
 				// On error here, we always append unknown blob errors.
 				errs = append(errs, distribution.ErrManifestBlobUnknown{Digest: manifestDescriptor.Digest})
 			}
-		}
+			return errs
+		})
 	}
 	if len(errs) != 0 {
 		return errs
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/distribution/distribution/v3"
@@ -18,7 +20,8 @@ import (
 
 // A ManifestHandler gets and puts manifests of a particular type.
 type ManifestHandler interface {
-	// Unmarshal unmarshals the manifest from a byte slice.
+	// Unmarshal unmarshals the manifest from a byte slice. The slice is
+	// reused once Unmarshal returns, so the manifest must not retain it.
 	Unmarshal(ctx context.Context, dgst digest.Digest, content []byte) (distribution.Manifest, error)
 
 	// Put creates or updates the given manifest returning the manifest digest.
@@ -48,6 +51,10 @@ type manifestStore struct {
 
 	skipDependencyVerification bool
 
+	// bytesInFlight, if set, bounds the bytes of the manifests being read
+	// at once.
+	bytesInFlight *byteLimiter
+
 	schema1Handler      ManifestHandler
 	schema2Handler      ManifestHandler
 	ocischemaHandler    ManifestHandler
@@ -63,7 +70,7 @@ func (ms *manifestStore) Exists(ctx context.Context, dgst digest.Digest) (bool,
 
 	_, err := ms.blobStore.Stat(ms.ctx, dgst)
 	if err != nil {
-		if err == distribution.ErrBlobUnknown {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
 			return false, nil
 		}
 
@@ -79,9 +86,25 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 	// TODO(stevvooe): Need to check descriptor from above to ensure that the
 	// mediatype is as we expect for the manifest store.
 
-	content, err := ms.blobStore.Get(ctx, dgst)
+	desc, err := ms.blobStore.Stat(ctx, dgst) // access check
+	if err == nil && ms.bytesInFlight != nil {
+		var release func()
+		release, err = ms.bytesInFlight.acquire(ctx, desc.Size)
+		if err == nil {
+			defer release()
+		}
+	}
+
+	// The content is read into a pooled buffer, as the handlers copy the
+	// parts of the content their manifests retain.
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err == nil {
+		buf.Grow(int(desc.Size) + bytes.MinRead)
+		err = ms.blobStore.blobStore.getInto(ctx, desc.Digest, buf)
+	}
 	if err != nil {
-		if err == distribution.ErrBlobUnknown {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
 			return nil, distribution.ErrManifestUnknownRevision{
 				Name:     ms.repository.Named().Name(),
 				Revision: dgst,
@@ -90,6 +113,7 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 
 		return nil, err
 	}
+	content := buf.Bytes()
 
 	// Fallback to extension handlers if necessary.
 	fallback := func() (bool, distribution.Manifest, error) {
@@ -148,6 +172,100 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Put")
 
+	// Manifests pushed again, as CI pipelines often do, are not stored again,
+	// which callers are told of through WithManifestUnchanged. They are
+	// verified again, since the content they reference may have been deleted
+	// since, and their metadata is recorded again, as it may be missing.
+	var dgst digest.Digest
+	if d, err := revisionDigest(manifest); err == nil {
+		verifier, ok := ms.handler(manifest).(manifestVerifier)
+		if exists, err := ms.Exists(ctx, d); ok && err == nil && exists {
+			if err := verifier.verify(ctx, manifest, ms.skipDependencyVerification); err != nil {
+				return "", err
+			}
+			dcontext.GetLogger(ms.ctx).Debugf("manifest %s is already stored", d)
+			distribution.SetManifestUnchanged(ctx)
+			dgst = d
+		}
+	}
+
+	if dgst == "" {
+		var err error
+		dgst, err = ms.put(ctx, manifest)
+		if err != nil {
+			return dgst, err
+		}
+	}
+
+	if err := linkReferences(ctx, ms.blobStore.blobStore, ms.repository.Named().Name(), dgst, manifest); err != nil {
+		return "", err
+	}
+
+	// The media types of the blobs are only known from the manifests
+	// referencing them. Failing to record them leaves the blobs served as
+	// application/octet-stream, which does not fail the push. Each takes a
+	// stat, so that they are recorded a few at a time, once per blob.
+	blobs := ms.repository.Blobs(ctx).(*linkedBlobStore)
+	var references []distribution.Descriptor
+	seen := make(map[digest.Digest]struct{})
+	for _, desc := range manifest.References() {
+		if desc.MediaType == "" || desc.MediaType == "application/octet-stream" {
+			continue
+		}
+		if _, ok := seen[desc.Digest]; ok {
+			continue
+		}
+		seen[desc.Digest] = struct{}{}
+		references = append(references, desc)
+	}
+	parallel(len(references), batchStatConcurrency, func(i int) {
+		if err := blobs.setMediaType(ctx, references[i]); err != nil {
+			dcontext.GetLogger(ms.ctx).Warnf("error recording the media type of blob %s: %v", references[i].Digest, err)
+		}
+	})
+
+	// Neither does failing to record the platforms of the manifest, which
+	// are then read from the manifest and its config when listed.
+	if ms.blobStore.blobStore.linkMetadata {
+		if err := ms.recordPlatforms(ctx, dgst, manifest); err != nil {
+			dcontext.GetLogger(ms.ctx).Warnf("error recording the platforms of manifest %s: %v", dgst, err)
+		}
+	}
+	ms.putProvenance(ctx, dgst, options)
+	return dgst, nil
+}
+
+// putProvenance records the provenance of a push of the manifest dgst. Failing
+// to, as the other metadata of pushes, does not fail the push.
+func (ms *manifestStore) putProvenance(ctx context.Context, dgst digest.Digest, options []distribution.ManifestServiceOption) {
+	if err := ms.recordProvenance(ctx, dgst, options); err != nil {
+		dcontext.GetLogger(ms.ctx).Warnf("error recording the provenance of manifest %s: %v", dgst, err)
+	}
+}
+
+// manifestVerifier is implemented by the manifest handlers which can verify
+// a manifest without storing it.
+type manifestVerifier interface {
+	verify(ctx context.Context, manifest distribution.Manifest, skipDependencyVerification bool) error
+}
+
+// handler returns the handler of the manifest among the handlers of the
+// manifest types built in, or nil.
+func (ms *manifestStore) handler(manifest distribution.Manifest) ManifestHandler {
+	switch manifest.(type) {
+	case *schema1.SignedManifest:
+		return ms.schema1Handler
+	case *schema2.DeserializedManifest:
+		return ms.schema2Handler
+	case *ocischema.DeserializedManifest:
+		return ms.ocischemaHandler
+	case *manifestlist.DeserializedManifestList:
+		return ms.manifestListHandler
+	}
+	return nil
+}
+
+func (ms *manifestStore) put(ctx context.Context, manifest distribution.Manifest) (digest.Digest, error) {
 	switch manifest.(type) {
 	case *schema1.SignedManifest:
 		return ms.schema1Handler.Put(ctx, manifest, ms.skipDependencyVerification)
@@ -171,7 +289,29 @@ func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest
 // Delete removes the revision of the specified manifest.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
-	return ms.blobStore.Delete(ctx, dgst)
+	// Holds on the digest itself are checked by the blob store.
+	if ms.blobStore.deleteEnabled {
+		if err := NewHoldStore(ms.blobStore.driver).checkManifestTags(ctx, ms.repository, dgst); err != nil {
+			return err
+		}
+	}
+
+	// The manifest is read to remove its layer references once deleted.
+	manifest, err := ms.Get(ctx, dgst)
+	if err != nil {
+		manifest = nil
+	}
+
+	if err := ms.blobStore.Delete(ctx, dgst); err != nil {
+		return err
+	}
+
+	if manifest != nil && !distribution.IsDryRun(ctx) {
+		if err := unlinkReferences(ctx, ms.blobStore.blobStore, ms.repository.Named().Name(), dgst, manifest); err != nil {
+			dcontext.GetLogger(ctx).Warnf("error removing layer references of %s: %v", dgst, err)
+		}
+	}
+	return nil
 }
 
 func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Digest) error) error {
@@ -184,3 +324,17 @@ func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Dig
 	})
 	return err
 }
+
+// revisionDigest returns the digest of the revision a manifest is stored as,
+// which is the digest of its payload except for schema1 manifests, stored
+// without their signatures.
+func revisionDigest(manifest distribution.Manifest) (digest.Digest, error) {
+	if sm, ok := manifest.(*schema1.SignedManifest); ok {
+		return digest.FromBytes(sm.Canonical), nil
+	}
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return "", err
+	}
+	return digest.FromBytes(payload), nil
+}
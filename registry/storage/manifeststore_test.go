@@ -3,6 +3,7 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"reflect"
 	"testing"
@@ -575,3 +576,160 @@ func TestLinkPathFuncs(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkManifestStoreGet(b *testing.B) {
+	ctx := context.Background()
+	registry, err := NewRegistry(ctx, inmemory.New(), ManifestBytesInFlight(1<<20))
+	if err != nil {
+		b.Fatalf("error creating registry: %v", err)
+	}
+	name, _ := reference.WithName("foo/bar")
+	repository, err := registry.Repository(ctx, name)
+	if err != nil {
+		b.Fatalf("unexpected error getting repo: %v", err)
+	}
+	ms, err := repository.Manifests(ctx, SkipLayerVerification())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	m := ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: distribution.Descriptor{
+			Digest:    digest.FromString("config"),
+			Size:      6,
+			MediaType: v1.MediaTypeImageConfig,
+		},
+	}
+	for i := 0; i < 256; i++ {
+		m.Layers = append(m.Layers, distribution.Descriptor{
+			Digest:    digest.FromBytes([]byte{byte(i)}),
+			Size:      int64(i + 1),
+			MediaType: v1.MediaTypeImageLayerGzip,
+		})
+	}
+	dm, err := ocischema.FromStruct(m)
+	if err != nil {
+		b.Fatal(err)
+	}
+	dgst, err := ms.Put(ctx, dm)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := ms.Get(ctx, dgst); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestManifestPutRecordsMediaTypes ensures that the media types manifests
+// reference blobs with are recorded, when the blobs were uploaded without
+// one, if link metadata or the descriptor cache are enabled.
+func TestManifestPutRecordsMediaTypes(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		options   []RegistryOption
+		mediaType string
+	}{
+		{"none", nil, "application/octet-stream"},
+		{"link metadata", []RegistryOption{EnableLinkMetadata}, v1.MediaTypeImageLayerGzip},
+		{"cache", []RegistryOption{BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider())}, v1.MediaTypeImageLayerGzip},
+	} {
+		repoName, _ := reference.WithName("foo/bar")
+		env := newManifestStoreTestEnv(t, repoName, "thetag", tc.options...)
+
+		ms, err := env.repository.Manifests(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rs, dgst, err := testutil.CreateRandomTarFile()
+		if err != nil {
+			t.Fatalf("%s: unexpected error generating test layer file: %v", tc.name, err)
+		}
+		wr, err := env.repository.Blobs(env.ctx).Create(env.ctx)
+		if err != nil {
+			t.Fatalf("%s: unexpected error creating test upload: %v", tc.name, err)
+		}
+		if _, err := io.Copy(wr, rs); err != nil {
+			t.Fatalf("%s: unexpected error copying to upload: %v", tc.name, err)
+		}
+		layer, err := wr.Commit(env.ctx, distribution.Descriptor{Digest: dgst})
+		if err != nil {
+			t.Fatalf("%s: unexpected error finishing upload: %v", tc.name, err)
+		}
+
+		builder := ocischema.NewManifestBuilder(env.repository.Blobs(env.ctx), []byte("{}"), map[string]string{})
+		layer.MediaType = v1.MediaTypeImageLayerGzip
+		if err := builder.AppendReference(layer); err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := builder.Build(env.ctx)
+		if err != nil {
+			t.Fatalf("%s: unexpected error generating manifest: %v", tc.name, err)
+		}
+		if _, err := ms.Put(env.ctx, manifest); err != nil {
+			t.Fatalf("%s: unexpected error putting manifest: %v", tc.name, err)
+		}
+
+		desc, err := env.repository.Blobs(env.ctx).Stat(env.ctx, dgst)
+		if err != nil {
+			t.Fatalf("%s: unexpected error stating layer: %v", tc.name, err)
+		}
+		if desc.MediaType != tc.mediaType {
+			t.Errorf("%s: unexpected media type: %q != %q", tc.name, desc.MediaType, tc.mediaType)
+		}
+
+		// Pushing the manifest again records the media types again, as
+		// when they were lost or the manifest was stored before the
+		// metadata was recorded.
+		blobs := env.repository.Blobs(env.ctx).(*linkedBlobStore)
+		if err := blobs.linkBlob(env.ctx, distribution.Descriptor{Digest: dgst, Size: layer.Size, MediaType: "application/octet-stream"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ms.Put(env.ctx, manifest); err != nil {
+			t.Fatalf("%s: unexpected error putting manifest again: %v", tc.name, err)
+		}
+		desc, err = env.repository.Blobs(env.ctx).Stat(env.ctx, dgst)
+		if err != nil {
+			t.Fatalf("%s: unexpected error stating layer: %v", tc.name, err)
+		}
+		if desc.MediaType != tc.mediaType {
+			t.Errorf("%s: unexpected media type after pushing again: %q != %q", tc.name, desc.MediaType, tc.mediaType)
+		}
+	}
+}
+
+func TestManifestRePushVerifiesReferences(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "foo/bar")
+	ms, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := uploadRandomSchema2Image(t, repo)
+	var unchanged bool
+	if dgst, err := ms.Put(distribution.WithManifestUnchanged(ctx, &unchanged), image.manifest); err != nil || dgst != image.manifestDigest {
+		t.Fatalf("unexpected result pushing the manifest again: %s, %v", dgst, err)
+	}
+	if !unchanged {
+		t.Fatal("expected pushing the manifest again to be reported unchanged")
+	}
+
+	if err := repo.Blobs(ctx).Delete(ctx, getAnyKey(image.layers)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.Put(ctx, image.manifest); !errors.As(err, &distribution.ErrManifestVerification{}) {
+		t.Fatalf("expected pushing the manifest again without its layer to fail verification, got %v", err)
+	}
+}
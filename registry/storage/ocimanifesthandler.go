@@ -16,11 +16,12 @@ import (
 
 // ocischemaManifestHandler is a ManifestHandler that covers ocischema manifests.
 type ocischemaManifestHandler struct {
-	repository    distribution.Repository
-	blobStore     distribution.BlobStore
-	ctx           context.Context
-	manifestURLs  manifestURLs
-	storageDriver driver.StorageDriver
+	repository        distribution.Repository
+	blobStore         distribution.BlobStore
+	ctx               context.Context
+	manifestURLs      manifestURLs
+	storageDriver     driver.StorageDriver
+	verifyConcurrency int
 }
 
 var _ ManifestHandler = &ocischemaManifestHandler{}
@@ -61,7 +62,7 @@ func (ms *ocischemaManifestHandler) Put(ctx context.Context, manifest distributi
 
 	if m.Subject != nil {
 		// add link file here if Reference field isn't empty
-		err = ms.indexReferrers(ctx, m, revision.Digest)
+		err = ms.indexReferrers(ctx, m, mt, revision)
 		if err != nil {
 			dcontext.GetLogger(ctx).Errorf("error indexing referrers: %v", err)
 			return "", err
@@ -71,6 +72,16 @@ func (ms *ocischemaManifestHandler) Put(ctx context.Context, manifest distributi
 	return revision.Digest, nil
 }
 
+// verify verifies the manifest as Put does, without storing it.
+func (ms *ocischemaManifestHandler) verify(ctx context.Context, manifest distribution.Manifest, skipDependencyVerification bool) error {
+	m, ok := manifest.(*ocischema.DeserializedManifest)
+	if !ok {
+		return fmt.Errorf("non-ocischema manifest verified by ocischemaManifestHandler: %T", manifest)
+	}
+
+	return ms.verifyManifest(ms.ctx, *m, skipDependencyVerification)
+}
+
 // verifyManifest ensures that the manifest content is valid from the
 // perspective of the registry. As a policy, the registry only tries to store
 // valid content, leaving trust policies of that content up to consumers.
@@ -92,11 +103,20 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 
 	blobsService := ms.repository.Blobs(ctx)
 
+	// Stat the blobs in a batch rather than one round trip at a time.
+	var dgsts []digest.Digest
 	for _, descriptor := range mnfst.References() {
+		nonDistributable := descriptor.MediaType == v1.MediaTypeImageLayerNonDistributable || descriptor.MediaType == v1.MediaTypeImageLayerNonDistributableGzip
+		if (!nonDistributable || len(descriptor.URLs) == 0) && descriptor.Digest.Validate() == nil {
+			dgsts = append(dgsts, descriptor.Digest)
+		}
+	}
+	stat := batchStat(ctx, blobsService, dgsts)
+
+	errs = verifyDescriptors(mnfst.References(), ms.verifyConcurrency, func(descriptor distribution.Descriptor) []error {
 		err := descriptor.Digest.Validate()
 		if err != nil {
-			errs = append(errs, err, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
-			continue
+			return []error{err, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest}}
 		}
 
 		switch descriptor.MediaType {
@@ -117,7 +137,7 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 				if len(descriptor.URLs) == 0 ||
 					(descriptor.MediaType == v1.MediaTypeImageLayer || descriptor.MediaType == v1.MediaTypeImageLayerGzip) {
 
-					_, err = blobsService.Stat(ctx, descriptor.Digest)
+					_, err = stat(ctx, descriptor.Digest)
 				}
 			}
 
@@ -134,18 +154,20 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 			fallthrough // double check the blob store.
 		default:
 			// check the presence
-			_, err = blobsService.Stat(ctx, descriptor.Digest)
+			_, err = stat(ctx, descriptor.Digest)
 		}
 
 		if err != nil {
+			var errs []error
 			if err != distribution.ErrBlobUnknown {
 				errs = append(errs, err)
 			}
 
 			// On error here, we always append unknown blob errors.
-			errs = append(errs, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
+			return append(errs, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
 		}
-	}
+		return nil
+	})
 
 	if len(errs) != 0 {
 		return errs
@@ -155,16 +177,14 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 }
 
 // indexReferrers indexes the subject of the given revision in its referrers index store.
-func (ms *ocischemaManifestHandler) indexReferrers(ctx context.Context, dm *ocischema.DeserializedManifest, revision digest.Digest) error {
-	subjectRevision := dm.Subject.Digest
-
-	rootPath := path.Join(referrersLinkPath(ms.repository.Named().Name()), subjectRevision.Algorithm().String(), subjectRevision.Hex())
-	referenceLinkPath := path.Join(rootPath, revision.Algorithm().String(), revision.Hex(), "link")
-	if err := ms.storageDriver.PutContent(ctx, referenceLinkPath, []byte(revision.String())); err != nil {
-		return err
-	}
-
-	return nil
+func (ms *ocischemaManifestHandler) indexReferrers(ctx context.Context, dm *ocischema.DeserializedManifest, mediaType string, revision distribution.Descriptor) error {
+	return IndexReferrer(ctx, ms.storageDriver, ms.repository.Named().Name(), dm.Subject.Digest, v1.Descriptor{
+		MediaType:    mediaType,
+		Digest:       revision.Digest,
+		Size:         revision.Size,
+		ArtifactType: dm.Config.MediaType,
+		Annotations:  dm.Annotations,
+	})
 }
 
 func referrersLinkPath(name string) string {
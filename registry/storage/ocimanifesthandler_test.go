@@ -332,3 +332,62 @@ func TestVerifyOCIManifestBlobLayerAndConfig(t *testing.T) {
 		checkFn(m, c.Err)
 	}
 }
+
+func TestOCIManifestArtifacts(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, strings.ToLower(t.Name()))
+	manifestService := makeManifestService(t, repo)
+
+	put := func(mediaType, content string) distribution.Descriptor {
+		desc, err := repo.Blobs(ctx).Put(ctx, mediaType, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc.MediaType = mediaType
+		return desc
+	}
+	chartConfig := put(ocischema.MediaTypeHelmConfig, `{"name": "app", "version": "1.0.0", "apiVersion": "v2"}`)
+	chart := put(ocischema.MediaTypeHelmChartContent, "chart")
+	provenance := put(ocischema.MediaTypeHelmChartProvenance, "provenance")
+	wasmConfig := put(ocischema.MediaTypeWasmConfig, `{}`)
+	module := put(ocischema.MediaTypeWasmContentLayer, "module")
+
+	for _, tc := range []struct {
+		config distribution.Descriptor
+		layers []distribution.Descriptor
+	}{
+		{config: chartConfig, layers: []distribution.Descriptor{chart}},
+		{config: chartConfig, layers: []distribution.Descriptor{chart, provenance}},
+		{config: wasmConfig, layers: []distribution.Descriptor{module}},
+	} {
+		dm, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: manifest.Versioned{
+				SchemaVersion: 2,
+				MediaType:     v1.MediaTypeImageManifest,
+			},
+			Config: tc.config,
+			Layers: tc.layers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dgst, err := manifestService.Put(ctx, dm)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tc.layers, err)
+			continue
+		}
+
+		// The manifest round-trips with the media types of its config
+		// and layers.
+		stored, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := stored.(*ocischema.DeserializedManifest)
+		if m.Config.MediaType != tc.config.MediaType || len(m.Layers) != len(tc.layers) || m.Layers[0].MediaType != tc.layers[0].MediaType {
+			t.Errorf("unexpected manifest stored: %v", m.Manifest)
+		}
+	}
+}
@@ -36,12 +36,17 @@ const (
 //								-> <algorithm>/<hex digest>/link
 // 					-> _layers/
 // 						<layer links to blob store>
+// 					-> _refs/layers/<algorithm>/<hex digest>
+// 						<links to the manifests referencing the layer>
+// 					-> _ops/tags/<tag>
+// 						<latest operation on the tag>
 // 					-> _uploads/<id>
 // 						data
 // 						startedat
 // 						hashstates/<algorithm>/<offset>
 //			-> blob/<algorithm>
 //				<split directory content addressable storage>
+//			-> holds/<id>
 //
 // The storage backend layout is broken up into a content-addressable blob
 // store and repositories. The content-addressable blob store holds most data
@@ -89,8 +94,32 @@ const (
 // 	layerLinkPathSpec:            <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/link
 // 	layersPathSpec:               <root>/v2/repositories/<name>/_layers
 //
+//	Layer References:
+//
+//	layerReferencesRootPathSpec:    <root>/v2/repositories/<name>/_refs/layers/
+//	layerReferencesPathSpec:        <root>/v2/repositories/<name>/_refs/layers/<algorithm>/<hex digest>/
+//	layerReferenceLinkPathSpec:     <root>/v2/repositories/<name>/_refs/layers/<algorithm>/<hex digest>/<manifest algorithm>/<manifest hex digest>/link
+//
+//	Tag Operations:
+//
+//	tagOperationsPathSpec:          <root>/v2/repositories/<name>/_ops/tags/
+//	tagOperationPathSpec:           <root>/v2/repositories/<name>/_ops/tags/<tag>
+//
+//	Tag History:
+//
+//	tagHistoryPathSpec:             <root>/v2/repositories/<name>/_history/tags/<tag>/
+//
+//	Provenance:
+//
+//	manifestProvenancePathSpec:     <root>/v2/repositories/<name>/_provenance/manifests/<algorithm>/<hex digest>/
+//
+//	Bootstrap:
+//
+//	repositoryBootstrapPathSpec:    <root>/v2/repositories/<name>/_bootstrap/template
+//
 //	Uploads:
 //
+//	uploadsPathSpec:                <root>/v2/repositories/<name>/_uploads/
 // 	uploadDataPathSpec:             <root>/v2/repositories/<name>/_uploads/<id>/data
 // 	uploadStartedAtPathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/startedat
 // 	uploadHashStatePathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/hashstates/<algorithm>/<offset>
@@ -101,6 +130,27 @@ const (
 // 	blobPathSpec:                   <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>
 // 	blobDataPathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
 // 	blobMediaTypePathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
+//	blobDelegationPathSpec:         <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/delegation
+//
+//	Legal Holds:
+//
+//	holdsPathSpec:                  <root>/v2/holds/
+//	holdPathSpec:                   <root>/v2/holds/<id>
+//
+//	Catalog Snapshots:
+//
+//	catalogSnapshotsPathSpec:       <root>/v2/catalog/snapshots/
+//	catalogSnapshotPathSpec:        <root>/v2/catalog/snapshots/<generation>
+//
+//	Digest Aliases:
+//
+//	digestAliasesPathSpec:          <root>/v2/aliases/
+//	digestAliasPathSpec:            <root>/v2/aliases/<algorithm>/<hex digest>
+//
+//	Batch Journals:
+//
+//	journalsPathSpec:               <root>/v2/journal/
+//	journalPathSpec:                <root>/v2/journal/<id>
 //
 // For more information on the semantic meaning of each path and their
 // contents, please see the path spec documentation.
@@ -209,6 +259,42 @@ func pathFor(spec pathSpec) (string, error) {
 		return path.Join(path.Join(append(blobLinkPathComponents, components...)...), "link"), nil
 	case layersPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_layers")...), nil
+	case layerReferencesRootPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_refs", "layers")...), nil
+	case layerReferencesPathSpec:
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_refs", "layers"), components...)...), nil
+	case layerReferenceLinkPathSpec:
+		root, err := pathFor(layerReferencesPathSpec{name: v.name, digest: v.digest})
+		if err != nil {
+			return "", err
+		}
+
+		components, err := digestPathComponents(v.manifest, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, path.Join(components...), "link"), nil
+	case tagOperationsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_ops", "tags")...), nil
+	case tagOperationPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_ops", "tags", v.tag)...), nil
+	case tagHistoryPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_history", "tags", v.tag)...), nil
+	case manifestProvenancePathSpec:
+		components, err := digestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_provenance", "manifests"), components...)...), nil
+	case repositoryBootstrapPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_bootstrap", "template")...), nil
 	case blobsPathSpec:
 		blobsPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(blobsPathPrefix...), nil
@@ -229,7 +315,18 @@ func pathFor(spec pathSpec) (string, error) {
 		components = append(components, "data")
 		blobPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobDelegationPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "delegation")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
 
+	case uploadsPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_uploads")...), nil
 	case uploadDataPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "data")...), nil
 	case uploadStartedAtPathSpec:
@@ -242,6 +339,26 @@ func pathFor(spec pathSpec) (string, error) {
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset)...), nil
 	case repositoriesRootPathSpec:
 		return path.Join(repoPrefix...), nil
+	case holdsPathSpec:
+		return path.Join(append(rootPrefix, "holds")...), nil
+	case holdPathSpec:
+		return path.Join(append(rootPrefix, "holds", v.id)...), nil
+	case catalogSnapshotsPathSpec:
+		return path.Join(append(rootPrefix, "catalog", "snapshots")...), nil
+	case catalogSnapshotPathSpec:
+		return path.Join(append(rootPrefix, "catalog", "snapshots", v.generation)...), nil
+	case digestAliasesPathSpec:
+		return path.Join(append(rootPrefix, "aliases")...), nil
+	case digestAliasPathSpec:
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(append(append(rootPrefix, "aliases"), components...)...), nil
+	case journalsPathSpec:
+		return path.Join(append(rootPrefix, "journal")...), nil
+	case journalPathSpec:
+		return path.Join(append(rootPrefix, "journal", v.id)...), nil
 	default:
 		// TODO(sday): This is an internal error. Ensure it doesn't escape (panic?).
 		return "", fmt.Errorf("unknown path spec: %#v", v)
@@ -364,6 +481,76 @@ type layerLinkPathSpec struct {
 
 func (layerLinkPathSpec) pathSpec() {}
 
+// layerReferencesRootPathSpec contains the path for the layer references of a
+// repository.
+type layerReferencesRootPathSpec struct {
+	name string
+}
+
+func (layerReferencesRootPathSpec) pathSpec() {}
+
+// layerReferencesPathSpec contains the path for the links to the manifests of
+// a repository referencing a layer.
+type layerReferencesPathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+func (layerReferencesPathSpec) pathSpec() {}
+
+// layerReferenceLinkPathSpec specifies the path of a link to a manifest
+// referencing a layer. Its contents are the digest of the manifest.
+type layerReferenceLinkPathSpec struct {
+	name     string
+	digest   digest.Digest
+	manifest digest.Digest
+}
+
+func (layerReferenceLinkPathSpec) pathSpec() {}
+
+// tagOperationsPathSpec contains the path for the latest operations on the
+// tags of a repository.
+type tagOperationsPathSpec struct {
+	name string
+}
+
+func (tagOperationsPathSpec) pathSpec() {}
+
+// tagOperationPathSpec specifies the path of the latest operation on a tag.
+type tagOperationPathSpec struct {
+	name string
+	tag  string
+}
+
+func (tagOperationPathSpec) pathSpec() {}
+
+// tagHistoryPathSpec specifies the directory of the history of a tag, which
+// holds an entry per write to the tag named by its time.
+type tagHistoryPathSpec struct {
+	name string
+	tag  string
+}
+
+func (tagHistoryPathSpec) pathSpec() {}
+
+// manifestProvenancePathSpec specifies the directory of the provenance of a
+// manifest revision, which holds an entry per push of the manifest named by
+// its time.
+type manifestProvenancePathSpec struct {
+	name     string
+	revision digest.Digest
+}
+
+func (manifestProvenancePathSpec) pathSpec() {}
+
+// repositoryBootstrapPathSpec specifies the path of the record of the
+// template a repository was bootstrapped with.
+type repositoryBootstrapPathSpec struct {
+	name string
+}
+
+func (repositoryBootstrapPathSpec) pathSpec() {}
+
 // blobAlgorithmReplacer does some very simple path sanitization for user
 // input. Paths should be "safe" before getting this far due to strict digest
 // requirements but we can add further path conversion here, if needed.
@@ -393,6 +580,22 @@ type blobDataPathSpec struct {
 
 func (blobDataPathSpec) pathSpec() {}
 
+// blobDelegationPathSpec contains the path of the record of the delegation of
+// the verification of a blob to the source replicating it.
+type blobDelegationPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobDelegationPathSpec) pathSpec() {}
+
+// uploadsPathSpec defines the path of the directory of the uploads of a
+// repository.
+type uploadsPathSpec struct {
+	name string
+}
+
+func (uploadsPathSpec) pathSpec() {}
+
 // uploadDataPathSpec defines the path parameters of the data file for
 // uploads.
 type uploadDataPathSpec struct {
@@ -436,6 +639,59 @@ type repositoriesRootPathSpec struct {
 
 func (repositoriesRootPathSpec) pathSpec() {}
 
+// holdsPathSpec contains the path for the directory of legal holds.
+type holdsPathSpec struct{}
+
+func (holdsPathSpec) pathSpec() {}
+
+// holdPathSpec contains the path for the record of a legal hold.
+type holdPathSpec struct {
+	id string
+}
+
+func (holdPathSpec) pathSpec() {}
+
+// catalogSnapshotsPathSpec contains the path for the directory of catalog
+// snapshots.
+type catalogSnapshotsPathSpec struct{}
+
+func (catalogSnapshotsPathSpec) pathSpec() {}
+
+// catalogSnapshotPathSpec contains the path for a snapshot of the catalog.
+type catalogSnapshotPathSpec struct {
+	generation string
+}
+
+func (catalogSnapshotPathSpec) pathSpec() {}
+
+// digestAliasesPathSpec contains the path for the directory of digest
+// aliases.
+type digestAliasesPathSpec struct{}
+
+func (digestAliasesPathSpec) pathSpec() {}
+
+// digestAliasPathSpec contains the path for the alias of a digest, holding
+// the digest the content was re-addressed to.
+type digestAliasPathSpec struct {
+	digest digest.Digest
+}
+
+func (digestAliasPathSpec) pathSpec() {}
+
+// journalsPathSpec contains the path for the directory of the journals of
+// the batches of metadata writes being applied.
+type journalsPathSpec struct{}
+
+func (journalsPathSpec) pathSpec() {}
+
+// journalPathSpec contains the path for the journal of a batch of metadata
+// writes, which exists while the batch is applied.
+type journalPathSpec struct {
+	id string
+}
+
+func (journalPathSpec) pathSpec() {}
+
 // digestPathComponents provides a consistent path breakdown for a given
 // digest. For a generic digest, it will be as follows:
 //
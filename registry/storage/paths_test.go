@@ -69,6 +69,21 @@ func TestPathMapper(t *testing.T) {
 			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tags/thetag/index/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/link",
 		},
 
+		{
+			spec: manifestProvenancePathSpec{
+				name:     "foo/bar",
+				revision: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_provenance/manifests/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+		},
+		{
+			spec:     repositoryBootstrapPathSpec{name: "foo/bar"},
+			expected: "/docker/registry/v2/repositories/foo/bar/_bootstrap/template",
+		},
+		{
+			spec:     uploadsPathSpec{name: "foo/bar"},
+			expected: "/docker/registry/v2/repositories/foo/bar/_uploads",
+		},
 		{
 			spec: uploadDataPathSpec{
 				name: "foo/bar",
@@ -87,6 +102,21 @@ func TestPathMapper(t *testing.T) {
 			spec:     layersPathSpec{name: "foo/bar"},
 			expected: "/docker/registry/v2/repositories/foo/bar/_layers",
 		},
+		{
+			spec: layerReferenceLinkPathSpec{
+				name:     "foo/bar",
+				digest:   "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				manifest: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_refs/layers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/sha256/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef/link",
+		},
+		{
+			spec: tagOperationPathSpec{
+				name: "foo/bar",
+				tag:  "thetag",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_ops/tags/thetag",
+		},
 	} {
 		p, err := pathFor(testcase.spec)
 		if err != nil {
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxPlatformConfigSize bounds the size of the image configs read for their
+// platform.
+const maxPlatformConfigSize = 4 << 20
+
+// ManifestPlatforms is implemented by the manifest services of repositories
+// to list the platforms the images of their manifests run on.
+type ManifestPlatforms interface {
+	// Platforms returns the platforms of the manifest of dgst: the
+	// platform of its image config for an image manifest, or the platforms
+	// of the images an index lists. Manifests of other artifacts have none.
+	Platforms(ctx context.Context, dgst digest.Digest) ([]v1.Platform, error)
+}
+
+var _ ManifestPlatforms = &manifestStore{}
+
+// Platforms implements ManifestPlatforms. The platforms recorded into the
+// link of the revision are returned when link metadata is enabled; those of
+// manifests linked without them are read from the manifest and its config.
+func (ms *manifestStore) Platforms(ctx context.Context, dgst digest.Digest) ([]v1.Platform, error) {
+	path, err := manifestRevisionLinkPath(ms.repository.Named().Name(), dgst)
+	if err != nil {
+		return nil, err
+	}
+	if _, metadata, err := ms.blobStore.blobStore.readlinkMetadata(ctx, path); err == nil && metadata != nil && metadata.Platforms != nil {
+		return metadata.Platforms, nil
+	}
+
+	manifest, err := ms.Get(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	return ms.manifestPlatforms(ctx, manifest)
+}
+
+// recordPlatforms records the platforms of manifest into the link of its
+// revision dgst, written with metadata, so that listing them does not read
+// the manifest and its config.
+func (ms *manifestStore) recordPlatforms(ctx context.Context, dgst digest.Digest, manifest distribution.Manifest) error {
+	platforms, err := ms.manifestPlatforms(ctx, manifest)
+	if err != nil || len(platforms) == 0 {
+		return err
+	}
+
+	path, err := manifestRevisionLinkPath(ms.repository.Named().Name(), dgst)
+	if err != nil {
+		return err
+	}
+	_, metadata, err := ms.blobStore.blobStore.readlinkMetadata(ctx, path)
+	if err != nil || metadata == nil {
+		return err
+	}
+	metadata.Platforms = platforms
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return ms.blobStore.driver.PutContent(ctx, path, content)
+}
+
+// manifestPlatforms returns the platforms of the images of manifest.
+func (ms *manifestStore) manifestPlatforms(ctx context.Context, manifest distribution.Manifest) ([]v1.Platform, error) {
+	var config distribution.Descriptor
+	switch m := manifest.(type) {
+	case *schema2.DeserializedManifest:
+		config = m.Config
+		if config.MediaType != schema2.MediaTypeImageConfig {
+			return nil, nil
+		}
+	case *ocischema.DeserializedManifest:
+		config = m.Config
+		if config.MediaType != v1.MediaTypeImageConfig {
+			return nil, nil
+		}
+	case *manifestlist.DeserializedManifestList:
+		var platforms []v1.Platform
+		for _, desc := range m.Manifests {
+			// Indexes may list artifacts, which run on no platform.
+			if desc.Platform.OS == "" && desc.Platform.Architecture == "" {
+				continue
+			}
+			platforms = append(platforms, v1.Platform{
+				OS:           desc.Platform.OS,
+				Architecture: desc.Platform.Architecture,
+				Variant:      desc.Platform.Variant,
+			})
+		}
+		return platforms, nil
+	default:
+		return nil, nil
+	}
+
+	if config.Size > maxPlatformConfigSize {
+		return nil, nil
+	}
+	content, err := ms.repository.Blobs(ctx).Get(ctx, config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var image v1.Image
+	if err := json.Unmarshal(content, &image); err != nil {
+		return nil, err
+	}
+	if image.OS == "" && image.Architecture == "" {
+		return nil, nil
+	}
+	return []v1.Platform{{
+		OS:           image.OS,
+		Architecture: image.Architecture,
+		Variant:      image.Variant,
+	}}, nil
+}
+
+// MatchPlatform reports whether platform matches the platform filter, whose
+// variant matches any when empty.
+func MatchPlatform(filter, platform v1.Platform) bool {
+	return filter.OS == platform.OS &&
+		filter.Architecture == platform.Architecture &&
+		(filter.Variant == "" || filter.Variant == platform.Variant)
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// TestManifestPlatforms ensures that the platforms of manifests are read
+// from their config or index, and are recorded at push into the links of
+// their revision when link metadata is enabled.
+func TestManifestPlatforms(t *testing.T) {
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	for _, tc := range []struct {
+		name     string
+		options  []RegistryOption
+		recorded bool
+	}{
+		{"none", nil, false},
+		{"link metadata", []RegistryOption{EnableLinkMetadata}, true},
+	} {
+		repoName, _ := reference.WithName("foo/bar")
+		env := newManifestStoreTestEnv(t, repoName, "thetag", tc.options...)
+		ms, err := env.repository.Manifests(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		builder := ocischema.NewManifestBuilder(env.repository.Blobs(env.ctx), []byte(`{"os":"linux","architecture":"arm64","variant":"v8"}`), map[string]string{})
+		manifest, err := builder.Build(env.ctx)
+		if err != nil {
+			t.Fatalf("%s: unexpected error generating manifest: %v", tc.name, err)
+		}
+		dgst, err := ms.Put(env.ctx, manifest)
+		if err != nil {
+			t.Fatalf("%s: unexpected error putting manifest: %v", tc.name, err)
+		}
+
+		_, payload, _ := manifest.Payload()
+		index, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{{
+			Descriptor: distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: int64(len(payload))},
+			Platform:   manifestlist.PlatformSpec{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexDigest, err := ms.Put(env.ctx, index)
+		if err != nil {
+			t.Fatalf("%s: unexpected error putting index: %v", tc.name, err)
+		}
+
+		for _, d := range []digest.Digest{dgst, indexDigest} {
+			platforms, err := ms.(ManifestPlatforms).Platforms(env.ctx, d)
+			if err != nil {
+				t.Fatalf("%s: unexpected error listing the platforms of %s: %v", tc.name, d, err)
+			}
+			if !reflect.DeepEqual(platforms, []v1.Platform{arm64}) {
+				t.Errorf("%s: unexpected platforms of %s: %v", tc.name, d, platforms)
+			}
+		}
+
+		// Recorded platforms are listed without reading the config.
+		configPath, _ := pathFor(blobDataPathSpec{digest: manifest.(*ocischema.DeserializedManifest).Config.Digest})
+		if err := env.driver.Delete(env.ctx, configPath); err != nil {
+			t.Fatal(err)
+		}
+		platforms, err := ms.(ManifestPlatforms).Platforms(env.ctx, dgst)
+		if recorded := err == nil && reflect.DeepEqual(platforms, []v1.Platform{arm64}); recorded != tc.recorded {
+			t.Errorf("%s: unexpected platforms without the config: %v, %v", tc.name, platforms, err)
+		}
+	}
+}
+
+func TestMatchPlatform(t *testing.T) {
+	platform := v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	for _, tc := range []struct {
+		filter  v1.Platform
+		matches bool
+	}{
+		{v1.Platform{OS: "linux", Architecture: "arm"}, true},
+		{v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, true},
+		{v1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, false},
+		{v1.Platform{OS: "linux", Architecture: "amd64"}, false},
+		{v1.Platform{OS: "windows", Architecture: "arm"}, false},
+	} {
+		if matches := MatchPlatform(tc.filter, platform); matches != tc.matches {
+			t.Errorf("unexpected match of %v: %v", tc.filter, matches)
+		}
+	}
+}
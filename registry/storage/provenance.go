@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// Provenance records where a push of a manifest came from, as known to the
+// registry from the request pushing it.
+type Provenance struct {
+	// Subject is the name of the user the push was authenticated as, empty
+	// when authentication is disabled.
+	Subject string `json:"subject,omitempty"`
+
+	// RemoteIP is the address of the client, as reported by the proxies in
+	// front of the registry.
+	RemoteIP string `json:"remoteIP,omitempty"`
+
+	UserAgent string `json:"userAgent,omitempty"`
+	RequestID string `json:"requestID,omitempty"`
+
+	// Tag is the tag the manifest was pushed by, empty when pushed by
+	// digest.
+	Tag string `json:"tag,omitempty"`
+
+	PushedAt time.Time `json:"pushedAt"`
+}
+
+// recordProvenance records the provenance of a push of the manifest dgst from
+// the request of ctx, if enabled.
+func (ms *manifestStore) recordProvenance(ctx context.Context, dgst digest.Digest, options []distribution.ManifestServiceOption) error {
+	if !ms.repository.provenance {
+		return nil
+	}
+
+	root, err := pathFor(manifestProvenancePathSpec{name: ms.repository.Named().Name(), revision: dgst})
+	if err != nil {
+		return err
+	}
+
+	provenance := Provenance{
+		Subject:   dcontext.GetUserName(ctx),
+		RequestID: dcontext.GetRequestID(ctx),
+		PushedAt:  time.Now().UTC(),
+	}
+	if r, err := dcontext.GetRequest(ctx); err == nil {
+		provenance.RemoteIP = dcontext.RemoteIP(r)
+		provenance.UserAgent = r.UserAgent()
+	}
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithTagOption); ok {
+			provenance.Tag = opt.Tag
+		}
+	}
+
+	content, err := json.Marshal(provenance)
+	if err != nil {
+		return err
+	}
+	return ms.blobStore.driver.PutContent(ctx, path.Join(root, provenanceEntryName(provenance.PushedAt)), content)
+}
+
+// provenanceEntryName returns the name of the entry of a push at t, which
+// sorts the entries of a manifest by time. Pushes within the same nanosecond
+// share their entry.
+func provenanceEntryName(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// ManifestProvenance returns the provenance of the pushes of the manifest
+// dgst into the repository name, oldest first. Only the pushes made while the
+// provenance of manifests was recorded are returned, and none are for
+// manifests never pushed then.
+func ManifestProvenance(ctx context.Context, storageDriver driver.StorageDriver, name string, dgst digest.Digest) ([]Provenance, error) {
+	root, err := pathFor(manifestProvenancePathSpec{name: name, revision: dgst})
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := storageDriver.List(ctx, root)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	provenance := make([]Provenance, 0, len(paths))
+	for _, p := range paths {
+		content, err := storageDriver.GetContent(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		var entry Provenance
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse provenance entry %s of manifest %s: %v", path.Base(p), dgst, err)
+		}
+		provenance = append(provenance, entry)
+	}
+	return provenance, nil
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestManifestProvenance(t *testing.T) {
+	driver := inmemory.New()
+	registry := createRegistry(t, driver, RecordProvenance)
+	repo := makeRepository(t, registry, "foo/bar")
+	image := uploadRandomSchema2Image(t, repo)
+
+	req, err := http.NewRequest(http.MethodPut, "/v2/foo/bar/manifests/latest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "ci/1.0")
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	ctx := dcontext.WithRequest(context.WithValue(dcontext.Background(), dcontext.UserNameKey, "alice"), req)
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The manifest is pushed again by tag, as CI pipelines do.
+	if _, err := manifests.Put(ctx, image.manifest, distribution.WithTag("latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	provenance, err := ManifestProvenance(ctx, driver, "foo/bar", image.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first push, from uploadRandomSchema2Image, has no request.
+	if len(provenance) != 2 || provenance[0].Subject != "" {
+		t.Fatalf("unexpected provenance: %+v", provenance)
+	}
+	p := provenance[1]
+	if p.Subject != "alice" || p.RemoteIP != "192.0.2.1" || p.UserAgent != "ci/1.0" || p.RequestID == "" || p.Tag != "latest" || p.PushedAt.Before(provenance[0].PushedAt) {
+		t.Errorf("unexpected provenance of the push: %+v", p)
+	}
+
+	driver = inmemory.New()
+	image = uploadRandomSchema2Image(t, makeRepository(t, createRegistry(t, driver), "foo/bar"))
+	if provenance, err := ManifestProvenance(ctx, driver, "foo/bar", image.manifestDigest); err != nil || len(provenance) != 0 {
+		t.Errorf("unexpected provenance without recording: %+v, %v", provenance, err)
+	}
+}
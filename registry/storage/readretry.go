@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// linkReadRetries counts the reads of missing links retried, labeled by the
+// kind of link and whether a retry found it.
+var linkReadRetries = prometheus.StorageNamespace.NewLabeledCounter("link_read_retries", "The number of reads of missing links retried", "link", "outcome")
+
+// ReadRetry retries the reads of links found missing, for storage backends
+// that are eventually consistent, where a link just written may not be
+// readable at once. Reads of links that do not exist wait through all the
+// retries, so they are bounded.
+type ReadRetry struct {
+	// Retries is the number of reads retried after the first one.
+	Retries int
+
+	// Delay is the wait before the first retry, doubled before each
+	// following one.
+	Delay time.Duration
+}
+
+// readRetriesKey is the context key of the contexts without read retries.
+type readRetriesKey struct{}
+
+// WithoutReadRetries returns a context whose reads of missing links are not
+// retried, for the reads that expect links to be missing, such as those
+// before writing a tag.
+func WithoutReadRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readRetriesKey{}, true)
+}
+
+// do calls read, and calls it again while it returns a PathNotFoundError,
+// up to the retries of rr. link names the kind of link read in metrics.
+func (rr ReadRetry) do(ctx context.Context, link string, read func() error) error {
+	err := read()
+	if rr.Retries <= 0 || ctx.Value(readRetriesKey{}) != nil {
+		return err
+	}
+
+	delay := rr.Delay
+	for i := 0; i < rr.Retries; i++ {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+
+		if err = read(); err == nil {
+			linkReadRetries.WithValues(link, "found").Inc(1)
+			return nil
+		}
+	}
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		linkReadRetries.WithValues(link, "missing").Inc(1)
+	}
+	return err
+}
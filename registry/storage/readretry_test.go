@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// laggingDriver hides the paths written with a suffix until they have been
+// read a number of times, as eventually consistent backends do.
+type laggingDriver struct {
+	storagedriver.StorageDriver
+	suffix string
+	lag    int
+
+	mu     sync.Mutex
+	misses map[string]int
+}
+
+func (d *laggingDriver) hidden(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for written, misses := range d.misses {
+		if (path == written || strings.HasPrefix(written, path+"/")) && misses < d.lag {
+			d.misses[written]++
+			return true
+		}
+	}
+	return false
+}
+
+func (d *laggingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if strings.HasSuffix(path, d.suffix) {
+		d.mu.Lock()
+		d.misses[path] = 0
+		d.mu.Unlock()
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func (d *laggingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if d.hidden(path) {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *laggingDriver) List(ctx context.Context, path string) ([]string, error) {
+	if d.hidden(path) {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	return d.StorageDriver.List(ctx, path)
+}
+
+func TestTagReadRetry(t *testing.T) {
+	ctx := context.Background()
+	d := &laggingDriver{StorageDriver: inmemory.New(), suffix: "/current/link", lag: 2, misses: make(map[string]int)}
+
+	for _, tc := range []struct {
+		retry ReadRetry
+		found bool
+	}{
+		{ReadRetry{}, false},
+		{ReadRetry{Retries: 1, Delay: time.Millisecond}, false},
+		{ReadRetry{Retries: 3, Delay: time.Millisecond}, true},
+	} {
+		reg, err := NewRegistry(ctx, d, RetryLinkReads(tc.retry))
+		if err != nil {
+			t.Fatal(err)
+		}
+		named, _ := reference.WithName("a/b")
+		repo, err := reg.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags := repo.Tags(ctx)
+		dgst := digest.FromString(tc.retry.Delay.String())
+		if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+
+		desc, err := tags.Get(ctx, "latest")
+		if !tc.found {
+			if _, ok := err.(distribution.ErrTagUnknown); !ok {
+				t.Fatalf("expected an unknown tag with %d retries, got %v, %v", tc.retry.Retries, desc, err)
+			}
+			continue
+		}
+		if err != nil || desc.Digest != dgst {
+			t.Fatalf("unexpected tag with %d retries: %v, %v", tc.retry.Retries, desc, err)
+		}
+	}
+
+	reg, err := NewRegistry(ctx, d, RetryLinkReads(ReadRetry{Retries: 3, Delay: time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, _ := reference.WithName("a/c")
+	repo, err := reg.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: digest.FromString("c")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Tags(ctx).Get(WithoutReadRetries(ctx), "latest"); err == nil {
+		t.Fatal("expected reads without retries to miss the tag")
+	}
+}
+
+func TestReferrersReadRetry(t *testing.T) {
+	ctx := context.Background()
+	d := &laggingDriver{StorageDriver: inmemory.New(), suffix: "/link", lag: 2, misses: make(map[string]int)}
+
+	subject := digest.FromString("subject")
+	referrer := digest.FromString("referrer")
+	if err := d.PutContent(ctx, blobDataPath(referrer), []byte("referrer")); err != nil {
+		t.Fatal(err)
+	}
+	if err := IndexReferrer(ctx, d, "a/b", subject, v1.Descriptor{Digest: referrer, ArtifactType: "application/vnd.example.signature"}); err != nil {
+		t.Fatal(err)
+	}
+
+	referrers, _, err := ListReferrers(ctx, d, "a/b", subject, ReferrersOptions{Retry: ReadRetry{Retries: 3, Delay: time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != referrer {
+		t.Fatalf("unexpected referrers: %v", referrers)
+	}
+
+	start := time.Now()
+	referrers, _, err = ListReferrers(ctx, d, "a/b", digest.FromString("unknown"), ReferrersOptions{Retry: ReadRetry{Retries: 2, Delay: 10 * time.Millisecond}})
+	if err != nil || len(referrers) != 0 {
+		t.Fatalf("unexpected referrers of an unknown subject: %v, %v", referrers, err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("listing the referrers of an unknown subject took %s, expected the retries to wait", elapsed)
+	}
+}
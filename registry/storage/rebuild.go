@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// RebuildOpts contains options for rebuilding indexes
+type RebuildOpts struct {
+	// Repository is the name of the repository whose indexes are rebuilt.
+	// The indexes of all repositories are rebuilt when it is empty.
+	Repository string
+	DryRun     bool
+	// ReferrersOnly restricts the rebuild to the referrers index, leaving
+	// tags and layer references untouched.
+	ReferrersOnly bool
+}
+
+// RebuildIndexes reconstructs the indexes of repositories from their manifest
+// revisions: the current links and index entries of their tags, the referrers
+// index, and the layer references. Entries derived from the revisions are
+// restored, and entries of revisions which are not stored are removed, so that
+// rebuilding the same revisions always yields the same indexes. Referrers
+// are indexed with their descriptors, which are backfilled for referrers
+// indexed before descriptors were stored.
+//
+// The repositories listed in the catalog are those which store manifest
+// revisions, so the catalog needs no rebuilding. As with garbage collection,
+// the registry should not accept pushes while indexes are rebuilt.
+func RebuildIndexes(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts RebuildOpts) error {
+	rebuild := func(repoName string) error {
+		emit(repoName)
+
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+
+		rb := &indexRebuilder{
+			ctx:        ctx,
+			blobStore:  &blobStore{driver: storageDriver},
+			repository: repository,
+			name:       repoName,
+			dryRun:     opts.DryRun,
+			referrers:  opts.ReferrersOnly,
+		}
+		return rb.rebuild()
+	}
+
+	if opts.Repository != "" {
+		return rebuild(opts.Repository)
+	}
+
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+	err := repositoryEnumerator.Enumerate(ctx, rebuild)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// the registry stores no repositories
+		return nil
+	}
+	return err
+}
+
+type indexRebuilder struct {
+	ctx        context.Context
+	blobStore  *blobStore
+	repository distribution.Repository
+	name       string
+	dryRun     bool
+	// referrers restricts the rebuild to the referrers index.
+	referrers bool
+}
+
+func (rb *indexRebuilder) rebuild() error {
+	manifestService, err := rb.repository.Manifests(rb.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	revisions := make(map[digest.Digest]struct{})
+	layerReferences := make(map[string]digest.Digest)
+	referrers := make(map[string]digest.Digest)
+	descriptors := make(map[string]v1.Descriptor)
+
+	err = manifestEnumerator.Enumerate(rb.ctx, func(dgst digest.Digest) error {
+		manifest, err := manifestService.Get(rb.ctx, dgst)
+		if err != nil {
+			emit("%s: skipping unreadable manifest %s: %v", rb.name, dgst, err)
+			return nil
+		}
+		revisions[dgst] = struct{}{}
+
+		for _, desc := range manifest.References() {
+			p, err := pathFor(layerReferenceLinkPathSpec{name: rb.name, digest: desc.Digest, manifest: dgst})
+			if err != nil {
+				return err
+			}
+			layerReferences[p] = dgst
+		}
+
+		mediaType, payload, err := manifest.Payload()
+		if err != nil {
+			return err
+		}
+		var fields struct {
+			Subject      *distribution.Descriptor `json:"subject"`
+			ArtifactType string                   `json:"artifactType"`
+			Config       struct {
+				MediaType string `json:"mediaType"`
+			} `json:"config"`
+			Annotations map[string]string `json:"annotations"`
+		}
+		if err := json.Unmarshal(payload, &fields); err == nil && fields.Subject != nil {
+			subject := fields.Subject.Digest
+			if err := subject.Validate(); err != nil {
+				emit("%s: skipping invalid subject of manifest %s: %v", rb.name, dgst, err)
+				return nil
+			}
+			p := path.Join(referrersLinkPath(rb.name), subject.Algorithm().String(), subject.Hex(), dgst.Algorithm().String(), dgst.Hex(), "link")
+			referrers[p] = dgst
+
+			artifactType := fields.ArtifactType
+			if artifactType == "" {
+				artifactType = fields.Config.MediaType
+			}
+			descriptors[path.Join(path.Dir(p), referrerDescriptorFile)] = v1.Descriptor{
+				MediaType:    mediaType,
+				Digest:       dgst,
+				Size:         int64(len(payload)),
+				ArtifactType: artifactType,
+				Annotations:  fields.Annotations,
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// the repository stores no revisions
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to enumerate manifests: %v", err)
+	}
+
+	if err := rb.reconcileLinks("referrer", referrersLinkPath(rb.name), referrers); err != nil {
+		return fmt.Errorf("failed to rebuild referrers index: %v", err)
+	}
+	if err := rb.ensureDescriptors(descriptors); err != nil {
+		return fmt.Errorf("failed to rebuild referrers index: %v", err)
+	}
+	if rb.referrers {
+		return nil
+	}
+
+	if err := rb.rebuildTags(revisions); err != nil {
+		return fmt.Errorf("failed to rebuild tags: %v", err)
+	}
+	root, err := pathFor(layerReferencesRootPathSpec{name: rb.name})
+	if err != nil {
+		return err
+	}
+	if err := rb.reconcileLinks("layer reference", root, layerReferences); err != nil {
+		return fmt.Errorf("failed to rebuild layer references: %v", err)
+	}
+	return nil
+}
+
+// rebuildTags restores the current links of tags pointing to revisions which
+// are not stored to the revision most recently tagged among those which are,
+// and removes tags without any. Index entries of revisions which are not
+// stored are removed, and the current revision of each tag is indexed.
+func (rb *indexRebuilder) rebuildTags(revisions map[digest.Digest]struct{}) error {
+	tags, err := rb.repository.Tags(rb.ctx).All(rb.ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return nil
+		}
+		return err
+	}
+
+	for _, tag := range tags {
+		currentPath, err := pathFor(manifestTagCurrentPathSpec{name: rb.name, tag: tag})
+		if err != nil {
+			return err
+		}
+		indexPath, err := pathFor(manifestTagIndexPathSpec{name: rb.name, tag: tag})
+		if err != nil {
+			return err
+		}
+
+		// the index entries of stored revisions, most recently tagged first
+		type entry struct {
+			revision digest.Digest
+			tagged   int64
+		}
+		var entries []entry
+		err = rb.blobStore.driver.Walk(rb.ctx, indexPath, func(fi driver.FileInfo) error {
+			if fi.IsDir() || path.Base(fi.Path()) != "link" {
+				return nil
+			}
+			revision, err := rb.blobStore.readlink(rb.ctx, fi.Path())
+			if err != nil {
+				return err
+			}
+			if _, ok := revisions[revision]; !ok {
+				emit("%s: removing index entry of tag %s for missing manifest %s", rb.name, tag, revision)
+				if !rb.dryRun {
+					return rb.blobStore.driver.Delete(rb.ctx, path.Dir(fi.Path()))
+				}
+				return nil
+			}
+			entries = append(entries, entry{revision: revision, tagged: fi.ModTime().UnixNano()})
+			return nil
+		})
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].tagged != entries[j].tagged {
+				return entries[i].tagged > entries[j].tagged
+			}
+			return entries[i].revision > entries[j].revision
+		})
+
+		current, err := rb.blobStore.readlink(rb.ctx, currentPath)
+		if _, ok := revisions[current]; err != nil || !ok {
+			if len(entries) == 0 {
+				emit("%s: removing tag %s, which points to no stored manifest", rb.name, tag)
+				if !rb.dryRun {
+					tagPath, err := pathFor(manifestTagPathSpec{name: rb.name, tag: tag})
+					if err != nil {
+						return err
+					}
+					if err := rb.blobStore.driver.Delete(rb.ctx, tagPath); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			current = entries[0].revision
+			emit("%s: restoring tag %s to %s", rb.name, tag, current)
+			if !rb.dryRun {
+				if err := rb.blobStore.link(rb.ctx, currentPath, current); err != nil {
+					return err
+				}
+			}
+		}
+
+		entryPath, err := pathFor(manifestTagIndexEntryLinkPathSpec{name: rb.name, tag: tag, revision: current})
+		if err != nil {
+			return err
+		}
+		if err := rb.ensureLink("index entry of tag "+tag, entryPath, current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileLinks makes the links under root those of expected, keyed by
+// their paths.
+func (rb *indexRebuilder) reconcileLinks(kind, root string, expected map[string]digest.Digest) error {
+	var stale []string
+	err := rb.blobStore.driver.Walk(rb.ctx, root, func(fi driver.FileInfo) error {
+		if fi.IsDir() || path.Base(fi.Path()) != "link" {
+			return nil
+		}
+		if _, ok := expected[fi.Path()]; !ok {
+			stale = append(stale, fi.Path())
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, p := range stale {
+		emit("%s: removing stale %s %s", rb.name, kind, p)
+		if !rb.dryRun {
+			if err := rb.blobStore.driver.Delete(rb.ctx, path.Dir(p)); err != nil {
+				if _, ok := err.(driver.PathNotFoundError); !ok {
+					return err
+				}
+			}
+			if kind == "referrer" {
+				danglingReferrers.WithValues("rebuild-indexes").Inc(1)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(expected))
+	for p := range expected {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := rb.ensureLink(kind, p, expected[p]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureLink links p to dgst unless it already is. Links which are missing or
+// cannot be read are rewritten.
+func (rb *indexRebuilder) ensureLink(kind, p string, dgst digest.Digest) error {
+	if linked, err := rb.blobStore.readlink(rb.ctx, p); err == nil && linked == dgst {
+		return nil
+	}
+
+	emit("%s: adding %s %s", rb.name, kind, p)
+	if rb.dryRun {
+		return nil
+	}
+	return rb.blobStore.link(rb.ctx, p, dgst)
+}
+
+// ensureDescriptors writes the descriptors of referrers, keyed by their
+// paths, which are missing or differ from those stored.
+func (rb *indexRebuilder) ensureDescriptors(expected map[string]v1.Descriptor) error {
+	paths := make([]string, 0, len(expected))
+	for p := range expected {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		content, err := json.Marshal(expected[p])
+		if err != nil {
+			return err
+		}
+		if stored, err := rb.blobStore.driver.GetContent(rb.ctx, p); err == nil && bytes.Equal(stored, content) {
+			continue
+		}
+
+		emit("%s: writing referrer descriptor %s", rb.name, p)
+		if rb.dryRun {
+			continue
+		}
+		if err := rb.blobStore.driver.PutContent(rb.ctx, p, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
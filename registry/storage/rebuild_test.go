@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"path"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestRebuildIndexes(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, EnableBlobReferenceChecks)
+	repo := makeRepository(t, registry, "foo/bar")
+	manifests := makeManifestService(t, repo)
+	tags := repo.Tags(ctx)
+
+	image := uploadRandomSchema2Image(t, repo)
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrer, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config:  config,
+		Subject: &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: image.manifestDigest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrerDigest, err := manifests.Put(ctx, referrer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{name: "foo/bar", tag: "latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := getAnyKey(image.layers)
+	layerReferencePath, err := pathFor(layerReferenceLinkPathSpec{name: "foo/bar", digest: layer, manifest: image.manifestDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrerPath := path.Join(referrersLinkPath("foo/bar"), image.manifestDigest.Algorithm().String(), image.manifestDigest.Hex(), referrerDigest.Algorithm().String(), referrerDigest.Hex(), "link")
+	stalePath, err := pathFor(layerReferenceLinkPathSpec{name: "foo/bar", digest: layer, manifest: digest.FromString("removed")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lose the links and the descriptor of the referrer, and leave an entry of
+	// a removed manifest.
+	for _, p := range []string{currentPath, layerReferencePath, referrerPath, path.Join(path.Dir(referrerPath), referrerDescriptorFile)} {
+		if err := inmemoryDriver.Delete(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := inmemoryDriver.PutContent(ctx, stalePath, []byte(digest.FromString("removed"))); err != nil {
+		t.Fatal(err)
+	}
+
+	err = RebuildIndexes(ctx, inmemoryDriver, registry, RebuildOpts{
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to rebuild indexes: %v", err)
+	}
+	if _, err := inmemoryDriver.GetContent(ctx, currentPath); err == nil {
+		t.Fatalf("expected a dry run to leave the indexes unchanged")
+	}
+
+	err = RebuildIndexes(ctx, inmemoryDriver, registry, RebuildOpts{
+		Repository: "foo/bar",
+	})
+	if err != nil {
+		t.Fatalf("Failed to rebuild indexes: %v", err)
+	}
+
+	desc, err := tags.Get(ctx, "latest")
+	if err != nil || desc.Digest != image.manifestDigest {
+		t.Fatalf("expected tag to be restored to %s, got %v, %v", image.manifestDigest, desc, err)
+	}
+	if err := repo.Blobs(ctx).Delete(ctx, layer); err == nil {
+		t.Fatalf("expected layer references to be restored")
+	}
+	if content, err := inmemoryDriver.GetContent(ctx, referrerPath); err != nil || string(content) != referrerDigest.String() {
+		t.Fatalf("expected referrer to be restored, got %q, %v", content, err)
+	}
+	referrers, _, err := ListReferrers(ctx, inmemoryDriver, "foo/bar", image.manifestDigest, ReferrersOptions{})
+	if err != nil || len(referrers) != 1 || referrers[0].Digest != referrerDigest || referrers[0].ArtifactType != config.MediaType {
+		t.Fatalf("expected the descriptor of the referrer to be restored, got %v, %v", referrers, err)
+	}
+	if _, err := inmemoryDriver.GetContent(ctx, stalePath); err == nil {
+		t.Fatalf("expected stale layer reference to be removed")
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		t.Fatal(err)
+	}
+}
+
+func TestRebuildIndexesRemovesDanglingTags(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "foo/bar")
+	tags := repo.Tags(ctx)
+
+	image := uploadRandomSchema2Image(t, repo)
+	removed := uploadRandomSchema2Image(t, repo)
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: removed.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tags.Tag(ctx, "old", distribution.Descriptor{Digest: removed.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lose the revision while its tags remain.
+	revisionPath, err := pathFor(manifestRevisionPathSpec{name: "foo/bar", revision: removed.manifestDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.Delete(ctx, revisionPath); err != nil {
+		t.Fatal(err)
+	}
+
+	err = RebuildIndexes(ctx, inmemoryDriver, registry, RebuildOpts{})
+	if err != nil {
+		t.Fatalf("Failed to rebuild indexes: %v", err)
+	}
+
+	desc, err := tags.Get(ctx, "latest")
+	if err != nil || desc.Digest != image.manifestDigest {
+		t.Fatalf("expected tag to be restored to %s, got %v, %v", image.manifestDigest, desc, err)
+	}
+	if _, err := tags.Get(ctx, "old"); err == nil {
+		t.Fatalf("expected tag without stored manifests to be removed")
+	}
+	tagged, err := tags.Lookup(ctx, distribution.Descriptor{Digest: removed.manifestDigest})
+	if err != nil || len(tagged) != 0 {
+		t.Fatalf("expected no tags of a removed manifest, got %v, %v", tagged, err)
+	}
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// The layer references of a repository index the manifests stored in the
+// repository by the content they reference, so that deleting a layer still
+// needed by a manifest can be rejected. Entries are written as manifests are
+// put and removed as they are deleted. Entries left by manifests removed
+// otherwise, such as by garbage collection, are ignored and removed when
+// found; manifests put before the index was maintained are not indexed until
+// the indexes are rebuilt.
+
+// linkReferences indexes the content referenced by the manifest dgst of the
+// repository named name.
+func linkReferences(ctx context.Context, bs *blobStore, name string, dgst digest.Digest, manifest distribution.Manifest) error {
+	for _, desc := range manifest.References() {
+		p, err := pathFor(layerReferenceLinkPathSpec{name: name, digest: desc.Digest, manifest: dgst})
+		if err != nil {
+			return err
+		}
+		if err := bs.link(ctx, p, dgst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unlinkReferences removes the entries of the manifest dgst of the
+// repository named name from the index.
+func unlinkReferences(ctx context.Context, bs *blobStore, name string, dgst digest.Digest, manifest distribution.Manifest) error {
+	for _, desc := range manifest.References() {
+		p, err := pathFor(layerReferenceLinkPathSpec{name: name, digest: desc.Digest, manifest: dgst})
+		if err != nil {
+			return err
+		}
+		if err := bs.driver.Delete(ctx, path.Dir(p)); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// referencingManifest returns the digest of a manifest stored in the
+// repository named name which references dgst, or an empty digest.
+func referencingManifest(ctx context.Context, bs *blobStore, name string, dgst digest.Digest) (digest.Digest, error) {
+	root, err := pathFor(layerReferencesPathSpec{name: name, digest: dgst})
+	if err != nil {
+		return "", err
+	}
+
+	algorithms, err := bs.driver.List(ctx, root)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return "", nil
+		}
+		return "", err
+	}
+
+	for _, algorithm := range algorithms {
+		entries, err := bs.driver.List(ctx, algorithm)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return "", err
+		}
+
+		for _, entry := range entries {
+			manifest, err := bs.readlink(ctx, path.Join(entry, "link"))
+			if err != nil {
+				if _, ok := err.(driver.PathNotFoundError); ok {
+					continue
+				}
+				return "", err
+			}
+
+			revision, err := pathFor(manifestRevisionLinkPathSpec{name: name, revision: manifest})
+			if err != nil {
+				return "", err
+			}
+			if _, err := bs.readlink(ctx, revision); err == nil {
+				return manifest, nil
+			} else if _, ok := err.(driver.PathNotFoundError); !ok {
+				return "", err
+			}
+
+			// the manifest was removed without its entries
+			if err := bs.driver.Delete(ctx, entry); err != nil {
+				dcontext.GetLogger(ctx).Warnf("error removing stale reference of %s to %s: %v", manifest, dgst, err)
+			}
+		}
+	}
+	return "", nil
+}
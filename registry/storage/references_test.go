@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestReferencedBlobCannotBeDeleted(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, EnableBlobReferenceChecks)
+	repo := makeRepository(t, registry, "foo/bar")
+	manifests := makeManifestService(t, repo)
+	blobs := repo.Blobs(ctx)
+
+	image := uploadRandomSchema2Image(t, repo)
+	layer := getAnyKey(image.layers)
+
+	err := blobs.Delete(ctx, layer)
+	if referenced, ok := err.(distribution.ErrBlobReferenced); !ok || referenced.Manifest != image.manifestDigest {
+		t.Fatalf("expected deleting a referenced layer to fail, got %v", err)
+	}
+
+	// The layer is not referenced in other repositories.
+	other := makeRepository(t, registry, "foo/other")
+	if _, err := image.layers[layer].Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(other, map[digest.Digest]io.ReadSeeker{layer: image.layers[layer]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Blobs(ctx).Delete(ctx, layer); err != nil {
+		t.Fatalf("unexpected error deleting unreferenced layer: %v", err)
+	}
+
+	if err := manifests.Delete(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+	if err := blobs.Delete(ctx, layer); err != nil {
+		t.Fatalf("unexpected error deleting layer of a deleted manifest: %v", err)
+	}
+}
+
+func TestStaleReferencesAreIgnored(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, EnableBlobReferenceChecks)
+	repo := makeRepository(t, registry, "foo/bar")
+
+	image := uploadRandomSchema2Image(t, repo)
+	layer := getAnyKey(image.layers)
+
+	// Remove the manifest as garbage collection does, leaving its references.
+	if err := NewVacuum(ctx, inmemoryDriver).RemoveManifest("foo/bar", image.manifestDigest, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Blobs(ctx).Delete(ctx, layer); err != nil {
+		t.Fatalf("unexpected error deleting layer of a removed manifest: %v", err)
+	}
+
+	p, err := pathFor(layerReferencesPathSpec{name: "foo/bar", digest: layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths, err := inmemoryDriver.List(ctx, p); err == nil {
+		for _, p := range paths {
+			if children, _ := inmemoryDriver.List(ctx, p); len(children) != 0 {
+				t.Fatalf("expected stale references to be removed, found %v", children)
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// referrerDescriptorFile is the file stored next to the link of a referrer,
+// holding the descriptor the referrers API lists it with.
+const referrerDescriptorFile = "descriptor"
+
+// Referrers returns the digests of the manifests of the repository name whose
+// subject is the manifest subject, as indexed when they were pushed.
+func Referrers(ctx context.Context, storageDriver driver.StorageDriver, name string, subject digest.Digest) ([]digest.Digest, error) {
+	rootPath := path.Join(referrersLinkPath(name), subject.Algorithm().String(), subject.Hex())
+
+	var referrers []digest.Digest
+	err := storageDriver.Walk(ctx, rootPath, func(fi driver.FileInfo) error {
+		if fi.IsDir() || path.Base(fi.Path()) != "link" {
+			return nil
+		}
+		content, err := storageDriver.GetContent(ctx, fi.Path())
+		if err != nil {
+			return err
+		}
+		dgst, err := digest.Parse(string(content))
+		if err != nil {
+			// Malformed links are left to rebuild-indexes.
+			return nil
+		}
+		referrers = append(referrers, dgst)
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// the manifest has no referrers
+		return nil, nil
+	}
+	return referrers, err
+}
+
+// IndexReferrer indexes the manifest desc as a referrer of the manifest
+// subject in the repository name, along with its descriptor so that listing
+// referrers needs not fetch their manifests.
+func IndexReferrer(ctx context.Context, storageDriver driver.StorageDriver, name string, subject digest.Digest, desc v1.Descriptor) error {
+	referrerPath := path.Join(referrersLinkPath(name), subject.Algorithm().String(), subject.Hex(), desc.Digest.Algorithm().String(), desc.Digest.Hex())
+
+	content, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	// The descriptor is written before the link, so that indexed referrers
+	// have a descriptor unless they were indexed before descriptors were
+	// stored. The batch needs no journal, since a descriptor written without
+	// its link does not index the referrer.
+	batch := NewBatch(storageDriver)
+	batch.Put(path.Join(referrerPath, referrerDescriptorFile), content)
+	batch.Put(path.Join(referrerPath, "link"), []byte(desc.Digest.String()))
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	referrersIndexed.Inc(1)
+	return nil
+}
+
+// ReferrersOptions narrows the referrers listed by ListReferrers.
+type ReferrersOptions struct {
+	// ArtifactType, when set, only lists the referrers of that artifact type.
+	ArtifactType string
+
+	// Last, when set, only lists the referrers whose digest sorts after it.
+	Last digest.Digest
+
+	// Limit, when positive, bounds the number of referrers listed.
+	Limit int
+
+	// Describe returns the descriptor of the referrer dgst, for referrers
+	// indexed without one. Referrers it returns no descriptor for are not
+	// listed, and the descriptors it returns are stored with their links.
+	Describe func(ctx context.Context, dgst digest.Digest) (*v1.Descriptor, error)
+
+	// Retry retries listing the referrers of a subject none are indexed
+	// for, as those just indexed may not be listed yet.
+	Retry ReadRetry
+}
+
+// errReferrersLimit stops listing referrers once the limit is exceeded.
+var errReferrersLimit = errors.New("referrers limit reached")
+
+// ListReferrers returns the descriptors of the referrers of the manifest
+// subject in the repository name, in the order of their digests, and whether
+// more referrers follow those listed within the limit of the options.
+// Referrers whose manifest was deleted are not listed.
+func ListReferrers(ctx context.Context, storageDriver driver.StorageDriver, name string, subject digest.Digest, opts ReferrersOptions) ([]v1.Descriptor, bool, error) {
+	defer referrersListed.UpdateSince(time.Now())
+	rootPath := path.Join(referrersLinkPath(name), subject.Algorithm().String(), subject.Hex())
+
+	var referrers []v1.Descriptor
+	err := opts.Retry.do(ctx, "referrers", func() error {
+		referrers = []v1.Descriptor{}
+		return walkReferrers(ctx, storageDriver, rootPath, opts.Last, func(referrerPath string, dgst digest.Digest) error {
+			desc, err := referrerDescriptor(ctx, storageDriver, referrerPath, dgst, opts.Describe)
+			if err != nil {
+				return err
+			}
+			if desc == nil || (opts.ArtifactType != "" && desc.ArtifactType != opts.ArtifactType) {
+				return nil
+			}
+
+			if _, err := storageDriver.Stat(ctx, blobDataPath(dgst)); err != nil {
+				if _, ok := err.(driver.PathNotFoundError); ok {
+					// Referrers deleted since they were indexed are left
+					// to rebuild-indexes.
+					danglingReferrers.WithValues("skipped").Inc(1)
+					return nil
+				}
+				return err
+			}
+
+			if opts.Limit > 0 && len(referrers) == opts.Limit {
+				return errReferrersLimit
+			}
+			referrers = append(referrers, *desc)
+			return nil
+		})
+	})
+	switch err.(type) {
+	case nil:
+		return referrers, false, nil
+	case driver.PathNotFoundError:
+		// the manifest has no referrers
+		return referrers, false, nil
+	}
+	if err == errReferrersLimit {
+		return referrers, true, nil
+	}
+	return nil, false, err
+}
+
+// walkReferrers calls fn with the path and digest of the referrers indexed
+// under rootPath whose digest sorts after last, in the order of their
+// digests.
+func walkReferrers(ctx context.Context, storageDriver driver.StorageDriver, rootPath string, last digest.Digest, fn func(referrerPath string, dgst digest.Digest) error) error {
+	algorithms, err := storageDriver.List(ctx, rootPath)
+	if err != nil {
+		return err
+	}
+	sort.Strings(algorithms)
+
+	for _, algorithmPath := range algorithms {
+		algorithm := path.Base(algorithmPath)
+		if last != "" && algorithm < last.Algorithm().String() {
+			continue
+		}
+		hexes, err := storageDriver.List(ctx, algorithmPath)
+		if err != nil {
+			return err
+		}
+		sort.Strings(hexes)
+
+		for _, referrerPath := range hexes {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(algorithm), path.Base(referrerPath))
+			if dgst.Validate() != nil {
+				// Malformed paths are left to rebuild-indexes.
+				continue
+			}
+			if last != "" && dgst.String() <= last.String() {
+				continue
+			}
+			if err := fn(referrerPath, dgst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// referrerDescriptor returns the descriptor stored for the referrer dgst at
+// referrerPath, describing and storing it when missing.
+func referrerDescriptor(ctx context.Context, storageDriver driver.StorageDriver, referrerPath string, dgst digest.Digest, describe func(context.Context, digest.Digest) (*v1.Descriptor, error)) (*v1.Descriptor, error) {
+	descriptorPath := path.Join(referrerPath, referrerDescriptorFile)
+	content, err := storageDriver.GetContent(ctx, descriptorPath)
+	if err == nil {
+		var desc v1.Descriptor
+		if err := json.Unmarshal(content, &desc); err == nil && desc.Digest == dgst {
+			return &desc, nil
+		}
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		return nil, err
+	}
+
+	if describe == nil {
+		return nil, nil
+	}
+	desc, err := describe(ctx, dgst)
+	if err != nil || desc == nil {
+		return nil, err
+	}
+	if content, err := json.Marshal(desc); err == nil {
+		// Storing the descriptor only spares describing the referrer again.
+		_ = storageDriver.PutContent(ctx, descriptorPath, content)
+	}
+	return desc, nil
+}
+
+// blobDataPath returns the path of the data of the blob dgst.
+func blobDataPath(dgst digest.Digest) string {
+	p, _ := pathFor(blobDataPathSpec{digest: dgst})
+	return p
+}
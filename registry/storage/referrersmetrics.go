@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/docker/go-metrics"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	// referrersIndexed counts the referrers indexed as they are pushed.
+	referrersIndexed = prometheus.ReferrersNamespace.NewCounter("indexed", "The number of referrers indexed")
+
+	// referrersListed tracks the time listing the referrers of a subject
+	// takes.
+	referrersListed = prometheus.ReferrersNamespace.NewTimer("list", "The number of seconds listing the referrers of a subject takes")
+
+	// danglingReferrers counts the links of the referrers index to deleted
+	// manifests, labeled by whether they were skipped when listing
+	// referrers or removed by garbage collection or rebuilding indexes.
+	danglingReferrers = prometheus.ReferrersNamespace.NewLabeledCounter("dangling_links", "The number of referrer links to deleted manifests skipped or removed", "action")
+
+	// indexSubjects, indexLinks and indexSubjectsByReferrers describe the
+	// referrers index as of its last scan.
+	indexSubjects            = prometheus.ReferrersNamespace.NewGauge("index_subjects", "The number of subjects in the referrers index", "")
+	indexLinks               = prometheus.ReferrersNamespace.NewGauge("index_links", "The number of referrer links in the referrers index", "")
+	indexSubjectsByReferrers = prometheus.ReferrersNamespace.NewLabeledGauge("index_subjects_by_referrers", "The number of subjects in the referrers index, by their number of referrers", "", "referrers")
+)
+
+func init() {
+	metrics.Register(prometheus.ReferrersNamespace)
+}
+
+// referrersBuckets are the upper bounds of the ranges of the numbers of
+// referrers subjects are counted by.
+var referrersBuckets = []int{1, 5, 10, 50, 100}
+
+// referrersBucket returns the range of numbers of referrers n is counted in,
+// such as 6-10.
+func referrersBucket(n int) string {
+	lower := 1
+	for _, upper := range referrersBuckets {
+		if n <= upper {
+			if lower == upper {
+				return fmt.Sprint(upper)
+			}
+			return fmt.Sprintf("%d-%d", lower, upper)
+		}
+		lower = upper + 1
+	}
+	return fmt.Sprintf("%d+", lower)
+}
+
+// ReferrersStats describes the referrers index of a registry.
+type ReferrersStats struct {
+	// Subjects is the number of subjects with referrers indexed.
+	Subjects int
+
+	// Links is the number of referrer links, of all subjects.
+	Links int
+
+	// SubjectsByReferrers counts the subjects by their number of
+	// referrers, keyed by ranges such as 1, 2-5 and 101+.
+	SubjectsByReferrers map[string]int
+}
+
+// ScanReferrers walks the referrers index of all repositories of registry,
+// and records its size in metrics.
+func ScanReferrers(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace) (ReferrersStats, error) {
+	stats := ReferrersStats{SubjectsByReferrers: make(map[string]int)}
+
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return stats, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		root := referrersLinkPath(repoName)
+		algorithms, err := storageDriver.List(ctx, root)
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, algorithmPath := range algorithms {
+			subjects, err := storageDriver.List(ctx, algorithmPath)
+			if err != nil {
+				return err
+			}
+			for _, subjectPath := range subjects {
+				subject := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(algorithmPath)), path.Base(subjectPath))
+				if subject.Validate() != nil {
+					continue
+				}
+				n := 0
+				err := walkReferrers(ctx, storageDriver, subjectPath, "", func(string, digest.Digest) error {
+					n++
+					return nil
+				})
+				if _, ok := err.(driver.PathNotFoundError); ok {
+					// deleted since it was listed
+					continue
+				} else if err != nil {
+					return err
+				}
+				if n == 0 {
+					continue
+				}
+				stats.Subjects++
+				stats.Links += n
+				stats.SubjectsByReferrers[referrersBucket(n)]++
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// the registry stores no repositories
+		err = nil
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	indexSubjects.Set(float64(stats.Subjects))
+	indexLinks.Set(float64(stats.Links))
+	// Every range is set, so that those no subject is in anymore are reset.
+	uppers := append([]int{}, referrersBuckets...)
+	uppers = append(uppers, referrersBuckets[len(referrersBuckets)-1]+1)
+	for _, upper := range uppers {
+		bucket := referrersBucket(upper)
+		indexSubjectsByReferrers.WithValues(bucket).Set(float64(stats.SubjectsByReferrers[bucket]))
+	}
+	return stats, nil
+}
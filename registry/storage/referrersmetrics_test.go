@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReferrersBucket(t *testing.T) {
+	for n, expected := range map[int]string{1: "1", 2: "2-5", 5: "2-5", 6: "6-10", 50: "11-50", 100: "51-100", 101: "101+", 1000: "101+"} {
+		if bucket := referrersBucket(n); bucket != expected {
+			t.Errorf("referrersBucket(%d) = %s, expected %s", n, bucket, expected)
+		}
+	}
+}
+
+func TestScanReferrers(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := ScanReferrers(ctx, d, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Subjects != 0 || stats.Links != 0 {
+		t.Fatalf("unexpected stats of an empty registry: %+v", stats)
+	}
+
+	// The repositories are listed in the catalog by their manifests.
+	for _, name := range []string{"a/b", "c"} {
+		if err := d.PutContent(ctx, "/docker/registry/v2/repositories/"+name+"/_manifests/revisions/sha256/"+digest.FromString(name).Hex()+"/link", []byte(digest.FromString(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := func(name, subject string, referrers int) {
+		for i := 0; i < referrers; i++ {
+			desc := v1.Descriptor{Digest: digest.FromString(fmt.Sprintf("%s %s %d", name, subject, i))}
+			if err := IndexReferrer(ctx, d, name, digest.FromString(subject), desc); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	index("a/b", "one", 1)
+	index("a/b", "three", 3)
+	index("c", "seven", 7)
+
+	stats, err = ScanReferrers(ctx, d, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Subjects != 3 || stats.Links != 11 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats.SubjectsByReferrers["1"] != 1 || stats.SubjectsByReferrers["2-5"] != 1 || stats.SubjectsByReferrers["6-10"] != 1 {
+		t.Fatalf("unexpected subjects by referrers: %v", stats.SubjectsByReferrers)
+	}
+}
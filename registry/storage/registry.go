@@ -2,11 +2,13 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"regexp"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/distribution/distribution/v3/registry/storage/coalesce"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/docker/libtrust"
 )
@@ -21,11 +23,21 @@ type registry struct {
 	deleteEnabled                bool
 	schema1Enabled               bool
 	resumableDigestEnabled       bool
+	blobReferenceChecks          bool
+	journalBatches               bool
 	schema1SigningKey            libtrust.PrivateKey
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
 	manifestURLs                 manifestURLs
+	verifyConcurrency            int
+	manifestBytesInFlight        *byteLimiter
 	driver                       storagedriver.StorageDriver
 	extendedStorages             []ExtendedStorage
+	tagOperationsActor           string
+	tagHistory                   bool
+	linkReadRetry                ReadRetry
+	features                     func(feature, repository string) bool
+	provenance                   bool
+	bootstrap                    func(repository string) (RepositoryBootstrap, bool)
 }
 
 // manifestURLs holds regular expressions for controlling manifest URL whitelisting
@@ -53,6 +65,19 @@ func EnableRedirect(registry *registry) error {
 	return nil
 }
 
+// CoalesceBlobReads is a functional option for NewRegistry. It causes the
+// backend blob server to share a single storage read between concurrent
+// downloads of the same blob, for blobs of up to maxSize bytes. The blob is
+// buffered in memory while it is being downloaded by more than one client, up
+// to maxBuffered bytes for all blobs, beyond which blobs are read separately.
+func CoalesceBlobReads(maxSize, maxBuffered int64) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobServer.coalescer = &coalesce.Group{MaxBytes: maxBuffered}
+		registry.blobServer.coalesceMaxSize = maxSize
+		return nil
+	}
+}
+
 // EnableDelete is a functional option for NewRegistry. It enables deletion on
 // the registry.
 func EnableDelete(registry *registry) error {
@@ -74,6 +99,102 @@ func DisableDigestResumption(registry *registry) error {
 	return nil
 }
 
+// EnableBlobReferenceChecks is a functional option for NewRegistry. It rejects
+// deleting blobs still referenced by manifests of their repository. It should
+// not be used if the registry is acting as a caching proxy, where cached blobs
+// expire independently of the manifests referencing them.
+func EnableBlobReferenceChecks(registry *registry) error {
+	registry.blobReferenceChecks = true
+	return nil
+}
+
+// JournalBatches is a functional option for NewRegistry. It records the
+// batches of writes to tags in journals, unless the storage driver applies
+// them atomically, so that those interrupted can be completed by
+// RecoverBatches.
+func JournalBatches(registry *registry) error {
+	registry.journalBatches = true
+	return nil
+}
+
+// RecordTagHistory is a functional option for NewRegistry. It records each
+// write to a tag, so that the manifest a tag pointed to at a past time can be
+// resolved.
+func RecordTagHistory(registry *registry) error {
+	registry.tagHistory = true
+	return nil
+}
+
+// RecordProvenance is a functional option for NewRegistry. It records the
+// provenance of each push of a manifest, the user, client address, user agent
+// and request pushing it, so that it can be audited.
+func RecordProvenance(registry *registry) error {
+	registry.provenance = true
+	return nil
+}
+
+// EnableLinkMetadata is a functional option for NewRegistry. It writes the
+// size and media type of the blobs linked into repositories into their link
+// files, so that stating linked blobs only reads their link. Registries of
+// earlier versions cannot read such links.
+func EnableLinkMetadata(registry *registry) error {
+	registry.blobStore.linkMetadata = true
+	return nil
+}
+
+// The features of the storage which EnableFeatures enables for some
+// repositories.
+const (
+	// FeatureTagHistory records the history of the tags of the repository,
+	// as RecordTagHistory does.
+	FeatureTagHistory = "taghistory"
+
+	// FeatureLinkMetadata writes metadata into the links of the
+	// repository, as EnableLinkMetadata does.
+	FeatureLinkMetadata = "linkmetadata"
+
+	// FeatureProvenance records the provenance of the manifests pushed
+	// into the repository, as RecordProvenance does.
+	FeatureProvenance = "provenance"
+)
+
+// Features returns the names of the features EnableFeatures enables.
+func Features() []string {
+	return []string{FeatureTagHistory, FeatureLinkMetadata, FeatureProvenance}
+}
+
+// BootstrapRepositories is a functional option for NewRegistry. As the first
+// blob is uploaded or put into a repository, creating it, it records the bootstrap
+// returned for the repository, unless bootstrap returns false. Repositories
+// created before are not bootstrapped.
+func BootstrapRepositories(bootstrap func(repository string) (RepositoryBootstrap, bool)) RegistryOption {
+	return func(registry *registry) error {
+		registry.bootstrap = bootstrap
+		return nil
+	}
+}
+
+// EnableFeatures is a functional option for NewRegistry. It enables the
+// features of the storage for the repositories enabled reports them enabled
+// for, in addition to the features enabled for all repositories by other
+// options.
+func EnableFeatures(enabled func(feature, repository string) bool) RegistryOption {
+	return func(registry *registry) error {
+		registry.features = enabled
+		return nil
+	}
+}
+
+// RetryLinkReads is a functional option for NewRegistry. It retries the
+// reads of the tags resolved and of the referrers listed when their links are
+// missing, for storage backends that are eventually consistent.
+func RetryLinkReads(retry ReadRetry) RegistryOption {
+	return func(registry *registry) error {
+		registry.linkReadRetry = retry
+		return nil
+	}
+}
+
 // ManifestURLsAllowRegexp is a functional option for NewRegistry.
 func ManifestURLsAllowRegexp(r *regexp.Regexp) RegistryOption {
 	return func(registry *registry) error {
@@ -90,6 +211,32 @@ func ManifestURLsDenyRegexp(r *regexp.Regexp) RegistryOption {
 	}
 }
 
+// ManifestVerificationConcurrency is a functional option for NewRegistry. It
+// sets the number of descriptors of a pushed manifest whose presence is
+// checked at once.
+func ManifestVerificationConcurrency(n int) RegistryOption {
+	return func(registry *registry) error {
+		if n <= 0 {
+			return fmt.Errorf("manifest verification concurrency must be positive, got %d", n)
+		}
+		registry.verifyConcurrency = n
+		return nil
+	}
+}
+
+// ManifestBytesInFlight is a functional option for NewRegistry. It bounds the
+// bytes of the manifests read at once across the repositories of the
+// registry, making reads wait for earlier ones to complete beyond n bytes.
+func ManifestBytesInFlight(n int64) RegistryOption {
+	return func(registry *registry) error {
+		if n <= 0 {
+			return fmt.Errorf("manifest bytes in flight must be positive, got %d", n)
+		}
+		registry.manifestBytesInFlight = newByteLimiter(n)
+		return nil
+	}
+}
+
 // Schema1SigningKey returns a functional option for NewRegistry. It sets the
 // key for signing  all schema1 manifests.
 func Schema1SigningKey(key libtrust.PrivateKey) RegistryOption {
@@ -183,12 +330,29 @@ func (reg *registry) Repository(ctx context.Context, canonicalName reference.Nam
 		}
 	}
 
-	return &repository{
+	repo := &repository{
 		ctx:             ctx,
 		registry:        reg,
 		name:            canonicalName,
 		descriptorCache: descriptorCache,
-	}, nil
+		blobStore:       reg.blobStore,
+		tagHistory:      reg.tagHistory,
+		provenance:      reg.provenance,
+	}
+	if reg.features != nil {
+		if !repo.tagHistory {
+			repo.tagHistory = reg.features(FeatureTagHistory, canonicalName.Name())
+		}
+		if !repo.provenance {
+			repo.provenance = reg.features(FeatureProvenance, canonicalName.Name())
+		}
+		if !reg.blobStore.linkMetadata && reg.features(FeatureLinkMetadata, canonicalName.Name()) {
+			bs := *reg.blobStore
+			bs.linkMetadata = true
+			repo.blobStore = &bs
+		}
+	}
+	return repo, nil
 }
 
 func (reg *registry) Blobs() distribution.BlobEnumerator {
@@ -205,6 +369,12 @@ type repository struct {
 	ctx             context.Context
 	name            reference.Named
 	descriptorCache distribution.BlobDescriptorService
+
+	// blobStore, tagHistory and provenance override those of the registry
+	// for the features enabled for the repository only.
+	blobStore  *blobStore
+	tagHistory bool
+	provenance bool
 }
 
 // Name returns the name of the repository.
@@ -215,7 +385,7 @@ func (repo *repository) Named() reference.Named {
 func (repo *repository) Tags(ctx context.Context) distribution.TagService {
 	tags := &tagStore{
 		repository: repo,
-		blobStore:  repo.registry.blobStore,
+		blobStore:  repo.blobStore,
 	}
 
 	return tags
@@ -264,6 +434,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 			schema1SigningKey: repo.schema1SigningKey,
 			repository:        repo,
 			blobStore:         blobStore,
+			verifyConcurrency: repo.verifyConcurrency,
 		}
 	} else {
 		v1Handler = &v1UnsupportedHandler{
@@ -272,6 +443,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 				schema1SigningKey: repo.schema1SigningKey,
 				repository:        repo,
 				blobStore:         blobStore,
+				verifyConcurrency: repo.verifyConcurrency,
 			},
 		}
 	}
@@ -290,24 +462,28 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		blobStore:      blobStore,
 		schema1Handler: v1Handler,
 		schema2Handler: &schema2ManifestHandler{
-			ctx:          ctx,
-			repository:   repo,
-			blobStore:    blobStore,
-			manifestURLs: repo.registry.manifestURLs,
+			ctx:               ctx,
+			repository:        repo,
+			blobStore:         blobStore,
+			manifestURLs:      repo.registry.manifestURLs,
+			verifyConcurrency: repo.registry.verifyConcurrency,
 		},
 		manifestListHandler: &manifestListHandler{
-			ctx:        ctx,
-			repository: repo,
-			blobStore:  blobStore,
+			ctx:               ctx,
+			repository:        repo,
+			blobStore:         blobStore,
+			verifyConcurrency: repo.registry.verifyConcurrency,
 		},
 		ocischemaHandler: &ocischemaManifestHandler{
-			ctx:           ctx,
-			repository:    repo,
-			blobStore:     blobStore,
-			manifestURLs:  repo.registry.manifestURLs,
-			storageDriver: repo.registry.driver,
+			ctx:               ctx,
+			repository:        repo,
+			blobStore:         blobStore,
+			manifestURLs:      repo.registry.manifestURLs,
+			storageDriver:     repo.registry.driver,
+			verifyConcurrency: repo.registry.verifyConcurrency,
 		},
 		extensionManifestHandlers: extensionManifestHandlers,
+		bytesInFlight:             repo.registry.manifestBytesInFlight,
 	}
 
 	// Apply options
@@ -353,5 +529,6 @@ func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 		linkDirectoryPathSpec:  layersPathSpec{name: repo.name.Name()},
 		deleteEnabled:          repo.registry.deleteEnabled,
 		resumableDigestEnabled: repo.resumableDigestEnabled,
+		checkReferences:        repo.registry.blobReferenceChecks,
 	}
 }
@@ -20,10 +20,11 @@ var (
 
 //schema2ManifestHandler is a ManifestHandler that covers schema2 manifests.
 type schema2ManifestHandler struct {
-	repository   distribution.Repository
-	blobStore    distribution.BlobStore
-	ctx          context.Context
-	manifestURLs manifestURLs
+	repository        distribution.Repository
+	blobStore         distribution.BlobStore
+	ctx               context.Context
+	manifestURLs      manifestURLs
+	verifyConcurrency int
 }
 
 var _ ManifestHandler = &schema2ManifestHandler{}
@@ -65,6 +66,16 @@ func (ms *schema2ManifestHandler) Put(ctx context.Context, manifest distribution
 	return revision.Digest, nil
 }
 
+// verify verifies the manifest as Put does, without storing it.
+func (ms *schema2ManifestHandler) verify(ctx context.Context, manifest distribution.Manifest, skipDependencyVerification bool) error {
+	m, ok := manifest.(*schema2.DeserializedManifest)
+	if !ok {
+		return fmt.Errorf("non-schema2 manifest verified by schema2ManifestHandler: %T", manifest)
+	}
+
+	return ms.verifyManifest(ms.ctx, *m, skipDependencyVerification)
+}
+
 // verifyManifest ensures that the manifest content is valid from the
 // perspective of the registry. As a policy, the registry only tries to store
 // valid content, leaving trust policies of that content up to consumers.
@@ -86,11 +97,19 @@ func (ms *schema2ManifestHandler) verifyManifest(ctx context.Context, mnfst sche
 
 	blobsService := ms.repository.Blobs(ctx)
 
+	// Stat the blobs in a batch rather than one round trip at a time.
+	var dgsts []digest.Digest
 	for _, descriptor := range mnfst.References() {
+		if descriptor.MediaType != schema2.MediaTypeForeignLayer && descriptor.Digest.Validate() == nil {
+			dgsts = append(dgsts, descriptor.Digest)
+		}
+	}
+	stat := batchStat(ctx, blobsService, dgsts)
+
+	errs = verifyDescriptors(mnfst.References(), ms.verifyConcurrency, func(descriptor distribution.Descriptor) []error {
 		err := descriptor.Digest.Validate()
 		if err != nil {
-			errs = append(errs, err, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
-			continue
+			return []error{err, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest}}
 		}
 
 		switch descriptor.MediaType {
@@ -123,18 +142,20 @@ func (ms *schema2ManifestHandler) verifyManifest(ctx context.Context, mnfst sche
 			fallthrough // double check the blob store.
 		default:
 			// check its presence
-			_, err = blobsService.Stat(ctx, descriptor.Digest)
+			_, err = stat(ctx, descriptor.Digest)
 		}
 
 		if err != nil {
+			var errs []error
 			if err != distribution.ErrBlobUnknown {
 				errs = append(errs, err)
 			}
 
 			// On error here, we always append unknown blob errors.
-			errs = append(errs, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
+			return append(errs, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
 		}
-	}
+		return nil
+	})
 
 	if len(errs) != 0 {
 		return errs
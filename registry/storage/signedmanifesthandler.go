@@ -20,6 +20,7 @@ type signedManifestHandler struct {
 	schema1SigningKey libtrust.PrivateKey
 	blobStore         distribution.BlobStore
 	ctx               context.Context
+	verifyConcurrency int
 }
 
 var _ ManifestHandler = &signedManifestHandler{}
@@ -80,6 +81,16 @@ func (ms *signedManifestHandler) Put(ctx context.Context, manifest distribution.
 	return revision.Digest, nil
 }
 
+// verify verifies the manifest as Put does, without storing it.
+func (ms *signedManifestHandler) verify(ctx context.Context, manifest distribution.Manifest, skipDependencyVerification bool) error {
+	m, ok := manifest.(*schema1.SignedManifest)
+	if !ok {
+		return fmt.Errorf("non-schema1 manifest verified by signedManifestHandler: %T", manifest)
+	}
+
+	return ms.verifyManifest(ms.ctx, *m, skipDependencyVerification)
+}
+
 // verifyManifest ensures that the manifest content is valid from the
 // perspective of the registry. It ensures that the signature is valid for the
 // enclosed payload. As a policy, the registry only tries to store valid
@@ -122,8 +133,16 @@ func (ms *signedManifestHandler) verifyManifest(ctx context.Context, mnfst schem
 	}
 
 	if !skipDependencyVerification {
+		var dgsts []digest.Digest
 		for _, fsLayer := range mnfst.References() {
-			_, err := ms.repository.Blobs(ctx).Stat(ctx, fsLayer.Digest)
+			if fsLayer.Digest.Validate() == nil {
+				dgsts = append(dgsts, fsLayer.Digest)
+			}
+		}
+		stat := batchStat(ctx, ms.repository.Blobs(ctx), dgsts)
+		errs = append(errs, verifyDescriptors(mnfst.References(), ms.verifyConcurrency, func(fsLayer distribution.Descriptor) []error {
+			var errs []error
+			_, err := stat(ctx, fsLayer.Digest)
 			if err != nil {
 				if err != distribution.ErrBlobUnknown {
 					errs = append(errs, err)
@@ -132,7 +151,8 @@ func (ms *signedManifestHandler) verifyManifest(ctx context.Context, mnfst schem
 				// On error here, we always append unknown blob errors.
 				errs = append(errs, distribution.ErrManifestBlobUnknown{Digest: fsLayer.Digest})
 			}
-		}
+			return errs
+		})...)
 	}
 	if len(errs) != 0 {
 		return errs
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// TagHistory is implemented by the tag services of registries recording the
+// history of tags.
+type TagHistory interface {
+	// At returns the descriptor of the manifest tag pointed to at t. It
+	// returns ErrTagUnknown if the tag did not exist at t, or if its history
+	// was not recorded back to t.
+	At(ctx context.Context, tag string, t time.Time) (distribution.Descriptor, error)
+}
+
+var _ TagHistory = &tagStore{}
+
+// tagHistoryEntry records a write to a tag, tagging Digest or deleting the
+// tag if empty.
+type tagHistoryEntry struct {
+	Digest digest.Digest `json:"digest,omitempty"`
+}
+
+// tagHistoryEntryName returns the name of the entry of a write at t, which
+// sorts the entries of a tag by time.
+func tagHistoryEntryName(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// recordHistory adds the record of a write to tag into its history to batch,
+// if enabled.
+func (ts *tagStore) recordHistory(batch *Batch, tag string, dgst digest.Digest) error {
+	if !ts.repository.tagHistory {
+		return nil
+	}
+
+	root, err := pathFor(tagHistoryPathSpec{name: ts.repository.Named().Name(), tag: tag})
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(tagHistoryEntry{Digest: dgst})
+	if err != nil {
+		return err
+	}
+	batch.Put(path.Join(root, tagHistoryEntryName(time.Now())), content)
+	return nil
+}
+
+// At implements TagHistory.At.
+func (ts *tagStore) At(ctx context.Context, tag string, t time.Time) (distribution.Descriptor, error) {
+	root, err := pathFor(tagHistoryPathSpec{name: ts.repository.Named().Name(), tag: tag})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	paths, err := ts.blobStore.driver.List(ctx, root)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+		}
+		return distribution.Descriptor{}, err
+	}
+	sort.Strings(paths)
+
+	// The latest entry written at or before t.
+	name := tagHistoryEntryName(t)
+	i := sort.Search(len(paths), func(i int) bool {
+		return path.Base(paths[i]) > name
+	})
+	if i == 0 {
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+
+	content, err := ts.blobStore.driver.GetContent(ctx, paths[i-1])
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	var entry tagHistoryEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("failed to parse history entry of tag %s: %v", tag, err)
+	}
+	if entry.Digest == "" {
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+	return distribution.Descriptor{Digest: entry.Digest}, nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTagHistory(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New(), RecordTagHistory)
+	repo := makeRepository(t, registry, "foo/bar")
+	image1 := uploadRandomSchema2Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+	tags := repo.Tags(ctx)
+
+	// now returns the time between the writes around it.
+	now := func() time.Time {
+		time.Sleep(time.Millisecond)
+		defer time.Sleep(time.Millisecond)
+		return time.Now()
+	}
+
+	before := now()
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	tagged1 := now()
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image2.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	tagged2 := now()
+	if err := tags.Untag(ctx, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	untagged := now()
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	retagged := now()
+
+	history := tags.(TagHistory)
+	for _, c := range []struct {
+		at       time.Time
+		expected digest.Digest
+	}{
+		{before, ""},
+		{tagged1, image1.manifestDigest},
+		{tagged2, image2.manifestDigest},
+		{untagged, ""},
+		{retagged, image1.manifestDigest},
+	} {
+		desc, err := history.At(ctx, "latest", c.at)
+		if c.expected == "" {
+			if _, ok := err.(distribution.ErrTagUnknown); !ok {
+				t.Errorf("expected the tag to be unknown at %v, got %v, %v", c.at, desc, err)
+			}
+		} else if err != nil || desc.Digest != c.expected {
+			t.Errorf("expected the tag to point to %s at %v, got %v, %v", c.expected, c.at, desc, err)
+		}
+	}
+	if _, err := history.At(ctx, "other", retagged); err == nil {
+		t.Errorf("expected a tag without history to be unknown")
+	}
+}
+
+func TestTagHistoryNotRecorded(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "foo/bar")
+	image := uploadRandomSchema2Image(t, repo)
+	tags := repo.Tags(ctx)
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tags.(TagHistory).At(ctx, "latest", time.Now()); err == nil {
+		t.Errorf("expected no history to be recorded by default")
+	}
+}
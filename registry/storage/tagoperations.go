@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+var anchoredTagRegexp = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
+// TagOperation records a write to a tag by a registry accepting tag writes
+// concurrently with others. The tags of registries exchanging their
+// operations converge, as the state of each tag is that set by its latest
+// operation, ordered by timestamp, then by actor.
+type TagOperation struct {
+	// Tag is the tag written.
+	Tag string `json:"tag"`
+
+	// Digest is the digest of the manifest tagged, or empty if the tag was
+	// deleted.
+	Digest digest.Digest `json:"digest,omitempty"`
+
+	// Timestamp is the time the tag was written.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Actor identifies the registry which wrote the tag.
+	Actor string `json:"actor"`
+}
+
+// Validate checks that the operation names a valid tag and digest, and is
+// timestamped and attributed.
+func (op TagOperation) Validate() error {
+	if !anchoredTagRegexp.MatchString(op.Tag) {
+		return fmt.Errorf("invalid tag %q", op.Tag)
+	}
+	if op.Digest != "" {
+		if err := op.Digest.Validate(); err != nil {
+			return err
+		}
+	}
+	if op.Timestamp.IsZero() {
+		return fmt.Errorf("operation on tag %s has no timestamp", op.Tag)
+	}
+	if op.Actor == "" {
+		return fmt.Errorf("operation on tag %s has no actor", op.Tag)
+	}
+	return nil
+}
+
+// after reports whether op is ordered after other. Operations with the same
+// timestamp and actor are ordered by digest, so that all registries order
+// any two operations the same way.
+func (op TagOperation) after(other TagOperation) bool {
+	if !op.Timestamp.Equal(other.Timestamp) {
+		return op.Timestamp.After(other.Timestamp)
+	}
+	if op.Actor != other.Actor {
+		return op.Actor > other.Actor
+	}
+	return op.Digest > other.Digest
+}
+
+// TagOperationLog is implemented by the tag services of registries recording
+// tag operations.
+type TagOperationLog interface {
+	// Operations returns the latest operation on each tag of the
+	// repository, including deleted tags, ordered by tag.
+	Operations(ctx context.Context) ([]TagOperation, error)
+
+	// Merge applies the operations ordered after the latest operations on
+	// their tags. The manifests tagged must be stored in the repository.
+	Merge(ctx context.Context, ops []TagOperation) error
+}
+
+var _ TagOperationLog = &tagStore{}
+
+// TagOperations is a functional option for NewRegistry. It records writes to
+// tags as operations attributed to actor, which can be merged into other
+// registries for them to converge on the state of tags.
+func TagOperations(actor string) RegistryOption {
+	return func(registry *registry) error {
+		if actor == "" {
+			return fmt.Errorf("an actor must be given to record tag operations")
+		}
+		registry.tagOperationsActor = actor
+		return nil
+	}
+}
+
+// Operations implements TagOperationLog.Operations.
+func (ts *tagStore) Operations(ctx context.Context) ([]TagOperation, error) {
+	root, err := pathFor(tagOperationsPathSpec{name: ts.repository.Named().Name()})
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := ts.blobStore.driver.List(ctx, root)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ops := make([]TagOperation, 0, len(paths))
+	for _, p := range paths {
+		op, err := ts.operation(ctx, path.Base(p))
+		if err != nil {
+			return nil, err
+		}
+		if op != nil {
+			ops = append(ops, *op)
+		}
+	}
+	return ops, nil
+}
+
+// Merge implements TagOperationLog.Merge.
+func (ts *tagStore) Merge(ctx context.Context, ops []TagOperation) error {
+	for _, op := range ops {
+		if err := op.Validate(); err != nil {
+			return err
+		}
+		if op.Digest == "" {
+			continue
+		}
+		revision, err := pathFor(manifestRevisionLinkPathSpec{name: ts.repository.Named().Name(), revision: op.Digest})
+		if err != nil {
+			return err
+		}
+		if _, err := ts.blobStore.readlink(ctx, revision); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				return distribution.ErrManifestUnknownRevision{Name: ts.repository.Named().Name(), Revision: op.Digest}
+			}
+			return err
+		}
+	}
+
+	for _, op := range ops {
+		latest, err := ts.operation(ctx, op.Tag)
+		if err != nil {
+			return err
+		}
+		if latest != nil && !op.after(*latest) {
+			continue
+		}
+
+		if op.Digest != "" {
+			err = ts.tag(ctx, op.Tag, distribution.Descriptor{Digest: op.Digest})
+		} else {
+			err = ts.untag(ctx, op.Tag)
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := ts.putOperation(ctx, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record records a write to tag, tagging dgst or deleting the tag if empty,
+// as the latest operation on tag.
+func (ts *tagStore) record(ctx context.Context, tag string, dgst digest.Digest) error {
+	op := TagOperation{
+		Tag:       tag,
+		Digest:    dgst,
+		Timestamp: time.Now().UTC(),
+		Actor:     ts.repository.registry.tagOperationsActor,
+	}
+
+	// A write is ordered after the operation it overwrites, even if that
+	// was timestamped ahead of the clock of this registry.
+	latest, err := ts.operation(ctx, tag)
+	if err != nil {
+		return err
+	}
+	if latest != nil && !op.Timestamp.After(latest.Timestamp) {
+		op.Timestamp = latest.Timestamp.Add(time.Nanosecond)
+	}
+
+	return ts.putOperation(ctx, op)
+}
+
+// operation returns the latest operation on tag, or nil if none is recorded.
+func (ts *tagStore) operation(ctx context.Context, tag string) (*TagOperation, error) {
+	p, err := pathFor(tagOperationPathSpec{name: ts.repository.Named().Name(), tag: tag})
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ts.blobStore.driver.GetContent(ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var op TagOperation
+	if err := json.Unmarshal(content, &op); err != nil {
+		return nil, fmt.Errorf("failed to parse operation on tag %s: %v", tag, err)
+	}
+	return &op, nil
+}
+
+func (ts *tagStore) putOperation(ctx context.Context, op TagOperation) error {
+	p, err := pathFor(tagOperationPathSpec{name: ts.repository.Named().Name(), tag: op.Tag})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	return ts.blobStore.driver.PutContent(ctx, p, content)
+}
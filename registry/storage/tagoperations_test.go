@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTagOperationsConverge(t *testing.T) {
+	ctx := context.Background()
+	eastDriver := inmemory.New()
+	westDriver := inmemory.New()
+
+	east := createRegistry(t, eastDriver, TagOperations("east"))
+	eastRepo := makeRepository(t, east, "foo/bar")
+	image1 := uploadRandomSchema2Image(t, eastRepo)
+	image2 := uploadRandomSchema2Image(t, eastRepo)
+
+	// Replicate the content without tags to the other region.
+	backupDriver := inmemory.New()
+	snapshot, err := Backup(ctx, eastDriver, east, backupDriver, BackupOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	west := createRegistry(t, westDriver, TagOperations("west"))
+	if err := Restore(ctx, backupDriver, snapshot, west); err != nil {
+		t.Fatal(err)
+	}
+	westRepo := makeRepository(t, west, "foo/bar")
+
+	eastTags := eastRepo.Tags(ctx)
+	westTags := westRepo.Tags(ctx)
+	if err := eastTags.Tag(ctx, "latest", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := westTags.Tag(ctx, "latest", distribution.Descriptor{Digest: image2.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := eastTags.Tag(ctx, "dev", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := westTags.Tag(ctx, "dev", distribution.Descriptor{Digest: image2.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := westTags.Untag(ctx, "dev"); err != nil {
+		t.Fatal(err)
+	}
+
+	eastLog := eastTags.(TagOperationLog)
+	westLog := westTags.(TagOperationLog)
+	eastOps, err := eastLog.Operations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	westOps, err := westLog.Operations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eastLog.Merge(ctx, westOps); err != nil {
+		t.Fatalf("unexpected error merging operations: %v", err)
+	}
+	if err := westLog.Merge(ctx, eastOps); err != nil {
+		t.Fatalf("unexpected error merging operations: %v", err)
+	}
+
+	for region, tags := range map[string]distribution.TagService{"east": eastTags, "west": westTags} {
+		desc, err := tags.Get(ctx, "latest")
+		if err != nil || desc.Digest != image2.manifestDigest {
+			t.Fatalf("%s: expected latest to point to %s, got %v, %v", region, image2.manifestDigest, desc, err)
+		}
+		if _, err := tags.Get(ctx, "dev"); err == nil {
+			t.Fatalf("%s: expected dev to be deleted", region)
+		}
+	}
+
+	eastOps, err = eastLog.Operations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	westOps, err = westLog.Operations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(eastOps, westOps) {
+		t.Fatalf("expected operations to converge, got %v and %v", eastOps, westOps)
+	}
+
+	unknown := TagOperation{Tag: "latest", Digest: digest.FromString("unknown"), Timestamp: time.Now().Add(time.Hour), Actor: "east"}
+	if err := westLog.Merge(ctx, []TagOperation{unknown}); err == nil {
+		t.Fatalf("expected merging an operation tagging an unknown manifest to fail")
+	} else if _, ok := err.(distribution.ErrManifestUnknownRevision); !ok {
+		t.Fatalf("unexpected error merging an operation tagging an unknown manifest: %v", err)
+	}
+	for _, op := range []TagOperation{
+		{Tag: "latest", Timestamp: time.Now()},
+		{Tag: "latest", Actor: "east"},
+		{Tag: "-invalid", Timestamp: time.Now(), Actor: "east"},
+	} {
+		if err := westLog.Merge(ctx, []TagOperation{op}); err == nil {
+			t.Errorf("expected merging invalid operation %#v to fail", op)
+		}
+	}
+}
+
+func TestTagOperationsOrderLocalWrites(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, TagOperations("east"))
+	repo := makeRepository(t, registry, "foo/bar")
+	image1 := uploadRandomSchema2Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+	log := repo.Tags(ctx).(TagOperationLog)
+
+	// An operation from a registry whose clock is ahead.
+	ahead := TagOperation{Tag: "latest", Digest: image1.manifestDigest, Timestamp: time.Now().Add(time.Hour), Actor: "west"}
+	if err := log.Merge(ctx, []TagOperation{ahead}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: image2.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := log.Operations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].Digest != image2.manifestDigest || ops[0].Actor != "east" || !ops[0].after(ahead) {
+		t.Fatalf("expected the local write to be ordered after %v, got %v", ahead, ops)
+	}
+
+	// Merging the overwritten operation again has no effect.
+	if err := log.Merge(ctx, []TagOperation{ahead}); err != nil {
+		t.Fatal(err)
+	}
+	desc, err := repo.Tags(ctx).Get(ctx, "latest")
+	if err != nil || desc.Digest != image2.manifestDigest {
+		t.Fatalf("expected latest to point to %s, got %v, %v", image2.manifestDigest, desc, err)
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"path"
 	"sort"
 
@@ -35,12 +36,10 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 
 	entries, err := ts.blobStore.driver.List(ctx, pathSpec)
 	if err != nil {
-		switch err := err.(type) {
-		case storagedriver.PathNotFoundError:
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
 			return tags, distribution.ErrRepositoryUnknown{Name: ts.repository.Named().Name()}
-		default:
-			return tags, err
 		}
+		return tags, err
 	}
 
 	for _, entry := range entries {
@@ -58,6 +57,17 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 // Tag tags the digest with the given tag, updating the the store to point at
 // the current tag. The digest must point to a manifest.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	if err := ts.tag(ctx, tag, desc); err != nil {
+		return err
+	}
+
+	if ts.repository.registry.tagOperationsActor != "" {
+		return ts.record(ctx, tag, desc.Digest)
+	}
+	return nil
+}
+
+func (ts *tagStore) tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
 		name: ts.repository.Named().Name(),
 		tag:  tag,
@@ -67,15 +77,38 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 		return err
 	}
 
-	lbs := ts.linkedBlobStore(ctx, tag)
+	// A held tag may not be moved to another manifest.
+	if current, err := ts.Get(WithoutReadRetries(ctx), tag); err == nil && current.Digest != desc.Digest {
+		if err := NewHoldStore(ts.blobStore.driver).checkTag(ctx, ts.repository.Named().Name(), tag); err != nil {
+			return err
+		}
+	}
 
-	// Link into the index
-	if err := lbs.linkBlob(ctx, desc); err != nil {
+	indexPath, err := pathFor(manifestTagIndexEntryLinkPathSpec{
+		name:     ts.repository.Named().Name(),
+		tag:      tag,
+		revision: desc.Digest,
+	})
+	if err != nil {
+		return err
+	}
+	link, err := ts.blobStore.linkContent(desc)
+	if err != nil {
 		return err
 	}
 
-	// Overwrite the current link
-	return ts.blobStore.link(ctx, currentPath, desc.Digest)
+	// The link into the index, the current link and the entry of the
+	// history are written in a batch, in that order, so that a tag never
+	// points at a manifest missing from its index, nor from its history once
+	// the batch is applied or recovered.
+	batch := NewBatch(ts.blobStore.driver)
+	batch.journaled = ts.repository.registry.journalBatches
+	batch.Put(indexPath, link)
+	batch.Put(currentPath, []byte(desc.Digest))
+	if err := ts.recordHistory(batch, tag, desc.Digest); err != nil {
+		return err
+	}
+	return batch.Commit(ctx)
 }
 
 // resolve the current revision for name and tag.
@@ -89,10 +122,14 @@ func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descripto
 		return distribution.Descriptor{}, err
 	}
 
-	revision, err := ts.blobStore.readlink(ctx, currentPath)
+	var revision digest.Digest
+	err = ts.repository.registry.linkReadRetry.do(ctx, "tag", func() error {
+		var err error
+		revision, err = ts.blobStore.readlink(ctx, currentPath)
+		return err
+	})
 	if err != nil {
-		switch err.(type) {
-		case storagedriver.PathNotFoundError:
+		if errors.As(err, &storagedriver.PathNotFoundError{}) {
 			return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
 		}
 
@@ -104,6 +141,17 @@ func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descripto
 
 // Untag removes the tag association
 func (ts *tagStore) Untag(ctx context.Context, tag string) error {
+	if err := ts.untag(ctx, tag); err != nil {
+		return err
+	}
+
+	if ts.repository.registry.tagOperationsActor != "" && !distribution.IsDryRun(ctx) {
+		return ts.record(ctx, tag, "")
+	}
+	return nil
+}
+
+func (ts *tagStore) untag(ctx context.Context, tag string) error {
 	tagPath, err := pathFor(manifestTagPathSpec{
 		name: ts.repository.Named().Name(),
 		tag:  tag,
@@ -112,40 +160,35 @@ func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 		return err
 	}
 
-	return ts.blobStore.driver.Delete(ctx, tagPath)
-}
+	if err := NewHoldStore(ts.blobStore.driver).checkTag(ctx, ts.repository.Named().Name(), tag); err != nil {
+		return err
+	}
+
+	if distribution.IsDryRun(ctx) {
+		// the tag is only checked to exist
+		_, err := ts.blobStore.driver.Stat(ctx, tagPath)
+		return err
+	}
 
-// linkedBlobStore returns the linkedBlobStore for the named tag, allowing one
-// to index manifest blobs by tag name. While the tag store doesn't map
-// precisely to the linked blob store, using this ensures the links are
-// managed via the same code path.
-func (ts *tagStore) linkedBlobStore(ctx context.Context, tag string) *linkedBlobStore {
-	return &linkedBlobStore{
-		blobStore:  ts.blobStore,
-		repository: ts.repository,
-		ctx:        ctx,
-		linkPathFns: []linkPathFunc{func(name string, dgst digest.Digest) (string, error) {
-			return pathFor(manifestTagIndexEntryLinkPathSpec{
-				name:     name,
-				tag:      tag,
-				revision: dgst,
-			})
-
-		}},
+	if _, err := ts.blobStore.driver.Stat(ctx, tagPath); err != nil {
+		return err
+	}
+	batch := NewBatch(ts.blobStore.driver)
+	batch.journaled = ts.repository.registry.journalBatches
+	batch.Delete(tagPath)
+	if err := ts.recordHistory(batch, tag, ""); err != nil {
+		return err
 	}
+	return batch.Commit(ctx)
 }
 
 // Lookup recovers a list of tags which refer to this digest.  When a manifest is deleted by
 // digest, tag entries which point to it need to be recovered to avoid dangling tags.
 func (ts *tagStore) Lookup(ctx context.Context, desc distribution.Descriptor) ([]string, error) {
 	allTags, err := ts.All(ctx)
-	switch err.(type) {
-	case distribution.ErrRepositoryUnknown:
-		// This tag store has been initialized but not yet populated
-		break
-	case nil:
-		break
-	default:
+	if err != nil && !errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		// An unknown repository has a tag store initialized but not yet
+		// populated.
 		return nil, err
 	}
 
@@ -159,8 +202,7 @@ func (ts *tagStore) Lookup(ctx context.Context, desc distribution.Descriptor) ([
 		tagLinkPath, _ := pathFor(tagLinkPathSpec)
 		tagDigest, err := ts.blobStore.readlink(ctx, tagLinkPath)
 		if err != nil {
-			switch err.(type) {
-			case storagedriver.PathNotFoundError:
+			if errors.As(err, &storagedriver.PathNotFoundError{}) {
 				continue
 			}
 			return nil, err
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -9,6 +11,7 @@ import (
 	"github.com/distribution/distribution/v3/manifest"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/distribution/distribution/v3/reference"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	digest "github.com/opencontainers/go-digest"
 )
@@ -119,6 +122,27 @@ func TestTagStoreUnTag(t *testing.T) {
 	}
 }
 
+func TestTagStoreUnTagDryRun(t *testing.T) {
+	env := testTagStore(t)
+	tags := env.ts
+	ctx := distribution.WithDryRun(env.ctx)
+	desc := distribution.Descriptor{Digest: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}
+
+	if err := tags.Untag(ctx, "latest"); err == nil {
+		t.Error("expected error removing unknown tag")
+	}
+
+	if err := tags.Tag(env.ctx, "latest", desc); err != nil {
+		t.Fatal(err)
+	}
+	if err := tags.Untag(ctx, "latest"); err != nil {
+		t.Error(err)
+	}
+	if _, err := tags.Get(env.ctx, "latest"); err != nil {
+		t.Errorf("tag removed by a dry run: %v", err)
+	}
+}
+
 func TestTagStoreAll(t *testing.T) {
 	env := testTagStore(t)
 	tagStore := env.ts
@@ -314,3 +338,51 @@ func digestMap(dgsts []digest.Digest) map[digest.Digest]struct{} {
 	}
 	return set
 }
+
+// wrappingDriver wraps the errors of the driver it embeds, as storage driver
+// middlewares may.
+type wrappingDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d wrappingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	content, err := d.StorageDriver.GetContent(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return content, nil
+}
+
+func (d wrappingDriver) List(ctx context.Context, path string) ([]string, error) {
+	entries, err := d.StorageDriver.List(ctx, path)
+	if err != nil {
+		return nil, storagedriver.Error{DriverName: "wrapping", Enclosed: err}
+	}
+	return entries, nil
+}
+
+func TestTagStoreWrappedErrors(t *testing.T) {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, wrappingDriver{inmemory.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.Tags(ctx).Get(ctx, "missing")
+	if !errors.As(err, &distribution.ErrTagUnknown{}) {
+		t.Fatalf("expected an unknown tag error, got %v", err)
+	}
+	_, err = repo.Tags(ctx).All(ctx)
+	if !errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		t.Fatalf("expected an unknown repository error, got %v", err)
+	}
+	_, err = repo.Blobs(ctx).Get(ctx, digest.FromString("missing"))
+	if !errors.Is(err, distribution.ErrBlobUnknown) {
+		t.Fatalf("expected an unknown blob error, got %v", err)
+	}
+}
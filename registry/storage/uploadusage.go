@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// UploadUsage describes the incomplete uploads of a repository, whose data is
+// stored outside of its blobs until the uploads are committed or purged.
+type UploadUsage struct {
+	// Uploads is the number of uploads in progress or abandoned.
+	Uploads int64
+
+	// Size is the number of bytes of the files of the uploads, their data
+	// along with their hash states.
+	Size int64
+
+	// Oldest is the time the oldest upload started at, or the zero time when
+	// unknown.
+	Oldest time.Time
+}
+
+// RepositoryUploads is implemented by the repositories of registries which
+// account for the space taken by their incomplete uploads.
+type RepositoryUploads interface {
+	// UploadUsage returns the usage of the incomplete uploads of the
+	// repository.
+	UploadUsage(ctx context.Context) (UploadUsage, error)
+}
+
+var _ RepositoryUploads = &repository{}
+
+// UploadUsage walks the uploads directory of the repository, so its cost is
+// proportional to the number of files of the uploads in progress.
+func (repo *repository) UploadUsage(ctx context.Context) (UploadUsage, error) {
+	var usage UploadUsage
+	root, err := pathFor(uploadsPathSpec{name: repo.name.Name()})
+	if err != nil {
+		return usage, err
+	}
+
+	err = repo.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		rel := strings.TrimPrefix(fileInfo.Path(), root+"/")
+		if fileInfo.IsDir() {
+			if !strings.Contains(rel, "/") {
+				usage.Uploads++
+			}
+			return nil
+		}
+
+		usage.Size += fileInfo.Size()
+		if dir, file := path.Split(rel); file == "startedat" && !strings.Contains(strings.TrimSuffix(dir, "/"), "/") {
+			// Uploads being committed or purged as they are walked are
+			// only missing from Oldest.
+			if startedAt, err := readStartedAtFile(ctx, repo.driver, fileInfo.Path()); err == nil && (usage.Oldest.IsZero() || startedAt.Before(usage.Oldest)) {
+				usage.Oldest = startedAt
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// the repository has no uploads
+		err = nil
+	}
+	return usage, err
+}
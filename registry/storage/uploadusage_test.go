@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestUploadUsage(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "foo/bar")
+	uploads := repo.(RepositoryUploads)
+
+	usage, err := uploads.UploadUsage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != (UploadUsage{}) {
+		t.Fatalf("unexpected usage without uploads: %+v", usage)
+	}
+
+	blobs := repo.Blobs(ctx)
+	for _, content := range []string{"first", "second"} {
+		upload, err := blobs.Create(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := upload.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := upload.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// committed uploads are not accounted for
+	uploadRandomSchema2Image(t, repo)
+
+	usage, err = uploads.UploadUsage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Uploads != 2 || usage.Size < int64(len("first")+len("second")) || usage.Oldest.IsZero() {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+}
@@ -84,6 +84,98 @@ func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) e
 	return v.driver.Delete(v.ctx, manifestPath)
 }
 
+// RemoveLayerLinks removes the links of a repository to the blobs of the
+// digests. Links written with metadata are trusted without stating the blob
+// they link, so they must not outlive it.
+func (v Vacuum) RemoveLayerLinks(name string, dgsts map[digest.Digest]struct{}) error {
+	layersPath, err := pathFor(layersPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	algorithms, err := v.driver.List(v.ctx, layersPath)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, algorithmPath := range algorithms {
+		linkPaths, err := v.driver.List(v.ctx, algorithmPath)
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, linkPath := range linkPaths {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(algorithmPath)), path.Base(linkPath))
+			if _, ok := dgsts[dgst]; !ok {
+				continue
+			}
+			dcontext.GetLogger(v.ctx).Infof("deleting layer link: %s", linkPath)
+			if err := v.driver.Delete(v.ctx, linkPath); err != nil {
+				if _, ok := err.(driver.PathNotFoundError); !ok {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveReferrerLinks removes the links of the referrers index of a
+// repository to the manifest revisions which are not among kept, along with
+// the index of the subjects left without referrers.
+func (v Vacuum) RemoveReferrerLinks(name string, kept map[digest.Digest]struct{}) error {
+	root := referrersLinkPath(name)
+	subjectAlgorithms, err := v.driver.List(v.ctx, root)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, subjectAlgorithmPath := range subjectAlgorithms {
+		subjectPaths, err := v.driver.List(v.ctx, subjectAlgorithmPath)
+		if err != nil {
+			return err
+		}
+		for _, subjectPath := range subjectPaths {
+			var dangling, referrers []string
+			err := v.driver.Walk(v.ctx, subjectPath, func(fi driver.FileInfo) error {
+				if fi.IsDir() || path.Base(fi.Path()) != "link" {
+					return nil
+				}
+				referrerPath := path.Dir(fi.Path())
+				referrers = append(referrers, referrerPath)
+				dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(path.Dir(referrerPath))), path.Base(referrerPath))
+				if _, ok := kept[dgst]; !ok {
+					dangling = append(dangling, referrerPath)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if len(dangling) == len(referrers) {
+				dcontext.GetLogger(v.ctx).Infof("deleting referrers index of subject: %s", subjectPath)
+				if err := v.driver.Delete(v.ctx, subjectPath); err != nil {
+					return err
+				}
+				danglingReferrers.WithValues("garbage-collect").Inc(float64(len(dangling)))
+				continue
+			}
+			for _, referrerPath := range dangling {
+				dcontext.GetLogger(v.ctx).Infof("deleting referrer link: %s", referrerPath)
+				if err := v.driver.Delete(v.ctx, referrerPath); err != nil {
+					return err
+				}
+				danglingReferrers.WithValues("garbage-collect").Inc(1)
+			}
+		}
+	}
+	return nil
+}
+
 // RemoveRepository removes a repository directory from the
 // filesystem
 func (v Vacuum) RemoveRepository(repoName string) error {
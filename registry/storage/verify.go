@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+)
+
+// defaultVerifyConcurrency is the number of descriptors of a manifest
+// verified at once, unless configured otherwise.
+const defaultVerifyConcurrency = 16
+
+// verifyDescriptors calls verify for each of the descriptors, with up to
+// concurrency calls running at once. On backends with high latency, checking
+// the presence of the descriptors one after the other dominates the time
+// taken to push manifests referencing many of them.
+//
+// The errors returned by verify are aggregated in the order of the
+// descriptors, regardless of the order the calls complete in.
+func verifyDescriptors(descriptors []distribution.Descriptor, concurrency int, verify func(descriptor distribution.Descriptor) []error) distribution.ErrManifestVerification {
+	if concurrency <= 0 {
+		concurrency = defaultVerifyConcurrency
+	}
+
+	results := make([][]error, len(descriptors))
+	parallel(len(descriptors), concurrency, func(i int) {
+		results[i] = verify(descriptors[i])
+	})
+
+	var errs distribution.ErrManifestVerification
+	for _, result := range results {
+		errs = append(errs, result...)
+	}
+	return errs
+}
+
+// parallel calls f with each index from 0 to n, with up to concurrency calls
+// running at once, and returns once they all have returned.
+func parallel(n, concurrency int, f func(i int)) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
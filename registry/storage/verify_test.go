@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestVerifyDescriptors(t *testing.T) {
+	var descriptors []distribution.Descriptor
+	for i := 0; i < 20; i++ {
+		descriptors = append(descriptors, distribution.Descriptor{Digest: digest.FromBytes([]byte{byte(i)})})
+	}
+
+	var running, maxRunning int32
+	errs := verifyDescriptors(descriptors, 4, func(descriptor distribution.Descriptor) []error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+
+		// Complete the descriptors out of order.
+		i := int(descriptor.Digest.Encoded()[0])
+		time.Sleep(time.Duration(i%3) * time.Millisecond)
+		if i%2 == 0 {
+			return []error{distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest}}
+		}
+		return nil
+	})
+
+	if maxRunning > 4 {
+		t.Errorf("expected at most 4 concurrent verifications, got %d", maxRunning)
+	}
+
+	var expected []error
+	for _, descriptor := range descriptors {
+		if int(descriptor.Digest.Encoded()[0])%2 == 0 {
+			expected = append(expected, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
+		}
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	for i := range errs {
+		if errs[i] != expected[i] {
+			t.Errorf("expected error %d to be %v, got %v", i, expected[i], errs[i])
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package distribution
+
+import (
+	"context"
+)
+
+type manifestUnchangedKey struct{}
+
+// WithManifestUnchanged returns a context in which the manifest services
+// which find the manifests put already stored, and skip storing them again,
+// report it by setting unchanged.
+func WithManifestUnchanged(ctx context.Context, unchanged *bool) context.Context {
+	return context.WithValue(ctx, manifestUnchangedKey{}, unchanged)
+}
+
+// SetManifestUnchanged reports, to the caller of Put which created ctx with
+// WithManifestUnchanged, that the manifest put was already stored.
+func SetManifestUnchanged(ctx context.Context) {
+	if unchanged, ok := ctx.Value(manifestUnchangedKey{}).(*bool); ok {
+		*unchanged = true
+	}
+}